@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// defaultStripParams are the tracking query parameters removed from access URLs. Only
+// parameters on this explicit list are removed so functional ones (title IDs) are kept.
+const defaultStripParams = "utm_source,utm_medium,utm_campaign,utm_term,utm_content,cmpid"
+
+// normalizeAccessURL lowercases the scheme and host, drops any fragment and removes the
+// listed query parameters, keeping the rest in their original order. URLs that can't be
+// parsed are returned trimmed but otherwise unchanged.
+func normalizeAccessURL(rawURL string, stripParams map[string]bool) string {
+	rawURL = strings.TrimSpace(rawURL)
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return rawURL
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	parsed.Host = strings.ToLower(parsed.Host)
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+	if parsed.RawQuery != "" {
+		kept := make([]string, 0)
+		for _, param := range strings.Split(parsed.RawQuery, "&") {
+			if param == "" {
+				continue
+			}
+			name, _, _ := strings.Cut(param, "=")
+			if decoded, err := url.QueryUnescape(name); err == nil {
+				name = decoded
+			}
+			if stripParams[strings.ToLower(name)] == false {
+				kept = append(kept, param)
+			}
+		}
+		parsed.RawQuery = strings.Join(kept, "&")
+	}
+	return parsed.String()
+}
+
+// getAccessURLs returns the normalized 856$u access URLs of a bib with duplicates
+// (after normalization) removed, keeping the first
+func (svc *ServiceContext) getAccessURLs(bib *JMRLBib) []string {
+	out := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, rawURL := range getVarField(&bib.VarFields, "856", "u") {
+		normalized := normalizeAccessURL(rawURL, svc.StripParams)
+		if normalized == "" || seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		out = append(out, normalized)
+	}
+	return out
+}
+
+// accessURLProvider returns the provider for an access URL; see providersHandler
+func accessURLProvider(accessURL string) string {
+	if strings.Contains(accessURL, "overdrive") {
+		return "overdrive"
+	}
+	return "freading"
+}
+
+// getAccessURLFields returns an access_url field for each distinct access URL
+func (svc *ServiceContext) getAccessURLFields(bib *JMRLBib) []v4api.RecordField {
+	fields := make([]v4api.RecordField, 0)
+	for _, accessURL := range svc.getAccessURLs(bib) {
+		fields = append(fields, v4api.RecordField{Name: "access_url", Type: "url", Label: "Online Access",
+			Value: accessURL, Provider: accessURLProvider(accessURL)})
+	}
+	return fields
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeAccessURL(t *testing.T) {
+	strip := toCodeSet(strings.Split(defaultStripParams, ","))
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"unchanged", "https://jmrl.overdrive.com/media/1001", "https://jmrl.overdrive.com/media/1001"},
+		{"case", "HTTPS://JMRL.OverDrive.com/Media/1001", "https://jmrl.overdrive.com/Media/1001"},
+		{"fragment", "https://jmrl.overdrive.com/media/1001#reviews", "https://jmrl.overdrive.com/media/1001"},
+		{"tracking only", "https://jmrl.overdrive.com/media/1001?utm_source=virgo&utm_campaign=fall",
+			"https://jmrl.overdrive.com/media/1001"},
+		{"title id kept in order", "https://freading.com/ebooks/details?cmpid=x&titleId=42&utm_medium=email&format=epub",
+			"https://freading.com/ebooks/details?titleId=42&format=epub"},
+		{"parameter case", "https://jmrl.overdrive.com/media/1001?UTM_Source=virgo&id=7", "https://jmrl.overdrive.com/media/1001?id=7"},
+		{"escaped parameter name", "https://jmrl.overdrive.com/media/1001?utm%5Fterm=x&id=7", "https://jmrl.overdrive.com/media/1001?id=7"},
+		{"empty parameters", "https://jmrl.overdrive.com/media/1001?&id=7&", "https://jmrl.overdrive.com/media/1001?id=7"},
+		{"similar name kept", "https://jmrl.overdrive.com/media/1001?utm=1&source=2", "https://jmrl.overdrive.com/media/1001?utm=1&source=2"},
+		{"whitespace", "  https://jmrl.overdrive.com/media/1001 ", "https://jmrl.overdrive.com/media/1001"},
+		{"relative", "media/1001?utm_source=x", "media/1001?utm_source=x"},
+		{"unparseable", "https://jmrl overdrive.com/%zz", "https://jmrl overdrive.com/%zz"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := normalizeAccessURL(tc.url, strip)
+			if got != tc.want {
+				t.Errorf("normalizeAccessURL(%q) = %q, want %q", tc.url, got, tc.want)
+			}
+			if again := normalizeAccessURL(got, strip); again != got {
+				t.Errorf("normalizeAccessURL is not stable for %q: %q then %q", tc.url, got, again)
+			}
+		})
+	}
+}
+
+// only parameters on the configured list are stripped
+func TestNormalizeAccessURLStripList(t *testing.T) {
+	raw := "https://jmrl.overdrive.com/media/1001?utm_source=virgo&ref=news"
+	if got := normalizeAccessURL(raw, toCodeSet([]string{"ref"})); got != "https://jmrl.overdrive.com/media/1001?utm_source=virgo" {
+		t.Errorf("custom strip list = %q", got)
+	}
+	if got := normalizeAccessURL(raw, map[string]bool{}); got != raw {
+		t.Errorf("empty strip list = %q, want %q", got, raw)
+	}
+}
+
+func TestAccessURLFieldsCollapseDuplicates(t *testing.T) {
+	svc := newTestService(t, nil)
+	bib := testBib("1001", "Cats")
+	bib.VarFields = append(bib.VarFields,
+		marcField("856", "u", "https://jmrl.overdrive.com/media/1001?utm_campaign=spring"),
+		marcField("856", "u", "https://JMRL.overdrive.com/media/1001?utm_campaign=fall#top"),
+		marcField("856", "u", "https://freading.com/ebooks/details?titleId=42&cmpid=x"),
+		marcField("856", "u", "https://freading.com/ebooks/details?titleId=43"),
+		marcField("856", "u", " "))
+	fields := svc.getAccessURLFields(&bib)
+	want := []string{"https://jmrl.overdrive.com/media/1001", "https://freading.com/ebooks/details?titleId=42",
+		"https://freading.com/ebooks/details?titleId=43"}
+	if got := fieldValues(fields, "access_url"); reflect.DeepEqual(got, want) == false {
+		t.Errorf("access URLs = %q, want %q", got, want)
+	}
+	providers := []string{"overdrive", "freading", "freading"}
+	for i, f := range fields {
+		if i < len(providers) && f.Provider != providers[i] {
+			t.Errorf("%s provider = %s, want %s", f.Value, f.Provider, providers[i])
+		}
+	}
+}
+
+func FuzzNormalizeAccessURL(f *testing.F) {
+	strip := toCodeSet(strings.Split(defaultStripParams, ","))
+	for _, seed := range []string{"https://jmrl.overdrive.com/media/1001?utm_source=a&id=1#x", "HTTP://Example.ORG/a?b=c&&d", "mailto:x@y", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, raw string) {
+		got := normalizeAccessURL(raw, strip)
+		if again := normalizeAccessURL(got, strip); again != got {
+			t.Errorf("normalizeAccessURL is not stable for %q: %q then %q", raw, got, again)
+		}
+	})
+}
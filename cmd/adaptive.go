@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// adaptive paging tuning. The window holds the most recent Sierra search latencies and
+// no decision is made until it has adaptiveMinSamples. Page sizes are reduced when the
+// p95 exceeds the configured threshold and restored only once it drops below
+// adaptiveRecoverRatio of the threshold, so the mode does not flap around the threshold.
+const adaptiveWindowSize = 50
+const adaptiveMinSamples = 20
+const adaptiveRecoverRatio = 0.6
+
+// adaptiveReducedRows is the page size requested from Sierra while latency is high
+const adaptiveReducedRows = 10
+
+// adaptivePaging tracks a rolling window of Sierra search latency and reduces the page
+// size requested from Sierra while the p95 latency is high. It is nil unless enabled.
+type adaptivePaging struct {
+	lock      sync.Mutex
+	threshold time.Duration
+	samples   []time.Duration
+	next      int
+	reduced   bool
+	changedAt time.Time
+}
+
+// AdaptivePagingStatus is the adaptive paging state reported in admin status
+type AdaptivePagingStatus struct {
+	Reduced     bool      `json:"reduced"`
+	P95MS       int64     `json:"p95_ms"`
+	ThresholdMS int64     `json:"threshold_ms"`
+	Samples     int       `json:"samples"`
+	ReducedRows int       `json:"reduced_rows"`
+	ChangedAt   time.Time `json:"changed_at,omitempty"`
+}
+
+// newAdaptivePaging creates adaptive paging with the given p95 latency threshold
+func newAdaptivePaging(threshold time.Duration) *adaptivePaging {
+	return &adaptivePaging{threshold: threshold, samples: make([]time.Duration, 0, adaptiveWindowSize)}
+}
+
+// record adds a Sierra search latency to the window and re-evaluates the paging mode
+func (ap *adaptivePaging) record(latency time.Duration, now time.Time) {
+	ap.lock.Lock()
+	defer ap.lock.Unlock()
+	if len(ap.samples) < adaptiveWindowSize {
+		ap.samples = append(ap.samples, latency)
+	} else {
+		ap.samples[ap.next] = latency
+	}
+	ap.next = (ap.next + 1) % adaptiveWindowSize
+	if len(ap.samples) < adaptiveMinSamples {
+		return
+	}
+	p95 := ap.p95()
+	recoverAt := time.Duration(float64(ap.threshold) * adaptiveRecoverRatio)
+	if ap.reduced == false && p95 > ap.threshold {
+		log.Printf("WARNING: Sierra search p95 %s exceeds %s; reducing page size to %d", p95, ap.threshold, adaptiveReducedRows)
+		ap.reduced = true
+		ap.changedAt = now
+	} else if ap.reduced && p95 < recoverAt {
+		log.Printf("Sierra search p95 %s is below %s; restoring requested page sizes", p95, recoverAt)
+		ap.reduced = false
+		ap.changedAt = now
+	}
+}
+
+// p95 returns the 95th percentile of the window. Callers must hold the lock.
+func (ap *adaptivePaging) p95() time.Duration {
+	if len(ap.samples) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(ap.samples))
+	copy(sorted, ap.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := (len(sorted)*95 + 99) / 100
+	return sorted[idx-1]
+}
+
+// pageSize returns the page size to request from Sierra for the requested rows and true
+// if it was reduced
+func (ap *adaptivePaging) pageSize(rows int) (int, bool) {
+	if ap == nil {
+		return rows, false
+	}
+	ap.lock.Lock()
+	defer ap.lock.Unlock()
+	if ap.reduced && rows > adaptiveReducedRows {
+		return adaptiveReducedRows, true
+	}
+	return rows, false
+}
+
+// status returns the current adaptive paging state
+func (ap *adaptivePaging) status() AdaptivePagingStatus {
+	ap.lock.Lock()
+	defer ap.lock.Unlock()
+	return AdaptivePagingStatus{Reduced: ap.reduced, P95MS: int64(ap.p95() / time.Millisecond),
+		ThresholdMS: int64(ap.threshold / time.Millisecond), Samples: len(ap.samples),
+		ReducedRows: adaptiveReducedRows, ChangedAt: ap.changedAt}
+}
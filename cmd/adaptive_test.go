@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+// feedLatencies records n samples of the latency, a second apart from *now
+func feedLatencies(ap *adaptivePaging, n int, latency time.Duration, now *time.Time) {
+	for i := 0; i < n; i++ {
+		*now = now.Add(time.Second)
+		ap.record(latency, *now)
+	}
+}
+
+func TestAdaptivePagingP95(t *testing.T) {
+	tests := []struct {
+		name string
+		slow int
+		want time.Duration
+	}{
+		{"none slow", 0, 100 * time.Millisecond},
+		{"two slow", 2, 100 * time.Millisecond},
+		{"three slow", 3, 3 * time.Second},
+		{"all slow", adaptiveWindowSize, 3 * time.Second},
+	}
+	for _, tc := range tests {
+		ap := newAdaptivePaging(time.Second)
+		now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		feedLatencies(ap, adaptiveWindowSize-tc.slow, 100*time.Millisecond, &now)
+		feedLatencies(ap, tc.slow, 3*time.Second, &now)
+		if got := ap.status().P95MS; got != int64(tc.want/time.Millisecond) {
+			t.Errorf("%s: p95 = %dms, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+// the rolling window reduces page sizes above the threshold and only restores them well
+// below it
+func TestAdaptivePagingHysteresis(t *testing.T) {
+	ap := newAdaptivePaging(time.Second)
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	steps := []struct {
+		name    string
+		samples int
+		latency time.Duration
+		reduced bool
+	}{
+		{"too few samples", adaptiveMinSamples - 1, 2 * time.Second, false},
+		{"enough samples", 1, 2 * time.Second, true},
+		{"between recovery and threshold", adaptiveWindowSize, 800 * time.Millisecond, true},
+		{"below recovery", adaptiveWindowSize, 500 * time.Millisecond, false},
+		{"just under threshold", adaptiveWindowSize, 999 * time.Millisecond, false},
+		{"brief spike", 2, 5 * time.Second, false},
+		{"sustained spike", 1, 5 * time.Second, true},
+	}
+	var changedAt time.Time
+	for _, step := range steps {
+		before := ap.status().Reduced
+		batchStart := now
+		logged := captureLog(func() { feedLatencies(ap, step.samples, step.latency, &now) })
+		status := ap.status()
+		if status.Reduced != step.reduced {
+			t.Fatalf("%s: reduced = %t, want %t (p95 %dms)", step.name, status.Reduced, step.reduced, status.P95MS)
+		}
+		if before != status.Reduced {
+			// each decision is logged and timestamped with the sample that caused it
+			if status.ChangedAt.After(batchStart) == false || status.ChangedAt.After(now) {
+				t.Errorf("%s: changed at %s, want within %s - %s", step.name, status.ChangedAt, batchStart, now)
+			}
+			changedAt = status.ChangedAt
+			if logged == "" {
+				t.Errorf("%s: the change was not logged", step.name)
+			}
+		} else {
+			if logged != "" {
+				t.Errorf("%s: unexpected log %q", step.name, logged)
+			}
+			if status.ChangedAt.Equal(changedAt) == false {
+				t.Errorf("%s: changed at %s without a change, want %s", step.name, status.ChangedAt, changedAt)
+			}
+		}
+		rows, reduced := ap.pageSize(20)
+		if reduced != step.reduced || (reduced && rows != adaptiveReducedRows) || (reduced == false && rows != 20) {
+			t.Errorf("%s: pageSize(20) = %d %t", step.name, rows, reduced)
+		}
+	}
+	if rows, reduced := ap.pageSize(adaptiveReducedRows - 5); reduced || rows != adaptiveReducedRows-5 {
+		t.Errorf("small pages are never reduced: got %d %t", rows, reduced)
+	}
+	var disabled *adaptivePaging
+	if rows, reduced := disabled.pageSize(50); reduced || rows != 50 {
+		t.Errorf("disabled pageSize(50) = %d %t", rows, reduced)
+	}
+}
+
+// while reduced, Sierra is asked for smaller pages and the result says so
+func TestPagedSearchAdaptiveReduction(t *testing.T) {
+	svc, fake := newPagingService(t, 100)
+	svc.Adaptive = newAdaptivePaging(time.Second)
+	now := time.Now()
+	captureLog(func() { feedLatencies(svc.Adaptive, adaptiveMinSamples, 2*time.Second, &now) })
+	res, warnings, err := svc.pagedSearch(context.Background(), url.Values{"text": {"(cats)"}}, 0, 20, newRequestTrace(false))
+	if err != nil {
+		t.Fatalf("pagedSearch failed: %s", err.Message)
+	}
+	pages, _ := fake.requests()
+	if len(pages) != 1 || pages[0][1] != adaptiveReducedRows || len(res.Entries) != adaptiveReducedRows {
+		t.Errorf("Sierra pages %v returned %d entries, want one of %d", pages, len(res.Entries), adaptiveReducedRows)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("warnings = %q, want the reduction", warnings)
+	}
+	if svc.Metrics.Snapshot()["adaptive_page_reduced"] != 1 {
+		t.Errorf("reduction was not counted")
+	}
+}
+
+// both admin views report the adaptive paging state
+func TestAdminReportsAdaptivePaging(t *testing.T) {
+	cfg := newTestConfig("http://sierra.invalid" + fakeSierraBase)
+	cfg.AdaptiveMS = 1000
+	svc := newTestServiceWithConfig(t, cfg)
+	now := time.Now()
+	captureLog(func() { feedLatencies(svc.Adaptive, adaptiveMinSamples, 2*time.Second, &now) })
+	router := newRouter(svc)
+	for _, target := range []string{"/api/admin/status", "/api/admin/config"} {
+		rec := apiRequestAs(t, router, v4jwt.Staff, http.MethodGet, target, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s status = %d", target, rec.Code)
+		}
+		var resp struct {
+			Adaptive *AdaptivePagingStatus `json:"adaptive_paging"`
+			Metrics  map[string]int64      `json:"metrics"`
+		}
+		decodeTestJSON(t, rec, &resp)
+		if resp.Adaptive == nil || resp.Adaptive.Reduced == false || resp.Adaptive.ThresholdMS != 1000 || resp.Adaptive.Samples != adaptiveMinSamples {
+			t.Errorf("%s adaptive_paging = %+v", target, resp.Adaptive)
+		}
+		if resp.Metrics == nil {
+			t.Errorf("%s has no metrics", target)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+// safeConfigFields is the allowlist of ServiceConfig fields that may be reported verbatim.
+// Any field not listed here is masked, so new secrets are never exposed by default.
+var safeConfigFields = map[string]bool{
+	"API":             true,
+	"Port":            true,
+	"BranchGeo":       true,
+	"Placeholders":    true,
+	"SummaryLength":   true,
+	"OverrideDir":     true,
+	"LocationCfg":     true,
+	"ZeroResultsMax":  true,
+	"SoonDays":        true,
+	"MaxSubjects":     true,
+	"MonitorCIDRs":    true,
+	"MaxConcurrent":   true,
+	"I18NDir":         true,
+	"SlowMS":          true,
+	"FeedOrigins":     true,
+	"CoverURL":        true,
+	"BrowseWildcard":  true,
+	"StatsHours":      true,
+	"FieldCfg":        true,
+	"StripParams":     true,
+	"SuppressFields":  true,
+	"SuppressRaw":     true,
+	"AdaptiveMS":      true,
+	"FaultInjection":  true,
+	"OnlineTypes":     true,
+	"PairLargePrint":  true,
+	"FoldDiacritics":  true,
+	"TrendingHours":   true,
+	"MaxQueryLength":  true,
+	"BriefCodes":      true,
+	"RecordCacheSecs": true,
+}
+
+// LoadedFile tracks an external file loaded by the service
+type LoadedFile struct {
+	Kind     string    `json:"kind"`
+	Path     string    `json:"path"`
+	Checksum string    `json:"sha256"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+// loadedFiles is the registry of external files the running service has loaded
+type loadedFiles struct {
+	lock  sync.Mutex
+	files map[string]LoadedFile
+}
+
+// recordLoadedFile adds (or replaces) the registry entry for an external file
+func (svc *ServiceContext) recordLoadedFile(kind string, path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("WARNING: unable to checksum %s file %s: %s", kind, path, err.Error())
+		return
+	}
+	svc.LoadedFiles.lock.Lock()
+	defer svc.LoadedFiles.lock.Unlock()
+	if svc.LoadedFiles.files == nil {
+		svc.LoadedFiles.files = make(map[string]LoadedFile)
+	}
+	svc.LoadedFiles.files[path] = LoadedFile{Kind: kind, Path: path,
+		Checksum: fmt.Sprintf("%x", sha256.Sum256(data)), LoadedAt: time.Now()}
+}
+
+// loadedFileList returns a copy of all loaded file entries
+func (svc *ServiceContext) loadedFileList() []LoadedFile {
+	svc.LoadedFiles.lock.Lock()
+	defer svc.LoadedFiles.lock.Unlock()
+	out := make([]LoadedFile, 0, len(svc.LoadedFiles.files))
+	for _, f := range svc.LoadedFiles.files {
+		out = append(out, f)
+	}
+	return out
+}
+
+// redactedConfig returns every ServiceConfig value, masking anything not allowlisted
+func redactedConfig(cfg *ServiceConfig) map[string]interface{} {
+	out := make(map[string]interface{})
+	val := reflect.ValueOf(*cfg)
+	for i := 0; i < val.NumField(); i++ {
+		name := val.Type().Field(i).Name
+		fieldVal := val.Field(i)
+		if safeConfigFields[name] {
+			out[name] = fieldVal.Interface()
+		} else if fieldVal.IsZero() {
+			out[name] = ""
+		} else {
+			out[name] = "*****"
+		}
+	}
+	return out
+}
+
+// staffMiddleware must follow authMiddleware and limits access to staff or admin users
+func (svc *ServiceContext) staffMiddleware(c *gin.Context) {
+	if isStaff(c) == false {
+		log.Printf("Staff access required for %s", c.Request.URL.Path)
+		sendError(c, http.StatusForbidden, errForbidden, "staff access is required")
+	}
+}
+
+// isStaff returns true if the request claims have a staff or admin role
+func isStaff(c *gin.Context) bool {
+	claims, exist := c.Get("claims")
+	if exist == false {
+		return false
+	}
+	v4Claims, ok := claims.(*v4jwt.V4Claims)
+	if ok == false {
+		return false
+	}
+	return v4Claims.Role == v4jwt.Staff || v4Claims.Role == v4jwt.Admin
+}
+
+// adminConfig returns a redacted snapshot of the effective service configuration
+func (svc *ServiceContext) adminConfig(c *gin.Context) {
+	log.Printf("Admin config snapshot requested")
+	type buildDetails struct {
+		Version   string            `json:"version"`
+		GoVersion string            `json:"go_version,omitempty"`
+		Settings  map[string]string `json:"settings,omitempty"`
+	}
+	build := buildDetails{Version: svc.Version, Settings: make(map[string]string)}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		build.GoVersion = info.GoVersion
+		for _, setting := range info.Settings {
+			build.Settings[setting.Key] = setting.Value
+		}
+	}
+
+	resp := svc.runtimeStatus()
+	resp["config"] = redactedConfig(svc.Config)
+	resp["files"] = svc.loadedFileList()
+	resp["build"] = build
+	c.JSON(http.StatusOK, resp)
+}
+
+// runtimeStatus returns the runtime state reported by both admin config and admin status
+func (svc *ServiceContext) runtimeStatus() map[string]interface{} {
+	resp := make(map[string]interface{})
+	resp["metrics"] = svc.Metrics.Snapshot()
+	resp["latency"] = svc.Metrics.Histograms()
+	if svc.Faults != nil {
+		resp["fault"] = svc.Faults.current(time.Now())
+	}
+	resp["sierra_in_flight"] = svc.Limiter.inUse()
+	resp["caches"] = svc.cacheStats()
+	if svc.Adaptive != nil {
+		resp["adaptive_paging"] = svc.Adaptive.status()
+	}
+	return resp
+}
+
+// adminReload re-reads all reloadable external configuration
+func (svc *ServiceContext) adminReload(c *gin.Context) {
+	log.Printf("Admin reload requested")
+	if err := svc.reload(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"status_code": http.StatusInternalServerError,
+			"status_msg": err.Error(), "error_code": errInternal, "reload": svc.getReloadStatus()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reload": svc.getReloadStatus()})
+}
+
+// adminStatus reports the runtime status of the service
+func (svc *ServiceContext) adminStatus(c *gin.Context) {
+	resp := svc.runtimeStatus()
+	resp["reload"] = svc.getReloadStatus()
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+// secretConfigFields are the ServiceConfig fields that must never be reported
+var secretConfigFields = map[string]bool{"APIKey": true, "APISecret": true, "JWTKey": true, "MonitorSecret": true}
+
+// every config field is either allowlisted or a known secret, so a new field is a decision
+// rather than a silent mask or leak
+func TestConfigFieldsAreClassified(t *testing.T) {
+	cfgType := reflect.TypeOf(ServiceConfig{})
+	for i := 0; i < cfgType.NumField(); i++ {
+		name := cfgType.Field(i).Name
+		if safeConfigFields[name] == secretConfigFields[name] {
+			t.Errorf("config field %s must be either in safeConfigFields or a secret", name)
+		}
+	}
+	for name := range safeConfigFields {
+		if _, found := cfgType.FieldByName(name); found == false {
+			t.Errorf("safeConfigFields lists %s, which is not a config field", name)
+		}
+	}
+}
+
+func TestRedactedConfig(t *testing.T) {
+	cfg := newTestConfig("https://sierra.example.org/iii/sierra-api/v6")
+	cfg.APIKey = "key-123"
+	cfg.APISecret = "secret-456"
+	cfg.MonitorSecret = ""
+	out := redactedConfig(cfg)
+	tests := []struct {
+		name string
+		want interface{}
+	}{
+		{"APIKey", "*****"},
+		{"APISecret", "*****"},
+		{"MonitorSecret", ""},
+		{"API", "https://sierra.example.org/iii/sierra-api/v6"},
+		{"SummaryLength", 300},
+	}
+	for _, tc := range tests {
+		if got := out[tc.name]; got != tc.want {
+			t.Errorf("%s = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if len(out) != reflect.TypeOf(ServiceConfig{}).NumField() {
+		t.Errorf("%d config values reported, want every field", len(out))
+	}
+}
+
+func TestAdminConfigSnapshot(t *testing.T) {
+	cfg := newTestConfig("https://sierra.example.org/iii/sierra-api/v6")
+	cfg.APISecret = "secret-456"
+	cfg.FieldCfg = filepath.Join(t.TempDir(), "fields.toml")
+	writeOverrides(t, cfg.FieldCfg, "[fields.author]\nvisibility = \"hidden\"\n")
+	svc := newTestServiceWithConfig(t, cfg)
+	if err := svc.reloadFieldOverrides(); err != nil {
+		t.Fatalf("reloadFieldOverrides failed: %s", err.Error())
+	}
+	router := newRouter(svc)
+
+	if rec := apiRequestAs(t, router, v4jwt.User, http.MethodGet, "/api/admin/config", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("non-staff status = %d, want 403", rec.Code)
+	}
+	rec := apiRequestAs(t, router, v4jwt.Staff, http.MethodGet, "/api/admin/config", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "secret-456") || strings.Contains(rec.Body.String(), cfg.JWTKey) {
+		t.Errorf("snapshot leaks a secret: %s", rec.Body.String())
+	}
+	var resp struct {
+		Config map[string]interface{} `json:"config"`
+		Files  []LoadedFile           `json:"files"`
+		Build  struct {
+			Version string `json:"version"`
+		} `json:"build"`
+	}
+	decodeTestJSON(t, rec, &resp)
+	if resp.Config["APISecret"] != "*****" || resp.Config["API"] != cfg.API {
+		t.Errorf("config = %v", resp.Config)
+	}
+	kinds := make(map[string]bool)
+	for _, f := range resp.Files {
+		kinds[f.Kind] = true
+		if len(f.Checksum) != 64 || f.LoadedAt.IsZero() {
+			t.Errorf("loaded file %+v has no checksum or load time", f)
+		}
+	}
+	if kinds["field_overrides"] == false {
+		t.Errorf("loaded files = %+v, want the field overrides", resp.Files)
+	}
+	if resp.Build.Version != svc.Version {
+		t.Errorf("build version = %q, want %q", resp.Build.Version, svc.Version)
+	}
+}
@@ -2,24 +2,34 @@ package main
 
 // JMRLResult contains the response data from a JMRL search
 type JMRLResult struct {
-	Count   int `json:"count"`
-	Total   int `json:"total"`
-	Start   int `json:"start"`
-	Entries []struct {
-		Relevance float32 `json:"relevance"`
-		Bib       JMRLBib `json:"bib"`
-	} `json:"entries"`
+	Count   int         `json:"count"`
+	Total   int         `json:"total"`
+	Start   int         `json:"start"`
+	Entries []JMRLEntry `json:"entries"`
+}
+
+// JMRLEntry is a single search hit with its relevance score
+type JMRLEntry struct {
+	Relevance float32 `json:"relevance"`
+	Bib       JMRLBib `json:"bib"`
 }
 
 // JMRLBib contans the MARC and JRML data for a single query hit
 type JMRLBib struct {
-	ID          string          `json:"id"`
-	PublishYear int             `json:"publishYear"`
-	Language    JMRLCodeValue   `json:"lang"`
-	Type        JMRLCodeValue   `json:"materialType"`
-	Locations   []JMRLCodeValue `json:"locations"`
-	Available   bool            `json:"available"`
-	VarFields   []JMRLVarFields `json:"varFields"`
+	ID          string                    `json:"id"`
+	Title       string                    `json:"title"`
+	Author      string                    `json:"author"`
+	BibLevel    JMRLCodeValue             `json:"bibLevel"`
+	PublishYear int                       `json:"publishYear"`
+	CreatedDate string                    `json:"createdDate,omitempty"`
+	Language    JMRLCodeValue             `json:"lang"`
+	Type        JMRLCodeValue             `json:"materialType"`
+	Locations   []JMRLCodeValue           `json:"locations"`
+	Available   bool                      `json:"available"`
+	VarFields   []JMRLVarFields           `json:"varFields"`
+	FixedFields map[string]JMRLFixedField `json:"fixedFields,omitempty"`
+	Deleted     bool                      `json:"deleted,omitempty"`
+	DeletedDate string                    `json:"deletedDate,omitempty"`
 }
 
 // JMRLCodeValue is a pair of code / value or code/name data
@@ -29,11 +39,62 @@ type JMRLCodeValue struct {
 	Name  string `json:"name,omitempty"`
 }
 
-// JMRLVarFields contains MARC data from the JRML fields=varFields request param
+// JMRLVarFields contains MARC data from the JRML fields=varFields request param.
+// Non-MARC fields (such as item messages) have a FieldTag and Content instead.
 type JMRLVarFields struct {
-	MarcTag   string `json:"marcTag"`
-	Subfields []struct {
-		Tag     string `json:"tag"`
-		Content string `json:"content"`
-	} `json:"subfields"`
+	FieldTag  string         `json:"fieldTag,omitempty"`
+	MarcTag   string         `json:"marcTag"`
+	Content   string         `json:"content,omitempty"`
+	Subfields []JMRLSubfield `json:"subfields"`
+}
+
+// JMRLFixedField is a single Sierra fixed length field
+type JMRLFixedField struct {
+	Label   string      `json:"label"`
+	Value   interface{} `json:"value"`
+	Display string      `json:"display,omitempty"`
+}
+
+// JMRLSubfield is a single MARC subfield. Content is decoded from the raw JSON bytes
+// so that legacy Latin-1 data can be repaired rather than replaced with U+FFFD
+type JMRLSubfield struct {
+	Tag      string `json:"tag"`
+	Content  string `json:"content"`
+	Repaired bool   `json:"-"`
+}
+
+// JMRLItemResult contains the response data from a JMRL items request
+type JMRLItemResult struct {
+	Total   int        `json:"total"`
+	Entries []JMRLItem `json:"entries"`
+}
+
+// JMRLItem contains the Sierra data for a single physical item
+type JMRLItem struct {
+	ID          string                    `json:"id"`
+	BibIDs      []string                  `json:"bibIds"`
+	Location    JMRLCodeValue             `json:"location"`
+	Status      JMRLItemStatus            `json:"status"`
+	Barcode     string                    `json:"barcode"`
+	CallNumber  string                    `json:"callNumber"`
+	ItemType    string                    `json:"itemType"`
+	VarFields   []JMRLVarFields           `json:"varFields"`
+	FixedFields map[string]JMRLFixedField `json:"fixedFields"`
+}
+
+// JMRLItemStatus is the circulation status of an item. DueDate is only present
+// for checked out items
+type JMRLItemStatus struct {
+	Code    string `json:"code"`
+	Display string `json:"display"`
+	DueDate string `json:"duedate,omitempty"`
+}
+
+// SierraError is the structured error body returned by the Sierra API
+type SierraError struct {
+	Code         int    `json:"code"`
+	SpecificCode int    `json:"specificCode"`
+	HTTPStatus   int    `json:"httpStatus"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
 }
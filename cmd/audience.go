@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// agesFixedFieldLabel is the label of the JMRL bib fixed field holding the juvenile age range
+const agesFixedFieldLabel = "AGES"
+
+// agePattern finds an age or age range, e.g. "Ages 4-8", "4 to 8", "ages 10 and up"
+var agePattern = regexp.MustCompile(`(\d{1,2})(?:\s*(?:-|–|to)\s*(\d{1,2}))?`)
+
+// getAgesFixedField returns the display value of the AGES fixed field, if present
+func getAgesFixedField(bib *JMRLBib) string {
+	for _, ff := range bib.FixedFields {
+		if strings.EqualFold(strings.TrimSpace(ff.Label), agesFixedFieldLabel) {
+			val := strings.TrimSpace(ff.Display)
+			if val == "" {
+				val = strings.TrimSpace(fmt.Sprintf("%v", ff.Value))
+			}
+			return val
+		}
+	}
+	return ""
+}
+
+// parseAgeRange extracts the first age or age range from text. A single age is
+// returned as a range of one year.
+func parseAgeRange(text string) (int, int, bool) {
+	m := agePattern.FindStringSubmatch(text)
+	if m == nil {
+		return 0, 0, false
+	}
+	low, _ := strconv.Atoi(m[1])
+	high := low
+	if m[2] != "" {
+		high, _ = strconv.Atoi(m[2])
+	}
+	return low, high, true
+}
+
+// agesConflict returns true when both values contain an age range and the ranges differ.
+// Values that can't be parsed are never reported as conflicts.
+func agesConflict(note string, fixed string) bool {
+	noteLow, noteHigh, noteOK := parseAgeRange(note)
+	fixedLow, fixedHigh, fixedOK := parseAgeRange(fixed)
+	if noteOK == false || fixedOK == false {
+		return false
+	}
+	return noteLow != fixedLow || noteHigh != fixedHigh
+}
+
+// getAudienceFields returns the audience_age field, preferring the 521 target audience
+// note, with the AGES fixed field as a detailed secondary field. Disagreements between
+// the two are logged as data quality reports for JMRL catalogers.
+func getAudienceFields(bib *JMRLBib) []v4api.RecordField {
+	fields := make([]v4api.RecordField, 0)
+	note := ""
+	if vals := getVarField(&bib.VarFields, "521", "a"); len(vals) > 0 {
+		note = cleanNoteText(vals[0])
+	}
+	fixed := getAgesFixedField(bib)
+	if note != "" {
+		fields = append(fields, v4api.RecordField{Name: "audience_age", Type: "audience", Label: "Audience", Value: note})
+	} else if fixed != "" {
+		fields = append(fields, v4api.RecordField{Name: "audience_age", Type: "audience", Label: "Audience", Value: fixed})
+	}
+	if note != "" && fixed != "" {
+		fields = append(fields, v4api.RecordField{Name: "audience_age_fixed", Type: "audience", Label: "Audience (Age Range)",
+			Value: fixed, Visibility: "detailed"})
+		if agesConflict(note, fixed) {
+			log.Printf("DATA QUALITY: bib %s audience age mismatch; 521 [%s] AGES [%s]", bib.ID, note, fixed)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// captureLog returns the log output written while fn runs
+func captureLog(fn func()) string {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	fn()
+	return buf.String()
+}
+
+func TestParseAgeRange(t *testing.T) {
+	tests := []struct {
+		text      string
+		low, high int
+		ok        bool
+	}{
+		{"Ages 4-8.", 4, 8, true},
+		{"4 to 8", 4, 8, true},
+		{"Ages 4 – 8", 4, 8, true},
+		{"Ages 10 and up", 10, 10, true},
+		{"Juvenile", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tc := range tests {
+		low, high, ok := parseAgeRange(tc.text)
+		if low != tc.low || high != tc.high || ok != tc.ok {
+			t.Errorf("parseAgeRange(%q) = %d %d %t, want %d %d %t", tc.text, low, high, ok, tc.low, tc.high, tc.ok)
+		}
+	}
+}
+
+func TestAudienceFields(t *testing.T) {
+	ages := func(display string) map[string]JMRLFixedField {
+		return map[string]JMRLFixedField{"44": {Label: "AGES", Value: "j", Display: display}}
+	}
+	tests := []struct {
+		name     string
+		note     string
+		fixed    map[string]JMRLFixedField
+		audience []string
+		fixedVal []string
+		conflict bool
+	}{
+		{"agreeing", "Ages 4-8.", ages("4-8"), []string{"Ages 4-8"}, []string{"4-8"}, false},
+		{"conflicting", "Ages 4-8.", ages("9-12"), []string{"Ages 4-8"}, []string{"9-12"}, true},
+		{"unparseable fixed field", "Ages 4-8.", ages("Juvenile"), []string{"Ages 4-8"}, []string{"Juvenile"}, false},
+		{"note only", "Grades 3-5", nil, []string{"Grades 3-5"}, []string{}, false},
+		{"fixed field only", "", ages("4-8"), []string{"4-8"}, []string{}, false},
+		{"fixed field value without display", "", map[string]JMRLFixedField{"44": {Label: "ages", Value: "10-14"}}, []string{"10-14"}, []string{}, false},
+		{"neither", "", nil, []string{}, []string{}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bib := testBib("1001", "Title")
+			bib.FixedFields = tc.fixed
+			if tc.note != "" {
+				bib.VarFields = append(bib.VarFields, marcField("521", "a", tc.note))
+			}
+			var fields []v4api.RecordField
+			out := captureLog(func() { fields = getAudienceFields(&bib) })
+			if got := fieldValues(fields, "audience_age"); reflect.DeepEqual(got, tc.audience) == false {
+				t.Errorf("audience_age = %q, want %q", got, tc.audience)
+			}
+			if got := fieldValues(fields, "audience_age_fixed"); reflect.DeepEqual(got, tc.fixedVal) == false {
+				t.Errorf("audience_age_fixed = %q, want %q", got, tc.fixedVal)
+			}
+			reported := strings.Contains(out, "DATA QUALITY: bib 1001")
+			if reported != tc.conflict {
+				t.Errorf("data quality report %t, want %t: %s", reported, tc.conflict, out)
+			}
+			if reported && (strings.Contains(out, "521 [Ages 4-8]") == false || strings.Contains(out, "AGES [9-12]") == false) {
+				t.Errorf("data quality report is missing the values: %s", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// authorMarcTags are the MARC tags that carry author-ish names, in display order
+var authorMarcTags = []string{"100", "110", "700", "710"}
+
+// authorValue is an author name in its original display form plus the canonical form
+// used to bucket and de-duplicate names
+type authorValue struct {
+	Display   string
+	Canonical string
+}
+
+// normalizeAuthorName produces a canonical form of an author name: lowercase, punctuation
+// and extra spacing removed so "Rowling, J. K." and "Rowling, J.K." match. When stripDates
+// is set, trailing dates such as "1835-1910" or "b. 1950" are dropped as well.
+func normalizeAuthorName(name string, stripDates bool) string {
+	if stripDates {
+		tokens := strings.Fields(html.UnescapeString(name))
+		next := ""
+		for len(tokens) > 0 && isDateToken(tokens[len(tokens)-1], next) {
+			next = tokens[len(tokens)-1]
+			tokens = tokens[:len(tokens)-1]
+		}
+		name = strings.Join(tokens, " ")
+	}
+	return normalizeMatchText(name)
+}
+
+// isDateToken returns true for a year or year range token such as "1835-1910." and for
+// the b./d./ca. (born/died/circa) markers and range dashes when the token after them is a
+// year. A marker must not end in a comma, so the initial in "Smith, J. D., 1950-" is kept.
+func isDateToken(token string, next string) bool {
+	switch strings.ToLower(strings.TrimSuffix(token, ".")) {
+	case "b", "d", "ca", "-":
+		return isYearToken(next)
+	}
+	return isYearToken(token)
+}
+
+// isYearToken returns true for a token of digits with date punctuation, like 1950- or
+// (1835-1910)
+func isYearToken(token string) bool {
+	digits := 0
+	for _, r := range token {
+		if unicode.IsDigit(r) {
+			digits++
+		} else if strings.ContainsRune("-?.,;:()[]", r) == false {
+			return false
+		}
+	}
+	return digits > 0
+}
+
+// getAuthorValues returns the de-duplicated author names for a bib. The first display
+// form seen for each canonical name is kept.
+func getAuthorValues(bib *JMRLBib) []authorValue {
+	out := make([]authorValue, 0)
+	seen := make(map[string]bool)
+	for _, tag := range authorMarcTags {
+		for _, val := range getVarField(&bib.VarFields, tag, "a") {
+			display := html.UnescapeString(val)
+			canonical := normalizeAuthorName(display, true)
+			if canonical == "" || seen[canonical] {
+				continue
+			}
+			seen[canonical] = true
+			out = append(out, authorValue{Display: display, Canonical: canonical})
+		}
+	}
+	return out
+}
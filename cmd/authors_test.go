@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeAuthorName(t *testing.T) {
+	tests := []struct {
+		name       string
+		stripDates bool
+		want       string
+	}{
+		{"Rowling, J. K.", true, "rowling j k"},
+		{"Rowling, J.K.", true, "rowling j k"},
+		{"  Rowling ,  J. K.  ", true, "rowling j k"},
+		{"Twain, Mark, 1835-1910.", true, "twain mark"},
+		{"Twain, Mark, 1835-1910.", false, "twain mark 1835 1910"},
+		{"Twain, Mark, 1835 - 1910", true, "twain mark"},
+		{"Smith, John, b. 1950", true, "smith john"},
+		{"Smith, John, d. 1910.", true, "smith john"},
+		{"Homer, ca. 800 B.C.", true, "homer ca 800 b c"},
+		{"Aesop, ca. 620-564", true, "aesop"},
+		{"Rowling, J. K., 1965-", true, "rowling j k"},
+		// b and d initials are only markers when a year follows them
+		{"Smith, John D.", true, "smith john d"},
+		{"Smith, John B", true, "smith john b"},
+		{"Smith, J. D., 1950-", true, "smith j d"},
+		{"Brontë, Charlotte, 1816-1855", true, "brontë charlotte"},
+		{"Dickens &amp; Co.", true, "dickens co"},
+		{"1984", true, ""},
+		{"", true, ""},
+	}
+	for _, tc := range tests {
+		if got := normalizeAuthorName(tc.name, tc.stripDates); got != tc.want {
+			t.Errorf("normalizeAuthorName(%q, %t) = %q, want %q", tc.name, tc.stripDates, got, tc.want)
+		}
+	}
+}
+
+func TestIsDateToken(t *testing.T) {
+	tests := []struct {
+		token string
+		next  string
+		want  bool
+	}{
+		{"1950", "", true},
+		{"1835-1910.", "", true},
+		{"(1835-1910)", "", true},
+		{"1950-", "", true},
+		{"b.", "1950", true},
+		{"d", "1910.", true},
+		{"ca.", "1800", true},
+		{"b.", "", false},
+		{"D.", "", false},
+		{"d.", "Smith", false},
+		{"D.,", "1950-", false},
+		{"-", "1910", true},
+		{"-", "", false},
+		{"Smith", "", false},
+	}
+	for _, tc := range tests {
+		if got := isDateToken(tc.token, tc.next); got != tc.want {
+			t.Errorf("isDateToken(%q, %q) = %t, want %t", tc.token, tc.next, got, tc.want)
+		}
+	}
+}
+
+func TestAuthorValuesDeduplicate(t *testing.T) {
+	bib := JMRLBib{ID: "b1", VarFields: []JMRLVarFields{
+		marcField("100", "a", "Rowling, J. K.,", "d", "1965-"),
+		marcField("700", "a", "Rowling, J.K."),
+		marcField("700", "a", "Kay, Jim,"),
+		marcField("710", "a", "Scholastic Inc."),
+		marcField("110", "a", "Bloomsbury Publishing."),
+		marcField("700", "a", "KAY, JIM"),
+	}}
+	want := []authorValue{
+		{Display: "Rowling, J. K.,", Canonical: "rowling j k"},
+		{Display: "Bloomsbury Publishing", Canonical: "bloomsbury publishing"},
+		{Display: "Kay, Jim,", Canonical: "kay jim"},
+		{Display: "Scholastic Inc", Canonical: "scholastic inc"},
+	}
+	if got := getAuthorValues(&bib); reflect.DeepEqual(got, want) == false {
+		t.Errorf("getAuthorValues = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"time"
+)
+
+// Availability classes are a stable contract with the client, which uses them to color
+// availability. Do not rename them.
+const (
+	// availableClass: at least one physical copy is on the shelf
+	availableClass = "available"
+	// soonClass: no copy is on the shelf but one is due back within the soon window
+	soonClass = "soon"
+	// onlineClass: no copy is on the shelf but the record is available online
+	onlineClass = "online"
+	// unavailableClass: none of the above
+	unavailableClass = "unavailable"
+)
+
+// earliestDue returns the soonest due date across all checked out holdings
+func earliestDue(holdings []Holding) (time.Time, bool) {
+	var soonest time.Time
+	found := false
+	for _, h := range holdings {
+		due, ok := parseSierraDate(h.DueDate)
+		if ok == false {
+			continue
+		}
+		if found == false || due.Before(soonest) {
+			soonest = due
+			found = true
+		}
+	}
+	return soonest, found
+}
+
+// classifyAvailability derives the coarse availability class for a record from its
+// holdings, whether it has online access, the current time and the "soon" window
+func classifyAvailability(holdings []Holding, online bool, now time.Time, soonWindow time.Duration) string {
+	for _, h := range holdings {
+		if h.Available {
+			return availableClass
+		}
+	}
+	if online {
+		return onlineClass
+	}
+	if due, ok := earliestDue(holdings); ok && due.Before(now.Add(soonWindow)) {
+		return soonClass
+	}
+	return unavailableClass
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClassifyAvailability(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+	week := 7 * 24 * time.Hour
+	due := func(d time.Duration) Holding {
+		return Holding{DueDate: now.Add(d).Format(time.RFC3339)}
+	}
+	tests := []struct {
+		name     string
+		holdings []Holding
+		online   bool
+		window   time.Duration
+		want     string
+	}{
+		{"on shelf", []Holding{{Available: true}}, false, week, availableClass},
+		{"on shelf and checked out", []Holding{due(2 * time.Hour), {Available: true}}, false, week, availableClass},
+		{"on shelf and online", []Holding{{Available: true}}, true, week, availableClass},
+		{"online only", nil, true, week, onlineClass},
+		{"online beats due soon", []Holding{due(24 * time.Hour)}, true, week, onlineClass},
+		{"due tomorrow", []Holding{due(24 * time.Hour)}, false, week, soonClass},
+		{"overdue", []Holding{due(-48 * time.Hour)}, false, week, soonClass},
+		{"soonest copy counts", []Holding{due(30 * 24 * time.Hour), due(3 * 24 * time.Hour)}, false, week, soonClass},
+		{"due at window edge", []Holding{due(week)}, false, week, unavailableClass},
+		{"due next month", []Holding{due(30 * 24 * time.Hour)}, false, week, unavailableClass},
+		{"configured window", []Holding{due(10 * 24 * time.Hour)}, false, 14 * 24 * time.Hour, soonClass},
+		{"zero window", []Holding{due(time.Hour)}, false, 0, unavailableClass},
+		{"missing with no due date", []Holding{{Status: "MISSING"}}, false, week, unavailableClass},
+		{"no holdings", nil, false, week, unavailableClass},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := classifyAvailability(tc.holdings, tc.online, now, tc.window); got != tc.want {
+				t.Errorf("classifyAvailability = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// the class names are a contract with the client
+func TestAvailabilityClassNames(t *testing.T) {
+	for got, want := range map[string]string{availableClass: "available", soonClass: "soon",
+		onlineClass: "online", unavailableClass: "unavailable"} {
+		if got != want {
+			t.Errorf("availability class %q renamed from %q", got, want)
+		}
+	}
+}
+
+func TestEarliestDue(t *testing.T) {
+	holdings := []Holding{{DueDate: "2024-03-15T08:00:00Z"}, {Available: true}, {DueDate: "2024-03-01T08:00:00Z"}, {DueDate: "bad"}}
+	due, ok := earliestDue(holdings)
+	if ok == false || due.Equal(time.Date(2024, time.March, 1, 8, 0, 0, 0, time.UTC)) == false {
+		t.Errorf("earliestDue = %s %t", due, ok)
+	}
+	if _, ok := earliestDue([]Holding{{Available: true}}); ok {
+		t.Errorf("earliestDue of available holdings returned a date")
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// filterAvailability is the V4 facet ID for the availability filter. Sierra can't
+// restrict a text search by availability, so it is applied to the results instead.
+const filterAvailability = "FilterAvailability"
+
+// availability filter values
+const availOnShelf = "On shelf"
+const availOnline = "Online"
+
+// postFilterScanMax is the most Sierra hits scanned to fill a post-filtered page
+const postFilterScanMax = 500
+
+// availabilityFilter is the set of availability values selected in a search. Values are ORed.
+// Values required by the query must all match as well.
+type availabilityFilter struct {
+	OnShelf        bool
+	Online         bool
+	RequireOnShelf bool
+	RequireOnline  bool
+}
+
+// getAvailabilityFilter extracts the availability filter from the request filters.
+// Unrecognized values are returned as warnings.
+func getAvailabilityFilter(filters []v4api.Filter) (availabilityFilter, []string) {
+	out := availabilityFilter{}
+	warnings := make([]string, 0)
+	for _, filter := range filters {
+		for _, facet := range filter.Facets {
+			if facet.FacetID != filterAvailability {
+				continue
+			}
+			switch availabilityValue(facet.Value) {
+			case availOnShelf:
+				out.OnShelf = true
+			case availOnline:
+				out.Online = true
+			default:
+				warnings = append(warnings, fmt.Sprintf("%s value [%s] is not supported by JMRL and was ignored", filterAvailability, facet.Value))
+			}
+		}
+	}
+	return out, warnings
+}
+
+// requireValues adds the availability values the query requires; other facets are skipped.
+// Unrecognized values are returned as warnings.
+func (af *availabilityFilter) requireValues(required []filterValue) []string {
+	warnings := make([]string, 0)
+	for _, fv := range required {
+		if fv.FacetID != filterAvailability {
+			continue
+		}
+		switch availabilityValue(fv.Value) {
+		case availOnShelf:
+			af.RequireOnShelf = true
+		case availOnline:
+			af.RequireOnline = true
+		default:
+			warnings = append(warnings, fmt.Sprintf("%s value [%s] is not supported by JMRL and was ignored", filterAvailability, fv.Value))
+		}
+	}
+	return warnings
+}
+
+// availabilityValue returns the availability bucket named by a filter value, or empty
+func availabilityValue(value string) string {
+	switch normalizeMatchText(value) {
+	case normalizeMatchText(availOnShelf), "available":
+		return availOnShelf
+	case normalizeMatchText(availOnline):
+		return availOnline
+	}
+	return ""
+}
+
+// active returns true if the filter restricts results
+func (af availabilityFilter) active() bool {
+	return af.OnShelf || af.Online || af.RequireOnShelf || af.RequireOnline
+}
+
+// matches returns true if the bib has every required availability value and any of the
+// selected ones
+func (af availabilityFilter) matches(bib *JMRLBib) bool {
+	if (af.RequireOnShelf && bib.Available == false) || (af.RequireOnline && hasOnlineAccess(bib) == false) {
+		return false
+	}
+	if af.OnShelf == false && af.Online == false {
+		return true
+	}
+	return (af.OnShelf && bib.Available) || (af.Online && hasOnlineAccess(bib))
+}
+
+// hasOnlineAccess returns true if the bib has an 856 access URL
+func hasOnlineAccess(bib *JMRLBib) bool {
+	return len(getVarField(&bib.VarFields, "856", "u")) > 0
+}
+
+// searchBibs runs a Sierra bib search with the given params and parses the response
+func (svc *ServiceContext) searchBibs(ctx context.Context, params url.Values, trace *requestTrace) (*JMRLResult, *RequestError) {
+	resp, err := svc.tracedAPIGet(ctx, svc.Sierra.SearchURL(params), trace)
+	if err != nil {
+		return nil, err
+	}
+	jmrlResp := &JMRLResult{}
+	if respErr := json.Unmarshal(resp, jmrlResp); respErr != nil {
+		log.Printf("ERROR: Invalid response from JMRL API: %s", respErr.Error())
+		return nil, &RequestError{StatusCode: http.StatusInternalServerError, Message: respErr.Error()}
+	}
+	return jmrlResp, nil
+}
+
+// postFilteredSearch fills a page of results that Sierra can't filter server-side. Sierra
+// hits are scanned from the start of the result set in batches (up to postFilterScanMax)
+// and the requested window of matching hits is returned. Total is exact when the whole
+// Sierra result set was scanned; otherwise it is estimated from the match rate of the
+// scanned hits and true is returned to flag the estimate. The estimate is never less
+// than the number of matches already found, and never more than paging can reach: the
+// matches found plus every hit left to scan within postFilterScanMax.
+func (svc *ServiceContext) postFilteredSearch(ctx context.Context, params url.Values, start int, rows int, sortOrder v4api.SortOrder,
+	match func(bib *JMRLBib) bool, trace *requestTrace) (*JMRLResult, bool, *RequestError) {
+	scanned := make([]JMRLEntry, 0)
+	sierraTotal := 0
+	for len(scanned) < postFilterScanMax {
+		params.Set("offset", fmt.Sprintf("%d", len(scanned)))
+		params.Set("limit", fmt.Sprintf("%d", sierraMaxLimit))
+		batch, err := svc.searchBibs(ctx, params, trace)
+		if err != nil {
+			// with some hits scanned, a spent time budget gives an estimated partial page
+			if isBudgetExceeded(err) && len(scanned) > 0 {
+				log.Printf("WARNING: post filter stopped by the time budget after %d hits", len(scanned))
+				trace.decision("time budget spent during post filter scan")
+				break
+			}
+			return nil, false, err
+		}
+		sierraTotal = batch.Total
+		trace.returned(len(batch.Entries))
+		scanned = append(scanned, batch.Entries...)
+		if len(batch.Entries) == 0 || len(scanned) >= sierraTotal {
+			break
+		}
+		if countMatches(scanned, match) >= start+rows {
+			break
+		}
+	}
+	orderEntries(scanned, sortOrder)
+
+	matches := make([]JMRLEntry, 0)
+	for _, entry := range scanned {
+		if match(&entry.Bib) {
+			matches = append(matches, entry)
+		}
+	}
+
+	out := &JMRLResult{Start: start, Total: len(matches), Entries: make([]JMRLEntry, 0)}
+	estimated := len(scanned) < sierraTotal
+	if estimated && len(scanned) > 0 {
+		rate := float64(len(matches)) / float64(len(scanned))
+		est := int(math.Round(rate * float64(sierraTotal)))
+		if reachable := len(matches) + unscannedHits(sierraTotal, len(scanned)); est > reachable {
+			est = reachable
+		}
+		if est > out.Total {
+			out.Total = est
+		}
+	}
+	if start < len(matches) {
+		end := start + rows
+		if end > len(matches) {
+			end = len(matches)
+		}
+		out.Entries = matches[start:end]
+	}
+	out.Count = len(out.Entries)
+	log.Printf("Post filter scanned %d of %d Sierra hits; %d matched, total %d (estimated %t)",
+		len(scanned), sierraTotal, len(matches), out.Total, estimated)
+	if trace.enabled() {
+		trace.decision(fmt.Sprintf("post filter scanned %d of %d hits, %d matched", len(scanned), sierraTotal, len(matches)))
+	}
+	return out, estimated, nil
+}
+
+// unscannedHits returns how many more Sierra hits a post filter scan could reach
+func unscannedHits(sierraTotal int, scanned int) int {
+	limit := sierraTotal
+	if limit > postFilterScanMax {
+		limit = postFilterScanMax
+	}
+	if scanned >= limit {
+		return 0
+	}
+	return limit - scanned
+}
+
+// countMatches returns the number of entries accepted by the match function
+func countMatches(entries []JMRLEntry, match func(bib *JMRLBib) bool) int {
+	cnt := 0
+	for i := range entries {
+		if match(&entries[i].Bib) {
+			cnt++
+		}
+	}
+	return cnt
+}
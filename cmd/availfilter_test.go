@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// servePagedSearch answers Sierra searches with total bibs, paged by offset and limit.
+// Every nth bib is available.
+func servePagedSearch(sierra *fakeSierra, total int, every int) {
+	sierra.handle("bibs/search", func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		out := JMRLResult{Total: total, Start: offset, Entries: make([]JMRLEntry, 0)}
+		for i := offset; i < total && i < offset+limit; i++ {
+			bib := testBib(fmt.Sprintf("%d", 100000+i), "Bib")
+			bib.Available = i%every == 0
+			out.Entries = append(out.Entries, JMRLEntry{Bib: bib})
+		}
+		out.Count = len(out.Entries)
+		writeTestJSON(w, http.StatusOK, out)
+	})
+}
+
+func TestGetAvailabilityFilter(t *testing.T) {
+	tests := []struct {
+		filters  []v4api.Filter
+		want     availabilityFilter
+		warnings int
+	}{
+		{nil, availabilityFilter{}, 0},
+		{testFilters(filterAvailability, "On shelf"), availabilityFilter{OnShelf: true}, 0},
+		{testFilters(filterAvailability, "available"), availabilityFilter{OnShelf: true}, 0},
+		{testFilters(filterAvailability, "ONLINE", filterAvailability, "On Shelf"), availabilityFilter{OnShelf: true, Online: true}, 0},
+		{testFilters(filterAvailability, "Someday", filterFormat, "Book"), availabilityFilter{}, 1},
+	}
+	for _, tc := range tests {
+		got, warnings := getAvailabilityFilter(tc.filters)
+		if got != tc.want || len(warnings) != tc.warnings {
+			t.Errorf("getAvailabilityFilter(%v) = %+v %v, want %+v with %d warnings", tc.filters, got, warnings, tc.want, tc.warnings)
+		}
+	}
+}
+
+func TestAvailabilityFilterMatches(t *testing.T) {
+	onShelf := testBib("1", "On shelf")
+	onShelf.Available = true
+	online := testBib("2", "Online")
+	online.VarFields = append(online.VarFields, marcField("856", "u", "https://example.org/e"))
+	neither := testBib("3", "Neither")
+	tests := []struct {
+		filter availabilityFilter
+		bib    *JMRLBib
+		want   bool
+	}{
+		{availabilityFilter{OnShelf: true}, &onShelf, true},
+		{availabilityFilter{OnShelf: true}, &online, false},
+		{availabilityFilter{Online: true}, &online, true},
+		{availabilityFilter{Online: true}, &onShelf, false},
+		{availabilityFilter{OnShelf: true, Online: true}, &online, true},
+		{availabilityFilter{OnShelf: true, Online: true}, &neither, false},
+	}
+	for _, tc := range tests {
+		if got := tc.filter.matches(tc.bib); got != tc.want {
+			t.Errorf("%+v matches %s = %t, want %t", tc.filter, tc.bib.Title, got, tc.want)
+		}
+	}
+}
+
+func TestUnscannedHits(t *testing.T) {
+	tests := []struct {
+		total, scanned, want int
+	}{
+		{30, 30, 0},
+		{2000, 50, postFilterScanMax - 50},
+		{2000, postFilterScanMax, 0},
+		{2000, postFilterScanMax + 50, 0},
+		{120, 50, 70},
+	}
+	for _, tc := range tests {
+		if got := unscannedHits(tc.total, tc.scanned); got != tc.want {
+			t.Errorf("unscannedHits(%d, %d) = %d, want %d", tc.total, tc.scanned, got, tc.want)
+		}
+	}
+}
+
+func TestPostFilteredTotals(t *testing.T) {
+	relevance := v4api.SortOrder{SortID: v4api.SortRelevance.String(), Order: "desc"}
+	onShelf := availabilityFilter{OnShelf: true}
+	tests := []struct {
+		name      string
+		total     int
+		every     int
+		start     int
+		rows      int
+		wantTotal int
+		estimated bool
+		entries   int
+	}{
+		{"whole result scanned", 30, 3, 0, 20, 10, false, 10},
+		{"rate estimate", 2000, 10, 0, 5, 200, true, 5},
+		// every hit matches, but paging can't go past the scan limit
+		{"capped at reachable", 2000, 1, 0, 20, postFilterScanMax, true, 20},
+		{"last reachable page", 2000, 1, postFilterScanMax - 20, 20, postFilterScanMax, true, 20},
+		{"past reachable", 2000, 1, postFilterScanMax, 20, postFilterScanMax, true, 0},
+		// the scan limit is reached; only the matches found can be paged to
+		{"sparse capped at scanned", 5000, 100, 0, 20, 5, true, 5},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sierra := newFakeSierra(t)
+			servePagedSearch(sierra, tc.total, tc.every)
+			svc := newTestService(t, sierra)
+			params := url.Values{"text": {"(cats)"}}
+			res, estimated, err := svc.postFilteredSearch(context.Background(), params, tc.start, tc.rows, relevance, onShelf.matches, nil)
+			if err != nil {
+				t.Fatalf("postFilteredSearch failed: %s", err.Message)
+			}
+			if res.Total != tc.wantTotal || estimated != tc.estimated || len(res.Entries) != tc.entries {
+				t.Errorf("total %d estimated %t entries %d, want %d %t %d", res.Total, estimated, len(res.Entries),
+					tc.wantTotal, tc.estimated, tc.entries)
+			}
+			if res.Total < tc.start+len(res.Entries) {
+				t.Errorf("total %d is less than the %d matches returned", res.Total, tc.start+len(res.Entries))
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// bibtexType is the BibTeX entry type for a Sierra material type. BibTeX has no audio or
+// video entry types, so those are @misc with a howpublished description.
+type bibtexType struct {
+	Entry        string
+	HowPublished string
+}
+
+// bibtexTypes maps Sierra material type codes (see formatMaterialTypes) to entry types.
+// Anything else is @misc.
+var bibtexTypes = map[string]bibtexType{
+	"a": {Entry: "book"},
+	"z": {Entry: "book", HowPublished: "Electronic book"},
+	"g": {Entry: "misc", HowPublished: "Video recording"},
+	"i": {Entry: "misc", HowPublished: "Audio recording"},
+	"s": {Entry: "misc", HowPublished: "Serial"},
+}
+
+// bibtexFieldOrder is the order of the fields in an entry
+var bibtexFieldOrder = []string{"author", "title", "howpublished", "publisher", "year", "isbn", "language", "url"}
+
+// bibtexEscapes are the characters with special meaning in BibTeX / LaTeX
+var bibtexEscapes = strings.NewReplacer(`\`, `\textbackslash{}`, "{", `\{`, "}", `\}`, "&", `\&`, "%", `\%`,
+	"$", `\$`, "#", `\#`, "_", `\_`, "~", `\textasciitilde{}`, "^", `\textasciicircum{}`)
+
+// escapeBibTeX escapes LaTeX special characters in a field value
+func escapeBibTeX(value string) string {
+	return bibtexEscapes.Replace(strings.Join(strings.Fields(value), " "))
+}
+
+// asciiTransliterate removes diacritics (é => e) and drops any other non-ASCII characters
+func asciiTransliterate(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII {
+			return -1
+		}
+		return r
+	}, foldDiacritics(value))
+}
+
+// keyPart lowercases the ASCII letters and digits of a value for use in a citation key
+func keyPart(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, asciiTransliterate(value))
+}
+
+// bibtexKey builds a deterministic citation key from the first author's surname, the
+// year and the first significant title word: rowling1997harry. Missing parts fall back
+// to anon, nd and the record ID.
+func bibtexKey(authors []string, year string, title string, id string) string {
+	surname := ""
+	if len(authors) > 0 {
+		name, _, _ := strings.Cut(authors[0], ",")
+		if words := strings.Fields(name); len(words) > 0 && strings.Contains(authors[0], ",") == false {
+			// uninverted names (corporate or "First Last") use the last word
+			name = words[len(words)-1]
+		}
+		surname = keyPart(name)
+	}
+	if surname == "" {
+		surname = "anon"
+	}
+	if year == "" {
+		year = "nd"
+	}
+	titleWord := ""
+	for _, word := range strings.Fields(normalizeMatchText(title)) {
+		if leadingArticles[word] == false {
+			titleWord = keyPart(word)
+			if titleWord != "" {
+				break
+			}
+		}
+	}
+	if titleWord == "" {
+		titleWord = keyPart(id)
+	}
+	return surname + year + titleWord
+}
+
+// buildBibTeX builds a BibTeX entry from the citation parts of record fields
+func buildBibTeX(bib *JMRLBib, fields []v4api.RecordField, ascii bool) string {
+	values := make(map[string]string)
+	authors := make([]string, 0)
+	title := ""
+	for _, f := range fields {
+		switch f.CitationPart {
+		case "author":
+			// MARC headings end in the punctuation before a date subfield: Smith, Ann,
+			authors = append(authors, strings.TrimRight(f.Value, " ,"))
+		case "title":
+			title = f.Value
+		case "published_date":
+			values["year"] = f.Value
+		case "publisher":
+			values["publisher"] = f.Value
+		case "serial_number":
+			if values["isbn"] == "" {
+				values["isbn"] = f.Value
+			}
+		case "language":
+			values["language"] = f.Value
+		case "url":
+			if values["url"] == "" {
+				values["url"] = f.Value
+			}
+		}
+	}
+	if len(authors) > 0 {
+		values["author"] = strings.Join(authors, " and ")
+	}
+	values["title"] = title
+	entryType, found := bibtexTypes[bib.Type.Code]
+	if found == false {
+		entryType = bibtexType{Entry: "misc"}
+	}
+	values["howpublished"] = entryType.HowPublished
+
+	var out strings.Builder
+	out.WriteString(fmt.Sprintf("@%s{%s,\n", entryType.Entry, bibtexKey(authors, values["year"], title, bib.ID)))
+	for _, name := range bibtexFieldOrder {
+		val := values[name]
+		if val == "" {
+			continue
+		}
+		if ascii {
+			val = asciiTransliterate(val)
+		}
+		if name != "url" {
+			val = escapeBibTeX(val)
+		}
+		out.WriteString(fmt.Sprintf("  %s = {%s},\n", name, val))
+	}
+	out.WriteString("}\n")
+	return out.String()
+}
+
+// getBibTeX returns a BibTeX entry for a JMRL record in the negotiated language. With
+// ascii=true, non-ASCII characters are transliterated.
+func (svc *ServiceContext) getBibTeX(c *gin.Context) {
+	id := c.Param("id")
+	log.Printf("BibTeX for resource %s requested", id)
+	resp, err := svc.apiGet(c.Request.Context(), svc.Sierra.BibURL(id, bibFields))
+	if err != nil {
+		sendError(c, err.StatusCode, err.errorCode(), err.Message)
+		return
+	}
+	jmrlBib := &JMRLBib{}
+	if respErr := json.Unmarshal(resp, jmrlBib); respErr != nil {
+		log.Printf("ERROR: Invalid response from JMRL API: %s", respErr.Error())
+		sendError(c, http.StatusInternalServerError, errUpstreamError, respErr.Error())
+		return
+	}
+	if jmrlBib.Deleted {
+		sendError(c, http.StatusNotFound, errRecordNotFound, fmt.Sprintf("record %s not found", id))
+		return
+	}
+	acceptLang := svc.negotiateLanguage(c.GetHeader("Accept-Language"))
+	fieldOpts := fieldOptions{View: viewFull, Language: acceptLang, Localizer: i18n.NewLocalizer(svc.I18NBundle, acceptLang)}
+	fields := svc.getResultFields(jmrlBib, fieldOpts)
+	entry := buildBibTeX(jmrlBib, fields, c.Query("ascii") == "true")
+	c.Header("Content-Language", acceptLang)
+	c.Data(http.StatusOK, "application/x-bibtex; charset=utf-8", []byte(entry))
+}
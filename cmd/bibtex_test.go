@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+func TestBibTeXKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		authors []string
+		year    string
+		title   string
+		id      string
+		want    string
+	}{
+		{"inverted name", []string{"Rowling, J. K."}, "1997", "Harry Potter and the philosopher's stone", "1001", "rowling1997harry"},
+		{"uninverted name", []string{"Jefferson-Madison Regional Library"}, "2001", "Annual report", "1001", "library2001annual"},
+		{"leading article", []string{"Burnett, Frances Hodgson"}, "1911", "The secret garden", "1001", "burnett1911secret"},
+		{"spanish article", []string{"García Márquez, Gabriel"}, "1967", "Los cien años de soledad", "1001", "garciamarquez1967cien"},
+		{"no author", nil, "2020", "Cats", "1001", "anon2020cats"},
+		{"blank author", []string{"  "}, "2020", "Cats", "1001", "anon2020cats"},
+		{"non-latin author", []string{"村上, 春樹"}, "1987", "Norwegian wood", "1001", "anon1987norwegian"},
+		{"no year", []string{"Smith, Ann"}, "", "Cats", "1001", "smithndcats"},
+		{"no title", []string{"Smith, Ann"}, "2020", "", "b1001", "smith2020b1001"},
+		{"only articles", []string{"Smith, Ann"}, "2020", "The", "1001", "smith20201001"},
+		{"nothing", nil, "", "", "1001", "anonnd1001"},
+	}
+	for _, tc := range tests {
+		if got := bibtexKey(tc.authors, tc.year, tc.title, tc.id); got != tc.want {
+			t.Errorf("%s: bibtexKey = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestEscapeBibTeX(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Cats & dogs", `Cats \& dogs`},
+		{"100% cotton", `100\% cotton`},
+		{"snake_case {braces}", `snake\_case \{braces\}`},
+		{"$5 #1 ~ ^", `\$5 \#1 \textasciitilde{} \textasciicircum{}`},
+		{`back\slash`, `back\textbackslash{}slash`},
+		{"  extra \n space ", "extra space"},
+	}
+	for _, tc := range tests {
+		if got := escapeBibTeX(tc.in); got != tc.want {
+			t.Errorf("escapeBibTeX(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// bibtexGoldenBibs are records covering each entry type and the citation key fallbacks
+func bibtexGoldenBibs() []JMRLBib {
+	book := testBib("1001", "Cats & dogs :")
+	book.VarFields[0] = marcField("100", "a", "Smith, Ann,", "d", "1950-")
+	book.VarFields[1] = marcField("245", "a", "Cats & dogs :", "b", "100% true stories /")
+	book.VarFields = append(book.VarFields, marcField("020", "a", "9780306406157"))
+
+	anonymous := testBib("1002", "The annual report")
+	anonymous.Author = ""
+	anonymous.VarFields = []JMRLVarFields{marcField("245", "a", "The annual report."), marcField("260", "b", "JMRL,")}
+	anonymous.PublishYear = 0
+
+	untitled := testBib("1003", "")
+	untitled.Author = ""
+	untitled.PublishYear = 0
+	untitled.VarFields = []JMRLVarFields{marcField("245", "a", "")}
+
+	ebook := testBib("1004", "Cien años de soledad")
+	ebook.Type = JMRLCodeValue{Code: "z", Value: "E-Book"}
+	ebook.Language = JMRLCodeValue{Code: "spa", Name: "Spanish"}
+	ebook.VarFields[0] = marcField("100", "a", "García Márquez, Gabriel,")
+	ebook.VarFields = append(ebook.VarFields, marcField("856", "u", "https://jmrl.overdrive.com/media/1004"))
+
+	audio := testBib("1005", "Symphony no. 5")
+	audio.Type = JMRLCodeValue{Code: "i", Value: "Audio CD"}
+	audio.VarFields[0] = marcField("110", "a", "Berliner Philharmoniker.")
+
+	return []JMRLBib{book, anonymous, untitled, ebook, audio}
+}
+
+func TestBuildBibTeXGolden(t *testing.T) {
+	svc := newTestService(t, nil)
+	for _, ascii := range []bool{false, true} {
+		var out strings.Builder
+		for _, bib := range bibtexGoldenBibs() {
+			bib := bib
+			fields := svc.getResultFields(&bib, fieldOptions{View: viewFull, Language: "en-US", Localizer: testLocalizer(svc, "en-US")})
+			out.WriteString(buildBibTeX(&bib, fields, ascii))
+			out.WriteString("\n")
+		}
+		file := "bibtex.golden.bib"
+		if ascii {
+			file = "bibtex_ascii.golden.bib"
+		}
+		checkGoldenFile(t, file, []byte(out.String()))
+	}
+}
+
+// the entry is built with the negotiated localizer and says which language it is in
+func TestGetBibTeXUsesNegotiatedLanguage(t *testing.T) {
+	sierra := newFakeSierra(t)
+	bib := testBib("1001", "Cien años de soledad")
+	sierra.handleJSON("bibs/1001", http.StatusOK, bib)
+	svc := newTestService(t, sierra)
+	router := gin.New()
+	router.GET("/api/resource/:id/bibtex", svc.authMiddleware, svc.getBibTeX)
+
+	tests := []struct {
+		lang     string
+		wantLang string
+	}{
+		{"es", "es"},
+		{"es-MX,es;q=0.9", "es"},
+		{"en-US", "en"},
+		{"fr", "en-US"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/resource/1001/bibtex", nil)
+		req.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.User))
+		req.Header.Set("Accept-Language", tc.lang)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tc.lang, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Language"); got != tc.wantLang {
+			t.Errorf("%s: Content-Language = %q, want %q", tc.lang, got, tc.wantLang)
+		}
+		if strings.HasPrefix(rec.Body.String(), "@book{author2020cien,\n") == false {
+			t.Errorf("%s: unexpected entry:\n%s", tc.lang, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// branchRefreshInterval is how often the Sierra branch list is re-fetched
+const branchRefreshInterval = 24 * time.Hour
+
+// sierraBranchList is the Sierra branches endpoint response
+type sierraBranchList struct {
+	Total   int            `json:"total"`
+	Entries []sierraBranch `json:"entries"`
+}
+
+// sierraBranch is a single Sierra branch and the locations that belong to it
+type sierraBranch struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Locations []JMRLCodeValue `json:"locations,omitempty"`
+}
+
+// branchDirectory is the authoritative branch and location list fetched from Sierra.
+// Until a fetch succeeds it is empty and location names come from the bib payload.
+type branchDirectory struct {
+	lock      sync.RWMutex
+	branches  []sierraBranch
+	names     map[string]string
+	fetchedAt time.Time
+}
+
+// set replaces the branch list and rebuilds the location code to name index
+func (bd *branchDirectory) set(branches []sierraBranch, now time.Time) {
+	names := make(map[string]string)
+	for _, b := range branches {
+		for _, loc := range b.Locations {
+			code := strings.ToLower(strings.TrimSpace(loc.Code))
+			if code != "" && strings.TrimSpace(loc.Name) != "" {
+				names[code] = strings.TrimSpace(loc.Name)
+			}
+		}
+	}
+	bd.lock.Lock()
+	defer bd.lock.Unlock()
+	bd.branches = branches
+	bd.names = names
+	bd.fetchedAt = now
+}
+
+// name returns the canonical Sierra name of a location code, if known
+func (bd *branchDirectory) name(code string) (string, bool) {
+	bd.lock.RLock()
+	defer bd.lock.RUnlock()
+	name, found := bd.names[strings.ToLower(strings.TrimSpace(code))]
+	return name, found
+}
+
+// list returns the branches and the time they were fetched. The list is empty if
+// Sierra has not been reached yet.
+func (bd *branchDirectory) list() ([]sierraBranch, time.Time) {
+	bd.lock.RLock()
+	defer bd.lock.RUnlock()
+	return bd.branches, bd.fetchedAt
+}
+
+// unknownCodes returns the location codes that Sierra does not know. Prefix codes match
+// any location they begin, since branch codes prefix their location codes. Nothing is
+// reported until the directory has been fetched.
+func (bd *branchDirectory) unknownCodes(codes []string, prefix bool) []string {
+	bd.lock.RLock()
+	defer bd.lock.RUnlock()
+	out := make([]string, 0)
+	if len(bd.names) == 0 {
+		return out
+	}
+	for _, code := range codes {
+		code = strings.ToLower(strings.TrimSpace(code))
+		if code == "" || bd.names[code] != "" {
+			continue
+		}
+		known := false
+		if prefix {
+			for locCode := range bd.names {
+				if strings.HasPrefix(locCode, code) {
+					known = true
+					break
+				}
+			}
+		}
+		if known == false {
+			out = append(out, code)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// refreshBranches fetches the Sierra branch list and checks the configured location
+// codes against it. A failure leaves the previous list in place.
+func (svc *ServiceContext) refreshBranches(ctx context.Context, now time.Time) error {
+	params := url.Values{}
+	params.Set("fields", "id,name,locations")
+	params.Set("limit", "500")
+	resp, err := svc.apiGet(ctx, svc.Sierra.BranchesURL(params))
+	if err != nil {
+		return fmt.Errorf("branch list request failed: %s", err.Message)
+	}
+	var branchList sierraBranchList
+	if jsonErr := json.Unmarshal(resp, &branchList); jsonErr != nil {
+		return fmt.Errorf("unable to parse branch list: %s", jsonErr.Error())
+	}
+	if len(branchList.Entries) == 0 {
+		return fmt.Errorf("branch list is empty")
+	}
+	svc.Branches.set(branchList.Entries, now.UTC())
+	svc.setStaticModified()
+	log.Printf("Branch list refreshed: %d branches", len(branchList.Entries))
+	svc.checkLocationCodes()
+	return nil
+}
+
+// checkLocationCodes warns about configured location codes that Sierra does not know
+func (svc *ServiceContext) checkLocationCodes() {
+	redactedSet, branchGeo := svc.Locations.get()
+	redacted := make([]string, 0, len(redactedSet))
+	for code := range redactedSet {
+		redacted = append(redacted, code)
+	}
+	for _, code := range svc.Branches.unknownCodes(redacted, false) {
+		log.Printf("WARNING: redacted location code %s is not a Sierra location", code)
+	}
+	geoCodes := make([]string, 0, len(branchGeo))
+	for _, b := range branchGeo {
+		geoCodes = append(geoCodes, b.Code)
+	}
+	for _, code := range svc.Branches.unknownCodes(geoCodes, true) {
+		log.Printf("WARNING: branch location code %s does not match any Sierra location", code)
+	}
+}
+
+// runBranchRefresh re-fetches the branch list in the background forever
+func (svc *ServiceContext) runBranchRefresh(interval time.Duration) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for {
+		time.Sleep(statsDelay(interval, rnd))
+		if err := svc.refreshBranches(context.Background(), time.Now()); err != nil {
+			log.Printf("WARNING: unable to refresh branch list: %s", err.Error())
+			svc.Metrics.Increment("branch_refresh_failure")
+		}
+	}
+}
+
+// branchesHandler returns the Sierra branches and their locations for branch pickers.
+// Redacted locations are reported as the generic outreach location, as is a branch
+// whose locations are all redacted.
+func (svc *ServiceContext) branchesHandler(c *gin.Context) {
+	branches, fetchedAt := svc.Branches.list()
+	if len(branches) == 0 {
+		sendError(c, http.StatusServiceUnavailable, errUpstreamUnavailable, "the JMRL branch list is not available")
+		return
+	}
+	out := make([]sierraBranch, 0, len(branches))
+	for _, b := range branches {
+		name := b.Name
+		if svc.allLocationsRedacted(b.Locations) {
+			name = redactedLocationName
+		}
+		out = append(out, sierraBranch{ID: b.ID, Name: name, Locations: svc.normalizeLocations(b.Locations)})
+	}
+	c.JSON(http.StatusOK, gin.H{"branches": out, "fetched_at": fetchedAt.Format(time.RFC3339)})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// testBranches are the Sierra branches of the branch tests
+var testBranches = []sierraBranch{
+	{ID: "1", Name: "Central Library", Locations: []JMRLCodeValue{{Code: "cnf", Name: "Central Nonfiction"}, {Code: "cyp", Name: "Central Young People"}}},
+	{ID: "2", Name: "Gordon Avenue Library", Locations: []JMRLCodeValue{{Code: "gaf", Name: "Gordon Avenue Fiction"}}},
+}
+
+func TestBranchDirectory(t *testing.T) {
+	var bd branchDirectory
+	if got := bd.unknownCodes([]string{"zzz"}, false); len(got) != 0 {
+		t.Errorf("unknown codes reported before the first fetch: %v", got)
+	}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	bd.set(testBranches, now)
+	if name, found := bd.name(" CNF "); found == false || name != "Central Nonfiction" {
+		t.Errorf("name(CNF) = %q %t", name, found)
+	}
+	if branches, fetched := bd.list(); len(branches) != 2 || fetched.Equal(now) == false {
+		t.Errorf("list = %d branches fetched %s", len(branches), fetched)
+	}
+	tests := []struct {
+		codes  []string
+		prefix bool
+		want   []string
+	}{
+		{[]string{"cnf", "gaf"}, false, []string{}},
+		{[]string{"c", "ga", "zz"}, true, []string{"zz"}},
+		{[]string{"c", "zz", "ab"}, false, []string{"ab", "c", "zz"}},
+	}
+	for _, tc := range tests {
+		if got := bd.unknownCodes(tc.codes, tc.prefix); reflect.DeepEqual(got, tc.want) == false {
+			t.Errorf("unknownCodes(%v, %t) = %v, want %v", tc.codes, tc.prefix, got, tc.want)
+		}
+	}
+}
+
+func TestRefreshBranches(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("branches", http.StatusOK, sierraBranchList{Total: 2, Entries: testBranches})
+	svc := newTestService(t, sierra)
+	router := newRouter(svc)
+
+	if rec := apiRequest(t, router, http.MethodGet, "/api/branches", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("branches before the first fetch = %d, want 503", rec.Code)
+	}
+	if err := svc.refreshBranches(context.Background(), time.Now()); err != nil {
+		t.Fatalf("refreshBranches failed: %s", err.Error())
+	}
+	// an empty list leaves the previous one in place
+	sierra.handleJSON("branches", http.StatusOK, sierraBranchList{})
+	if err := svc.refreshBranches(context.Background(), time.Now()); err == nil {
+		t.Errorf("refreshBranches accepted an empty branch list")
+	}
+	rec := apiRequest(t, router, http.MethodGet, "/api/branches", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("branches = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Branches []sierraBranch `json:"branches"`
+	}
+	decodeTestJSON(t, rec, &resp)
+	if len(resp.Branches) != 2 || resp.Branches[1].Name != "Gordon Avenue Library" {
+		t.Errorf("branches = %+v", resp.Branches)
+	}
+}
+
+func TestBranchFilterSearch(t *testing.T) {
+	sierra := newFakeSierra(t)
+	bib := testBib("1001", "Cats")
+	bib.Locations = []JMRLCodeValue{{Code: "gaf", Name: "GORDON AVE FIC"}}
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, bib))
+	svc := newTestService(t, sierra)
+	svc.Locations.set(make(map[string]bool), []BranchGeo{{Code: "c", Name: "Central Library"}, {Code: "ga", Name: "Gordon Avenue Library"}})
+	svc.Branches.set(testBranches, time.Now())
+	router := newRouter(svc)
+
+	body := `{"query":"keyword: {cats}","filters":[{"pool_id":"jmrl","facets":[` +
+		`{"facet_id":"FilterLibrary","value":"Central Library"},{"facet_id":"FilterLibrary","value":"gordon avenue library"}]}]}`
+	rec := apiRequest(t, router, http.MethodPost, "/api/search", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	urls := sierra.requestURLs()
+	sent, _ := url.Parse(urls[len(urls)-1])
+	if got := sent.Query().Get("text"); got != "((cats)) AND (l:c* OR l:ga*)" {
+		t.Errorf("Sierra text = %s", got)
+	}
+	var resp v4api.PoolResult
+	decodeTestJSON(t, rec, &resp)
+	if len(resp.Groups) != 1 {
+		t.Fatalf("%d groups, want 1", len(resp.Groups))
+	}
+	want := []string{"Jefferson-Madison Regional Library - Gordon Avenue Fiction"}
+	if got := fieldValues(resp.Groups[0].Records[0].Fields, "location"); reflect.DeepEqual(got, want) == false {
+		t.Errorf("location = %q, want %q", got, want)
+	}
+
+	facet := svc.branchFacet(testFilters(filterLibrary, "Gordon Avenue Library"), map[string]int{"c": 4}, testLocalizer(svc, "en-US"))
+	wantBuckets := []v4api.FacetBucket{{Value: "Central Library", Count: 4}, {Value: "Gordon Avenue Library", Selected: true}}
+	if reflect.DeepEqual(facet.Buckets, wantBuckets) == false {
+		t.Errorf("library facet = %+v, want %+v", facet.Buckets, wantBuckets)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// browseDays is how far back an empty query looks for recently added titles
+const browseDays = 90
+
+// browse count settings: how long a recent additions count is reused and how many are kept
+const browseCountTTL = 15 * time.Minute
+const browseCountCacheMax = 8
+
+// JMRLBibList is the response from the Sierra bibs list endpoint. Unlike search, the
+// entries are bare bibs with no relevance, and the total is the number of entries in
+// this response rather than the number of matching bibs.
+type JMRLBibList struct {
+	Total   int       `json:"total"`
+	Start   int       `json:"start"`
+	Entries []JMRLBib `json:"entries"`
+}
+
+// recentBibs returns a page of the bibs created since the supplied time. This is used in
+// place of a wildcard search for empty (browse) queries.
+func (svc *ServiceContext) recentBibs(ctx context.Context, start int, rows int, since time.Time, trace *requestTrace) (*JMRLResult, *RequestError) {
+	sinceDate := since.UTC().Format("2006-01-02T00:00:00Z")
+	// pages are at most maxSearchRows, well within the list limit, so one request is enough
+	params := recentBibsParams(sinceDate)
+	params.Set("offset", fmt.Sprintf("%d", start))
+	params.Set("limit", fmt.Sprintf("%d", rows))
+	params.Set("fields", bibFields)
+	trace.decision("recent additions browse")
+	list, err := svc.listBibs(ctx, params, trace)
+	if err != nil {
+		return nil, err
+	}
+	trace.returned(len(list.Entries))
+	out := &JMRLResult{Start: start, Count: len(list.Entries), Entries: make([]JMRLEntry, 0, len(list.Entries))}
+	for _, bib := range list.Entries {
+		out.Entries = append(out.Entries, JMRLEntry{Bib: bib})
+	}
+
+	// a short page ends the set; otherwise the list total is only the page size
+	if len(list.Entries) < rows && (len(list.Entries) > 0 || start == 0) {
+		out.Total = start + len(list.Entries)
+		return out, nil
+	}
+	total, err := svc.countRecentBibs(ctx, sinceDate, trace)
+	if err != nil {
+		return nil, err
+	}
+	out.Total = total
+	return out, nil
+}
+
+// recentBibsParams returns the bibs list params for the bibs created since the date
+func recentBibsParams(sinceDate string) url.Values {
+	params := url.Values{}
+	params.Set("createdDate", createdDateRange(sinceDate))
+	params.Set("deleted", "false")
+	params.Set("suppressed", "false")
+	return params
+}
+
+// createdDateRange returns the open ended Sierra createdDate range starting at the date
+func createdDateRange(sinceDate string) string {
+	return fmt.Sprintf("[%s,]", sinceDate)
+}
+
+// countRecentBibs returns the number of bibs created since the date. The list endpoint
+// has no count, so the IDs are listed in pages of the largest list limit. Counts are
+// reused for browseCountTTL since every browse page needs the same one.
+func (svc *ServiceContext) countRecentBibs(ctx context.Context, sinceDate string, trace *requestTrace) (int, *RequestError) {
+	now := time.Now()
+	key := cacheKey{Kind: "browse_count", Subject: sinceDate}.String()
+	if cached, found := svc.BrowseCounts.get(key, now); found {
+		trace.decision("recent additions count cached")
+		return cached.(int), nil
+	}
+	trace.decision("recent additions count")
+	params := recentBibsParams(sinceDate)
+	params.Set("fields", "id")
+	params.Set("limit", fmt.Sprintf("%d", sierraMaxListLimit))
+	total := 0
+	for {
+		params.Set("offset", fmt.Sprintf("%d", total))
+		list, err := svc.listBibs(ctx, params, trace)
+		if err != nil {
+			return 0, err
+		}
+		total += len(list.Entries)
+		if len(list.Entries) < sierraMaxListLimit {
+			break
+		}
+	}
+	svc.BrowseCounts.put(key, total, newCacheSource(now, svc.Sierra.BibsURL(params)), now)
+	return total, nil
+}
+
+// listBibs requests a page of the Sierra bibs list
+func (svc *ServiceContext) listBibs(ctx context.Context, params url.Values, trace *requestTrace) (*JMRLBibList, *RequestError) {
+	resp, err := svc.tracedAPIGet(ctx, svc.Sierra.BibsURL(params), trace)
+	if err != nil {
+		return nil, err
+	}
+	list := &JMRLBibList{}
+	if respErr := json.Unmarshal(resp, list); respErr != nil {
+		log.Printf("ERROR: Invalid response from JMRL API: %s", respErr.Error())
+		return nil, &RequestError{StatusCode: http.StatusInternalServerError, Message: respErr.Error()}
+	}
+	return list, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// serveRecentBibs answers bibs list requests for a set of recent additions, with ID only
+// pages for counts. Like Sierra, the list total is the number of entries returned.
+func serveRecentBibs(sierra *fakeSierra, total int) {
+	sierra.handle("bibs", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		list := JMRLBibList{Start: offset, Entries: make([]JMRLBib, 0)}
+		for idx := offset; idx < total && idx < offset+limit; idx++ {
+			id := fmt.Sprintf("%d", 1000000+idx)
+			if q.Get("fields") == "id" {
+				list.Entries = append(list.Entries, JMRLBib{ID: id})
+			} else {
+				list.Entries = append(list.Entries, testBib(id, "Recent "+id))
+			}
+		}
+		list.Total = len(list.Entries)
+		writeTestJSON(w, http.StatusOK, list)
+	})
+}
+
+// countRequests returns the number of ID only list requests made for counts
+func countRequests(sierra *fakeSierra) int {
+	n := 0
+	for _, raw := range sierra.requestURLs() {
+		if u, err := url.Parse(raw); err == nil && u.Query().Get("fields") == "id" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRecentBrowseTotal(t *testing.T) {
+	tests := []struct {
+		name   string
+		total  int
+		start  int
+		rows   int
+		want   v4api.Pagination
+		counts int
+	}{
+		{"short first page", 12, 0, 20, v4api.Pagination{Start: 0, Rows: 12, Total: 12}, 0},
+		{"short last page", 45, 40, 20, v4api.Pagination{Start: 40, Rows: 5, Total: 45}, 0},
+		{"full page", 4500, 20, 20, v4api.Pagination{Start: 20, Rows: 20, Total: 4500}, 3},
+		{"exact list limit", 2000, 0, 20, v4api.Pagination{Start: 0, Rows: 20, Total: 2000}, 2},
+		// pages larger than the search limit are not capped
+		{"large page", 300, 0, 120, v4api.Pagination{Start: 0, Rows: 120, Total: 300}, 1},
+		{"past the end", 30, 60, 20, v4api.Pagination{Start: 60, Rows: 0, Total: 30}, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sierra := newFakeSierra(t)
+			serveRecentBibs(sierra, tc.total)
+			router := newRouter(newTestService(t, sierra))
+			body := fmt.Sprintf(`{"query":"keyword: {}","pagination":{"start":%d,"rows":%d}}`, tc.start, tc.rows)
+			rec := apiRequest(t, router, http.MethodPost, "/api/search", body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var resp v4api.PoolResult
+			decodeTestJSON(t, rec, &resp)
+			if resp.Pagination != tc.want {
+				t.Errorf("pagination = %+v, want %+v", resp.Pagination, tc.want)
+			}
+			if n := countRequests(sierra); n != tc.counts {
+				t.Errorf("%d count requests, want %d", n, tc.counts)
+			}
+		})
+	}
+}
+
+// every page of a browse needs the same count; it is only listed once
+func TestRecentBrowseCountIsCached(t *testing.T) {
+	sierra := newFakeSierra(t)
+	serveRecentBibs(sierra, 250)
+	router := newRouter(newTestService(t, sierra))
+	for _, start := range []int{0, 20, 40} {
+		body := fmt.Sprintf(`{"query":"keyword: {}","pagination":{"start":%d,"rows":20}}`, start)
+		var resp v4api.PoolResult
+		decodeTestJSON(t, apiRequest(t, router, http.MethodPost, "/api/search", body), &resp)
+		if resp.Pagination.Total != 250 {
+			t.Errorf("start %d total = %d, want 250", start, resp.Pagination.Total)
+		}
+	}
+	if n := countRequests(sierra); n != 1 {
+		t.Errorf("%d count requests, want 1", n)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchTimeoutHeader is the header the Virgo master search uses to pass the time it
+// will wait for this pool, in milliseconds
+const searchTimeoutHeader = "X-Search-Timeout-Ms"
+
+// requestBudget returns the time budget of a request: timeout_ms from the search request
+// if set, otherwise the X-Search-Timeout-Ms header. Zero means no budget. Invalid header
+// values are logged and ignored.
+func requestBudget(header http.Header, timeoutMS int) time.Duration {
+	if timeoutMS > 0 {
+		return time.Duration(timeoutMS) * time.Millisecond
+	}
+	hdr := strings.TrimSpace(header.Get(searchTimeoutHeader))
+	if hdr == "" {
+		return 0
+	}
+	ms, err := strconv.Atoi(hdr)
+	if err != nil || ms <= 0 {
+		log.Printf("WARNING: ignoring invalid %s header [%s]", searchTimeoutHeader, hdr)
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// budgetContext returns a context derived from the request context that expires when the
+// budget is spent. Without a budget the context only ends with the request.
+func budgetContext(ctx context.Context, budget time.Duration) (context.Context, context.CancelFunc) {
+	if budget <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, budget)
+}
+
+// budgetError returns the error for a Sierra request that could not be made or finished
+// because the request time budget was spent
+func budgetError(tgtURL string) *RequestError {
+	return &RequestError{StatusCode: http.StatusRequestTimeout, Code: errTimeout,
+		Message: fmt.Sprintf("%s exceeded the request time budget", tgtURL)}
+}
+
+// isBudgetExceeded returns true if the error is from a spent request time budget
+func isBudgetExceeded(err *RequestError) bool {
+	return err != nil && err.StatusCode == http.StatusRequestTimeout && err.Code == errTimeout
+}
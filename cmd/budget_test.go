@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+func TestRequestBudget(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		timeoutMS int
+		want      time.Duration
+	}{
+		{"none", "", 0, 0},
+		{"header", "250", 0, 250 * time.Millisecond},
+		{"request field wins", "250", 100, 100 * time.Millisecond},
+		{"padded header", " 75 ", 0, 75 * time.Millisecond},
+		{"invalid header", "soon", 0, 0},
+		{"negative header", "-5", 0, 0},
+		{"zero header", "0", 0, 0},
+	}
+	for _, tc := range tests {
+		header := http.Header{}
+		if tc.header != "" {
+			header.Set(searchTimeoutHeader, tc.header)
+		}
+		if got := requestBudget(header, tc.timeoutMS); got != tc.want {
+			t.Errorf("%s: requestBudget = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+// slowHandler answers after the delay, or gives up when the client does
+func slowHandler(delay time.Duration, body interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+			writeTestJSON(w, http.StatusOK, body)
+		case <-r.Context().Done():
+		}
+	}
+}
+
+// a search that outlives its budget returns promptly with a 408 and a warning, whether
+// the budget comes from the request body or the header
+func TestSearchBudgetExceeded(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handle("bibs/search", slowHandler(2*time.Second, searchResult(1, testBib("1001", "Cats"))))
+	svc := newTestService(t, sierra)
+	router := newRouter(svc)
+
+	tests := []struct {
+		name   string
+		body   string
+		header string
+		warn   string
+	}{
+		{"request field", `{"query":"keyword: {cats}","timeout_ms":100}`, "", "JMRL did not respond within the 100ms search time budget"},
+		{"header", `{"query":"keyword: {cats}"}`, "80", "JMRL did not respond within the 80ms search time budget"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/api/search", strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.User))
+		if tc.header != "" {
+			req.Header.Set(searchTimeoutHeader, tc.header)
+		}
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		router.ServeHTTP(rec, req)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: search took %s, past its budget", tc.name, elapsed)
+		}
+		if rec.Code != http.StatusRequestTimeout {
+			t.Fatalf("%s: status = %d, want 408: %s", tc.name, rec.Code, rec.Body.String())
+		}
+		var resp poolErrorResult
+		decodeTestJSON(t, rec, &resp)
+		if resp.ErrorCode != errTimeout || containsString(resp.Warnings, tc.warn) == false {
+			t.Errorf("%s: error code %q warnings %q, want %q and %q", tc.name, resp.ErrorCode, resp.Warnings, errTimeout, tc.warn)
+		}
+	}
+	if got := svc.Metrics.Snapshot()["search_budget_exceeded"]; got != 2 {
+		t.Errorf("search_budget_exceeded = %v, want 2", got)
+	}
+}
+
+// a multi page fetch returns the pages retrieved before the budget ran out
+func TestPagedSearchBudgetPartial(t *testing.T) {
+	sierra := newFakeSierra(t)
+	bibs := make([]JMRLBib, 0, sierraMaxLimit)
+	for i := 0; i < sierraMaxLimit; i++ {
+		bibs = append(bibs, testBib("1001", "Cats"))
+	}
+	sierra.handle("bibs/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("offset") == "0" {
+			writeTestJSON(w, http.StatusOK, searchResult(500, bibs...))
+			return
+		}
+		slowHandler(2*time.Second, searchResult(500))(w, r)
+	})
+	svc := newTestService(t, sierra)
+	ctx, cancel := budgetContext(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	res, warnings, err := svc.pagedSearch(ctx, url.Values{"text": {"(cats)"}}, 0, 80, newRequestTrace(false))
+	if err != nil {
+		t.Fatalf("pagedSearch failed: %s", err.Message)
+	}
+	if len(res.Entries) != sierraMaxLimit || res.Total != 500 {
+		t.Errorf("%d entries of %d, want %d of 500", len(res.Entries), res.Total, sierraMaxLimit)
+	}
+	want := "Only 50 of the requested 80 results could be retrieved within the search time budget"
+	if containsString(warnings, want) == false {
+		t.Errorf("warnings = %q, want %q", warnings, want)
+	}
+}
+
+// the first page failing the budget is an error, not an empty result
+func TestPagedSearchBudgetFirstPage(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handle("bibs/search", slowHandler(2*time.Second, searchResult(0)))
+	svc := newTestService(t, sierra)
+	ctx, cancel := budgetContext(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := svc.pagedSearch(ctx, url.Values{"text": {"(cats)"}}, 0, 20, newRequestTrace(false)); isBudgetExceeded(err) == false {
+		t.Errorf("pagedSearch error = %v, want a budget error", err)
+	}
+}
+
+// suggestions honor the header budget too
+func TestSuggestBudget(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handle("bibs/search", slowHandler(2*time.Second, searchResult(0)))
+	router := newRouter(newTestService(t, sierra))
+	req := httptest.NewRequest(http.MethodGet, "/api/suggest?q=cats", nil)
+	req.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.User))
+	req.Header.Set(searchTimeoutHeader, "50")
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	router.ServeHTTP(rec, req)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("suggest took %s, past its budget", elapsed)
+	}
+	if rec.Code != http.StatusRequestTimeout {
+		t.Errorf("status = %d, want 408: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// a client that goes away ends the Sierra requests of its record lookup
+func TestResourceEndsWithRequest(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handle("bibs/1001", slowHandler(2*time.Second, testBib("1001", "Cats")))
+	router := newRouter(newTestService(t, sierra))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/api/resource/1001", nil).WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.User))
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	router.ServeHTTP(rec, req)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("resource took %s after the client went away", elapsed)
+	}
+	if rec.Code == http.StatusOK {
+		t.Errorf("status = %d, want a failure", rec.Code)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// cacheEntryOverhead approximates the bytes used by an entry beyond its key, value and source
+const cacheEntryOverhead = 64
+
+type cacheEntry struct {
+	stored  time.Time
+	expires time.Time
+	value   interface{}
+	source  cacheSource
+	bytes   int
+}
+
+// ttlCache is a small in-memory cache of values that expire after a fixed lifetime
+type ttlCache struct {
+	lock       sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]cacheEntry
+	bytes      int
+}
+
+// cacheStats is the size of a cache for the admin status
+type cacheStats struct {
+	Entries int `json:"entries"`
+	Bytes   int `json:"approx_bytes"`
+}
+
+func newTTLCache(ttl time.Duration, maxEntries int) *ttlCache {
+	return &ttlCache{ttl: ttl, maxEntries: maxEntries, entries: make(map[string]cacheEntry)}
+}
+
+// get returns the unexpired value cached for the key
+func (tc *ttlCache) get(key string, now time.Time) (interface{}, bool) {
+	value, _, found := tc.lookup(key, now)
+	return value, found
+}
+
+// lookup returns the unexpired value cached for the key and how long ago it was cached
+func (tc *ttlCache) lookup(key string, now time.Time) (interface{}, time.Duration, bool) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	entry, found := tc.entries[key]
+	if found == false || now.After(entry.expires) {
+		return nil, 0, false
+	}
+	return entry.value, now.Sub(entry.stored), true
+}
+
+// put caches a value for the key along with the Sierra request that produced it. When
+// the cache is full, expired entries are dropped; if it is still full everything is dropped.
+func (tc *ttlCache) put(key string, value interface{}, source cacheSource, now time.Time) {
+	// encoding the value for its size is done before the lock is taken
+	size := approxEntryBytes(key, value, source)
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	if len(tc.entries) >= tc.maxEntries {
+		for k, entry := range tc.entries {
+			if now.After(entry.expires) {
+				tc.bytes -= entry.bytes
+				delete(tc.entries, k)
+			}
+		}
+		if len(tc.entries) >= tc.maxEntries {
+			tc.entries = make(map[string]cacheEntry)
+			tc.bytes = 0
+		}
+	}
+	if prior, found := tc.entries[key]; found {
+		tc.bytes -= prior.bytes
+	}
+	entry := cacheEntry{stored: now, expires: now.Add(tc.ttl), value: value, source: source, bytes: size}
+	tc.bytes += entry.bytes
+	tc.entries[key] = entry
+}
+
+// inspect returns the unexpired entry whose key has the digest, and its key
+func (tc *ttlCache) inspect(digest string, now time.Time) (string, cacheEntry, bool) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	for key, entry := range tc.entries {
+		if cacheDigest(key) == digest && now.Before(entry.expires) {
+			return key, entry, true
+		}
+	}
+	return "", cacheEntry{}, false
+}
+
+// stats returns the number of entries and their approximate size
+func (tc *ttlCache) stats() cacheStats {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	return cacheStats{Entries: len(tc.entries), Bytes: tc.bytes}
+}
+
+// approxEntryBytes estimates the memory used by an entry from the size of its key, its
+// value and its source metadata. Raw Sierra responses are counted as is; other values by
+// their JSON encoding, which would base64 encode a []byte.
+func approxEntryBytes(key string, value interface{}, source cacheSource) int {
+	size := cacheEntryOverhead + len(key) + source.bytes()
+	if raw, ok := value.([]byte); ok {
+		return size + len(raw)
+	}
+	if body, err := json.Marshal(value); err == nil {
+		size += len(body)
+	}
+	return size
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheKey is the single construction for every service cache key. Anything that changes
+// the cached payload must be part of the key: the resource ID or query digest, the
+// resolved locale, the view/projection and whether staff only data may be included.
+type cacheKey struct {
+	Kind    string
+	Subject string
+	Locale  string
+	View    string
+	Staff   bool
+}
+
+// String returns the key. The subject is digested so arbitrary query text can't collide
+// with the separators.
+func (k cacheKey) String() string {
+	return fmt.Sprintf("%s|%s|%s|%t|%x", k.Kind, k.Locale, k.View, k.Staff, sha256.Sum256([]byte(k.Subject)))
+}
+
+// requestCacheKey builds the cache key for a request. The locale is the language the
+// response is localized in, so requests for es-MX and es share cache entries.
+func (svc *ServiceContext) requestCacheKey(c *gin.Context, kind string, subject string, view string) string {
+	return cacheKey{Kind: kind, Subject: subject, Locale: svc.negotiateLanguage(c.GetHeader("Accept-Language")),
+		View: view, Staff: isStaff(c)}.String()
+}
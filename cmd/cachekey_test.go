@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/uvalib/virgo4-api/v4api"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+func TestCacheKeyParts(t *testing.T) {
+	base := cacheKey{Kind: "feed", Subject: "cats|false|25", Locale: "en", View: viewBrief}
+	if base.String() != base.String() {
+		t.Fatalf("cache key is not deterministic")
+	}
+	variants := map[string]cacheKey{
+		"kind":    {Kind: "suggest", Subject: base.Subject, Locale: base.Locale, View: base.View},
+		"subject": {Kind: base.Kind, Subject: "dogs|false|25", Locale: base.Locale, View: base.View},
+		"locale":  {Kind: base.Kind, Subject: base.Subject, Locale: "es", View: base.View},
+		"view":    {Kind: base.Kind, Subject: base.Subject, Locale: base.Locale, View: viewFull},
+		"staff":   {Kind: base.Kind, Subject: base.Subject, Locale: base.Locale, View: base.View, Staff: true},
+		// subjects are digested, so separators in them can't shift the other parts
+		"separator": {Kind: base.Kind, Subject: "cats|false|25|es", Locale: base.Locale, View: base.View},
+	}
+	for name, variant := range variants {
+		if variant.String() == base.String() {
+			t.Errorf("keys differing by %s are equal: %s", name, base.String())
+		}
+	}
+}
+
+func TestRequestCacheKeyLocale(t *testing.T) {
+	svc := newTestService(t, nil)
+	keyFor := func(acceptLang string) string {
+		c, _ := newTestContext(http.MethodGet, "/api/suggest?q=cats", nil)
+		if acceptLang != "" {
+			c.Request.Header.Set("Accept-Language", acceptLang)
+		}
+		return svc.requestCacheKey(c, "suggest", "cats", viewBrief)
+	}
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"es", "es-MX", true},
+		{"es-MX,es;q=0.9", "es", true},
+		{"fr-CH, fr;q=0.9, es;q=0.8", "es", true},
+		{"en", "en-GB", true},
+		{"es", "en", false},
+		{"fr", "", true},
+		{"not a language!", "", true},
+	}
+	for _, tc := range tests {
+		if got := keyFor(tc.a) == keyFor(tc.b); got != tc.same {
+			t.Errorf("keys for %q and %q equal = %t, want %t", tc.a, tc.b, got, tc.same)
+		}
+	}
+}
+
+// warm caches must never answer a request with an entry made for another locale or role
+func TestCachesDoNotCrossLocales(t *testing.T) {
+	sierra := newFakeSierra(t)
+	brief := JMRLBib{ID: "1001", Title: "Cats"}
+	sierra.handleJSON("bibs", http.StatusOK, JMRLBibList{Total: 1, Entries: []JMRLBib{brief}})
+	cfg := newTestConfig(sierra.apiURL())
+	cfg.TrendingHours = 24
+	svc := newTestServiceWithConfig(t, cfg)
+	svc.Trending.record("1001", time.Now())
+	router := newRouter(svc)
+
+	english := "Brief record — details unavailable"
+	spanish := "Registro breve — detalles no disponibles"
+	tests := []struct {
+		acceptLang string
+		role       v4jwt.RoleEnum
+		want       string
+		fetches    int
+	}{
+		{"es", v4jwt.User, spanish, 1},
+		{"en", v4jwt.User, english, 2},
+		{"es-MX", v4jwt.User, spanish, 2},
+		{"en-US", v4jwt.User, english, 2},
+		{"es", v4jwt.Staff, spanish, 3},
+		{"es;q=0.5, en", v4jwt.User, english, 3},
+		{"es", v4jwt.User, spanish, 3},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/trending", nil)
+		req.Header.Set("Authorization", "Bearer "+mintTestToken(t, tc.role))
+		req.Header.Set("Accept-Language", tc.acceptLang)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var got []v4api.Record
+		decodeTestJSON(t, rec, &got)
+		if len(got) != 1 {
+			t.Fatalf("%s: %d trending records, want 1", tc.acceptLang, len(got))
+		}
+		if note := fieldValues(got[0].Fields, "brief_record_note"); len(note) != 1 || note[0] != tc.want {
+			t.Errorf("%s %v: note = %q, want %q", tc.acceptLang, tc.role, note, tc.want)
+		}
+		if n := sierra.count("bibs"); n != tc.fetches {
+			t.Errorf("%s %v: %d Sierra fetches, want %d", tc.acceptLang, tc.role, n, tc.fetches)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheKeyHeader reports the digest of the cache key that served a cached endpoint, for
+// use with the admin cache inspection endpoint
+const cacheKeyHeader = "X-Cache-Key"
+
+// cacheSource is the Sierra requests that produced a cached value, in the order they were
+// made. The URLs never contain credentials; Sierra requests authenticate with a header.
+type cacheSource struct {
+	URLs      []string  `json:"upstream_urls"`
+	FetchedAt time.Time `json:"sierra_response_at"`
+}
+
+// newCacheSource records the Sierra request URLs, with any user info elided, and the time
+// the last response was received
+func newCacheSource(fetchedAt time.Time, rawURLs ...string) cacheSource {
+	out := cacheSource{URLs: make([]string, 0, len(rawURLs)), FetchedAt: fetchedAt}
+	for _, rawURL := range rawURLs {
+		out.URLs = append(out.URLs, elideCredentials(rawURL))
+	}
+	return out
+}
+
+// bytes is the approximate memory used by the source
+func (cs cacheSource) bytes() int {
+	size := 0
+	for _, u := range cs.URLs {
+		size += len(u)
+	}
+	return size
+}
+
+// elideCredentials removes the user info from a URL
+func elideCredentials(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.User == nil {
+		return rawURL
+	}
+	parsed.User = nil
+	return parsed.String()
+}
+
+// cacheDigest is the client visible form of a cache key
+func cacheDigest(key string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(key)))[:16]
+}
+
+// setCacheKeyHeader reports the digest of the cache key for the response
+func setCacheKeyHeader(c *gin.Context, key string) {
+	c.Header(cacheKeyHeader, cacheDigest(key))
+}
+
+// inspectableCaches are the caches of Sierra responses, by name
+func (svc *ServiceContext) inspectableCaches() map[string]*ttlCache {
+	out := map[string]*ttlCache{"suggest": svc.Suggestions, "feed": svc.Feeds}
+	if svc.TrendingRecords != nil {
+		out["trending"] = svc.TrendingRecords
+	}
+	if svc.Records != nil {
+		out["record"] = svc.Records
+	}
+	return out
+}
+
+// cacheStats returns the size of each inspectable cache
+func (svc *ServiceContext) cacheStats() map[string]cacheStats {
+	out := make(map[string]cacheStats)
+	for name, cache := range svc.inspectableCaches() {
+		out[name] = cache.stats()
+	}
+	return out
+}
+
+// adminCacheEntry returns the Sierra request behind the cached response whose X-Cache-Key
+// header had the digest
+func (svc *ServiceContext) adminCacheEntry(c *gin.Context) {
+	digest := c.Param("digest")
+	now := time.Now()
+	for name, cache := range svc.inspectableCaches() {
+		if _, entry, found := cache.inspect(digest, now); found {
+			c.JSON(http.StatusOK, gin.H{"cache": name, "digest": digest, "stored": entry.stored,
+				"expires": entry.expires, "approx_bytes": entry.bytes, "source": entry.source})
+			return
+		}
+	}
+	sendError(c, http.StatusNotFound, errRecordNotFound, fmt.Sprintf("no cache entry for %s", digest))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheStatus records whether a response came from a pool cache rather than Sierra
+type cacheStatus struct {
+	Cached     bool  `json:"cached"`
+	AgeSeconds int64 `json:"cache_age_seconds"`
+}
+
+// newCacheStatus returns the status of a response; age is ignored for uncached responses
+func newCacheStatus(cached bool, age time.Duration) cacheStatus {
+	if cached == false {
+		return cacheStatus{}
+	}
+	return cacheStatus{Cached: true, AgeSeconds: int64(age / time.Second)}
+}
+
+// label returns the X-Cache value for the status
+func (cs cacheStatus) label() string {
+	if cs.Cached {
+		return "HIT"
+	}
+	return "MISS"
+}
+
+// recordCacheStatus notes the cache status of the request for the access log
+func recordCacheStatus(c *gin.Context, status cacheStatus) {
+	c.Set("cache", status)
+}
+
+// setCacheHeader records the cache status and reports it in the X-Cache header. It is used
+// by the endpoints where stale data has consequences (records and availability).
+func setCacheHeader(c *gin.Context, status cacheStatus) {
+	recordCacheStatus(c, status)
+	c.Header("X-Cache", status.label())
+}
+
+// accessLogFormatter is the gin access log format with the cache status and the response
+// encoding time appended when the request recorded them
+func accessLogFormatter(param gin.LogFormatterParams) string {
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	cache := ""
+	if status, ok := param.Keys["cache"].(cacheStatus); ok {
+		cache = fmt.Sprintf(" | cache %s %ds", status.label(), status.AgeSeconds)
+	}
+	if encodeMS, ok := param.Keys["encode_ms"].(int64); ok {
+		cache += fmt.Sprintf(" | encode %dms", encodeMS)
+	}
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v%s\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		param.Path,
+		cache,
+		param.ErrorMessage,
+	)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-api/v4api"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+// ageCache makes every entry of the cache look as if it was stored d earlier
+func ageCache(tc *ttlCache, d time.Duration) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	for key, entry := range tc.entries {
+		entry.stored = entry.stored.Add(-d)
+		entry.expires = entry.expires.Add(-d)
+		tc.entries[key] = entry
+	}
+}
+
+func TestCombineCacheStatus(t *testing.T) {
+	hit := func(age time.Duration) cacheStatus { return newCacheStatus(true, age) }
+	tests := []struct {
+		name string
+		a, b cacheStatus
+		want cacheStatus
+	}{
+		{"both cached", hit(10 * time.Second), hit(30 * time.Second), hit(30 * time.Second)},
+		{"oldest first", hit(30 * time.Second), hit(10 * time.Second), hit(30 * time.Second)},
+		{"bib missed", newCacheStatus(false, 0), hit(10 * time.Second), cacheStatus{}},
+		{"items missed", hit(10 * time.Second), newCacheStatus(false, time.Minute), cacheStatus{}},
+	}
+	for _, tc := range tests {
+		if got := tc.a.combine(tc.b); got != tc.want {
+			t.Errorf("%s: combine = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAccessLogCacheStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		keys map[string]interface{}
+		want string
+	}{
+		{"hit", map[string]interface{}{"cache": newCacheStatus(true, 42*time.Second)}, `"/api/resource/1001" | cache HIT 42s`},
+		{"miss", map[string]interface{}{"cache": newCacheStatus(false, 0)}, `"/api/resource/1001" | cache MISS 0s`},
+		{"not recorded", nil, `"/api/resource/1001"` + "\n"},
+		{"hit and encoding time", map[string]interface{}{"cache": newCacheStatus(true, 42*time.Second), "encode_ms": int64(7)},
+			`"/api/resource/1001" | cache HIT 42s | encode 7ms`},
+	}
+	for _, tc := range tests {
+		line := accessLogFormatter(gin.LogFormatterParams{Method: http.MethodGet, Path: "/api/resource/1001",
+			StatusCode: http.StatusOK, Keys: tc.keys})
+		if strings.Contains(line, tc.want) == false {
+			t.Errorf("%s: access log %q, want %q", tc.name, line, tc.want)
+		}
+	}
+}
+
+// the resource X-Cache header and debug block follow the record cache through a
+// cold, warm and expired lookup
+func TestResourceCacheStatus(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/1001", http.StatusOK, testBib("1001", "Cats"))
+	sierra.handleJSON("items", http.StatusOK, JMRLItemResult{})
+	cfg := newTestConfig(sierra.apiURL())
+	cfg.RecordCacheSecs = 60
+	svc := newTestServiceWithConfig(t, cfg)
+	router := newRouter(svc)
+
+	tests := []struct {
+		name      string
+		age       time.Duration
+		wantCache string
+		wantAge   int64
+		wantBibs  int
+	}{
+		{"cold", 0, "MISS", 0, 1},
+		{"warm", 0, "HIT", 0, 1},
+		{"older", 45 * time.Second, "HIT", 45, 1},
+		{"expired", 20 * time.Second, "MISS", 0, 2},
+		{"rewarmed", 0, "HIT", 0, 2},
+	}
+	for _, tc := range tests {
+		ageCache(svc.Records, tc.age)
+		rec := apiRequestAs(t, router, v4jwt.Staff, http.MethodGet, "/api/resource/1001?debug=true", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tc.name, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("X-Cache"); got != tc.wantCache {
+			t.Errorf("%s: X-Cache = %q, want %q", tc.name, got, tc.wantCache)
+		}
+		var resp struct {
+			Debug *cacheStatus `json:"debug"`
+		}
+		decodeTestJSON(t, rec, &resp)
+		want := cacheStatus{Cached: tc.wantCache == "HIT", AgeSeconds: tc.wantAge}
+		if resp.Debug == nil || *resp.Debug != want {
+			t.Errorf("%s: debug = %+v, want %+v", tc.name, resp.Debug, want)
+		}
+		if got := sierra.count("bibs/1001"); got != tc.wantBibs {
+			t.Errorf("%s: %d bib requests, want %d", tc.name, got, tc.wantBibs)
+		}
+	}
+
+	// patrons get the header but not the debug block
+	rec := apiRequest(t, router, http.MethodGet, "/api/resource/1001?debug=true", "")
+	if got := rec.Header().Get("X-Cache"); got != "HIT" {
+		t.Errorf("patron X-Cache = %q, want HIT", got)
+	}
+	if strings.Contains(rec.Body.String(), `"debug"`) {
+		t.Errorf("patron response has a debug block: %s", rec.Body.String())
+	}
+}
+
+// without a record cache every record is fetched from Sierra
+func TestResourceWithoutRecordCache(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/1001", http.StatusOK, testBib("1001", "Cats"))
+	sierra.handleJSON("items", http.StatusOK, JMRLItemResult{})
+	router := newRouter(newTestService(t, sierra))
+	for i := 0; i < 2; i++ {
+		rec := apiRequest(t, router, http.MethodGet, "/api/resource/1001", "")
+		if got := rec.Header().Get("X-Cache"); got != "MISS" {
+			t.Errorf("request %d: X-Cache = %q, want MISS", i, got)
+		}
+	}
+	if got := sierra.count("bibs/1001"); got != 2 {
+		t.Errorf("%d bib requests, want 2", got)
+	}
+}
+
+// failed Sierra responses are not cached
+func TestRecordCacheSkipsErrors(t *testing.T) {
+	sierra := newFakeSierra(t)
+	fail := true
+	sierra.handle("bibs/1001", func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			writeTestJSON(w, http.StatusInternalServerError, SierraError{Code: 109, Description: "Internal error"})
+			return
+		}
+		writeTestJSON(w, http.StatusOK, testBib("1001", "Cats"))
+	})
+	sierra.handleJSON("items", http.StatusOK, JMRLItemResult{})
+	cfg := newTestConfig(sierra.apiURL())
+	cfg.RecordCacheSecs = 60
+	router := newRouter(newTestServiceWithConfig(t, cfg))
+	if rec := apiRequest(t, router, http.MethodGet, "/api/resource/1001", ""); rec.Code == http.StatusOK {
+		t.Fatalf("status = %d, want a failure", rec.Code)
+	}
+	fail = false
+	if rec := apiRequest(t, router, http.MethodGet, "/api/resource/1001", ""); rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("X-Cache after a failure = %q, want MISS", rec.Header().Get("X-Cache"))
+	}
+}
+
+// a bib number search reports the record cache lookup in its debug block
+func TestBibNumberSearchCacheStatus(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/1234567", http.StatusOK, testBib("1234567", "Cats"))
+	cfg := newTestConfig(sierra.apiURL())
+	cfg.RecordCacheSecs = 60
+	svc := newTestServiceWithConfig(t, cfg)
+	router := newRouter(svc)
+
+	tests := []struct {
+		name       string
+		age        time.Duration
+		wantCached bool
+		wantAge    float64
+	}{
+		{"cold", 0, false, 0},
+		{"warm", 12 * time.Second, true, 12},
+		{"expired", time.Minute, false, 0},
+	}
+	for _, tc := range tests {
+		ageCache(svc.Records, tc.age)
+		rec := apiRequestAs(t, router, v4jwt.Staff, http.MethodPost, "/api/search?debug=true", `{"query":"keyword: {b1234567}"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tc.name, rec.Code, rec.Body.String())
+		}
+		var resp v4api.PoolResult
+		decodeTestJSON(t, rec, &resp)
+		if resp.Debug["cached"] != tc.wantCached || resp.Debug["cache_age_seconds"] != tc.wantAge {
+			t.Errorf("%s: debug cached %v age %v, want %t %v", tc.name, resp.Debug["cached"], resp.Debug["cache_age_seconds"], tc.wantCached, tc.wantAge)
+		}
+		if got := rec.Header().Get("X-Cache"); got != "" {
+			t.Errorf("%s: search X-Cache = %q, want none", tc.name, got)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Sierra text search index code for call numbers
+const sierraCallNumberIndex = "c"
+
+// callNumberSubfields are the 092 subfields that make up the displayed call number:
+// the classification ($a) and the item / cutter part ($b)
+const callNumberSubfields = "ab"
+
+// getCallNumbers returns the call numbers of a bib as displayed, one per 092 field.
+// This is also the form accepted by call_number: searches.
+func getCallNumbers(varFields *[]JMRLVarFields) []string {
+	out := make([]string, 0)
+	for _, field := range *varFields {
+		if field.MarcTag != "092" {
+			continue
+		}
+		parts := make([]string, 0)
+		for _, sub := range field.Subfields {
+			if strings.Contains(callNumberSubfields, sub.Tag) {
+				parts = append(parts, stripTrailingData(html.UnescapeString(sub.Content)))
+			}
+		}
+		if val := normalizeCallNumber(strings.Join(parts, " ")); val != "" {
+			out = append(out, val)
+		}
+	}
+	return out
+}
+
+// normalizeCallNumber removes characters that are query syntax and any subfield
+// delimiters, and collapses whitespace
+func normalizeCallNumber(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`"(){}`, r) {
+			return ' '
+		}
+		return r
+	}, value)
+	return cleanCallNumber(value)
+}
+
+// translateCallNumberClause searches the call number index for the whole clause as a
+// phrase, so the periods and spaces in a call number are matched literally.
+// EX: call_number: {641.5 SMI} => c:("641.5 SMI")
+func translateCallNumberClause(content string) (string, error) {
+	callNumber := normalizeCallNumber(content)
+	if callNumber == "" {
+		return sierraNoMatch, nil
+	}
+	return fmt.Sprintf("%s:(\"%s\")", sierraCallNumberIndex, callNumber), nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// callNumberField returns a 092 field with the subfields, given as tag, content pairs
+func callNumberField(pairs ...string) JMRLVarFields {
+	field := JMRLVarFields{MarcTag: "092"}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		field.Subfields = append(field.Subfields, JMRLSubfield{Tag: pairs[i], Content: pairs[i+1]})
+	}
+	return field
+}
+
+func TestGetCallNumbers(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []JMRLVarFields
+		want   []string
+	}{
+		{"classification only", []JMRLVarFields{callNumberField("a", "FIC ROWLING")}, []string{"FIC ROWLING"}},
+		{"with item part", []JMRLVarFields{callNumberField("a", "641.5", "b", "SMI")}, []string{"641.5 SMI"}},
+		{"trailing period", []JMRLVarFields{callNumberField("a", "973.7", "b", "LIN.")}, []string{"973.7 LIN"}},
+		{"other subfields", []JMRLVarFields{callNumberField("a", "J 599.75", "b", "GIB", "e", "c.2")}, []string{"J 599.75 GIB"}},
+		{"whitespace", []JMRLVarFields{callNumberField("a", " 641.5 ", "b", "  SMI ")}, []string{"641.5 SMI"}},
+		{"query syntax", []JMRLVarFields{callNumberField("a", "FIC (ROWLING)")}, []string{"FIC ROWLING"}},
+		{"entities", []JMRLVarFields{callNumberField("a", "B &amp; W")}, []string{"B & W"}},
+		{"several", []JMRLVarFields{callNumberField("a", "FIC ROWLING"), callNumberField("a", "CD FIC ROWLING")},
+			[]string{"FIC ROWLING", "CD FIC ROWLING"}},
+		{"empty", []JMRLVarFields{callNumberField("b", " ")}, []string{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := getCallNumbers(&tc.fields); reflect.DeepEqual(got, tc.want) == false {
+				t.Errorf("getCallNumbers = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTranslateCallNumberClause(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"641.5 SMI", `c:("641.5 SMI")`},
+		{"FIC ROWLING", `c:("FIC ROWLING")`},
+		{`"FIC  ROWLING"`, `c:("FIC ROWLING")`},
+		{"", sierraNoMatch},
+	}
+	for _, tc := range tests {
+		if got, err := translateCallNumberClause(tc.content); err != nil || got != tc.want {
+			t.Errorf("translateCallNumberClause(%q) = %s %v, want %s", tc.content, got, err, tc.want)
+		}
+	}
+}
+
+// searching for a displayed call number must search Sierra for exactly that call number
+func TestCallNumberRoundTrip(t *testing.T) {
+	svc := newTestService(t, nil)
+	for _, field := range []JMRLVarFields{
+		callNumberField("a", "FIC ROWLING"),
+		callNumberField("a", "641.5", "b", "SMI"),
+		callNumberField("a", "J 599.75", "b", "GIB."),
+		callNumberField("a", "CD 782.42166", "b", "BEA"),
+	} {
+		bib := testBib("1001", "Shelf list")
+		bib.VarFields = append(bib.VarFields, field)
+		fields := svc.getResultFields(&bib, fieldOptions{View: viewFull, Language: "en", Localizer: testLocalizer(svc, "en")})
+		displayed := fieldValues(fields, "call_number")
+		if len(displayed) != 1 {
+			t.Fatalf("call_number fields = %q, want one", displayed)
+		}
+		query := "call_number: {" + displayed[0] + "}"
+		req := jmrlSearchRequest{SearchRequest: v4api.SearchRequest{Query: query, Pagination: v4api.Pagination{Rows: 20}}}
+		xlate, err := svc.translateSearch(req)
+		if err != nil {
+			t.Fatalf("translateSearch(%q) failed: %s", query, err.Message)
+		}
+		if want := `c:("` + displayed[0] + `")`; xlate.Query != want {
+			t.Errorf("translateSearch(%q) = %s, want %s", query, xlate.Query, want)
+		}
+	}
+}
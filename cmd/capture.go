@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fixedFixtureDate replaces all volatile dates in captured fixtures
+const fixedFixtureDate = "2000-01-01T00:00:00Z"
+
+// volatileKeys are JSON keys whose values change over time and are normalized in fixtures
+var volatileKeys = map[string]string{
+	"updatedDate":  fixedFixtureDate,
+	"createdDate":  fixedFixtureDate,
+	"catalogDate":  "2000-01-01",
+	"deletedDate":  "2000-01-01",
+	"duedate":      fixedFixtureDate,
+	"access_token": "fixture-token",
+}
+
+// sensitiveKeys are JSON keys whose values are replaced in fixtures
+var sensitiveKeys = map[string]string{
+	"barcode": "00000000000000",
+}
+
+// sensitiveFieldTags are item varField tags (barcode, internal note) removed from fixtures
+var sensitiveFieldTags = map[string]bool{"b": true, "x": true}
+
+// runCapture implements the developer-facing capture subcommand, which fetches a bib, its
+// items and a sample search from the live Sierra API and writes scrubbed canonical fixtures:
+//
+//	v4jmrl capture -api URL -apikey KEY -apisecret SECRET -id 1234567 -query "keyword: {cats}" -out cmd/testdata/fixtures/
+func runCapture(args []string) {
+	var cfg ServiceConfig
+	var bibID, query, outDir string
+	fs := flag.NewFlagSet("capture", flag.ExitOnError)
+	fs.StringVar(&cfg.API, "api", "", "JRML API URL")
+	fs.StringVar(&cfg.APIKey, "apikey", "", "Key you access the JRML API")
+	fs.StringVar(&cfg.APISecret, "apisecret", "", "Secret to access the JRML API")
+	fs.StringVar(&bibID, "id", "", "Bib ID to capture")
+	fs.StringVar(&query, "query", "", "Optional Sierra text query to capture a sample search")
+	fs.StringVar(&outDir, "out", "cmd/testdata/fixtures", "Fixture output directory")
+	fs.Parse(args)
+	if cfg.API == "" || cfg.APIKey == "" || cfg.APISecret == "" || bibID == "" {
+		log.Fatal("Parameters -api, -apikey, -apisecret and -id are required")
+	}
+	cfg.Placeholders = defaultPlaceholderLocations
+	svc := InitializeService(version, &cfg)
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		log.Fatalf("Unable to create %s: %s", outDir, err.Error())
+	}
+	captures := map[string]string{
+		fmt.Sprintf("bib_%s.json", bibID):   svc.Sierra.BibURL(bibID, bibFields),
+		fmt.Sprintf("items_%s.json", bibID): svc.Sierra.ItemsURL([]string{bibID}, itemFields),
+	}
+	if query != "" {
+		params := url.Values{}
+		params.Set("text", query)
+		params.Set("offset", "0")
+		params.Set("limit", fmt.Sprintf("%d", defaultPageSize))
+		params.Set("fields", bibFields)
+		captures[fmt.Sprintf("search_%s.json", fixtureSlug(query))] = svc.Sierra.SearchURL(params)
+	}
+
+	for name, tgtURL := range captures {
+		resp, err := svc.apiGet(context.Background(), tgtURL)
+		if err != nil {
+			log.Fatalf("Unable to capture %s: %d %s", name, err.StatusCode, err.Message)
+		}
+		if writeErr := writeFixture(filepath.Join(outDir, name), resp); writeErr != nil {
+			log.Fatalf("Unable to write %s: %s", name, writeErr.Error())
+		}
+		if written, readErr := os.ReadFile(filepath.Join(outDir, name)); readErr != nil || json.Valid(written) == false {
+			log.Fatalf("Fixture %s does not load", name)
+		}
+		log.Printf("Captured %s", name)
+	}
+}
+
+// fixtureSlug converts a query into a safe fixture file name component
+func fixtureSlug(query string) string {
+	slug := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+		return '_'
+	}, query)
+	slug = strings.Trim(slug, "_")
+	if len(slug) > 40 {
+		slug = slug[:40]
+	}
+	return slug
+}
+
+// writeFixture scrubs and normalizes a raw Sierra response and writes it as indented
+// JSON with sorted keys so that re-captures produce minimal diffs
+func writeFixture(filename string, raw []byte) error {
+	var data interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(scrubFixture(data), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(out, '\n'), 0644)
+}
+
+// scrubFixture walks decoded JSON replacing volatile and sensitive values
+func scrubFixture(data interface{}) interface{} {
+	switch val := data.(type) {
+	case map[string]interface{}:
+		for key, child := range val {
+			if fixed, ok := volatileKeys[key]; ok {
+				val[key] = fixed
+			} else if fixed, ok := sensitiveKeys[key]; ok {
+				val[key] = fixed
+			} else {
+				val[key] = scrubFixture(child)
+			}
+		}
+		return val
+	case []interface{}:
+		out := make([]interface{}, 0, len(val))
+		for _, child := range val {
+			if vf, ok := child.(map[string]interface{}); ok {
+				if tag, ok := vf["fieldTag"].(string); ok && sensitiveFieldTags[tag] {
+					continue
+				}
+			}
+			out = append(out, scrubFixture(child))
+		}
+		return out
+	}
+	return data
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-api/v4api"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+// fixtureDir holds the fixtures written by the capture subcommand; see runCapture
+const fixtureDir = "testdata/fixtures"
+
+// loadFixture reads a captured fixture file into the target structure
+func loadFixture(t *testing.T, name string, target interface{}) {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join(fixtureDir, name))
+	if err != nil {
+		t.Fatalf("unable to read fixture %s: %s", name, err.Error())
+	}
+	if err := json.Unmarshal(raw, target); err != nil {
+		t.Fatalf("invalid fixture %s: %s", name, err.Error())
+	}
+}
+
+// serveFixture registers a fixture as the fake Sierra response for a path
+func serveFixture(t *testing.T, sierra *fakeSierra, relPath string, name string) {
+	t.Helper()
+	var raw json.RawMessage
+	loadFixture(t, name, &raw)
+	sierra.handle(relPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(raw)
+	})
+}
+
+// checkScrubbed fails for any volatile or sensitive value left in decoded fixture JSON
+func checkScrubbed(t *testing.T, name string, data interface{}) {
+	t.Helper()
+	switch val := data.(type) {
+	case map[string]interface{}:
+		for key, child := range val {
+			if fixed, ok := volatileKeys[key]; ok && child != fixed {
+				t.Errorf("%s: %s is %v, want %s", name, key, child, fixed)
+			}
+			if fixed, ok := sensitiveKeys[key]; ok && child != fixed {
+				t.Errorf("%s: %s is %v, want %s", name, key, child, fixed)
+			}
+			if tag, ok := val["fieldTag"].(string); ok && sensitiveFieldTags[tag] && val["marcTag"] == nil {
+				t.Errorf("%s: item field %s was not removed", name, tag)
+			}
+			checkScrubbed(t, name, child)
+		}
+	case []interface{}:
+		for _, child := range val {
+			checkScrubbed(t, name, child)
+		}
+	}
+}
+
+func TestFixturesAreScrubbed(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join(fixtureDir, "*.json"))
+	if len(files) == 0 {
+		t.Fatalf("no fixtures in %s", fixtureDir)
+	}
+	for _, fn := range files {
+		var data interface{}
+		loadFixture(t, filepath.Base(fn), &data)
+		checkScrubbed(t, filepath.Base(fn), data)
+	}
+}
+
+func TestWriteFixtureIsCanonical(t *testing.T) {
+	dir := t.TempDir()
+	captures := []string{
+		`{"id":"1","updatedDate":"2024-05-01T12:13:14Z","entries":[{"barcode":"3222200","varFields":[{"fieldTag":"b","content":"3222200"},{"fieldTag":"z","content":"note"}]}]}`,
+		`{"entries":[{"varFields":[{"fieldTag":"x","content":"staff"},{"fieldTag":"z","content":"note"}],"barcode":"3222299"}],"updatedDate":"2025-01-01T00:00:00Z","id":"1"}`,
+	}
+	written := make([]string, 0)
+	for idx, raw := range captures {
+		fn := filepath.Join(dir, "capture"+string(rune('a'+idx))+".json")
+		if err := writeFixture(fn, []byte(raw)); err != nil {
+			t.Fatalf("writeFixture failed: %s", err.Error())
+		}
+		out, _ := os.ReadFile(fn)
+		written = append(written, string(out))
+	}
+	if written[0] != written[1] {
+		t.Errorf("re-captures differ:\n%s\n%s", written[0], written[1])
+	}
+	for _, secret := range []string{"3222200", "3222299", "staff", "2024-05-01", "2025-01-01"} {
+		if strings.Contains(written[0], secret) {
+			t.Errorf("fixture contains %s:\n%s", secret, written[0])
+		}
+	}
+	if err := writeFixture(filepath.Join(dir, "bad.json"), []byte("not json")); err == nil {
+		t.Errorf("writeFixture accepted invalid JSON")
+	}
+}
+
+func TestFixtureSlug(t *testing.T) {
+	tests := map[string]string{
+		"t:(cats)":                      "t__cats",
+		`keyword: {"Cien Años"}`:        "keyword____cien_a_os",
+		strings.Repeat("long query", 8): "long_querylong_querylong_querylong_query",
+	}
+	for query, want := range tests {
+		if got := fixtureSlug(query); got != want {
+			t.Errorf("fixtureSlug(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+// newFixtureRouter returns a router backed by a fake Sierra replaying the fixture corpus
+func newFixtureRouter(t *testing.T) *gin.Engine {
+	sierra := newFakeSierra(t)
+	serveFixture(t, sierra, "bibs/1001", "bib_1001.json")
+	serveFixture(t, sierra, "items", "items_1001.json")
+	serveFixture(t, sierra, "bibs/search", "search_t__cats.json")
+	svc := newTestService(t, sierra)
+	router := gin.New()
+	router.POST("/api/search", svc.authMiddleware, svc.search)
+	router.GET("/api/resource/:id", svc.authMiddleware, svc.getResource)
+	return router
+}
+
+func replayRequest(t *testing.T, router *gin.Engine, method string, target string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.User))
+	req.Header.Set("Accept-Language", "es")
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s %s = %d: %s", method, target, rec.Code, rec.Body.String())
+	}
+	return rec
+}
+
+func TestReplayResourceFixture(t *testing.T) {
+	router := newFixtureRouter(t)
+	var resp interface{}
+	decodeTestJSON(t, replayRequest(t, router, http.MethodGet, "/api/resource/1001", ""), &resp)
+	checkGolden(t, "replay_resource_1001", resp)
+}
+
+func TestReplaySearchFixture(t *testing.T) {
+	router := newFixtureRouter(t)
+	var resp v4api.PoolResult
+	decodeTestJSON(t, replayRequest(t, router, http.MethodPost, "/api/search", `{"query":"keyword: {cats}"}`), &resp)
+	resp.ElapsedMS = 0
+	checkGolden(t, "replay_search_cats", resp)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// citationMapping is the citation part of a record field and the RIS tag it exports as
+type citationMapping struct {
+	Part string
+	RIS  string
+}
+
+// citationMappings is the single place record fields are mapped onto citation parts.
+// Subtitle has no tag of its own: applyCitationParts appends it to the title in a hidden
+// citation_title field, so a citation has one TI and series can use T3 without any RIS
+// consumer reading it as part of the title.
+var citationMappings = map[string]citationMapping{
+	"id":               {Part: "id", RIS: "ID"},
+	"title":            {Part: "title", RIS: "TI"},
+	"citation_title":   {Part: "title", RIS: "TI"},
+	"series":           {Part: "series", RIS: "T3"},
+	"author":           {Part: "author", RIS: "AU"},
+	"publication_date": {Part: "published_date", RIS: "PY"},
+	"published":        {Part: "publisher", RIS: "PB"},
+	"format":           {Part: "format", RIS: "M3"},
+	"language":         {Part: "language", RIS: "LA"},
+	"isbn":             {Part: "serial_number", RIS: "SN"},
+	"call_number":      {Part: "call_number", RIS: "CN"},
+	"location":         {Part: "location", RIS: "AV"},
+	"subject":          {Part: "subject", RIS: "KW"},
+	"genre":            {Part: "subject", RIS: "KW"},
+	"summary":          {Part: "abstract", RIS: "AB"},
+	"contents":         {Part: "notes", RIS: "N1"},
+	"access_url":       {Part: "url", RIS: "UR"},
+}
+
+// nonCitableFields are record fields deliberately left out of citations
+var nonCitableFields = map[string]bool{
+	"title_collation_key": true, "brief_record_note": true, "subject_more": true, "subtitle": true,
+	"summary_truncated": true, "audience_age": true, "audience_age_fixed": true,
+	"availability": true, "availability_class": true, "earliest_due": true,
+	"earliest_due_iso": true, "nearest_branch_distance": true, "relevance": true, "title_language": true,
+	"subtitle_language": true, "contents_language": true, "summary_language": true,
+	"subject_language": true, "subject_more_language": true, "date_added": true, "date_added_iso": true,
+	"language_code": true, "genre_language": true,
+}
+
+// unmappedCitationFields returns the record fields that have no citation mapping and are
+// not listed as non-citable, so new fields get a deliberate decision
+func unmappedCitationFields() []string {
+	out := make([]string, 0)
+	for name := range knownRecordFields {
+		if _, mapped := citationMappings[name]; mapped == false && nonCitableFields[name] == false {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// checkCitationMappings logs any record field that has no citation mapping
+func checkCitationMappings() {
+	for _, name := range unmappedCitationFields() {
+		log.Printf("WARNING: record field %s has no citation mapping", name)
+	}
+}
+
+// citationTitle joins a title and subtitle the way citations show them: Title: Subtitle
+func citationTitle(title string, subtitle string) string {
+	if subtitle == "" {
+		return title
+	}
+	return fmt.Sprintf("%s: %s", strings.TrimRight(title, " :"), subtitle)
+}
+
+// applyCitationParts sets the citation part of each field from citationMappings. When
+// the record has a subtitle the title citation moves to a hidden citation_title field
+// holding both.
+func applyCitationParts(fields []v4api.RecordField) []v4api.RecordField {
+	titleIdx := -1
+	subtitle := ""
+	for i := range fields {
+		fields[i].CitationPart = citationMappings[fields[i].Name].Part
+		switch fields[i].Name {
+		case "title":
+			titleIdx = i
+		case "subtitle":
+			subtitle = fields[i].Value
+		}
+	}
+	if titleIdx < 0 || subtitle == "" {
+		return fields
+	}
+	fields[titleIdx].CitationPart = ""
+	return append(fields, v4api.RecordField{Name: "citation_title", Type: "title", Visibility: "hidden",
+		Value: citationTitle(fields[titleIdx].Value, subtitle), CitationPart: citationMappings["citation_title"].Part})
+}
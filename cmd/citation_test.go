@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+func TestCitationMappingsCoverRecordFields(t *testing.T) {
+	if unmapped := unmappedCitationFields(); len(unmapped) > 0 {
+		t.Errorf("record fields %v have no citation mapping and are not listed as non-citable", unmapped)
+	}
+	for name := range citationMappings {
+		if knownRecordFields[name] == false {
+			t.Errorf("citation mapping for %s, which is not a record field", name)
+		}
+		if nonCitableFields[name] {
+			t.Errorf("%s is both mapped and listed as non-citable", name)
+		}
+	}
+	for name := range nonCitableFields {
+		if knownRecordFields[name] == false {
+			t.Errorf("%s is listed as non-citable but is not a record field", name)
+		}
+	}
+}
+
+func TestCitationTitle(t *testing.T) {
+	tests := []struct {
+		title, subtitle, want string
+	}{
+		{"Cats", "", "Cats"},
+		{"Cats", "a history", "Cats: a history"},
+		{"Cats :", "a history", "Cats: a history"},
+		{"Gatos:", "una historia", "Gatos: una historia"},
+	}
+	for _, tc := range tests {
+		if got := citationTitle(tc.title, tc.subtitle); got != tc.want {
+			t.Errorf("citationTitle(%q, %q) = %q, want %q", tc.title, tc.subtitle, got, tc.want)
+		}
+	}
+}
+
+// citedTitles returns the values of the fields cited as the title
+func citedTitles(fields []v4api.RecordField) []string {
+	out := make([]string, 0)
+	for _, f := range fields {
+		if f.CitationPart == "title" {
+			out = append(out, f.Value)
+		}
+	}
+	return out
+}
+
+func TestSubtitleIsAppendedToCitedTitle(t *testing.T) {
+	svc := newTestService(t, nil)
+	tests := []struct {
+		name     string
+		subtitle string
+		want     string
+	}{
+		{"with subtitle", "a history /", "Cats: a history"},
+		{"without subtitle", "", "Cats"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bib := testBib("1001", "Cats")
+			if tc.subtitle != "" {
+				bib.VarFields[1] = marcField("245", "a", "Cats :", "b", tc.subtitle)
+			}
+			fields := svc.getResultFields(&bib, fieldOptions{View: viewFull, Language: "en-US", Localizer: testLocalizer(svc, "en-US")})
+			if got := citedTitles(fields); len(got) != 1 || got[0] != tc.want {
+				t.Errorf("cited titles = %q, want [%q]", got, tc.want)
+			}
+			for _, f := range fields {
+				if f.Name == "subtitle" && f.CitationPart != "" {
+					t.Errorf("subtitle cited as %q, want no citation part", f.CitationPart)
+				}
+				if f.Name == "citation_title" && f.Visibility != "hidden" {
+					t.Errorf("citation_title visibility = %q, want hidden", f.Visibility)
+				}
+			}
+			if got := fieldValues(fields, "title"); len(got) != 1 || got[0] != "Cats" {
+				t.Errorf("display title = %q, want [Cats]", got)
+			}
+			entry := buildBibTeX(&bib, fields, false)
+			if strings.Contains(entry, "  title = {"+tc.want+"},\n") == false {
+				t.Errorf("bibtex entry has no title %q:\n%s", tc.want, entry)
+			}
+		})
+	}
+}
+
+func TestEachCitationPartIsEmittedOnce(t *testing.T) {
+	svc := newTestService(t, nil)
+	bib := testBib("1001", "Cats")
+	bib.VarFields[1] = marcField("245", "a", "Cats :", "b", "a history")
+	bib.VarFields = append(bib.VarFields, marcField("490", "a", "Pets of the world ;", "v", "3"))
+	fields := svc.getResultFields(&bib, fieldOptions{View: viewFull, Language: "en-US", Localizer: testLocalizer(svc, "en-US")})
+	tags := make(map[string]int)
+	for _, f := range fields {
+		if f.CitationPart == "" {
+			continue
+		}
+		if mapping, found := citationMappings[f.Name]; found == false || mapping.Part != f.CitationPart {
+			t.Errorf("%s cited as %q, want the %q from citationMappings", f.Name, f.CitationPart, mapping.Part)
+		}
+		tags[citationMappings[f.Name].RIS]++
+	}
+	for _, tag := range []string{"ID", "TI", "T3"} {
+		if tags[tag] != 1 {
+			t.Errorf("RIS %s emitted %d times, want 1", tag, tags[tag])
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/hex"
+	"sync"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// collatorCache holds one collator per locale. Collators are not safe for concurrent
+// use, so all access is serialized by the lock
+type collatorCache struct {
+	lock      sync.Mutex
+	collators map[string]*collate.Collator
+	buf       collate.Buffer
+}
+
+// get returns the cached collator for a locale, creating it if needed. Caller must hold lock
+func (cc *collatorCache) get(lang string) *collate.Collator {
+	if cc.collators == nil {
+		cc.collators = make(map[string]*collate.Collator)
+	}
+	tag := language.Make(lang)
+	col, found := cc.collators[tag.String()]
+	if found == false {
+		col = collate.New(tag, collate.IgnoreCase, collate.Loose)
+		cc.collators[tag.String()] = col
+	}
+	return col
+}
+
+// compare orders two strings by the collation rules of the locale. Returns <0, 0 or >0
+func (cc *collatorCache) compare(lang string, a string, b string) int {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	return cc.get(lang).CompareString(a, b)
+}
+
+// key returns a hex encoded collation key for the string. Byte ordering of keys
+// matches the collation order of the strings for the locale.
+func (cc *collatorCache) key(lang string, s string) string {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	cc.buf.Reset()
+	return hex.EncodeToString(cc.get(lang).KeyFromString(&cc.buf, s))
+}
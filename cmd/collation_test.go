@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// mixedScriptTitles are titles in Latin (Spanish and English), Cyrillic, Hangul and Han
+var mixedScriptTitles = []string{"Zorro", "ñandú", "Война и мир", "nube", "Árbol", "鲁迅全集", "oso", "apple", "한강", "Banana", "Ángel"}
+
+// sortByKey orders the titles by their collation keys, as the aggregator would
+func sortByKey(cc *collatorCache, lang string, titles []string) []string {
+	out := append([]string{}, titles...)
+	sort.SliceStable(out, func(i, j int) bool { return cc.key(lang, out[i]) < cc.key(lang, out[j]) })
+	return out
+}
+
+func TestCollationOrder(t *testing.T) {
+	tests := []struct {
+		lang string
+		want []string
+	}{
+		// ñ is a variant of n in English and a separate letter after n in Spanish
+		{"en", []string{"Ángel", "apple", "Árbol", "Banana", "ñandú", "nube", "oso", "Zorro", "Война и мир", "한강", "鲁迅全集"}},
+		{"es", []string{"Ángel", "apple", "Árbol", "Banana", "nube", "ñandú", "oso", "Zorro", "Война и мир", "한강", "鲁迅全集"}},
+	}
+	for _, tc := range tests {
+		cc := &collatorCache{}
+		if got := sortByKey(cc, tc.lang, mixedScriptTitles); reflect.DeepEqual(got, tc.want) == false {
+			t.Errorf("%s key order = %v, want %v", tc.lang, got, tc.want)
+		}
+		// keys and direct comparison agree
+		byCompare := append([]string{}, mixedScriptTitles...)
+		sort.SliceStable(byCompare, func(i, j int) bool { return cc.compare(tc.lang, byCompare[i], byCompare[j]) < 0 })
+		if reflect.DeepEqual(byCompare, tc.want) == false {
+			t.Errorf("%s compare order = %v, want %v", tc.lang, byCompare, tc.want)
+		}
+	}
+}
+
+func TestCollatorCachedPerLocale(t *testing.T) {
+	cc := &collatorCache{}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lang := []string{"en", "es", "en-US"}[i%3]
+			cc.key(lang, mixedScriptTitles[i%len(mixedScriptTitles)])
+		}(i)
+	}
+	wg.Wait()
+	if len(cc.collators) != 3 {
+		t.Errorf("%d collators cached, want one per locale", len(cc.collators))
+	}
+	if cc.key("en", "Apple") != cc.key("en", "apple") {
+		t.Errorf("collation keys are case sensitive")
+	}
+}
+
+func TestTitleCollationKeyField(t *testing.T) {
+	svc := newTestService(t, nil)
+	bib := testBib("1001", "ñandú")
+	fields := svc.getResultFields(&bib, fieldOptions{View: viewBrief, Language: "es", Localizer: testLocalizer(svc, "es")})
+	keys := fieldValues(fields, "title_collation_key")
+	if len(keys) != 1 || keys[0] != svc.Collators.key("es", "ñandú") {
+		t.Errorf("title_collation_key = %v", keys)
+	}
+	for _, f := range fields {
+		if f.Name == "title_collation_key" && f.Visibility != "hidden" {
+			t.Errorf("title_collation_key visibility = %q", f.Visibility)
+		}
+	}
+}
+
+func TestLanguageFacetLabelsCollated(t *testing.T) {
+	svc := newTestService(t, nil)
+	bibs := make([]JMRLBib, 0)
+	for _, code := range []string{"rus", "ara", "spa", "fre", "eng", "eng"} {
+		bibs = append(bibs, JMRLBib{ID: code, Language: JMRLCodeValue{Code: code}})
+	}
+	facet := svc.languageFacet(bibs, nil, testLocalizer(svc, "es"), "es")
+	got := make([]string, 0)
+	for _, b := range facet.Buckets {
+		got = append(got, b.Label)
+	}
+	// by count, then label; a byte order sort would put Árabe last
+	want := []string{"Inglés", "Árabe", "Español", "Francés", "Ruso"}
+	if reflect.DeepEqual(got, want) == false {
+		t.Errorf("language facet labels = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// staticMaxAge is the client cache lifetime for responses that only change on deploy or reload
+const staticMaxAge = 300
+
+// setStaticModified records when the static pool responses (identify, providers) last changed.
+// It is called at startup and after every successful reload to bust client validators.
+// Last-Modified has one second resolution, so a change within the same second as the
+// previous one is recorded a second later to make sure If-Modified-Since sees it.
+func (svc *ServiceContext) setStaticModified() {
+	svc.staticLock.Lock()
+	defer svc.staticLock.Unlock()
+	modified := time.Now().UTC().Truncate(time.Second)
+	if modified.After(svc.staticModified) == false {
+		modified = svc.staticModified.Add(time.Second)
+	}
+	svc.staticModified = modified
+}
+
+// getStaticModified returns the time the static pool responses last changed
+func (svc *ServiceContext) getStaticModified() time.Time {
+	svc.staticLock.Lock()
+	defer svc.staticLock.Unlock()
+	return svc.staticModified
+}
+
+// etagMatches checks an If-None-Match header value against an ETag
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// sendCacheableJSON sends a JSON payload with ETag / Last-Modified validators and honors
+// If-None-Match (preferred) and If-Modified-Since with a 304 Not Modified
+func (svc *ServiceContext) sendCacheableJSON(c *gin.Context, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("ERROR: unable to encode response: %s", err.Error())
+		sendError(c, http.StatusInternalServerError, errInternal, err.Error())
+		return
+	}
+	etag := fmt.Sprintf("\"%x\"", sha256.Sum256(body))
+	lastModified := svc.getStaticModified()
+
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", lastModified.Format(http.TimeFormat))
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", staticMaxAge))
+	c.Header("Vary", "Accept-Language")
+
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		if etagMatches(inm, etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	} else if ims := c.GetHeader("If-Modified-Since"); ims != "" {
+		if imsTime, err := http.ParseTime(ims); err == nil && lastModified.After(imsTime) == false {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// resultDigest computes a deterministic digest of the content of a search result.
+// Timing data is excluded so identical results always produce the same digest. The
+// content language is included since the same records are localized per language.
+func resultDigest(res *v4api.PoolResult) string {
+	content := struct {
+		Language   string           `json:"language"`
+		Pagination v4api.Pagination `json:"pagination"`
+		Sort       v4api.SortOrder  `json:"sort"`
+		Groups     []v4api.Group    `json:"groups"`
+		Confidence string           `json:"confidence"`
+		Warnings   []string         `json:"warnings"`
+	}{res.ContentLanguage, res.Pagination, res.Sort, res.Groups, res.Confidence, res.Warnings}
+	body, _ := json.Marshal(content)
+	return fmt.Sprintf("\"%x\"", sha256.Sum256(body))
+}
+
+// sendSearchResult sends a successful search result with its digest as the ETag and
+// the time the content was last validated against Sierra. When the aggregator's
+// If-None-Match matches the digest, a 304 with no body is returned instead. The result is
+// marked complete unless the trace recorded a skipped enrichment.
+func (svc *ServiceContext) sendSearchResult(c *gin.Context, res *v4api.PoolResult, trace *requestTrace, validatedAt time.Time) {
+	digest := resultDigest(res)
+	c.Header("ETag", digest)
+	c.Header("X-Validated-At", validatedAt.UTC().Format(time.RFC3339))
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Vary", "Accept-Language")
+	if res.ContentLanguage != "" {
+		c.Header("Content-Language", res.ContentLanguage)
+	}
+	if inm := c.GetHeader("If-None-Match"); inm != "" && etagMatches(inm, digest) {
+		log.Printf("Search result unchanged since last request; returning 304")
+		c.Status(http.StatusNotModified)
+		return
+	}
+	svc.streamJSON(c, http.StatusOK, svc.completedResult(res, trace))
+}
+
+// completedResult marks a successful search result complete unless the trace recorded a
+// skipped enrichment
+func (svc *ServiceContext) completedResult(res *v4api.PoolResult, trace *requestTrace) poolErrorResult {
+	skipped := trace.skippedEnrichments()
+	complete := len(skipped) == 0
+	if complete == false {
+		svc.Metrics.Increment("search_incomplete")
+	}
+	return poolErrorResult{PoolResult: res, Complete: &complete, SkippedEnrichments: skipped}
+}
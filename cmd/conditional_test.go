@@ -0,0 +1,230 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-api/v4api"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+// newStaticTestServer returns a service with an identify override directory and a router
+// serving identify and providers
+func newStaticTestServer(t *testing.T) (*ServiceContext, *gin.Engine, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := newTestConfig("http://sierra.invalid" + fakeSierraBase)
+	cfg.OverrideDir = dir
+	svc := newTestServiceWithConfig(t, cfg)
+	router := gin.New()
+	router.GET("/identify", svc.identifyHandler)
+	router.GET("/api/providers", svc.providersHandler)
+	return svc, router, dir
+}
+
+func staticRequest(router *gin.Engine, target string, header string, value string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func writeIdentifyOverride(t *testing.T, dir string, description string) {
+	t.Helper()
+	content := "PoolDescription = \"" + description + "\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "identify.en.toml"), []byte(content), 0644); err != nil {
+		t.Fatalf("unable to write override: %s", err.Error())
+	}
+}
+
+func TestIdentifyETagReloadSequence(t *testing.T) {
+	svc, router, dir := newStaticTestServer(t)
+
+	first := staticRequest(router, "/identify", "", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || first.Header().Get("Last-Modified") == "" {
+		t.Fatalf("first request = %d etag %q last modified %q", first.Code, etag, first.Header().Get("Last-Modified"))
+	}
+	if cc := first.Header().Get("Cache-Control"); cc != "public, max-age=300" {
+		t.Errorf("Cache-Control = %q", cc)
+	}
+
+	cached := staticRequest(router, "/identify", "If-None-Match", etag)
+	if cached.Code != http.StatusNotModified || cached.Body.Len() != 0 {
+		t.Fatalf("revalidation = %d with %d bytes, want 304 and no body", cached.Code, cached.Body.Len())
+	}
+
+	writeIdentifyOverride(t, dir, "Now featuring expanded e-audiobook offerings!")
+	if err := svc.reload(); err != nil {
+		t.Fatalf("reload failed: %s", err.Error())
+	}
+
+	reloaded := staticRequest(router, "/identify", "If-None-Match", etag)
+	if reloaded.Code != http.StatusOK {
+		t.Fatalf("request after reload = %d, want 200", reloaded.Code)
+	}
+	newETag := reloaded.Header().Get("ETag")
+	if newETag == etag {
+		t.Errorf("ETag %s did not change after reload", etag)
+	}
+	var identity struct {
+		Description string `json:"description"`
+	}
+	decodeTestJSON(t, reloaded, &identity)
+	if identity.Description != "Now featuring expanded e-audiobook offerings!" {
+		t.Errorf("description = %q", identity.Description)
+	}
+
+	if again := staticRequest(router, "/identify", "If-None-Match", newETag); again.Code != http.StatusNotModified {
+		t.Errorf("revalidation after reload = %d, want 304", again.Code)
+	}
+}
+
+func TestProvidersLastModifiedReloadSequence(t *testing.T) {
+	svc, router, dir := newStaticTestServer(t)
+
+	first := staticRequest(router, "/api/providers", "", "")
+	lastModified := first.Header().Get("Last-Modified")
+	if first.Code != http.StatusOK || lastModified == "" {
+		t.Fatalf("first request = %d last modified %q", first.Code, lastModified)
+	}
+	if cached := staticRequest(router, "/api/providers", "If-Modified-Since", lastModified); cached.Code != http.StatusNotModified {
+		t.Fatalf("revalidation = %d, want 304", cached.Code)
+	}
+
+	// a reload in the same second must still move Last-Modified forward
+	writeIdentifyOverride(t, dir, "Updated")
+	if err := svc.reload(); err != nil {
+		t.Fatalf("reload failed: %s", err.Error())
+	}
+	reloaded := staticRequest(router, "/api/providers", "If-Modified-Since", lastModified)
+	if reloaded.Code != http.StatusOK {
+		t.Fatalf("request after reload = %d, want 200", reloaded.Code)
+	}
+	if reloaded.Header().Get("Last-Modified") == lastModified {
+		t.Errorf("Last-Modified %s did not change after reload", lastModified)
+	}
+	if again := staticRequest(router, "/api/providers", "If-Modified-Since", reloaded.Header().Get("Last-Modified")); again.Code != http.StatusNotModified {
+		t.Errorf("revalidation after reload = %d, want 304", again.Code)
+	}
+}
+
+func TestMalformedReloadKeepsValidators(t *testing.T) {
+	svc, router, dir := newStaticTestServer(t)
+	first := staticRequest(router, "/identify", "", "")
+	if err := os.WriteFile(filepath.Join(dir, "identify.en.toml"), []byte("PoolDescription = "), 0644); err != nil {
+		t.Fatalf("unable to write override: %s", err.Error())
+	}
+	if err := svc.reload(); err == nil {
+		t.Fatalf("reload of a malformed override succeeded")
+	}
+	if cached := staticRequest(router, "/identify", "If-None-Match", first.Header().Get("ETag")); cached.Code != http.StatusNotModified {
+		t.Errorf("revalidation after failed reload = %d, want 304", cached.Code)
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`*`, true},
+		{`"xyz"`, false},
+		{`abc`, false},
+	}
+	for _, tc := range tests {
+		if got := etagMatches(tc.header, `"abc"`); got != tc.want {
+			t.Errorf("etagMatches(%q) = %t, want %t", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestResultDigest(t *testing.T) {
+	base := func() *v4api.PoolResult {
+		return &v4api.PoolResult{ElapsedMS: 12, ContentLanguage: "en-US", Confidence: "high",
+			Pagination: v4api.Pagination{Start: 0, Rows: 1, Total: 1},
+			Sort:       v4api.SortOrder{SortID: v4api.SortRelevance.String(), Order: "desc"},
+			Groups:     []v4api.Group{{Value: "1001", Count: 1}}}
+	}
+	digest := resultDigest(base())
+	timing := base()
+	timing.ElapsedMS = 999
+	if resultDigest(timing) != digest {
+		t.Errorf("elapsed time changed the digest")
+	}
+	changes := map[string]func(res *v4api.PoolResult){
+		"language":   func(res *v4api.PoolResult) { res.ContentLanguage = "es" },
+		"pagination": func(res *v4api.PoolResult) { res.Pagination.Total = 2 },
+		"sort":       func(res *v4api.PoolResult) { res.Sort.Order = "asc" },
+		"groups":     func(res *v4api.PoolResult) { res.Groups[0].Value = "1002" },
+		"confidence": func(res *v4api.PoolResult) { res.Confidence = "low" },
+		"warnings":   func(res *v4api.PoolResult) { res.Warnings = []string{"approximate"} },
+	}
+	for name, change := range changes {
+		res := base()
+		change(res)
+		if resultDigest(res) == digest {
+			t.Errorf("changing the %s did not change the digest", name)
+		}
+	}
+}
+
+func TestConditionalSearch(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "Cats")))
+	svc := newTestService(t, sierra)
+	router := gin.New()
+	router.POST("/api/search", svc.authMiddleware, svc.search)
+	token := mintTestToken(t, v4jwt.User)
+	send := func(lang string, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/search", strings.NewReader(`{"query":"keyword: {cats}"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept-Language", lang)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := send("en-US", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || first.Header().Get("X-Validated-At") == "" {
+		t.Fatalf("first search = %d etag %q validated %q", first.Code, etag, first.Header().Get("X-Validated-At"))
+	}
+	if vary := first.Header().Get("Vary"); vary != "Accept-Language" {
+		t.Errorf("Vary = %q", vary)
+	}
+
+	repeat := send("en-US", etag)
+	if repeat.Code != http.StatusNotModified || repeat.Body.Len() != 0 {
+		t.Errorf("repeat search = %d with %d bytes, want 304 and no body", repeat.Code, repeat.Body.Len())
+	}
+	if repeat.Header().Get("X-Validated-At") == "" || repeat.Header().Get("ETag") != etag {
+		t.Errorf("304 headers = %v", repeat.Header())
+	}
+
+	// the same records in another language are a different result
+	spanish := send("es", etag)
+	if spanish.Code != http.StatusOK || spanish.Header().Get("ETag") == etag {
+		t.Errorf("Spanish search = %d etag %q, want 200 with a new etag", spanish.Code, spanish.Header().Get("ETag"))
+	}
+
+	// every conditional search is validated against Sierra
+	if got := sierra.count("bibs/search"); got != 3 {
+		t.Errorf("Sierra searched %d times, want 3", got)
+	}
+}
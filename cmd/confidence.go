@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+)
+
+// confidenceCheckCount is the number of leading results compared against the query
+const confidenceCheckCount = 3
+
+// closeMatchOverlap is the minimum word overlap for a close title match
+const closeMatchOverlap = 0.8
+
+// dominantRelevanceRatio is how many times the second best Sierra relevance score the
+// best must be for the top hit to stand out
+const dominantRelevanceRatio = 2
+
+// leadingArticles are dropped from the start of titles and queries before comparison
+var leadingArticles = map[string]bool{"a": true, "an": true, "the": true, "el": true, "la": true, "los": true, "las": true}
+
+// normalizeTitle lowercases, strips punctuation and drops a leading article
+func normalizeTitle(title string) string {
+	words := strings.Fields(normalizeMatchText(title))
+	if len(words) > 1 && leadingArticles[words[0]] {
+		words = words[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// simpleQueryText returns the text of a query that is a single title or keyword clause
+// of plain words or phrases with no boolean operators. False is returned otherwise.
+func simpleQueryText(tokens []queryToken) (string, bool) {
+	if len(tokens) < 3 || tokens[0].Type != tokenField || (tokens[0].Value != "title" && tokens[0].Value != "keyword") {
+		return "", false
+	}
+	if tokens[1].Type != tokenOpen || tokens[len(tokens)-1].Type != tokenClose {
+		return "", false
+	}
+	parts := make([]string, 0)
+	for _, tok := range tokens[2 : len(tokens)-1] {
+		if tok.Type != tokenWord && tok.Type != tokenPhrase {
+			return "", false
+		}
+		if tok.Value == "AND" || tok.Value == "OR" || tok.Value == "NOT" {
+			return "", false
+		}
+		parts = append(parts, tok.Value)
+	}
+	return strings.Join(parts, " "), len(parts) > 0
+}
+
+// wordOverlap returns the share of words in the longer of two texts that appear in both
+func wordOverlap(a string, b string) float64 {
+	aWords := strings.Fields(a)
+	bWords := strings.Fields(b)
+	if len(aWords) == 0 || len(bWords) == 0 {
+		return 0
+	}
+	inA := make(map[string]bool)
+	for _, w := range aWords {
+		inA[w] = true
+	}
+	common := 0
+	for _, w := range bWords {
+		if inA[w] {
+			common++
+			delete(inA, w)
+		}
+	}
+	longest := len(aWords)
+	if len(bWords) > longest {
+		longest = len(bWords)
+	}
+	return float64(common) / float64(longest)
+}
+
+// bibTitle returns the 245$a title of a bib, falling back to the Sierra default title
+func bibTitle(bib *JMRLBib) string {
+	if vals := getVarField(&bib.VarFields, "245", "a"); len(vals) > 0 {
+		return vals[0]
+	}
+	return bib.Title
+}
+
+// searchConfidence rates how well the results match a simple title or keyword query.
+// An exact (normalized) title match on a single result is "exact", an exact or close
+// match among the first few results is "high", any other hits are "medium" and no hits
+// are "low". Other queries, and simple ones without a title match, are "high" when the
+// Sierra relevance score of the best hit stands out from the rest.
+func searchConfidence(tokens []queryToken, entries []JMRLEntry, total int) string {
+	if total == 0 {
+		return "low"
+	}
+	text, ok := simpleQueryText(tokens)
+	if ok {
+		query := normalizeTitle(text)
+		for i := 0; i < len(entries) && i < confidenceCheckCount; i++ {
+			title := normalizeTitle(bibTitle(&entries[i].Bib))
+			if title == "" {
+				continue
+			}
+			if title == query {
+				if total == 1 {
+					return "exact"
+				}
+				return "high"
+			}
+			if wordOverlap(title, query) >= closeMatchOverlap {
+				return "high"
+			}
+		}
+	}
+	if dominantRelevance(entries) {
+		return "high"
+	}
+	return "medium"
+}
+
+// dominantRelevance reports whether the best Sierra relevance score among the entries is
+// at least dominantRelevanceRatio times the next best. Entries may be in any sort order;
+// a lone hit or entries without scores (browse, direct lookups) have nothing to stand
+// out from.
+func dominantRelevance(entries []JMRLEntry) bool {
+	var best, next float32
+	for _, entry := range entries {
+		if entry.Relevance > best {
+			best, next = entry.Relevance, best
+		} else if entry.Relevance > next {
+			next = entry.Relevance
+		}
+	}
+	return next > 0 && best >= next*dominantRelevanceRatio
+}
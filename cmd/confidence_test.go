@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// titledEntries returns search entries for bibs with the 245$a titles, in order
+func titledEntries(titles ...string) []JMRLEntry {
+	out := make([]JMRLEntry, 0, len(titles))
+	for idx, title := range titles {
+		out = append(out, JMRLEntry{Bib: testBib(string(rune('a'+idx)), title)})
+	}
+	return out
+}
+
+func TestNormalizeTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"The Secret Garden /", "secret garden"},
+		{"A tale of two cities :", "tale of two cities"},
+		{"El jardín secreto", "jardín secreto"},
+		{"Los   Ángeles!", "ángeles"},
+		{"The", "the"},
+		{"Theory of everything", "theory of everything"},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		if got := normalizeTitle(tc.title); got != tc.want {
+			t.Errorf("normalizeTitle(%q) = %q, want %q", tc.title, got, tc.want)
+		}
+	}
+}
+
+func TestSearchConfidence(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		entries []JMRLEntry
+		total   int
+		want    string
+	}{
+		{"no hits", `title: {secret garden}`, titledEntries(), 0, "low"},
+		{"exact single", `title: {The Secret Garden}`, titledEntries("The secret garden /"), 1, "exact"},
+		{"exact without article", `title: {secret garden}`, titledEntries("The Secret Garden."), 1, "exact"},
+		{"exact phrase", `keyword: {"the secret garden"}`, titledEntries("The secret garden /"), 1, "exact"},
+		{"exact among many", `title: {The Secret Garden}`, titledEntries("Gardening", "The secret garden /"), 40, "high"},
+		{"long subtitle", `title: {harry potter and the chamber of secrets}`,
+			titledEntries("Harry Potter and the chamber of secrets : the illustrated edition"), 1, "medium"},
+		{"close overlap", `title: {tale of two great cities}`, titledEntries("A tale of two cities"), 1, "high"},
+		{"too far down", `title: {secret garden}`, titledEntries("Gardens", "Garden design", "Secrets", "The secret garden"), 4, "medium"},
+		{"weak", `title: {secret garden}`, titledEntries("Gardening for beginners"), 1, "medium"},
+		{"author query", `author: {burnett}`, titledEntries("The secret garden"), 1, "medium"},
+		{"boolean query", `title: {secret AND garden}`, titledEntries("The secret garden"), 1, "medium"},
+		{"two clauses", `title: {secret garden} AND author: {burnett}`, titledEntries("The secret garden"), 1, "medium"},
+	}
+	for _, tc := range tests {
+		if got := searchConfidence(tokenizeQuery(tc.query), tc.entries, tc.total); got != tc.want {
+			t.Errorf("%s: searchConfidence = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// scoredEntries returns titled search entries with the Sierra relevance scores, in order
+func scoredEntries(scores ...float32) []JMRLEntry {
+	out := make([]JMRLEntry, 0, len(scores))
+	for idx, score := range scores {
+		out = append(out, JMRLEntry{Relevance: score, Bib: testBib(string(rune('a'+idx)), "Untitled")})
+	}
+	return out
+}
+
+func TestSearchConfidenceRelevance(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		entries []JMRLEntry
+		total   int
+		want    string
+	}{
+		{"dominant score", `author: {burnett}`, scoredEntries(12.5, 3.25, 1), 40, "high"},
+		{"dominant score out of order", `author: {burnett}`, scoredEntries(3, 1, 6), 40, "high"},
+		{"exactly twice", `author: {burnett}`, scoredEntries(4, 2), 2, "high"},
+		{"close scores", `author: {burnett}`, scoredEntries(5, 4, 1), 40, "medium"},
+		{"tied top scores", `author: {burnett}`, scoredEntries(8, 8, 1), 40, "medium"},
+		{"lone hit", `author: {burnett}`, scoredEntries(5), 1, "medium"},
+		{"no scores", `author: {burnett}`, scoredEntries(0, 0), 2, "medium"},
+		{"simple query without title match", `keyword: {gardening}`, scoredEntries(9, 2), 2, "high"},
+		{"simple query with close scores", `keyword: {gardening}`, scoredEntries(3, 2), 2, "medium"},
+		{"no hits", `author: {burnett}`, scoredEntries(), 0, "low"},
+	}
+	for _, tc := range tests {
+		if got := searchConfidence(tokenizeQuery(tc.query), tc.entries, tc.total); got != tc.want {
+			t.Errorf("%s: searchConfidence = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// bibs without a 245 are compared on the Sierra title
+func TestSearchConfidenceDefaultTitle(t *testing.T) {
+	entries := []JMRLEntry{{Bib: JMRLBib{ID: "1001", Title: "The Secret Garden"}}}
+	if got := searchConfidence(tokenizeQuery(`title: {secret garden}`), entries, 1); got != "exact" {
+		t.Errorf("searchConfidence = %q, want exact", got)
+	}
+}
+
+func TestWordOverlap(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"secret garden", "secret garden", 1},
+		{"secret garden", "garden secret", 1},
+		{"tale of two cities", "tale of two great cities", 0.8},
+		{"the the the", "the", 1.0 / 3},
+		{"", "garden", 0},
+	}
+	for _, tc := range tests {
+		if got := wordOverlap(tc.a, tc.b); got != tc.want {
+			t.Errorf("wordOverlap(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestSearchReportsConfidence(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "The cats of Charlottesville /")))
+	router := newRouter(newTestService(t, sierra))
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"title: {cats of charlottesville}", "exact"},
+		{"keyword: {cats}", "medium"},
+	}
+	for _, tc := range tests {
+		var resp v4api.PoolResult
+		decodeTestJSON(t, apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"`+tc.query+`"}`), &resp)
+		if resp.Confidence != tc.want {
+			t.Errorf("%s: confidence = %q, want %q", tc.query, resp.Confidence, tc.want)
+		}
+	}
+}
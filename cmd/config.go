@@ -7,11 +7,41 @@ import (
 
 // ServiceConfig defines all of the JRML pool configuration parameters
 type ServiceConfig struct {
-	API       string
-	APIKey    string
-	APISecret string
-	Port      int
-	JWTKey    string
+	API             string
+	APIKey          string
+	APISecret       string
+	Port            int
+	JWTKey          string
+	BranchGeo       string
+	Placeholders    string
+	SummaryLength   int
+	OverrideDir     string
+	LocationCfg     string
+	ZeroResultsMax  int
+	SoonDays        int
+	MaxSubjects     int
+	MonitorCIDRs    string
+	MonitorSecret   string
+	MaxConcurrent   int
+	I18NDir         string
+	SlowMS          int
+	FeedOrigins     string
+	CoverURL        string
+	BrowseWildcard  bool
+	StatsHours      int
+	FieldCfg        string
+	StripParams     string
+	SuppressFields  string
+	SuppressRaw     bool
+	AdaptiveMS      int
+	FaultInjection  bool
+	OnlineTypes     string
+	PairLargePrint  bool
+	FoldDiacritics  bool
+	TrendingHours   int
+	MaxQueryLength  int
+	BriefCodes      string
+	RecordCacheSecs int
 }
 
 // LoadConfiguration will load the service configuration from env/cmdline
@@ -24,6 +54,36 @@ func LoadConfiguration() *ServiceConfig {
 	flag.StringVar(&cfg.APIKey, "apikey", "", "Key you access the JRML API")
 	flag.StringVar(&cfg.APISecret, "apisecret", "", "Secret to access the JRML API")
 	flag.StringVar(&cfg.JWTKey, "jwtkey", "", "JWT signature key")
+	flag.StringVar(&cfg.Placeholders, "placeholderlocs", defaultPlaceholderLocations, "Comma separated Sierra location codes that are not real branches")
+	flag.IntVar(&cfg.SummaryLength, "summarylen", 300, "Max characters of summary/contents in search results (0 for no limit)")
+	flag.StringVar(&cfg.OverrideDir, "overridedir", "", "Optional directory of identify.<lang>.toml files overriding identify strings")
+	flag.StringVar(&cfg.LocationCfg, "locationcfg", "", "Optional TOML location configuration file (redacted location codes)")
+	flag.IntVar(&cfg.ZeroResultsMax, "zeroresults", 0, "Number of scrubbed zero result queries to retain for collection development (0 disables)")
+	flag.IntVar(&cfg.SoonDays, "soondays", 7, "Items due back within this many days are classed as available soon")
+	flag.IntVar(&cfg.MaxSubjects, "maxsubjects", 25, "Max subject headings emitted per record (0 for no limit)")
+	flag.StringVar(&cfg.MonitorCIDRs, "monitorcidrs", "", "Optional comma separated CIDRs allowed to send signed monitoring probes")
+	flag.StringVar(&cfg.MonitorSecret, "monitorsecret", "", "Secret used to sign monitoring probes")
+	flag.IntVar(&cfg.MaxConcurrent, "maxconcurrent", 10, "Max concurrent Sierra requests; type-ahead suggestions may use at most half")
+	flag.StringVar(&cfg.I18NDir, "i18ndir", "./i18n", "Directory of active.<lang>.toml message files added to the embedded English messages")
+	flag.IntVar(&cfg.SlowMS, "slowms", 2000, "Searches slower than this many milliseconds log a timing summary (0 disables)")
+	flag.StringVar(&cfg.FeedOrigins, "feedorigins", "", "Optional comma separated origins allowed to embed the subject feed (default all)")
+	flag.StringVar(&cfg.CoverURL, "coverurl", "", "Optional cover image URL template for the subject feed; {isbn} is replaced with the ISBN")
+	flag.BoolVar(&cfg.BrowseWildcard, "browsewildcard", false, "Answer empty queries with a wildcard search instead of recent additions")
+	flag.IntVar(&cfg.StatsHours, "statshours", 24, "Hours between collection size statistics refreshes (0 disables)")
+	flag.StringVar(&cfg.FieldCfg, "fieldcfg", "", "Optional TOML file of per-field visibility/display overrides")
+	flag.StringVar(&cfg.SuppressFields, "suppress", defaultSuppressedFields, "Comma separated MARC tags (590), tag$subfield (500$a) or record field names never exposed")
+	flag.BoolVar(&cfg.SuppressRaw, "suppressraw", false, "Also remove suppressed MARC fields from the staff raw bib view")
+	flag.IntVar(&cfg.AdaptiveMS, "adaptivems", 0, "Sierra search p95 latency (ms) above which page sizes are reduced (0 disables)")
+	flag.StringVar(&cfg.StripParams, "stripparams", defaultStripParams, "Comma separated tracking query parameters removed from access URLs")
+	flag.BoolVar(&cfg.FaultInjection, "faultinjection", false, "Allow staff to inject Sierra faults for resilience testing. Never set in production")
+	flag.StringVar(&cfg.OnlineTypes, "onlinetypes", defaultOnlineMaterialTypes, "Comma separated Sierra material type codes of electronic resources")
+	flag.BoolVar(&cfg.PairLargePrint, "pairlargeprint", false, "Allow ungrouped searches to pair large print bibs with their regular print sibling")
+	flag.BoolVar(&cfg.FoldDiacritics, "folddiacritics", false, "Remove diacritics from search terms before they are sent to Sierra")
+	flag.IntVar(&cfg.TrendingHours, "trendinghours", 0, "Half-life in hours of the return and click counts behind /api/trending (0 disables trending and beacons)")
+	flag.IntVar(&cfg.MaxQueryLength, "maxquerylen", defaultMaxQueryLength, "Longest search query in characters (0 for no limit)")
+	flag.StringVar(&cfg.BriefCodes, "briefcodes", "", "Comma separated Sierra bib level or BCODE3 codes of brief on-the-fly records")
+	flag.IntVar(&cfg.RecordCacheSecs, "recordcachesecs", 0, "Seconds Sierra bib and item responses are cached for records and availability (0 disables)")
+	flag.StringVar(&cfg.BranchGeo, "branchgeo", "", "Optional TOML file mapping JMRL branch codes to lat/long")
 
 	flag.Parse()
 
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// maxAddedDays is the longest date added window a search may request
+const maxAddedDays = 3650
+
+// addedFilter restricts results to bibs added to the catalog on or after Since. Only the
+// bibs list endpoint takes a createdDate range, so a recent additions browse is limited
+// by Sierra and a text search is filtered by the pool instead.
+type addedFilter struct {
+	Since time.Time
+}
+
+// newAddedFilter returns the filter for bibs added in the last days days. Zero days is
+// an inactive filter.
+func newAddedFilter(days int, now time.Time) addedFilter {
+	if days <= 0 {
+		return addedFilter{}
+	}
+	return addedFilter{Since: now.AddDate(0, 0, -days).UTC().Truncate(24 * time.Hour)}
+}
+
+// active returns true if the filter restricts results
+func (af addedFilter) active() bool {
+	return af.Since.IsZero() == false
+}
+
+// matches returns true if the bib was added on or after the filter date. Bibs with no
+// usable created date never match.
+func (af addedFilter) matches(bib *JMRLBib) bool {
+	created, ok := bibCreatedDate(bib)
+	return ok && created.Before(af.Since) == false
+}
+
+// bibCreatedDate parses the Sierra created date of a bib
+func bibCreatedDate(bib *JMRLBib) (time.Time, bool) {
+	val := strings.TrimSpace(bib.CreatedDate)
+	if val == "" {
+		return time.Time{}, false
+	}
+	if created, err := time.Parse(time.RFC3339, val); err == nil {
+		return created, true
+	}
+	if created, err := time.Parse("2006-01-02", val); err == nil {
+		return created, true
+	}
+	return time.Time{}, false
+}
+
+// getDateAddedFields returns the localized date the bib was added to the catalog and its
+// hidden date_added_iso companion. Nothing is returned without a usable created date.
+func getDateAddedFields(bib *JMRLBib, localizer *i18n.Localizer) []v4api.RecordField {
+	created, ok := bibCreatedDate(bib)
+	if ok == false {
+		return nil
+	}
+	return getDateFields(localizer, "date_added", "Date Added", created.UTC(), "detailed")
+}
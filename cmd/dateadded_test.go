@@ -0,0 +1,231 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+func TestNewAddedFilter(t *testing.T) {
+	now := time.Date(2024, 3, 15, 22, 30, 0, 0, time.FixedZone("EDT", -4*3600))
+	tests := []struct {
+		days   int
+		active bool
+		since  string
+	}{
+		{0, false, ""},
+		{-5, false, ""},
+		{1, true, "2024-03-15"},
+		{30, true, "2024-02-15"},
+		{maxAddedDays, true, "2014-03-19"},
+	}
+	for _, tc := range tests {
+		af := newAddedFilter(tc.days, now)
+		if af.active() != tc.active {
+			t.Errorf("newAddedFilter(%d) active = %t, want %t", tc.days, af.active(), tc.active)
+		}
+		if tc.active && af.Since.Format("2006-01-02") != tc.since {
+			t.Errorf("newAddedFilter(%d) since = %s, want %s", tc.days, af.Since.Format("2006-01-02"), tc.since)
+		}
+	}
+}
+
+func TestAddedFilterMatches(t *testing.T) {
+	af := newAddedFilter(30, time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC))
+	tests := []struct {
+		created string
+		want    bool
+	}{
+		{"2024-03-01T10:00:00Z", true},
+		{"2024-02-14T00:00:00Z", true},
+		{"2024-02-13T23:59:59Z", false},
+		{"2024-02-13T20:00:00-05:00", true},
+		{"", false},
+		{"not a date", false},
+	}
+	for _, tc := range tests {
+		bib := JMRLBib{ID: "1001", CreatedDate: tc.created}
+		if got := af.matches(&bib); got != tc.want {
+			t.Errorf("matches(%q) = %t, want %t", tc.created, got, tc.want)
+		}
+	}
+}
+
+func TestDateAddedFields(t *testing.T) {
+	svc := newTestService(t, nil)
+	tests := []struct {
+		created string
+		lang    string
+		want    string
+		iso     string
+	}{
+		{"2024-01-02T15:04:05Z", "en-US", "January 2, 2024", "2024-01-02T15:04:05Z"},
+		{"2024-01-02T23:30:00-05:00", "en-US", "January 3, 2024", "2024-01-03T04:30:00Z"},
+		{" 2024-01-02 ", "es", "2 de enero de 2024", "2024-01-02T00:00:00Z"},
+		{"", "en-US", "", ""},
+		{"01/02/2024", "en-US", "", ""},
+	}
+	for _, tc := range tests {
+		bib := JMRLBib{ID: "1001", CreatedDate: tc.created}
+		fields := getDateAddedFields(&bib, testLocalizer(svc, tc.lang))
+		if tc.want == "" {
+			if len(fields) > 0 {
+				t.Errorf("getDateAddedFields(%q) = %+v, want none", tc.created, fields)
+			}
+			continue
+		}
+		if got := fieldValues(fields, "date_added"); len(got) != 1 || got[0] != tc.want {
+			t.Errorf("getDateAddedFields(%q, %s) date_added = %q, want [%q]", tc.created, tc.lang, got, tc.want)
+		}
+		if got := fieldValues(fields, "date_added_iso"); len(got) != 1 || got[0] != tc.iso {
+			t.Errorf("getDateAddedFields(%q) date_added_iso = %q, want [%q]", tc.created, got, tc.iso)
+		}
+		for _, f := range fields {
+			want := map[string]string{"date_added": "detailed", "date_added_iso": "hidden"}[f.Name]
+			if f.Visibility != want {
+				t.Errorf("%s visibility = %q, want %q", f.Name, f.Visibility, want)
+			}
+		}
+	}
+}
+
+// searchParams returns the query params of the Sierra requests to the path
+func searchParams(sierra *fakeSierra, relPath string) []url.Values {
+	out := make([]url.Values, 0)
+	for _, raw := range sierra.requestURLs() {
+		if u, _ := url.Parse(raw); u.Path == fakeSierraBase+"/"+relPath {
+			out = append(out, u.Query())
+		}
+	}
+	return out
+}
+
+// addedDaysSierra returns a fake Sierra whose search finds a recent and an old bib among
+// total hits, and has bib 1234567
+func addedDaysSierra(t *testing.T, total int) *fakeSierra {
+	recent := testBib("1001", "Cats")
+	recent.CreatedDate = time.Now().UTC().AddDate(0, 0, -3).Format(time.RFC3339)
+	old := testBib("1002", "Old cats")
+	old.CreatedDate = time.Now().UTC().AddDate(-2, 0, 0).Format(time.RFC3339)
+	sierra := newFakeSierra(t)
+	sierra.handle("bibs/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("offset") != "" && r.URL.Query().Get("offset") != "0" {
+			writeTestJSON(w, http.StatusOK, searchResult(total))
+			return
+		}
+		writeTestJSON(w, http.StatusOK, searchResult(total, recent, old))
+	})
+	sierra.handleJSON("bibs/1234567", http.StatusOK, testBib("1234567", "Direct"))
+	return sierra
+}
+
+// Sierra text search has no created date restriction, so added_days is applied to the
+// search results by the pool and the total is estimated unless every hit was scanned
+func TestAddedDaysIsPostFiltered(t *testing.T) {
+	tests := []struct {
+		name      string
+		total     int
+		body      string
+		wantTotal int
+		warning   string
+	}{
+		{"every hit scanned", 2, `{"query":"keyword: {cats}","added_days":30}`, 1, ""},
+		{"estimated", 400, `{"query":"keyword: {cats}","added_days":30}`, 200,
+			"Date added filtering is applied by the pool; the result total is an estimate"},
+		{"newest first", 2, `{"query":"keyword: {cats}","sort":{"sort_id":"SortNewest","order":"desc"},"added_days":30}`, 1, ""},
+		{"bib number is searched", 2, `{"query":"keyword: {b1234567}","added_days":30}`, 1, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sierra := addedDaysSierra(t, tc.total)
+			router := newRouter(newTestService(t, sierra))
+			rec := apiRequest(t, router, http.MethodPost, "/api/search", tc.body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var resp v4api.PoolResult
+			decodeTestJSON(t, rec, &resp)
+			if resp.Pagination.Total != tc.wantTotal {
+				t.Errorf("total = %d, want %d", resp.Pagination.Total, tc.wantTotal)
+			}
+			if len(resp.Groups) != 1 || len(resp.Groups[0].Records) != 1 {
+				t.Fatalf("groups = %+v, want only the recent bib", resp.Groups)
+			}
+			if got := fieldValues(resp.Groups[0].Records[0].Fields, "id"); len(got) != 1 || got[0] != "1001" {
+				t.Errorf("record ids = %v, want [1001]", got)
+			}
+			for _, params := range searchParams(sierra, "bibs/search") {
+				if params.Has("createdDate") {
+					t.Errorf("text search sent createdDate %q", params.Get("createdDate"))
+				}
+			}
+			estimated := false
+			for _, warn := range resp.Warnings {
+				if strings.Contains(warn, "the result total is an estimate") {
+					estimated = true
+					if warn != tc.warning {
+						t.Errorf("warning = %q, want %q", warn, tc.warning)
+					}
+				}
+			}
+			if estimated != (tc.warning != "") {
+				t.Errorf("warnings = %q, want estimate warning %t", resp.Warnings, tc.warning != "")
+			}
+			if n := sierra.count("bibs/1234567"); n != 0 {
+				t.Errorf("bib fetched directly %d times with added_days set, want 0", n)
+			}
+		})
+	}
+}
+
+// the estimate warning names only the filters the pool applied
+func TestPostFilterWarning(t *testing.T) {
+	onShelf := availabilityFilter{OnShelf: true}
+	tests := []struct {
+		name  string
+		xlate searchTranslation
+		want  string
+	}{
+		{"availability", searchTranslation{AvailFilter: onShelf}, "Availability filtering is applied by the pool; the result total is an estimate"},
+		{"date added", searchTranslation{AddedFilter: newAddedFilter(7, time.Now())}, "Date added filtering is applied by the pool; the result total is an estimate"},
+		{"both", searchTranslation{AvailFilter: onShelf, AddedFilter: newAddedFilter(7, time.Now())},
+			"Availability and date added filtering are applied by the pool; the result total is an estimate"},
+	}
+	for _, tc := range tests {
+		if got := tc.xlate.postFilterWarning(); got != tc.want {
+			t.Errorf("%s: warning = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+	browse := searchTranslation{AddedFilter: newAddedFilter(7, time.Now()), RecentBrowse: true}
+	if browse.postFiltered() {
+		t.Errorf("recent additions browse is post filtered, want the list endpoint window")
+	}
+}
+
+// a search without added_days has no date range, and an empty query browses the list
+// endpoint with the requested window
+func TestAddedDaysBrowseAndDefault(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "Cats")))
+	sierra.handleJSON("bibs", http.StatusOK, JMRLBibList{Total: 1, Entries: []JMRLBib{testBib("1002", "New")}})
+	router := newRouter(newTestService(t, sierra))
+
+	if rec := apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"keyword: {cats}"}`); rec.Code != http.StatusOK {
+		t.Fatalf("search status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if sent := searchParams(sierra, "bibs/search"); len(sent) != 1 || sent[0].Has("createdDate") {
+		t.Errorf("search without added_days sent %v, want no createdDate", sent)
+	}
+
+	if rec := apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"keyword: {}","added_days":7}`); rec.Code != http.StatusOK {
+		t.Fatalf("browse status = %d: %s", rec.Code, rec.Body.String())
+	}
+	want := createdDateRange(time.Now().UTC().AddDate(0, 0, -7).Format("2006-01-02T00:00:00Z"))
+	if sent := searchParams(sierra, "bibs"); len(sent) != 1 || sent[0].Get("createdDate") != want {
+		t.Errorf("browse list requests %v, want createdDate %s", sent, want)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sierraYearIndex is the Sierra text search index for the publication year
+const sierraYearIndex = "y"
+
+// earliestYear is where open ended BEFORE ranges start. The year index holds 4 digit years.
+const earliestYear = 1000
+
+// V4 date clause forms: a single date, BEFORE date, AFTER date or date TO date.
+// Dates may be yyyy, yyyy-mm or yyyy-mm-dd; only the year is significant to Sierra
+var singleDatePattern = regexp.MustCompile(`^(\d{4})(-\d{2}){0,2}$`)
+var rangeDatePattern = regexp.MustCompile(`^(\d{4})(?:-\d{2}){0,2}\s+TO\s+(\d{4})(?:-\d{2}){0,2}$`)
+var afterDatePattern = regexp.MustCompile(`^AFTER\s+(\d{4})(?:-\d{2}){0,2}$`)
+var beforeDatePattern = regexp.MustCompile(`^BEFORE\s+(\d{4})(?:-\d{2}){0,2}$`)
+
+// yearSpanPattern is the simple yyyy-yyyy range accepted in published clauses
+var yearSpanPattern = regexp.MustCompile(`^(\d{4})\s*-\s*(\d{4})$`)
+
+// unsupportedDateError is returned for date forms Sierra can not express
+type unsupportedDateError struct {
+	Clause string
+	Reason string
+}
+
+func (e *unsupportedDateError) Error() string {
+	return fmt.Sprintf("date restriction [%s] is not supported: %s", e.Clause, e.Reason)
+}
+
+// translateDateClause converts the content of a V4 date clause into a Sierra publication
+// year expression. AFTER runs through next year and BEFORE starts at earliestYear.
+// EX: 1990 TO 1992 => y:(1990 OR 1991 OR 1992)
+func translateDateClause(content string) (string, error) {
+	clause := strings.TrimSpace(content)
+	clause = strings.Trim(clause, `"`)
+	var startYear, endYear int
+	if m := singleDatePattern.FindStringSubmatch(clause); m != nil {
+		startYear, _ = strconv.Atoi(m[1])
+		endYear = startYear
+	} else if m := rangeDatePattern.FindStringSubmatch(clause); m != nil {
+		startYear, _ = strconv.Atoi(m[1])
+		endYear, _ = strconv.Atoi(m[2])
+	} else if m := afterDatePattern.FindStringSubmatch(clause); m != nil {
+		startYear, _ = strconv.Atoi(m[1])
+		startYear++
+		endYear = time.Now().Year() + 1
+	} else if m := beforeDatePattern.FindStringSubmatch(clause); m != nil {
+		endYear, _ = strconv.Atoi(m[1])
+		endYear--
+		if endYear < earliestYear {
+			return "", &unsupportedDateError{Clause: clause, Reason: fmt.Sprintf("dates before %d can not be searched", earliestYear)}
+		}
+		startYear = earliestYear
+	} else {
+		return "", &unsupportedDateError{Clause: clause, Reason: "unrecognized date format"}
+	}
+	return yearRangeQuery(clause, startYear, endYear)
+}
+
+// translatePublishedClause converts the content of a V4 published clause into a Sierra
+// publication year expression. It accepts the date clause forms plus yyyy-yyyy ranges.
+// EX: 1995 => y:(1995), 1990-1992 => y:(1990 OR 1991 OR 1992)
+func translatePublishedClause(content string) (string, error) {
+	clause := strings.Trim(strings.TrimSpace(content), `"`)
+	if m := yearSpanPattern.FindStringSubmatch(clause); m != nil {
+		startYear, _ := strconv.Atoi(m[1])
+		endYear, _ := strconv.Atoi(m[2])
+		return yearRangeQuery(clause, startYear, endYear)
+	}
+	return translateDateClause(clause)
+}
+
+// yearRangeQuery builds a Sierra year index query matching any year in the inclusive range
+func yearRangeQuery(clause string, startYear int, endYear int) (string, error) {
+	if endYear < startYear {
+		return "", &unsupportedDateError{Clause: clause, Reason: "range end is before range start"}
+	}
+	return fmt.Sprintf("%s:(%s)", sierraYearIndex, strings.Join(yearRangeTerms(startYear, endYear), " OR ")), nil
+}
+
+// yearRangeTerms covers the inclusive year range with as few terms as possible. Sierra has
+// no range operator for the year index, so whole decades, centuries and millennia become
+// truncated terms and the remaining years are listed. Any 4 digit range needs at most 54.
+// EX: 1989-2001 => 1989 199* 2000 2001
+func yearRangeTerms(startYear int, endYear int) []string {
+	terms := make([]string, 0)
+	for year := startYear; year <= endYear; {
+		size, digits := 1, 0
+		for size < 1000 && year%(size*10) == 0 && year+size*10-1 <= endYear {
+			size *= 10
+			digits++
+		}
+		term := fmt.Sprintf("%04d", year)
+		if digits > 0 {
+			term = term[:len(term)-digits] + sierraTruncation
+		}
+		terms = append(terms, term)
+		year += size
+	}
+	return terms
+}
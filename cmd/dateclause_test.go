@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+func TestYearRangeTerms(t *testing.T) {
+	tests := []struct {
+		start, end int
+		want       []string
+	}{
+		{1995, 1995, []string{"1995"}},
+		{1990, 1992, []string{"1990", "1991", "1992"}},
+		{1990, 1999, []string{"199*"}},
+		{1989, 2001, []string{"1989", "199*", "2000", "2001"}},
+		{1900, 1999, []string{"19*"}},
+		{1000, 1999, []string{"1*"}},
+		{1000, 1499, []string{"10*", "11*", "12*", "13*", "14*"}},
+		{1850, 1999, []string{"185*", "186*", "187*", "188*", "189*", "19*"}},
+	}
+	for _, tc := range tests {
+		if got := yearRangeTerms(tc.start, tc.end); reflect.DeepEqual(got, tc.want) == false {
+			t.Errorf("yearRangeTerms(%d, %d) = %v, want %v", tc.start, tc.end, got, tc.want)
+		}
+	}
+	// the worst case 4 digit range stays bounded
+	if got := yearRangeTerms(1001, 2998); len(got) > 54 {
+		t.Errorf("yearRangeTerms(1001, 2998) returned %d terms", len(got))
+	}
+}
+
+func TestTranslateDateClause(t *testing.T) {
+	tests := []struct {
+		clause string
+		want   string
+	}{
+		{"1995", "y:(1995)"},
+		{"1995-06-01", "y:(1995)"},
+		{`"1995-06"`, "y:(1995)"},
+		{"1990 TO 1992", "y:(1990 OR 1991 OR 1992)"},
+		{"1900-01-01 TO 2010-12-31", "y:(19* OR 200* OR 2010)"},
+		{"BEFORE 1900", "y:(10* OR 11* OR 12* OR 13* OR 14* OR 15* OR 16* OR 17* OR 18*)"},
+		{"BEFORE 1850", "y:(10* OR 11* OR 12* OR 13* OR 14* OR 15* OR 16* OR 17* OR 180* OR 181* OR 182* OR 183* OR 184*)"},
+		{"BEFORE 2000-01-01", "y:(1*)"},
+	}
+	for _, tc := range tests {
+		got, err := translateDateClause(tc.clause)
+		if err != nil {
+			t.Errorf("translateDateClause(%q) failed: %s", tc.clause, err.Error())
+		} else if got != tc.want {
+			t.Errorf("translateDateClause(%q) = %s, want %s", tc.clause, got, tc.want)
+		}
+	}
+
+	// open ended AFTER ranges run through next year
+	nextYear := strconv.Itoa(time.Now().Year() + 1)
+	for _, clause := range []string{"AFTER 2015", "AFTER 1850"} {
+		got, err := translateDateClause(clause)
+		if err != nil {
+			t.Errorf("translateDateClause(%q) failed: %s", clause, err.Error())
+			continue
+		}
+		if strings.HasSuffix(got, nextYear+")") == false {
+			t.Errorf("translateDateClause(%q) = %s, want it to end with %s", clause, got, nextYear)
+		}
+	}
+	if got, _ := translateDateClause("AFTER 1850"); strings.HasPrefix(got, "y:(1851 OR 1852 OR") == false || strings.Contains(got, "19*") == false {
+		t.Errorf("translateDateClause(AFTER 1850) = %s", got)
+	}
+}
+
+func TestUnsupportedDateClauses(t *testing.T) {
+	for _, clause := range []string{"2000 TO 1990", "BEFORE 1000", "BEFORE 0500", "sometime", "1990 TO", "AFTER", "199"} {
+		_, err := translateDateClause(clause)
+		var dateErr *unsupportedDateError
+		if errors.As(err, &dateErr) == false {
+			t.Errorf("translateDateClause(%q) returned %v, want unsupportedDateError", clause, err)
+		}
+	}
+}
+
+func TestTranslatePublishedClause(t *testing.T) {
+	tests := []struct {
+		clause string
+		want   string
+	}{
+		{"1995", "y:(1995)"},
+		{`"1995"`, "y:(1995)"},
+		{"1995-06-01", "y:(1995)"},
+		{"1990-1992", "y:(1990 OR 1991 OR 1992)"},
+		{"1800 - 1899", "y:(18*)"},
+		{"1990 TO 1992", "y:(1990 OR 1991 OR 1992)"},
+		{"BEFORE 1100", "y:(10*)"},
+	}
+	for _, tc := range tests {
+		if got, err := translatePublishedClause(tc.clause); err != nil || got != tc.want {
+			t.Errorf("translatePublishedClause(%q) = %s %v, want %s", tc.clause, got, err, tc.want)
+		}
+	}
+}
+
+func TestUnsupportedPublishedClauses(t *testing.T) {
+	for _, clause := range []string{"circa 1990", "1990s", "1992-1990", "95", "Penguin"} {
+		_, err := translatePublishedClause(clause)
+		var dateErr *unsupportedDateError
+		if errors.As(err, &dateErr) == false {
+			t.Errorf("translatePublishedClause(%q) returned %v, want unsupportedDateError", clause, err)
+		}
+	}
+}
+
+// published clauses search the year index, and ones Sierra can't express are dropped
+// with a warning instead of emptying the whole search
+func TestSearchWithPublishedClause(t *testing.T) {
+	tests := []struct {
+		query   string
+		text    string
+		warning string
+	}{
+		{"published: {1995}", "y:(1995)", ""},
+		{"published: {1990-1992}", "y:(1990 OR 1991 OR 1992)", ""},
+		{"title: {gardening} AND published: {1995}", "t:(gardening) AND y:(1995)", ""},
+		{"title: {gardening} AND published: {circa 1990}", "t:(gardening)", "circa 1990"},
+	}
+	for _, tc := range tests {
+		sierra := newFakeSierra(t)
+		sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "Gardening")))
+		router := newRouter(newTestService(t, sierra))
+		rec := apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"`+tc.query+`","pagination":{"rows":20}}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tc.query, rec.Code, rec.Body.String())
+		}
+		var resp v4api.PoolResult
+		decodeTestJSON(t, rec, &resp)
+		if len(resp.Groups) != 1 {
+			t.Errorf("%s: %d groups, want 1", tc.query, len(resp.Groups))
+		}
+		warned := false
+		for _, warn := range resp.Warnings {
+			warned = warned || (tc.warning != "" && strings.Contains(warn, tc.warning))
+		}
+		if warned != (tc.warning != "") {
+			t.Errorf("%s: warnings = %q, want one naming %q", tc.query, resp.Warnings, tc.warning)
+		}
+		texts := make([]string, 0)
+		for _, raw := range sierra.requestURLs() {
+			if u, _ := url.Parse(raw); u.Path == fakeSierraBase+"/bibs/search" {
+				texts = append(texts, u.Query().Get("text"))
+			}
+		}
+		if len(texts) != 1 || texts[0] != tc.text {
+			t.Errorf("%s: Sierra searches %q, want [%q]", tc.query, texts, tc.text)
+		}
+	}
+}
+
+func TestDateClauseWithKeyword(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`keyword: {cats} AND date: {1990 TO 1992}`, `(cats) AND y:(1990 OR 1991 OR 1992)`},
+		{`date: {BEFORE 1900} AND title: {"civil war"}`, `y:(10* OR 11* OR 12* OR 13* OR 14* OR 15* OR 16* OR 17* OR 18*) AND t:("civil war")`},
+	}
+	for _, tc := range tests {
+		got, err := translateQuery(tokenizeQuery(tc.query))
+		if err != nil || got != tc.want {
+			t.Errorf("translateQuery(%q) = %s %v, want %s", tc.query, got, err, tc.want)
+		}
+	}
+}
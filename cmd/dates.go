@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// formatDisplayDate returns a human readable date using the month names and date
+// layout from the i18n bundle for the localizer language
+func formatDisplayDate(localizer *i18n.Localizer, t time.Time) string {
+	monthID := fmt.Sprintf("Month%d", int(t.Month()))
+	month, err := localizer.Localize(&i18n.LocalizeConfig{MessageID: monthID})
+	if err != nil {
+		month = t.Month().String()
+	}
+	out, err := localizer.Localize(&i18n.LocalizeConfig{MessageID: "DisplayDate",
+		TemplateData: map[string]interface{}{"Month": month, "Day": t.Day(), "Year": t.Year()}})
+	if err != nil {
+		return t.Format("January 2, 2006")
+	}
+	return out
+}
+
+// getDateFields returns a localized, human facing date field along with a hidden
+// RFC3339 companion (named <name>_iso) for clients that need to compute with the date
+func getDateFields(localizer *i18n.Localizer, name string, label string, t time.Time, visibility string) []v4api.RecordField {
+	out := make([]v4api.RecordField, 0, 2)
+	out = append(out, v4api.RecordField{Name: name, Type: "date", Label: label,
+		Value: formatDisplayDate(localizer, t), Visibility: visibility})
+	out = append(out, v4api.RecordField{Name: fmt.Sprintf("%s_iso", name), Type: "iso_date",
+		Value: t.Format(time.RFC3339), Visibility: "hidden"})
+	return out
+}
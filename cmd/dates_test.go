@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatDisplayDate(t *testing.T) {
+	svc := newTestService(t, nil)
+	tests := []struct {
+		lang string
+		date time.Time
+		want string
+	}{
+		{"en-US", time.Date(2024, time.January, 5, 0, 0, 0, 0, time.UTC), "January 5, 2024"},
+		{"en-US", time.Date(2023, time.December, 31, 23, 59, 0, 0, time.UTC), "December 31, 2023"},
+		{"es", time.Date(2024, time.January, 5, 0, 0, 0, 0, time.UTC), "5 de enero de 2024"},
+		{"es", time.Date(2024, time.August, 17, 0, 0, 0, 0, time.UTC), "17 de agosto de 2024"},
+		{"es-MX", time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC), "1 de marzo de 2024"},
+		// no messages for the language; English is used
+		{"fr", time.Date(2024, time.May, 9, 0, 0, 0, 0, time.UTC), "May 9, 2024"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.lang+" "+tc.want, func(t *testing.T) {
+			if got := formatDisplayDate(testLocalizer(svc, tc.lang), tc.date); got != tc.want {
+				t.Errorf("formatDisplayDate(%s, %s) = %q, want %q", tc.lang, tc.date, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDateFieldsHaveISOCompanion(t *testing.T) {
+	svc := newTestService(t, nil)
+	due := time.Date(2024, time.February, 29, 14, 30, 0, 0, time.UTC)
+	fields := getDateFields(testLocalizer(svc, "es"), "earliest_due", "Earliest Due", due, "detailed")
+	if len(fields) != 2 {
+		t.Fatalf("got %d fields, want 2", len(fields))
+	}
+	if fields[0].Name != "earliest_due" || fields[0].Value != "29 de febrero de 2024" || fields[0].Visibility != "detailed" {
+		t.Errorf("display field = %+v", fields[0])
+	}
+	if fields[1].Name != "earliest_due_iso" || fields[1].Value != "2024-02-29T14:30:00Z" || fields[1].Visibility != "hidden" {
+		t.Errorf("iso field = %+v", fields[1])
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// QueryTranslation is the response of the query debug endpoint
+type QueryTranslation struct {
+	Query        string          `json:"query"`
+	BibID        string          `json:"bib_id,omitempty"`
+	SierraText   string          `json:"sierra_text,omitempty"`
+	SierraParams string          `json:"sierra_params,omitempty"`
+	Start        int             `json:"start"`
+	Rows         int             `json:"rows"`
+	Sort         v4api.SortOrder `json:"sort"`
+	RecentBrowse bool            `json:"recent_browse"`
+	PostFiltered bool            `json:"post_filtered"`
+	StopWords    bool            `json:"stop_words_only"`
+	Warnings     []string        `json:"warnings"`
+}
+
+// debugQuery translates a search request exactly as search does and returns the Sierra
+// query that would be sent, without calling Sierra
+func (svc *ServiceContext) debugQuery(c *gin.Context) {
+	if requireJSONBody(c) == false {
+		return
+	}
+	var req jmrlSearchRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("ERROR: unable to parse debug query request: %s", err.Error())
+		sendError(c, http.StatusBadRequest, errBadRequest, "invalid request")
+		return
+	}
+	log.Printf("Query translation requested for [%s]", req.Query)
+	xlate, err := svc.translateSearch(req)
+	if err != nil {
+		sendError(c, err.StatusCode, err.errorCode(), err.Message)
+		return
+	}
+
+	out := QueryTranslation{Query: req.Query, BibID: xlate.BibID, Sort: xlate.Sort, Start: xlate.Start,
+		Rows: xlate.Rows, RecentBrowse: xlate.RecentBrowse, PostFiltered: xlate.postFiltered(),
+		StopWords: xlate.StopWordsOnly, Warnings: xlate.Warnings}
+	if xlate.BibID == "" || xlate.BibFallback {
+		// parameters of the first Sierra request for the page, run if a bib number is not found
+		params := url.Values{}
+		for k, v := range xlate.Params {
+			params[k] = v
+		}
+		limit := xlate.Rows
+		if limit > sierraMaxLimit {
+			limit = sierraMaxLimit
+		}
+		params.Set("offset", fmt.Sprintf("%d", xlate.Start))
+		params.Set("limit", fmt.Sprintf("%d", limit))
+		out.SierraText = xlate.Query
+		out.SierraParams = params.Encode()
+	}
+	c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// the debug endpoint reports exactly the Sierra request the same search would send first
+func TestDebugQueryMatchesSearch(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"keyword", `{"query":"keyword: {cats}","pagination":{"rows":20}}`},
+		{"fields and paging", `{"query":"title: {cats} AND author: {smith}","pagination":{"start":40,"rows":20}}`},
+		{"sorted", `{"query":"keyword: {cats}","pagination":{"rows":20},"sort":{"sort_id":"SortTitle","order":"asc"}}`},
+		{"large page", `{"query":"keyword: {cats}","pagination":{"rows":120}}`},
+		{"filtered", `{"query":"keyword: {cats}","pagination":{"rows":20},"filters":[{"pool_id":"jmrl","facets":[{"facet_id":"FilterFormat","value":"Book"}]}]}`},
+		{"rewritten", `{"query":"keyword: {cats NOT dogs} AND fulltext: {hats}","pagination":{"rows":20}}`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sierra := newFakeSierra(t)
+			sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "Cats")))
+			router := newRouter(newTestService(t, sierra))
+
+			rec := apiRequest(t, router, http.MethodPost, "/api/debug/query", tc.body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("debug status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var debug QueryTranslation
+			decodeTestJSON(t, rec, &debug)
+			if n := len(sierra.requestURLs()); n != 0 {
+				t.Fatalf("debug query made %d Sierra requests", n)
+			}
+			want, err := url.ParseQuery(debug.SierraParams)
+			if err != nil || want.Get("text") != debug.SierraText {
+				t.Fatalf("sierra_params %q do not carry sierra_text %q", debug.SierraParams, debug.SierraText)
+			}
+
+			rec = apiRequest(t, router, http.MethodPost, "/api/search", tc.body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("search status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var sent url.Values
+			for _, raw := range sierra.requestURLs() {
+				if u, _ := url.Parse(raw); u.Path == fakeSierraBase+"/bibs/search" {
+					sent = u.Query()
+					break
+				}
+			}
+			if reflect.DeepEqual(sent, want) == false {
+				t.Errorf("search sent %v, debug reported %v", sent, want)
+			}
+		})
+	}
+}
+
+func TestDebugQueryResponses(t *testing.T) {
+	router := newRouter(newTestService(t, nil))
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		check  func(t *testing.T, qt QueryTranslation)
+	}{
+		{"bib number", `{"query":"keyword: {b1001001}","pagination":{"rows":20}}`, http.StatusOK, func(t *testing.T, qt QueryTranslation) {
+			if qt.BibID != "1001001" || qt.SierraText == "" {
+				t.Errorf("bib_id %q sierra_text %q, want the bib and the fallback search", qt.BibID, qt.SierraText)
+			}
+		}},
+		{"identifier", `{"query":"identifier: {b1001001}","pagination":{"rows":20}}`, http.StatusOK, func(t *testing.T, qt QueryTranslation) {
+			if qt.BibID != "1001001" || qt.SierraText != "" {
+				t.Errorf("bib_id %q sierra_text %q, want only the bib", qt.BibID, qt.SierraText)
+			}
+		}},
+		{"browse", `{"query":"keyword: {}","pagination":{"rows":20}}`, http.StatusOK, func(t *testing.T, qt QueryTranslation) {
+			if qt.RecentBrowse == false {
+				t.Errorf("recent_browse = false for an empty query")
+			}
+		}},
+		{"warnings", `{"query":"keyword: {cats} AND date: {BEFORE 1000}","pagination":{"start":5,"rows":500}}`, http.StatusOK,
+			func(t *testing.T, qt QueryTranslation) {
+				if len(qt.Warnings) == 0 || qt.SierraText != "(cats)" || qt.Start != 5 || qt.Rows != maxSearchRows {
+					t.Errorf("translation = %+v", qt)
+				}
+			}},
+		{"malformed", `{"query":"keyword: {cats","pagination":{"rows":20}}`, http.StatusBadRequest, nil},
+		{"unsupported", `{"query":"date: {BEFORE 1000}","pagination":{"rows":20}}`, http.StatusNotImplemented, nil},
+		{"invalid json", `{"query":`, http.StatusBadRequest, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := apiRequest(t, router, http.MethodPost, "/api/debug/query", tc.body)
+			if rec.Code != tc.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.status, rec.Body.String())
+			}
+			if tc.check != nil {
+				var qt QueryTranslation
+				decodeTestJSON(t, rec, &qt)
+				tc.check(t, qt)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// relaxQuery returns a looser version of a simple title or keyword query for a zero
+// result search. Quoted phrases become plain words and a title restriction becomes
+// keyword; if neither applies, the longest word (most likely the rare or misspelled
+// one) is dropped. False is returned for other queries or if nothing can be relaxed.
+func relaxQuery(tokens []queryToken) (string, bool) {
+	if _, simple := simpleQueryText(tokens); simple == false {
+		return "", false
+	}
+	relaxed := tokens[0].Value != "keyword"
+	words := make([]string, 0)
+	for _, tok := range tokens[2 : len(tokens)-1] {
+		if tok.Type == tokenPhrase {
+			relaxed = true
+			words = append(words, strings.Fields(strings.Trim(tok.Value, `"`))...)
+		} else {
+			words = append(words, tok.Value)
+		}
+	}
+	if relaxed == false {
+		if len(words) < 2 {
+			return "", false
+		}
+		longest := 0
+		for i, word := range words {
+			if utf8.RuneCountInString(word) > utf8.RuneCountInString(words[longest]) {
+				longest = i
+			}
+		}
+		words = append(words[:longest], words[longest+1:]...)
+	}
+	if len(words) == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("keyword: {%s}", strings.Join(words, " ")), true
+}
+
+// enrichmentDidYouMean names the zero result suggestion when it is skipped
+const enrichmentDidYouMean = "did_you_mean"
+
+// didYouMean runs the relaxed form of a zero result search as a single count query and
+// returns a localized suggestion naming the relaxed query and its hit count. Empty is returned if
+// the query can't be relaxed or the relaxed query finds nothing either. A failed count
+// is recorded on the trace as a skipped enrichment.
+func (svc *ServiceContext) didYouMean(ctx context.Context, xlate *searchTranslation, localizer *i18n.Localizer, trace *requestTrace) string {
+	if xlate.postFiltered() {
+		return ""
+	}
+	v4Query, ok := relaxQuery(xlate.Tokens)
+	if ok == false {
+		return ""
+	}
+	sierraQ, err := translateQuery(tokenizeQuery(v4Query))
+	if err != nil {
+		return ""
+	}
+	if xlate.FilterQuery != "" {
+		sierraQ = fmt.Sprintf("(%s) AND %s", sierraQ, xlate.FilterQuery)
+	}
+	trace.decision("zero result suggestion")
+	total, reqErr := svc.countBibs(ctx, sierraQ, trace)
+	if reqErr != nil {
+		log.Printf("WARNING: suggestion query [%s] failed: %s", sierraQ, reqErr.Message)
+		trace.skip(enrichmentDidYouMean)
+		return ""
+	}
+	log.Printf("Relaxed query [%s] has %d results", v4Query, total)
+	if total == 0 {
+		return ""
+	}
+	return localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "DidYouMean", PluralCount: total,
+		TemplateData: map[string]interface{}{"Query": v4Query, "Count": total}})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-api/v4api"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+func TestRelaxQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+		ok    bool
+	}{
+		{`keyword: {"secret garden" roses}`, "keyword: {secret garden roses}", true},
+		{"title: {secret gardn}", "keyword: {secret gardn}", true},
+		{"keyword: {secret gardenning}", "keyword: {secret}", true},
+		{"keyword: {roses}", "", false},
+		{"keyword: {roses AND tulips}", "", false},
+		{"keyword: {roses} AND title: {tulips}", "", false},
+		{"author: {smith jones}", "", false},
+	}
+	for _, tc := range tests {
+		got, ok := relaxQuery(tokenizeQuery(tc.query))
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("relaxQuery(%q) = %q %t, want %q %t", tc.query, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestZeroResultSuggestion(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		lang   string
+		count  int
+		status int
+		want   string
+	}{
+		{"english", "title: {gardn tips}", "en-US", 12, http.StatusOK,
+			"No results were found. Did you mean keyword: {gardn tips} (12 results)?"},
+		{"english singular", "title: {gardn tips}", "en-US", 1, http.StatusOK,
+			"No results were found. Did you mean keyword: {gardn tips} (1 result)?"},
+		{"spanish", "title: {gardn tips}", "es", 3, http.StatusOK,
+			"No se encontraron resultados. ¿Quiso decir keyword: {gardn tips} (3 resultados)?"},
+		{"spanish singular", "title: {gardn tips}", "es", 1, http.StatusOK,
+			"No se encontraron resultados. ¿Quiso decir keyword: {gardn tips} (1 resultado)?"},
+		{"relaxed query finds nothing", "title: {gardn tips}", "en-US", 0, http.StatusOK, ""},
+		{"relaxed query fails", "title: {gardn tips}", "en-US", 0, http.StatusInternalServerError, ""},
+		{"boolean query is not relaxed", "keyword: {gardn} OR title: {tips}", "en-US", 5, http.StatusOK, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sierra := newFakeSierra(t)
+			counts := 0
+			sierra.handle("bibs/search", func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("fields") != "id" {
+					writeTestJSON(w, http.StatusOK, searchResult(0))
+					return
+				}
+				counts++
+				if tc.status != http.StatusOK {
+					writeTestJSON(w, tc.status, SierraError{Code: 109, Description: "Internal error"})
+					return
+				}
+				writeTestJSON(w, http.StatusOK, JMRLResult{Total: tc.count})
+			})
+			svc := newTestService(t, sierra)
+			router := gin.New()
+			router.POST("/api/search", svc.authMiddleware, svc.search)
+			req := httptest.NewRequest(http.MethodPost, "/api/search", strings.NewReader(`{"query":"`+strings.ReplaceAll(tc.query, `"`, `\"`)+`"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.User))
+			req.Header.Set("Accept-Language", tc.lang)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var resp v4api.PoolResult
+			decodeTestJSON(t, rec, &resp)
+			suggestions := make([]string, 0)
+			for _, warn := range resp.Warnings {
+				if strings.Contains(warn, "keyword: {") {
+					suggestions = append(suggestions, warn)
+				}
+			}
+			if tc.want == "" && len(suggestions) > 0 {
+				t.Errorf("suggestions = %q, want none", suggestions)
+			}
+			if tc.want != "" && (len(suggestions) != 1 || suggestions[0] != tc.want) {
+				t.Errorf("suggestions = %q, want [%q]", suggestions, tc.want)
+			}
+			if counts > 1 {
+				t.Errorf("%d suggestion queries, want at most 1", counts)
+			}
+		})
+	}
+}
+
+// a suggestion that fails or runs out of budget marks the result incomplete; the zero
+// result search itself is still returned
+func TestSkippedSuggestionMarksResultIncomplete(t *testing.T) {
+	tests := []struct {
+		name     string
+		count    http.HandlerFunc
+		complete bool
+	}{
+		{"suggestion found", slowHandler(0, JMRLResult{Total: 12}), true},
+		{"suggestion failed", func(w http.ResponseWriter, r *http.Request) {
+			writeTestJSON(w, http.StatusInternalServerError, SierraError{Code: 109, Description: "Internal error"})
+		}, false},
+		{"suggestion too slow", slowHandler(2*time.Second, JMRLResult{Total: 12}), false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sierra := newFakeSierra(t)
+			sierra.handle("bibs/search", func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("fields") == "id" {
+					tc.count(w, r)
+					return
+				}
+				writeTestJSON(w, http.StatusOK, searchResult(0))
+			})
+			router := newRouter(newTestService(t, sierra))
+			rec := apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"title: {gardn tips}","timeout_ms":300}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var resp struct {
+				v4api.PoolResult
+				Complete           *bool    `json:"complete"`
+				SkippedEnrichments []string `json:"skipped_enrichments"`
+			}
+			decodeTestJSON(t, rec, &resp)
+			if resp.Complete == nil || *resp.Complete != tc.complete {
+				t.Fatalf("complete = %v, want %t: %s", resp.Complete, tc.complete, rec.Body.String())
+			}
+			if tc.complete == false && (len(resp.SkippedEnrichments) != 1 || resp.SkippedEnrichments[0] != enrichmentDidYouMean) {
+				t.Errorf("skipped_enrichments = %v, want [%s]", resp.SkippedEnrichments, enrichmentDidYouMean)
+			}
+			if tc.complete && len(resp.SkippedEnrichments) > 0 {
+				t.Errorf("skipped_enrichments = %v, want none", resp.SkippedEnrichments)
+			}
+			if resp.StatusCode != http.StatusOK || resp.Pagination.Total != 0 {
+				t.Errorf("status_code = %d, total = %d, want the zero result search", resp.StatusCode, resp.Pagination.Total)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// slowEncodeThreshold is the serialization time above which a warning is logged
+const slowEncodeThreshold = 100 * time.Millisecond
+
+// streamJSON encodes a response directly to the response writer rather than building the
+// full encoded value in memory first. The encoding time is tracked in the service metrics
+// and reported in the access log; it can't be in the response, which is already written.
+// There is no separate time limit: results are bounded by the maxSearchRows page size, and
+// an encoder that stopped part way would only leave the client with invalid JSON.
+func (svc *ServiceContext) streamJSON(c *gin.Context, status int, obj interface{}) {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(status)
+	startTime := time.Now()
+	err := json.NewEncoder(c.Writer).Encode(obj)
+	elapsed := time.Since(startTime)
+	elapsedMS := int64(elapsed / time.Millisecond)
+	svc.Metrics.Increment("json_encode_count")
+	svc.Metrics.Add("json_encode_ms_total", elapsedMS)
+	c.Set("encode_ms", elapsedMS)
+	if err != nil {
+		log.Printf("ERROR: unable to encode response for %s: %s", c.Request.URL.Path, err.Error())
+		svc.Metrics.Increment("json_encode_errors")
+		return
+	}
+	if elapsed > slowEncodeThreshold {
+		log.Printf("WARNING: slow response serialization for %s. Elapsed Time: %d (ms)", c.Request.URL.Path, elapsedMS)
+		svc.Metrics.Increment("json_encode_slow")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// largePoolResult returns a pool result of records built from test bibs, the way a
+// search of the rows builds them
+func largePoolResult(svc *ServiceContext, rows int) *v4api.PoolResult {
+	opts := fieldOptions{View: viewBrief, Language: "en-US", Localizer: testLocalizer(svc, "en-US")}
+	res := &v4api.PoolResult{Confidence: "medium", Pagination: v4api.Pagination{Rows: rows, Total: rows * 10},
+		Groups: make([]v4api.Group, 0, rows), Warnings: []string{"Facet counts are approximate <sampled> & rounded"}}
+	for i := 0; i < rows; i++ {
+		bib := testBib(fmt.Sprintf("%d", 1000+i), fmt.Sprintf("Título número %d: \"quoted\" & <tagged>", i))
+		bib.VarFields = append(bib.VarFields, marcField("520", "a", "A summary of the record with accents (é, ñ) and symbols → ✓."))
+		record := v4api.Record{Fields: svc.getResultFields(&bib, opts)}
+		res.Groups = append(res.Groups, v4api.Group{Value: bib.ID, Count: 1, Records: []v4api.Record{record}})
+	}
+	return res
+}
+
+func TestStreamJSONMatchesGinJSON(t *testing.T) {
+	svc := newTestService(t, nil)
+	res := largePoolResult(svc, 100)
+
+	streamed, streamRec := newTestContext(http.MethodGet, "/", nil)
+	svc.streamJSON(streamed, http.StatusOK, res)
+	built, builtRec := newTestContext(http.MethodGet, "/", nil)
+	built.JSON(http.StatusOK, res)
+
+	if streamRec.Code != builtRec.Code {
+		t.Errorf("status %d, gin %d", streamRec.Code, builtRec.Code)
+	}
+	if got, want := streamRec.Header().Get("Content-Type"), builtRec.Header().Get("Content-Type"); got != want {
+		t.Errorf("Content-Type %q, gin %q", got, want)
+	}
+	if got, want := bytes.TrimSuffix(streamRec.Body.Bytes(), []byte("\n")), builtRec.Body.Bytes(); bytes.Equal(got, want) == false {
+		t.Errorf("streamed JSON differs from gin JSON:\n%s\n%s", got, want)
+	}
+	checkGolden(t, "pool_result", largePoolResult(svc, 2))
+}
+
+func TestStreamJSONMetrics(t *testing.T) {
+	svc := newTestService(t, nil)
+	for i := 0; i < 3; i++ {
+		c, _ := newTestContext(http.MethodGet, "/", nil)
+		svc.streamJSON(c, http.StatusOK, largePoolResult(svc, 5))
+		encodeMS, ok := c.Get("encode_ms")
+		if ok == false {
+			t.Fatalf("encode_ms not recorded for the access log")
+		}
+		line := accessLogFormatter(gin.LogFormatterParams{Method: http.MethodPost, Path: "/api/search", Keys: c.Keys})
+		if strings.Contains(line, fmt.Sprintf(`"/api/search" | encode %dms`, encodeMS)) == false {
+			t.Errorf("access log %q does not report the encoding time", line)
+		}
+	}
+	if got := svc.Metrics.Snapshot()["json_encode_count"]; got != 3 {
+		t.Errorf("json_encode_count = %d, want 3", got)
+	}
+
+	// an unencodable value is counted as an error
+	c, _ := newTestContext(http.MethodGet, "/", nil)
+	svc.streamJSON(c, http.StatusOK, map[string]interface{}{"bad": make(chan int)})
+	if got := svc.Metrics.Snapshot()["json_encode_errors"]; got != 1 {
+		t.Errorf("json_encode_errors = %d, want 1", got)
+	}
+}
+
+func BenchmarkEncodeGinJSON(b *testing.B) {
+	svc := newTestServiceWithConfig(b, newTestConfig("http://sierra.invalid"+fakeSierraBase))
+	res := largePoolResult(svc, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+func BenchmarkEncodeStreamJSON(b *testing.B) {
+	svc := newTestServiceWithConfig(b, newTestConfig("http://sierra.invalid"+fakeSierraBase))
+	res := largePoolResult(svc, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		svc.streamJSON(c, http.StatusOK, res)
+	}
+}
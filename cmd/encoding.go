@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+// UnmarshalJSON decodes a subfield, repairing any Latin-1 bytes embedded in the content
+func (sf *JMRLSubfield) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Tag     string          `json:"tag"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	sf.Tag = raw.Tag
+	sf.Content = ""
+	sf.Repaired = false
+	if len(raw.Content) == 0 {
+		return nil
+	}
+	content := []byte(raw.Content)
+	if utf8.Valid(content) == false {
+		content = repairLatin1(content)
+		sf.Repaired = true
+	}
+	return json.Unmarshal(content, &sf.Content)
+}
+
+// repairLatin1 re-interprets every byte that is not part of a valid UTF-8 sequence
+// as a Latin-1 character and returns valid UTF-8
+func repairLatin1(data []byte) []byte {
+	out := make([]byte, 0, len(data)+8)
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			out = utf8.AppendRune(out, rune(data[0]))
+		} else {
+			out = append(out, data[:size]...)
+		}
+		data = data[size:]
+	}
+	return out
+}
+
+// logEncodingRepairs reports bibs that needed Latin-1 repair, or that contain
+// replacement characters that could not be repaired, so JMRL can fix the source records
+func (svc *ServiceContext) logEncodingRepairs(bib *JMRLBib) {
+	repaired := make([]string, 0)
+	unrepairable := make([]string, 0)
+	for _, vf := range bib.VarFields {
+		for _, sf := range vf.Subfields {
+			if sf.Repaired {
+				repaired = append(repaired, vf.MarcTag+"$"+sf.Tag)
+			} else if strings.ContainsRune(sf.Content, utf8.RuneError) {
+				unrepairable = append(unrepairable, vf.MarcTag+"$"+sf.Tag)
+			}
+		}
+	}
+	if len(repaired) > 0 {
+		log.Printf("DATA QUALITY: bib %s had Latin-1 encoded data repaired in %s", bib.ID, strings.Join(repaired, ", "))
+		svc.Metrics.Increment("bib_encoding_repaired")
+	}
+	if len(unrepairable) > 0 {
+		log.Printf("DATA QUALITY: bib %s has replacement characters in %s", bib.ID, strings.Join(unrepairable, ", "))
+		svc.Metrics.Increment("bib_encoding_unrepairable")
+	}
+}
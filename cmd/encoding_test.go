@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// mangledBib is a bib as Sierra returns it when the MARC was loaded as Latin-1: the
+// accented characters of most subfields are single bytes rather than UTF-8 sequences
+const mangledBib = "{\"id\":\"1001\",\"title\":\"Cien a\xf1os de soledad\",\"varFields\":[" +
+	"{\"marcTag\":\"100\",\"subfields\":[{\"tag\":\"a\",\"content\":\"Garc\xeda M\xe1rquez, Gabriel,\"},{\"tag\":\"d\",\"content\":\"1927-2014.\"}]}," +
+	"{\"marcTag\":\"245\",\"subfields\":[{\"tag\":\"a\",\"content\":\"Cien a\xf1os de soledad /\"},{\"tag\":\"c\",\"content\":\"Gabriel Garc\xc3\xada M\xc3\xa1rquez.\"}]}," +
+	"{\"marcTag\":\"500\",\"subfields\":[{\"tag\":\"a\",\"content\":\"Traducci\xf3n \xabautorizada\xbb.\"}]}," +
+	"{\"marcTag\":\"520\",\"subfields\":[{\"tag\":\"a\",\"content\":\"Unreadable \xef\xbf\xbd character.\"}]}]}"
+
+func TestLatin1SubfieldsAreRepaired(t *testing.T) {
+	var bib JMRLBib
+	if err := json.Unmarshal([]byte(mangledBib), &bib); err != nil {
+		t.Fatalf("unable to decode mangled bib: %s", err.Error())
+	}
+	tests := []struct {
+		tag      string
+		code     string
+		want     string
+		repaired bool
+	}{
+		{"100", "a", "García Márquez, Gabriel,", true},
+		{"100", "d", "1927-2014.", false},
+		{"245", "a", "Cien años de soledad /", true},
+		{"245", "c", "Gabriel García Márquez.", false},
+		{"500", "a", "Traducción «autorizada».", true},
+		{"520", "a", "Unreadable � character.", false},
+	}
+	for _, tc := range tests {
+		found := false
+		for _, vf := range bib.VarFields {
+			for _, sf := range vf.Subfields {
+				if vf.MarcTag != tc.tag || sf.Tag != tc.code {
+					continue
+				}
+				found = true
+				if sf.Content != tc.want || sf.Repaired != tc.repaired {
+					t.Errorf("%s$%s = %q repaired %t, want %q repaired %t", tc.tag, tc.code, sf.Content, sf.Repaired, tc.want, tc.repaired)
+				}
+			}
+		}
+		if found == false {
+			t.Errorf("%s$%s missing from decoded bib", tc.tag, tc.code)
+		}
+	}
+}
+
+func TestRepairLatin1(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain ascii", "plain ascii"},
+		{"Bront\xeb", "Brontë"},
+		{"Bront\xc3\xab", "Brontë"},
+		{"mixed \xe9 and \xc3\xa9", "mixed é and é"},
+		{"\xa9 1999", "© 1999"},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		if got := string(repairLatin1([]byte(tc.in))); got != tc.want {
+			t.Errorf("repairLatin1(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestEncodingRepairMetrics(t *testing.T) {
+	svc := newTestService(t, nil)
+	var bib JMRLBib
+	if err := json.Unmarshal([]byte(mangledBib), &bib); err != nil {
+		t.Fatalf("unable to decode mangled bib: %s", err.Error())
+	}
+	svc.logEncodingRepairs(&bib)
+	clean := testBib("1002", "Clean")
+	svc.logEncodingRepairs(&clean)
+	metrics := svc.Metrics.Snapshot()
+	if metrics["bib_encoding_repaired"] != 1 || metrics["bib_encoding_unrepairable"] != 1 {
+		t.Errorf("repaired %d unrepairable %d, want 1 and 1", metrics["bib_encoding_repaired"], metrics["bib_encoding_unrepairable"])
+	}
+}
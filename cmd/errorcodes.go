@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// errorCode is a stable, machine readable error identifier included in every error
+// response. Clients branch on the code; the message is for display and may be reworded
+// or localized at any time. Codes must never be renamed once released.
+type errorCode string
+
+const (
+	errBadRequest            errorCode = "bad_request"
+	errUnsupportedMediaType  errorCode = "unsupported_media_type"
+	errQueryMalformed        errorCode = "query_malformed"
+	errQueryUnsupportedField errorCode = "query_unsupported_field"
+	errSortUnsupported       errorCode = "sort_unsupported"
+	errRecordNotFound        errorCode = "record_not_found"
+	errNotFound              errorCode = "not_found"
+	errUpstreamUnavailable   errorCode = "upstream_unavailable"
+	errUpstreamError         errorCode = "upstream_error"
+	errRateLimited           errorCode = "rate_limited"
+	errTimeout               errorCode = "timeout"
+	errAuthRequired          errorCode = "auth_required"
+	errAuthExpired           errorCode = "auth_expired"
+	errAuthInvalid           errorCode = "auth_invalid"
+	errForbidden             errorCode = "forbidden"
+	errNotEnabled            errorCode = "not_enabled"
+	errInternal              errorCode = "internal_error"
+)
+
+// errorResponse is the envelope of all non-search error responses. The field names
+// match the V4 PoolResult status fields.
+type errorResponse struct {
+	StatusCode    int       `json:"status_code"`
+	StatusMessage string    `json:"status_msg"`
+	ErrorCode     errorCode `json:"error_code"`
+}
+
+// poolErrorResult is a search PoolResult that carries the error code of a failed search,
+// or for a successful one whether every optional enrichment made it into the result
+type poolErrorResult struct {
+	*v4api.PoolResult
+	ErrorCode          errorCode `json:"error_code,omitempty"`
+	Complete           *bool     `json:"complete,omitempty"`
+	SkippedEnrichments []string  `json:"skipped_enrichments,omitempty"`
+}
+
+// poolFacetsError is a failed facets response with the error code of the failure
+type poolFacetsError struct {
+	*v4api.PoolFacets
+	ErrorCode errorCode `json:"error_code"`
+}
+
+// sendError aborts the request with an error envelope
+func sendError(c *gin.Context, status int, code errorCode, message string) {
+	c.AbortWithStatusJSON(status, errorResponse{StatusCode: status, StatusMessage: message, ErrorCode: code})
+}
+
+// sendFacetsError sends a failed facets response using the status of the error
+func sendFacetsError(c *gin.Context, err *RequestError) {
+	c.JSON(err.StatusCode, poolFacetsError{PoolFacets: &v4api.PoolFacets{StatusCode: err.StatusCode,
+		StatusMessage: err.Message}, ErrorCode: err.errorCode()})
+}
+
+// sendNoRoute answers requests for endpoints the pool does not have, including those of
+// features that are not enabled
+func sendNoRoute(c *gin.Context) {
+	sendError(c, http.StatusNotFound, errNotFound, "not found")
+}
+
+// recoverPanic answers a request whose handler panicked
+func recoverPanic(c *gin.Context, err interface{}) {
+	log.Printf("ERROR: panic handling %s: %v", c.Request.URL.Path, err)
+	sendError(c, http.StatusInternalServerError, errInternal, "internal error")
+}
+
+// sendPoolError sends a failed search result using its status code
+func sendPoolError(c *gin.Context, res *v4api.PoolResult, code errorCode) {
+	c.JSON(res.StatusCode, poolErrorResult{PoolResult: res, ErrorCode: code})
+}
+
+// errorCode returns the code for a failed Sierra request. An explicit code wins;
+// otherwise it is derived from the status.
+func (re *RequestError) errorCode() errorCode {
+	if re.Code != "" {
+		return re.Code
+	}
+	switch re.StatusCode {
+	case http.StatusNotFound:
+		return errRecordNotFound
+	case http.StatusTooManyRequests:
+		return errRateLimited
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return errTimeout
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return errUpstreamUnavailable
+	}
+	return errUpstreamError
+}
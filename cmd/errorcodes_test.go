@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+// responseMethods are the gin.Context methods and service helpers that write a status
+var responseMethods = map[string]bool{"JSON": true, "AbortWithStatus": true, "AbortWithStatusJSON": true,
+	"Status": true, "String": true, "Data": true, "IndentedJSON": true, "PureJSON": true, "streamJSON": true}
+
+// successStatuses are the statuses that are not errors
+var successStatuses = map[string]bool{"StatusOK": true, "StatusCreated": true, "StatusNoContent": true,
+	"StatusNotModified": true, "StatusMovedPermanently": true}
+
+// errorEnvelopeWriters may send a status decided by their caller; they add the code
+var errorEnvelopeWriters = map[string]bool{"sendError": true, "sendPoolError": true, "sendFacetsError": true, "streamJSON": true}
+
+// hasErrorCode returns true if a response body expression is an error envelope or a
+// gin.H with an error_code
+func hasErrorCode(body ast.Expr) bool {
+	lit, ok := body.(*ast.CompositeLit)
+	if ok == false {
+		return false
+	}
+	switch typ := lit.Type.(type) {
+	case *ast.Ident:
+		return typ.Name == "errorResponse" || typ.Name == "poolErrorResult" || typ.Name == "poolFacetsError"
+	case *ast.SelectorExpr:
+		for _, elt := range lit.Elts {
+			if kv, ok := elt.(*ast.KeyValueExpr); ok {
+				if key, ok := kv.Key.(*ast.BasicLit); ok && key.Value == `"error_code"` {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// every response written with an error status must go through an envelope with a code
+func TestErrorResponsesCarryCode(t *testing.T) {
+	files, _ := filepath.Glob("*.go")
+	fset := token.NewFileSet()
+	for _, filename := range files {
+		if strings.HasSuffix(filename, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filename, nil, 0)
+		if err != nil {
+			t.Fatalf("unable to parse %s: %s", filename, err.Error())
+		}
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if ok == false || fn.Body == nil {
+				continue
+			}
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if ok == false || len(call.Args) == 0 {
+					return true
+				}
+				sel, ok := call.Fun.(*ast.SelectorExpr)
+				if ok == false || responseMethods[sel.Sel.Name] == false {
+					return true
+				}
+				// only responses written on the gin context, c by convention, or by streamJSON
+				if recv, ok := sel.X.(*ast.Ident); (ok == false || recv.Name != "c") && sel.Sel.Name != "streamJSON" {
+					return true
+				}
+				statusArg := call.Args[0]
+				if sel.Sel.Name == "streamJSON" && len(call.Args) > 1 {
+					statusArg = call.Args[1]
+				}
+				pos := fset.Position(call.Pos())
+				status, ok := statusArg.(*ast.SelectorExpr)
+				if ok == false {
+					if errorEnvelopeWriters[fn.Name.Name] == false {
+						t.Errorf("%s: %s in %s writes a computed status; use sendError or sendPoolError", pos, sel.Sel.Name, fn.Name.Name)
+					}
+					return true
+				}
+				if successStatuses[status.Sel.Name] {
+					return true
+				}
+				bodyIdx := 1
+				if sel.Sel.Name == "streamJSON" {
+					bodyIdx = 2
+				}
+				if len(call.Args) <= bodyIdx || hasErrorCode(call.Args[bodyIdx]) == false {
+					t.Errorf("%s: %s %s in %s has no error_code; use sendError", pos, sel.Sel.Name, status.Sel.Name, fn.Name.Name)
+				}
+				return true
+			})
+		}
+	}
+}
+
+// errorCodeValues returns the values of the errorCode constants
+func errorCodeValues(t *testing.T) []string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "errorcodes.go", nil, 0)
+	if err != nil {
+		t.Fatalf("unable to parse errorcodes.go: %s", err.Error())
+	}
+	out := make([]string, 0)
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if ok == false || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			vs := spec.(*ast.ValueSpec)
+			if typ, ok := vs.Type.(*ast.Ident); ok && typ.Name == "errorCode" {
+				val, _ := strconv.Unquote(vs.Values[0].(*ast.BasicLit).Value)
+				out = append(out, val)
+			}
+		}
+	}
+	return out
+}
+
+// codes are a published contract: each is distinct and documented
+func TestErrorCodesDocumented(t *testing.T) {
+	readme, err := os.ReadFile("../README.md")
+	if err != nil {
+		t.Fatalf("unable to read README: %s", err.Error())
+	}
+	seen := make(map[string]bool)
+	for _, code := range errorCodeValues(t) {
+		if seen[code] {
+			t.Errorf("error code %s is defined twice", code)
+		}
+		seen[code] = true
+		if strings.Contains(string(readme), "`"+code+"`") == false {
+			t.Errorf("error code %s is not listed in the README", code)
+		}
+	}
+}
+
+func TestRequestErrorCode(t *testing.T) {
+	tests := []struct {
+		err  RequestError
+		want errorCode
+	}{
+		{RequestError{StatusCode: http.StatusNotFound}, errRecordNotFound},
+		{RequestError{StatusCode: http.StatusTooManyRequests}, errRateLimited},
+		{RequestError{StatusCode: http.StatusRequestTimeout}, errTimeout},
+		{RequestError{StatusCode: http.StatusGatewayTimeout}, errTimeout},
+		{RequestError{StatusCode: http.StatusBadGateway}, errUpstreamUnavailable},
+		{RequestError{StatusCode: http.StatusServiceUnavailable}, errUpstreamUnavailable},
+		{RequestError{StatusCode: http.StatusInternalServerError}, errUpstreamError},
+		{RequestError{StatusCode: http.StatusBadRequest}, errUpstreamError},
+		{RequestError{StatusCode: http.StatusNotFound, Code: errQueryMalformed}, errQueryMalformed},
+	}
+	for _, tc := range tests {
+		if got := tc.err.errorCode(); got != tc.want {
+			t.Errorf("errorCode of %d %q = %s, want %s", tc.err.StatusCode, tc.err.Code, got, tc.want)
+		}
+	}
+}
+
+// each error path reports its code, whatever the message says
+func TestErrorCodesByPath(t *testing.T) {
+	fastRecordBusyRetries(t)
+	sierra := newFakeSierra(t)
+	sierra.handle("bibs/search", func(w http.ResponseWriter, r *http.Request) {
+		switch text := r.URL.Query().Get("text"); {
+		case strings.Contains(text, "unavailable"):
+			writeTestJSON(w, http.StatusServiceUnavailable, SierraError{Code: 134, HTTPStatus: 503, Name: "Service unavailable"})
+		case strings.Contains(text, "broken"):
+			writeTestJSON(w, http.StatusInternalServerError, SierraError{Code: 109, HTTPStatus: 500, Name: "Internal server error"})
+		case strings.Contains(text, "throttled"):
+			writeTestJSON(w, http.StatusTooManyRequests, SierraError{Code: 429, HTTPStatus: 429, Name: "Too many requests"})
+		default:
+			writeTestJSON(w, http.StatusOK, searchResult(0))
+		}
+	})
+	sierra.handleJSON("bibs/9999999", http.StatusNotFound, SierraError{Code: 107, HTTPStatus: 404, Name: "Record not found"})
+	cfg := newTestConfig(sierra.apiURL())
+	cfg.FeedOrigins = "https://guides.example.org"
+	svc := newTestServiceWithConfig(t, cfg)
+	router := newRouter(svc)
+
+	expired, err := v4jwt.Mint(v4jwt.V4Claims{UserID: "tester", Role: v4jwt.User}, -time.Hour, testJWTKey)
+	if err != nil {
+		t.Fatalf("unable to mint expired token: %s", err.Error())
+	}
+	search := func(query string) string {
+		return `{"query":"` + query + `","pagination":{"rows":20}}`
+	}
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		body        string
+		auth        string
+		contentType string
+		status      int
+		code        errorCode
+	}{
+		{"no token", http.MethodPost, "/api/search", search("keyword: {cats}"), "none", "", http.StatusUnauthorized, errAuthRequired},
+		{"expired token", http.MethodPost, "/api/search", search("keyword: {cats}"), expired, "", http.StatusUnauthorized, errAuthExpired},
+		{"invalid token", http.MethodPost, "/api/search", search("keyword: {cats}"), "not.a.token", "", http.StatusUnauthorized, errAuthInvalid},
+		{"not staff", http.MethodGet, "/api/admin/status", "", "", "", http.StatusForbidden, errForbidden},
+		{"media type", http.MethodPost, "/api/search", search("keyword: {cats}"), "", "text/plain", http.StatusUnsupportedMediaType, errUnsupportedMediaType},
+		{"bad json", http.MethodPost, "/api/search", `{"query":`, "", "", http.StatusBadRequest, errBadRequest},
+		{"malformed query", http.MethodPost, "/api/search", search("keyword: {cats"), "", "", http.StatusBadRequest, errQueryMalformed},
+		{"unsupported field", http.MethodPost, "/api/search", search("date: {BEFORE 1000}"), "", "", http.StatusNotImplemented, errQueryUnsupportedField},
+		{"unsupported sort", http.MethodPost, "/api/search", `{"query":"keyword: {cats}","pagination":{"rows":20},"sort":{"sort_id":"SortShelf","order":"asc"}}`,
+			"", "", http.StatusBadRequest, errSortUnsupported},
+		{"upstream unavailable", http.MethodPost, "/api/search", search("keyword: {unavailable}"), "", "", http.StatusServiceUnavailable, errUpstreamUnavailable},
+		{"upstream error", http.MethodPost, "/api/search", search("keyword: {broken}"), "", "", http.StatusInternalServerError, errUpstreamError},
+		{"rate limited", http.MethodPost, "/api/search", search("keyword: {throttled}"), "", "", http.StatusTooManyRequests, errRateLimited},
+		{"facets bad json", http.MethodPost, "/api/search/facets", `{"query":`, "", "", http.StatusBadRequest, errBadRequest},
+		{"facets malformed query", http.MethodPost, "/api/search/facets", search("keyword: {cats"), "", "", http.StatusBadRequest, errQueryMalformed},
+		{"record not found", http.MethodGet, "/api/resource/9999999", "", "", "", http.StatusNotFound, errRecordNotFound},
+		{"not enabled", http.MethodGet, "/api/admin/zero-results", "", "staff", "", http.StatusNotFound, errNotEnabled},
+		{"disabled endpoint", http.MethodGet, "/api/trending", "", "", "", http.StatusNotFound, errNotFound},
+		{"unknown endpoint", http.MethodGet, "/api/nothing", "", "", "", http.StatusNotFound, errNotFound},
+		{"feed origin", http.MethodOptions, "/api/feed", "", "none", "", http.StatusForbidden, errForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			if tc.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			switch tc.auth {
+			case "none":
+			case "":
+				req.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.User))
+			case "staff":
+				req.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.Staff))
+			default:
+				req.Header.Set("Authorization", "Bearer "+tc.auth)
+			}
+			if tc.target == "/api/feed" {
+				req.Header.Set("Origin", "https://evil.example.org")
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tc.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.status, rec.Body.String())
+			}
+			var body struct {
+				StatusCode int       `json:"status_code"`
+				ErrorCode  errorCode `json:"error_code"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("error response is not JSON: %q", rec.Body.String())
+			}
+			if body.ErrorCode != tc.code || body.StatusCode != tc.status {
+				t.Errorf("envelope = %d %q, want %d %q", body.StatusCode, body.ErrorCode, tc.status, tc.code)
+			}
+		})
+	}
+}
+
+func TestPanicReportsInternalError(t *testing.T) {
+	router := gin.New()
+	router.Use(gin.CustomRecovery(recoverPanic))
+	router.GET("/panic", func(c *gin.Context) { panic("boom") })
+	rec := httptest.NewRecorder()
+	captureLog(func() { router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/panic", nil)) })
+	var body errorResponse
+	decodeTestJSON(t, rec, &body)
+	if rec.Code != http.StatusInternalServerError || body.ErrorCode != errInternal {
+		t.Errorf("panic response = %d %q, want %d %q", rec.Code, body.ErrorCode, http.StatusInternalServerError, errInternal)
+	}
+}
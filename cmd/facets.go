@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// facetSampleSize is the number of search hits counted for the format, branch and language facets.
+// Sierra has no facet counts, so they are approximated from this sample.
+const facetSampleSize = sierraMaxLimit
+
+// facetSampleFields are the bib fields needed to count material types, branches and languages
+const facetSampleFields = "id,materialType,locations,lang"
+
+// languageUnknown is the language facet value for bibs without a listed language. It is
+// the MARC code for undetermined, so FilterLanguage accepts it; selecting it restricts the
+// search to the same bibs (see unknownLanguageRestriction).
+const languageUnknown = "und"
+
+// facetBucket is a V4 facet bucket with a display label for values that are codes
+type facetBucket struct {
+	v4api.FacetBucket
+	Label string `json:"label,omitempty"`
+}
+
+// poolFacet is a V4 facet whose buckets may have labels. Approximate is set when the
+// counts come from a sample of the search hits rather than all of them.
+type poolFacet struct {
+	v4api.Facet
+	Buckets     []facetBucket `json:"buckets,omitempty"`
+	Approximate bool          `json:"approximate,omitempty"`
+}
+
+// poolFacets is the V4 facet response with labeled buckets
+type poolFacets struct {
+	v4api.PoolFacets
+	FacetList []poolFacet `json:"facet_list,omitempty"`
+}
+
+// newPoolFacet converts a V4 facet into a poolFacet with unlabeled buckets
+func newPoolFacet(facet v4api.Facet) poolFacet {
+	out := poolFacet{Facet: facet, Buckets: make([]facetBucket, 0, len(facet.Buckets))}
+	for _, bucket := range facet.Buckets {
+		out.Buckets = append(out.Buckets, facetBucket{FacetBucket: bucket})
+	}
+	out.Facet.Buckets = nil
+	return out
+}
+
+// formatFacetNames are the display names of the material types offered in the format
+// facet. Each is a value FilterFormat accepts (see formatMaterialTypes).
+var formatFacetNames = map[string]string{
+	"a": "Book",
+	"z": "eBook",
+	"g": "DVD",
+	"i": "Audiobook",
+	"s": "Journal",
+}
+
+// formatFacet counts the material types of the bibs into a format facet. Types without a
+// FilterFormat value are not listed. Buckets are ordered by count, then name, and are
+// selected when the filters include their format.
+func formatFacet(bibs []JMRLBib, filters []v4api.Filter, localizer *i18n.Localizer) v4api.Facet {
+	counts := make(map[string]int)
+	for _, bib := range bibs {
+		if _, found := formatFacetNames[bib.Type.Code]; found {
+			counts[bib.Type.Code]++
+		}
+	}
+	selected := make(map[string]bool)
+	for _, filter := range filters {
+		for _, facet := range filter.Facets {
+			if facet.FacetID == filterFormat {
+				selected[formatMaterialTypes[normalizeMatchText(facet.Value)]] = true
+			}
+		}
+	}
+	name := localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "FacetFormat"})
+	out := v4api.Facet{ID: filterFormat, Name: name, Type: "checkbox", Buckets: make([]v4api.FacetBucket, 0)}
+	for code, count := range counts {
+		out.Buckets = append(out.Buckets, v4api.FacetBucket{Value: formatFacetNames[code], Count: count, Selected: selected[code]})
+	}
+	sort.Slice(out.Buckets, func(i, j int) bool {
+		if out.Buckets[i].Count != out.Buckets[j].Count {
+			return out.Buckets[i].Count > out.Buckets[j].Count
+		}
+		return out.Buckets[i].Value < out.Buckets[j].Value
+	})
+	return out
+}
+
+// languageFacet counts the languages of the bibs into a language facet. Bucket values are
+// MARC language codes, which FilterLanguage accepts, with localized labels. Bibs with no
+// language code or one not in sierraLanguages are counted as unknown. Buckets are ordered
+// by count, then label in the collation order of the language, and are selected when the
+// filters include their language.
+func (svc *ServiceContext) languageFacet(bibs []JMRLBib, filters []v4api.Filter, localizer *i18n.Localizer, lang string) poolFacet {
+	counts := make(map[string]int)
+	for idx := range bibs {
+		code := marcLanguageCode(&bibs[idx])
+		if _, known := lookupLanguage(code); code == "" || known == false {
+			code = languageUnknown
+		}
+		counts[code]++
+	}
+	selected := make(map[string]bool)
+	for _, filter := range filters {
+		for _, facet := range filter.Facets {
+			if lang, ok := lookupLanguage(facet.Value); ok && facet.FacetID == filterLanguage {
+				selected[lang.Code] = true
+			}
+		}
+	}
+	name := localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "FacetLanguage"})
+	out := poolFacet{Facet: v4api.Facet{ID: filterLanguage, Name: name, Type: "checkbox"}, Buckets: make([]facetBucket, 0)}
+	for code, count := range counts {
+		out.Buckets = append(out.Buckets, facetBucket{Label: languageLabel(code, localizer),
+			FacetBucket: v4api.FacetBucket{Value: code, Count: count, Selected: selected[code]}})
+	}
+	sort.Slice(out.Buckets, func(i, j int) bool {
+		if out.Buckets[i].Count != out.Buckets[j].Count {
+			return out.Buckets[i].Count > out.Buckets[j].Count
+		}
+		return svc.Collators.compare(lang, out.Buckets[i].Label, out.Buckets[j].Label) < 0
+	})
+	return out
+}
+
+// languageLabel returns the localized name of a MARC language code. The messages are named
+// for the code (LanguageEng); codes without one use the sierraLanguages name.
+func languageLabel(code string, localizer *i18n.Localizer) string {
+	msgID := "LanguageUnknown"
+	if code != languageUnknown {
+		msgID = "Language" + strings.ToUpper(code[:1]) + code[1:]
+	}
+	if label, err := localizer.Localize(&i18n.LocalizeConfig{MessageID: msgID}); err == nil {
+		return label
+	}
+	lang, _ := lookupLanguage(code)
+	return lang.Name
+}
+
+// branchCounts counts the bibs held by each configured branch, keyed by branch code.
+// Placeholder locations are ignored and a bib counts once per branch however many of
+// its locations are in that branch.
+func (svc *ServiceContext) branchCounts(bibs []JMRLBib) map[string]int {
+	out := make(map[string]int)
+	for _, bib := range bibs {
+		held := make(map[string]bool)
+		for _, loc := range svc.normalizeLocations(bib.Locations) {
+			if branch := svc.branchForLocation(loc.Code); branch != nil {
+				held[branch.Code] = true
+			}
+		}
+		for code := range held {
+			out[code]++
+		}
+	}
+	return out
+}
+
+// sampleFacetBibs runs the translated search for the first facetSampleSize hits and
+// returns those that pass the pool applied filters. When the sample is not every hit,
+// counts made from it are approximate and a warning saying so is returned.
+func (svc *ServiceContext) sampleFacetBibs(ctx context.Context, xlate *searchTranslation, trace *requestTrace) ([]JMRLBib, string, *RequestError) {
+	params := url.Values{}
+	for key, vals := range xlate.Params {
+		params[key] = append([]string{}, vals...)
+	}
+	params.Set("offset", "0")
+	params.Set("limit", fmt.Sprintf("%d", facetSampleSize))
+	params.Set("fields", facetSampleFields)
+	if xlate.postFiltered() {
+		// pool applied filters need the full bib
+		params.Set("fields", bibFields)
+	}
+	resp, err := svc.searchBibs(ctx, params, trace)
+	if err != nil {
+		return nil, "", err
+	}
+	trace.returned(len(resp.Entries))
+	bibs := make([]JMRLBib, 0, len(resp.Entries))
+	for _, entry := range resp.Entries {
+		if xlate.postFiltered() == false || xlate.postFilter(&entry.Bib) {
+			bibs = append(bibs, entry.Bib)
+		}
+	}
+	log.Printf("Facets counted from %d of %d hits", len(resp.Entries), resp.Total)
+	warning := ""
+	if resp.Total > len(resp.Entries) || xlate.postFiltered() {
+		warning = fmt.Sprintf("Facet counts are approximate, based on the first %d of %d results", len(resp.Entries), resp.Total)
+	}
+	return bibs, warning, nil
+}
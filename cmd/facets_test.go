@@ -0,0 +1,247 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+// mixedFormatBibs are search hits of every kind the format facet sees: listed types,
+// repeats, and a material type FilterFormat has no value for
+func mixedFormatBibs() []JMRLBib {
+	typed := func(id string, code string, value string) JMRLBib {
+		bib := testBib(id, "Cats "+id)
+		bib.Type = JMRLCodeValue{Code: code, Value: value}
+		return bib
+	}
+	return []JMRLBib{
+		typed("1001", "a", "Book"), typed("1002", "z", "E-Book"), typed("1003", "a", "Book"),
+		typed("1004", "g", "DVD"), typed("1005", "z", "E-Book"), typed("1006", "a", "Book"),
+		typed("1007", "e", "Map"), typed("1008", "i", "Audiobook"),
+	}
+}
+
+func TestFormatFacet(t *testing.T) {
+	svc := newTestService(t, nil)
+	tests := []struct {
+		name    string
+		lang    string
+		filters []v4api.Filter
+		want    []v4api.FacetBucket
+		label   string
+	}{
+		{"unfiltered", "en-US", nil, []v4api.FacetBucket{
+			{Value: "Book", Count: 3}, {Value: "eBook", Count: 2}, {Value: "Audiobook", Count: 1}, {Value: "DVD", Count: 1},
+		}, "Format"},
+		{"selected", "es", testFilters(filterFormat, "ebook"), []v4api.FacetBucket{
+			{Value: "Book", Count: 3}, {Value: "eBook", Count: 2, Selected: true}, {Value: "Audiobook", Count: 1}, {Value: "DVD", Count: 1},
+		}, "Formato"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			facet := formatFacet(mixedFormatBibs(), tc.filters, testLocalizer(svc, tc.lang))
+			if facet.ID != filterFormat || facet.Name != tc.label {
+				t.Errorf("facet = %s %q, want %s %q", facet.ID, facet.Name, filterFormat, tc.label)
+			}
+			if reflect.DeepEqual(facet.Buckets, tc.want) == false {
+				t.Errorf("buckets = %+v, want %+v", facet.Buckets, tc.want)
+			}
+		})
+	}
+}
+
+// facetNames maps the facet IDs of a facets response to their names and approximate flags
+func facetNames(facets []poolFacet) (map[string]string, map[string]bool) {
+	names := make(map[string]string)
+	approx := make(map[string]bool)
+	for _, facet := range facets {
+		names[facet.ID] = facet.Name
+		approx[facet.ID] = facet.Approximate
+	}
+	return names, approx
+}
+
+func TestFacetsEndpoint(t *testing.T) {
+	tests := []struct {
+		name   string
+		lang   string
+		total  int
+		names  map[string]string
+		approx bool
+	}{
+		{"every hit counted", "en-US", 8, map[string]string{filterFormat: "Format", filterLanguage: "Language",
+			filterAvailability: "Availability"}, false},
+		{"sampled", "en-US", 120, map[string]string{filterFormat: "Format", filterLanguage: "Language",
+			filterAvailability: "Availability"}, true},
+		{"spanish", "es", 8, map[string]string{filterFormat: "Formato", filterLanguage: "Idioma",
+			filterAvailability: "Disponibilidad"}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sierra := newFakeSierra(t)
+			sierra.handle("bibs/search", func(w http.ResponseWriter, r *http.Request) {
+				if got := r.URL.Query().Get("text"); got != "(cats)" {
+					t.Errorf("sampled search text = %q, want the search translation (cats)", got)
+				}
+				if got := r.URL.Query().Get("fields"); got != facetSampleFields {
+					t.Errorf("sampled search fields = %q, want %q", got, facetSampleFields)
+				}
+				writeTestJSON(w, http.StatusOK, searchResult(tc.total, mixedFormatBibs()...))
+			})
+			router := newRouter(newTestService(t, sierra))
+			req := httptest.NewRequest(http.MethodPost, "/api/search/facets", strings.NewReader(`{"query":"keyword: {cats}"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.User))
+			req.Header.Set("Accept-Language", tc.lang)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var resp poolFacets
+			decodeTestJSON(t, rec, &resp)
+			names, approx := facetNames(resp.FacetList)
+			if reflect.DeepEqual(names, tc.names) == false {
+				t.Errorf("facet names = %v, want %v", names, tc.names)
+			}
+			if approx[filterFormat] != tc.approx || approx[filterLanguage] != tc.approx || approx[filterAvailability] {
+				t.Errorf("approximate = %v, want %t for the counted facets", approx, tc.approx)
+			}
+			warned := false
+			for _, warn := range resp.Warnings {
+				warned = warned || strings.Contains(warn, "approximate")
+			}
+			if warned != tc.approx {
+				t.Errorf("warnings = %q, want approximate warning %t", resp.Warnings, tc.approx)
+			}
+			for _, facet := range resp.FacetList {
+				if facet.ID == filterFormat && (len(facet.Buckets) != 4 || facet.Buckets[0].Value != "Book" || facet.Buckets[0].Count != 3) {
+					t.Errorf("format buckets = %+v", facet.Buckets)
+				}
+			}
+		})
+	}
+}
+
+// a bib counts once per branch however many of its locations are there, and placeholder
+// locations are not counted
+func TestBranchCounts(t *testing.T) {
+	svc := newTestService(t, nil)
+	svc.Locations.set(make(map[string]bool), []BranchGeo{{Code: "c", Name: "Central Library"}, {Code: "ga", Name: "Gordon Avenue Library"}})
+	located := func(id string, codes ...string) JMRLBib {
+		bib := testBib(id, "Cats")
+		for _, code := range codes {
+			bib.Locations = append(bib.Locations, JMRLCodeValue{Code: code})
+		}
+		return bib
+	}
+	bibs := []JMRLBib{
+		located("1001", "cnf", "cyp", "gaf"),
+		located("1002", "cnf", "cnf"),
+		located("1003", "none"),
+		located("1004", "gaf", "multi"),
+		located("1005", "zzzzz", "xyz"),
+	}
+	want := map[string]int{"c": 2, "ga": 2}
+	if got := svc.branchCounts(bibs); reflect.DeepEqual(got, want) == false {
+		t.Errorf("branchCounts = %v, want %v", got, want)
+	}
+}
+
+// the library facet is localized, and selecting one of its buckets narrows the search to
+// that branch
+func TestLibraryFacetSelection(t *testing.T) {
+	sierra := newFakeSierra(t)
+	bib := testBib("1001", "Cats")
+	bib.Locations = []JMRLCodeValue{{Code: "cnf"}, {Code: "none"}}
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, bib))
+	svc := newTestService(t, sierra)
+	svc.Locations.set(make(map[string]bool), []BranchGeo{{Code: "c", Name: "Central Library"}, {Code: "ga", Name: "Gordon Avenue Library"}})
+	router := newRouter(svc)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/search/facets", strings.NewReader(`{"query":"keyword: {cats}"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.User))
+	req.Header.Set("Accept-Language", "es")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("facets status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp poolFacets
+	decodeTestJSON(t, rec, &resp)
+	var library *poolFacet
+	for idx := range resp.FacetList {
+		if resp.FacetList[idx].ID == filterLibrary {
+			library = &resp.FacetList[idx]
+		}
+	}
+	if library == nil {
+		t.Fatalf("no library facet: %s", rec.Body.String())
+	}
+	if library.Name != "Biblioteca" {
+		t.Errorf("library facet name = %q, want Biblioteca", library.Name)
+	}
+	if len(library.Buckets) != 2 || library.Buckets[0].Value != "Central Library" || library.Buckets[0].Count != 1 || library.Buckets[1].Count != 0 {
+		t.Fatalf("library buckets = %+v", library.Buckets)
+	}
+
+	body := `{"query":"keyword: {cats}","filters":[{"pool_id":"jmrl","facets":[{"facet_id":"FilterLibrary","value":"` +
+		library.Buckets[0].Value + `"}]}]}`
+	if rec := apiRequest(t, router, http.MethodPost, "/api/search", body); rec.Code != http.StatusOK {
+		t.Fatalf("search status = %d: %s", rec.Code, rec.Body.String())
+	}
+	urls := sierra.requestURLs()
+	sent, _ := url.Parse(urls[len(urls)-1])
+	if got := sent.Query().Get("text"); got != "((cats)) AND (l:c*)" {
+		t.Errorf("Sierra text = %s, want the search narrowed to the selected branch", got)
+	}
+}
+
+// bibs without a listed language are counted under und, and selecting that bucket sends
+// Sierra a restriction matching them rather than g:und
+func TestUnknownLanguageBucketSelection(t *testing.T) {
+	spoken := func(id string, code string) JMRLBib {
+		bib := testBib(id, "Cats")
+		bib.Language = JMRLCodeValue{Code: code}
+		return bib
+	}
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(4, spoken("1001", "eng"), spoken("1002", ""),
+		spoken("1003", "tha"), spoken("1004", "und")))
+	router := newRouter(newTestService(t, sierra))
+
+	rec := apiRequest(t, router, http.MethodPost, "/api/search/facets", `{"query":"keyword: {cats}"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("facets status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp poolFacets
+	decodeTestJSON(t, rec, &resp)
+	counts := make(map[string]int)
+	for _, facet := range resp.FacetList {
+		if facet.ID == filterLanguage {
+			for _, bucket := range facet.Buckets {
+				counts[bucket.Value] = bucket.Count
+			}
+		}
+	}
+	if want := map[string]int{"eng": 1, languageUnknown: 3}; reflect.DeepEqual(counts, want) == false {
+		t.Fatalf("language counts = %v, want %v", counts, want)
+	}
+
+	body := `{"query":"keyword: {cats}","filters":[{"pool_id":"jmrl","facets":[{"facet_id":"FilterLanguage","value":"und"}]}]}`
+	if rec := apiRequest(t, router, http.MethodPost, "/api/search", body); rec.Code != http.StatusOK {
+		t.Fatalf("search status = %d: %s", rec.Code, rec.Body.String())
+	}
+	urls := sierra.requestURLs()
+	sent, _ := url.Parse(urls[len(urls)-1])
+	if got, want := sent.Query().Get("text"), "((cats)) AND ("+unknownLanguageRestriction()+")"; got != want {
+		t.Errorf("Sierra text = %s, want %s", got, want)
+	}
+}
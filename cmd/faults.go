@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sierra faults that can be injected for resilience testing
+const (
+	faultLatency      = "latency"
+	faultUnauthorized = "unauthorized"
+	faultRateLimited  = "rate_limited"
+	faultTruncated    = "truncated"
+	faultTokenFailure = "token_failure"
+)
+
+// maxFaultDuration is the longest a fault may stay active before it expires
+const maxFaultDuration = time.Hour
+
+// maxFaultLatency is the largest latency that may be injected
+const maxFaultLatency = 30 * time.Second
+
+// faultSpec is an admin request to inject a fault into a share of Sierra requests
+type faultSpec struct {
+	Type            string  `json:"type"`
+	Probability     float64 `json:"probability"`
+	DurationSeconds int     `json:"duration_seconds"`
+	LatencyMS       int     `json:"latency_ms,omitempty"`
+}
+
+// activeFault is the fault being injected and when it stops
+type activeFault struct {
+	faultSpec
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// faultInjector holds the active Sierra fault. It only exists when -faultinjection is
+// set; a nil injector never injects anything.
+type faultInjector struct {
+	lock   sync.Mutex
+	active *activeFault
+	rnd    *rand.Rand
+}
+
+func newFaultInjector() *faultInjector {
+	return &faultInjector{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// validate checks a fault request
+func (spec faultSpec) validate() error {
+	switch spec.Type {
+	case faultLatency:
+		if spec.LatencyMS <= 0 || time.Duration(spec.LatencyMS)*time.Millisecond > maxFaultLatency {
+			return fmt.Errorf("latency_ms must be between 1 and %d", maxFaultLatency/time.Millisecond)
+		}
+	case faultUnauthorized, faultRateLimited, faultTruncated, faultTokenFailure:
+	default:
+		return fmt.Errorf("unknown fault type [%s]", spec.Type)
+	}
+	if spec.Probability <= 0 || spec.Probability > 1 {
+		return fmt.Errorf("probability must be greater than 0 and at most 1")
+	}
+	if spec.DurationSeconds <= 0 || time.Duration(spec.DurationSeconds)*time.Second > maxFaultDuration {
+		return fmt.Errorf("duration_seconds must be between 1 and %d", maxFaultDuration/time.Second)
+	}
+	return nil
+}
+
+// set activates a fault, replacing any active one
+func (fi *faultInjector) set(spec faultSpec, now time.Time) (*activeFault, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	fault := &activeFault{faultSpec: spec, ExpiresAt: now.Add(time.Duration(spec.DurationSeconds) * time.Second).UTC()}
+	fi.lock.Lock()
+	defer fi.lock.Unlock()
+	fi.active = fault
+	return fault, nil
+}
+
+// clear stops any active fault
+func (fi *faultInjector) clear() {
+	fi.lock.Lock()
+	defer fi.lock.Unlock()
+	fi.active = nil
+}
+
+// current returns the active fault, or nil if there is none or it has expired
+func (fi *faultInjector) current(now time.Time) *activeFault {
+	if fi == nil {
+		return nil
+	}
+	fi.lock.Lock()
+	defer fi.lock.Unlock()
+	if fi.active != nil && now.After(fi.active.ExpiresAt) {
+		log.Printf("Injected %s fault has expired", fi.active.Type)
+		fi.active = nil
+	}
+	return fi.active
+}
+
+// inject returns the active fault if it is one of the requested types and it fires for
+// this request according to its probability. Nil is returned otherwise.
+func (fi *faultInjector) inject(types ...string) *activeFault {
+	fault := fi.current(time.Now())
+	if fault == nil {
+		return nil
+	}
+	if containsString(types, fault.Type) == false {
+		return nil
+	}
+	fi.lock.Lock()
+	fires := fi.rnd.Float64() < fault.Probability
+	fi.lock.Unlock()
+	if fires == false {
+		return nil
+	}
+	log.Printf("WARNING: injecting %s fault", fault.Type)
+	return fault
+}
+
+// injectRequestFault applies an active Sierra request fault before a request is sent.
+// Latency faults delay the request and let it continue; error faults return the error
+// Sierra would have returned. Truncation is reported so the caller can cut the body.
+// Injected latency counts against the request time budget like real latency.
+func (svc *ServiceContext) injectRequestFault(ctx context.Context, tgtURL string) (bool, *RequestError) {
+	fault := svc.Faults.inject(faultLatency, faultUnauthorized, faultRateLimited, faultTruncated)
+	if fault == nil {
+		return false, nil
+	}
+	svc.Metrics.Increment("fault_injected")
+	switch fault.Type {
+	case faultLatency:
+		select {
+		case <-time.After(time.Duration(fault.LatencyMS) * time.Millisecond):
+		case <-ctx.Done():
+			return false, budgetError(tgtURL)
+		}
+	case faultUnauthorized:
+		return false, &RequestError{StatusCode: http.StatusUnauthorized, Message: fmt.Sprintf("injected fault: %s unauthorized", tgtURL)}
+	case faultRateLimited:
+		return false, &RequestError{StatusCode: http.StatusTooManyRequests, Message: fmt.Sprintf("injected fault: %s rate limited", tgtURL)}
+	case faultTruncated:
+		return true, nil
+	}
+	return false, nil
+}
+
+// adminGetFault reports the active fault
+func (svc *ServiceContext) adminGetFault(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"fault": svc.Faults.current(time.Now())})
+}
+
+// adminSetFault activates a fault for a limited time
+func (svc *ServiceContext) adminSetFault(c *gin.Context) {
+	if requireJSONBody(c) == false {
+		return
+	}
+	var spec faultSpec
+	if err := c.BindJSON(&spec); err != nil {
+		log.Printf("ERROR: unable to parse fault request: %s", err.Error())
+		sendError(c, http.StatusBadRequest, errBadRequest, "invalid request")
+		return
+	}
+	fault, err := svc.Faults.set(spec, time.Now())
+	if err != nil {
+		log.Printf("ERROR: invalid fault request: %s", err.Error())
+		sendError(c, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+	log.Printf("WARNING: %s fault injection enabled for %d%% of Sierra requests until %s", fault.Type,
+		int(fault.Probability*100), fault.ExpiresAt.Format(time.RFC3339))
+	c.JSON(http.StatusOK, gin.H{"fault": fault})
+}
+
+// adminClearFault stops any active fault
+func (svc *ServiceContext) adminClearFault(c *gin.Context) {
+	svc.Faults.clear()
+	log.Printf("Fault injection cleared")
+	c.JSON(http.StatusOK, gin.H{"fault": nil})
+}
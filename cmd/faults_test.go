@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+func TestFaultSpecValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		spec  faultSpec
+		valid bool
+	}{
+		{"latency", faultSpec{Type: faultLatency, Probability: 0.5, DurationSeconds: 60, LatencyMS: 500}, true},
+		{"latency without delay", faultSpec{Type: faultLatency, Probability: 0.5, DurationSeconds: 60}, false},
+		{"latency too long", faultSpec{Type: faultLatency, Probability: 0.5, DurationSeconds: 60, LatencyMS: 30001}, false},
+		{"unauthorized", faultSpec{Type: faultUnauthorized, Probability: 1, DurationSeconds: 1}, true},
+		{"rate limited", faultSpec{Type: faultRateLimited, Probability: 0.1, DurationSeconds: 3600}, true},
+		{"truncated", faultSpec{Type: faultTruncated, Probability: 1, DurationSeconds: 10}, true},
+		{"token failure", faultSpec{Type: faultTokenFailure, Probability: 1, DurationSeconds: 10}, true},
+		{"unknown type", faultSpec{Type: "meteor", Probability: 1, DurationSeconds: 10}, false},
+		{"zero probability", faultSpec{Type: faultTruncated, Probability: 0, DurationSeconds: 10}, false},
+		{"probability above one", faultSpec{Type: faultTruncated, Probability: 1.5, DurationSeconds: 10}, false},
+		{"no duration", faultSpec{Type: faultTruncated, Probability: 1}, false},
+		{"duration too long", faultSpec{Type: faultTruncated, Probability: 1, DurationSeconds: 3601}, false},
+	}
+	for _, tc := range tests {
+		if err := tc.spec.validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: validate = %v, want valid %t", tc.name, err, tc.valid)
+		}
+	}
+}
+
+func TestFaultInjectorExpires(t *testing.T) {
+	var disabled *faultInjector
+	if disabled.current(time.Now()) != nil || disabled.inject(faultTruncated) != nil {
+		t.Errorf("a nil injector injected a fault")
+	}
+	fi := newFaultInjector()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if _, err := fi.set(faultSpec{Type: faultTruncated, Probability: 1, DurationSeconds: 60}, now); err != nil {
+		t.Fatalf("set failed: %s", err.Error())
+	}
+	if fi.current(now.Add(59*time.Second)) == nil {
+		t.Errorf("fault expired early")
+	}
+	if fi.current(now.Add(61*time.Second)) != nil {
+		t.Errorf("fault still active after its duration")
+	}
+	if _, err := fi.set(faultSpec{Type: faultTruncated, Probability: 1, DurationSeconds: 60}, time.Now()); err != nil {
+		t.Fatalf("set failed: %s", err.Error())
+	}
+	if fi.inject(faultUnauthorized, faultRateLimited) != nil {
+		t.Errorf("a truncation fault fired for other fault types")
+	}
+	fi.clear()
+	if fi.inject(faultTruncated) != nil {
+		t.Errorf("a cleared fault fired")
+	}
+}
+
+// the fault endpoints only exist with -faultinjection and are staff only
+func TestFaultEndpoints(t *testing.T) {
+	disabled := newRouter(newTestService(t, nil))
+	if rec := apiRequestAs(t, disabled, v4jwt.Staff, http.MethodGet, "/api/admin/fault", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("fault endpoint without -faultinjection = %d, want 404", rec.Code)
+	}
+	if rec := apiRequestAs(t, disabled, v4jwt.Staff, http.MethodPost, "/api/admin/fault", `{"type":"truncated","probability":1,"duration_seconds":60}`); rec.Code != http.StatusNotFound {
+		t.Errorf("fault set without -faultinjection = %d, want 404", rec.Code)
+	}
+
+	cfg := newTestConfig("http://sierra.invalid" + fakeSierraBase)
+	cfg.FaultInjection = true
+	svc := newTestServiceWithConfig(t, cfg)
+	router := newRouter(svc)
+	body := `{"type":"rate_limited","probability":0.25,"duration_seconds":60}`
+	if rec := apiRequest(t, router, http.MethodPost, "/api/admin/fault", body); rec.Code != http.StatusForbidden {
+		t.Errorf("fault set by a patron = %d, want 403", rec.Code)
+	}
+	if rec := apiRequestAs(t, router, v4jwt.Staff, http.MethodPost, "/api/admin/fault", `{"type":"rate_limited","probability":2,"duration_seconds":60}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid fault = %d, want 400", rec.Code)
+	}
+	if rec := apiRequestAs(t, router, v4jwt.Staff, http.MethodPost, "/api/admin/fault", body); rec.Code != http.StatusOK {
+		t.Fatalf("fault set = %d: %s", rec.Code, rec.Body.String())
+	}
+	var status struct {
+		Fault *activeFault `json:"fault"`
+	}
+	decodeTestJSON(t, apiRequestAs(t, router, v4jwt.Staff, http.MethodGet, "/api/admin/status", ""), &status)
+	if status.Fault == nil || status.Fault.Type != faultRateLimited || status.Fault.Probability != 0.25 {
+		t.Errorf("admin status fault = %+v, want the rate limit fault", status.Fault)
+	}
+	if rec := apiRequestAs(t, router, v4jwt.Staff, http.MethodDelete, "/api/admin/fault", ""); rec.Code != http.StatusOK {
+		t.Fatalf("fault clear = %d", rec.Code)
+	}
+	status.Fault = nil
+	decodeTestJSON(t, apiRequestAs(t, router, v4jwt.Staff, http.MethodGet, "/api/admin/fault", ""), &status)
+	if status.Fault != nil {
+		t.Errorf("fault after clear = %+v, want none", status.Fault)
+	}
+}
+
+// each fault type produces the response a real Sierra failure of that kind would
+func TestInjectedFaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		body     string
+		status   int
+		code     errorCode
+		searched int
+		minTime  time.Duration
+	}{
+		{"latency", `{"type":"latency","probability":1,"duration_seconds":60,"latency_ms":150}`,
+			`{"query":"keyword: {cats}"}`, http.StatusOK, "", 1, 150 * time.Millisecond},
+		{"latency past the time budget", `{"type":"latency","probability":1,"duration_seconds":60,"latency_ms":2000}`,
+			`{"query":"keyword: {cats}","timeout_ms":100}`, http.StatusRequestTimeout, errTimeout, 0, 100 * time.Millisecond},
+		{"unauthorized", `{"type":"unauthorized","probability":1,"duration_seconds":60}`,
+			`{"query":"keyword: {cats}"}`, http.StatusUnauthorized, errUpstreamError, 0, 0},
+		{"rate limited", `{"type":"rate_limited","probability":1,"duration_seconds":60}`,
+			`{"query":"keyword: {cats}"}`, http.StatusTooManyRequests, errRateLimited, 0, 0},
+		{"truncated", `{"type":"truncated","probability":1,"duration_seconds":60}`,
+			`{"query":"keyword: {cats}"}`, http.StatusInternalServerError, errUpstreamError, 1, 0},
+		{"token failure", `{"type":"token_failure","probability":1,"duration_seconds":60}`,
+			`{"query":"keyword: {cats}"}`, http.StatusUnauthorized, errUpstreamError, 0, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sierra := newFakeSierra(t)
+			sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "Cats")))
+			cfg := newTestConfig(sierra.apiURL())
+			cfg.FaultInjection = true
+			svc := newTestServiceWithConfig(t, cfg)
+			router := newRouter(svc)
+			if rec := apiRequestAs(t, router, v4jwt.Staff, http.MethodPost, "/api/admin/fault", tc.spec); rec.Code != http.StatusOK {
+				t.Fatalf("fault set = %d: %s", rec.Code, rec.Body.String())
+			}
+			start := time.Now()
+			rec := apiRequest(t, router, http.MethodPost, "/api/search", tc.body)
+			elapsed := time.Since(start)
+			if rec.Code != tc.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.status, rec.Body.String())
+			}
+			var resp poolErrorResult
+			decodeTestJSON(t, rec, &resp)
+			if resp.ErrorCode != tc.code {
+				t.Errorf("error code = %q, want %q", resp.ErrorCode, tc.code)
+			}
+			if elapsed < tc.minTime {
+				t.Errorf("search took %s, want at least %s", elapsed, tc.minTime)
+			}
+			if got := sierra.count("bibs/search"); got != tc.searched {
+				t.Errorf("%d searches reached Sierra, want %d", got, tc.searched)
+			}
+			if svc.Metrics.Snapshot()["fault_injected"] == 0 {
+				t.Errorf("fault_injected metric not incremented")
+			}
+		})
+	}
+}
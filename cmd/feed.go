@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// feed settings: default and max items, max subject length, cache lifetime and size
+const feedDefaultLimit = 25
+const feedMaxLimit = sierraMaxLimit
+const feedMaxSubject = 200
+const feedTTL = 15 * time.Minute
+const feedCacheMax = 500
+
+// feedPath is the route of the feed, which has its own CORS handling
+const feedPath = "/api/feed"
+
+// virgoItemURL is the Virgo permalink format for a JMRL record
+const virgoItemURL = "https://search.lib.virginia.edu/sources/jmrl/items/%s"
+
+// FeedItem is a compact record suitable for embedding in LibGuides
+type FeedItem struct {
+	Title        string `json:"title"`
+	Author       string `json:"author,omitempty"`
+	Format       string `json:"format,omitempty"`
+	CoverURL     string `json:"cover_url,omitempty"`
+	Permalink    string `json:"permalink"`
+	Availability string `json:"availability"`
+}
+
+// feedParams are the validated feed query parameters
+type feedParams struct {
+	Subject   string
+	Available bool
+	Limit     int
+}
+
+// subject identifies the feed parameters in the feed cache key
+func (fp feedParams) subject() string {
+	return fmt.Sprintf("%s|%t|%d", normalizeMatchText(fp.Subject), fp.Available, fp.Limit)
+}
+
+// parseFeedParams validates the feed query parameters
+func parseFeedParams(c *gin.Context) (feedParams, error) {
+	out := feedParams{Limit: feedDefaultLimit}
+	out.Subject = strings.Join(strings.Fields(strings.ReplaceAll(c.Query("subject"), `"`, " ")), " ")
+	if out.Subject == "" {
+		return out, fmt.Errorf("subject is required")
+	}
+	if len([]rune(out.Subject)) > feedMaxSubject {
+		return out, fmt.Errorf("subject may be at most %d characters", feedMaxSubject)
+	}
+	if avail := c.Query("available"); avail != "" {
+		val, err := strconv.ParseBool(avail)
+		if err != nil {
+			return out, fmt.Errorf("available must be true or false")
+		}
+		out.Available = val
+	}
+	if limit := c.Query("limit"); limit != "" {
+		val, err := strconv.Atoi(limit)
+		if err != nil || val < 1 || val > feedMaxLimit {
+			return out, fmt.Errorf("limit must be between 1 and %d", feedMaxLimit)
+		}
+		out.Limit = val
+	}
+	return out, nil
+}
+
+// feedOriginAllowed returns true if the request Origin may use the feed. With no
+// configured origins, all origins are allowed.
+func (svc *ServiceContext) feedOriginAllowed(origin string) bool {
+	if svc.Config.FeedOrigins == "" || origin == "" {
+		return true
+	}
+	for _, allowed := range strings.Split(svc.Config.FeedOrigins, ",") {
+		if strings.EqualFold(strings.TrimSpace(allowed), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// feedCORS sets the CORS headers of the feed. The allowed origin is echoed back rather
+// than *, with Vary: Origin so shared caches keep the responses of each origin apart.
+// Preflight requests end here; disallowed origins get no CORS headers.
+func (svc *ServiceContext) feedCORS(c *gin.Context) {
+	c.Writer.Header().Add("Vary", "Origin")
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		return
+	}
+	if svc.feedOriginAllowed(origin) == false {
+		if c.Request.Method == http.MethodOptions {
+			sendError(c, http.StatusForbidden, errForbidden, "origin not allowed")
+		}
+		return
+	}
+	c.Header("Access-Control-Allow-Origin", origin)
+	c.Header("Access-Control-Allow-Credentials", "true")
+	if c.Request.Method == http.MethodOptions {
+		c.Header("Access-Control-Allow-Methods", "GET, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Authorization, Accept-Language")
+		c.Header("Access-Control-Max-Age", "43200")
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// feed returns the newest JMRL holdings for a subject as a compact JSON array
+func (svc *ServiceContext) feed(c *gin.Context) {
+	if svc.feedOriginAllowed(c.GetHeader("Origin")) == false {
+		log.Printf("WARNING: feed request from origin %s is not allowed", c.GetHeader("Origin"))
+		sendError(c, http.StatusForbidden, errForbidden, "origin not allowed")
+		return
+	}
+	fp, err := parseFeedParams(c)
+	if err != nil {
+		log.Printf("ERROR: invalid feed request: %s", err.Error())
+		sendError(c, http.StatusBadRequest, errBadRequest, err.Error())
+		return
+	}
+
+	now := time.Now()
+	key := svc.requestCacheKey(c, "feed", fp.subject(), viewBrief)
+	c.Writer.Header().Add("Vary", "Accept-Language")
+	setCacheKeyHeader(c, key)
+	cached, age, found := svc.Feeds.lookup(key, now)
+	recordCacheStatus(c, newCacheStatus(found, age))
+	if found {
+		svc.Metrics.Increment("feed_cache_hit")
+		c.Header("Cache-Control", "public, max-age=900")
+		c.JSON(http.StatusOK, cached.([]FeedItem))
+		return
+	}
+	svc.Metrics.Increment("feed_cache_miss")
+
+	// build the query the same way as a V4 subject search
+	sierraQ, qErr := translateQuery(tokenizeQuery(fmt.Sprintf(`subject: {"%s"}`, fp.Subject)))
+	if qErr != nil {
+		sendError(c, http.StatusBadRequest, errQueryMalformed, qErr.Error())
+		return
+	}
+	params := url.Values{}
+	params.Set("text", sierraQ)
+	params.Set("fields", bibFields)
+	newest := v4api.SortOrder{SortID: sortNewest, Order: "desc"}
+	applySierraSort(params, newest)
+
+	// a detailed trace keeps the Sierra URLs for the cache entry
+	trace := newRequestTrace(true)
+	var jmrlResp *JMRLResult
+	var reqErr *RequestError
+	if fp.Available {
+		onShelf := availabilityFilter{OnShelf: true}
+		jmrlResp, _, reqErr = svc.postFilteredSearch(c.Request.Context(), params, 0, fp.Limit, newest, onShelf.matches, trace)
+	} else {
+		params.Set("offset", "0")
+		params.Set("limit", fmt.Sprintf("%d", fp.Limit))
+		jmrlResp, reqErr = svc.searchBibs(c.Request.Context(), params, trace)
+	}
+	if reqErr != nil {
+		sendError(c, reqErr.StatusCode, reqErr.errorCode(), reqErr.Message)
+		return
+	}
+
+	out := make([]FeedItem, 0, len(jmrlResp.Entries))
+	for _, entry := range jmrlResp.Entries {
+		out = append(out, svc.getFeedItem(&entry.Bib))
+	}
+	svc.Feeds.put(key, out, newCacheSource(time.Now(), trace.urls()...), now)
+	c.Header("Cache-Control", "public, max-age=900")
+	c.JSON(http.StatusOK, out)
+}
+
+// getFeedItem projects a bib into a feed item
+func (svc *ServiceContext) getFeedItem(bib *JMRLBib) FeedItem {
+	svc.suppressVarFields(bib)
+	title := strings.TrimSpace(bib.Title)
+	if vals := getVarField(&bib.VarFields, "245", "a"); len(vals) > 0 {
+		title = stripTrailingData(vals[0])
+	}
+	item := FeedItem{Title: title, Format: bib.Type.Value, Permalink: fmt.Sprintf(virgoItemURL, bib.ID)}
+	if authors := getAuthorValues(bib); len(authors) > 0 {
+		item.Author = authors[0].Display
+	} else {
+		item.Author = strings.TrimSpace(bib.Author)
+	}
+	if svc.Config.CoverURL != "" {
+		if isbns := getISBNs(bib); len(isbns) > 0 {
+			item.CoverURL = strings.ReplaceAll(svc.Config.CoverURL, "{isbn}", url.PathEscape(isbns[0]))
+		}
+	}
+	item.Availability = unavailableClass
+	if bib.Available {
+		item.Availability = availableClass
+	} else if hasOnlineAccess(bib) {
+		item.Availability = onlineClass
+	}
+	return item
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+func TestParseFeedParams(t *testing.T) {
+	tests := []struct {
+		query string
+		want  feedParams
+		err   string
+	}{
+		{"subject=cats", feedParams{Subject: "cats", Limit: feedDefaultLimit}, ""},
+		{"subject=%20Virginia%20%20--%20History%20&available=true&limit=10", feedParams{Subject: "Virginia -- History", Available: true, Limit: 10}, ""},
+		{"subject=%22cats%22&available=false&limit=50", feedParams{Subject: "cats", Limit: 50}, ""},
+		{"", feedParams{}, "subject is required"},
+		{"subject=%22%22", feedParams{}, "subject is required"},
+		{"subject=" + strings.Repeat("a", feedMaxSubject+1), feedParams{}, "subject may be at most 200 characters"},
+		{"subject=cats&available=maybe", feedParams{}, "available must be true or false"},
+		{"subject=cats&limit=0", feedParams{}, "limit must be between 1 and 50"},
+		{"subject=cats&limit=51", feedParams{}, "limit must be between 1 and 50"},
+		{"subject=cats&limit=ten", feedParams{}, "limit must be between 1 and 50"},
+	}
+	for _, tc := range tests {
+		c, _ := newTestContext(http.MethodGet, "/api/feed?"+tc.query, nil)
+		got, err := parseFeedParams(c)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("parseFeedParams(%q) error = %v, want %q", tc.query, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("parseFeedParams(%q) = %+v %v, want %+v", tc.query, got, err, tc.want)
+		}
+	}
+}
+
+func TestFeed(t *testing.T) {
+	sierra := newFakeSierra(t)
+	cats := testBib("1001", "Cats of the world /")
+	cats.Available = true
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(2, cats, testBib("1002", "Dogs")))
+	svc := newTestService(t, sierra)
+	router := newRouter(svc)
+
+	tests := []struct {
+		name     string
+		query    string
+		searches int
+	}{
+		{"miss", "subject=Cats&limit=2", 1},
+		// the cache is keyed by the normalized parameters
+		{"hit", "subject=%20cats%20&limit=2", 1},
+		{"other limit", "subject=cats&limit=3", 2},
+	}
+	for _, tc := range tests {
+		rec := apiRequest(t, router, http.MethodGet, "/api/feed?"+tc.query, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tc.name, rec.Code, rec.Body.String())
+		}
+		var got []FeedItem
+		decodeTestJSON(t, rec, &got)
+		want := []FeedItem{
+			{Title: "Cats of the world", Author: "Author, Test", Format: "Book", Permalink: "https://search.lib.virginia.edu/sources/jmrl/items/1001", Availability: availableClass},
+			{Title: "Dogs", Author: "Author, Test", Format: "Book", Permalink: "https://search.lib.virginia.edu/sources/jmrl/items/1002", Availability: unavailableClass},
+		}
+		if reflect.DeepEqual(got, want) == false {
+			t.Errorf("%s: feed = %+v, want %+v", tc.name, got, want)
+		}
+		if n := sierra.count("bibs/search"); n != tc.searches {
+			t.Errorf("%s: %d Sierra searches, want %d", tc.name, n, tc.searches)
+		}
+		if got := rec.Header().Get("Cache-Control"); got != "public, max-age=900" {
+			t.Errorf("%s: Cache-Control = %q", tc.name, got)
+		}
+	}
+	metrics := svc.Metrics.Snapshot()
+	if metrics["feed_cache_hit"] != 1 || metrics["feed_cache_miss"] != 2 {
+		t.Errorf("feed cache hits %d misses %d, want 1 and 2", metrics["feed_cache_hit"], metrics["feed_cache_miss"])
+	}
+
+	// the subject search is built the way a V4 subject search is, newest first
+	var sent *url.URL
+	for _, u := range sierra.requestURLs() {
+		if strings.Contains(u, "bibs/search") {
+			sent, _ = url.Parse(u)
+			break
+		}
+	}
+	want := url.Values{}
+	want.Set("text", `d:("Cats")`)
+	want.Set("fields", bibFields)
+	want.Set("sort", "createdDate")
+	want.Set("sortOrder", "desc")
+	want.Set("offset", "0")
+	want.Set("limit", "2")
+	if reflect.DeepEqual(sent.Query(), want) == false {
+		t.Errorf("Sierra feed params = %v, want %v", sent.Query(), want)
+	}
+}
+
+func TestFeedRejectsInvalidParams(t *testing.T) {
+	sierra := newFakeSierra(t)
+	router := newRouter(newTestService(t, sierra))
+	for _, query := range []string{"", "subject=cats&limit=100", "subject=cats&available=yes"} {
+		rec := apiRequest(t, router, http.MethodGet, "/api/feed?"+query, "")
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("feed %q status = %d, want 400", query, rec.Code)
+		}
+	}
+	if n := sierra.count("bibs/search"); n != 0 {
+		t.Errorf("%d Sierra searches for invalid feeds", n)
+	}
+}
+
+func TestFeedCORS(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "Cats")))
+	const guides = "https://guides.example.org"
+
+	tests := []struct {
+		name    string
+		origins string
+		method  string
+		origin  string
+		status  int
+		acao    string
+	}{
+		{"allowed", guides + ", https://other.example.org", http.MethodGet, guides, http.StatusOK, guides},
+		{"allowed other case", guides, http.MethodGet, "https://GUIDES.example.org", http.StatusOK, "https://GUIDES.example.org"},
+		{"disallowed", guides, http.MethodGet, "https://evil.example.org", http.StatusForbidden, ""},
+		{"no origin", guides, http.MethodGet, "", http.StatusOK, ""},
+		{"unconfigured", "", http.MethodGet, "https://evil.example.org", http.StatusOK, "https://evil.example.org"},
+		{"preflight allowed", guides, http.MethodOptions, guides, http.StatusNoContent, guides},
+		{"preflight disallowed", guides, http.MethodOptions, "https://evil.example.org", http.StatusForbidden, ""},
+	}
+	for _, tc := range tests {
+		cfg := newTestConfig(sierra.apiURL())
+		cfg.FeedOrigins = tc.origins
+		router := newRouter(newTestServiceWithConfig(t, cfg))
+		req := httptest.NewRequest(tc.method, "/api/feed?subject=cats", nil)
+		if tc.method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.User))
+		}
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Errorf("%s: status = %d, want %d", tc.name, rec.Code, tc.status)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tc.acao {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", tc.name, got, tc.acao)
+		}
+		vary := strings.Join(rec.Header().Values("Vary"), ", ")
+		if strings.Contains(vary, "Origin") == false {
+			t.Errorf("%s: Vary = %q, want Origin", tc.name, vary)
+		}
+		if tc.status == http.StatusOK && strings.Contains(vary, "Accept-Language") == false {
+			t.Errorf("%s: Vary = %q, want Accept-Language", tc.name, vary)
+		}
+		if tc.status == http.StatusNoContent && rec.Header().Get("Access-Control-Allow-Methods") != "GET, OPTIONS" {
+			t.Errorf("%s: Access-Control-Allow-Methods = %q", tc.name, rec.Header().Get("Access-Control-Allow-Methods"))
+		}
+	}
+
+	// the other routes keep the open CORS configuration
+	cfg := newTestConfig(sierra.apiURL())
+	cfg.FeedOrigins = guides
+	req := httptest.NewRequest(http.MethodGet, "/version", nil)
+	req.Header.Set("Origin", "https://evil.example.org")
+	rec := httptest.NewRecorder()
+	newRouter(newTestServiceWithConfig(t, cfg)).ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("version Access-Control-Allow-Origin = %q, want *", got)
+	}
+}
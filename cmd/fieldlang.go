@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// workLanguageFields are the fields written in the language of the work itself
+var workLanguageFields = []string{"title", "subtitle", "contents", "summary"}
+
+// headingLanguageFields are the fields taken from LCSH subject and genre headings, which
+// are always English
+var headingLanguageFields = []string{"subject", "subject_more", "genre"}
+
+// workLanguage returns the ISO 639-1 code of the language of the work (see
+// marcLanguageCode). Empty is returned if it is unknown or has no ISO code, like mul.
+func workLanguage(bib *JMRLBib) string {
+	if lang, ok := lookupLanguage(marcLanguageCode(bib)); ok {
+		return lang.ISO
+	}
+	return ""
+}
+
+// primaryLanguage returns the lowercase primary subtag of a language tag: en-US => en
+func primaryLanguage(tag string) string {
+	primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	return primary
+}
+
+// getFieldLanguageFields returns hidden <name>_language fields giving the language of
+// fields whose language differs from the response content language, so screen readers
+// can pronounce them correctly. Work text (title, summary) is in the language of the
+// work; subject and genre headings are LCSH and so English. One tag is emitted per field name
+// present in fields.
+func getFieldLanguageFields(bib *JMRLBib, fields []v4api.RecordField, contentLang string) []v4api.RecordField {
+	out := make([]v4api.RecordField, 0)
+	present := make(map[string]bool)
+	for _, f := range fields {
+		present[f.Name] = true
+	}
+	responseLang := primaryLanguage(contentLang)
+	tag := func(names []string, lang string) {
+		if lang == "" || lang == responseLang {
+			return
+		}
+		for _, name := range names {
+			if present[name] {
+				out = append(out, v4api.RecordField{Name: name + "_language", Type: "language_tag",
+					Value: lang, Visibility: "hidden"})
+			}
+		}
+	}
+	tag(workLanguageFields, workLanguage(bib))
+	tag(headingLanguageFields, "en")
+	return out
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// languageTags returns the <name>_language tags of the fields, keyed by tagged field name
+func languageTags(t *testing.T, svc *ServiceContext, bib JMRLBib, lang string) map[string]string {
+	fields := svc.getResultFields(&bib, fieldOptions{View: viewFull, Language: lang, Localizer: testLocalizer(svc, lang)})
+	out := make(map[string]string)
+	for _, f := range fields {
+		if name, found := strings.CutSuffix(f.Name, "_language"); found {
+			if f.Visibility != "hidden" {
+				t.Errorf("%s visibility = %q, want hidden", f.Name, f.Visibility)
+			}
+			out[name] = f.Value
+		}
+	}
+	return out
+}
+
+func TestFieldLanguageTags(t *testing.T) {
+	svc := newTestService(t, nil)
+	var spanish, bilingual JMRLBib
+	loadFixture(t, "bib_1001.json", &spanish)
+	loadFixture(t, "bib_7007007.json", &bilingual)
+	multiple := testBib("1002", "Stories")
+	multiple.Language = JMRLCodeValue{Code: "mul", Name: "Multiple"}
+	multiple.VarFields = append(multiple.VarFields, marcField("650", "a", "Short stories."), marcField("655", "a", "Anthologies."))
+
+	tests := []struct {
+		name string
+		bib  JMRLBib
+		lang string
+		want map[string]string
+	}{
+		// Spanish work text in an English response; the LCSH subjects need no tag
+		{"spanish in english", spanish, "en-US", map[string]string{"title": "es", "summary": "es"}},
+		{"spanish in spanish", spanish, "es", map[string]string{"subject": "en"}},
+		{"spanish in regional spanish", spanish, "es-MX", map[string]string{"subject": "en"}},
+		// the work language comes from 041 when the bib language is undetermined
+		{"041 in english", bilingual, "en", map[string]string{"title": "es", "subtitle": "es", "contents": "es", "summary": "es"}},
+		{"041 in spanish", bilingual, "es", map[string]string{"subject": "en", "genre": "en"}},
+		// no single work language; headings are still English
+		{"multiple in spanish", multiple, "es", map[string]string{"subject": "en", "genre": "en"}},
+		{"multiple in english", multiple, "en", map[string]string{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := languageTags(t, svc, tc.bib, tc.lang); reflect.DeepEqual(got, tc.want) == false {
+				t.Errorf("language tags = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPrimaryLanguage(t *testing.T) {
+	tests := map[string]string{"en-US": "en", " ES ": "es", "es-419": "es", "fr": "fr", "": ""}
+	for tag, want := range tests {
+		if got := primaryLanguage(tag); got != want {
+			t.Errorf("primaryLanguage(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// knownRecordFields are the record field names this pool emits. Overrides for any other
+// name are reported since they can never apply.
+var knownRecordFields = map[string]bool{
+	"id": true, "location": true, "publication_date": true, "format": true, "language": true,
+	"title": true, "title_collation_key": true, "brief_record_note": true, "subtitle": true,
+	"isbn": true, "call_number": true, "author": true, "subject": true, "subject_more": true,
+	"contents": true, "summary": true, "summary_truncated": true, "audience_age": true,
+	"audience_age_fixed": true, "published": true, "access_url": true, "availability": true,
+	"availability_class": true, "earliest_due": true, "earliest_due_iso": true,
+	"nearest_branch_distance": true, "series": true, "title_language": true, "subtitle_language": true,
+	"relevance": true, "contents_language": true, "summary_language": true, "subject_language": true, "subject_more_language": true,
+	"date_added": true, "date_added_iso": true, "language_code": true, "genre": true, "genre_language": true, "citation_title": true,
+}
+
+// visibility values accepted in the overrides file; basic is the V4 default (empty)
+var overrideVisibility = map[string]string{"basic": "", "detailed": "detailed", "hidden": "hidden"}
+
+// FieldOverride changes how a record field is presented. Empty values leave the
+// field unchanged.
+type FieldOverride struct {
+	Visibility string `toml:"visibility"`
+	Display    string `toml:"display"`
+}
+
+// fieldOverrides holds the field presentation overrides, keyed by field name
+type fieldOverrides struct {
+	lock      sync.RWMutex
+	overrides map[string]FieldOverride
+}
+
+// loadFieldOverrides reads a TOML file of [fields.<name>] tables with visibility
+// (basic, detailed or hidden) and display values
+func loadFieldOverrides(filename string) (map[string]FieldOverride, error) {
+	var cfg struct {
+		Fields map[string]FieldOverride `toml:"fields"`
+	}
+	if _, err := toml.DecodeFile(filename, &cfg); err != nil {
+		return nil, err
+	}
+	for name, override := range cfg.Fields {
+		if knownRecordFields[name] == false {
+			log.Printf("WARNING: field override for unknown field %s in %s", name, filename)
+		}
+		if override.Visibility != "" {
+			if _, ok := overrideVisibility[override.Visibility]; ok == false {
+				return nil, fmt.Errorf("field %s in %s has invalid visibility %s", name, filename, override.Visibility)
+			}
+		}
+	}
+	if cfg.Fields == nil {
+		cfg.Fields = make(map[string]FieldOverride)
+	}
+	return cfg.Fields, nil
+}
+
+// reloadFieldOverrides (re)loads the field overrides file. On failure the previous
+// overrides stay in effect.
+func (svc *ServiceContext) reloadFieldOverrides() error {
+	if svc.Config.FieldCfg == "" {
+		return nil
+	}
+	log.Printf("Load field overrides from %s", svc.Config.FieldCfg)
+	overrides, err := loadFieldOverrides(svc.Config.FieldCfg)
+	if err != nil {
+		log.Printf("ERROR: field overrides rejected, keeping previous values: %s", err.Error())
+		svc.setReloadStatus("field_overrides", false, err.Error())
+		return err
+	}
+	svc.FieldOverrides.lock.Lock()
+	svc.FieldOverrides.overrides = overrides
+	svc.FieldOverrides.lock.Unlock()
+	svc.recordLoadedFile("field_overrides", svc.Config.FieldCfg)
+	svc.setReloadStatus("field_overrides", true, fmt.Sprintf("%d field overrides loaded", len(overrides)))
+	return nil
+}
+
+// applyFieldOverrides applies the configured presentation overrides to assembled fields
+func (svc *ServiceContext) applyFieldOverrides(fields []v4api.RecordField) {
+	svc.FieldOverrides.lock.RLock()
+	defer svc.FieldOverrides.lock.RUnlock()
+	if len(svc.FieldOverrides.overrides) == 0 {
+		return
+	}
+	for i := range fields {
+		override, found := svc.FieldOverrides.overrides[fields[i].Name]
+		if found == false {
+			continue
+		}
+		if override.Visibility != "" {
+			fields[i].Visibility = overrideVisibility[override.Visibility]
+		}
+		if override.Display != "" {
+			fields[i].Display = override.Display
+		}
+	}
+}
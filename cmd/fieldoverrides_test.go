@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+// writeOverrides writes a field overrides file to the test directory
+func writeOverrides(t *testing.T, filename string, content string) {
+	t.Helper()
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatalf("unable to write %s: %s", filename, err.Error())
+	}
+}
+
+// fieldVisibility returns the visibility and display of the first field with the name
+func fieldVisibility(fields []v4api.RecordField, name string) (string, string, bool) {
+	for _, f := range fields {
+		if f.Name == name {
+			return f.Visibility, f.Display, true
+		}
+	}
+	return "", "", false
+}
+
+func TestLoadFieldOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]FieldOverride
+		warning string
+		err     bool
+	}{
+		{"visibility and display", "[fields.summary]\nvisibility = \"basic\"\n\n[fields.series]\nvisibility = \"detailed\"\ndisplay = \"optional\"\n",
+			map[string]FieldOverride{"summary": {Visibility: "basic"}, "series": {Visibility: "detailed", Display: "optional"}}, "", false},
+		{"empty", "", map[string]FieldOverride{}, "", false},
+		{"unknown field", "[fields.colophon]\nvisibility = \"hidden\"\n",
+			map[string]FieldOverride{"colophon": {Visibility: "hidden"}}, "unknown field colophon", false},
+		{"invalid visibility", "[fields.summary]\nvisibility = \"secret\"\n", nil, "", true},
+		{"invalid toml", "[fields.summary\n", nil, "", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "fields.toml")
+			writeOverrides(t, filename, tc.content)
+			var got map[string]FieldOverride
+			var err error
+			logged := captureLog(func() { got, err = loadFieldOverrides(filename) })
+			if (err != nil) != tc.err {
+				t.Fatalf("loadFieldOverrides error = %v, want error %t", err, tc.err)
+			}
+			if tc.err == false && reflect.DeepEqual(got, tc.want) == false {
+				t.Errorf("overrides = %+v, want %+v", got, tc.want)
+			}
+			if tc.warning != "" && strings.Contains(logged, "WARNING: field override for "+tc.warning) == false {
+				t.Errorf("log %q does not warn about %s", logged, tc.warning)
+			}
+			if tc.warning == "" && strings.Contains(logged, "WARNING") {
+				t.Errorf("unexpected warning: %s", logged)
+			}
+		})
+	}
+}
+
+// every field the pool emits must be overridable without a warning
+func TestKnownRecordFieldsCoverEmittedFields(t *testing.T) {
+	svc := newTestService(t, nil)
+	bib := testBib("1001", "Cien años de soledad")
+	bib.Language = JMRLCodeValue{Code: "spa", Name: "Spanish"}
+	bib.CreatedDate = "2024-01-02T03:04:05Z"
+	bib.VarFields = append(bib.VarFields, marcField("020", "a", "9780307474728"), marcField("092", "a", "FIC GAR"),
+		marcField("245", "b", "una novela"), marcField("490", "a", "Biblioteca"), marcField("505", "a", "Contents."),
+		marcField("520", "a", "Summary."), marcField("521", "a", "Ages 14-18."), marcField("650", "a", "Families"),
+		marcField("655", "a", "Magic realism"), marcField("856", "u", "https://jmrl.overdrive.com/1001"))
+	for _, view := range []string{viewBrief, viewFull} {
+		fields := svc.getResultFields(&bib, fieldOptions{View: view, Language: "en", Localizer: testLocalizer(svc, "en")})
+		for _, f := range fields {
+			if knownRecordFields[f.Name] == false {
+				t.Errorf("%s view emits %s, which is not in knownRecordFields", view, f.Name)
+			}
+		}
+	}
+}
+
+// overrides are a final pass over both search and resource records, and a reload
+// replaces them without a restart
+func TestFieldOverridesApplyToSearchAndResource(t *testing.T) {
+	bib := testBib("1001", "Gardening")
+	bib.VarFields = append(bib.VarFields, marcField("520", "a", "All about roses."))
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, bib))
+	sierra.handleJSON("bibs/1001", http.StatusOK, bib)
+	sierra.handleJSON("items", http.StatusOK, map[string]interface{}{"total": 0, "entries": []interface{}{}})
+
+	filename := filepath.Join(t.TempDir(), "fields.toml")
+	writeOverrides(t, filename, "[fields.author]\nvisibility = \"hidden\"\n\n[fields.title]\ndisplay = \"optional\"\n")
+	cfg := newTestConfig(sierra.apiURL())
+	cfg.FieldCfg = filename
+	svc := newTestServiceWithConfig(t, cfg)
+	if err := svc.reloadFieldOverrides(); err != nil {
+		t.Fatalf("reloadFieldOverrides failed: %s", err.Error())
+	}
+	router := newRouter(svc)
+
+	records := func() map[string][]v4api.RecordField {
+		t.Helper()
+		out := make(map[string][]v4api.RecordField)
+		rec := apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"keyword: {gardening}","pagination":{"rows":20}}`)
+		var search v4api.PoolResult
+		decodeTestJSON(t, rec, &search)
+		if len(search.Groups) != 1 || len(search.Groups[0].Records) != 1 {
+			t.Fatalf("search status %d returned %d groups", rec.Code, len(search.Groups))
+		}
+		out["search"] = search.Groups[0].Records[0].Fields
+		rec = apiRequest(t, router, http.MethodGet, "/api/resource/1001", "")
+		var resource struct {
+			Fields []v4api.RecordField `json:"fields"`
+		}
+		decodeTestJSON(t, rec, &resource)
+		out["resource"] = resource.Fields
+		return out
+	}
+
+	for source, fields := range records() {
+		if vis, _, _ := fieldVisibility(fields, "author"); vis != "hidden" {
+			t.Errorf("%s author visibility = %q, want hidden", source, vis)
+		}
+		if _, display, _ := fieldVisibility(fields, "title"); display != "optional" {
+			t.Errorf("%s title display = %q, want optional", source, display)
+		}
+	}
+
+	writeOverrides(t, filename, "[fields.summary]\nvisibility = \"detailed\"\n")
+	rec := apiRequestAs(t, router, v4jwt.Staff, http.MethodPost, "/api/admin/reload", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reload status = %d: %s", rec.Code, rec.Body.String())
+	}
+	for source, fields := range records() {
+		if vis, _, _ := fieldVisibility(fields, "author"); vis == "hidden" {
+			t.Errorf("%s author is still hidden after reload", source)
+		}
+		if vis, _, found := fieldVisibility(fields, "summary"); found == false || vis != "detailed" {
+			t.Errorf("%s summary visibility = %q found %t, want detailed", source, vis, found)
+		}
+	}
+
+	// a rejected file keeps the overrides in effect
+	writeOverrides(t, filename, "[fields.summary]\nvisibility = \"secret\"\n")
+	if rec := apiRequestAs(t, router, v4jwt.Staff, http.MethodPost, "/api/admin/reload", ""); rec.Code != http.StatusInternalServerError {
+		t.Errorf("invalid reload status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	for source, fields := range records() {
+		if vis, _, _ := fieldVisibility(fields, "summary"); vis != "detailed" {
+			t.Errorf("%s summary visibility = %q after a rejected reload, want detailed", source, vis)
+		}
+	}
+}
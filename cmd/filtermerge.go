@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// singleValuedFacets are the filters on a value a bib has only one of (a single material
+// type, a single language), so two different required values can never both match
+var singleValuedFacets = map[string]bool{filterFormat: true, filterLanguage: true}
+
+// filterValue is one facet value of a V4 filter. It has the same shape as the facets of
+// v4api.Filter so it can be appended to them.
+type filterValue struct {
+	FacetID string `json:"facet_id"`
+	Value   string `json:"value"`
+}
+
+// mergeFilters combines the filter: clauses ANDed at the top level of the query with the
+// request filters into a single filter, so a filter sent both ways is applied once. Values
+// are matched case-insensitively per facet and duplicates collapse to the first spelling
+// seen. A query clause is required, so the facet is narrowed to the intersection of its
+// value and the selected values; ORing them would loosen the query. A bib can have several
+// values of a multi-valued facet (held at two branches), so when that intersection is not
+// a single value the required values are returned as restrictions to AND with the filter.
+// Lifted clauses are removed from the returned tokens; filter clauses inside other
+// expressions stay in the query. An error names the conflict when a single-valued facet
+// is required to have two different values, or a value the request filters exclude.
+// EX: cats AND filter: {FilterFormat:"book"} + FilterFormat Book, DVD => cats + FilterFormat book
+// EX: filter: {FilterLibrary:"Central"} AND filter: {FilterLibrary:"Gordon"} => required Central, Gordon
+func mergeFilters(tokens []queryToken, filters []v4api.Filter) ([]queryToken, []v4api.Filter, []filterValue, error) {
+	remaining, required := liftFilterClauses(tokens)
+
+	// facets in first seen order, values deduped case-insensitively
+	facetIDs := make([]string, 0)
+	seenFacet := make(map[string]bool)
+	requiredVals := make(map[string][]string)
+	selectedVals := make(map[string][]string)
+	add := func(vals map[string][]string, fv filterValue) {
+		if seenFacet[fv.FacetID] == false {
+			seenFacet[fv.FacetID] = true
+			facetIDs = append(facetIDs, fv.FacetID)
+		}
+		if containsMatchText(vals[fv.FacetID], fv.Value) == false {
+			vals[fv.FacetID] = append(vals[fv.FacetID], fv.Value)
+		}
+	}
+	for _, fv := range required {
+		add(requiredVals, fv)
+	}
+	poolID := ""
+	for _, filter := range filters {
+		if poolID == "" {
+			poolID = filter.PoolID
+		}
+		for _, facet := range filter.Facets {
+			add(selectedVals, filterValue{FacetID: facet.FacetID, Value: facet.Value})
+		}
+	}
+
+	merged := v4api.Filter{PoolID: poolID}
+	restrictions := make([]filterValue, 0)
+	for _, facetID := range facetIDs {
+		reqVals := requiredVals[facetID]
+		values := selectedVals[facetID]
+		switch {
+		case len(reqVals) == 0:
+		case singleValuedFacets[facetID]:
+			if len(reqVals) > 1 {
+				return nil, nil, nil, fmt.Errorf("filter conflict: %s can not be both [%s] and [%s]", facetID, reqVals[0], reqVals[1])
+			}
+			if len(values) > 0 && containsMatchText(values, reqVals[0]) == false {
+				return nil, nil, nil, fmt.Errorf("filter conflict: %s [%s] in the query is not one of the selected values [%s]",
+					facetID, reqVals[0], strings.Join(values, ", "))
+			}
+			values = reqVals
+		case len(reqVals) == 1 && (len(values) == 0 || containsMatchText(values, reqVals[0])):
+			values = reqVals
+		default:
+			// a selection that includes a required value is implied by it
+			for _, v := range reqVals {
+				if containsMatchText(values, v) {
+					values = nil
+					break
+				}
+			}
+			for _, v := range reqVals {
+				restrictions = append(restrictions, filterValue{FacetID: facetID, Value: v})
+			}
+		}
+		for _, v := range values {
+			if containsFacetValue(merged, facetID, v) == false {
+				merged.Facets = append(merged.Facets, filterValue{FacetID: facetID, Value: v})
+			}
+		}
+	}
+	if len(merged.Facets) == 0 {
+		return remaining, make([]v4api.Filter, 0), restrictions, nil
+	}
+	return remaining, []v4api.Filter{merged}, restrictions, nil
+}
+
+// liftFilterClauses removes the filter: clauses that are ANDed with the rest of the query
+// at the top level and returns their facet values. Nothing is lifted from a query with a
+// top level OR, since the clauses would no longer apply to everything.
+func liftFilterClauses(tokens []queryToken) ([]queryToken, []filterValue) {
+	lifted := make([]filterValue, 0)
+	spans := make([][2]int, 0)
+	for i := 0; i < len(tokens); {
+		end := operandEnd(tokens, i)
+		if end-i == 1 && isOperator(tokens[i], "OR") {
+			return tokens, lifted
+		}
+		spans = append(spans, [2]int{i, end})
+		i = end
+	}
+	isAnd := func(s int) bool {
+		return s >= 0 && s < len(spans) && spans[s][1]-spans[s][0] == 1 && isOperator(tokens[spans[s][0]], "AND")
+	}
+
+	out := make([]queryToken, 0, len(tokens))
+	skipAnd := false
+	for s, span := range spans {
+		if skipAnd {
+			skipAnd = false
+			if isAnd(s) {
+				continue
+			}
+		}
+		fv, ok := filterClauseValue(tokens, span[0], span[1])
+		if ok && (s == 0 || isAnd(s-1)) && (s == len(spans)-1 || isAnd(s+1)) {
+			lifted = append(lifted, fv)
+			if len(out) > 0 {
+				// drop the AND joining it to the previous operand
+				out = out[:len(out)-1]
+			} else {
+				skipAnd = true
+			}
+			continue
+		}
+		out = append(out, tokens[span[0]:span[1]]...)
+	}
+	return out, lifted
+}
+
+// filterClauseValue returns the facet value of a filter: {FacetID:"value"} clause that
+// spans tokens[start:end]
+func filterClauseValue(tokens []queryToken, start int, end int) (filterValue, bool) {
+	if tokens[start].Type != tokenField || tokens[start].Value != "filter" {
+		return filterValue{}, false
+	}
+	content, next, ok := clauseContent(tokens, start)
+	if ok == false || next != end {
+		return filterValue{}, false
+	}
+	parts := strings.SplitN(content, ":", 2)
+	if len(parts) != 2 {
+		return filterValue{}, false
+	}
+	facetID := strings.TrimSpace(parts[0])
+	value := strings.Trim(strings.TrimSpace(parts[1]), `"`)
+	if facetID == "" || value == "" {
+		return filterValue{}, false
+	}
+	return filterValue{FacetID: facetID, Value: value}, true
+}
+
+// containsFacetValue returns true if the filter has the facet value, ignoring case
+func containsFacetValue(filter v4api.Filter, facetID string, value string) bool {
+	for _, facet := range filter.Facets {
+		if facet.FacetID == facetID && normalizeMatchText(facet.Value) == normalizeMatchText(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsMatchText returns true if the list has the value, ignoring case and punctuation
+func containsMatchText(list []string, value string) bool {
+	for _, v := range list {
+		if normalizeMatchText(v) == normalizeMatchText(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// mergedValues lists the merged filter values as FacetID=value
+func mergedValues(filters []v4api.Filter) []string {
+	out := make([]string, 0)
+	for _, filter := range filters {
+		for _, facet := range filter.Facets {
+			out = append(out, facet.FacetID+"="+facet.Value)
+		}
+	}
+	return out
+}
+
+// requiredValues lists the required filter values as FacetID=value
+func requiredValues(required []filterValue) []string {
+	out := make([]string, 0)
+	for _, fv := range required {
+		out = append(out, fv.FacetID+"="+fv.Value)
+	}
+	return out
+}
+
+func TestMergeFilters(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		filters  []v4api.Filter
+		wantQ    string
+		want     []string
+		required []string
+		conflict string
+	}{
+		// nothing to merge
+		{"no filters", "keyword: {cats}", nil, "keyword: {cats}", []string{}, []string{}, ""},
+		{"request only", "keyword: {cats}", testFilters(filterFormat, "Book", filterLibrary, "Central"),
+			"keyword: {cats}", []string{"FilterFormat=Book", "FilterLibrary=Central"}, []string{}, ""},
+		{"request duplicates collapse", "keyword: {cats}", testFilters(filterFormat, "Book", filterFormat, "BOOK", filterFormat, "DVD"),
+			"keyword: {cats}", []string{"FilterFormat=Book", "FilterFormat=DVD"}, []string{}, ""},
+		{"query only", `keyword: {cats} AND filter: {FilterFormat:"Book"}`, nil,
+			"keyword: {cats}", []string{"FilterFormat=Book"}, []string{}, ""},
+		{"query clause first", `filter: {FilterFormat:"Book"} AND keyword: {cats}`, nil,
+			"keyword: {cats}", []string{"FilterFormat=Book"}, []string{}, ""},
+
+		// single valued facets
+		{"format same value", `keyword: {cats} AND filter: {FilterFormat:"book"}`, testFilters(filterFormat, "Book"),
+			"keyword: {cats}", []string{"FilterFormat=book"}, []string{}, ""},
+		{"format narrows selection", `keyword: {cats} AND filter: {FilterFormat:"book"}`, testFilters(filterFormat, "Book", filterFormat, "DVD"),
+			"keyword: {cats}", []string{"FilterFormat=book"}, []string{}, ""},
+		{"format not selected", `keyword: {cats} AND filter: {FilterFormat:"Book"}`, testFilters(filterFormat, "DVD"),
+			"", nil, nil, "FilterFormat [Book] in the query is not one of the selected values [DVD]"},
+		{"format twice in query", `keyword: {cats} AND filter: {FilterFormat:"Book"} AND filter: {FilterFormat:"DVD"}`, nil,
+			"", nil, nil, "FilterFormat can not be both [Book] and [DVD]"},
+		{"format repeated in query", `keyword: {cats} AND filter: {FilterFormat:"Book"} AND filter: {FilterFormat:"BOOK"}`, nil,
+			"keyword: {cats}", []string{"FilterFormat=Book"}, []string{}, ""},
+		{"language not selected", `keyword: {cats} AND filter: {FilterLanguage:"Spanish"}`, testFilters(filterLanguage, "English"),
+			"", nil, nil, "FilterLanguage [Spanish] in the query is not one of the selected values [English]"},
+		{"language twice in query", `filter: {FilterLanguage:"Spanish"} AND filter: {FilterLanguage:"English"}`, nil,
+			"", nil, nil, "FilterLanguage can not be both [Spanish] and [English]"},
+
+		// multi valued facets are intersected, not ORed; a bib can have several values, so
+		// required values that are not a single selected value are each ANDed
+		{"library same value", `keyword: {cats} AND filter: {FilterLibrary:"Central"}`, testFilters(filterLibrary, "central"),
+			"keyword: {cats}", []string{"FilterLibrary=Central"}, []string{}, ""},
+		{"library narrows selection", `keyword: {cats} AND filter: {FilterLibrary:"Central"}`, testFilters(filterLibrary, "Northside", filterLibrary, "Central"),
+			"keyword: {cats}", []string{"FilterLibrary=Central"}, []string{}, ""},
+		{"library not selected", `keyword: {cats} AND filter: {FilterLibrary:"Central"}`, testFilters(filterLibrary, "Northside"),
+			"keyword: {cats}", []string{"FilterLibrary=Northside"}, []string{"FilterLibrary=Central"}, ""},
+		{"library twice in query", `keyword: {cats} AND filter: {FilterLibrary:"Central"} AND filter: {FilterLibrary:"Gordon"}`, nil,
+			"keyword: {cats}", []string{}, []string{"FilterLibrary=Central", "FilterLibrary=Gordon"}, ""},
+		{"library twice implies selection", `filter: {FilterLibrary:"Central"} AND filter: {FilterLibrary:"Gordon"} AND keyword: {cats}`,
+			testFilters(filterLibrary, "gordon", filterLibrary, "Northside", filterFormat, "Book"),
+			"keyword: {cats}", []string{"FilterFormat=Book"}, []string{"FilterLibrary=Central", "FilterLibrary=Gordon"}, ""},
+		{"library repeated in query", `keyword: {cats} AND filter: {FilterLibrary:"Central"} AND filter: {FilterLibrary:"CENTRAL"}`, nil,
+			"keyword: {cats}", []string{"FilterLibrary=Central"}, []string{}, ""},
+		{"availability not selected", `keyword: {cats} AND filter: {FilterAvailability:"online"}`, testFilters(filterAvailability, availOnShelf),
+			"keyword: {cats}", []string{"FilterAvailability=" + availOnShelf}, []string{"FilterAvailability=online"}, ""},
+
+		// other facets are untouched
+		{"different facets", `keyword: {cats} AND filter: {FilterFormat:"Book"}`, testFilters(filterLibrary, "Central", filterLibrary, "Northside"),
+			"keyword: {cats}", []string{"FilterFormat=Book", "FilterLibrary=Central", "FilterLibrary=Northside"}, []string{}, ""},
+
+		// clauses that do not apply to the whole query stay in it
+		{"top level OR", `keyword: {cats} OR filter: {FilterFormat:"Book"}`, testFilters(filterFormat, "DVD"),
+			`keyword: {cats} OR filter: {FilterFormat: "Book"}`, []string{"FilterFormat=DVD"}, []string{}, ""},
+		{"inside parens", `keyword: {cats} AND (title: {dogs} OR filter: {FilterFormat:"Book"})`, nil,
+			`keyword: {cats} AND (title: {dogs} OR filter: {FilterFormat: "Book"})`, []string{}, []string{}, ""},
+		{"negated", `keyword: {cats} NOT filter: {FilterFormat:"Book"}`, nil,
+			`keyword: {cats} NOT filter: {FilterFormat: "Book"}`, []string{}, []string{}, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens, merged, required, err := mergeFilters(tokenizeQuery(tc.query), tc.filters)
+			if tc.conflict != "" {
+				if err == nil || strings.Contains(err.Error(), tc.conflict) == false {
+					t.Fatalf("err = %v, want conflict %q", err, tc.conflict)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if got := formatQuery(tokens); got != tc.wantQ {
+				t.Errorf("query = %q, want %q", got, tc.wantQ)
+			}
+			if got := mergedValues(merged); reflect.DeepEqual(got, tc.want) == false {
+				t.Errorf("filters = %v, want %v", got, tc.want)
+			}
+			if got := requiredValues(required); reflect.DeepEqual(got, tc.required) == false {
+				t.Errorf("required = %v, want %v", got, tc.required)
+			}
+		})
+	}
+}
+
+func TestTranslateRequiredFilters(t *testing.T) {
+	branches := []BranchGeo{{Code: "c", Name: "Central Library"}, {Code: "ga", Name: "Gordon Avenue Library"}}
+	required := []filterValue{{FacetID: filterLibrary, Value: "Central Library"}, {FacetID: filterLibrary, Value: "Gordon Avenue Library"},
+		{FacetID: filterAvailability, Value: availOnline}, {FacetID: filterLibrary, Value: "Moon Branch"}}
+	got, warnings := translateRequiredFilters(required, branches)
+	if got != "(l:c*) AND (l:ga*)" || len(warnings) != 1 {
+		t.Errorf("translateRequiredFilters = %q %v, want (l:c*) AND (l:ga*) with 1 warning", got, warnings)
+	}
+}
+
+// two required branches are both applied to the Sierra search rather than rejected
+func TestRequiredBranchesAreANDed(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(0))
+	svc := newTestService(t, sierra)
+	svc.Locations.set(make(map[string]bool), []BranchGeo{{Code: "c", Name: "Central Library"}, {Code: "ga", Name: "Gordon Avenue Library"}})
+	router := newRouter(svc)
+	body := `{"query":"keyword: {cats} AND filter: {FilterLibrary:\"Central Library\"} AND filter: {FilterLibrary:\"Gordon Avenue Library\"}"}`
+	if rec := apiRequest(t, router, http.MethodPost, "/api/search", body); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	sent := searchParams(sierra, "bibs/search")
+	if len(sent) == 0 || strings.HasSuffix(sent[0].Get("text"), "AND (l:c*) AND (l:ga*)") == false {
+		t.Errorf("Sierra searches = %v, want both branches ANDed", sent)
+	}
+}
+
+// a bib must have every availability the query requires, on top of any selected
+func TestRequiredAvailability(t *testing.T) {
+	onShelf := testBib("1001", "Cats")
+	onShelf.Available = true
+	online := testBib("1002", "Cats")
+	online.VarFields = append(online.VarFields, marcField("856", "u", "https://jmrl.overdrive.com/media/1002"))
+	both := testBib("1003", "Cats")
+	both.Available = true
+	both.VarFields = append(both.VarFields, marcField("856", "u", "https://jmrl.overdrive.com/media/1003"))
+	neither := testBib("1004", "Cats")
+
+	af, _ := getAvailabilityFilter(testFilters(filterAvailability, availOnShelf))
+	if warnings := af.requireValues([]filterValue{{FacetID: filterAvailability, Value: "online"}, {FacetID: filterLibrary, Value: "Central"}}); len(warnings) != 0 {
+		t.Errorf("requireValues warnings = %v", warnings)
+	}
+	for _, tc := range []struct {
+		bib  JMRLBib
+		want bool
+	}{{onShelf, false}, {online, false}, {both, true}, {neither, false}} {
+		if got := af.matches(&tc.bib); got != tc.want {
+			t.Errorf("bib %s matches = %t, want %t", tc.bib.ID, got, tc.want)
+		}
+	}
+
+	required := availabilityFilter{}
+	required.requireValues([]filterValue{{FacetID: filterAvailability, Value: "online"}})
+	if required.active() == false || required.matches(&online) == false || required.matches(&onShelf) {
+		t.Errorf("required online alone: active %t, online %t, on shelf %t", required.active(), required.matches(&online), required.matches(&onShelf))
+	}
+}
+
+// a filter conflict is a 400 for searches and facets alike
+func TestFilterConflictIsBadRequest(t *testing.T) {
+	router := newRouter(newTestService(t, newFakeSierra(t)))
+	body := `{"query":"keyword: {cats} AND filter: {FilterFormat:\"Book\"}",` +
+		`"filters":[{"pool_id":"jmrl","facets":[{"facet_id":"FilterFormat","value":"DVD"}]}]}`
+	for _, target := range []string{"/api/search", "/api/search/facets"} {
+		rec := apiRequest(t, router, http.MethodPost, target, body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s status = %d, want 400: %s", target, rec.Code, rec.Body.String())
+			continue
+		}
+		if strings.Contains(rec.Body.String(), "filter conflict") == false {
+			t.Errorf("%s response does not name the conflict: %s", target, rec.Body.String())
+		}
+	}
+}
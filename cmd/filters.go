@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// sierraMaterialIndex is the Sierra text search index for the bib material type
+const sierraMaterialIndex = "m"
+
+// sierraLocationIndex is the Sierra text search index for bib locations
+const sierraLocationIndex = "l"
+
+// V4 facet IDs for the format and branch filters. Library and location are synonyms.
+const filterFormat = "FilterFormat"
+const filterLibrary = "FilterLibrary"
+const filterLocation = "FilterLocation"
+
+// formatMaterialTypes maps normalized V4 format values onto JMRL Sierra material type codes
+var formatMaterialTypes = map[string]string{
+	"book":            "a",
+	"books":           "a",
+	"ebook":           "z",
+	"e book":          "z",
+	"dvd":             "g",
+	"video":           "g",
+	"audiobook":       "i",
+	"audio book":      "i",
+	"sound recording": "i",
+	"journal":         "s",
+	"magazine":        "s",
+	"serial":          "s",
+}
+
+// filterIndexes maps supported V4 facet IDs onto the Sierra index and value codes used to restrict them
+var filterIndexes = map[string]struct {
+	index string
+	codes map[string]string
+}{
+	filterFormat: {index: sierraMaterialIndex, codes: formatMaterialTypes},
+}
+
+// filterValueCode returns the Sierra index and code for a V4 facet value. Branch values
+// are looked up by name or code in the configured branches and match every location code
+// of the branch. The error describes why the facet or value can't be applied.
+func filterValueCode(facetID string, value string, branches []BranchGeo) (string, string, error) {
+	if facetID == filterLibrary || facetID == filterLocation {
+		for _, b := range branches {
+			if normalizeMatchText(b.Name) == normalizeMatchText(value) || strings.EqualFold(b.Code, strings.TrimSpace(value)) {
+				return sierraLocationIndex, b.Code + "*", nil
+			}
+		}
+		return "", "", fmt.Errorf("%s value [%s] is not a known JMRL branch and was ignored", facetID, value)
+	}
+	if facetID == filterLanguage {
+		lang, ok := lookupLanguage(value)
+		if ok == false {
+			return "", "", fmt.Errorf("%s value [%s] is not a known language and was ignored", facetID, value)
+		}
+		return sierraLanguageIndex, lang.Code, nil
+	}
+	mapping, found := filterIndexes[facetID]
+	if found == false {
+		return "", "", fmt.Errorf("filter %s is not supported by JMRL and was ignored", facetID)
+	}
+	code, found := mapping.codes[normalizeMatchText(value)]
+	if found == false {
+		return "", "", fmt.Errorf("%s value [%s] is not supported by JMRL and was ignored", facetID, value)
+	}
+	return mapping.index, code, nil
+}
+
+// filterTerm returns the Sierra index of a V4 facet value and the restriction for it. The
+// unknown language matches what the language facet counts under it rather than g:und.
+// EX: FilterFormat Book => m, m:a
+func filterTerm(facetID string, value string, branches []BranchGeo) (string, string, error) {
+	index, code, err := filterValueCode(facetID, value, branches)
+	if err != nil {
+		return "", "", err
+	}
+	if index == sierraLanguageIndex && code == languageUnknown {
+		return index, unknownLanguageRestriction(), nil
+	}
+	return index, fmt.Sprintf("%s:%s", index, code), nil
+}
+
+// translateFilters converts the request filters into a Sierra restriction. Values within
+// a category are ORed and categories are ANDed. Facets or values that can't be mapped are
+// skipped and returned as warnings. An empty expression means no restriction. Categories
+// are keyed by Sierra index, so synonymous facets (library, location) are ORed together.
+// EX: FilterFormat Book, DVD => (m:a OR m:g)
+func translateFilters(filters []v4api.Filter, branches []BranchGeo) (string, []string) {
+	warnings := make([]string, 0)
+	categories := make([]string, 0)
+	terms := make(map[string][]string)
+	for _, filter := range filters {
+		for _, facet := range filter.Facets {
+			if facet.FacetID == filterAvailability {
+				// applied to the search results; see postFilteredSearch
+				continue
+			}
+			index, term, err := filterTerm(facet.FacetID, facet.Value, branches)
+			if err != nil {
+				warnings = append(warnings, err.Error())
+				continue
+			}
+			if _, found := terms[index]; found == false {
+				categories = append(categories, index)
+			}
+			if containsString(terms[index], term) == false {
+				terms[index] = append(terms[index], term)
+			}
+		}
+	}
+	clauses := make([]string, 0, len(categories))
+	for _, cat := range categories {
+		clauses = append(clauses, fmt.Sprintf("(%s)", strings.Join(terms[cat], " OR ")))
+	}
+	return strings.Join(clauses, " AND "), warnings
+}
+
+// translateRequiredFilters converts the filter values the query requires into Sierra
+// restrictions that are each ANDed, since a bib can match several values of a facet
+// (held at two branches). Availability is skipped; it is applied to the search results.
+// Values that can't be mapped are skipped and returned as warnings.
+// EX: FilterLibrary Central Library, Gordon Avenue Library => (l:c*) AND (l:ga*)
+func translateRequiredFilters(required []filterValue, branches []BranchGeo) (string, []string) {
+	warnings := make([]string, 0)
+	clauses := make([]string, 0, len(required))
+	for _, fv := range required {
+		if fv.FacetID == filterAvailability {
+			continue
+		}
+		_, term, err := filterTerm(fv.FacetID, fv.Value, branches)
+		if err != nil {
+			warnings = append(warnings, err.Error())
+			continue
+		}
+		clauses = append(clauses, fmt.Sprintf("(%s)", term))
+	}
+	return strings.Join(clauses, " AND "), warnings
+}
+
+// translateFilterClause converts the content of a V4 query filter clause into a Sierra
+// restriction. Branch filters need the configured branches and are only supported in the
+// request filters. EX: filter: {FilterFormat:"Book"} => (m:a)
+func translateFilterClause(content string) (string, error) {
+	parts := strings.SplitN(content, ":", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("filter [%s] is not supported", content)
+	}
+	_, term, err := filterTerm(strings.TrimSpace(parts[0]), strings.Trim(strings.TrimSpace(parts[1]), `"`), nil)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(%s)", term), nil
+}
+
+// containsString returns true if the list contains the value
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// branchFacet returns the library filter facet listing the configured branch names with
+// their counts, if known. Bucket values are names FilterLibrary accepts. Redacted branches
+// are never listed.
+func (svc *ServiceContext) branchFacet(filters []v4api.Filter, counts map[string]int, localizer *i18n.Localizer) v4api.Facet {
+	selected := make(map[string]bool)
+	for _, filter := range filters {
+		for _, facet := range filter.Facets {
+			if facet.FacetID == filterLibrary || facet.FacetID == filterLocation {
+				selected[normalizeMatchText(facet.Value)] = true
+			}
+		}
+	}
+	name := localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "FacetLibrary"})
+	out := v4api.Facet{ID: filterLibrary, Name: name, Type: "checkbox", Buckets: make([]v4api.FacetBucket, 0)}
+	_, branchGeo := svc.Locations.get()
+	for _, b := range branchGeo {
+		if svc.isRedactedLocation(b.Code) {
+			continue
+		}
+		name := b.Name
+		if name == "" {
+			name = b.Code
+		}
+		out.Buckets = append(out.Buckets, v4api.FacetBucket{Value: name, Count: counts[b.Code],
+			Selected: selected[normalizeMatchText(name)] || selected[normalizeMatchText(b.Code)]})
+	}
+	return out
+}
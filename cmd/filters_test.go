@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// testFilters returns a request filter list with the facet ID, value pairs
+func testFilters(pairs ...string) []v4api.Filter {
+	out := v4api.Filter{PoolID: "jmrl"}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		out.Facets = append(out.Facets, filterValue{FacetID: pairs[i], Value: pairs[i+1]})
+	}
+	return []v4api.Filter{out}
+}
+
+func TestTranslateFilters(t *testing.T) {
+	branches := []BranchGeo{{Code: "cn", Name: "Central Library"}, {Code: "nr", Name: "Northside Library"}}
+	tests := []struct {
+		name     string
+		filters  []v4api.Filter
+		want     string
+		warnings int
+	}{
+		{"none", nil, "", 0},
+		{"one format", testFilters(filterFormat, "Book"), "(m:a)", 0},
+		{"same category is ORed", testFilters(filterFormat, "Book", filterFormat, "DVD", filterFormat, "Audiobook"), "(m:a OR m:g OR m:i)", 0},
+		{"synonyms dedupe", testFilters(filterFormat, "Book", filterFormat, "books", filterFormat, "E-Book"), "(m:a OR m:z)", 0},
+		{"mixed categories are ANDed", testFilters(filterFormat, "Book", filterLibrary, "Central Library", filterFormat, "DVD"),
+			"(m:a OR m:g) AND (l:cn*)", 0},
+		{"library and location are one category", testFilters(filterLibrary, "central library", filterLocation, "NR"), "(l:cn* OR l:nr*)", 0},
+		{"unknown facet", testFilters("FilterColor", "Blue", filterFormat, "DVD"), "(m:g)", 1},
+		{"unknown value", testFilters(filterFormat, "Scroll", filterLibrary, "Moon Branch"), "", 2},
+		{"availability is post filtered", testFilters(filterAvailability, "Online", filterFormat, "DVD"), "(m:g)", 0},
+		{"language", testFilters(filterLanguage, "Spanish", filterLanguage, "fre"), "(g:spa OR g:fre)", 0},
+		{"unknown language", testFilters(filterLanguage, languageUnknown), "(" + unknownLanguageRestriction() + ")", 0},
+		{"unknown language ORed", testFilters(filterLanguage, "eng", filterLanguage, languageUnknown),
+			"(g:eng OR " + unknownLanguageRestriction() + ")", 0},
+	}
+	for _, tc := range tests {
+		got, warnings := translateFilters(tc.filters, branches)
+		if got != tc.want || len(warnings) != tc.warnings {
+			t.Errorf("%s: translateFilters = %q %v, want %q with %d warnings", tc.name, got, warnings, tc.want, tc.warnings)
+		}
+	}
+}
+
+func TestTranslateFilterClause(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+		fails   bool
+	}{
+		{`FilterFormat:"Book"`, "(m:a)", false},
+		{`FilterFormat: "Sound Recording"`, "(m:i)", false},
+		{`FilterFormat:"Scroll"`, "", true},
+		{`FilterLibrary:"Central Library"`, "", true},
+		{`FilterFormat`, "", true},
+		{`FilterLanguage:"und"`, "(" + unknownLanguageRestriction() + ")", false},
+	}
+	for _, tc := range tests {
+		got, err := translateFilterClause(tc.content)
+		if (err != nil) != tc.fails || got != tc.want {
+			t.Errorf("translateFilterClause(%q) = %q %v, want %q fails %t", tc.content, got, err, tc.want, tc.fails)
+		}
+	}
+}
+
+func TestSearchFilterWarnings(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "Cats")))
+	router := newRouter(newTestService(t, sierra))
+
+	body := `{"query":"keyword: {cats}","filters":[{"pool_id":"jmrl","facets":[` +
+		`{"facet_id":"FilterFormat","value":"Book"},{"facet_id":"FilterFormat","value":"DVD"},{"facet_id":"FilterColor","value":"Blue"}]}]}`
+	rec := apiRequest(t, router, http.MethodPost, "/api/search", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp v4api.PoolResult
+	decodeTestJSON(t, rec, &resp)
+	if len(resp.Groups) != 1 {
+		t.Errorf("%d groups, want 1", len(resp.Groups))
+	}
+	want := []string{"filter FilterColor is not supported by JMRL and was ignored"}
+	if reflect.DeepEqual(resp.Warnings, want) == false {
+		t.Errorf("warnings = %q, want %q", resp.Warnings, want)
+	}
+	urls := sierra.requestURLs()
+	sent, _ := url.Parse(urls[len(urls)-1])
+	if got := sent.Query().Get("text"); got != "((cats)) AND (m:a OR m:g)" {
+		t.Errorf("Sierra text = %s", got)
+	}
+}
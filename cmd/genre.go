@@ -0,0 +1,26 @@
+package main
+
+import (
+	"html"
+	"strings"
+)
+
+// sierraGenreIndex is the Sierra text search index of the genre/form headings (MARC 655)
+const sierraGenreIndex = "j"
+
+// getGenres returns the genre/form terms (655$a) of a bib, de-duplicated on the
+// normalized term in first-seen order
+func getGenres(varFields *[]JMRLVarFields) []string {
+	out := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, val := range getVarField(varFields, "655", "a") {
+		val = strings.TrimSpace(stripTrailingData(html.UnescapeString(val)))
+		key := normalizeMatchText(val)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, val)
+	}
+	return out
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+func TestGetGenres(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []JMRLVarFields
+		want   []string
+	}{
+		{"each heading", []JMRLVarFields{marcField("655", "a", "Cozy mysteries."), marcField("655", "a", "Detective and mystery fiction.")},
+			[]string{"Cozy mysteries", "Detective and mystery fiction"}},
+		{"duplicates", []JMRLVarFields{marcField("655", "a", "Graphic novels."), marcField("655", "a", "graphic novels")},
+			[]string{"Graphic novels"}},
+		{"entities", []JMRLVarFields{marcField("655", "a", "Romance &amp; love stories.")}, []string{"Romance & love stories"}},
+		{"blank", []JMRLVarFields{marcField("655", "a", " ")}, []string{}},
+		{"none", []JMRLVarFields{marcField("650", "a", "Cats.")}, []string{}},
+	}
+	for _, tc := range tests {
+		fields := tc.fields
+		if got := getGenres(&fields); reflect.DeepEqual(got, tc.want) == false {
+			t.Errorf("%s: getGenres = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestGrammarQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"genre: {cozy mysteries}", "keyword: {cozy mysteries}"},
+		{"genre: {cozy} AND author: {smith}", "keyword: {cozy} AND author: {smith}"},
+		{"series: {warriors} OR title: {cats}", "keyword: {warriors} OR title: {cats}"},
+	}
+	for _, tc := range tests {
+		if got := grammarQuery(tokenizeQuery(tc.query)); got != tc.want {
+			t.Errorf("grammarQuery(%q) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+// a genre clause with an author clause searches the genre index and the results list
+// every genre heading of the record
+func TestGenreSearch(t *testing.T) {
+	bib := testBib("1001", "Murder at the bakery")
+	bib.VarFields = append(bib.VarFields, marcField("655", "a", "Cozy mysteries."),
+		marcField("655", "a", "Detective and mystery fiction."))
+	sierra := newFakeSierra(t)
+	var text string
+	sierra.handle("bibs/search", func(w http.ResponseWriter, r *http.Request) {
+		text = r.URL.Query().Get("text")
+		writeTestJSON(w, http.StatusOK, searchResult(1, bib))
+	})
+	router := newRouter(newTestService(t, sierra))
+	rec := apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"genre: {cozy mysteries} AND author: {smith}"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if want := "j:(cozy mysteries) AND a:(smith)"; text != want {
+		t.Errorf("Sierra text = %q, want %q", text, want)
+	}
+	var resp v4api.PoolResult
+	decodeTestJSON(t, rec, &resp)
+	if len(resp.Groups) != 1 {
+		t.Fatalf("%d groups, want 1", len(resp.Groups))
+	}
+	got := fieldValues(resp.Groups[0].Records[0].Fields, "genre")
+	if want := []string{"Cozy mysteries", "Detective and mystery fiction"}; reflect.DeepEqual(got, want) == false {
+		t.Errorf("genre fields = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// sortNearest is the sort ID used to request a page-local distance sort
+const sortNearest = "SortNearest"
+
+// BranchGeo is the location of a single JMRL branch
+type BranchGeo struct {
+	Code      string  `toml:"code"`
+	Name      string  `toml:"name"`
+	Latitude  float64 `toml:"latitude"`
+	Longitude float64 `toml:"longitude"`
+}
+
+// GeoPoint is a latitude / longitude pair
+type GeoPoint struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// loadBranchGeo reads the branch code to lat/long mapping file. The file contains
+// a list of [[branch]] tables with code, name, latitude and longitude
+func loadBranchGeo(filename string) ([]BranchGeo, error) {
+	var geoCfg struct {
+		Branches []BranchGeo `toml:"branch"`
+	}
+	if _, err := toml.DecodeFile(filename, &geoCfg); err != nil {
+		return nil, err
+	}
+	for _, b := range geoCfg.Branches {
+		if b.Code == "" {
+			return nil, fmt.Errorf("branch %s in %s is missing a code", b.Name, filename)
+		}
+		if b.Latitude < -90 || b.Latitude > 90 || b.Longitude < -180 || b.Longitude > 180 {
+			return nil, fmt.Errorf("branch %s in %s has invalid coordinates", b.Code, filename)
+		}
+	}
+	return geoCfg.Branches, nil
+}
+
+// branchForLocation finds the branch for a Sierra location code. Location codes
+// are branch prefixed, so the longest matching branch code wins
+func (svc *ServiceContext) branchForLocation(locCode string) *BranchGeo {
+	_, branchGeo := svc.Locations.get()
+	var best *BranchGeo
+	for idx := range branchGeo {
+		b := &branchGeo[idx]
+		if strings.HasPrefix(locCode, b.Code) {
+			if best == nil || len(b.Code) > len(best.Code) {
+				best = b
+			}
+		}
+	}
+	return best
+}
+
+// nearestBranchDistance returns the distance in miles from the point to the closest
+// branch holding the bib. False is returned if no holding branch has a known location.
+func (svc *ServiceContext) nearestBranchDistance(bib *JMRLBib, from *GeoPoint) (float64, bool) {
+	found := false
+	nearest := 0.0
+	for _, loc := range svc.normalizeLocations(bib.Locations) {
+		branch := svc.branchForLocation(loc.Code)
+		if branch == nil {
+			continue
+		}
+		dist := haversineMiles(from, &GeoPoint{Latitude: branch.Latitude, Longitude: branch.Longitude})
+		if found == false || dist < nearest {
+			nearest = dist
+			found = true
+		}
+	}
+	return nearest, found
+}
+
+// haversineMiles computes the great circle distance between two points in miles
+func haversineMiles(a *GeoPoint, b *GeoPoint) float64 {
+	const earthRadiusMiles = 3958.8
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180.0 }
+	dLat := toRad(b.Latitude - a.Latitude)
+	dLon := toRad(b.Longitude - a.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(a.Latitude))*math.Cos(toRad(b.Latitude))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMiles * math.Asin(math.Sqrt(h))
+}
+
+// getRequestLocation returns the user location from the search request, falling back to
+// the X-Latitude / X-Longitude headers. Nil is returned if no valid location is present.
+func getRequestLocation(header http.Header, lat *float64, lon *float64) *GeoPoint {
+	if lat == nil || lon == nil {
+		latStr := header.Get("X-Latitude")
+		lonStr := header.Get("X-Longitude")
+		if latStr == "" || lonStr == "" {
+			return nil
+		}
+		hLat, latErr := strconv.ParseFloat(latStr, 64)
+		hLon, lonErr := strconv.ParseFloat(lonStr, 64)
+		if latErr != nil || lonErr != nil {
+			log.Printf("WARNING: invalid location headers [%s, %s]", latStr, lonStr)
+			return nil
+		}
+		lat = &hLat
+		lon = &hLon
+	}
+	if *lat < -90 || *lat > 90 || *lon < -180 || *lon > 180 {
+		log.Printf("WARNING: location [%f, %f] is out of range", *lat, *lon)
+		return nil
+	}
+	return &GeoPoint{Latitude: *lat, Longitude: *lon}
+}
+
+// sortGroupsByDistance orders the groups on the current page by the distance values
+// supplied. Groups with no distance (no physical locations) sort last and ties are
+// broken by group value.
+func sortGroupsByDistance(groups []v4api.Group, distances map[string]float64) {
+	sort.SliceStable(groups, func(i, j int) bool {
+		di, iOK := distances[groups[i].Value]
+		dj, jOK := distances[groups[j].Value]
+		if iOK && jOK && di != dj {
+			return di < dj
+		}
+		if iOK != jOK {
+			return iOK
+		}
+		return compareBibIDs(groups[i].Value, groups[j].Value) < 0
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// testBranchGeo are branches about 1 and 5 miles north of testOrigin
+var testBranchGeo = []BranchGeo{
+	{Code: "cn", Name: "Central Library", Latitude: 38.0145, Longitude: -78.48},
+	{Code: "nr", Name: "Northside Library", Latitude: 38.0724, Longitude: -78.48},
+}
+
+var testOrigin = GeoPoint{Latitude: 38.0, Longitude: -78.48}
+
+func TestHaversineMiles(t *testing.T) {
+	tests := []struct {
+		a, b GeoPoint
+		want float64
+	}{
+		{GeoPoint{0, 0}, GeoPoint{0, 0}, 0},
+		{GeoPoint{0, 0}, GeoPoint{1, 0}, 69.09},
+		{GeoPoint{0, 0}, GeoPoint{0, 1}, 69.09},
+		{testOrigin, GeoPoint{38.0724, -78.48}, 5.0},
+	}
+	for _, tc := range tests {
+		if got := haversineMiles(&tc.a, &tc.b); math.Abs(got-tc.want) > 0.05 {
+			t.Errorf("haversineMiles(%v, %v) = %.2f, want %.2f", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestGetRequestLocation(t *testing.T) {
+	lat, lon, far := 38.03, -78.48, 91.0
+	tests := []struct {
+		name    string
+		lat     *float64
+		lon     *float64
+		headers map[string]string
+		want    *GeoPoint
+	}{
+		{"none", nil, nil, nil, nil},
+		{"request", &lat, &lon, nil, &GeoPoint{38.03, -78.48}},
+		{"request wins over headers", &lat, &lon, map[string]string{"X-Latitude": "1", "X-Longitude": "2"}, &GeoPoint{38.03, -78.48}},
+		{"headers", nil, nil, map[string]string{"X-Latitude": "38.03", "X-Longitude": "-78.48"}, &GeoPoint{38.03, -78.48}},
+		{"only latitude", &lat, nil, map[string]string{"X-Latitude": "38.03"}, nil},
+		{"invalid header", nil, nil, map[string]string{"X-Latitude": "north", "X-Longitude": "-78.48"}, nil},
+		{"out of range", &far, &lon, nil, nil},
+	}
+	for _, tc := range tests {
+		header := http.Header{}
+		for k, v := range tc.headers {
+			header.Set(k, v)
+		}
+		if got := getRequestLocation(header, tc.lat, tc.lon); reflect.DeepEqual(got, tc.want) == false {
+			t.Errorf("%s: getRequestLocation = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLoadBranchGeo(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    int
+		fails   bool
+		err     string
+	}{
+		{"branches", "[[branch]]\ncode = \"cn\"\nname = \"Central\"\nlatitude = 38.03\nlongitude = -78.48\n\n" +
+			"[[branch]]\ncode = \"nr\"\nname = \"Northside\"\nlatitude = 38.07\nlongitude = -78.47\n", 2, false, ""},
+		{"empty", "", 0, false, ""},
+		{"missing code", "[[branch]]\nname = \"Central\"\nlatitude = 38.03\nlongitude = -78.48\n", 0, true, "missing a code"},
+		{"invalid coordinates", "[[branch]]\ncode = \"cn\"\nlatitude = 138.03\nlongitude = -78.48\n", 0, true, "invalid coordinates"},
+		{"malformed", "[[branch]\ncode = \"cn\"\n", 0, true, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "branches.toml")
+			writeOverrides(t, filename, tc.content)
+			got, err := loadBranchGeo(filename)
+			if tc.fails {
+				if err == nil || strings.Contains(err.Error(), tc.err) == false {
+					t.Fatalf("err = %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil || len(got) != tc.want {
+				t.Errorf("loadBranchGeo = %d branches %v, want %d", len(got), err, tc.want)
+			}
+		})
+	}
+}
+
+func TestNearestBranchDistance(t *testing.T) {
+	svc := newTestService(t, nil)
+	svc.Locations.set(make(map[string]bool), testBranchGeo)
+	tests := []struct {
+		name  string
+		codes []string
+		want  float64
+		found bool
+	}{
+		{"nearest of two", []string{"nrf", "cnf"}, 1.0, true},
+		{"one branch", []string{"nrf"}, 5.0, true},
+		{"placeholder ignored", []string{"none", "nrf"}, 5.0, true},
+		{"only placeholders", []string{"none", "multi"}, 0, false},
+		{"unknown branch", []string{"xyz"}, 0, false},
+		{"no locations", nil, 0, false},
+	}
+	for _, tc := range tests {
+		bib := testBib("1001", "Cats")
+		for _, code := range tc.codes {
+			bib.Locations = append(bib.Locations, JMRLCodeValue{Code: code})
+		}
+		got, found := svc.nearestBranchDistance(&bib, &testOrigin)
+		if found != tc.found || math.Abs(got-tc.want) > 0.05 {
+			t.Errorf("%s: nearestBranchDistance = %.2f %t, want %.2f %t", tc.name, got, found, tc.want, tc.found)
+		}
+	}
+}
+
+func TestSortGroupsByDistance(t *testing.T) {
+	groups := []v4api.Group{{Value: "1004"}, {Value: "1003"}, {Value: "1002"}, {Value: "1001"}, {Value: "999"}}
+	distances := map[string]float64{"1001": 5, "1002": 1, "1003": 5}
+	sortGroupsByDistance(groups, distances)
+	got := make([]string, 0)
+	for _, g := range groups {
+		got = append(got, g.Value)
+	}
+	// groups without a distance sort last, ties are in ID order
+	if want := []string{"1002", "1001", "1003", "999", "1004"}; reflect.DeepEqual(got, want) == false {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+// distance is only reported and sorted on when the request has a location
+func TestSearchNearestSort(t *testing.T) {
+	located := func(id string, title string, codes ...string) JMRLBib {
+		bib := testBib(id, title)
+		for _, code := range codes {
+			bib.Locations = append(bib.Locations, JMRLCodeValue{Code: code})
+		}
+		return bib
+	}
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(3,
+		located("1001", "Online cats"), located("1002", "Northside cats", "nrf"), located("1003", "Central cats", "cnf", "nrf")))
+	svc := newTestService(t, sierra)
+	svc.Locations.set(make(map[string]bool), testBranchGeo)
+	router := newRouter(svc)
+
+	tests := []struct {
+		name     string
+		location string
+		order    []string
+		sort     string
+		warned   bool
+	}{
+		{"with location", `"latitude":38.0,"longitude":-78.48,`, []string{"1003", "1002", "1001"}, sortNearest, true},
+		{"without location", ``, []string{"1001", "1002", "1003"}, v4api.SortRelevance.String(), false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			body := `{"query":"keyword: {cats}",` + tc.location + `"grouped":false,"sort":{"sort_id":"SortNearest","order":"asc"}}`
+			rec := apiRequest(t, router, http.MethodPost, "/api/search", body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var resp v4api.PoolResult
+			decodeTestJSON(t, rec, &resp)
+			got := make([]string, 0)
+			distances := 0
+			for _, g := range resp.Groups {
+				got = append(got, g.Value)
+				distances += len(fieldValues(g.Records[0].Fields, "nearest_branch_distance"))
+			}
+			if reflect.DeepEqual(got, tc.order) == false {
+				t.Errorf("order = %v, want %v", got, tc.order)
+			}
+			if wantDistances := map[bool]int{true: 2, false: 0}[tc.warned]; distances != wantDistances {
+				t.Errorf("%d records with a distance, want %d", distances, wantDistances)
+			}
+			if resp.Sort.SortID != tc.sort {
+				t.Errorf("sort = %s, want %s", resp.Sort.SortID, tc.sort)
+			}
+			warned := false
+			for _, warn := range resp.Warnings {
+				warned = warned || strings.Contains(warn, "this page")
+			}
+			if warned != tc.warned {
+				t.Errorf("warnings = %q, want page-local warning %t", resp.Warnings, tc.warned)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// workKey returns the key that identifies the work of a bib across editions: the
+// normalized 245$a title and 100$a author. Empty is returned for bibs without a title.
+func workKey(bib *JMRLBib) string {
+	title := normalizeTitle(bibTitle(bib))
+	if title == "" {
+		return ""
+	}
+	author := ""
+	if vals := getVarField(&bib.VarFields, "100", "a"); len(vals) > 0 {
+		author = normalizeAuthorName(vals[0], true)
+	}
+	return title + "|" + author
+}
+
+// groupWorks clusters the entries of a result page that are editions of the same work:
+// those with the same work key or sharing an ISBN. Clusters are returned in the order
+// of their first entry, each holding entry indexes in page order.
+func groupWorks(entries []JMRLEntry) [][]int {
+	parent := make([]int, len(entries))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(i int, j int) {
+		ri, rj := find(i), find(j)
+		if ri < rj {
+			parent[rj] = ri
+		} else if rj < ri {
+			parent[ri] = rj
+		}
+	}
+
+	owners := make(map[string]int)
+	for i := range entries {
+		keys := make([]string, 0)
+		if key := workKey(&entries[i].Bib); key != "" {
+			keys = append(keys, "work:"+key)
+		}
+		for _, isbn := range getISBNs(&entries[i].Bib) {
+			keys = append(keys, "isbn:"+isbn)
+		}
+		for _, key := range keys {
+			if owner, found := owners[key]; found {
+				union(owner, i)
+			} else {
+				owners[key] = i
+			}
+		}
+	}
+
+	clusters := make([][]int, 0)
+	clusterOf := make(map[int]int)
+	for i := range entries {
+		root := find(i)
+		idx, found := clusterOf[root]
+		if found == false {
+			idx = len(clusters)
+			clusterOf[root] = idx
+			clusters = append(clusters, make([]int, 0, 1))
+		}
+		clusters[idx] = append(clusters[idx], i)
+	}
+	return clusters
+}
+
+// workGroupValue returns the group value of a cluster of editions. It is derived from
+// the smallest work key in the cluster so the same work has the same value on every
+// page. Clusters with no work key (joined only by ISBN) use their smallest ISBN, and
+// otherwise the smallest bib ID, so the value never depends on page order.
+func workGroupValue(entries []JMRLEntry, cluster []int) string {
+	minKey, minISBN, minID := "", "", ""
+	for _, i := range cluster {
+		bib := &entries[i].Bib
+		if key := workKey(bib); key != "" && (minKey == "" || key < minKey) {
+			minKey = key
+		}
+		for _, isbn := range getISBNs(bib) {
+			if minISBN == "" || isbn < minISBN {
+				minISBN = isbn
+			}
+		}
+		if minID == "" || compareBibIDs(bib.ID, minID) < 0 {
+			minID = bib.ID
+		}
+	}
+	switch {
+	case minKey != "":
+		return fmt.Sprintf("work-%x", sha256.Sum256([]byte(minKey)))[:21]
+	case minISBN != "":
+		return fmt.Sprintf("work-%x", sha256.Sum256([]byte("isbn:"+minISBN)))[:21]
+	}
+	return minID
+}
+
+// orderEditions orders the editions of a cluster with available copies first, otherwise
+// keeping page order
+func orderEditions(entries []JMRLEntry, cluster []int) {
+	sort.SliceStable(cluster, func(a, b int) bool {
+		return entries[cluster[a]].Bib.Available && entries[cluster[b]].Bib.Available == false
+	})
+}
+
+// isLargePrint returns true if the material type or 250 edition statement of the bib
+// says it is large print
+func isLargePrint(bib *JMRLBib) bool {
+	values := append([]string{bib.Type.Value}, getVarField(&bib.VarFields, "250", "a")...)
+	for _, val := range values {
+		val = strings.ToLower(val)
+		if strings.Contains(val, "large print") || strings.Contains(val, "large type") {
+			return true
+		}
+	}
+	return false
+}
+
+// pairLargePrint clusters the entries of a result page so that each large print bib
+// joins the cluster of a regular print book with exactly the same work key. Every other
+// entry is its own cluster. Clusters are in the page order of their first entry, with
+// the regular print bib first. The number of pairs made is also returned.
+func pairLargePrint(entries []JMRLEntry) ([][]int, int) {
+	unpaired := make(map[string][]int)
+	for i := range entries {
+		if key := workKey(&entries[i].Bib); key != "" && isLargePrint(&entries[i].Bib) {
+			unpaired[key] = append(unpaired[key], i)
+		}
+	}
+	partner := make(map[int]int)
+	paired := make(map[int]bool)
+	for i := range entries {
+		bib := &entries[i].Bib
+		if bib.Type.Code != formatMaterialTypes["book"] || isLargePrint(bib) {
+			continue
+		}
+		key := workKey(bib)
+		if candidates := unpaired[key]; key != "" && len(candidates) > 0 {
+			partner[i] = candidates[0]
+			paired[candidates[0]] = true
+			unpaired[key] = candidates[1:]
+		}
+	}
+
+	clusters := make([][]int, 0, len(entries))
+	for i := range entries {
+		if paired[i] {
+			continue
+		}
+		cluster := []int{i}
+		if lp, found := partner[i]; found {
+			cluster = append(cluster, lp)
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters, len(partner)
+}
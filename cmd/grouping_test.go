@@ -0,0 +1,317 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// edition returns a bib with a 245$a title, 100$a author and 020$a ISBNs. An empty
+// title leaves the bib without a work key.
+func edition(id string, title string, author string, isbns ...string) JMRLBib {
+	bib := JMRLBib{ID: id, Type: JMRLCodeValue{Code: "a", Value: "Book"}}
+	if title != "" {
+		bib.VarFields = append(bib.VarFields, marcField("245", "a", title))
+	}
+	if author != "" {
+		bib.VarFields = append(bib.VarFields, marcField("100", "a", author))
+	}
+	for _, isbn := range isbns {
+		bib.VarFields = append(bib.VarFields, marcField("020", "a", isbn))
+	}
+	return bib
+}
+
+// editionEntries returns search entries for the bibs, in order
+func editionEntries(bibs ...JMRLBib) []JMRLEntry {
+	out := make([]JMRLEntry, 0, len(bibs))
+	for _, bib := range bibs {
+		out = append(out, JMRLEntry{Bib: bib})
+	}
+	return out
+}
+
+// clusterIDs returns the bib IDs of each cluster
+func clusterIDs(entries []JMRLEntry, clusters [][]int) [][]string {
+	out := make([][]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		ids := make([]string, 0, len(cluster))
+		for _, idx := range cluster {
+			ids = append(ids, entries[idx].Bib.ID)
+		}
+		out = append(out, ids)
+	}
+	return out
+}
+
+func TestWorkKey(t *testing.T) {
+	tests := []struct {
+		name string
+		bib  JMRLBib
+		want string
+	}{
+		{"title and author", edition("1", "The secret garden /", "Burnett, Frances Hodgson,"), "secret garden|burnett frances hodgson"},
+		{"no author", edition("1", "The secret garden.", ""), "secret garden|"},
+		{"no title", edition("1", "", "Burnett, Frances Hodgson"), ""},
+	}
+	for _, tc := range tests {
+		if got := workKey(&tc.bib); got != tc.want {
+			t.Errorf("%s: workKey = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestGroupWorks(t *testing.T) {
+	tests := []struct {
+		name string
+		bibs []JMRLBib
+		want [][]string
+	}{
+		{"same work", []JMRLBib{
+			edition("1", "The secret garden /", "Burnett, Frances Hodgson"),
+			edition("2", "Gardening", "Smith, Ann"),
+			edition("3", "The Secret Garden.", "Burnett, Frances Hodgson,"),
+		}, [][]string{{"1", "3"}, {"2"}}},
+		{"isbn 10 and 13", []JMRLBib{
+			edition("1", "", "", "0306406152"),
+			edition("2", "", "", "9780306406157 (pbk.)"),
+		}, [][]string{{"1", "2"}}},
+		{"joined through isbn", []JMRLBib{
+			edition("1", "Cats", "Able, A", "0306406152"),
+			edition("2", "Cats : the sequel", "Able, A", "9780306406157"),
+			edition("3", "Cats : the sequel", "Able, A"),
+		}, [][]string{{"1", "2", "3"}}},
+		{"different authors", []JMRLBib{
+			edition("1", "Poems", "Able, A"),
+			edition("2", "Poems", "Baker, B"),
+		}, [][]string{{"1"}, {"2"}}},
+		{"empty", nil, [][]string{}},
+	}
+	for _, tc := range tests {
+		entries := editionEntries(tc.bibs...)
+		if got := clusterIDs(entries, groupWorks(entries)); reflect.DeepEqual(got, tc.want) == false {
+			t.Errorf("%s: groupWorks = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// the group value of a work must not depend on the order of its editions on the page
+func TestWorkGroupValueIsStable(t *testing.T) {
+	tests := []struct {
+		name   string
+		bibs   []JMRLBib
+		isWork bool
+	}{
+		{"work key", []JMRLBib{
+			edition("1001", "The secret garden", "Burnett, Frances Hodgson", "0306406152"),
+			edition("1002", "The secret garden", "Burnett, Frances Hodgson"),
+			edition("1003", "Secret garden : illustrated", "Burnett, Frances Hodgson", "9780306406157"),
+		}, true},
+		{"isbn only", []JMRLBib{
+			edition("1001", "", "", "0306406152"),
+			edition("1002", "", "", "9780306406157"),
+			edition("1003", "", "", "9780306406157", "0140449264"),
+		}, true},
+		{"no keys", []JMRLBib{edition("1002", "", ""), edition("999", "", ""), edition("1001", "", "")}, false},
+	}
+	rng := rand.New(rand.NewSource(775))
+	for _, tc := range tests {
+		entries := editionEntries(tc.bibs...)
+		if clusters := groupWorks(entries); tc.isWork && len(clusters) != 1 {
+			t.Fatalf("%s: %d clusters, want 1", tc.name, len(clusters))
+		}
+		want := workGroupValue(entries, []int{0, 1, 2})
+		if strings.HasPrefix(want, "work-") != tc.isWork {
+			t.Errorf("%s: group value %q, want a work value %t", tc.name, want, tc.isWork)
+		}
+		if tc.isWork == false && want != "999" {
+			t.Errorf("%s: group value %q, want the smallest bib ID 999", tc.name, want)
+		}
+		for i := 0; i < 10; i++ {
+			shuffled := append([]JMRLEntry{}, entries...)
+			rng.Shuffle(len(shuffled), func(a, b int) { shuffled[a], shuffled[b] = shuffled[b], shuffled[a] })
+			if got := workGroupValue(shuffled, []int{0, 1, 2}); got != want {
+				t.Errorf("%s: shuffled group value %q, want %q", tc.name, got, want)
+			}
+		}
+	}
+}
+
+func TestOrderEditions(t *testing.T) {
+	entries := editionEntries(edition("1", "Cats", ""), edition("2", "Cats", ""), edition("3", "Cats", ""), edition("4", "Cats", ""))
+	entries[1].Bib.Available = true
+	entries[3].Bib.Available = true
+	cluster := []int{0, 1, 2, 3}
+	orderEditions(entries, cluster)
+	if want := []int{1, 3, 0, 2}; reflect.DeepEqual(cluster, want) == false {
+		t.Errorf("orderEditions = %v, want %v", cluster, want)
+	}
+}
+
+func TestSearchGroupsEditions(t *testing.T) {
+	hardcover := edition("1001", "The secret garden", "Burnett, Frances Hodgson", "0306406152")
+	paperback := edition("1003", "The secret garden", "Burnett, Frances Hodgson")
+	paperback.Available = true
+	other := edition("1002", "Gardening", "Smith, Ann")
+	sierra := newFakeSierra(t)
+	pages := map[string]JMRLResult{"0": searchResult(40, hardcover, other, paperback), "3": searchResult(40, paperback)}
+	sierra.handle("bibs/search", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, pages[r.URL.Query().Get("offset")])
+	})
+	router := newRouter(newTestService(t, sierra))
+
+	search := func(body string) v4api.PoolResult {
+		t.Helper()
+		var resp v4api.PoolResult
+		decodeTestJSON(t, apiRequest(t, router, http.MethodPost, "/api/search", body), &resp)
+		return resp
+	}
+	first := search(`{"query":"keyword: {garden}","pagination":{"start":0,"rows":3}}`)
+	if len(first.Groups) != 2 || first.Groups[0].Count != 2 || len(first.Groups[0].Records) != 2 {
+		t.Fatalf("groups = %+v, want the two editions grouped", first.Groups)
+	}
+	if ids := fieldValues(first.Groups[0].Records[0].Fields, "id"); len(ids) != 1 || ids[0] != "1003" {
+		t.Errorf("first edition = %v, want the available 1003", ids)
+	}
+	later := search(`{"query":"keyword: {garden}","pagination":{"start":3,"rows":1}}`)
+	if len(later.Groups) != 1 || later.Groups[0].Value != first.Groups[0].Value {
+		t.Errorf("group value on a later page = %+v, want %q", later.Groups, first.Groups[0].Value)
+	}
+
+	ungrouped := search(`{"query":"keyword: {garden}","pagination":{"start":0,"rows":3},"grouped":false}`)
+	if len(ungrouped.Groups) != 3 {
+		t.Fatalf("%d ungrouped groups, want 3", len(ungrouped.Groups))
+	}
+	for i, want := range []string{"1001", "1002", "1003"} {
+		if group := ungrouped.Groups[i]; group.Value != want || group.Count != 1 {
+			t.Errorf("ungrouped group %d = %q count %d, want %q count 1", i, group.Value, group.Count, want)
+		}
+	}
+}
+
+// largePrint returns a large print edition, marked by its material type or 250 edition
+func largePrint(id string, title string, author string, byEdition bool) JMRLBib {
+	bib := edition(id, title, author)
+	if byEdition {
+		bib.VarFields = append(bib.VarFields, marcField("250", "a", "Large print edition."))
+	} else {
+		bib.Type = JMRLCodeValue{Code: "l", Value: "Large Print"}
+	}
+	return bib
+}
+
+func TestIsLargePrint(t *testing.T) {
+	tests := []struct {
+		name string
+		bib  JMRLBib
+		want bool
+	}{
+		{"material type", largePrint("1", "Cats", "", false), true},
+		{"edition", largePrint("1", "Cats", "", true), true},
+		{"large type edition", func() JMRLBib {
+			bib := edition("1", "Cats", "")
+			bib.VarFields = append(bib.VarFields, marcField("250", "a", "Large type ed."))
+			return bib
+		}(), true},
+		{"regular print", edition("1", "Cats", ""), false},
+	}
+	for _, tc := range tests {
+		if got := isLargePrint(&tc.bib); got != tc.want {
+			t.Errorf("%s: isLargePrint = %t, want %t", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPairLargePrint(t *testing.T) {
+	tests := []struct {
+		name  string
+		bibs  []JMRLBib
+		want  [][]string
+		pairs int
+	}{
+		{"pair", []JMRLBib{
+			largePrint("1", "The secret garden", "Burnett, Frances Hodgson", true),
+			edition("2", "Gardening", "Smith, Ann"),
+			edition("3", "The secret garden", "Burnett, Frances Hodgson"),
+		}, [][]string{{"2"}, {"3", "1"}}, 1},
+		{"different author", []JMRLBib{
+			edition("1", "Cats", "Able, A"),
+			largePrint("2", "Cats", "Baker, B", false),
+		}, [][]string{{"1"}, {"2"}}, 0},
+		{"one sibling each", []JMRLBib{
+			edition("1", "Cats", "Able, A"),
+			largePrint("2", "Cats", "Able, A", false),
+			largePrint("3", "Cats", "Able, A", true),
+			edition("4", "Cats", "Able, A"),
+		}, [][]string{{"1", "2"}, {"4", "3"}}, 2},
+		{"only large print", []JMRLBib{
+			largePrint("1", "Cats", "Able, A", false),
+			largePrint("2", "Cats", "Able, A", true),
+		}, [][]string{{"1"}, {"2"}}, 0},
+		{"not a book", []JMRLBib{
+			func() JMRLBib {
+				bib := edition("1", "Cats", "Able, A")
+				bib.Type = JMRLCodeValue{Code: "g", Value: "DVD"}
+				return bib
+			}(),
+			largePrint("2", "Cats", "Able, A", true),
+		}, [][]string{{"1"}, {"2"}}, 0},
+	}
+	for _, tc := range tests {
+		entries := editionEntries(tc.bibs...)
+		clusters, pairs := pairLargePrint(entries)
+		if got := clusterIDs(entries, clusters); reflect.DeepEqual(got, tc.want) == false || pairs != tc.pairs {
+			t.Errorf("%s: pairLargePrint = %v %d pairs, want %v %d pairs", tc.name, got, pairs, tc.want, tc.pairs)
+		}
+	}
+}
+
+// large print pairing needs both the service flag and the client preference, and never
+// changes a grouped search
+func TestSearchPairsLargePrint(t *testing.T) {
+	regular := edition("1001", "The secret garden", "Burnett, Frances Hodgson")
+	large := largePrint("1002", "The secret garden", "Burnett, Frances Hodgson", true)
+	other := edition("1003", "Gardening", "Smith, Ann")
+	const warning = "Large print editions are shown with their regular print titles; the result total counts each record"
+
+	tests := []struct {
+		name    string
+		flag    bool
+		body    string
+		groups  []int
+		warning bool
+	}{
+		{"grouped", true, `{"query":"keyword: {garden}"}`, []int{2, 1}, false},
+		{"ungrouped", true, `{"query":"keyword: {garden}","grouped":false}`, []int{1, 1, 1}, false},
+		{"ungrouped opt in", true, `{"query":"keyword: {garden}","grouped":false,"preferences":{"pair_large_print":true}}`, []int{2, 1}, true},
+		{"opt in without flag", false, `{"query":"keyword: {garden}","grouped":false,"preferences":{"pair_large_print":true}}`, []int{1, 1, 1}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sierra := newFakeSierra(t)
+			sierra.handleJSON("bibs/search", http.StatusOK, searchResult(3, regular, large, other))
+			cfg := newTestConfig(sierra.apiURL())
+			cfg.PairLargePrint = tc.flag
+			router := newRouter(newTestServiceWithConfig(t, cfg))
+			var resp v4api.PoolResult
+			decodeTestJSON(t, apiRequest(t, router, http.MethodPost, "/api/search", tc.body), &resp)
+			counts := make([]int, 0)
+			for _, group := range resp.Groups {
+				counts = append(counts, group.Count)
+			}
+			if reflect.DeepEqual(counts, tc.groups) == false {
+				t.Errorf("group counts = %v, want %v", counts, tc.groups)
+			}
+			if resp.Pagination.Total != 3 {
+				t.Errorf("total = %d, want 3", resp.Pagination.Total)
+			}
+			if got := containsString(resp.Warnings, warning); got != tc.warning {
+				t.Errorf("warnings = %q, want the pairing warning %t", resp.Warnings, tc.warning)
+			}
+		})
+	}
+}
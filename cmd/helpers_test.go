@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/uvalib/virgo4-api/v4api"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+// testJWTKey signs the tokens minted by the tests
+const testJWTKey = "jmrl-test-key"
+
+// fakeSierraBase is the path of the Sierra API on the fake Sierra server
+const fakeSierraBase = "/iii/sierra-api/v6"
+
+// updateGolden rewrites the golden files in testdata instead of comparing against them
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// fakeSierra is a Sierra API served by httptest. Responses are registered by path,
+// relative to the API base. The token endpoint answers with a one hour token unless
+// replaced, and unregistered paths return the Sierra record not found error.
+type fakeSierra struct {
+	server *httptest.Server
+	lock   sync.Mutex
+	routes map[string]http.HandlerFunc
+	hits   map[string]int
+	urls   []string
+}
+
+// newFakeSierra starts a fake Sierra that is closed when the test ends
+func newFakeSierra(t *testing.T) *fakeSierra {
+	t.Helper()
+	fs := &fakeSierra{routes: make(map[string]http.HandlerFunc), hits: make(map[string]int)}
+	fs.handleJSON("token", http.StatusOK, map[string]interface{}{"access_token": "test-token", "token_type": "bearer", "expires_in": 3600})
+	fs.server = httptest.NewServer(http.HandlerFunc(fs.serve))
+	t.Cleanup(fs.server.Close)
+	return fs
+}
+
+// apiURL is the Sierra API URL of the fake, for the -api configuration
+func (fs *fakeSierra) apiURL() string {
+	return fs.server.URL + fakeSierraBase
+}
+
+func (fs *fakeSierra) serve(w http.ResponseWriter, r *http.Request) {
+	relPath := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, fakeSierraBase), "/")
+	fs.lock.Lock()
+	fs.hits[relPath]++
+	fs.urls = append(fs.urls, r.URL.String())
+	handler, found := fs.routes[relPath]
+	fs.lock.Unlock()
+	if found == false {
+		writeTestJSON(w, http.StatusNotFound, SierraError{Code: 107, HTTPStatus: 404, Name: "Record not found"})
+		return
+	}
+	handler(w, r)
+}
+
+// handle registers a handler for a path relative to the API base, e.g. bibs/search
+func (fs *fakeSierra) handle(relPath string, handler http.HandlerFunc) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.routes[relPath] = handler
+}
+
+// handleJSON registers a fixed JSON response for a path relative to the API base
+func (fs *fakeSierra) handleJSON(relPath string, status int, body interface{}) {
+	fs.handle(relPath, func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, status, body)
+	})
+}
+
+// count returns the number of requests made for a path relative to the API base
+func (fs *fakeSierra) count(relPath string) int {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.hits[relPath]
+}
+
+// requestURLs returns the path and query of every request received, in order
+func (fs *fakeSierra) requestURLs() []string {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return append([]string{}, fs.urls...)
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// newTestConfig returns the service configuration with the flag defaults. Messages are
+// loaded from the repository i18n directory.
+func newTestConfig(apiURL string) *ServiceConfig {
+	return &ServiceConfig{API: apiURL, APIKey: "key", APISecret: "secret", JWTKey: testJWTKey, I18NDir: "../i18n",
+		Placeholders: defaultPlaceholderLocations, SummaryLength: 300, SoonDays: 7, MaxSubjects: 25,
+		MaxConcurrent: 10, SlowMS: 2000, SuppressFields: defaultSuppressedFields, StripParams: defaultStripParams,
+		OnlineTypes: defaultOnlineMaterialTypes, MaxQueryLength: defaultMaxQueryLength}
+}
+
+// newTestService builds a service for the fake Sierra the way InitializeService does,
+// without the startup Sierra requests or background refreshes. The fake may be nil for
+// tests that make no Sierra requests.
+func newTestService(t *testing.T, sierra *fakeSierra) *ServiceContext {
+	t.Helper()
+	apiURL := "http://sierra.invalid" + fakeSierraBase
+	if sierra != nil {
+		apiURL = sierra.apiURL()
+	}
+	return newTestServiceWithConfig(t, newTestConfig(apiURL))
+}
+
+// newTestServiceWithConfig builds a test service with a modified configuration
+func newTestServiceWithConfig(t testing.TB, cfg *ServiceConfig) *ServiceContext {
+	t.Helper()
+	svc := &ServiceContext{Version: "test", Config: cfg, JWTKey: cfg.JWTKey, AuthToken: "dGVzdA=="}
+	sierra, err := newSierraClient(cfg.API)
+	if err != nil {
+		t.Fatalf("invalid test Sierra URL: %s", err.Error())
+	}
+	svc.Sierra = sierra
+	svc.Metrics = NewServiceMetrics()
+	svc.Limiter = newSierraLimiter(cfg.MaxConcurrent, time.Second, svc.Metrics)
+	svc.Suggestions = newTTLCache(suggestTTL, suggestCacheMax)
+	svc.Feeds = newTTLCache(feedTTL, feedCacheMax)
+	svc.BrowseCounts = newTTLCache(browseCountTTL, browseCountCacheMax)
+	if cfg.TrendingHours > 0 {
+		svc.Trending = newTrendingCounter(time.Duration(cfg.TrendingHours) * time.Hour)
+		svc.TrendingRecords = newTTLCache(trendingTTL, trendingCacheMax)
+	}
+	if cfg.RecordCacheSecs > 0 {
+		svc.Records = newTTLCache(time.Duration(cfg.RecordCacheSecs)*time.Second, recordCacheMax)
+	}
+	if cfg.ZeroResultsMax > 0 {
+		svc.ZeroResults = newZeroResultLog(cfg.ZeroResultsMax)
+	}
+	if cfg.FaultInjection {
+		svc.Faults = newFaultInjector()
+	}
+	if cfg.AdaptiveMS > 0 {
+		svc.Adaptive = newAdaptivePaging(time.Duration(cfg.AdaptiveMS) * time.Millisecond)
+	}
+	svc.PlaceholderLocations = parsePlaceholderLocations(cfg.Placeholders)
+	svc.Suppression = parseFieldSuppression(cfg.SuppressFields)
+	svc.StripParams = toCodeSet(strings.Split(strings.ToLower(cfg.StripParams), ","))
+	svc.OnlineTypes = parseMaterialTypes(cfg.OnlineTypes)
+	svc.BriefCodes = parseBriefCodes(cfg.BriefCodes)
+	svc.Locations.set(make(map[string]bool), nil)
+	svc.HTTPClient = &http.Client{Timeout: 5 * time.Second}
+	svc.I18NBundle, _ = newI18NBundle(cfg.I18NDir)
+	svc.setStaticModified()
+	return svc
+}
+
+// mintTestToken returns a signed V4 token for a user with the role
+func mintTestToken(t *testing.T, role v4jwt.RoleEnum) string {
+	t.Helper()
+	token, err := v4jwt.Mint(v4jwt.V4Claims{UserID: "tester", Role: role}, time.Hour, testJWTKey)
+	if err != nil {
+		t.Fatalf("unable to mint test token: %s", err.Error())
+	}
+	return token
+}
+
+// newTestContext returns a gin context for a request to a handler called directly. A
+// non-nil body is sent as JSON.
+func newTestContext(method string, target string, body interface{}) (*gin.Context, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	var reader io.Reader
+	if body != nil {
+		raw, _ := json.Marshal(body)
+		reader = bytes.NewReader(raw)
+	}
+	c.Request = httptest.NewRequest(method, target, reader)
+	if body != nil {
+		c.Request.Header.Set("Content-Type", "application/json")
+	}
+	return c, rec
+}
+
+// apiRequest sends a request with a user token through the router. A non-empty body
+// is sent as JSON.
+func apiRequest(t *testing.T, router http.Handler, method string, target string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return apiRequestAs(t, router, v4jwt.User, method, target, body)
+}
+
+// apiRequestAs sends a request with a token for the role through the router
+func apiRequestAs(t *testing.T, router http.Handler, role v4jwt.RoleEnum, method string, target string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+mintTestToken(t, role))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// asStaff adds staff claims to a test context, as authMiddleware would
+func asStaff(c *gin.Context) {
+	c.Set("claims", &v4jwt.V4Claims{UserID: "staff", Role: v4jwt.Staff})
+}
+
+// decodeTestJSON decodes a recorded JSON response
+func decodeTestJSON(t *testing.T, rec *httptest.ResponseRecorder, target interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), target); err != nil {
+		t.Fatalf("invalid JSON response %q: %s", rec.Body.String(), err.Error())
+	}
+}
+
+// marcField returns a MARC var field with subfields given as tag, content pairs
+func marcField(tag string, subfields ...string) JMRLVarFields {
+	out := JMRLVarFields{MarcTag: tag}
+	for i := 0; i+1 < len(subfields); i += 2 {
+		out.Subfields = append(out.Subfields, JMRLSubfield{Tag: subfields[i], Content: subfields[i+1]})
+	}
+	return out
+}
+
+// testBib returns a bib with a title, author and enough MARC to be a full record
+func testBib(id string, title string) JMRLBib {
+	return JMRLBib{ID: id, Title: title, Author: "Author, Test", PublishYear: 2020,
+		Type:     JMRLCodeValue{Code: "a", Value: "Book"},
+		Language: JMRLCodeValue{Code: "eng", Name: "English"},
+		VarFields: []JMRLVarFields{
+			marcField("100", "a", "Author, Test."),
+			marcField("245", "a", title),
+			marcField("260", "c", "2020."),
+		}}
+}
+
+// searchResult returns a Sierra search response for the bibs, relevance descending
+func searchResult(total int, bibs ...JMRLBib) JMRLResult {
+	out := JMRLResult{Count: len(bibs), Total: total, Entries: make([]JMRLEntry, 0, len(bibs))}
+	for idx, bib := range bibs {
+		out.Entries = append(out.Entries, JMRLEntry{Relevance: float32(len(bibs) - idx), Bib: bib})
+	}
+	return out
+}
+
+// testLocalizer returns a localizer of the service bundle for the language
+func testLocalizer(svc *ServiceContext, lang string) *i18n.Localizer {
+	return i18n.NewLocalizer(svc.I18NBundle, lang)
+}
+
+// fieldValues returns the values of the record fields with the name, in order
+func fieldValues(fields []v4api.RecordField, name string) []string {
+	out := make([]string, 0)
+	for _, f := range fields {
+		if f.Name == name {
+			out = append(out, f.Value)
+		}
+	}
+	return out
+}
+
+// checkGolden compares a value, encoded as indented JSON, with testdata/<name>.golden.json.
+// Run the tests with -update to rewrite the golden file after an intended change.
+func checkGolden(t *testing.T, name string, value interface{}) {
+	t.Helper()
+	got, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		t.Fatalf("unable to encode %s: %s", name, err.Error())
+	}
+	checkGoldenFile(t, name+".golden.json", append(got, '\n'))
+}
+
+// checkGoldenFile compares output with the testdata golden file, rewriting the file
+// instead with -update
+func checkGoldenFile(t *testing.T, file string, got []byte) {
+	t.Helper()
+	fn := filepath.Join("testdata", file)
+	if *updateGolden {
+		if err := os.WriteFile(fn, got, 0644); err != nil {
+			t.Fatalf("unable to update %s: %s", fn, err.Error())
+		}
+	}
+	want, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("unable to read %s: %s", fn, err.Error())
+	}
+	if bytes.Equal(got, want) == false {
+		t.Errorf("output does not match %s:\n%s", fn, got)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// identifier kinds recognized in identifier: clauses
+const (
+	identifierUnknown = iota
+	identifierISBN
+	identifierOCLC
+	identifierBibID
+)
+
+// Sierra text search index codes for standard numbers
+const sierraISBNIndex = "i"
+const sierraOCLCIndex = "o"
+
+// sierraNoMatch is a Sierra expression that never matches; it stands in for identifiers
+// that can't be parsed so the boolean structure of the rest of the query is preserved
+const sierraNoMatch = "v:(nomatch)"
+
+// bibIDPattern matches Sierra bib record numbers: .b1234567, b1234567 or b12345678
+// (with trailing check digit, which may be x)
+var bibIDPattern = regexp.MustCompile(`^\.?[bB](\d{7})[\dxX]?$`)
+
+// searchBoxBibPattern matches a bib number typed into the search box: a bib number as
+// above or a bare 7 digit record number, either optionally followed by the check digit
+var searchBoxBibPattern = regexp.MustCompile(`^\.?[bB]?(\d{7})([\dxX]?)$`)
+var oclcPattern = regexp.MustCompile(`^(?:\(OCoLC\)|ocm|ocn|on)(\d+)$`)
+
+// parseIdentifier detects the kind of a standard identifier and returns its normalized form
+func parseIdentifier(value string) (int, string) {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if m := bibIDPattern.FindStringSubmatch(value); m != nil {
+		return identifierBibID, m[1]
+	}
+	if m := oclcPattern.FindStringSubmatch(value); m != nil {
+		return identifierOCLC, m[1]
+	}
+	digits := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(value))
+	if isbn, ok := cleanISBN(digits); ok && isbn == digits {
+		if len(isbn) == 10 || strings.HasPrefix(isbn, "978") || strings.HasPrefix(isbn, "979") {
+			return identifierISBN, isbn
+		}
+	}
+	return identifierUnknown, value
+}
+
+// translateIdentifierClause converts the content of an identifier clause into Sierra
+// standard number searches. Boolean operators are preserved. Bib IDs and values that do
+// not parse can not be searched and become a no-match term.
+func translateIdentifierClause(content string) (string, error) {
+	parts := make([]string, 0)
+	for _, term := range strings.Fields(content) {
+		if term == "AND" || term == "OR" || term == "NOT" {
+			parts = append(parts, term)
+			continue
+		}
+		kind, normalized := parseIdentifier(term)
+		switch kind {
+		case identifierISBN:
+			// records may carry either form, so search both
+			forms := isbnForms(normalized)
+			parts = append(parts, fmt.Sprintf("%s:(%s)", sierraISBNIndex, strings.Join(forms, " OR ")))
+		case identifierOCLC:
+			parts = append(parts, fmt.Sprintf("%s:(%s)", sierraOCLCIndex, normalized))
+		default:
+			log.Printf("WARNING: identifier [%s] can not be searched", term)
+			parts = append(parts, sierraNoMatch)
+		}
+	}
+	if len(parts) == 0 {
+		return sierraNoMatch, nil
+	}
+	return "(" + strings.Join(parts, " ") + ")", nil
+}
+
+// singleBibID returns the bib ID when the entire query is a single identifier clause
+// holding a Sierra bib number
+func singleBibID(tokens []queryToken) (string, bool) {
+	if len(tokens) == 0 || tokens[0].Type != tokenField || tokens[0].Value != "identifier" {
+		return "", false
+	}
+	content, next, ok := clauseContent(tokens, 0)
+	if ok == false || next != len(tokens) {
+		return "", false
+	}
+	kind, id := parseIdentifier(content)
+	return id, kind == identifierBibID
+}
+
+// bibCheckDigit returns the Sierra check digit of a 7 digit record number: the digits
+// weighted 2, 3, 4... from the right, summed, mod 11, with 10 written as x
+func bibCheckDigit(num string) byte {
+	sum := 0
+	for i := len(num) - 1; i >= 0; i-- {
+		sum += int(num[i]-'0') * (len(num) - i + 1)
+	}
+	check := sum % 11
+	if check == 10 {
+		return 'x'
+	}
+	return byte('0' + check)
+}
+
+// keywordBibID returns the record number when the entire query is a keyword clause
+// holding just a Sierra bib number. A check digit, if present, must be correct.
+func keywordBibID(tokens []queryToken) (string, bool) {
+	if len(tokens) != 4 || tokens[0].Value != "keyword" {
+		return "", false
+	}
+	text, ok := simpleQueryText(tokens)
+	if ok == false {
+		return "", false
+	}
+	return parseBibNumber(strings.Trim(text, `"`))
+}
+
+// searchByBibID fetches a single bib directly and returns it as a one group result. The
+// Sierra request is abandoned when ctx ends. A bib that can't be found produces an empty
+// result set rather than an error, unless fallback is set; then false is returned so the
+// caller can search instead.
+func (svc *ServiceContext) searchByBibID(ctx context.Context, bibID string, acceptLang string, trace *requestTrace, fallback bool) (*v4api.PoolResult, *RequestError, bool) {
+	log.Printf("Search is for bib %s; fetch it directly", bibID)
+	startTime := time.Now()
+	trace.decision("direct bib lookup")
+	resp, status, err := svc.recordGet(ctx, svc.Sierra.BibURL(bibID, bibFields), trace)
+	trace.cached(status)
+	v4Resp := &v4api.PoolResult{ElapsedMS: int64(time.Since(startTime) / time.Millisecond), Confidence: "low"}
+	v4Resp.Groups = make([]v4api.Group, 0)
+	v4Resp.StatusCode = http.StatusOK
+	v4Resp.ContentLanguage = acceptLang
+
+	bib := &JMRLBib{}
+	if err == nil {
+		if parseErr := json.Unmarshal(resp, bib); parseErr != nil {
+			log.Printf("ERROR: Invalid response from JMRL API: %s", parseErr.Error())
+			err = &RequestError{StatusCode: http.StatusInternalServerError, Message: parseErr.Error()}
+		}
+	}
+	if err != nil && err.StatusCode == http.StatusNotFound && fallback {
+		log.Printf("Bib %s not found; search for it instead", bibID)
+		trace.decision("bib number not found; normal search")
+		return nil, nil, false
+	}
+	if err != nil && err.StatusCode != http.StatusNotFound {
+		v4Resp.StatusCode = err.StatusCode
+		v4Resp.StatusMessage = err.Message
+		return v4Resp, err, true
+	}
+
+	if err == nil {
+		trace.returned(1)
+		fieldOpts := fieldOptions{View: viewBrief, Language: acceptLang, Localizer: i18n.NewLocalizer(svc.I18NBundle, acceptLang)}
+		svc.logEncodingRepairs(bib)
+		record := v4api.Record{Fields: svc.getResultFields(bib, fieldOpts)}
+		v4Resp.Groups = append(v4Resp.Groups, v4api.Group{Value: bib.ID, Count: 1, Records: []v4api.Record{record}})
+		v4Resp.Confidence = "exact"
+	}
+	v4Resp.Pagination = v4api.Pagination{Start: 0, Total: len(v4Resp.Groups), Rows: len(v4Resp.Groups)}
+	return v4Resp, nil, true
+}
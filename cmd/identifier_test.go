@@ -0,0 +1,239 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+func TestParseIdentifier(t *testing.T) {
+	tests := []struct {
+		value string
+		kind  int
+		want  string
+	}{
+		{"0-306-40615-2", identifierISBN, "0306406152"},
+		{"030640615X", identifierISBN, "030640615X"},
+		{"978-0-306-40615-7", identifierISBN, "9780306406157"},
+		{`"979 10 90636 07 1"`, identifierISBN, "9791090636071"},
+		{".b1234567", identifierBibID, "1234567"},
+		{"b12345678", identifierBibID, "1234567"},
+		{"B1234567x", identifierBibID, "1234567"},
+		{"(OCoLC)12345", identifierOCLC, "12345"},
+		{"ocm00012345", identifierOCLC, "00012345"},
+		{"1234567890123", identifierUnknown, "1234567890123"},
+		{"12345", identifierUnknown, "12345"},
+		{"cats", identifierUnknown, "cats"},
+	}
+	for _, tc := range tests {
+		kind, got := parseIdentifier(tc.value)
+		if kind != tc.kind || got != tc.want {
+			t.Errorf("parseIdentifier(%q) = %d %q, want %d %q", tc.value, kind, got, tc.kind, tc.want)
+		}
+	}
+}
+
+func TestTranslateIdentifierClause(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"0-306-40615-2", "(i:(9780306406157 OR 0306406152))"},
+		{"978-0-306-40615-7", "(i:(9780306406157 OR 0306406152))"},
+		{"9791090636071", "(i:(9791090636071))"},
+		{"(OCoLC)12345 OR 0306406152", "(o:(12345) OR i:(9780306406157 OR 0306406152))"},
+		{"not-an-isbn", "(" + sierraNoMatch + ")"},
+		{".b1234567", "(" + sierraNoMatch + ")"},
+		{"", sierraNoMatch},
+	}
+	for _, tc := range tests {
+		got, err := translateIdentifierClause(tc.content)
+		if err != nil || got != tc.want {
+			t.Errorf("translateIdentifierClause(%q) = %s %v, want %s", tc.content, got, err, tc.want)
+		}
+	}
+}
+
+func TestIdentifierWithKeyword(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`identifier: {0306406152} OR keyword: {cats}`, `(i:(9780306406157 OR 0306406152)) OR (cats)`},
+		{`keyword: {cats} OR identifier: {junk}`, `(cats) OR (v:(nomatch))`},
+	}
+	for _, tc := range tests {
+		got, err := translateQuery(tokenizeQuery(tc.query))
+		if err != nil || got != tc.want {
+			t.Errorf("translateQuery(%q) = %s %v, want %s", tc.query, got, err, tc.want)
+		}
+	}
+}
+
+func TestSingleBibID(t *testing.T) {
+	tests := []struct {
+		query string
+		id    string
+		ok    bool
+	}{
+		{`identifier: {.b1234567}`, "1234567", true},
+		{`identifier: {"b12345678"}`, "1234567", true},
+		{`identifier: {.b1234567} OR keyword: {cats}`, "", false},
+		{`identifier: {0306406152}`, "", false},
+		{`keyword: {.b1234567}`, "", false},
+	}
+	for _, tc := range tests {
+		id, ok := singleBibID(tokenizeQuery(tc.query))
+		if ok != tc.ok || (ok && id != tc.id) {
+			t.Errorf("singleBibID(%q) = %q %t, want %q %t", tc.query, id, ok, tc.id, tc.ok)
+		}
+	}
+}
+
+func TestIdentifierSearch(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/1234567", http.StatusOK, testBib("1234567", "Direct"))
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(0))
+	router := newRouter(newTestService(t, sierra))
+
+	tests := []struct {
+		name   string
+		query  string
+		groups int
+		search string
+	}{
+		{"bib id is fetched directly", `identifier: {.b1234567}`, 1, ""},
+		{"missing bib is an empty result", `identifier: {.b7654321}`, 0, ""},
+		{"isbn is searched", `identifier: {978-0-306-40615-7}`, 0, "(i:(9780306406157 OR 0306406152))"},
+		{"unparseable identifier is an empty result", `identifier: {junk}`, 0, "(v:(nomatch))"},
+	}
+	for _, tc := range tests {
+		before := sierra.count("bibs/search")
+		rec := apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"`+strings.ReplaceAll(tc.query, `"`, `\"`)+`"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tc.name, rec.Code, rec.Body.String())
+		}
+		var resp v4api.PoolResult
+		decodeTestJSON(t, rec, &resp)
+		if len(resp.Groups) != tc.groups {
+			t.Errorf("%s: %d groups, want %d", tc.name, len(resp.Groups), tc.groups)
+		}
+		searched := sierra.count("bibs/search") > before
+		if searched != (tc.search != "") {
+			t.Errorf("%s: Sierra search sent %t, want %t", tc.name, searched, tc.search != "")
+		}
+		if tc.search == "" {
+			continue
+		}
+		urls := sierra.requestURLs()
+		sent, _ := url.Parse(urls[len(urls)-1])
+		if got := sent.Query().Get("text"); got != tc.search {
+			t.Errorf("%s: Sierra text = %s, want %s", tc.name, got, tc.search)
+		}
+	}
+	if resp := sierra.count("bibs/1234567"); resp != 1 {
+		t.Errorf("bib fetched %d times, want 1", resp)
+	}
+}
+
+func TestBibCheckDigit(t *testing.T) {
+	tests := []struct {
+		num  string
+		want byte
+	}{
+		{"1024364", '1'},
+		{"1234567", '2'},
+		{"1000000", '8'},
+		{"1000001", 'x'},
+	}
+	for _, tc := range tests {
+		if got := bibCheckDigit(tc.num); got != tc.want {
+			t.Errorf("bibCheckDigit(%s) = %c, want %c", tc.num, got, tc.want)
+		}
+	}
+}
+
+func TestKeywordBibID(t *testing.T) {
+	tests := []struct {
+		query string
+		id    string
+		ok    bool
+	}{
+		{`keyword: {b1234567}`, "1234567", true},
+		{`keyword: {.b1234567}`, "1234567", true},
+		{`keyword: {B12345672}`, "1234567", true},
+		{`keyword: {.b1000001x}`, "1000001", true},
+		{`keyword: {1000001X}`, "1000001", true},
+		{`keyword: {1234567}`, "1234567", true},
+		{`keyword: {"b1234567"}`, "1234567", true},
+		{`keyword: {b12345678}`, "", false},
+		{`keyword: {12345678}`, "", false},
+		{`keyword: {b123456}`, "", false},
+		{`keyword: {b1234567 cats}`, "", false},
+		{`title: {b1234567}`, "", false},
+		{`keyword: {b1234567} AND title: {cats}`, "", false},
+	}
+	for _, tc := range tests {
+		id, ok := keywordBibID(tokenizeQuery(tc.query))
+		if ok != tc.ok || id != tc.id {
+			t.Errorf("keywordBibID(%q) = %q %t, want %q %t", tc.query, id, ok, tc.id, tc.ok)
+		}
+	}
+}
+
+// a bib number in the search box is fetched directly, and searched for when it is not a record
+func TestSearchBoxBibNumber(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/1234567", http.StatusOK, testBib("1234567", "Direct"))
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "Mentions b7654321")))
+	router := newRouter(newTestService(t, sierra))
+
+	tests := []struct {
+		name       string
+		query      string
+		id         string
+		confidence string
+		search     string
+	}{
+		{"bib number", `keyword: {b1234567}`, "1234567", "exact", ""},
+		{"bib number with check digit", `keyword: {.b12345672}`, "1234567", "exact", ""},
+		{"bare record number", `keyword: {1234567}`, "1234567", "exact", ""},
+		{"unknown bib number", `keyword: {b7654321}`, "1001", "", "(b7654321)"},
+		{"wrong check digit", `keyword: {b12345679}`, "1001", "", "(b12345679)"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			before := sierra.count("bibs/search")
+			rec := apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"`+tc.query+`","pagination":{"start":0,"rows":20}}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var resp v4api.PoolResult
+			decodeTestJSON(t, rec, &resp)
+			if len(resp.Groups) != 1 || len(resp.Groups[0].Records) != 1 {
+				t.Fatalf("groups = %+v, want one record", resp.Groups)
+			}
+			if ids := fieldValues(resp.Groups[0].Records[0].Fields, "id"); len(ids) != 1 || ids[0] != tc.id {
+				t.Errorf("record = %v, want %s", ids, tc.id)
+			}
+			if tc.confidence != "" && resp.Confidence != tc.confidence {
+				t.Errorf("confidence = %q, want %q", resp.Confidence, tc.confidence)
+			}
+			searched := sierra.count("bibs/search") > before
+			if searched != (tc.search != "") {
+				t.Fatalf("Sierra search sent %t, want %t", searched, tc.search != "")
+			}
+			if tc.search == "" {
+				return
+			}
+			urls := sierra.requestURLs()
+			sent, _ := url.Parse(urls[len(urls)-1])
+			if got := sent.Query().Get("text"); got != tc.search {
+				t.Errorf("Sierra text = %s, want %s", got, tc.search)
+			}
+		})
+	}
+}
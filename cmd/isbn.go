@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// isbnCandidate finds the first ISBN-like run of digits, hyphens and spaces in an 020
+// value, ignoring qualifiers such as "(pbk.)"
+var isbnCandidate = regexp.MustCompile(`[0-9][0-9\- ]{8,16}[0-9Xx]`)
+
+// cleanISBN strips hyphens, spaces and qualifiers from an ISBN. The result is the bare
+// 10 or 13 character ISBN, and false if the value does not have the shape of an ISBN.
+// EX: "978-1-56619-909-4 (hardcover : alk. paper)" => 9781566199094
+func cleanISBN(raw string) (string, bool) {
+	match := isbnCandidate.FindString(raw)
+	if match == "" {
+		return "", false
+	}
+	isbn := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(match))
+	if len(isbn) == 10 && isAllDigits(isbn[:9]) && (isAllDigits(isbn[9:]) || isbn[9] == 'X') {
+		return isbn, true
+	}
+	if len(isbn) == 13 && isAllDigits(isbn) {
+		return isbn, true
+	}
+	return "", false
+}
+
+// isbn10CheckDigit computes the check digit for the first 9 digits of an ISBN-10
+func isbn10CheckDigit(digits string) string {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(digits[i]-'0') * (10 - i)
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return "X"
+	}
+	return fmt.Sprintf("%d", check)
+}
+
+// isbn13CheckDigit computes the check digit for the first 12 digits of an ISBN-13
+func isbn13CheckDigit(digits string) string {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(digits[i]-'0') * weight
+	}
+	return fmt.Sprintf("%d", (10-sum%10)%10)
+}
+
+// validISBN returns true if a cleaned ISBN-10 or ISBN-13 has a correct check digit
+func validISBN(isbn string) bool {
+	switch len(isbn) {
+	case 10:
+		return isbn10CheckDigit(isbn) == isbn[9:]
+	case 13:
+		return (strings.HasPrefix(isbn, "978") || strings.HasPrefix(isbn, "979")) && isbn13CheckDigit(isbn) == isbn[12:]
+	}
+	return false
+}
+
+// isbnForms returns the 13 and 10 digit forms of a cleaned, valid ISBN. 979 ISBNs have no
+// 10 digit form, and invalid ISBNs are returned unchanged as the only form.
+func isbnForms(isbn string) []string {
+	if validISBN(isbn) == false {
+		return []string{isbn}
+	}
+	if len(isbn) == 10 {
+		isbn13 := "978" + isbn[:9]
+		return []string{isbn13 + isbn13CheckDigit(isbn13), isbn}
+	}
+	if strings.HasPrefix(isbn, "978") {
+		isbn10 := isbn[3:12]
+		return []string{isbn, isbn10 + isbn10CheckDigit(isbn10)}
+	}
+	return []string{isbn}
+}
+
+// getISBNs returns the distinct normalized forms of all ISBNs in the 020$a fields
+func getISBNs(bib *JMRLBib) []string {
+	out := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, raw := range getVarField(&bib.VarFields, "020", "a") {
+		isbn, ok := cleanISBN(raw)
+		if ok == false {
+			continue
+		}
+		for _, form := range isbnForms(isbn) {
+			if seen[form] == false {
+				seen[form] = true
+				out = append(out, form)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCleanISBN(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		ok   bool
+	}{
+		{"978-1-56619-909-4 (hardcover : alk. paper)", "9781566199094", true},
+		{"1566199093 (pbk.)", "1566199093", true},
+		{"0-8044-2957-X", "080442957X", true},
+		{"080442957x (lib. bdg.)", "080442957X", true},
+		{"9780306406157 :", "9780306406157", true},
+		{"978 0 306 40615 7", "9780306406157", true},
+		{"ISBN 0306406152", "0306406152", true},
+		{"(v. 1) 9780306406157", "9780306406157", true},
+		{"9791090636071 (ebook)", "9791090636071", true},
+		// the shape is checked here; check digits are checked by validISBN
+		{"9780306406158", "9780306406158", true},
+		{"(pbk.)", "", false},
+		{"12345", "", false},
+		{"030640615", "", false},
+		{"97803064061577", "", false},
+		{"", "", false},
+	}
+	for _, tc := range tests {
+		got, ok := cleanISBN(tc.raw)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("cleanISBN(%q) = %q %t, want %q %t", tc.raw, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestValidISBN(t *testing.T) {
+	tests := []struct {
+		isbn string
+		want bool
+	}{
+		{"0306406152", true},
+		{"080442957X", true},
+		{"1566199093", true},
+		{"9780306406157", true},
+		{"9781566199094", true},
+		{"9791090636071", true},
+		{"0306406153", false},
+		{"9780306406158", false},
+		// a correct check digit is not enough without the Bookland prefix
+		{"9770306406151", false},
+		{"030640615", false},
+		{"", false},
+	}
+	for _, tc := range tests {
+		if got := validISBN(tc.isbn); got != tc.want {
+			t.Errorf("validISBN(%q) = %t, want %t", tc.isbn, got, tc.want)
+		}
+	}
+}
+
+func TestISBNForms(t *testing.T) {
+	tests := []struct {
+		isbn string
+		want []string
+	}{
+		{"0306406152", []string{"9780306406157", "0306406152"}},
+		{"9780306406157", []string{"9780306406157", "0306406152"}},
+		{"080442957X", []string{"9780804429573", "080442957X"}},
+		{"9780804429573", []string{"9780804429573", "080442957X"}},
+		{"9791090636071", []string{"9791090636071"}},
+		{"9780306406158", []string{"9780306406158"}},
+	}
+	for _, tc := range tests {
+		if got := isbnForms(tc.isbn); reflect.DeepEqual(got, tc.want) == false {
+			t.Errorf("isbnForms(%q) = %v, want %v", tc.isbn, got, tc.want)
+		}
+	}
+}
+
+// converting to the other form and back gives the ISBN unchanged
+func TestISBNFormsRoundTrip(t *testing.T) {
+	for _, isbn := range []string{"0306406152", "080442957X", "1566199093", "0140449132", "0000000000"} {
+		forms := isbnForms(isbn)
+		if len(forms) != 2 || validISBN(forms[0]) == false {
+			t.Fatalf("isbnForms(%q) = %v, want a valid 13 digit form", isbn, forms)
+		}
+		if back := isbnForms(forms[0]); reflect.DeepEqual(back, forms) == false {
+			t.Errorf("isbnForms(%q) = %v, want %v", forms[0], back, forms)
+		}
+	}
+}
+
+func TestISBNFields(t *testing.T) {
+	svc := newTestService(t, nil)
+	bib := testBib("1001", "Cats")
+	bib.VarFields = append(bib.VarFields,
+		marcField("020", "a", "978-1-56619-909-4 (hardcover : alk. paper)"),
+		marcField("020", "a", "1566199093 (pbk.)"),
+		marcField("020", "a", "0-8044-2957-X"),
+		marcField("020", "a", "9791090636071 (ebook)"),
+		marcField("020", "a", "(set)"),
+		marcField("020", "z", "0306406152"))
+	got := fieldValues(svc.getResultFields(&bib, fieldOptions{View: viewFull, Language: "en", Localizer: testLocalizer(svc, "en")}), "isbn")
+	want := []string{"9781566199094", "1566199093", "9780804429573", "080442957X", "9791090636071"}
+	if reflect.DeepEqual(got, want) == false {
+		t.Errorf("isbn fields = %v, want %v", got, want)
+	}
+}
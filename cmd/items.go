@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// itemFields is the list of item fields requested from Sierra
+const itemFields = "default,varFields,fixedFields"
+
+// itemAvailableStatus is the Sierra item status code for an item on the shelf
+const itemAvailableStatus = "-"
+
+// subfieldDelimiter matches the |x subfield markers Sierra embeds in item call numbers
+var subfieldDelimiter = regexp.MustCompile(`\|[a-z]`)
+
+// Holding is a single item of a bib as presented in the structured holdings list
+type Holding struct {
+	ItemID         string `json:"item_id"`
+	Volume         string `json:"volume,omitempty"`
+	Branch         string `json:"branch"`
+	Collection     string `json:"collection,omitempty"`
+	LocationCode   string `json:"location_code,omitempty"`
+	CallNumber     string `json:"call_number,omitempty"`
+	Status         string `json:"status"`
+	Available      bool   `json:"available"`
+	DueDate        string `json:"due_date,omitempty"`
+	DueDateDisplay string `json:"due_date_display,omitempty"`
+	LendingNote    string `json:"lending_note,omitempty"`
+}
+
+// itemVolumeTag is the item varField tag holding the volume designation
+const itemVolumeTag = "v"
+
+// VolumeAvailability is the roll-up availability of all copies of one volume of a set
+type VolumeAvailability struct {
+	Volume    string `json:"volume"`
+	Copies    int    `json:"copies"`
+	Available int    `json:"available"`
+}
+
+// item varField tags carrying patron facing notes; m is the item message and z the public
+// note. Other tags (x internal note, etc) are staff only and never exposed.
+var itemNoteTags = []string{"m", "z"}
+
+// the item fixed fields holding the item message and OPAC message codes
+const itemMessageFixedField = "97"
+const opacMessageFixedField = "108"
+
+// lendingNoteCodes maps the standard JMRL item / OPAC message codes onto i18n message IDs
+var lendingNoteCodes = map[string]string{
+	"l": "LendingLibraryUseOnly",
+	"r": "LendingLibraryUseOnly",
+	"s": "LendingSevenDay",
+	"n": "LendingNoRenewals",
+}
+
+// enrichmentAvailability names the item holdings and availability of a record when they
+// are skipped
+const enrichmentAvailability = "availability"
+
+// getItems fetches all non-deleted, non-suppressed items for a bib. A 404 from
+// Sierra means the bib has no items and is not an error.
+func (svc *ServiceContext) getItems(ctx context.Context, bibID string) ([]JMRLItem, cacheStatus, *RequestError) {
+	resp, status, err := svc.recordGet(ctx, svc.Sierra.ItemsURL([]string{bibID}, itemFields), nil)
+	if err != nil {
+		if err.StatusCode == http.StatusNotFound {
+			return make([]JMRLItem, 0), status, nil
+		}
+		return nil, status, err
+	}
+	itemResp := &JMRLItemResult{}
+	if parseErr := json.Unmarshal(resp, itemResp); parseErr != nil {
+		log.Printf("ERROR: Invalid items response from JMRL API: %s", parseErr.Error())
+		return nil, status, &RequestError{StatusCode: http.StatusInternalServerError, Message: parseErr.Error()}
+	}
+	return itemResp.Entries, status, nil
+}
+
+// parseSierraDate parses the date formats used by Sierra (RFC3339 or yyyy-mm-dd)
+func parseSierraDate(val string) (time.Time, bool) {
+	val = strings.TrimSpace(val)
+	if val == "" {
+		return time.Time{}, false
+	}
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse("2006-01-02", val); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// cleanCallNumber removes Sierra subfield delimiters (|a, |b) from an item call number
+func cleanCallNumber(callNum string) string {
+	return strings.Join(strings.Fields(subfieldDelimiter.ReplaceAllString(callNum, " ")), " ")
+}
+
+// getHoldings converts Sierra items into the structured holdings list, one per item.
+// Items in placeholder locations are skipped.
+func (svc *ServiceContext) getHoldings(items []JMRLItem, localizer *i18n.Localizer) []Holding {
+	out := make([]Holding, 0)
+	for _, item := range items {
+		if svc.isPlaceholderLocation(item.Location) {
+			continue
+		}
+		h := Holding{ItemID: item.ID, LocationCode: item.Location.Code,
+			Collection: svc.locationDisplayName(item.Location)}
+		h.Branch = h.Collection
+		if svc.isRedactedLocation(item.Location.Code) {
+			h.LocationCode = redactedLocationCode
+		} else if branch := svc.branchForLocation(item.Location.Code); branch != nil && branch.Name != "" {
+			h.Branch = branch.Name
+		}
+		h.CallNumber = cleanCallNumber(item.CallNumber)
+		h.Status = item.Status.Display
+		if due, ok := parseSierraDate(item.Status.DueDate); ok {
+			h.DueDate = due.Format(time.RFC3339)
+			h.DueDateDisplay = formatDisplayDate(localizer, due)
+		} else {
+			h.Available = item.Status.Code == itemAvailableStatus
+		}
+		h.LendingNote = getLendingNote(&item, localizer)
+		for _, vf := range item.VarFields {
+			if vf.FieldTag == itemVolumeTag && h.Volume == "" {
+				h.Volume = cleanNoteText(vf.Content)
+			}
+		}
+		out = append(out, h)
+	}
+	return out
+}
+
+// getLendingNote combines the localized standard lending message codes and any free
+// text item notes into a single note. Empty if the item has no special lending rules.
+func getLendingNote(item *JMRLItem, localizer *i18n.Localizer) string {
+	notes := make([]string, 0)
+	seen := make(map[string]bool)
+	addNote := func(note string) {
+		if note != "" && seen[strings.ToLower(note)] == false {
+			seen[strings.ToLower(note)] = true
+			notes = append(notes, note)
+		}
+	}
+	for _, ffID := range []string{itemMessageFixedField, opacMessageFixedField} {
+		ff, ok := item.FixedFields[ffID]
+		if ok == false {
+			continue
+		}
+		code := strings.TrimSpace(fmt.Sprintf("%v", ff.Value))
+		if msgID, known := lendingNoteCodes[code]; known {
+			addNote(localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: msgID}))
+		}
+	}
+	for _, vf := range item.VarFields {
+		for _, tag := range itemNoteTags {
+			if vf.FieldTag == tag {
+				addNote(cleanNoteText(vf.Content))
+			}
+		}
+	}
+	return strings.Join(notes, "; ")
+}
+
+// cleanNoteText unescapes entities and collapses whitespace in free text notes
+func cleanNoteText(note string) string {
+	note = strings.Join(strings.Fields(html.UnescapeString(note)), " ")
+	return strings.TrimRight(note, " ;,")
+}
+
+// getVolumeAvailability rolls up holdings by volume, in first seen order. Empty
+// unless the holdings carry volume designations.
+func getVolumeAvailability(holdings []Holding) []VolumeAvailability {
+	out := make([]VolumeAvailability, 0)
+	index := make(map[string]int)
+	for _, h := range holdings {
+		if h.Volume == "" {
+			continue
+		}
+		idx, found := index[h.Volume]
+		if found == false {
+			idx = len(out)
+			index[h.Volume] = idx
+			out = append(out, VolumeAvailability{Volume: h.Volume})
+		}
+		out[idx].Copies++
+		if h.Available {
+			out[idx].Available++
+		}
+	}
+	return out
+}
+
+// availabilityMessageID picks the i18n message for the bib-level availability of a set of
+// holdings. Multi-volume sets where only some volumes are on the shelf are reported as such.
+func availabilityMessageID(holdings []Holding, volumes []VolumeAvailability) string {
+	if len(volumes) > 1 {
+		availVols := 0
+		for _, v := range volumes {
+			if v.Available > 0 {
+				availVols++
+			}
+		}
+		if availVols > 0 && availVols < len(volumes) {
+			return "AvailabilitySomeVolumes"
+		}
+	}
+	for _, h := range holdings {
+		if h.Available {
+			return "AvailabilityOnShelf"
+		}
+	}
+	return "AvailabilityCheckedOut"
+}
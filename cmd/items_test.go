@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-api/v4api"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+// testItems are the items of a two volume set and a single copy title as Sierra
+// returns them, including an e-only placeholder and a redacted outreach location
+const testItems = `{"total":6,"entries":[
+{"id":"i1","bibIds":["1001"],"location":{"code":"cnf","name":"Central Nonfiction"},"status":{"code":"-","display":"AVAILABLE"},
+ "callNumber":"|a973.7 |bLIN","varFields":[{"fieldTag":"v","content":"v. 1"}]},
+{"id":"i2","bibIds":["1001"],"location":{"code":"nrf","name":"Northside Fiction"},"status":{"code":"-","display":"DUE 03-15-24","duedate":"2024-03-15T08:00:00Z"},
+ "callNumber":"973.7 LIN","varFields":[{"fieldTag":"v","content":"v. 2"},{"fieldTag":"z","content":"Spine label damaged;"}]},
+{"id":"i3","bibIds":["1001"],"location":{"code":"cnf","name":"Central Nonfiction"},"status":{"code":"-","display":"AVAILABLE"},
+ "callNumber":"973.7 LIN","varFields":[{"fieldTag":"v","content":"v. 2"}],"fixedFields":{"97":{"label":"IMESSAGE","value":"l"}}},
+{"id":"i4","bibIds":["1001"],"location":{"code":"jail","name":"Regional Jail"},"status":{"code":"m","display":"MISSING"},"callNumber":"973.7 LIN"},
+{"id":"i5","bibIds":["1001"],"location":{"code":"none","name":"None"},"status":{"code":"-","display":"AVAILABLE"}},
+{"id":"i6","bibIds":["1001"],"location":{"code":"crr","name":"Crozet Reference"},"status":{"code":"o","display":"LIB USE ONLY"},
+ "callNumber":"R 973.7 LIN","fixedFields":{"108":{"label":"OPACMSG","value":"r"}}}
+]}`
+
+func newHoldingsTestService(t *testing.T, sierra *fakeSierra) *ServiceContext {
+	t.Helper()
+	svc := newTestService(t, sierra)
+	svc.Locations.set(toCodeSet([]string{"jail"}), []BranchGeo{{Code: "cnf", Name: "Central Library"}, {Code: "nrf", Name: "Northside Library"}})
+	return svc
+}
+
+func TestHoldingsGolden(t *testing.T) {
+	var items JMRLItemResult
+	if err := json.Unmarshal([]byte(testItems), &items); err != nil {
+		t.Fatalf("unable to decode items: %s", err.Error())
+	}
+	svc := newHoldingsTestService(t, nil)
+	for _, lang := range []string{"en-US", "es"} {
+		holdings := svc.getHoldings(items.Entries, testLocalizer(svc, lang))
+		checkGolden(t, "holdings."+lang, struct {
+			Holdings []Holding            `json:"holdings"`
+			Volumes  []VolumeAvailability `json:"volumes"`
+		}{holdings, getVolumeAvailability(holdings)})
+	}
+}
+
+func TestResourceHoldingsAlongsideFields(t *testing.T) {
+	sierra := newFakeSierra(t)
+	bib := testBib("1001", "Lincoln")
+	bib.Locations = []JMRLCodeValue{{Code: "cnf", Name: "Central Nonfiction"}, {Code: "nrf", Name: "Northside Fiction"}}
+	bib.VarFields = append(bib.VarFields, marcField("092", "a", "973.7 LIN"))
+	sierra.handleJSON("bibs/1001", http.StatusOK, bib)
+	sierra.handle("items", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(testItems))
+	})
+	svc := newHoldingsTestService(t, sierra)
+	router := gin.New()
+	router.GET("/api/resource/:id", svc.authMiddleware, svc.getResource)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/resource/1001", nil)
+	req.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.User))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Fields   []v4api.RecordField  `json:"fields"`
+		Holdings []Holding            `json:"holdings"`
+		Volumes  []VolumeAvailability `json:"volumes"`
+	}
+	decodeTestJSON(t, rec, &resp)
+	if len(resp.Holdings) != 5 {
+		t.Errorf("got %d holdings, want 5", len(resp.Holdings))
+	}
+	if len(resp.Volumes) != 2 {
+		t.Errorf("got %d volumes, want 2", len(resp.Volumes))
+	}
+	// the flat fields remain for clients that do not use holdings
+	for _, name := range []string{"location", "call_number", "availability", "availability_class"} {
+		if len(fieldValues(resp.Fields, name)) == 0 {
+			t.Errorf("flat %s field missing", name)
+		}
+	}
+	if got := fieldValues(resp.Fields, "earliest_due_iso"); len(got) != 1 || got[0] != "2024-03-15T08:00:00Z" {
+		t.Errorf("earliest_due_iso = %v", got)
+	}
+}
+
+func TestIdentifyAdvertisesHoldings(t *testing.T) {
+	svc := newTestService(t, nil)
+	c, rec := newTestContext(http.MethodGet, "/identify", nil)
+	svc.identifyHandler(c)
+	var identity v4api.PoolIdentity
+	decodeTestJSON(t, rec, &identity)
+	for _, attr := range identity.Attributes {
+		if attr.Name == "holdings" {
+			if attr.Supported == false {
+				t.Errorf("holdings attribute is not supported")
+			}
+			return
+		}
+	}
+	t.Errorf("identify has no holdings attribute")
+}
+
+func TestLendingNotes(t *testing.T) {
+	svc := newTestService(t, nil)
+	tests := []struct {
+		name string
+		item JMRLItem
+		en   string
+		es   string
+	}{
+		{"seven day DVD", JMRLItem{ID: "dvd", FixedFields: map[string]JMRLFixedField{
+			itemMessageFixedField: {Label: "IMESSAGE", Value: "s"}, opacMessageFixedField: {Label: "OPACMSG", Value: "n"}}},
+			"7-day loan; No renewals", "Préstamo de 7 días; Sin renovaciones"},
+		{"reference", JMRLItem{ID: "ref", FixedFields: map[string]JMRLFixedField{
+			itemMessageFixedField: {Label: "IMESSAGE", Value: "r"}, opacMessageFixedField: {Label: "OPACMSG", Value: "l"}}},
+			"In-library use only", "Solo para uso en la biblioteca"},
+		{"free text", JMRLItem{ID: "note", VarFields: []JMRLVarFields{
+			{FieldTag: "m", Content: "  Ask at&nbsp;the  desk; "}, {FieldTag: "x", Content: "staff only"}, {FieldTag: "z", Content: "Includes map"}}},
+			"Ask at the desk; Includes map", "Ask at the desk; Includes map"},
+		{"code and free text", JMRLItem{ID: "both", FixedFields: map[string]JMRLFixedField{
+			itemMessageFixedField: {Label: "IMESSAGE", Value: "s"}}, VarFields: []JMRLVarFields{{FieldTag: "z", Content: "7-DAY LOAN"}}},
+			"7-day loan", "Préstamo de 7 días; 7-DAY LOAN"},
+		{"unknown code", JMRLItem{ID: "unknown", FixedFields: map[string]JMRLFixedField{itemMessageFixedField: {Label: "IMESSAGE", Value: "-"}}}, "", ""},
+		{"no notes", JMRLItem{ID: "plain"}, "", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := getLendingNote(&tc.item, testLocalizer(svc, "en-US")); got != tc.en {
+				t.Errorf("English note = %q, want %q", got, tc.en)
+			}
+			if got := getLendingNote(&tc.item, testLocalizer(svc, "es")); got != tc.es {
+				t.Errorf("Spanish note = %q, want %q", got, tc.es)
+			}
+		})
+	}
+}
+
+func TestVolumeSetAvailability(t *testing.T) {
+	var items JMRLItemResult
+	loadFixture(t, "items_2002.json", &items)
+	svc := newTestService(t, nil)
+	holdings := svc.getHoldings(items.Entries, testLocalizer(svc, "en-US"))
+	volumes := getVolumeAvailability(holdings)
+	want := []VolumeAvailability{{"v. 1", 1, 1}, {"v. 2", 1, 1}, {"v. 3", 1, 1}, {"v. 4", 1, 0}, {"v. 5", 1, 1}}
+	if reflect.DeepEqual(volumes, want) == false {
+		t.Errorf("volumes = %+v, want %+v", volumes, want)
+	}
+
+	tests := []struct {
+		name     string
+		holdings []Holding
+		want     string
+	}{
+		{"one volume out", holdings, "AvailabilitySomeVolumes"},
+		{"all in", []Holding{{Volume: "v. 1", Available: true}, {Volume: "v. 2", Available: true}}, "AvailabilityOnShelf"},
+		{"none in", []Holding{{Volume: "v. 1"}, {Volume: "v. 2"}}, "AvailabilityCheckedOut"},
+		{"another copy in", []Holding{{Volume: "v. 1", Available: true}, {Volume: "v. 2"}, {Volume: "v. 2", Available: true}}, "AvailabilityOnShelf"},
+		{"single volume", []Holding{{Volume: "v. 1"}, {Volume: "v. 1", Available: true}}, "AvailabilityOnShelf"},
+		{"no volumes", []Holding{{}, {Available: true}}, "AvailabilityOnShelf"},
+	}
+	for _, tc := range tests {
+		if got := availabilityMessageID(tc.holdings, getVolumeAvailability(tc.holdings)); got != tc.want {
+			t.Errorf("%s: availabilityMessageID = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestResourceVolumeSet(t *testing.T) {
+	sierra := newFakeSierra(t)
+	bib := testBib("2002", "World Book Encyclopedia")
+	bib.Locations = []JMRLCodeValue{{Code: "cnf", Name: "Central Nonfiction"}}
+	sierra.handleJSON("bibs/2002", http.StatusOK, bib)
+	serveFixture(t, sierra, "items", "items_2002.json")
+	svc := newTestService(t, sierra)
+	router := newRouter(svc)
+
+	for lang, want := range map[string]string{"en-US": "Some volumes available", "es": "Algunos volúmenes disponibles"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/resource/2002", nil)
+		req.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.User))
+		req.Header.Set("Accept-Language", lang)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp struct {
+			Fields  []v4api.RecordField  `json:"fields"`
+			Volumes []VolumeAvailability `json:"volumes"`
+		}
+		decodeTestJSON(t, rec, &resp)
+		if got := fieldValues(resp.Fields, "availability"); len(got) != 1 || got[0] != want {
+			t.Errorf("%s availability = %q, want [%s]", lang, got, want)
+		}
+		if len(resp.Volumes) != 5 || resp.Volumes[3].Available != 0 {
+			t.Errorf("%s volumes = %+v", lang, resp.Volumes)
+		}
+	}
+}
+
+// holdings are left out, and the record marked incomplete, when the items can't be fetched
+// within the request budget; the bib fields are always returned
+func TestResourceIncompleteWhenItemsSkipped(t *testing.T) {
+	var items JMRLItemResult
+	if err := json.Unmarshal([]byte(testItems), &items); err != nil {
+		t.Fatalf("unable to decode items: %s", err.Error())
+	}
+	tests := []struct {
+		name     string
+		items    http.HandlerFunc
+		holdings int
+		complete bool
+	}{
+		{"items returned", slowHandler(0, items), 5, true},
+		{"items too slow", slowHandler(2*time.Second, items), 0, false},
+		{"items failed", func(w http.ResponseWriter, r *http.Request) {
+			writeTestJSON(w, http.StatusInternalServerError, SierraError{Code: 109, Description: "Internal error"})
+		}, 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sierra := newFakeSierra(t)
+			sierra.handleJSON("bibs/1001", http.StatusOK, testBib("1001", "Lincoln"))
+			sierra.handle("items", tc.items)
+			router := newRouter(newHoldingsTestService(t, sierra))
+			req := httptest.NewRequest(http.MethodGet, "/api/resource/1001", nil)
+			req.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.User))
+			req.Header.Set(searchTimeoutHeader, "200")
+			rec := httptest.NewRecorder()
+			start := time.Now()
+			router.ServeHTTP(rec, req)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("resource took %s, past its budget", elapsed)
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var resp struct {
+				Fields             []v4api.RecordField `json:"fields"`
+				Holdings           []Holding           `json:"holdings"`
+				Complete           bool                `json:"complete"`
+				SkippedEnrichments []string            `json:"skipped_enrichments"`
+			}
+			decodeTestJSON(t, rec, &resp)
+			if resp.Complete != tc.complete {
+				t.Errorf("complete = %t, want %t", resp.Complete, tc.complete)
+			}
+			wantSkipped := []string{enrichmentAvailability}
+			if tc.complete {
+				wantSkipped = nil
+			}
+			if reflect.DeepEqual(resp.SkippedEnrichments, wantSkipped) == false {
+				t.Errorf("skipped_enrichments = %v, want %v", resp.SkippedEnrichments, wantSkipped)
+			}
+			if len(resp.Holdings) != tc.holdings {
+				t.Errorf("%d holdings, want %d", len(resp.Holdings), tc.holdings)
+			}
+			if got := fieldValues(resp.Fields, "title"); len(got) != 1 || got[0] != "Lincoln" {
+				t.Errorf("title = %v, want the core fields intact", got)
+			}
+			if got := fieldValues(resp.Fields, "availability_class"); (len(got) == 1) != tc.complete {
+				t.Errorf("availability_class = %v with complete %t", got, tc.complete)
+			}
+		})
+	}
+}
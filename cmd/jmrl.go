@@ -1,18 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"html"
+	"io"
 	"log"
 	"net/http"
-	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
 	"github.com/uvalib/virgo4-api/v4api"
-	"github.com/uvalib/virgo4-parser/v4parser"
 )
 
 type providerDetails struct {
@@ -26,6 +29,21 @@ type poolProviders struct {
 	Providers []providerDetails `json:"providers"`
 }
 
+// jmrlSearchRequest is a V4 search request plus optional JMRL pool extensions
+type jmrlSearchRequest struct {
+	v4api.SearchRequest
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	// Grouped false returns every edition as its own group; editions are grouped by default
+	Grouped *bool `json:"grouped,omitempty"`
+	// AddedDays limits results to bibs added to the catalog in the last n days
+	AddedDays int `json:"added_days,omitempty"`
+	// Preferences replaces the V4 preferences to add the online resources preference
+	Preferences jmrlSearchPreferences `json:"preferences,omitempty"`
+	// TimeoutMS is the time the caller will wait for results; it overrides X-Search-Timeout-Ms
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+}
+
 // ProvidersHandler returns a list of access_url providers for JMRL
 func (svc *ServiceContext) providersHandler(c *gin.Context) {
 	p := poolProviders{Providers: make([]providerDetails, 0)}
@@ -41,230 +59,485 @@ func (svc *ServiceContext) providersHandler(c *gin.Context) {
 		LogoURL:     "/assets/overdrive.png",
 		HomepageURL: "https://www.overdrive.com",
 	})
-	c.JSON(http.StatusOK, p)
+	svc.sendCacheableJSON(c, p)
 }
 
-// Search accepts a search POST, transforms the query into JMRL format and perfoms the search
-func (svc *ServiceContext) search(c *gin.Context) {
-	log.Printf("JMRL search requested")
-	var req v4api.SearchRequest
-	if err := c.BindJSON(&req); err != nil {
-		log.Printf("ERROR: unable to parse search request: %s", err.Error())
-		c.String(http.StatusBadRequest, "invalid request")
-		return
+// isJSONContentType returns true for application/json and any +json media type
+func isJSONContentType(contentType string) bool {
+	ct := strings.ToLower(strings.TrimSpace(contentType))
+	return ct == "application/json" || (strings.HasPrefix(ct, "application/") && strings.HasSuffix(ct, "+json"))
+}
+
+// requireJSONBody verifies that a POST body is JSON. Requests with no Content-Type are
+// accepted if the body looks like JSON. Anything else is rejected with a 415 and false is returned.
+func requireJSONBody(c *gin.Context) bool {
+	contentType := c.ContentType()
+	if isJSONContentType(contentType) {
+		return true
+	}
+	if contentType == "" {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		trimmed := bytes.TrimSpace(body)
+		if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+			log.Printf("WARNING: request to %s has no Content-Type; attempting to parse body as JSON", c.Request.URL.Path)
+			return true
+		}
 	}
+	log.Printf("ERROR: unsupported content type [%s] for %s", contentType, c.Request.URL.Path)
+	sendError(c, http.StatusUnsupportedMediaType, errUnsupportedMediaType,
+		fmt.Sprintf("unsupported content type [%s]; application/json is required", contentType))
+	return false
+}
 
-	acceptLang := strings.Split(c.GetHeader("Accept-Language"), ",")[0]
-	if acceptLang == "" {
-		acceptLang = "en-US"
+// searchEnv is what a search takes from the HTTP request that asked for it
+type searchEnv struct {
+	// Header has the Accept-Language, location and time budget headers of the request
+	Header http.Header
+	// Path is the request path, for logging
+	Path string
+	// Debug is set when ?debug=true was requested
+	Debug bool
+	Staff bool
+	// Probe is set for synthetic probe searches, which are not recorded as zero result queries
+	Probe bool
+}
+
+// newSearchEnv captures the parts of the request a search uses
+func newSearchEnv(c *gin.Context) searchEnv {
+	return searchEnv{Header: c.Request.Header.Clone(), Path: c.Request.URL.Path, Debug: c.Query("debug") == "true",
+		Staff: isStaff(c), Probe: c.GetBool("synthetic_probe")}
+}
+
+// parseSearchRequest decodes a search POST body and returns the fields it has that the
+// JMRL search request does not
+func parseSearchRequest(body []byte) (jmrlSearchRequest, []string, error) {
+	var jmrlReq jmrlSearchRequest
+	if err := json.Unmarshal(body, &jmrlReq); err != nil {
+		return jmrlReq, nil, err
 	}
+	return jmrlReq, unknownRequestKeys(body, jmrlReq), nil
+}
 
-	// make sure the query is well formed
-	log.Printf("Raw query: %s, %+v", req.Query, req.Pagination)
-	valid, errors := v4parser.Validate(req.Query)
-	if valid == false {
-		log.Printf("ERROR: Query [%s] is not valid: %s", req.Query, errors)
-		c.String(http.StatusBadRequest, "Malformed search")
+// addUnknownKeysWarning reports the unsupported request fields in the search result, so
+// changes to the V4 contract are noticed
+func addUnknownKeysWarning(res *v4api.PoolResult, unknownKeys []string) {
+	if len(unknownKeys) == 0 {
 		return
 	}
+	log.Printf("WARNING: search request contains unsupported fields: %s", strings.Join(unknownKeys, ", "))
+	res.Warnings = append(res.Warnings, fmt.Sprintf("Unsupported search request fields were ignored: %s", strings.Join(unknownKeys, ", ")))
+}
 
-	// JMRL does not support filtering. If a filter is specified in the search, return 0 hits
-	// Note: when doing a next page request, the request contains:
-	//       Filters:[{PoolID:worldcat Facets:[]}]
-	//       accept this configuration
-	filtersSpecified := false
-	if len(req.Filters) > 1 {
-		filtersSpecified = true
-	} else if len(req.Filters) == 1 {
-		filtersSpecified = len(req.Filters[0].Facets) > 0
-	}
-	if filtersSpecified || strings.Contains(req.Query, "filter:") {
-		log.Printf("Filters specified in search, return no matches")
-		v4Resp := &v4api.PoolResult{ElapsedMS: 0, Confidence: "low"}
-		v4Resp.Groups = make([]v4api.Group, 0)
-		v4Resp.Pagination = v4api.Pagination{Start: 0, Total: 0, Rows: 0}
-		v4Resp.StatusCode = http.StatusOK
-		v4Resp.ContentLanguage = acceptLang
-		c.JSON(http.StatusOK, v4Resp)
-		return
+// searchErrorCode returns the error code of a failed search
+func searchErrorCode(err error) errorCode {
+	if reqErr, ok := err.(*RequestError); ok {
+		return reqErr.errorCode()
 	}
+	return errInternal
+}
 
-	// date, identifier, journal_title, and filter are not supported.
-	// Fail these with a bad request and info about the reason
-	// We mark these messages as WARNING's because they are expected
-	if strings.Contains(req.Query, "date:") {
-		log.Printf("WARNING: date queries are not supported")
-		c.String(http.StatusNotImplemented, "Date queries are not supported")
+// Search accepts a search POST, transforms the query into JMRL format and perfoms the search
+func (svc *ServiceContext) search(c *gin.Context) {
+	log.Printf("JMRL search requested")
+	if requireJSONBody(c) == false {
 		return
 	}
-	if strings.Contains(req.Query, "identifier:") {
-		log.Printf("WARNING: identifier queries are not supported")
-		c.String(http.StatusNotImplemented, "Identifier queries are not supported")
+	body, _ := io.ReadAll(c.Request.Body)
+	jmrlReq, unknownKeys, parseErr := parseSearchRequest(body)
+	if parseErr != nil {
+		log.Printf("ERROR: unable to parse search request: %s", parseErr.Error())
+		sendError(c, http.StatusBadRequest, errBadRequest, "invalid request")
 		return
 	}
-	if strings.Contains(req.Query, "journal_title:") {
-		log.Printf("WARNING: journal title queries are not supported")
-		c.String(http.StatusNotImplemented, "Journal Title queries are not supported")
+	env := newSearchEnv(c)
+	trace := newRequestTrace(env.traceEnabled(jmrlReq.Preferences.Debug))
+	validatedAt := time.Now()
+	v4Resp, err := svc.runSearch(c.Request.Context(), jmrlReq, env, trace)
+	addUnknownKeysWarning(v4Resp, unknownKeys)
+	recordCacheStatus(c, trace.cacheStatus())
+	if err != nil {
+		sendPoolError(c, v4Resp, searchErrorCode(err))
 		return
 	}
-
-	// EX: keyword: {(calico OR "tortoise shell") AND cats}
-	// Approach, replace all {} with (),
-	// Remove keyword:, replace subject, author and title with JMRL codes
-	parsedQ := req.Query
-	parsedQ = strings.ReplaceAll(parsedQ, "{", "(")
-	parsedQ = strings.ReplaceAll(parsedQ, "}", ")")
-	parsedQ = strings.ReplaceAll(parsedQ, "keyword:", "")
-	parsedQ = strings.ReplaceAll(parsedQ, "title:", "t:")
-	parsedQ = strings.ReplaceAll(parsedQ, "author:", "a:")
-	parsedQ = strings.ReplaceAll(parsedQ, "subject:", "d:")
-
-	// map unsupported fields to fine inventory number, which they won't match
-	// this preserves the AND/OR/NOT behavior
-	parsedQ = strings.ReplaceAll(parsedQ, "published:", "v:")
-
-	parsedQ = strings.TrimSpace(parsedQ)
-	log.Printf("Parsed query: %s", parsedQ)
-	if parsedQ == "()" {
-		parsedQ = "(*)"
+	// a direct bib lookup names the record cache entry it came from
+	if key := trace.recordCacheKey(); key != "" {
+		setCacheKeyHeader(c, key)
+	}
+	pageSize := effectivePageSize(jmrlReq.Pagination.Rows)
+	if links := paginationLinks(c.Request.URL.Path, v4Resp.Pagination.Start, pageSize, v4Resp.Pagination.Rows, v4Resp.Pagination.Total); links != "" {
+		c.Header("Link", links)
 	}
+	svc.sendSearchResult(c, v4Resp, trace, validatedAt)
+}
 
-	parsedQ = url.QueryEscape(parsedQ)
-	fields := "fields=default,varFields,locations,available"
-	paging := fmt.Sprintf("offset=%d&limit=%d", req.Pagination.Start, 20)
-	tgtURL := fmt.Sprintf("%s/bibs/search?text=%s&%s&%s", svc.API, parsedQ, paging, fields)
+// runSearch performs a search and returns its pool result. Sierra requests are abandoned
+// when ctx ends or the time budget of the request is spent. A failed search returns the
+// result to send, with its status, and a *RequestError.
+func (svc *ServiceContext) runSearch(ctx context.Context, jmrlReq jmrlSearchRequest, env searchEnv, trace *requestTrace) (*v4api.PoolResult, error) {
+	requestStart := time.Now()
+	req := jmrlReq.SearchRequest
+	userLoc := getRequestLocation(env.Header, jmrlReq.Latitude, jmrlReq.Longitude)
+	budget := requestBudget(env.Header, jmrlReq.TimeoutMS)
+	budgetCtx, cancelBudget := budgetContext(ctx, budget)
+	defer cancelBudget()
+
+	acceptLang := svc.negotiateLanguage(env.Header.Get("Accept-Language"))
+
+	xlate, xlateErr := svc.translateSearch(jmrlReq)
+	if xlateErr != nil {
+		v4Resp := &v4api.PoolResult{StatusCode: xlateErr.StatusCode, StatusMessage: xlateErr.Message,
+			Groups: make([]v4api.Group, 0), ContentLanguage: acceptLang}
+		return v4Resp, xlateErr
+	}
+	if xlate.BibID != "" {
+		v4Resp, err, found := svc.searchByBibID(budgetCtx, xlate.BibID, acceptLang, trace, xlate.BibFallback)
+		if found {
+			svc.finishTrace(env, v4Resp, trace, requestStart)
+			if err != nil {
+				return v4Resp, err
+			}
+			if len(v4Resp.Groups) > 0 {
+				svc.recordReturned(env, []string{xlate.BibID})
+			}
+			return v4Resp, nil
+		}
+	}
+	if xlate.StopWordsOnly {
+		// Sierra either fails or returns everything for these; neither is useful
+		log.Printf("WARNING: query [%s] contains only stop words", req.Query)
+		localizer := i18n.NewLocalizer(svc.I18NBundle, acceptLang)
+		v4Resp := &v4api.PoolResult{Confidence: "low", Sort: xlate.Sort, Groups: make([]v4api.Group, 0),
+			Pagination: v4api.Pagination{Start: xlate.Start}, StatusCode: http.StatusOK, ContentLanguage: acceptLang}
+		v4Resp.Warnings = append(v4Resp.Warnings, xlate.Warnings...)
+		v4Resp.Warnings = append(v4Resp.Warnings, localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "StopWordsOnly"}))
+		svc.finishTrace(env, v4Resp, trace, requestStart)
+		return v4Resp, nil
+	}
+	tokens := xlate.Tokens
+	sortOrder := xlate.Sort
+	pageSize := xlate.Rows
+	recentBrowse := xlate.RecentBrowse
 
 	startTime := time.Now()
-	resp, err := svc.apiGet(tgtURL)
+	var jmrlResp *JMRLResult
+	var err *RequestError
+	totalEstimated := false
+	var pageWarnings []string
+	if recentBrowse {
+		jmrlResp, err = svc.recentBibs(budgetCtx, xlate.Start, pageSize, startTime.AddDate(0, 0, -xlate.BrowseDays), trace)
+	} else if xlate.postFiltered() {
+		trace.decision("post filter")
+		jmrlResp, totalEstimated, err = svc.postFilteredSearch(budgetCtx, xlate.Params, xlate.Start, pageSize, sortOrder, xlate.postFilter, trace)
+	} else {
+		jmrlResp, pageWarnings, err = svc.pagedSearch(budgetCtx, xlate.Params, xlate.Start, pageSize, trace)
+	}
 	elapsedNanoSec := time.Since(startTime)
 	elapsedMS := int64(elapsedNanoSec / time.Millisecond)
-	v4Resp := &v4api.PoolResult{ElapsedMS: elapsedMS, Confidence: "low"}
+	v4Resp := &v4api.PoolResult{ElapsedMS: elapsedMS, Confidence: "low", Sort: sortOrder}
 	v4Resp.Groups = make([]v4api.Group, 0)
+	if trace.enabled() {
+		v4Resp.Debug = map[string]interface{}{"sierra_query": xlate.Query, "sierra_url": trace.firstURL()}
+	}
+	v4Resp.Warnings = append(v4Resp.Warnings, xlate.Warnings...)
 
 	if err != nil {
 		v4Resp.StatusCode = err.StatusCode
 		v4Resp.StatusMessage = err.Message
-		c.JSON(err.StatusCode, v4Resp)
-		return
+		if isBudgetExceeded(err) {
+			svc.Metrics.Increment("search_budget_exceeded")
+			v4Resp.Warnings = append(v4Resp.Warnings, fmt.Sprintf("JMRL did not respond within the %dms search time budget", budget/time.Millisecond))
+		}
+		// failed searches keep their trace; the attempts are what explain the failure
+		svc.finishTrace(env, v4Resp, trace, requestStart)
+		return v4Resp, err
 	}
-
-	jmrlResp := &JMRLResult{}
-	respErr := json.Unmarshal(resp, jmrlResp)
-	if respErr != nil {
-		log.Printf("ERROR: Invalid response from JMRL API: %s", respErr.Error())
-		v4Resp.StatusCode = http.StatusInternalServerError
-		v4Resp.StatusMessage = respErr.Error()
-		c.JSON(err.StatusCode, v4Resp)
-		return
+	if recentBrowse {
+		v4Resp.Warnings = append(v4Resp.Warnings, fmt.Sprintf("Showing recent additions from the last %d days", xlate.BrowseDays))
+	}
+	v4Resp.Warnings = append(v4Resp.Warnings, pageWarnings...)
+	if totalEstimated {
+		v4Resp.Warnings = append(v4Resp.Warnings, xlate.postFilterWarning())
 	}
 
 	v4Resp.Pagination = v4api.Pagination{Start: jmrlResp.Start, Total: jmrlResp.Total,
-		Rows: jmrlResp.Count}
-	for _, entry := range jmrlResp.Entries {
+		Rows: len(jmrlResp.Entries)}
+	distances := make(map[string]float64)
+	if recentBrowse == false {
+		orderEntries(jmrlResp.Entries, sortOrder)
+	}
+	svc.recordReturned(env, entryIDs(jmrlResp.Entries))
+	fieldOpts := fieldOptions{View: viewBrief, Language: acceptLang, Localizer: i18n.NewLocalizer(svc.I18NBundle, acceptLang)}
+	records := make([]v4api.Record, len(jmrlResp.Entries))
+	recordDist := make(map[int]float64)
+	for idx, entry := range jmrlResp.Entries {
 		bib := entry.Bib
-		groupRec := v4api.Group{Value: bib.ID, Count: 1}
-		groupRec.Records = make([]v4api.Record, 0)
 		record := v4api.Record{}
-		record.Fields = getResultFields(&bib)
-		groupRec.Records = append(groupRec.Records, record)
+		svc.logEncodingRepairs(&bib)
+		fieldOpts.Relevance = entry.Relevance
+		record.Fields = svc.getResultFields(&bib, fieldOpts)
+		if userLoc != nil {
+			if dist, ok := svc.nearestBranchDistance(&bib, userLoc); ok {
+				recordDist[idx] = dist
+				record.Fields = append(record.Fields, v4api.RecordField{Name: "nearest_branch_distance",
+					Type: "distance", Label: "Nearest Branch (miles)", Value: fmt.Sprintf("%.1f", dist), Visibility: "detailed"})
+			}
+		}
+		records[idx] = record
+	}
+
+	// editions of the same work on this page are grouped unless the client opts out. Without
+	// work grouping, large print bibs are only paired with their regular print sibling when
+	// the client asks for it as well.
+	var clusters [][]int
+	grouped := jmrlReq.Grouped == nil || *jmrlReq.Grouped
+	if grouped {
+		clusters = groupWorks(jmrlResp.Entries)
+	} else if svc.Config.PairLargePrint && jmrlReq.Preferences.PairLargePrint {
+		var pairs int
+		clusters, pairs = pairLargePrint(jmrlResp.Entries)
+		if pairs > 0 {
+			v4Resp.Warnings = append(v4Resp.Warnings,
+				"Large print editions are shown with their regular print titles; the result total counts each record")
+		}
+	} else {
+		clusters = make([][]int, 0, len(jmrlResp.Entries))
+		for idx := range jmrlResp.Entries {
+			clusters = append(clusters, []int{idx})
+		}
+	}
+	for _, cluster := range clusters {
+		groupRec := v4api.Group{Value: jmrlResp.Entries[cluster[0]].Bib.ID, Count: len(cluster)}
+		if grouped {
+			groupRec.Value = workGroupValue(jmrlResp.Entries, cluster)
+			orderEditions(jmrlResp.Entries, cluster)
+		}
+		groupRec.Records = make([]v4api.Record, 0, len(cluster))
+		for _, idx := range cluster {
+			groupRec.Records = append(groupRec.Records, records[idx])
+			if dist, ok := recordDist[idx]; ok {
+				if prev, seen := distances[groupRec.Value]; seen == false || dist < prev {
+					distances[groupRec.Value] = dist
+				}
+			}
+		}
 		v4Resp.Groups = append(v4Resp.Groups, groupRec)
 	}
 
+	// distance sorting is only possible for the records on this page; Sierra has no notion of it
+	if sortOrder.SortID == sortNearest {
+		if userLoc == nil {
+			v4Resp.Sort = v4api.SortOrder{SortID: v4api.SortRelevance.String(), Order: "desc"}
+		} else {
+			log.Printf("Apply page-local distance sort")
+			sortGroupsByDistance(v4Resp.Groups, distances)
+			v4Resp.Warnings = append(v4Resp.Warnings, "Distance ordering applies only to the results on this page")
+		}
+	}
+
 	if jmrlResp.Total > 0 {
-		v4Resp.Confidence = "medium"
+		v4Resp.Confidence = searchConfidence(tokens, jmrlResp.Entries, jmrlResp.Total)
+	} else {
+		if suggestion := svc.didYouMean(budgetCtx, xlate, fieldOpts.Localizer, trace); suggestion != "" {
+			v4Resp.Warnings = append(v4Resp.Warnings, suggestion)
+		}
+		if svc.ZeroResults != nil && env.Probe == false {
+			svc.ZeroResults.add(req.Query)
+		}
 	}
 
 	v4Resp.StatusCode = http.StatusOK
 	v4Resp.ContentLanguage = acceptLang
-	c.JSON(http.StatusOK, v4Resp)
+	svc.finishTrace(env, v4Resp, trace, requestStart)
+	return v4Resp, nil
+}
+
+// field projections; brief is used for search results and full for resource details
+const viewBrief = "brief"
+const viewFull = "full"
+
+// fieldOptions controls how bib data is projected into record fields
+type fieldOptions struct {
+	View      string
+	Language  string
+	Localizer *i18n.Localizer
+	Relevance float32
+}
+
+// sierraBCode3Field is the fixed field number of the Sierra BCODE3 bib code
+const sierraBCode3Field = "31"
+
+// parseBriefCodes converts the comma separated brief record codes into a lookup set
+func parseBriefCodes(list string) map[string]bool {
+	out := make(map[string]bool)
+	for _, code := range strings.Split(list, ",") {
+		if code = strings.ToLower(strings.TrimSpace(code)); code != "" {
+			out[code] = true
+		}
+	}
+	return out
+}
+
+// isBriefRecord detects the brief "on-the-fly" bibs Sierra creates for ILL and temporary
+// items. A configured bib level or BCODE3 code identifies them directly; otherwise a
+// record with little or no MARC data, most notably no 245 title, is treated as brief.
+func (svc *ServiceContext) isBriefRecord(bib *JMRLBib) bool {
+	if svc.BriefCodes[strings.ToLower(strings.TrimSpace(bib.BibLevel.Code))] {
+		return true
+	}
+	if ff, ok := bib.FixedFields[sierraBCode3Field]; ok {
+		if code, ok := ff.Value.(string); ok && svc.BriefCodes[strings.ToLower(strings.TrimSpace(code))] {
+			return true
+		}
+	}
+	return len(bib.VarFields) < 3 || len(getVarField(&bib.VarFields, "245", "a")) == 0
 }
 
 // TODO localization of labels
-func getResultFields(bib *JMRLBib) []v4api.RecordField {
+func (svc *ServiceContext) getResultFields(bib *JMRLBib, opts fieldOptions) []v4api.RecordField {
+	svc.suppressVarFields(bib)
+	view := opts.View
 	fields := make([]v4api.RecordField, 0)
 	f := v4api.RecordField{Name: "id", Type: "identifier", Label: "Identifier",
-		Value: bib.ID, Display: "optional", CitationPart: "id"}
+		Value: bib.ID, Display: "optional"}
 	fields = append(fields, f)
 
-	for _, loc := range bib.Locations {
+	// e-only records carry only placeholder locations; show them as held by JMRL generally
+	locations := svc.normalizeLocations(bib.Locations)
+	if len(locations) == 0 && len(bib.Locations) > 0 {
+		f = v4api.RecordField{Name: "location", Type: "location", Label: "Location",
+			Value: "Jefferson-Madison Regional Library"}
+		fields = append(fields, f)
+	}
+	for _, loc := range locations {
 		val := fmt.Sprintf("Jefferson-Madison Regional Library - %s", loc.Name)
-		if loc.Name == "none" {
-			val = "Jefferson-Madison Regional Library"
-		}
 		f = v4api.RecordField{Name: "location", Type: "location", Label: "Location",
 			Value: val}
 		fields = append(fields, f)
 	}
 
-	f = v4api.RecordField{Name: "publication_date", Type: "publication_date", Label: "Publication Date",
-		Value: fmt.Sprintf("%d", bib.PublishYear), CitationPart: "published_date"}
-	fields = append(fields, f)
+	if bib.PublishYear > 0 {
+		f = v4api.RecordField{Name: "publication_date", Type: "publication_date", Label: "Publication Date",
+			Value: fmt.Sprintf("%d", bib.PublishYear)}
+		fields = append(fields, f)
+	}
 
 	f = v4api.RecordField{Name: "format", Type: "format", Label: "Format",
-		Value: bib.Type.Value, CitationPart: "format"}
+		Value: bib.Type.Value}
 	fields = append(fields, f)
 
 	f = v4api.RecordField{Name: "language", Type: "language", Label: "Language",
-		Value: bib.Language.Value, Visibility: "detailed", CitationPart: "language"}
+		Value: languageDisplay(bib.Language), Visibility: "detailed"}
 	fields = append(fields, f)
+	if code := marcLanguageCode(bib); code != "" {
+		f = v4api.RecordField{Name: "language_code", Type: "language_code", Value: code, Visibility: "hidden"}
+		fields = append(fields, f)
+	}
 
+	// brief records fall back to the Sierra-normalized default title and author
+	briefRecord := svc.isBriefRecord(bib)
 	vals := getVarField(&bib.VarFields, "245", "a")
-	f = v4api.RecordField{Name: "title", Type: "title", Label: "Title", Value: html.UnescapeString(vals[0]), CitationPart: "title"}
+	title := strings.TrimSpace(bib.Title)
+	if len(vals) > 0 {
+		title = vals[0]
+	}
+	if title == "" {
+		title = bib.ID
+	}
+	f = v4api.RecordField{Name: "title", Type: "title", Label: "Title", Value: html.UnescapeString(title)}
+	fields = append(fields, f)
+	f = v4api.RecordField{Name: "title_collation_key", Type: "collation_key",
+		Value: svc.Collators.key(opts.Language, html.UnescapeString(title)), Visibility: "hidden"}
 	fields = append(fields, f)
+	if briefRecord {
+		log.Printf("Bib %s is a brief record; using default title and author", bib.ID)
+		note := opts.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "BriefRecordNote"})
+		f = v4api.RecordField{Name: "brief_record_note", Type: "note", Label: "Note", Value: note}
+		fields = append(fields, f)
+	}
 
 	vals = getVarField(&bib.VarFields, "245", "b")
 	if len(vals) > 0 {
-		f = v4api.RecordField{Name: "subtitle", Type: "subtitle", Label: "Subtitle", Value: html.UnescapeString(vals[0]), CitationPart: "subtitle"}
+		f = v4api.RecordField{Name: "subtitle", Type: "subtitle", Label: "Subtitle", Value: html.UnescapeString(vals[0])}
 		fields = append(fields, f)
 	}
 
-	vals = getVarField(&bib.VarFields, "020", "a")
-	for _, val := range vals {
-		f = v4api.RecordField{Name: "isbn", Type: "isbn", Label: "ISBN", Value: val, Visibility: "detailed", CitationPart: "serial_number"}
+	for _, val := range getISBNs(bib) {
+		f = v4api.RecordField{Name: "isbn", Type: "isbn", Label: "ISBN", Value: val, Visibility: "detailed"}
 		fields = append(fields, f)
 	}
 
-	vals = getVarField(&bib.VarFields, "092", "")
-	for _, val := range vals {
+	for _, val := range getCallNumbers(&bib.VarFields) {
 		f = v4api.RecordField{Name: "call_number", Type: "call_number", Label: "Call Number",
-			Value: val, Visibility: "detailed", CitationPart: "call_number"}
+			Value: val, Visibility: "detailed"}
 		fields = append(fields, f)
 	}
 
-	vals = getVarField(&bib.VarFields, "100", "a")
-	for _, val := range vals {
-		f = v4api.RecordField{Name: "author", Type: "author", Label: "Author", Value: html.UnescapeString(val), CitationPart: "author"}
+	authors := getAuthorValues(bib)
+	if len(authors) == 0 && briefRecord && strings.TrimSpace(bib.Author) != "" {
+		authors = append(authors, authorValue{Display: strings.TrimSpace(bib.Author)})
+	}
+	for _, author := range authors {
+		f = v4api.RecordField{Name: "author", Type: "author", Label: "Author", Value: author.Display}
 		fields = append(fields, f)
 	}
 
 	// Get subjects....
-	marcIDs := []string{"600", "650", "651", "647"}
-	for _, id := range marcIDs {
-		vals = getVarField(&bib.VarFields, id, "a")
-		for _, val := range vals {
-			f = v4api.RecordField{Name: "subject", Type: "subject", Label: "Subject", Value: val, Visibility: "detailed", CitationPart: "subject"}
-			fields = append(fields, f)
-		}
+	subjects, moreSubjects := capSubjects(getSubjectHeadings(&bib.VarFields), svc.Config.MaxSubjects)
+	for _, val := range subjects {
+		f = v4api.RecordField{Name: "subject", Type: "subject", Label: "Subject", Value: val, Visibility: "detailed"}
+		fields = append(fields, f)
+	}
+	if moreSubjects != "" {
+		f = v4api.RecordField{Name: "subject_more", Type: "note", Label: "Subject", Value: moreSubjects, Visibility: "detailed"}
+		fields = append(fields, f)
+	}
+
+	for _, val := range getSeries(&bib.VarFields) {
+		f = v4api.RecordField{Name: "series", Type: "series", Label: "Series", Value: val}
+		fields = append(fields, f)
+	}
+
+	for _, val := range getGenres(&bib.VarFields) {
+		f = v4api.RecordField{Name: "genre", Type: "genre", Label: "Genre", Value: val}
+		fields = append(fields, f)
 	}
 
 	vals = getVarField(&bib.VarFields, "505", "a")
 	if len(vals) > 0 {
+		val := cleanFreeText(vals[0])
+		if view == viewBrief {
+			val, _ = truncateText(val, svc.Config.SummaryLength)
+		}
 		f = v4api.RecordField{Name: "contents", Type: "contents", Label: "Contents",
-			Value: html.UnescapeString(vals[0]), Visibility: "detailed"}
+			Value: val, Visibility: "detailed"}
 		fields = append(fields, f)
 	}
 
 	vals = getVarField(&bib.VarFields, "520", "a")
 	if len(vals) > 0 {
+		val := cleanFreeText(vals[0])
+		truncated := false
+		if view == viewBrief {
+			val, truncated = truncateText(val, svc.Config.SummaryLength)
+		}
 		f = v4api.RecordField{Name: "summary", Type: "summary", Label: "Summary",
-			Value: html.UnescapeString(vals[0]), CitationPart: "abstract"}
+			Value: val}
 		fields = append(fields, f)
+		if truncated {
+			f = v4api.RecordField{Name: "summary_truncated", Type: "boolean", Value: "true", Visibility: "hidden"}
+			fields = append(fields, f)
+		}
 	}
 
+	fields = append(fields, getAudienceFields(bib)...)
+	fields = append(fields, svc.getAccessURLFields(bib)...)
+	fields = append(fields, getDateAddedFields(bib, opts.Localizer)...)
+
 	vals = getVarField(&bib.VarFields, "776", "d")
 	if len(vals) > 0 {
 		f = v4api.RecordField{Name: "published", Type: "published", Label: "Published", Value: vals[0],
-			Visibility: "detailed", CitationPart: "publisher"}
+			Visibility: "detailed"}
 		fields = append(fields, f)
 	}
 
@@ -288,6 +561,18 @@ func getResultFields(bib *JMRLBib) []v4api.RecordField {
 		}
 		fields = append(fields, availF)
 	*/
+	// browse and direct lookups have no relevance; omit it rather than showing 0
+	if opts.Relevance > 0 {
+		f = v4api.RecordField{Name: "relevance", Type: "number",
+			Label: opts.Localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "FieldRelevance"}),
+			Value: strconv.FormatFloat(float64(opts.Relevance), 'f', -1, 32), Visibility: "detailed"}
+		fields = append(fields, f)
+	}
+
+	fields = svc.suppressRecordFields(fields)
+	fields = append(fields, getFieldLanguageFields(bib, fields, opts.Language)...)
+	fields = applyCitationParts(fields)
+	svc.applyFieldOverrides(fields)
 	return fields
 }
 
@@ -336,41 +621,176 @@ func indexAt(s string, tgt string, startIdx int) int {
 	return idx
 }
 
-// Facets placeholder implementaion for a V4 facet POST.
+// Facets returns the filters JMRL supports for a V4 facet POST: format, language,
+// availability, and the branches when a branch table is configured. Format, language and
+// branch counts are approximated from a sample of the search hits; see sampleFacetBibs.
+// Availability counts are not known.
 func (svc *ServiceContext) facets(c *gin.Context) {
-	log.Printf("JMRL facets requested, but JMRL does not support this. Returning empty list")
-	empty := make(map[string]interface{})
-	empty["facets"] = make([]v4api.Facet, 0)
-	c.JSON(http.StatusOK, empty)
+	log.Printf("JMRL facets requested")
+	start := time.Now()
+	var jmrlReq jmrlSearchRequest
+	if err := c.ShouldBindJSON(&jmrlReq); err != nil {
+		log.Printf("ERROR: unable to parse facets request: %s", err.Error())
+		sendError(c, http.StatusBadRequest, errBadRequest, "invalid request")
+		return
+	}
+	xlate, xlateErr := svc.translateSearch(jmrlReq)
+	if xlateErr != nil {
+		sendFacetsError(c, xlateErr)
+		return
+	}
+	budgetCtx, cancelBudget := budgetContext(c.Request.Context(), requestBudget(c.Request.Header, jmrlReq.TimeoutMS))
+	defer cancelBudget()
+	trace := newRequestTrace(false)
+
+	acceptLang := svc.negotiateLanguage(c.GetHeader("Accept-Language"))
+	localizer := i18n.NewLocalizer(svc.I18NBundle, acceptLang)
+	resp := poolFacets{PoolFacets: v4api.PoolFacets{StatusCode: http.StatusOK, Warnings: make([]string, 0)},
+		FacetList: make([]poolFacet, 0)}
+	// a bib number lookup or a stop word query has no search to sample
+	var branchCounts map[string]int
+	approximate := false
+	if xlate.Params != nil && xlate.StopWordsOnly == false {
+		bibs, warning, err := svc.sampleFacetBibs(budgetCtx, xlate, trace)
+		if err != nil {
+			log.Printf("WARNING: unable to count facets: %s", err.Message)
+			resp.Warnings = append(resp.Warnings, "Facet counts are not available")
+		} else {
+			format := newPoolFacet(formatFacet(bibs, xlate.Filters, localizer))
+			language := svc.languageFacet(bibs, xlate.Filters, localizer, acceptLang)
+			branchCounts = svc.branchCounts(bibs)
+			if warning != "" {
+				resp.Warnings = append(resp.Warnings, warning)
+				format.Approximate = true
+				language.Approximate = true
+				approximate = true
+			}
+			resp.FacetList = append(resp.FacetList, format, language)
+		}
+	}
+	selected, _ := getAvailabilityFilter(xlate.Filters)
+	avail := v4api.Facet{ID: filterAvailability, Type: "checkbox",
+		Name: localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "FacetAvailability"})}
+	avail.Buckets = []v4api.FacetBucket{
+		{Value: availOnShelf, Selected: selected.OnShelf},
+		{Value: availOnline, Selected: selected.Online},
+	}
+	resp.FacetList = append(resp.FacetList, newPoolFacet(avail))
+	if _, branchGeo := svc.Locations.get(); len(branchGeo) > 0 {
+		branches := newPoolFacet(svc.branchFacet(xlate.Filters, branchCounts, localizer))
+		branches.Approximate = approximate
+		resp.FacetList = append(resp.FacetList, branches)
+	}
+	resp.ElapsedMS = int64(time.Since(start) / time.Millisecond)
+	c.JSON(http.StatusOK, resp)
 }
 
 // GetResource will get a JMRL resource by ID
 func (svc *ServiceContext) getResource(c *gin.Context) {
 	id := c.Param("id")
 	log.Printf("Resource %s details requested", id)
-	acceptLang := strings.Split(c.GetHeader("Accept-Language"), ",")[0]
-	if acceptLang == "" {
-		acceptLang = "en-US"
-	}
+	acceptLang := svc.negotiateLanguage(c.GetHeader("Accept-Language"))
+	budgetCtx, cancelBudget := budgetContext(c.Request.Context(), requestBudget(c.Request.Header, 0))
+	defer cancelBudget()
 
-	tgtURL := fmt.Sprintf("%s/bibs/%s?fields=default,varFields,locations,available", svc.API, id)
-	resp, err := svc.apiGet(tgtURL)
+	tgtURL := svc.Sierra.BibURL(id, bibFields)
+	resp, status, err := svc.recordGet(budgetCtx, tgtURL, nil)
 	if err != nil {
-		c.JSON(err.StatusCode, err.Message)
+		sendError(c, err.StatusCode, err.errorCode(), err.Message)
 		return
 	}
+	if svc.Records != nil {
+		setCacheKeyHeader(c, recordCacheKey(tgtURL))
+	}
 
 	jmrlBib := &JMRLBib{}
 	respErr := json.Unmarshal(resp, jmrlBib)
 	if respErr != nil {
 		log.Printf("ERROR: Invalid response from JMRL API: %s", respErr.Error())
-		c.JSON(http.StatusInternalServerError, respErr.Error())
+		sendError(c, http.StatusInternalServerError, errUpstreamError, respErr.Error())
+		return
+	}
+
+	// deleted bibs are usually merged duplicates; point the client at the survivor if possible
+	if jmrlBib.Deleted {
+		if target, found := svc.findMergeTarget(c.Request.Context(), jmrlBib); found {
+			log.Printf("Bib %s was deleted %s; merged into %s", id, jmrlBib.DeletedDate, target)
+			c.Header("Location", fmt.Sprintf("/api/resource/%s", target))
+			c.JSON(http.StatusMovedPermanently, gin.H{"id": id, "moved_to": target})
+			return
+		}
+		log.Printf("Bib %s was deleted %s with no merge target", id, jmrlBib.DeletedDate)
+		sendError(c, http.StatusNotFound, errRecordNotFound, fmt.Sprintf("record %s not found", id))
 		return
 	}
 
 	var jsonResp struct {
-		Fields []v4api.RecordField `json:"fields"`
+		Fields             []v4api.RecordField  `json:"fields"`
+		Holdings           []Holding            `json:"holdings"`
+		Volumes            []VolumeAvailability `json:"volumes,omitempty"`
+		Complete           bool                 `json:"complete"`
+		SkippedEnrichments []string             `json:"skipped_enrichments,omitempty"`
+		Raw                json.RawMessage      `json:"raw,omitempty"`
+		Debug              *cacheStatus         `json:"debug,omitempty"`
+	}
+	svc.logEncodingRepairs(jmrlBib)
+	fieldOpts := fieldOptions{View: viewFull, Language: acceptLang, Localizer: i18n.NewLocalizer(svc.I18NBundle, acceptLang)}
+	jsonResp.Fields = svc.getResultFields(jmrlBib, fieldOpts)
+
+	// holdings are supplemental; an items failure or a spent budget leaves them empty and the
+	// response incomplete rather than failing the request
+	jsonResp.Holdings = make([]Holding, 0)
+	jsonResp.Complete = true
+	items, itemStatus, itemErr := svc.getItems(budgetCtx, jmrlBib.ID)
+	status = status.combine(itemStatus)
+	if itemErr != nil {
+		log.Printf("WARNING: unable to get items for %s: %s", jmrlBib.ID, itemErr.Message)
+		jsonResp.Complete = false
+		jsonResp.SkippedEnrichments = []string{enrichmentAvailability}
+		svc.Metrics.Increment("resource_incomplete")
+	} else {
+		jsonResp.Holdings = svc.getHoldings(items, fieldOpts.Localizer)
+		jsonResp.Volumes = getVolumeAvailability(jsonResp.Holdings)
+		if len(jsonResp.Holdings) > 0 {
+			availMsg := fieldOpts.Localizer.MustLocalize(&i18n.LocalizeConfig{
+				MessageID: availabilityMessageID(jsonResp.Holdings, jsonResp.Volumes)})
+			jsonResp.Fields = append(jsonResp.Fields, v4api.RecordField{Name: "availability",
+				Type: "availability", Label: "Availability", Value: availMsg})
+		}
+		if due, ok := earliestDue(jsonResp.Holdings); ok {
+			jsonResp.Fields = append(jsonResp.Fields,
+				getDateFields(fieldOpts.Localizer, "earliest_due", "Earliest Due", due, "detailed")...)
+		}
+		online := hasOnlineAccess(jmrlBib)
+		soonWindow := time.Duration(svc.Config.SoonDays) * 24 * time.Hour
+		availClass := classifyAvailability(jsonResp.Holdings, online, time.Now(), soonWindow)
+		jsonResp.Fields = append(jsonResp.Fields, v4api.RecordField{Name: "availability_class",
+			Type: "availability_class", Value: availClass, Visibility: "hidden"})
+	}
+
+	jsonResp.Fields = svc.suppressRecordFields(jsonResp.Fields)
+	svc.applyFieldOverrides(jsonResp.Fields)
+
+	// staff can request the unmodified Sierra bib for comparison with the displayed fields.
+	// With -suppressraw, suppressed MARC data is removed from it as well.
+	if c.Query("raw") == "true" {
+		if isStaff(c) && svc.Config.SuppressRaw {
+			if raw, rawErr := svc.suppressRawBib(resp); rawErr != nil {
+				log.Printf("ERROR: unable to suppress fields in raw bib %s: %s", id, rawErr.Error())
+			} else {
+				jsonResp.Raw = json.RawMessage(raw)
+			}
+		} else if isStaff(c) {
+			jsonResp.Raw = json.RawMessage(resp)
+		} else {
+			log.Printf("WARNING: raw bib data requested for %s by non-staff user; ignoring", id)
+		}
+	}
+
+	setCacheHeader(c, status)
+	if traceEnabled(c, false) {
+		jsonResp.Debug = &status
 	}
-	jsonResp.Fields = getResultFields(jmrlBib)
-	c.JSON(http.StatusOK, jsonResp)
+	c.Header("Content-Language", acceptLang)
+	svc.streamJSON(c, http.StatusOK, jsonResp)
 }
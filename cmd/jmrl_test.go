@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-api/v4api"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+func TestIsJSONContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"application/json", true},
+		{"Application/JSON", true},
+		{" application/json ", true},
+		{"application/vnd.api+json", true},
+		{"application/ld+json", true},
+		{"text/plain", false},
+		{"application/x-www-form-urlencoded", false},
+		{"multipart/form-data", false},
+		{"text/json+plain", false},
+		{"", false},
+	}
+	for _, tc := range tests {
+		if got := isJSONContentType(tc.contentType); got != tc.want {
+			t.Errorf("isJSONContentType(%q) = %t, want %t", tc.contentType, got, tc.want)
+		}
+	}
+}
+
+func TestRequireJSONBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		accepted    bool
+	}{
+		{"json", "application/json", `{"query":"keyword: {cats}"}`, true},
+		{"json with charset", "application/json; charset=utf-8", `{"query":"x"}`, true},
+		{"vendor json", "application/vnd.virgo+json", `{"query":"x"}`, true},
+		{"form encoded", "application/x-www-form-urlencoded", "query=cats", false},
+		{"text plain json", "text/plain", `{"query":"x"}`, false},
+		{"multipart", "multipart/form-data; boundary=x", "--x--", false},
+		{"missing type json object", "", ` {"query":"x"}`, true},
+		{"missing type json array", "", `[{"query":"x"}]`, true},
+		{"missing type not json", "", "query=cats", false},
+		{"missing type empty", "", "", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/search", strings.NewReader(tc.body))
+			if tc.contentType != "" {
+				c.Request.Header.Set("Content-Type", tc.contentType)
+			}
+			if got := requireJSONBody(c); got != tc.accepted {
+				t.Fatalf("requireJSONBody = %t, want %t", got, tc.accepted)
+			}
+			if tc.accepted {
+				// the body must still be readable by the handler
+				var buf bytes.Buffer
+				buf.ReadFrom(c.Request.Body)
+				if buf.String() != tc.body {
+					t.Errorf("body after check = %q, want %q", buf.String(), tc.body)
+				}
+				return
+			}
+			if rec.Code != http.StatusUnsupportedMediaType {
+				t.Fatalf("status = %d, want 415", rec.Code)
+			}
+			// the media type is named without its parameters
+			mediaType := strings.Split(tc.contentType, ";")[0]
+			var resp errorResponse
+			decodeTestJSON(t, rec, &resp)
+			if resp.ErrorCode != errUnsupportedMediaType || strings.Contains(resp.StatusMessage, "["+mediaType+"]") == false {
+				t.Errorf("error = %+v, want %s naming [%s]", resp, errUnsupportedMediaType, mediaType)
+			}
+		})
+	}
+}
+
+// rawTestBib is a Sierra bib response with fields the pool does not model
+const rawTestBib = `{"id": "1001", "title": "Raw Title", "bibLevel": {"code": "m"},
+	"varFields": [{"marcTag": "245", "subfields": [{"tag": "a", "content": "Raw Title"}]},
+		{"marcTag": "100", "subfields": [{"tag": "a", "content": "Author, Raw"}]},
+		{"marcTag": "260", "subfields": [{"tag": "c", "content": "1999"}]}],
+	"unmodeled": {"nested": [1, 2.50, "x"]}}`
+
+// newResourceTestServer returns a service and router for GET /api/resource/:id backed
+// by a fake Sierra that has bib 1001 and no items
+func newResourceTestServer(t *testing.T) (*ServiceContext, *gin.Engine) {
+	sierra := newFakeSierra(t)
+	sierra.handle("bibs/1001", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(rawTestBib))
+	})
+	sierra.handleJSON("items", http.StatusOK, JMRLItemResult{Entries: []JMRLItem{}})
+	svc := newTestService(t, sierra)
+	router := gin.New()
+	router.GET("/api/resource/:id", svc.authMiddleware, svc.getResource)
+	return svc, router
+}
+
+func TestRawBibIsStaffOnly(t *testing.T) {
+	_, router := newResourceTestServer(t)
+	var want bytes.Buffer
+	json.Compact(&want, []byte(rawTestBib))
+	tests := []struct {
+		name    string
+		role    v4jwt.RoleEnum
+		query   string
+		wantRaw bool
+	}{
+		{"guest", v4jwt.Guest, "?raw=true", false},
+		{"patron", v4jwt.User, "?raw=true", false},
+		{"staff", v4jwt.Staff, "?raw=true", true},
+		{"admin", v4jwt.Admin, "?raw=true", true},
+		{"staff without raw", v4jwt.Staff, "", false},
+		{"staff raw false", v4jwt.Staff, "?raw=false", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/resource/1001"+tc.query, nil)
+			req.Header.Set("Authorization", "Bearer "+mintTestToken(t, tc.role))
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var resp struct {
+				Fields []json.RawMessage `json:"fields"`
+				Raw    json.RawMessage   `json:"raw"`
+			}
+			decodeTestJSON(t, rec, &resp)
+			if len(resp.Fields) == 0 {
+				t.Errorf("no fields returned")
+			}
+			if tc.wantRaw == false {
+				if resp.Raw != nil {
+					t.Errorf("raw bib returned to %s: %s", tc.name, resp.Raw)
+				}
+				return
+			}
+			var got bytes.Buffer
+			json.Compact(&got, resp.Raw)
+			if got.String() != want.String() {
+				t.Errorf("raw = %s, want %s", got.String(), want.String())
+			}
+		})
+	}
+}
+
+func TestResourceRequiresToken(t *testing.T) {
+	_, router := newResourceTestServer(t)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/resource/1001?raw=true", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+}
+
+// otfBibs are on-the-fly bibs as Sierra returns them for ILL and temporary items
+const otfBibs = `[
+{"id":"3400112","title":"The overstory","author":"Powers, Richard","bibLevel":{"code":"-","value":"---"},
+ "materialType":{"code":"a","value":"BOOK"},"varFields":[{"fieldTag":"t","content":"The overstory"},{"fieldTag":"a","content":"Powers, Richard"}],
+ "fixedFields":{"31":{"label":"BCODE3","value":"-"}}},
+{"id":"3400113","title":"","author":"","bibLevel":{"code":"-","value":"---"},"materialType":{"code":"a","value":"BOOK"},"varFields":[]},
+{"id":"3400114","title":"ILL: Braiding sweetgrass","author":"Kimmerer, Robin Wall","bibLevel":{"code":"m","value":"MONOGRAPH"},
+ "materialType":{"code":"a","value":"BOOK"},"varFields":[
+  {"marcTag":"100","subfields":[{"tag":"a","content":"Kimmerer, Robin Wall."}]},
+  {"marcTag":"245","subfields":[{"tag":"a","content":"ILL: Braiding sweetgrass"}]},
+  {"marcTag":"500","subfields":[{"tag":"a","content":"Interlibrary loan."}]}],
+ "fixedFields":{"31":{"label":"BCODE3","value":"o"}}}
+]`
+
+func TestBriefRecords(t *testing.T) {
+	var bibs []JMRLBib
+	if err := json.Unmarshal([]byte(otfBibs), &bibs); err != nil {
+		t.Fatalf("unable to decode on-the-fly bibs: %s", err.Error())
+	}
+	full := testBib("1001", "A full record")
+	full.BibLevel = JMRLCodeValue{Code: "m", Value: "MONOGRAPH"}
+	full.FixedFields = map[string]JMRLFixedField{sierraBCode3Field: {Label: "BCODE3", Value: "-"}}
+
+	tests := []struct {
+		name       string
+		briefCodes string
+		bib        JMRLBib
+		brief      bool
+		title      string
+	}{
+		{"no MARC title", "", bibs[0], true, "The overstory"},
+		{"no title at all", "", bibs[1], true, "3400113"},
+		{"full MARC without codes", "", bibs[2], false, "ILL: Braiding sweetgrass"},
+		{"BCODE3 code", "o", bibs[2], true, "ILL: Braiding sweetgrass"},
+		{"BCODE3 code case", " O ", bibs[2], true, "ILL: Braiding sweetgrass"},
+		{"bib level code", "m", bibs[2], true, "ILL: Braiding sweetgrass"},
+		{"full record", "o", full, false, "A full record"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newTestConfig("http://sierra.invalid" + fakeSierraBase)
+			cfg.BriefCodes = tc.briefCodes
+			svc := newTestServiceWithConfig(t, cfg)
+			bib := tc.bib
+			if got := svc.isBriefRecord(&bib); got != tc.brief {
+				t.Errorf("isBriefRecord = %t, want %t", got, tc.brief)
+			}
+			fields := svc.getResultFields(&bib, fieldOptions{View: viewBrief, Language: "es", Localizer: testLocalizer(svc, "es")})
+			if got := fieldValues(fields, "title"); len(got) != 1 || got[0] != tc.title {
+				t.Errorf("title = %v, want %q", got, tc.title)
+			}
+			if got := fieldValues(fields, "title_collation_key"); len(got) != 1 || got[0] == "" {
+				t.Errorf("title_collation_key = %v, want a sort key", got)
+			}
+			notes := fieldValues(fields, "brief_record_note")
+			if tc.brief && (len(notes) != 1 || notes[0] != "Registro breve — detalles no disponibles") {
+				t.Errorf("brief_record_note = %v, want the Spanish note", notes)
+			}
+			if tc.brief == false && len(notes) != 0 {
+				t.Errorf("brief_record_note = %v, want none", notes)
+			}
+		})
+	}
+}
+
+// relevanceField returns the relevance field of the fields, if any
+func relevanceField(fields []v4api.RecordField) (v4api.RecordField, bool) {
+	for _, f := range fields {
+		if f.Name == "relevance" {
+			return f, true
+		}
+	}
+	return v4api.RecordField{}, false
+}
+
+func TestRelevanceField(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "fields.toml")
+	writeOverrides(t, filename, "[fields.relevance]\nvisibility = \"hidden\"\n")
+	tests := []struct {
+		name       string
+		relevance  float32
+		lang       string
+		suppress   string
+		overrides  string
+		wantValue  string
+		wantLabel  string
+		visibility string
+	}{
+		{"english", 12.5, "en-US", "", "", "12.5", "Relevance", "detailed"},
+		{"spanish", 3.25, "es", "", "", "3.25", "Relevancia", "detailed"},
+		{"no relevance", 0, "en-US", "", "", "", "", ""},
+		{"suppressed", 12.5, "en-US", "relevance", "", "", "", ""},
+		{"overridden", 12.5, "en-US", "", filename, "12.5", "Relevance", "hidden"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newTestConfig("http://sierra.invalid" + fakeSierraBase)
+			cfg.SuppressFields = tc.suppress
+			cfg.FieldCfg = tc.overrides
+			svc := newTestServiceWithConfig(t, cfg)
+			if err := svc.reloadFieldOverrides(); err != nil {
+				t.Fatalf("reloadFieldOverrides failed: %s", err.Error())
+			}
+			bib := testBib("1001", "Cats")
+			fields := svc.getResultFields(&bib, fieldOptions{View: viewBrief, Language: tc.lang,
+				Localizer: testLocalizer(svc, tc.lang), Relevance: tc.relevance})
+			f, found := relevanceField(fields)
+			if found != (tc.wantValue != "") {
+				t.Fatalf("relevance field present %t, want %t", found, tc.wantValue != "")
+			}
+			if found && (f.Value != tc.wantValue || f.Label != tc.wantLabel || f.Visibility != tc.visibility) {
+				t.Errorf("relevance = %q %q %q, want %q %q %q", f.Value, f.Label, f.Visibility, tc.wantValue, tc.wantLabel, tc.visibility)
+			}
+		})
+	}
+}
+
+func TestSearchRelevance(t *testing.T) {
+	sierra := newFakeSierra(t)
+	result := searchResult(2, testBib("1001", "Cats"), testBib("1002", "Dogs"))
+	result.Entries[0].Relevance = 12.5
+	result.Entries[1].Relevance = 0
+	sierra.handleJSON("bibs/search", http.StatusOK, result)
+	router := newRouter(newTestService(t, sierra))
+
+	var resp v4api.PoolResult
+	decodeTestJSON(t, apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"keyword: {pets}","pagination":{"rows":20}}`), &resp)
+	want := map[string]string{"1001": "12.5", "1002": ""}
+	for _, group := range resp.Groups {
+		for _, record := range group.Records {
+			id := fieldValues(record.Fields, "id")
+			if len(id) != 1 {
+				t.Fatalf("record has no id: %v", record.Fields)
+			}
+			f, _ := relevanceField(record.Fields)
+			if f.Value != want[id[0]] {
+				t.Errorf("record %s relevance = %q, want %q", id[0], f.Value, want[id[0]])
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sierraLanguageIndex is the Sierra text search index for the bib language
+const sierraLanguageIndex = "g"
+
+// filterLanguage is the V4 facet ID for the language filter
+const filterLanguage = "FilterLanguage"
+
+// sierraLanguage is a MARC language code used by Sierra with its display name and ISO 639-1 code
+type sierraLanguage struct {
+	Code string
+	ISO  string
+	Name string
+}
+
+// sierraLanguages are the most common languages in the JMRL catalog
+var sierraLanguages = []sierraLanguage{
+	{Code: "eng", ISO: "en", Name: "English"},
+	{Code: "spa", ISO: "es", Name: "Spanish"},
+	{Code: "fre", ISO: "fr", Name: "French"},
+	{Code: "ger", ISO: "de", Name: "German"},
+	{Code: "ita", ISO: "it", Name: "Italian"},
+	{Code: "por", ISO: "pt", Name: "Portuguese"},
+	{Code: "chi", ISO: "zh", Name: "Chinese"},
+	{Code: "jpn", ISO: "ja", Name: "Japanese"},
+	{Code: "kor", ISO: "ko", Name: "Korean"},
+	{Code: "vie", ISO: "vi", Name: "Vietnamese"},
+	{Code: "ara", ISO: "ar", Name: "Arabic"},
+	{Code: "rus", ISO: "ru", Name: "Russian"},
+	{Code: "hin", ISO: "hi", Name: "Hindi"},
+	{Code: "lat", ISO: "la", Name: "Latin"},
+	{Code: "mul", Name: "Multiple languages"},
+	{Code: "und", Name: "Undetermined"},
+}
+
+// unknownLanguageRestriction is the Sierra restriction for the bibs the language facet
+// counts as unknown: those without a language listed in sierraLanguages, or undetermined.
+// EX: (* AND NOT (g:eng OR g:spa ... OR g:mul))
+func unknownLanguageRestriction() string {
+	known := make([]string, 0, len(sierraLanguages))
+	for _, lang := range sierraLanguages {
+		if lang.Code != languageUnknown {
+			known = append(known, sierraLanguageIndex+":"+lang.Code)
+		}
+	}
+	return fmt.Sprintf("(* AND NOT (%s))", strings.Join(known, " OR "))
+}
+
+// lookupLanguage finds a language by Sierra code, ISO 639-1 code or name (case insensitive)
+func lookupLanguage(value string) (sierraLanguage, bool) {
+	val := strings.ToLower(strings.TrimSpace(value))
+	for _, lang := range sierraLanguages {
+		if val == lang.Code || (lang.ISO != "" && val == lang.ISO) || val == strings.ToLower(lang.Name) {
+			return lang, true
+		}
+	}
+	return sierraLanguage{}, false
+}
+
+// languageDisplay returns the display name for a Sierra language, preferring the
+// mapping table and falling back to the Sierra supplied name
+func languageDisplay(lang JMRLCodeValue) string {
+	if known, ok := lookupLanguage(lang.Code); ok {
+		return known.Name
+	}
+	if lang.Name != "" {
+		return lang.Name
+	}
+	return lang.Value
+}
+
+// marcLanguageCode returns the MARC (ISO 639-2/B) code of the language of the work: the
+// Sierra bib language (from 008) or the first 041$a code. Blank, malformed and
+// undetermined (und) codes are treated as unknown and empty is returned.
+func marcLanguageCode(bib *JMRLBib) string {
+	valid := func(code string) bool {
+		if len(code) != 3 || code == "und" {
+			return false
+		}
+		for _, r := range code {
+			if r < 'a' || r > 'z' {
+				return false
+			}
+		}
+		return true
+	}
+	if code := strings.ToLower(strings.TrimSpace(bib.Language.Code)); valid(code) {
+		return code
+	}
+	// the first 041$a is the primary language; getVarField would return the last of a
+	// repeated $a
+	for _, field := range bib.VarFields {
+		if field.MarcTag != "041" {
+			continue
+		}
+		for _, sub := range field.Subfields {
+			if sub.Tag != "a" {
+				continue
+			}
+			// old records run the codes together: spaeng
+			if code := strings.ToLower(strings.TrimSpace(sub.Content)); len(code) >= 3 && valid(code[:3]) {
+				return code[:3]
+			}
+			return ""
+		}
+	}
+	return ""
+}
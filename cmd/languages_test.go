@@ -0,0 +1,179 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+func TestLookupLanguage(t *testing.T) {
+	tests := []struct {
+		value string
+		code  string
+		ok    bool
+	}{
+		{"Spanish", "spa", true},
+		{" spanish ", "spa", true},
+		{"spa", "spa", true},
+		{"ES", "spa", true},
+		{"fre", "fre", true},
+		{"fr", "fre", true},
+		{"Multiple languages", "mul", true},
+		{"Klingon", "", false},
+		{"", "", false},
+	}
+	for _, tc := range tests {
+		lang, ok := lookupLanguage(tc.value)
+		if ok != tc.ok || lang.Code != tc.code {
+			t.Errorf("lookupLanguage(%q) = %q %t, want %q %t", tc.value, lang.Code, ok, tc.code, tc.ok)
+		}
+	}
+}
+
+func TestLanguageDisplay(t *testing.T) {
+	tests := []struct {
+		lang JMRLCodeValue
+		want string
+	}{
+		{JMRLCodeValue{Code: "spa", Name: "Spanish; Castilian"}, "Spanish"},
+		{JMRLCodeValue{Code: "haw", Name: "Hawaiian"}, "Hawaiian"},
+		{JMRLCodeValue{Code: "xxx", Value: "Unknown"}, "Unknown"},
+	}
+	for _, tc := range tests {
+		if got := languageDisplay(tc.lang); got != tc.want {
+			t.Errorf("languageDisplay(%+v) = %q, want %q", tc.lang, got, tc.want)
+		}
+	}
+}
+
+func TestMarcLanguageCode(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		f041 string
+		want string
+	}{
+		{"bib language", "spa", "", "spa"},
+		{"upper case", "SPA", "", "spa"},
+		{"undetermined uses 041", "und", "fre", "fre"},
+		{"blank uses 041", "", "spaeng", "spa"},
+		{"malformed", "e1", "", ""},
+		{"undetermined everywhere", "und", "und", ""},
+		{"repeated 041", "", "spa|eng", "spa"},
+		{"041 primary undetermined", "", "und|eng", ""},
+	}
+	for _, tc := range tests {
+		bib := testBib("1001", "Title")
+		bib.Language = JMRLCodeValue{Code: tc.code}
+		if tc.f041 != "" {
+			field := JMRLVarFields{MarcTag: "041"}
+			for _, code := range strings.Split(tc.f041, "|") {
+				field.Subfields = append(field.Subfields, JMRLSubfield{Tag: "a", Content: code})
+			}
+			bib.VarFields = append(bib.VarFields, field)
+		}
+		if got := marcLanguageCode(&bib); got != tc.want {
+			t.Errorf("%s: marcLanguageCode = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLanguageFilter(t *testing.T) {
+	tests := []struct {
+		values   []string
+		want     string
+		warnings int
+	}{
+		{[]string{"Spanish"}, "(g:spa)", 0},
+		{[]string{"es", "English"}, "(g:spa OR g:eng)", 0},
+		{[]string{"spa", "Spanish"}, "(g:spa)", 0},
+		{[]string{"Klingon"}, "", 1},
+	}
+	for _, tc := range tests {
+		pairs := make([]string, 0)
+		for _, v := range tc.values {
+			pairs = append(pairs, filterLanguage, v)
+		}
+		got, warnings := translateFilters(testFilters(pairs...), nil)
+		if got != tc.want || len(warnings) != tc.warnings {
+			t.Errorf("language filter %v = %q %v, want %q with %d warnings", tc.values, got, warnings, tc.want, tc.warnings)
+		}
+	}
+}
+
+// the language field uses the same names the filter accepts
+func TestLanguageFieldMatchesFilter(t *testing.T) {
+	svc := newTestService(t, nil)
+	bib := testBib("1001", "Title")
+	bib.Language = JMRLCodeValue{Code: "spa", Name: "Spanish; Castilian"}
+	fields := svc.getResultFields(&bib, fieldOptions{View: viewFull, Language: "en-US", Localizer: testLocalizer(svc, "en-US")})
+	got := fieldValues(fields, "language")
+	if reflect.DeepEqual(got, []string{"Spanish"}) == false {
+		t.Fatalf("language = %q, want [Spanish]", got)
+	}
+	if lang, ok := lookupLanguage(got[0]); ok == false || lang.Code != "spa" {
+		t.Errorf("language value %q does not select spa", got[0])
+	}
+}
+
+// the language_code field contract agreed with the client team: a hidden field next to
+// the display name, with the lower case MARC code, left out when the language is unknown
+func TestLanguageCodeField(t *testing.T) {
+	svc := newTestService(t, nil)
+	tests := []struct {
+		name        string
+		lang        JMRLCodeValue
+		locale      string
+		wantName    string
+		wantCode    string
+		wantPresent bool
+	}{
+		{"english", JMRLCodeValue{Code: "eng", Name: "English"}, "en-US", "English", "eng", true},
+		{"spanish in spanish", JMRLCodeValue{Code: "spa", Name: "Spanish"}, "es", "Spanish", "spa", true},
+		{"upper case code", JMRLCodeValue{Code: "FRE", Name: "French"}, "en-US", "French", "fre", true},
+		{"undetermined", JMRLCodeValue{Code: "und", Name: "Undetermined"}, "en-US", "Undetermined", "", false},
+		{"blank", JMRLCodeValue{}, "en-US", "", "", false},
+	}
+	for _, tc := range tests {
+		for _, view := range []string{viewBrief, viewFull} {
+			bib := testBib("1001", "Title")
+			bib.Language = tc.lang
+			fields := svc.getResultFields(&bib, fieldOptions{View: view, Language: tc.locale, Localizer: testLocalizer(svc, tc.locale)})
+			var code *v4api.RecordField
+			for i := range fields {
+				if fields[i].Name == "language_code" {
+					code = &fields[i]
+				}
+			}
+			if (code != nil) != tc.wantPresent {
+				t.Errorf("%s %s: language_code present = %t, want %t", tc.name, view, code != nil, tc.wantPresent)
+				continue
+			}
+			if code != nil && (code.Value != tc.wantCode || code.Type != "language_code" || code.Visibility != "hidden" || code.Label != "") {
+				t.Errorf("%s %s: language_code = %+v, want hidden %q", tc.name, view, *code, tc.wantCode)
+			}
+			if tc.wantName != "" {
+				if got := fieldValues(fields, "language"); reflect.DeepEqual(got, []string{tc.wantName}) == false {
+					t.Errorf("%s %s: language = %q, want [%s]", tc.name, view, got, tc.wantName)
+				}
+			}
+		}
+	}
+}
+
+// the unknown language restriction excludes every listed language but undetermined, so it
+// matches what the language facet counts as unknown
+func TestUnknownLanguageRestriction(t *testing.T) {
+	got := unknownLanguageRestriction()
+	if strings.HasPrefix(got, "(* AND NOT (g:eng OR g:spa OR ") == false || strings.HasSuffix(got, " OR g:mul))") == false {
+		t.Errorf("restriction = %s", got)
+	}
+	if strings.Contains(got, "g:und") {
+		t.Errorf("restriction %s excludes undetermined bibs, which are counted as unknown", got)
+	}
+	if n := strings.Count(got, "g:"); n != len(sierraLanguages)-1 {
+		t.Errorf("restriction excludes %d languages, want %d", n, len(sierraLanguages)-1)
+	}
+}
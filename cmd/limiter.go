@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// sierraLimiter bounds the number of concurrent requests made to Sierra. Searches wait
+// for a slot; type-ahead suggestions never wait and may only use part of the capacity,
+// so bursts of suggestions can not starve real searches. Time spent waiting for a slot is
+// recorded in the sierra_slot_wait histogram, separately from Sierra latency.
+type sierraLimiter struct {
+	slots   chan struct{}
+	suggest chan struct{}
+	wait    time.Duration
+	metrics *ServiceMetrics
+}
+
+// newSierraLimiter creates a limiter allowing max concurrent Sierra requests. Suggestions
+// are limited to half of that (at least one). Metrics may be nil.
+func newSierraLimiter(max int, wait time.Duration, metrics *ServiceMetrics) *sierraLimiter {
+	if max < 1 {
+		max = 1
+	}
+	suggestMax := max / 2
+	if suggestMax < 1 {
+		suggestMax = 1
+	}
+	return &sierraLimiter{slots: make(chan struct{}, max), suggest: make(chan struct{}, suggestMax), wait: wait,
+		metrics: metrics}
+}
+
+// acquire waits up to the limiter wait time for a slot and returns the time spent
+// waiting. False is returned on timeout or when ctx ends first
+func (l *sierraLimiter) acquire(ctx context.Context) (time.Duration, bool) {
+	start := time.Now()
+	ok := l.waitForSlot(ctx)
+	wait := time.Since(start)
+	if l.metrics != nil {
+		l.metrics.Observe("sierra_slot_wait", wait)
+	}
+	return wait, ok
+}
+
+// waitForSlot takes a slot, waiting up to the limiter wait time
+func (l *sierraLimiter) waitForSlot(ctx context.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release frees a slot obtained from acquire
+func (l *sierraLimiter) release() {
+	<-l.slots
+}
+
+// tryAcquireSuggest takes a suggestion slot and a shared slot without waiting.
+// False is returned if either is unavailable.
+func (l *sierraLimiter) tryAcquireSuggest() bool {
+	select {
+	case l.suggest <- struct{}{}:
+	default:
+		return false
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		<-l.suggest
+		return false
+	}
+}
+
+// releaseSuggest frees the slots obtained from tryAcquireSuggest
+func (l *sierraLimiter) releaseSuggest() {
+	<-l.slots
+	<-l.suggest
+}
+
+// inUse returns the number of Sierra requests currently in flight
+func (l *sierraLimiter) inUse() int {
+	return len(l.slots)
+}
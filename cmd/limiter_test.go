@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+// holdSlots fills every slot of the limiter and frees them after the delay
+func holdSlots(l *sierraLimiter, delay time.Duration) {
+	for i := 0; i < cap(l.slots); i++ {
+		l.slots <- struct{}{}
+	}
+	time.AfterFunc(delay, func() {
+		for i := 0; i < cap(l.slots); i++ {
+			l.release()
+		}
+	})
+}
+
+func TestLimiterSlotWait(t *testing.T) {
+	metrics := NewServiceMetrics()
+	l := newSierraLimiter(2, time.Second, metrics)
+	if wait, ok := l.acquire(context.Background()); ok == false || wait > 5*time.Millisecond {
+		t.Fatalf("idle acquire = %s %t, want no wait", wait, ok)
+	}
+	l.release()
+
+	holdSlots(l, 30*time.Millisecond)
+	wait, ok := l.acquire(context.Background())
+	if ok == false || wait < 30*time.Millisecond {
+		t.Errorf("saturated acquire = %s %t, want a wait of at least 30ms", wait, ok)
+	}
+	l.release()
+
+	h := metrics.Histograms()["sierra_slot_wait"]
+	if h.Count != 2 || h.SumMS < 30 || h.Buckets["le_5"] != 1 {
+		t.Errorf("sierra_slot_wait = %+v, want 2 observations, one under 5ms and 30ms or more in total", h)
+	}
+}
+
+func TestLimiterSlotTimeouts(t *testing.T) {
+	l := newSierraLimiter(1, 20*time.Millisecond, nil)
+	holdSlots(l, time.Second)
+	if wait, ok := l.acquire(context.Background()); ok || wait < 20*time.Millisecond {
+		t.Errorf("acquire = %s %t, want a timeout after 20ms", wait, ok)
+	}
+
+	l = newSierraLimiter(1, time.Second, nil)
+	holdSlots(l, time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if wait, ok := l.acquire(ctx); ok || wait >= time.Second {
+		t.Errorf("acquire = %s %t, want the context to end the wait", wait, ok)
+	}
+}
+
+func TestLimiterSuggestionsShareCapacity(t *testing.T) {
+	l := newSierraLimiter(4, time.Second, nil)
+	if l.tryAcquireSuggest() == false || l.tryAcquireSuggest() == false {
+		t.Fatalf("suggestions could not take half the slots")
+	}
+	if l.tryAcquireSuggest() {
+		t.Errorf("suggestions took more than half the slots")
+	}
+	if _, ok := l.acquire(context.Background()); ok == false || l.inUse() != 3 {
+		t.Errorf("search could not use a slot next to suggestions, %d in use", l.inUse())
+	}
+	l.releaseSuggest()
+	l.releaseSuggest()
+	l.release()
+	if l.inUse() != 0 {
+		t.Errorf("%d slots in use after release, want 0", l.inUse())
+	}
+}
+
+// a search that waits for a saturated limiter reports the wait apart from Sierra time, in
+// the trace, the histograms and the slow request log
+func TestSearchReportsSlotWait(t *testing.T) {
+	sierra := scriptedSierra(t, http.StatusOK)
+	cfg := newTestConfig(sierra.apiURL())
+	cfg.MaxConcurrent = 1
+	cfg.SlowMS = 1
+	svc := newTestServiceWithConfig(t, cfg)
+	router := newRouter(svc)
+
+	// the slot is freed 50ms after being taken; the search starts a little later
+	holdSlots(svc.Limiter, 50*time.Millisecond)
+	var resp searchTrace
+	logged := captureLog(func() {
+		rec := apiRequestAs(t, router, v4jwt.Staff, http.MethodPost, "/api/search?debug=true", `{"query":"keyword: {cats}"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		decodeTestJSON(t, rec, &resp)
+	})
+	if resp.Debug.Trace.WaitMS < 30 {
+		t.Errorf("trace wait_ms = %d, want at least 30", resp.Debug.Trace.WaitMS)
+	}
+	if len(resp.Debug.Trace.Attempts) != 1 || resp.Debug.Trace.Attempts[0].WaitMS < 30 {
+		t.Errorf("attempts = %+v, want one that waited at least 30ms", resp.Debug.Trace.Attempts)
+	}
+	histograms := svc.Metrics.Histograms()
+	for _, name := range []string{"sierra_slot_wait", "sierra_upstream", "request_processing"} {
+		if histograms[name].Count != 1 {
+			t.Errorf("%s has %d observations, want 1", name, histograms[name].Count)
+		}
+	}
+	if histograms["sierra_slot_wait"].SumMS < 30 {
+		t.Errorf("sierra_slot_wait sum = %dms, want at least 30", histograms["sierra_slot_wait"].SumMS)
+	}
+	if strings.Contains(logged, "WARNING: slow search") == false || strings.Contains(logged, "ms waiting, ") == false {
+		t.Errorf("log has no slow search breakdown:\n%s", logged)
+	}
+}
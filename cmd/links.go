@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sierraMaxOffset is the largest result offset Sierra's bib search will serve; results
+// beyond this window can not be paged to
+const sierraMaxOffset = 10000
+
+// paginationLinks builds an RFC 8288 Link header value for a search result page. Since
+// search is a POST, each link targets the search endpoint and its start / rows
+// parameters describe the pagination block to send in the request body.
+func paginationLinks(searchPath string, start int, rows int, returned int, total int) string {
+	if rows <= 0 {
+		return ""
+	}
+	// pages can only be requested within the Sierra paging window
+	reachable := total
+	if reachable > sierraMaxOffset {
+		reachable = sierraMaxOffset
+	}
+	link := func(rel string, linkStart int) string {
+		return fmt.Sprintf(`<%s>; rel="%s"; start="%d"; rows="%d"`, searchPath, rel, linkStart, rows)
+	}
+
+	links := make([]string, 0, 4)
+	links = append(links, link("first", 0))
+	if start > 0 {
+		prev := start - rows
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, link("prev", prev))
+	}
+	if returned > 0 && start+returned < reachable {
+		links = append(links, link("next", start+returned))
+	}
+	if reachable > 0 {
+		last := ((reachable - 1) / rows) * rows
+		links = append(links, link("last", last))
+	}
+	return strings.Join(links, ", ")
+}
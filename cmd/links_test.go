@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+func TestPaginationLinks(t *testing.T) {
+	const first = `</api/search>; rel="first"; start="0"; rows="20"`
+	tests := []struct {
+		name     string
+		start    int
+		rows     int
+		returned int
+		total    int
+		want     []string
+	}{
+		{"first page", 0, 20, 20, 95, []string{first,
+			`</api/search>; rel="next"; start="20"; rows="20"`,
+			`</api/search>; rel="last"; start="80"; rows="20"`}},
+		{"middle page", 40, 20, 20, 95, []string{first,
+			`</api/search>; rel="prev"; start="20"; rows="20"`,
+			`</api/search>; rel="next"; start="60"; rows="20"`,
+			`</api/search>; rel="last"; start="80"; rows="20"`}},
+		{"final partial page", 80, 20, 15, 95, []string{first,
+			`</api/search>; rel="prev"; start="60"; rows="20"`,
+			`</api/search>; rel="last"; start="80"; rows="20"`}},
+		{"exact final page", 80, 20, 20, 100, []string{first,
+			`</api/search>; rel="prev"; start="60"; rows="20"`,
+			`</api/search>; rel="last"; start="80"; rows="20"`}},
+		{"unaligned start", 5, 20, 20, 95, []string{first,
+			`</api/search>; rel="prev"; start="0"; rows="20"`,
+			`</api/search>; rel="next"; start="25"; rows="20"`,
+			`</api/search>; rel="last"; start="80"; rows="20"`}},
+		{"single page", 0, 20, 3, 3, []string{first,
+			`</api/search>; rel="last"; start="0"; rows="20"`}},
+		{"no results", 0, 20, 0, 0, []string{first}},
+		{"Sierra paging window", 9980, 20, 20, 250000, []string{first,
+			`</api/search>; rel="prev"; start="9960"; rows="20"`,
+			`</api/search>; rel="last"; start="9980"; rows="20"`}},
+		{"before the window end", 9960, 20, 20, 250000, []string{first,
+			`</api/search>; rel="prev"; start="9940"; rows="20"`,
+			`</api/search>; rel="next"; start="9980"; rows="20"`,
+			`</api/search>; rel="last"; start="9980"; rows="20"`}},
+		{"no rows", 0, 0, 0, 10, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			want := strings.Join(tc.want, ", ")
+			if got := paginationLinks("/api/search", tc.start, tc.rows, tc.returned, tc.total); got != want {
+				t.Errorf("paginationLinks =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestSearchLinkHeader(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(45, testBib("1001", "One"), testBib("1002", "Two")))
+	svc := newTestService(t, sierra)
+	router := gin.New()
+	router.POST("/api/search", svc.authMiddleware, svc.search)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/search", strings.NewReader(`{"query":"keyword: {cats}","pagination":{"start":2,"rows":2}}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.User))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	want := strings.Join([]string{
+		`</api/search>; rel="first"; start="0"; rows="2"`,
+		`</api/search>; rel="prev"; start="0"; rows="2"`,
+		`</api/search>; rel="next"; start="4"; rows="2"`,
+		`</api/search>; rel="last"; start="44"; rows="2"`}, ", ")
+	if got := rec.Header().Get("Link"); got != want {
+		t.Errorf("Link =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	jmrli18n "github.com/uvalib/virgo4-pool-jmrl-ws/i18n"
+	"golang.org/x/text/language"
+)
+
+// newI18NBundle creates the message bundle. The embedded English messages are always
+// loaded first, then any active.*.toml files found in dir are added on top. Problems
+// with the external files are logged and skipped; they never prevent startup.
+// The paths of the external files that were loaded are returned.
+func newI18NBundle(dir string) (*i18n.Bundle, []string) {
+	bundle := i18n.NewBundle(language.English)
+	bundle.RegisterUnmarshalFunc("toml", toml.Unmarshal)
+	bundle.MustParseMessageFileBytes(jmrli18n.English, jmrli18n.EnglishFile)
+
+	loaded := make([]string, 0)
+	if dir == "" {
+		return bundle, loaded
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "active.*.toml"))
+	if err != nil || len(files) == 0 {
+		log.Printf("WARNING: no message files found in %s; using embedded English messages only", dir)
+		return bundle, loaded
+	}
+	sort.Strings(files)
+	for _, msgFile := range files {
+		data, err := os.ReadFile(msgFile)
+		if err != nil {
+			log.Printf("WARNING: unable to read message file %s: %s", msgFile, err.Error())
+			continue
+		}
+		if _, err := bundle.ParseMessageFileBytes(data, msgFile); err != nil {
+			log.Printf("WARNING: skipping malformed message file %s: %s", msgFile, err.Error())
+			continue
+		}
+		loaded = append(loaded, msgFile)
+	}
+	return bundle, loaded
+}
+
+// defaultContentLanguage is used when Accept-Language is missing, malformed or names
+// no language that has messages
+const defaultContentLanguage = "en-US"
+
+// negotiateLanguage picks the best language with loaded messages for a full
+// Accept-Language header, honoring q-values. EX: fr-CH, fr;q=0.9, es;q=0.8 => es
+func (svc *ServiceContext) negotiateLanguage(acceptLanguage string) string {
+	tags := svc.I18NBundle.LanguageTags()
+	if len(tags) == 0 {
+		return defaultContentLanguage
+	}
+	requested, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(requested) == 0 {
+		if err != nil {
+			log.Printf("WARNING: malformed Accept-Language [%s]: %s", acceptLanguage, err.Error())
+		}
+		return defaultContentLanguage
+	}
+	_, idx, confidence := language.NewMatcher(tags).Match(requested...)
+	if confidence == language.No {
+		return defaultContentLanguage
+	}
+	return tags[idx].String()
+}
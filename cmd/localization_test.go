@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+func TestNewI18NBundle(t *testing.T) {
+	dir := t.TempDir()
+	spanish, err := os.ReadFile("../i18n/active.es.toml")
+	if err != nil {
+		t.Fatalf("unable to read Spanish messages: %s", err.Error())
+	}
+	os.WriteFile(filepath.Join(dir, "active.es.toml"), spanish, 0644)
+	os.WriteFile(filepath.Join(dir, "active.fr.toml"), []byte("[PoolName\nother = "), 0644)
+
+	tests := []struct {
+		name   string
+		dir    string
+		loaded int
+		tags   int
+	}{
+		{"no directory", "", 0, 1},
+		{"missing directory", filepath.Join(dir, "missing"), 0, 1},
+		{"repository messages", "../i18n", 2, 2},
+		{"malformed file skipped", dir, 1, 2},
+	}
+	for _, tc := range tests {
+		bundle, loaded := newI18NBundle(tc.dir)
+		if len(loaded) != tc.loaded || len(bundle.LanguageTags()) != tc.tags {
+			t.Errorf("%s: loaded %v with tags %v, want %d files and %d tags", tc.name, loaded, bundle.LanguageTags(), tc.loaded, tc.tags)
+		}
+	}
+}
+
+// with a bad i18n mount the service still starts and answers in English
+func TestEmbeddedMessagesOnly(t *testing.T) {
+	cfg := newTestConfig("http://sierra.invalid" + fakeSierraBase)
+	cfg.I18NDir = filepath.Join(t.TempDir(), "missing")
+	svc := newTestServiceWithConfig(t, cfg)
+
+	c, rec := newTestContext(http.MethodGet, "/identify", nil)
+	c.Request.Header.Set("Accept-Language", "es")
+	svc.identifyHandler(c)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("identify = %d: %s", rec.Code, rec.Body.String())
+	}
+	var identity v4api.PoolIdentity
+	decodeTestJSON(t, rec, &identity)
+	if identity.Name != "JMRL Public Library" || identity.Description == "" {
+		t.Errorf("identify name %q description %q are not the English messages", identity.Name, identity.Description)
+	}
+	if len(identity.SortOptions) == 0 || identity.SortOptions[0].Label == "" {
+		t.Errorf("identify sort options are not localized: %+v", identity.SortOptions)
+	}
+
+	bib := testBib("1001", "Cats")
+	bib.Available = true
+	fields := svc.getResultFields(&bib, fieldOptions{View: viewFull, Language: "es", Localizer: testLocalizer(svc, "es")})
+	if got := fieldValues(fields, "title"); len(got) != 1 || got[0] != "Cats" {
+		t.Errorf("title = %v", got)
+	}
+	if lang := svc.negotiateLanguage("es"); lang != defaultContentLanguage {
+		t.Errorf("negotiateLanguage(es) = %s with English only, want %s", lang, defaultContentLanguage)
+	}
+}
+
+func TestNegotiateLanguage(t *testing.T) {
+	svc := newTestService(t, nil)
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", defaultContentLanguage},
+		{"es", "es"},
+		{"es-MX", "es"},
+		{"fr-CH, fr;q=0.9, es;q=0.8", "es"},
+		{"es;q=0.5, en;q=0.9", "en"},
+		{"de", defaultContentLanguage},
+		{"!!not a language", defaultContentLanguage},
+	}
+	for _, tc := range tests {
+		if got := svc.negotiateLanguage(tc.header); got != tc.want {
+			t.Errorf("negotiateLanguage(%q) = %s, want %s", tc.header, got, tc.want)
+		}
+	}
+}
+
+// identify, search and resource responses use the negotiated language, never a raw header fragment
+func TestHandlersUseNegotiatedLanguage(t *testing.T) {
+	sierra := newFakeSierra(t)
+	bib := testBib("1001", "Cats")
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, bib))
+	sierra.handleJSON("bibs/1001", http.StatusOK, bib)
+	sierra.handleJSON("items", http.StatusOK, JMRLItemResult{})
+	router := newRouter(newTestService(t, sierra))
+	token := mintTestToken(t, v4jwt.User)
+	send := func(method string, target string, body string, lang string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept-Language", lang)
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s %s [%s] status = %d: %s", method, target, lang, rec.Code, rec.Body.String())
+		}
+		return rec
+	}
+
+	tests := []struct {
+		header   string
+		want     string
+		poolName string
+	}{
+		{"fr-CH, fr;q=0.9, es;q=0.8", "es", "Biblioteca Pública JMRL"},
+		{"es-MX", "es", "Biblioteca Pública JMRL"},
+		{"fr-CH, fr;q=0.9, en;q=0.8", "en", "JMRL Public Library"},
+		{"de", defaultContentLanguage, "JMRL Public Library"},
+		{"en;q=nonsense,,", defaultContentLanguage, "JMRL Public Library"},
+	}
+	for _, tc := range tests {
+		rec := send(http.MethodGet, "/identify", "", tc.header)
+		var identity v4api.PoolIdentity
+		decodeTestJSON(t, rec, &identity)
+		if identity.Name != tc.poolName {
+			t.Errorf("[%s] identify name = %q, want %q", tc.header, identity.Name, tc.poolName)
+		}
+		if got := rec.Header().Get("Content-Language"); got != tc.want {
+			t.Errorf("[%s] identify Content-Language = %q, want %q", tc.header, got, tc.want)
+		}
+
+		rec = send(http.MethodPost, "/api/search", `{"query":"keyword: {cats}"}`, tc.header)
+		if got := rec.Header().Get("Content-Language"); got != tc.want {
+			t.Errorf("[%s] search Content-Language = %q, want %q", tc.header, got, tc.want)
+		}
+
+		rec = send(http.MethodGet, "/api/resource/1001", "", tc.header)
+		if got := rec.Header().Get("Content-Language"); got != tc.want {
+			t.Errorf("[%s] resource Content-Language = %q, want %q", tc.header, got, tc.want)
+		}
+		var record v4api.Record
+		decodeTestJSON(t, rec, &record)
+		spanish := len(fieldValues(record.Fields, "title_language")) > 0
+		if spanish != (tc.want == "es") {
+			t.Errorf("[%s] resource title_language present %t, want %t", tc.header, spanish, tc.want == "es")
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+// defaultPlaceholderLocations are the Sierra location codes that do not represent a real
+// branch. E-only records carry "none", and "multi" / "zzzzz" are used for special cases
+const defaultPlaceholderLocations = "none,multi,zzzzz"
+
+// redacted locations (outreach collections at shelters, correctional facilities) are
+// reported with this generic code and name in every response
+const redactedLocationCode = "outreach"
+const redactedLocationName = "JMRL Outreach Collection"
+
+// LocationConfig is the external location configuration file
+type LocationConfig struct {
+	// Redacted location codes have their names replaced with a generic outreach name
+	Redacted []string `toml:"redacted"`
+}
+
+// locationSettings holds the redacted location codes and branch coordinates, which are
+// replaced together when the location files are reloaded
+type locationSettings struct {
+	lock      sync.RWMutex
+	redacted  map[string]bool
+	branchGeo []BranchGeo
+}
+
+// set replaces the redacted location codes and branch coordinates
+func (ls *locationSettings) set(redacted map[string]bool, branchGeo []BranchGeo) {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	ls.redacted = redacted
+	ls.branchGeo = branchGeo
+}
+
+// get returns the current redacted location codes and branch coordinates. Both are
+// replaced rather than modified, so the caller may keep using them after a reload.
+func (ls *locationSettings) get() (map[string]bool, []BranchGeo) {
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+	return ls.redacted, ls.branchGeo
+}
+
+// reloadLocations (re)loads the location configuration and branch coordinate files. On
+// failure the previous settings stay in effect.
+func (svc *ServiceContext) reloadLocations() error {
+	if svc.Config.LocationCfg == "" && svc.Config.BranchGeo == "" {
+		return nil
+	}
+	redacted := make(map[string]bool)
+	if svc.Config.LocationCfg != "" {
+		log.Printf("Load location configuration from %s", svc.Config.LocationCfg)
+		locCfg, err := loadLocationConfig(svc.Config.LocationCfg)
+		if err != nil {
+			log.Printf("ERROR: location configuration rejected, keeping previous values: %s", err.Error())
+			svc.setReloadStatus("locations", false, err.Error())
+			return err
+		}
+		redacted = toCodeSet(locCfg.Redacted)
+	}
+	var branchGeo []BranchGeo
+	if svc.Config.BranchGeo != "" {
+		log.Printf("Load branch locations from %s", svc.Config.BranchGeo)
+		branches, err := loadBranchGeo(svc.Config.BranchGeo)
+		if err != nil {
+			log.Printf("ERROR: branch locations rejected, keeping previous values: %s", err.Error())
+			svc.setReloadStatus("locations", false, err.Error())
+			return err
+		}
+		branchGeo = branches
+	}
+	svc.Locations.set(redacted, branchGeo)
+	if svc.Config.LocationCfg != "" {
+		svc.recordLoadedFile("location_config", svc.Config.LocationCfg)
+	}
+	if svc.Config.BranchGeo != "" {
+		svc.recordLoadedFile("branch_geo", svc.Config.BranchGeo)
+	}
+	msg := fmt.Sprintf("%d redacted locations, %d branch locations loaded", len(redacted), len(branchGeo))
+	log.Printf("Locations reloaded: %s", msg)
+	svc.setReloadStatus("locations", true, msg)
+	svc.setStaticModified()
+	svc.checkLocationCodes()
+	return nil
+}
+
+// loadLocationConfig reads the external location configuration file
+func loadLocationConfig(filename string) (*LocationConfig, error) {
+	var locCfg LocationConfig
+	if _, err := toml.DecodeFile(filename, &locCfg); err != nil {
+		return nil, err
+	}
+	for _, code := range locCfg.Redacted {
+		if strings.TrimSpace(code) == "" {
+			return nil, fmt.Errorf("%s contains an empty redacted location code", filename)
+		}
+	}
+	return &locCfg, nil
+}
+
+// toCodeSet converts a list of location codes into a lowercase lookup set
+func toCodeSet(codes []string) map[string]bool {
+	out := make(map[string]bool)
+	for _, code := range codes {
+		out[strings.ToLower(strings.TrimSpace(code))] = true
+	}
+	return out
+}
+
+// allLocationsRedacted returns true if there are locations and none may be displayed
+func (svc *ServiceContext) allLocationsRedacted(locs []JMRLCodeValue) bool {
+	for _, loc := range locs {
+		if svc.isRedactedLocation(loc.Code) == false {
+			return false
+		}
+	}
+	return len(locs) > 0
+}
+
+// isRedactedLocation returns true if the location code must not be displayed
+func (svc *ServiceContext) isRedactedLocation(code string) bool {
+	redacted, _ := svc.Locations.get()
+	return redacted[strings.ToLower(strings.TrimSpace(code))]
+}
+
+// parsePlaceholderLocations converts a comma separated list of codes into a lookup set
+func parsePlaceholderLocations(codes string) map[string]bool {
+	out := make(map[string]bool)
+	for _, code := range strings.Split(codes, ",") {
+		code = strings.ToLower(strings.TrimSpace(code))
+		if code != "" {
+			out[code] = true
+		}
+	}
+	return out
+}
+
+// isPlaceholderLocation returns true if the location code or name is a placeholder
+func (svc *ServiceContext) isPlaceholderLocation(loc JMRLCodeValue) bool {
+	code := strings.ToLower(strings.TrimSpace(loc.Code))
+	name := strings.ToLower(strings.TrimSpace(loc.Name))
+	return code == "" || svc.PlaceholderLocations[code] || svc.PlaceholderLocations[name]
+}
+
+// normalizeLocations is the single place where bib locations are cleaned up. Placeholders
+// are removed, redacted locations replaced by the generic outreach location, duplicate
+// codes collapsed, and each location gets a display name in Name.
+// Every feature that works with locations must use this rather than bib.Locations directly.
+func (svc *ServiceContext) normalizeLocations(locs []JMRLCodeValue) []JMRLCodeValue {
+	out := make([]JMRLCodeValue, 0)
+	seen := make(map[string]bool)
+	for _, loc := range locs {
+		if svc.isPlaceholderLocation(loc) {
+			continue
+		}
+		code := strings.TrimSpace(loc.Code)
+		if svc.isRedactedLocation(code) {
+			code = redactedLocationCode
+		}
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		out = append(out, JMRLCodeValue{Code: code, Name: svc.locationDisplayName(loc)})
+	}
+	return out
+}
+
+// locationDisplayName returns the human readable name for a location: the Sierra branch
+// list name if known, otherwise the name embedded in the bib
+func (svc *ServiceContext) locationDisplayName(loc JMRLCodeValue) string {
+	if svc.isRedactedLocation(loc.Code) {
+		return redactedLocationName
+	}
+	if name, found := svc.Branches.name(loc.Code); found {
+		return name
+	}
+	name := strings.TrimSpace(loc.Name)
+	if name == "" {
+		name = strings.TrimSpace(loc.Code)
+	}
+	return name
+}
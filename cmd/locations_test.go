@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+func TestNormalizeLocations(t *testing.T) {
+	svc := newTestService(t, nil)
+	tests := []struct {
+		name string
+		locs []JMRLCodeValue
+		want []JMRLCodeValue
+	}{
+		{"e-only", []JMRLCodeValue{{Code: "none", Name: "none"}}, []JMRLCodeValue{}},
+		{"e-only placeholder name", []JMRLCodeValue{{Code: "", Name: "None"}}, []JMRLCodeValue{}},
+		{"physical only", []JMRLCodeValue{{Code: "cnf", Name: "Central Nonfiction"}, {Code: "nrf", Name: "Northside Fiction"}},
+			[]JMRLCodeValue{{Code: "cnf", Name: "Central Nonfiction"}, {Code: "nrf", Name: "Northside Fiction"}}},
+		{"mixed", []JMRLCodeValue{{Code: "none"}, {Code: "cnf", Name: "Central Nonfiction"}, {Code: "multi"}, {Code: "zzzzz"}},
+			[]JMRLCodeValue{{Code: "cnf", Name: "Central Nonfiction"}}},
+		{"placeholder case and space", []JMRLCodeValue{{Code: " NONE "}, {Code: "Multi"}}, []JMRLCodeValue{}},
+		{"duplicate codes", []JMRLCodeValue{{Code: "cnf", Name: "Central Nonfiction"}, {Code: "cnf", Name: "Central"}},
+			[]JMRLCodeValue{{Code: "cnf", Name: "Central Nonfiction"}}},
+		{"no name", []JMRLCodeValue{{Code: "cnf"}}, []JMRLCodeValue{{Code: "cnf", Name: "cnf"}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := svc.normalizeLocations(tc.locs); reflect.DeepEqual(got, tc.want) == false {
+				t.Errorf("normalizeLocations(%v) = %v, want %v", tc.locs, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConfiguredPlaceholderLocations(t *testing.T) {
+	cfg := newTestConfig("http://sierra.invalid/iii/sierra-api/v6")
+	cfg.Placeholders = " none, TEMP ,"
+	svc := newTestServiceWithConfig(t, cfg)
+	got := svc.normalizeLocations([]JMRLCodeValue{{Code: "temp", Name: "Temporary"}, {Code: "multi", Name: "Multiple"}})
+	want := []JMRLCodeValue{{Code: "multi", Name: "Multiple"}}
+	if reflect.DeepEqual(got, want) == false {
+		t.Errorf("normalizeLocations = %v, want %v", got, want)
+	}
+}
+
+func TestRedactedLocationsAreGeneric(t *testing.T) {
+	svc := newTestService(t, nil)
+	svc.Locations.set(toCodeSet([]string{"jail"}), nil)
+	got := svc.normalizeLocations([]JMRLCodeValue{{Code: "JAIL", Name: "Regional Jail"}, {Code: "shl", Name: "Shelter"}})
+	want := []JMRLCodeValue{{Code: redactedLocationCode, Name: redactedLocationName}, {Code: "shl", Name: "Shelter"}}
+	if reflect.DeepEqual(got, want) == false {
+		t.Errorf("normalizeLocations = %v, want %v", got, want)
+	}
+}
+
+func TestLocationFields(t *testing.T) {
+	svc := newTestService(t, nil)
+	opts := fieldOptions{View: viewBrief, Language: "en-US", Localizer: testLocalizer(svc, "en-US")}
+	tests := []struct {
+		name string
+		locs []JMRLCodeValue
+		want []string
+	}{
+		{"e-only", []JMRLCodeValue{{Code: "none"}}, []string{"Jefferson-Madison Regional Library"}},
+		{"physical", []JMRLCodeValue{{Code: "cnf", Name: "Central"}}, []string{"Jefferson-Madison Regional Library - Central"}},
+		{"mixed", []JMRLCodeValue{{Code: "none"}, {Code: "cnf", Name: "Central"}}, []string{"Jefferson-Madison Regional Library - Central"}},
+		{"no locations", nil, []string{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bib := testBib("b1", "Title")
+			bib.Locations = tc.locs
+			if got := fieldValues(svc.getResultFields(&bib, opts), "location"); reflect.DeepEqual(got, tc.want) == false {
+				t.Errorf("location fields = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRedactedNamesNeverReturned(t *testing.T) {
+	sierra := newFakeSierra(t)
+	bib := testBib("1001", "Cats")
+	bib.Locations = []JMRLCodeValue{{Code: "jail", Name: "Regional Jail"}, {Code: "cnf", Name: "Central Nonfiction"}}
+	bib.Available = true
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, bib))
+	sierra.handleJSON("bibs/1001", http.StatusOK, bib)
+	sierra.handleJSON("items", http.StatusOK, JMRLItemResult{Total: 2, Entries: []JMRLItem{
+		{ID: "i1", Location: JMRLCodeValue{Code: "jail", Name: "Regional Jail"}, Status: JMRLItemStatus{Code: "-", Display: "AVAILABLE"}},
+		{ID: "i2", Location: JMRLCodeValue{Code: "cnf", Name: "Central Nonfiction"}, Status: JMRLItemStatus{Code: "-", Display: "AVAILABLE"}},
+	}})
+	svc := newTestService(t, sierra)
+	svc.Locations.set(toCodeSet([]string{"jail"}), []BranchGeo{{Code: "jail", Name: "Regional Jail"}, {Code: "cnf", Name: "Central Library"}})
+	svc.Branches.set([]sierraBranch{
+		{ID: "1", Name: "Central Library", Locations: []JMRLCodeValue{{Code: "cnf", Name: "Central Nonfiction"}}},
+		{ID: "2", Name: "Regional Jail", Locations: []JMRLCodeValue{{Code: "jail", Name: "Regional Jail"}}},
+	}, time.Now())
+	router := newRouter(svc)
+	token := mintTestToken(t, v4jwt.User)
+	search := `{"query":"keyword: {cats}","pagination":{"start":0,"rows":20}}`
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{"search", http.MethodPost, "/api/search", search},
+		{"facets", http.MethodPost, "/api/search/facets", search},
+		{"resource", http.MethodGet, "/api/resource/1001", ""},
+		{"branches", http.MethodGet, "/api/branches", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			if tc.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			body := strings.ToLower(rec.Body.String())
+			if strings.Contains(body, "jail") {
+				t.Errorf("redacted location revealed: %s", body)
+			}
+			// the unredacted location is still reported
+			if strings.Contains(body, "central") == false {
+				t.Errorf("no locations in response: %s", body)
+			}
+		})
+	}
+}
+
+func TestReloadLocations(t *testing.T) {
+	dir := t.TempDir()
+	cfg := newTestConfig("http://sierra.invalid" + fakeSierraBase)
+	cfg.LocationCfg = filepath.Join(dir, "locations.toml")
+	cfg.BranchGeo = filepath.Join(dir, "branches.toml")
+	writeOverrides(t, cfg.LocationCfg, "redacted = [\"jail\"]\n")
+	writeOverrides(t, cfg.BranchGeo, "[[branch]]\ncode = \"cnf\"\nname = \"Central Library\"\nlatitude = 38.03\nlongitude = -78.48\n")
+	svc := newTestServiceWithConfig(t, cfg)
+	if err := svc.reloadLocations(); err != nil {
+		t.Fatalf("reloadLocations failed: %s", err.Error())
+	}
+	router := newRouter(svc)
+	if svc.isRedactedLocation("jail") == false || svc.isRedactedLocation("shl") {
+		t.Errorf("redacted jail %t shl %t, want true false", svc.isRedactedLocation("jail"), svc.isRedactedLocation("shl"))
+	}
+
+	writeOverrides(t, cfg.LocationCfg, "redacted = [\"shl\"]\n")
+	writeOverrides(t, cfg.BranchGeo, "[[branch]]\ncode = \"nrf\"\nname = \"Northside Library\"\nlatitude = 38.07\nlongitude = -78.47\n")
+	if rec := apiRequestAs(t, router, v4jwt.Staff, http.MethodPost, "/api/admin/reload", ""); rec.Code != http.StatusOK {
+		t.Fatalf("reload status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if svc.isRedactedLocation("jail") || svc.isRedactedLocation("shl") == false {
+		t.Errorf("after reload redacted jail %t shl %t, want false true", svc.isRedactedLocation("jail"), svc.isRedactedLocation("shl"))
+	}
+	if b := svc.branchForLocation("nrfic"); b == nil || b.Name != "Northside Library" {
+		t.Errorf("branchForLocation(nrfic) = %+v, want Northside Library", b)
+	}
+
+	// a rejected file keeps the previous locations in effect
+	writeOverrides(t, cfg.LocationCfg, "redacted = [\" \"]\n")
+	if rec := apiRequestAs(t, router, v4jwt.Staff, http.MethodPost, "/api/admin/reload", ""); rec.Code != http.StatusInternalServerError {
+		t.Errorf("invalid reload status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if svc.isRedactedLocation("shl") == false || svc.branchForLocation("nrfic") == nil {
+		t.Errorf("locations changed by a rejected reload")
+	}
+	if status := svc.getReloadStatus()["locations"]; status.Success {
+		t.Errorf("locations reload status = %+v, want a failure", status)
+	}
+}
+
+// the background branch refresh reads the locations while a reload replaces them;
+// run with -race to check the access is guarded
+func TestReloadLocationsDuringBranchRefresh(t *testing.T) {
+	dir := t.TempDir()
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("branches", http.StatusOK, sierraBranchList{Total: 2, Entries: testBranches})
+	cfg := newTestConfig(sierra.apiURL())
+	cfg.LocationCfg = filepath.Join(dir, "locations.toml")
+	cfg.BranchGeo = filepath.Join(dir, "branches.toml")
+	writeOverrides(t, cfg.LocationCfg, "redacted = [\"jail\"]\n")
+	writeOverrides(t, cfg.BranchGeo, "[[branch]]\ncode = \"cnf\"\nname = \"Central Library\"\nlatitude = 38.03\nlongitude = -78.48\n")
+	svc := newTestServiceWithConfig(t, cfg)
+
+	captureLog(func() {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				svc.refreshBranches(context.Background(), time.Now())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				svc.reloadLocations()
+				svc.branchForLocation("cnf")
+			}
+		}()
+		wg.Wait()
+	})
+	if svc.isRedactedLocation("jail") == false {
+		t.Errorf("jail is not redacted after concurrent reloads")
+	}
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/gzip"
@@ -17,22 +20,56 @@ const version = "1.1.1"
  * MAIN
  */
 func main() {
+	// developer fixture capture mode; see runCapture
+	if len(os.Args) > 1 && os.Args[1] == "capture" {
+		runCapture(os.Args[2:])
+		return
+	}
+
 	log.Printf("===> V4 JMRL pool starting up <===")
 
 	// Get config params and use them to init service context. Any issues are fatal
 	cfg := LoadConfiguration()
 	svc := InitializeService(version, cfg)
 
+	// SIGHUP reloads external configuration without a restart
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Printf("SIGHUP received")
+			svc.reload()
+		}
+	}()
+
 	log.Printf("Setup routes...")
 	gin.SetMode(gin.ReleaseMode)
 	gin.DisableConsoleColor()
-	router := gin.Default()
+	router := newRouter(svc)
+
+	portStr := fmt.Sprintf(":%d", cfg.Port)
+	log.Printf("Start service v%s on port %s", version, portStr)
+	log.Fatal(router.Run(portStr))
+}
+
+// newRouter creates the router with the middleware and routes of the service
+func newRouter(svc *ServiceContext) *gin.Engine {
+	router := gin.New()
+	router.Use(gin.LoggerWithFormatter(accessLogFormatter), gin.CustomRecovery(recoverPanic))
+	router.NoRoute(sendNoRoute)
 	router.Use(gzip.Gzip(gzip.DefaultCompression))
 	corsCfg := cors.DefaultConfig()
 	corsCfg.AllowAllOrigins = true
 	corsCfg.AllowCredentials = true
 	corsCfg.AddAllowHeaders("Authorization")
-	router.Use(cors.New(corsCfg))
+	allOrigins := cors.New(corsCfg)
+	router.Use(func(c *gin.Context) {
+		// the feed answers only its configured origins; see feedCORS
+		if c.Request.URL.Path == feedPath {
+			return
+		}
+		allOrigins(c)
+	})
 
 	//
 	// we are removing Prometheus support for now
@@ -55,14 +92,36 @@ func main() {
 	api := router.Group("/api")
 	{
 		api.GET("/providers", svc.providersHandler)
+		api.GET("/branches", svc.branchesHandler)
 		api.POST("/search", svc.authMiddleware, svc.search)
 		api.POST("/search/facets", svc.authMiddleware, svc.facets)
+		api.POST("/search/multi", svc.authMiddleware, svc.searchMulti)
+		api.POST("/debug/query", svc.authMiddleware, svc.debugQuery)
 		api.GET("/resource/:id", svc.authMiddleware, svc.getResource)
+		api.GET("/resource/:id/bibtex", svc.authMiddleware, svc.getBibTeX)
+		api.GET("/suggest", svc.authMiddleware, svc.suggest)
+		api.GET("/feed", svc.feedCORS, svc.authMiddleware, svc.feed)
+		api.OPTIONS("/feed", svc.feedCORS)
+		if svc.Trending != nil {
+			api.POST("/beacon", svc.authMiddleware, svc.beacon)
+			api.GET("/trending", svc.authMiddleware, svc.trending)
+		}
+		admin := api.Group("/admin", svc.authMiddleware, svc.staffMiddleware)
+		{
+			admin.GET("/config", svc.adminConfig)
+			admin.GET("/status", svc.adminStatus)
+			admin.POST("/reload", svc.adminReload)
+			admin.GET("/zero-results", svc.adminZeroResults)
+			admin.GET("/cache/:digest", svc.adminCacheEntry)
+			// fault injection is only reachable when enabled by configuration
+			if svc.Faults != nil {
+				admin.GET("/fault", svc.adminGetFault)
+				admin.POST("/fault", svc.adminSetFault)
+				admin.DELETE("/fault", svc.adminClearFault)
+			}
+		}
 	}
 
 	router.Use(static.Serve("/assets", static.LocalFile("./assets", true)))
-
-	portStr := fmt.Sprintf(":%d", cfg.Port)
-	log.Printf("Start service v%s on port %s", version, portStr)
-	log.Fatal(router.Run(portStr))
+	return router
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ServiceMetrics is a minimal set of named counters and latency histograms used to track
+// noteworthy events (upstream problems, degraded behavior) while Prometheus support is disabled
+type ServiceMetrics struct {
+	lock       sync.Mutex
+	counters   map[string]int64
+	histograms map[string]*latencyHistogram
+}
+
+// latencyBucketsMS are the upper bounds of the latency histogram buckets in milliseconds
+var latencyBucketsMS = []int64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// latencyHistogram counts observed durations by bucket. Buckets are cumulative as in
+// Prometheus; observations above the largest bound are only in the count.
+type latencyHistogram struct {
+	Buckets map[string]int64 `json:"buckets"`
+	Count   int64            `json:"count"`
+	SumMS   int64            `json:"sum_ms"`
+}
+
+// NewServiceMetrics creates an empty set of counters
+func NewServiceMetrics() *ServiceMetrics {
+	return &ServiceMetrics{counters: make(map[string]int64), histograms: make(map[string]*latencyHistogram)}
+}
+
+// Increment adds one to the named counter
+func (m *ServiceMetrics) Increment(name string) {
+	m.Add(name, 1)
+}
+
+// Add adds delta to the named counter
+func (m *ServiceMetrics) Add(name string, delta int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.counters[name] += delta
+}
+
+// Observe adds a duration to the named latency histogram
+func (m *ServiceMetrics) Observe(name string, d time.Duration) {
+	ms := int64(d / time.Millisecond)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h := m.histograms[name]
+	if h == nil {
+		h = &latencyHistogram{Buckets: make(map[string]int64)}
+		m.histograms[name] = h
+	}
+	for _, le := range latencyBucketsMS {
+		if ms <= le {
+			h.Buckets[fmt.Sprintf("le_%d", le)]++
+		}
+	}
+	h.Count++
+	h.SumMS += ms
+}
+
+// Snapshot returns a copy of all counters
+func (m *ServiceMetrics) Snapshot() map[string]int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	out := make(map[string]int64, len(m.counters))
+	for k, v := range m.counters {
+		out[k] = v
+	}
+	return out
+}
+
+// Histograms returns a copy of all latency histograms
+func (m *ServiceMetrics) Histograms() map[string]latencyHistogram {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	out := make(map[string]latencyHistogram, len(m.histograms))
+	for k, h := range m.histograms {
+		buckets := make(map[string]int64, len(h.Buckets))
+		for b, v := range h.Buckets {
+			buckets[b] = v
+		}
+		out[k] = latencyHistogram{Buckets: buckets, Count: h.Count, SumMS: h.SumMS}
+	}
+	return out
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestObserveBuckets(t *testing.T) {
+	tests := []struct {
+		name string
+		d    time.Duration
+		want map[string]int64
+	}{
+		{"zero", 0, map[string]int64{"le_5": 1, "le_10": 1, "le_25": 1, "le_50": 1, "le_100": 1, "le_250": 1,
+			"le_500": 1, "le_1000": 1, "le_2500": 1, "le_5000": 1, "le_10000": 1}},
+		{"on a bound", 250 * time.Millisecond, map[string]int64{"le_250": 1, "le_500": 1, "le_1000": 1,
+			"le_2500": 1, "le_5000": 1, "le_10000": 1}},
+		{"just over a bound", 5001 * time.Millisecond, map[string]int64{"le_10000": 1}},
+		{"over the largest bound", 30 * time.Second, map[string]int64{}},
+	}
+	for _, tc := range tests {
+		m := NewServiceMetrics()
+		m.Observe("latency", tc.d)
+		h := m.Histograms()["latency"]
+		if reflect.DeepEqual(h.Buckets, tc.want) == false || h.Count != 1 || h.SumMS != int64(tc.d/time.Millisecond) {
+			t.Errorf("%s: histogram = %+v, want buckets %v, count 1 and sum %d", tc.name, h, tc.want, tc.d/time.Millisecond)
+		}
+	}
+}
+
+func TestHistogramsAreCopies(t *testing.T) {
+	m := NewServiceMetrics()
+	m.Observe("latency", time.Millisecond)
+	snapshot := m.Histograms()
+	m.Observe("latency", time.Millisecond)
+	if snapshot["latency"].Count != 1 || snapshot["latency"].Buckets["le_5"] != 1 {
+		t.Errorf("snapshot changed after a later observation: %+v", snapshot["latency"])
+	}
+	if got := m.Histograms()["latency"].Count; got != 2 {
+		t.Errorf("count = %d, want 2", got)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// maxMultiSearches is the most searches accepted in one multi search request
+const maxMultiSearches = 5
+
+// multiSearchDeadline is the shared time limit for all searches in a multi search.
+// Searches still running at the deadline are reported as timed out.
+const multiSearchDeadline = 20 * time.Second
+
+// multiSearchResult is the outcome of one search in a multi search
+type multiSearchResult struct {
+	Index  int
+	Result poolErrorResult
+}
+
+// searchMulti runs up to maxMultiSearches search requests concurrently and returns their
+// pool results in request order. Each search is run by runSearch, so Sierra calls are
+// bounded by the shared limiter; a failed search only fails its own slot, which carries
+// the status of that search. The searches run under the request context, so they stop
+// their Sierra work when the deadline passes or the client goes away.
+func (svc *ServiceContext) searchMulti(c *gin.Context) {
+	if requireJSONBody(c) == false {
+		return
+	}
+	var reqs []json.RawMessage
+	if err := c.BindJSON(&reqs); err != nil {
+		log.Printf("ERROR: unable to parse multi search request: %s", err.Error())
+		sendError(c, http.StatusBadRequest, errBadRequest, "invalid request")
+		return
+	}
+	if len(reqs) == 0 || len(reqs) > maxMultiSearches {
+		log.Printf("ERROR: multi search with %d searches", len(reqs))
+		sendError(c, http.StatusBadRequest, errBadRequest, fmt.Sprintf("between 1 and %d searches are required", maxMultiSearches))
+		return
+	}
+	log.Printf("JMRL multi search requested with %d searches", len(reqs))
+
+	// the searches may still be running after this handler has returned
+	env := newSearchEnv(c)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), multiSearchDeadline)
+	defer cancel()
+	done := make(chan multiSearchResult, len(reqs))
+	for idx, req := range reqs {
+		svc.Metrics.Increment("multi_search_goroutines")
+		go func(idx int, body []byte) {
+			defer svc.Metrics.Add("multi_search_goroutines", -1)
+			done <- multiSearchResult{Index: idx, Result: svc.runSubSearch(ctx, body, env)}
+		}(idx, req)
+	}
+
+	results := make([]poolErrorResult, len(reqs))
+collect:
+	for pending := len(reqs); pending > 0; pending-- {
+		select {
+		case res := <-done:
+			results[res.Index] = res.Result
+		case <-ctx.Done():
+			log.Printf("WARNING: multi search ended (%s) with %d searches pending", ctx.Err(), pending)
+			break collect
+		}
+	}
+	for idx, res := range results {
+		if res.PoolResult == nil {
+			results[idx] = poolErrorResult{PoolResult: &v4api.PoolResult{StatusCode: http.StatusGatewayTimeout,
+				StatusMessage: "search did not complete in time", Groups: make([]v4api.Group, 0)}, ErrorCode: errTimeout}
+		}
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+// runSubSearch runs one search of a multi search and returns its pool result with the
+// error code of a failed search. The search is abandoned when ctx ends.
+func (svc *ServiceContext) runSubSearch(ctx context.Context, body []byte, env searchEnv) poolErrorResult {
+	jmrlReq, unknownKeys, parseErr := parseSearchRequest(body)
+	if parseErr != nil {
+		log.Printf("ERROR: unable to parse multi search request: %s", parseErr.Error())
+		return poolErrorResult{PoolResult: &v4api.PoolResult{StatusCode: http.StatusBadRequest,
+			StatusMessage: "invalid request", Groups: make([]v4api.Group, 0)}, ErrorCode: errBadRequest}
+	}
+	trace := newRequestTrace(env.traceEnabled(jmrlReq.Preferences.Debug))
+	res, err := svc.runSearch(ctx, jmrlReq, env, trace)
+	addUnknownKeysWarning(res, unknownKeys)
+	if err != nil {
+		return poolErrorResult{PoolResult: res, ErrorCode: searchErrorCode(err)}
+	}
+	return svc.completedResult(res, trace)
+}
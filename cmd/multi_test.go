@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+// multiSierra is a fake Sierra search that fails searches for "broken" and records the
+// most searches it saw in flight at once
+type multiSierra struct {
+	lock     sync.Mutex
+	inFlight int
+	maxSeen  int
+}
+
+func (m *multiSierra) serve(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	m.inFlight++
+	if m.inFlight > m.maxSeen {
+		m.maxSeen = m.inFlight
+	}
+	m.lock.Unlock()
+	defer func() {
+		m.lock.Lock()
+		m.inFlight--
+		m.lock.Unlock()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	text := r.URL.Query().Get("text")
+	if strings.Contains(text, "broken") {
+		writeTestJSON(w, http.StatusInternalServerError, SierraError{Code: 109, HTTPStatus: 500, Name: "Internal server error"})
+		return
+	}
+	writeTestJSON(w, http.StatusOK, searchResult(1, testBib("1001", text)))
+}
+
+func TestSearchMultiMixedResults(t *testing.T) {
+	sierra := newFakeSierra(t)
+	fake := &multiSierra{}
+	sierra.handle("bibs/search", fake.serve)
+	cfg := newTestConfig(sierra.apiURL())
+	cfg.MaxConcurrent = 2
+	svc := newTestServiceWithConfig(t, cfg)
+	router := newRouter(svc)
+
+	body := `[
+		{"query":"title: {cats}","pagination":{"rows":20}},
+		{"query":"keyword: {broken}","pagination":{"rows":20}},
+		{"query":"date: {BEFORE 1000}","pagination":{"rows":20}},
+		"not a search",
+		{"query":"author: {smith}","pagination":{"rows":20}}
+	]`
+	rec := apiRequest(t, router, http.MethodPost, "/api/search/multi", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var results []poolErrorResult
+	decodeTestJSON(t, rec, &results)
+	if len(results) != 5 {
+		t.Fatalf("got %d results, want 5", len(results))
+	}
+	tests := []struct {
+		status int
+		code   errorCode
+		title  string
+	}{
+		{http.StatusOK, "", "t:(cats)"},
+		{http.StatusInternalServerError, errUpstreamError, ""},
+		{http.StatusNotImplemented, errQueryUnsupportedField, ""},
+		{http.StatusBadRequest, errBadRequest, ""},
+		{http.StatusOK, "", "a:(smith)"},
+	}
+	for idx, tc := range tests {
+		res := results[idx]
+		if res.StatusCode != tc.status || res.ErrorCode != tc.code {
+			t.Errorf("search %d status %d %q, want %d %q", idx, res.StatusCode, res.ErrorCode, tc.status, tc.code)
+			continue
+		}
+		if tc.title == "" {
+			continue
+		}
+		// results are returned in request order
+		if len(res.Groups) != 1 || len(res.Groups[0].Records) != 1 {
+			t.Errorf("search %d returned %d groups", idx, len(res.Groups))
+		} else if got := fieldValues(res.Groups[0].Records[0].Fields, "title"); len(got) != 1 || got[0] != tc.title {
+			t.Errorf("search %d title = %q, want %s", idx, got, tc.title)
+		}
+	}
+
+	if fake.maxSeen > cfg.MaxConcurrent {
+		t.Errorf("%d Sierra searches in flight, limit is %d", fake.maxSeen, cfg.MaxConcurrent)
+	}
+	// each search goroutine finishes just after handing over its result
+	deadline := time.Now().Add(time.Second)
+	for svc.Metrics.Snapshot()["multi_search_goroutines"] != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := svc.Metrics.Snapshot()["multi_search_goroutines"]; n != 0 {
+		t.Errorf("%d multi search goroutines still running", n)
+	}
+}
+
+func TestSearchMultiRejectsBatchSize(t *testing.T) {
+	router := newRouter(newTestService(t, nil))
+	one := `{"query":"title: {cats}","pagination":{"rows":20}}`
+	for _, body := range []string{`[]`, "[" + strings.Repeat(one+",", maxMultiSearches) + one + "]", one, `not json`} {
+		rec := apiRequest(t, router, http.MethodPost, "/api/search/multi", body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("body %.40q status = %d, want %d", body, rec.Code, http.StatusBadRequest)
+			continue
+		}
+		var resp map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp["error_code"] != string(errBadRequest) {
+			t.Errorf("body %.40q response = %s", body, rec.Body.String())
+		}
+	}
+}
+
+// a client that disconnects mid search leaves no search goroutines or Sierra requests
+// behind: the goroutine count returns to where it was before the request
+func TestSearchMultiClientDisconnectLeaksNothing(t *testing.T) {
+	sierra := newFakeSierra(t)
+	started := make(chan struct{}, maxMultiSearches)
+	abandoned := make(chan struct{}, maxMultiSearches)
+	sierra.handle("bibs/search", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-r.Context().Done():
+			abandoned <- struct{}{}
+		case <-time.After(10 * time.Second):
+			writeTestJSON(w, http.StatusOK, searchResult(0))
+		}
+	})
+	svc := newTestService(t, sierra)
+	router := newRouter(svc)
+	token := mintTestToken(t, v4jwt.User)
+	baseline := runtime.NumGoroutine()
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	body := `[{"query":"title: {cats}"},{"query":"title: {dogs}"},{"query":"title: {birds}"}]`
+	req := httptest.NewRequest(http.MethodPost, "/api/search/multi", strings.NewReader(body)).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	handled := make(chan struct{})
+	go func() {
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		close(handled)
+	}()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of 3 searches reached Sierra", i)
+		}
+	}
+	if n := svc.Metrics.Snapshot()["multi_search_goroutines"]; n != 3 {
+		t.Errorf("%d multi search goroutines mid search, want 3", n)
+	}
+	disconnect()
+
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("multi search did not return after the client disconnected")
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-abandoned:
+		case <-time.After(time.Second):
+			t.Fatalf("only %d of 3 Sierra searches were abandoned", i)
+		}
+	}
+	svc.HTTPClient.CloseIdleConnections()
+	deadline := time.Now().Add(2 * time.Second)
+	for (svc.Metrics.Snapshot()["multi_search_goroutines"] != 0 || runtime.NumGoroutine() > baseline) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := svc.Metrics.Snapshot()["multi_search_goroutines"]; n != 0 {
+		t.Errorf("%d multi search goroutines still running", n)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		buf := make([]byte, 1<<16)
+		t.Errorf("%d goroutines after the disconnect, baseline %d:\n%s", n, baseline, buf[:runtime.Stack(buf, true)])
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+// isOperator returns true if the token is the named boolean operator
+func isOperator(tok queryToken, op string) bool {
+	return tok.Type == tokenWord && tok.Value == op
+}
+
+// operandEnd returns the index just past the operand starting at idx: a word or phrase,
+// a () or {} group, or a field prefix and its value
+func operandEnd(tokens []queryToken, idx int) int {
+	if idx >= len(tokens) {
+		return idx
+	}
+	switch tokens[idx].Type {
+	case tokenOpen:
+		depth := 0
+		for i := idx; i < len(tokens); i++ {
+			if tokens[i].Type == tokenOpen {
+				depth++
+			} else if tokens[i].Type == tokenClose {
+				depth--
+				if depth == 0 {
+					return i + 1
+				}
+			}
+		}
+		return len(tokens)
+	case tokenField:
+		return operandEnd(tokens, idx+1)
+	}
+	return idx + 1
+}
+
+// rewriteNegations converts V4 NOT operators into the binary AND NOT form Sierra
+// requires. `a NOT b` becomes `a AND NOT b`, `a AND NOT b` is unchanged and a NOT that
+// starts a query or group negates everything: `NOT b` becomes `* AND NOT b`. Sierra can't
+// express `a OR NOT b`; the negated operand is dropped and a note is returned for the
+// result warnings rather than sending a query with the wrong meaning. Clauses with their
+// own handlers (date, identifier, filter) are left untouched.
+func rewriteNegations(tokens []queryToken) ([]queryToken, []string) {
+	out := make([]queryToken, 0, len(tokens))
+	notes := make([]string, 0)
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.Type == tokenField {
+			if _, found := clauseHandlers[tok.Value]; found {
+				if _, next, ok := clauseContent(tokens, i); ok {
+					out = append(out, tokens[i:next]...)
+					i = next - 1
+					continue
+				}
+			}
+		}
+		if isOperator(tok, "NOT") == false {
+			out = append(out, tok)
+			continue
+		}
+
+		var prev *queryToken
+		if len(out) > 0 {
+			prev = &out[len(out)-1]
+		}
+		switch {
+		case prev == nil || prev.Type == tokenOpen || prev.Type == tokenField:
+			out = append(out, queryToken{Type: tokenWord, Value: "*"}, queryToken{Type: tokenWord, Value: "AND"}, tok)
+		case isOperator(*prev, "AND") || isOperator(*prev, "NOT"):
+			out = append(out, tok)
+		case isOperator(*prev, "OR"):
+			end := operandEnd(tokens, i+1)
+			notes = append(notes, "OR NOT can not be searched in JMRL; the excluded terms were ignored")
+			out = out[:len(out)-1]
+			i = end - 1
+		default:
+			out = append(out, queryToken{Type: tokenWord, Value: "AND"}, tok)
+		}
+	}
+	return out, notes
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+func TestTranslateSearchNegation(t *testing.T) {
+	svc := newTestService(t, nil)
+	orNot := "OR NOT can not be searched in JMRL; the excluded terms were ignored"
+	tests := []struct {
+		name     string
+		query    string
+		want     string
+		warnings []string
+	}{
+		{"single", `keyword: {cats NOT dogs}`, `(cats AND NOT dogs)`, []string{}},
+		{"and not", `keyword: {cats AND NOT dogs}`, `(cats AND NOT dogs)`, []string{}},
+		{"multiple", `keyword: {cats NOT dogs NOT birds}`, `(cats AND NOT dogs AND NOT birds)`, []string{}},
+		{"group", `keyword: {cats NOT (dogs OR birds)}`, `(cats AND NOT (dogs OR birds))`, []string{}},
+		{"phrase", `keyword: {cats NOT "hot dogs"}`, `(cats AND NOT "hot dogs")`, []string{}},
+		{"field prefixes", `title: {cats} NOT author: {smith}`, `t:(cats) AND NOT a:(smith)`, []string{}},
+		{"field prefix and not", `keyword: {cats} AND NOT title: {dogs}`, `(cats) AND NOT t:(dogs)`, []string{}},
+		{"inside field", `title: {cats NOT dogs} AND author: {smith}`, `t:(cats AND NOT dogs) AND a:(smith)`, []string{}},
+		{"leading", `keyword: {NOT dogs}`, `(* AND NOT dogs)`, []string{}},
+		{"leading field", `NOT title: {cats}`, `* AND NOT t:(cats)`, []string{}},
+		{"after or", `keyword: {cats OR dogs NOT birds}`, `(cats OR dogs AND NOT birds)`, []string{}},
+		{"or not", `keyword: {cats OR NOT dogs}`, `(cats)`, []string{orNot}},
+		{"or not group", `keyword: {cats OR NOT (dogs AND birds)}`, `(cats)`, []string{orNot}},
+		{"or not field", `title: {cats} OR NOT author: {smith}`, `t:(cats)`, []string{orNot}},
+		// date clauses have their own translation; NOT inside them is left alone
+		{"date", `date: {BEFORE 2001} NOT title: {cats}`, `y:(1* OR 2000) AND NOT t:(cats)`, []string{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := jmrlSearchRequest{SearchRequest: v4api.SearchRequest{Query: tc.query, Pagination: v4api.Pagination{Rows: 20}}}
+			xlate, err := svc.translateSearch(req)
+			if err != nil {
+				t.Fatalf("translateSearch(%q) failed: %s", tc.query, err.Message)
+			}
+			if xlate.Query != tc.want {
+				t.Errorf("query = %s, want %s", xlate.Query, tc.want)
+			}
+			if reflect.DeepEqual(xlate.Warnings, tc.warnings) == false {
+				t.Errorf("warnings = %q, want %q", xlate.Warnings, tc.warnings)
+			}
+		})
+	}
+}
+
+// only the NOT forms rewriteNegations understands are let through validation
+func TestMalformedNegationsRejected(t *testing.T) {
+	svc := newTestService(t, nil)
+	for _, query := range []string{`keyword: {cats NOT}`, `keyword: {(AND NOT dogs)}`, `keyword: {cats AND NOT NOT dogs}`, `keyword: {NOT}`} {
+		req := jmrlSearchRequest{SearchRequest: v4api.SearchRequest{Query: query, Pagination: v4api.Pagination{Rows: 20}}}
+		if _, err := svc.translateSearch(req); err == nil || err.StatusCode != http.StatusBadRequest {
+			t.Errorf("translateSearch(%q) = %v, want %d", query, err, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGrammarQueryNegations(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`keyword: {cats AND NOT dogs}`, `keyword: {cats NOT dogs}`},
+		{`keyword: {cats OR NOT dogs}`, `keyword: {cats NOT dogs}`},
+		{`keyword: {NOT dogs}`, `keyword: {dogs}`},
+		{`NOT title: {cats}`, `title: {cats}`},
+		{`keyword: {cats NOT dogs}`, `keyword: {cats NOT dogs}`},
+		{`genre: {cozy NOT (dark OR gritty)}`, `keyword: {cozy NOT (dark OR gritty)}`},
+	}
+	for _, tc := range tests {
+		if got := grammarQuery(tokenizeQuery(tc.query)); got != tc.want {
+			t.Errorf("grammarQuery(%q) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+// the excluded term must reach Sierra as an exclusion
+func TestSearchSendsExclusion(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "Cats")))
+	router := newRouter(newTestService(t, sierra))
+	rec := apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"keyword: {cats NOT dogs}","pagination":{"rows":20}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	found := false
+	for _, raw := range sierra.requestURLs() {
+		if u, _ := url.Parse(raw); u.Path == fakeSierraBase+"/bibs/search" {
+			found = true
+			if got := u.Query().Get("text"); got != "(cats AND NOT dogs)" {
+				t.Errorf("Sierra text = %q, want (cats AND NOT dogs)", got)
+			}
+		}
+	}
+	if found == false {
+		t.Errorf("no Sierra search was made")
+	}
+}
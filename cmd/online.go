@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// defaultOnlineMaterialTypes are the Sierra material type codes of electronic resources:
+// e-books (z), e-audiobooks (y), streaming video (w) and other online resources (m)
+const defaultOnlineMaterialTypes = "z,y,w,m"
+
+// jmrlSearchPreferences are the V4 search preferences plus the client's online resources,
+// large print pairing and debug preferences. Setting both online flags is an error.
+type jmrlSearchPreferences struct {
+	v4api.SearchPreferences
+	ExcludeOnline bool `json:"exclude_online,omitempty"`
+	OnlineOnly    bool `json:"online_only,omitempty"`
+	// PairLargePrint asks for large print pairing in an ungrouped search (with -pairlargeprint)
+	PairLargePrint bool `json:"pair_large_print,omitempty"`
+	// Debug asks for the search diagnostics (staff only), like ?debug=true
+	Debug bool `json:"debug,omitempty"`
+}
+
+// parseMaterialTypes converts a comma separated list of material type codes into a list
+func parseMaterialTypes(list string) []string {
+	out := make([]string, 0)
+	for _, code := range strings.Split(list, ",") {
+		code = strings.ToLower(strings.TrimSpace(code))
+		if code != "" && containsString(out, code) == false {
+			out = append(out, code)
+		}
+	}
+	return out
+}
+
+// onlineRestriction returns the Sierra restriction for the online resources preference,
+// to be ANDed onto the query. Empty means no restriction. Restricting the Sierra search
+// keeps result totals exact, unlike the online availability filter.
+// EX: exclude_online => NOT (m:z)
+func onlineRestriction(prefs jmrlSearchPreferences, types []string) string {
+	if len(types) == 0 || prefs.ExcludeOnline == prefs.OnlineOnly {
+		return ""
+	}
+	terms := make([]string, 0, len(types))
+	for _, code := range types {
+		terms = append(terms, fmt.Sprintf("%s:%s", sierraMaterialIndex, code))
+	}
+	restriction := fmt.Sprintf("(%s)", strings.Join(terms, " OR "))
+	if prefs.ExcludeOnline {
+		return "NOT " + restriction
+	}
+	return restriction
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+func TestParseMaterialTypes(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{"z", []string{"z"}},
+		{" Z, y ,z,,w ", []string{"z", "y", "w"}},
+		{"", []string{}},
+	}
+	for _, tc := range tests {
+		if got := parseMaterialTypes(tc.list); reflect.DeepEqual(got, tc.want) == false {
+			t.Errorf("parseMaterialTypes(%q) = %q, want %q", tc.list, got, tc.want)
+		}
+	}
+}
+
+func TestDefaultOnlineTypes(t *testing.T) {
+	types := parseMaterialTypes(defaultOnlineMaterialTypes)
+	for _, code := range []string{"z", "y", "w"} {
+		if containsString(types, code) == false {
+			t.Errorf("default online types %q do not include %q", types, code)
+		}
+	}
+	for _, code := range []string{formatMaterialTypes["book"], formatMaterialTypes["dvd"], formatMaterialTypes["audiobook"]} {
+		if containsString(types, code) {
+			t.Errorf("default online types %q include the physical type %q", types, code)
+		}
+	}
+}
+
+func TestOnlineRestriction(t *testing.T) {
+	types := []string{"z", "y"}
+	tests := []struct {
+		name  string
+		prefs jmrlSearchPreferences
+		types []string
+		want  string
+	}{
+		{"no preference", jmrlSearchPreferences{}, types, ""},
+		{"exclude", jmrlSearchPreferences{ExcludeOnline: true}, types, "NOT (m:z OR m:y)"},
+		{"only", jmrlSearchPreferences{OnlineOnly: true}, types, "(m:z OR m:y)"},
+		{"both", jmrlSearchPreferences{ExcludeOnline: true, OnlineOnly: true}, types, ""},
+		{"no online types", jmrlSearchPreferences{ExcludeOnline: true}, nil, ""},
+	}
+	for _, tc := range tests {
+		if got := onlineRestriction(tc.prefs, tc.types); got != tc.want {
+			t.Errorf("%s: onlineRestriction = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// materialTerm matches a material type term of a Sierra query
+var materialTerm = regexp.MustCompile(`m:([a-z0-9])`)
+
+// serveMixedCollection answers searches from a collection of print and electronic bibs,
+// applying an online restriction to the query text the way Sierra would
+func serveMixedCollection(sierra *fakeSierra, bibs []JMRLBib) {
+	sierra.handle("bibs/search", func(w http.ResponseWriter, r *http.Request) {
+		text := r.URL.Query().Get("text")
+		types := make(map[string]bool)
+		for _, match := range materialTerm.FindAllStringSubmatch(text, -1) {
+			types[match[1]] = true
+		}
+		exclude := strings.Contains(text, "NOT (m:")
+		matched := make([]JMRLBib, 0)
+		for _, bib := range bibs {
+			if len(types) == 0 || types[bib.Type.Code] != exclude {
+				matched = append(matched, bib)
+			}
+		}
+		writeTestJSON(w, http.StatusOK, searchResult(len(matched), matched...))
+	})
+}
+
+// mixedCollection is a print book, DVD and audiobook with an e-book, e-audiobook and
+// streaming video
+func mixedCollection() []JMRLBib {
+	bibs := make([]JMRLBib, 0)
+	for _, b := range []struct{ id, code, value string }{
+		{"1001", "a", "Book"}, {"1002", "z", "E-Book"}, {"1003", "g", "DVD"},
+		{"1004", "y", "E-Audiobook"}, {"1005", "i", "Audiobook"}, {"1006", "w", "Streaming Video"},
+	} {
+		bib := testBib(b.id, "Cats")
+		bib.Type = JMRLCodeValue{Code: b.code, Value: b.value}
+		bibs = append(bibs, bib)
+	}
+	return bibs
+}
+
+func TestOnlinePreferences(t *testing.T) {
+	sierra := newFakeSierra(t)
+	serveMixedCollection(sierra, mixedCollection())
+	router := newRouter(newTestService(t, sierra))
+
+	tests := []struct {
+		name  string
+		prefs string
+		want  []string
+	}{
+		{"no preference", `{}`, []string{"1001", "1002", "1003", "1004", "1005", "1006"}},
+		{"exclude online", `{"exclude_online":true}`, []string{"1001", "1003", "1005"}},
+		{"online only", `{"online_only":true}`, []string{"1002", "1004", "1006"}},
+	}
+	for _, tc := range tests {
+		rec := apiRequest(t, router, http.MethodPost, "/api/search",
+			`{"query":"keyword: {cats}","pagination":{"start":0,"rows":20},"grouped":false,"preferences":`+tc.prefs+`}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tc.name, rec.Code, rec.Body.String())
+		}
+		var resp v4api.PoolResult
+		decodeTestJSON(t, rec, &resp)
+		got := make([]string, 0)
+		for _, group := range resp.Groups {
+			for _, record := range group.Records {
+				got = append(got, fieldValues(record.Fields, "id")...)
+			}
+		}
+		if reflect.DeepEqual(got, tc.want) == false {
+			t.Errorf("%s: records %v, want %v", tc.name, got, tc.want)
+		}
+		if resp.Pagination.Total != len(tc.want) {
+			t.Errorf("%s: total %d, want %d", tc.name, resp.Pagination.Total, len(tc.want))
+		}
+	}
+
+	rec := apiRequest(t, router, http.MethodPost, "/api/search",
+		`{"query":"keyword: {cats}","preferences":{"exclude_online":true,"online_only":true}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("both preferences: status = %d, want 400", rec.Code)
+	}
+}
+
+// the online share counts the configured online types, and is not counted without any
+func TestPoolStatsOnlineTypes(t *testing.T) {
+	sierra := newFakeSierra(t)
+	counts := &countServer{total: 100, online: 25}
+	serveCounts(sierra, counts)
+	svc := newTestService(t, sierra)
+	svc.OnlineTypes = nil
+	if err := svc.refreshPoolStats(context.Background(), time.Now()); err != nil {
+		t.Fatalf("refreshPoolStats failed: %s", err.Error())
+	}
+	if strings.Join(counts.texts, "|") != "(*)" {
+		t.Errorf("count queries = %q, want only the collection count", counts.texts)
+	}
+	if got := svc.PoolStats.get(); got.CollectionSize != 100 || got.OnlineShare != 0 {
+		t.Errorf("stats = %+v, want 100 records and no online share", got)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// compareBibIDs orders Sierra bib IDs numerically (shorter numeric IDs first), falling
+// back to a plain string comparison. Returns <0, 0 or >0.
+func compareBibIDs(a string, b string) int {
+	if len(a) != len(b) && isAllDigits(a) && isAllDigits(b) {
+		return len(a) - len(b)
+	}
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// isAllDigits returns true if the string is non-empty and contains only ASCII digits
+func isAllDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// sortEntriesByRelevance makes Sierra relevance ordering deterministic. Entries are
+// ordered by descending relevance and entries with equal (or absent) relevance are
+// ordered by bib ID, so identical upstream responses always produce identical pages.
+func sortEntriesByRelevance(entries []JMRLEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Relevance != entries[j].Relevance {
+			return entries[i].Relevance > entries[j].Relevance
+		}
+		return compareBibIDs(entries[i].Bib.ID, entries[j].Bib.ID) < 0
+	})
+}
+
+// sierraSortKeys return the bib value Sierra orders on for each explicit sort
+var sierraSortKeys = map[string]func(bib *JMRLBib) string{
+	v4api.SortTitle.String():  func(bib *JMRLBib) string { return strings.ToLower(strings.TrimSpace(bib.Title)) },
+	v4api.SortAuthor.String(): func(bib *JMRLBib) string { return strings.ToLower(strings.TrimSpace(bib.Author)) },
+	v4api.SortDate.String():   func(bib *JMRLBib) string { return fmt.Sprintf("%d", bib.PublishYear) },
+	sortNewest:                func(bib *JMRLBib) string { return bib.CreatedDate },
+}
+
+// orderEntries makes the ordering of a page deterministic for the resolved sort. Relevance
+// (and distance) ordering uses sortEntriesByRelevance. For explicit sorts the Sierra order
+// is kept and each run of entries with an equal sort value is ordered by bib ID, so equal
+// values never swap between identical requests and paging never repeats or skips a record.
+func orderEntries(entries []JMRLEntry, sortOrder v4api.SortOrder) {
+	keyOf, explicit := sierraSortKeys[sortOrder.SortID]
+	if explicit == false {
+		sortEntriesByRelevance(entries)
+		return
+	}
+	for runStart := 0; runStart < len(entries); {
+		key := keyOf(&entries[runStart].Bib)
+		runEnd := runStart + 1
+		for runEnd < len(entries) && keyOf(&entries[runEnd].Bib) == key {
+			runEnd++
+		}
+		run := entries[runStart:runEnd]
+		sort.SliceStable(run, func(i, j int) bool {
+			return compareBibIDs(run[i].Bib.ID, run[j].Bib.ID) < 0
+		})
+		runStart = runEnd
+	}
+}
+
+// entryIDs returns the bib IDs of the entries, in order
+func entryIDs(entries []JMRLEntry) []string {
+	out := make([]string, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, e.Bib.ID)
+	}
+	return out
+}
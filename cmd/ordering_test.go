@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+func sortedEntry(id string, relevance float32, title string, author string, year int, created string) JMRLEntry {
+	return JMRLEntry{Relevance: relevance, Bib: JMRLBib{ID: id, Title: title, Author: author, PublishYear: year, CreatedDate: created}}
+}
+
+func TestCompareBibIDs(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1001", "1002", -1},
+		{"999", "1000", -1},
+		{"10000", "9999", 1},
+		{"1001", "1001", 0},
+		{"b1001", "b999", -1},
+		{"", "1", -1},
+	}
+	for _, tc := range tests {
+		got := compareBibIDs(tc.a, tc.b)
+		if (got < 0 && tc.want >= 0) || (got > 0 && tc.want <= 0) || (got == 0 && tc.want != 0) {
+			t.Errorf("compareBibIDs(%q, %q) = %d, want sign of %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestOrderEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		sort    v4api.SortOrder
+		entries []JMRLEntry
+		want    []string
+	}{
+		{"relevance ties", v4api.SortOrder{SortID: v4api.SortRelevance.String(), Order: "desc"}, []JMRLEntry{
+			sortedEntry("1003", 2, "", "", 0, ""), sortedEntry("1001", 5, "", "", 0, ""),
+			sortedEntry("999", 2, "", "", 0, ""), sortedEntry("1002", 2, "", "", 0, ""),
+		}, []string{"1001", "999", "1002", "1003"}},
+		{"no relevance", v4api.SortOrder{SortID: v4api.SortRelevance.String(), Order: "desc"}, []JMRLEntry{
+			sortedEntry("1003", 0, "", "", 0, ""), sortedEntry("1001", 0, "", "", 0, ""), sortedEntry("1002", 0, "", "", 0, ""),
+		}, []string{"1001", "1002", "1003"}},
+		{"nearest uses relevance", v4api.SortOrder{SortID: sortNearest, Order: "asc"}, []JMRLEntry{
+			sortedEntry("1002", 1, "", "", 0, ""), sortedEntry("1001", 1, "", "", 0, ""),
+		}, []string{"1001", "1002"}},
+		// Sierra order between different values is kept, even when it differs from ours
+		{"title ties", v4api.SortOrder{SortID: v4api.SortTitle.String(), Order: "asc"}, []JMRLEntry{
+			sortedEntry("1005", 0, "Alpha", "", 0, ""), sortedEntry("1003", 0, "Beta", "", 0, ""),
+			sortedEntry("1001", 0, "beta ", "", 0, ""), sortedEntry("1002", 0, "Beta", "", 0, ""),
+			sortedEntry("1000", 0, "Gamma", "", 0, ""),
+		}, []string{"1005", "1001", "1002", "1003", "1000"}},
+		{"title descending ties", v4api.SortOrder{SortID: v4api.SortTitle.String(), Order: "desc"}, []JMRLEntry{
+			sortedEntry("1002", 0, "Zebra", "", 0, ""), sortedEntry("1001", 0, "Zebra", "", 0, ""), sortedEntry("1003", 0, "Apple", "", 0, ""),
+		}, []string{"1001", "1002", "1003"}},
+		{"author ties", v4api.SortOrder{SortID: v4api.SortAuthor.String(), Order: "asc"}, []JMRLEntry{
+			sortedEntry("1002", 0, "", "Smith, Ann", 0, ""), sortedEntry("1001", 0, "", "Smith, Ann", 0, ""), sortedEntry("1003", 0, "", "Young, Bo", 0, ""),
+		}, []string{"1001", "1002", "1003"}},
+		{"date ties", v4api.SortOrder{SortID: v4api.SortDate.String(), Order: "desc"}, []JMRLEntry{
+			sortedEntry("1004", 0, "", "", 2021, ""), sortedEntry("1003", 0, "", "", 2020, ""),
+			sortedEntry("1002", 0, "", "", 2020, ""), sortedEntry("1001", 0, "", "", 2019, ""),
+		}, []string{"1004", "1002", "1003", "1001"}},
+		{"newest ties", v4api.SortOrder{SortID: sortNewest, Order: "desc"}, []JMRLEntry{
+			sortedEntry("1003", 0, "", "", 0, "2024-01-02"), sortedEntry("1002", 0, "", "", 0, "2024-01-01"), sortedEntry("1001", 0, "", "", 0, "2024-01-01"),
+		}, []string{"1003", "1001", "1002"}},
+		{"empty", v4api.SortOrder{SortID: v4api.SortTitle.String(), Order: "asc"}, []JMRLEntry{}, []string{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			orderEntries(tc.entries, tc.sort)
+			if got := entryIDs(tc.entries); reflect.DeepEqual(got, tc.want) == false {
+				t.Errorf("orderEntries = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Sierra may return records with equal sort values in any order; every permutation of
+// the same response must produce the same page
+func TestOrderEntriesIsStableAcrossResponses(t *testing.T) {
+	sorts := []v4api.SortOrder{
+		{SortID: v4api.SortRelevance.String(), Order: "desc"},
+		{SortID: v4api.SortTitle.String(), Order: "asc"},
+		{SortID: v4api.SortAuthor.String(), Order: "asc"},
+		{SortID: v4api.SortDate.String(), Order: "desc"},
+		{SortID: sortNewest, Order: "desc"},
+	}
+	response := []JMRLEntry{
+		sortedEntry("1001", 3, "Cats", "Able, A", 2020, "2024-01-01"),
+		sortedEntry("1002", 3, "Cats", "Able, A", 2020, "2024-01-01"),
+		sortedEntry("1003", 3, "Cats", "Able, A", 2020, "2024-01-01"),
+		sortedEntry("1004", 3, "Cats", "Able, A", 2020, "2024-01-01"),
+		sortedEntry("1005", 1, "Dogs", "Baker, B", 2018, "2023-06-01"),
+		sortedEntry("1006", 1, "Dogs", "Baker, B", 2018, "2023-06-01"),
+	}
+	rng := rand.New(rand.NewSource(741))
+	for _, sortOrder := range sorts {
+		var want []string
+		for i := 0; i < 25; i++ {
+			entries := append([]JMRLEntry{}, response...)
+			// shuffle within the tied runs only, as Sierra would
+			rng.Shuffle(4, func(a, b int) { entries[a], entries[b] = entries[b], entries[a] })
+			if rng.Intn(2) == 1 {
+				entries[4], entries[5] = entries[5], entries[4]
+			}
+			orderEntries(entries, sortOrder)
+			got := entryIDs(entries)
+			if want == nil {
+				want = got
+			} else if reflect.DeepEqual(got, want) == false {
+				t.Fatalf("%s: response %d ordered %v, earlier %v", sortOrder.SortID, i, got, want)
+			}
+		}
+		if reflect.DeepEqual(want, []string{"1001", "1002", "1003", "1004", "1005", "1006"}) == false {
+			t.Errorf("%s ordered %v, want ID order within ties", sortOrder.SortID, want)
+		}
+	}
+}
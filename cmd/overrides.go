@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"golang.org/x/text/language"
+)
+
+// identifyOverrides holds externally managed identify strings keyed by base language
+// (en, es) and then by i18n message ID (PoolName, PoolDescription)
+type identifyOverrides struct {
+	lock     sync.RWMutex
+	messages map[string]map[string]string
+}
+
+// ReloadStatus records the outcome of the most recent reload of an external resource
+type ReloadStatus struct {
+	Success    bool      `json:"success"`
+	Message    string    `json:"message"`
+	ReloadedAt time.Time `json:"reloaded_at"`
+}
+
+// loadIdentifyOverrides reads all identify.<lang>.toml files from the override directory.
+// Every file must be valid for the load to succeed; nothing is applied on failure.
+func loadIdentifyOverrides(dir string) (map[string]map[string]string, []string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "identify.*.toml"))
+	if err != nil {
+		return nil, nil, err
+	}
+	out := make(map[string]map[string]string)
+	for _, fn := range files {
+		lang := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(fn), "identify."), ".toml")
+		tag, tagErr := language.Parse(lang)
+		if tagErr != nil {
+			return nil, nil, fmt.Errorf("%s does not name a valid language: %s", fn, tagErr.Error())
+		}
+		base, _ := tag.Base()
+		msgs := make(map[string]string)
+		if _, err := toml.DecodeFile(fn, &msgs); err != nil {
+			return nil, nil, fmt.Errorf("%s is malformed: %s", fn, err.Error())
+		}
+		for key, val := range msgs {
+			if strings.TrimSpace(val) == "" {
+				return nil, nil, fmt.Errorf("%s has an empty value for %s", fn, key)
+			}
+		}
+		out[base.String()] = msgs
+	}
+	return out, files, nil
+}
+
+// reloadIdentifyOverrides re-reads the identify override files. On failure the
+// previous overrides are retained. The outcome is recorded in the reload status.
+func (svc *ServiceContext) reloadIdentifyOverrides() error {
+	if svc.Config.OverrideDir == "" {
+		return nil
+	}
+	log.Printf("Load identify overrides from %s", svc.Config.OverrideDir)
+	msgs, files, err := loadIdentifyOverrides(svc.Config.OverrideDir)
+	if err != nil {
+		log.Printf("ERROR: identify overrides rejected, keeping previous values: %s", err.Error())
+		svc.setReloadStatus("identify_overrides", false, err.Error())
+		return err
+	}
+	svc.IdentifyOverrides.lock.Lock()
+	svc.IdentifyOverrides.messages = msgs
+	svc.IdentifyOverrides.lock.Unlock()
+	for _, fn := range files {
+		svc.recordLoadedFile("identify_override", fn)
+	}
+	msg := fmt.Sprintf("%d override files loaded", len(files))
+	log.Printf("Identify overrides reloaded: %s", msg)
+	svc.setReloadStatus("identify_overrides", true, msg)
+	svc.setStaticModified()
+	return nil
+}
+
+// setReloadStatus records the outcome of a reload attempt
+func (svc *ServiceContext) setReloadStatus(name string, success bool, message string) {
+	svc.reloadLock.Lock()
+	defer svc.reloadLock.Unlock()
+	if svc.reloadStatus == nil {
+		svc.reloadStatus = make(map[string]ReloadStatus)
+	}
+	svc.reloadStatus[name] = ReloadStatus{Success: success, Message: message, ReloadedAt: time.Now()}
+}
+
+// getReloadStatus returns a copy of all reload outcomes
+func (svc *ServiceContext) getReloadStatus() map[string]ReloadStatus {
+	svc.reloadLock.Lock()
+	defer svc.reloadLock.Unlock()
+	out := make(map[string]ReloadStatus)
+	for k, v := range svc.reloadStatus {
+		out[k] = v
+	}
+	return out
+}
+
+// localizeIdentify returns an identify string for the language, preferring the
+// external override and falling back to the compiled-in i18n message
+func (svc *ServiceContext) localizeIdentify(localizer *i18n.Localizer, lang string, msgID string) string {
+	base, _ := language.Make(lang).Base()
+	svc.IdentifyOverrides.lock.RLock()
+	val, found := svc.IdentifyOverrides.messages[base.String()][msgID]
+	svc.IdentifyOverrides.lock.RUnlock()
+	if found {
+		return val
+	}
+	return localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: msgID})
+}
+
+// reload re-reads all reloadable external configuration
+func (svc *ServiceContext) reload() error {
+	log.Printf("Reload external configuration")
+	idErr := svc.reloadIdentifyOverrides()
+	fieldErr := svc.reloadFieldOverrides()
+	locErr := svc.reloadLocations()
+	if idErr != nil {
+		return idErr
+	}
+	if fieldErr != nil {
+		return fieldErr
+	}
+	return locErr
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-api/v4api"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+func TestLoadIdentifyOverrides(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  map[string]map[string]string
+		err   string
+	}{
+		{"per locale", map[string]string{
+			"identify.en.toml":    "PoolDescription = \"Now featuring e-audiobooks!\"\n",
+			"identify.es-MX.toml": "PoolName = \"JMRL\"\nPoolDescription = \"¡Ahora con audiolibros!\"\n",
+		}, map[string]map[string]string{
+			"en": {"PoolDescription": "Now featuring e-audiobooks!"},
+			"es": {"PoolName": "JMRL", "PoolDescription": "¡Ahora con audiolibros!"},
+		}, ""},
+		{"no files", map[string]string{}, map[string]map[string]string{}, ""},
+		{"other files ignored", map[string]string{"notes.toml": "[[["}, map[string]map[string]string{}, ""},
+		{"malformed", map[string]string{
+			"identify.en.toml": "PoolDescription = \"ok\"\n",
+			"identify.es.toml": "PoolDescription = \n",
+		}, nil, "is malformed"},
+		{"empty value", map[string]string{"identify.en.toml": "PoolName = \"  \"\n"}, nil, "empty value for PoolName"},
+		{"invalid language", map[string]string{"identify.xx_yy_zz.toml": "PoolName = \"JMRL\"\n"}, nil, "does not name a valid language"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tc.files {
+				writeOverrides(t, filepath.Join(dir, name), content)
+			}
+			got, _, err := loadIdentifyOverrides(dir)
+			if tc.err != "" {
+				if err == nil || strings.Contains(err.Error(), tc.err) == false {
+					t.Fatalf("error = %v, want one containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadIdentifyOverrides failed: %s", err.Error())
+			}
+			if reflect.DeepEqual(got, tc.want) == false {
+				t.Errorf("overrides = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// identifyStrings returns the pool name and description for the language
+func identifyStrings(t *testing.T, router *gin.Engine, lang string) (string, string) {
+	t.Helper()
+	rec := staticRequest(router, "/identify", "Accept-Language", lang)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("identify %s = %d: %s", lang, rec.Code, rec.Body.String())
+	}
+	var resp v4api.PoolIdentity
+	decodeTestJSON(t, rec, &resp)
+	return resp.Name, resp.Description
+}
+
+// overrides replace only the strings they name, for the locale they name; everything
+// else falls back to the compiled-in messages
+func TestIdentifyOverridesPerLocale(t *testing.T) {
+	svc, router, dir := newStaticTestServer(t)
+	writeOverrides(t, filepath.Join(dir, "identify.es.toml"), "PoolDescription = \"¡Ahora con audiolibros!\"\n")
+	if err := svc.reload(); err != nil {
+		t.Fatalf("reload failed: %s", err.Error())
+	}
+	tests := []struct {
+		lang        string
+		name        string
+		description string
+	}{
+		{"es", "Biblioteca Pública JMRL", "¡Ahora con audiolibros!"},
+		{"es-MX,es;q=0.9", "Biblioteca Pública JMRL", "¡Ahora con audiolibros!"},
+		{"en-US", "JMRL Public Library", "Materials from Charlottesville’s public library system, Jefferson-Madison Regional Library."},
+	}
+	for _, tc := range tests {
+		name, description := identifyStrings(t, router, tc.lang)
+		if name != tc.name || description != tc.description {
+			t.Errorf("%s: identify = %q %q, want %q %q", tc.lang, name, description, tc.name, tc.description)
+		}
+	}
+}
+
+// an admin reload picks up changed files; a malformed file is rejected as a whole, the
+// previous values are kept and the failure is reported in the admin status
+func TestAdminReloadIdentifyOverrides(t *testing.T) {
+	dir := t.TempDir()
+	cfg := newTestConfig("http://sierra.invalid" + fakeSierraBase)
+	cfg.OverrideDir = dir
+	svc := newTestServiceWithConfig(t, cfg)
+	router := newRouter(svc)
+
+	writeOverrides(t, filepath.Join(dir, "identify.en.toml"), "PoolDescription = \"Now featuring e-audiobooks!\"\n")
+	if rec := apiRequestAs(t, router, v4jwt.Staff, http.MethodPost, "/api/admin/reload", ""); rec.Code != http.StatusOK {
+		t.Fatalf("reload status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, description := identifyStrings(t, router, "en-US"); description != "Now featuring e-audiobooks!" {
+		t.Errorf("description after reload = %q", description)
+	}
+
+	writeOverrides(t, filepath.Join(dir, "identify.en.toml"), "PoolDescription = \"Half written\n")
+	writeOverrides(t, filepath.Join(dir, "identify.es.toml"), "PoolDescription = \"¡Nuevo!\"\n")
+	var rec *httptest.ResponseRecorder
+	logged := captureLog(func() {
+		rec = apiRequestAs(t, router, v4jwt.Staff, http.MethodPost, "/api/admin/reload", "")
+	})
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("malformed reload status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if strings.Contains(logged, "ERROR: identify overrides rejected, keeping previous values") == false {
+		t.Errorf("rejected reload was not logged:\n%s", logged)
+	}
+	if _, description := identifyStrings(t, router, "en-US"); description != "Now featuring e-audiobooks!" {
+		t.Errorf("en description after a rejected reload = %q, want the previous override", description)
+	}
+	if _, description := identifyStrings(t, router, "es"); description == "¡Nuevo!" {
+		t.Errorf("es override from a rejected reload was applied")
+	}
+
+	status := apiRequestAs(t, router, v4jwt.Staff, http.MethodGet, "/api/admin/status", "")
+	if status.Code != http.StatusOK {
+		t.Fatalf("admin status = %d: %s", status.Code, status.Body.String())
+	}
+	var resp struct {
+		Reload map[string]ReloadStatus `json:"reload"`
+	}
+	decodeTestJSON(t, status, &resp)
+	outcome, found := resp.Reload["identify_overrides"]
+	if found == false || outcome.Success || strings.Contains(outcome.Message, "identify.en.toml is malformed") == false {
+		t.Errorf("identify_overrides status = %+v, want the malformed file failure", outcome)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+)
+
+// pagedSearch runs a Sierra search for one page of results. Pages larger than
+// sierraMaxLimit are fetched with several Sierra requests; if one fails, the rows already
+// fetched are returned with a warning. Sierra requests past sierraMaxOffset fail or come
+// back empty with a misleading total, so pages reaching past it are truncated and pages
+// starting beyond it are not sent to Sierra at all. Empty pages past the end of the
+// results get their total from a count query so pagination stays accurate. While adaptive
+// paging is reducing page sizes, fewer rows are requested. Warnings are returned when the
+// page was limited.
+func (svc *ServiceContext) pagedSearch(ctx context.Context, params url.Values, start int, rows int, trace *requestTrace) (*JMRLResult, []string, *RequestError) {
+	warnings := make([]string, 0)
+	if start >= sierraMaxOffset {
+		log.Printf("WARNING: search start %d is past the Sierra offset limit %d", start, sierraMaxOffset)
+		trace.decision("start past offset limit")
+		total, err := svc.countSearch(ctx, params, trace)
+		if err != nil {
+			return nil, warnings, err
+		}
+		warnings = append(warnings, fmt.Sprintf("Only the first %d results are available from this pool", sierraMaxOffset))
+		return &JMRLResult{Start: start, Total: total, Entries: make([]JMRLEntry, 0)}, warnings, nil
+	}
+	if reducedRows, reduced := svc.Adaptive.pageSize(rows); reduced {
+		trace.decision("adaptive page size reduction")
+		svc.Metrics.Increment("adaptive_page_reduced")
+		rows = reducedRows
+		warnings = append(warnings, "JMRL is responding slowly; fewer results per page are being returned")
+	}
+	if start+rows > sierraMaxOffset {
+		log.Printf("WARNING: search page %d+%d truncated at the Sierra offset limit %d", start, rows, sierraMaxOffset)
+		rows = sierraMaxOffset - start
+		warnings = append(warnings, fmt.Sprintf("Only the first %d results are available from this pool", sierraMaxOffset))
+	}
+
+	// pages larger than Sierra's limit are stitched together from sequential requests
+	var jmrlResp *JMRLResult
+	for fetched := 0; fetched < rows; {
+		limit := rows - fetched
+		if limit > sierraMaxLimit {
+			limit = sierraMaxLimit
+		}
+		params.Set("offset", fmt.Sprintf("%d", start+fetched))
+		params.Set("limit", fmt.Sprintf("%d", limit))
+		searchStart := time.Now()
+		part, err := svc.searchBibs(ctx, params, trace)
+		if svc.Adaptive != nil {
+			svc.Adaptive.record(time.Since(searchStart), time.Now())
+		}
+		if err != nil {
+			if jmrlResp == nil {
+				return nil, warnings, err
+			}
+			log.Printf("WARNING: search page failed after %d of %d rows: %s", fetched, rows, err.Message)
+			if isBudgetExceeded(err) {
+				trace.decision("time budget spent during multi page fetch")
+				warnings = append(warnings, fmt.Sprintf("Only %d of the requested %d results could be retrieved within the search time budget", fetched, rows))
+				break
+			}
+			warnings = append(warnings, fmt.Sprintf("Only %d of the requested %d results could be retrieved", fetched, rows))
+			break
+		}
+		if jmrlResp == nil {
+			jmrlResp = part
+		} else {
+			trace.decision("multi page fetch")
+			jmrlResp.Entries = append(jmrlResp.Entries, part.Entries...)
+		}
+		trace.returned(len(part.Entries))
+		fetched += len(part.Entries)
+		if len(part.Entries) < limit {
+			break
+		}
+	}
+	jmrlResp.Start = start
+	jmrlResp.Count = len(jmrlResp.Entries)
+	if len(jmrlResp.Entries) == 0 && start > 0 {
+		log.Printf("WARNING: search start %d is past the end of the results; get the total with a count", start)
+		trace.decision("start past end of results")
+		if total, countErr := svc.countSearch(ctx, params, trace); countErr == nil {
+			jmrlResp.Total = total
+		}
+	}
+	return jmrlResp, warnings, nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// pagingSierra is a fake Sierra search over a result set of total bibs with IDs from
+// 1000000. Searches at failOffset fail and each page takes delay. Like Sierra, offsets
+// past the end return an empty page with a total of zero.
+type pagingSierra struct {
+	lock       sync.Mutex
+	total      int
+	failOffset int
+	delay      time.Duration
+	pages      [][2]int
+	counts     int
+}
+
+func (p *pagingSierra) serve(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	p.lock.Lock()
+	if q.Get("fields") == "id" {
+		p.counts++
+	} else {
+		p.pages = append(p.pages, [2]int{offset, limit})
+	}
+	p.lock.Unlock()
+	time.Sleep(p.delay)
+	if p.failOffset > 0 && offset == p.failOffset {
+		writeTestJSON(w, http.StatusInternalServerError, SierraError{Code: 109, HTTPStatus: 500, Name: "Internal server error"})
+		return
+	}
+	bibs := make([]JMRLBib, 0)
+	for i := offset; i < offset+limit && i < p.total; i++ {
+		bibs = append(bibs, testBib(strconv.Itoa(1000000+i), fmt.Sprintf("Title %d", i)))
+	}
+	total := p.total
+	if offset >= p.total {
+		total = 0
+	}
+	res := searchResult(total, bibs...)
+	res.Start = offset
+	writeTestJSON(w, http.StatusOK, res)
+}
+
+// requests returns the offset and limit of each page request and the number of counts
+func (p *pagingSierra) requests() ([][2]int, int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([][2]int{}, p.pages...), p.counts
+}
+
+func newPagingService(t *testing.T, total int) (*ServiceContext, *pagingSierra) {
+	t.Helper()
+	sierra := newFakeSierra(t)
+	fake := &pagingSierra{total: total}
+	sierra.handle("bibs/search", fake.serve)
+	return newTestService(t, sierra), fake
+}
+
+// entryRange returns true if the entries are the bibs from first, in order
+func entryRange(entries []JMRLEntry, first int) bool {
+	for i, e := range entries {
+		if e.Bib.ID != strconv.Itoa(1000000+first+i) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPagedSearchOffsetLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		total    int
+		start    int
+		rows     int
+		entries  int
+		wantTot  int
+		pages    [][2]int
+		counts   int
+		warnings int
+	}{
+		{"first page", 100, 0, 20, 20, 100, [][2]int{{0, 20}}, 0, 0},
+		{"last partial page", 30, 20, 20, 10, 30, [][2]int{{20, 20}}, 0, 0},
+		{"past the end", 30, 40, 20, 0, 30, [][2]int{{40, 20}}, 1, 0},
+		{"truncated at offset limit", 20000, sierraMaxOffset - 5, 20, 5, 20000, [][2]int{{sierraMaxOffset - 5, 5}}, 0, 1},
+		{"at offset limit", 20000, sierraMaxOffset, 20, 0, 20000, [][2]int{}, 1, 1},
+		{"beyond offset limit", 20000, sierraMaxOffset + 500, 20, 0, 20000, [][2]int{}, 1, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			svc, fake := newPagingService(t, tc.total)
+			params := url.Values{"text": {"(cats)"}}
+			res, warnings, err := svc.pagedSearch(context.Background(), params, tc.start, tc.rows, newRequestTrace(true))
+			if err != nil {
+				t.Fatalf("pagedSearch failed: %s", err.Message)
+			}
+			if len(res.Entries) != tc.entries || res.Total != tc.wantTot || res.Start != tc.start {
+				t.Errorf("got %d entries start %d total %d, want %d start %d total %d", len(res.Entries), res.Start, res.Total,
+					tc.entries, tc.start, tc.wantTot)
+			}
+			if entryRange(res.Entries, tc.start) == false {
+				t.Errorf("entries are not the bibs from %d", tc.start)
+			}
+			pages, counts := fake.requests()
+			if len(pages) != len(tc.pages) || (len(pages) > 0 && fmt.Sprint(pages) != fmt.Sprint(tc.pages)) || counts != tc.counts {
+				t.Errorf("Sierra pages %v counts %d, want %v counts %d", pages, counts, tc.pages, tc.counts)
+			}
+			if len(warnings) != tc.warnings {
+				t.Errorf("warnings = %q, want %d", warnings, tc.warnings)
+			}
+		})
+	}
+}
+
+// pages past the end or the offset limit are empty with accurate pagination; a negative
+// start is a bad request
+func TestSearchPagingBounds(t *testing.T) {
+	svc, _ := newPagingService(t, 20000)
+	router := newRouter(svc)
+	tests := []struct {
+		start  int
+		status int
+		total  int
+	}{
+		{sierraMaxOffset + 20, http.StatusOK, 20000},
+		{-1, http.StatusBadRequest, 0},
+	}
+	for _, tc := range tests {
+		body := fmt.Sprintf(`{"query":"keyword: {cats}","pagination":{"start":%d,"rows":20}}`, tc.start)
+		rec := apiRequest(t, router, http.MethodPost, "/api/search", body)
+		if rec.Code != tc.status {
+			t.Fatalf("start %d status = %d, want %d: %s", tc.start, rec.Code, tc.status, rec.Body.String())
+		}
+		var resp poolErrorResult
+		decodeTestJSON(t, rec, &resp)
+		if tc.status != http.StatusOK {
+			if resp.ErrorCode != errBadRequest {
+				t.Errorf("start %d error code = %q, want %q", tc.start, resp.ErrorCode, errBadRequest)
+			}
+			continue
+		}
+		want := v4api.Pagination{Start: tc.start, Rows: 0, Total: tc.total}
+		if resp.Pagination != want || len(resp.Groups) != 0 || len(resp.Warnings) == 0 {
+			t.Errorf("start %d pagination %+v with %d groups and warnings %q, want %+v, no groups and a warning",
+				tc.start, resp.Pagination, len(resp.Groups), resp.Warnings, want)
+		}
+	}
+}
+
+func TestPagedSearchStitchesPages(t *testing.T) {
+	tests := []struct {
+		name       string
+		total      int
+		failOffset int
+		start      int
+		rows       int
+		entries    int
+		pages      [][2]int
+		warnings   int
+		err        bool
+	}{
+		{"one request", 500, 0, 0, sierraMaxLimit, sierraMaxLimit, [][2]int{{0, 50}}, 0, false},
+		{"three requests", 500, 0, 0, 120, 120, [][2]int{{0, 50}, {50, 50}, {100, 20}}, 0, false},
+		{"offset start", 500, 0, 30, 100, 100, [][2]int{{30, 50}, {80, 50}}, 0, false},
+		{"ends early", 70, 0, 0, 120, 70, [][2]int{{0, 50}, {50, 50}}, 0, false},
+		{"fails mid stitch", 500, 50, 0, 120, 50, [][2]int{{0, 50}, {50, 50}}, 1, false},
+		{"fails on last request", 500, 100, 0, 120, 100, [][2]int{{0, 50}, {50, 50}, {100, 20}}, 1, false},
+		{"fails on first request", 500, 20, 20, 120, 0, [][2]int{{20, 50}}, 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fastRecordBusyRetries(t)
+			svc, fake := newPagingService(t, tc.total)
+			fake.failOffset = tc.failOffset
+			res, warnings, err := svc.pagedSearch(context.Background(), url.Values{"text": {"(cats)"}}, tc.start, tc.rows, newRequestTrace(true))
+			if (err != nil) != tc.err {
+				t.Fatalf("pagedSearch error = %v, want error %t", err, tc.err)
+			}
+			pages, _ := fake.requests()
+			if fmt.Sprint(pages) != fmt.Sprint(tc.pages) {
+				t.Errorf("Sierra pages %v, want %v", pages, tc.pages)
+			}
+			if tc.err {
+				return
+			}
+			if len(res.Entries) != tc.entries || res.Count != tc.entries || res.Start != tc.start || res.Total != tc.total {
+				t.Errorf("got %d entries (count %d) start %d total %d, want %d start %d total %d", len(res.Entries), res.Count,
+					res.Start, res.Total, tc.entries, tc.start, tc.total)
+			}
+			if entryRange(res.Entries, tc.start) == false {
+				t.Errorf("stitched entries are not the bibs from %d in order", tc.start)
+			}
+			if len(warnings) != tc.warnings {
+				t.Errorf("warnings = %q, want %d", warnings, tc.warnings)
+			}
+		})
+	}
+}
+
+// a stitched page reports the pagination of the whole page and the time of all requests
+func TestSearchStitchedPage(t *testing.T) {
+	svc, fake := newPagingService(t, 500)
+	fake.delay = 10 * time.Millisecond
+	router := newRouter(svc)
+	rec := apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"keyword: {cats}","pagination":{"start":10,"rows":120}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp v4api.PoolResult
+	decodeTestJSON(t, rec, &resp)
+	if want := (v4api.Pagination{Start: 10, Rows: 120, Total: 500}); resp.Pagination != want {
+		t.Errorf("pagination = %+v, want %+v", resp.Pagination, want)
+	}
+	records := 0
+	for _, group := range resp.Groups {
+		records += len(group.Records)
+	}
+	if records != 120 {
+		t.Errorf("got %d records, want 120", records)
+	}
+	if resp.ElapsedMS < 30 {
+		t.Errorf("elapsed %dms does not cover the three Sierra requests", resp.ElapsedMS)
+	}
+}
+
+// requested rows are honored, falling back to the default and clamped to maxSearchRows, and
+// the pagination reports the rows actually returned
+func TestSearchRequestedRows(t *testing.T) {
+	tests := []struct {
+		name  string
+		total int
+		rows  int
+		want  int
+		pages [][2]int
+	}{
+		{"no rows", 100, 0, defaultPageSize, [][2]int{{0, defaultPageSize}}},
+		{"negative rows", 100, -5, defaultPageSize, [][2]int{{0, defaultPageSize}}},
+		{"requested rows", 100, 35, 35, [][2]int{{0, 35}}},
+		{"fewer hits than rows", 30, 50, 30, [][2]int{{0, 50}}},
+		{"clamped", 1000, 1000, maxSearchRows, [][2]int{{0, 50}, {50, 50}, {100, 50}, {150, 50}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			svc, fake := newPagingService(t, tc.total)
+			router := newRouter(svc)
+			body := fmt.Sprintf(`{"query":"keyword: {cats}","pagination":{"start":0,"rows":%d},"grouped":false}`, tc.rows)
+			rec := apiRequest(t, router, http.MethodPost, "/api/search", body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var resp v4api.PoolResult
+			decodeTestJSON(t, rec, &resp)
+			if resp.Pagination.Rows != tc.want || len(resp.Groups) != tc.want {
+				t.Errorf("pagination rows = %d with %d groups, want %d", resp.Pagination.Rows, len(resp.Groups), tc.want)
+			}
+			pages, _ := fake.requests()
+			sort.Slice(pages, func(i, j int) bool { return pages[i][0] < pages[j][0] })
+			if reflect.DeepEqual(pages, tc.pages) == false {
+				t.Errorf("Sierra pages = %v, want %v", pages, tc.pages)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// probeReplayWindow is how far a probe timestamp may differ from the current time
+const probeReplayWindow = 60 * time.Second
+
+// monitorProbe validates signed synthetic monitoring requests. It is nil unless both
+// a CIDR allowlist and a monitoring secret are configured.
+type monitorProbe struct {
+	networks []*net.IPNet
+	secret   []byte
+	lock     sync.Mutex
+	seen     map[string]time.Time
+}
+
+// newMonitorProbe parses the comma separated CIDR allowlist. Nil is returned when
+// probing is not configured.
+func newMonitorProbe(cidrs string, secret string) (*monitorProbe, error) {
+	if strings.TrimSpace(cidrs) == "" || secret == "" {
+		return nil, nil
+	}
+	mp := &monitorProbe{secret: []byte(secret), seen: make(map[string]time.Time)}
+	for _, cidr := range strings.Split(cidrs, ",") {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid monitoring CIDR %s: %s", cidr, err.Error())
+		}
+		mp.networks = append(mp.networks, network)
+	}
+	return mp, nil
+}
+
+// allowedIP returns true if the address is inside the CIDR allowlist
+func (mp *monitorProbe) allowedIP(ipStr string) bool {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range mp.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// sign computes the hex HMAC-SHA256 of the timestamp using the monitoring secret
+func (mp *monitorProbe) sign(timestamp string) string {
+	mac := hmac.New(sha256.New, mp.secret)
+	mac.Write([]byte(timestamp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validate checks a probe timestamp and signature. The timestamp must be within the
+// replay window and each signature is only accepted once.
+func (mp *monitorProbe) validate(timestamp string, signature string, now time.Time) error {
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid probe timestamp [%s]", timestamp)
+	}
+	probeTime := time.Unix(secs, 0)
+	if probeTime.Before(now.Add(-probeReplayWindow)) || probeTime.After(now.Add(probeReplayWindow)) {
+		return fmt.Errorf("probe timestamp %s is outside the replay window", timestamp)
+	}
+	expected := mp.sign(timestamp)
+	if hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) == false {
+		return fmt.Errorf("probe signature is invalid")
+	}
+
+	mp.lock.Lock()
+	defer mp.lock.Unlock()
+	for sig, expires := range mp.seen {
+		if now.After(expires) {
+			delete(mp.seen, sig)
+		}
+	}
+	if _, replayed := mp.seen[expected]; replayed {
+		return fmt.Errorf("probe signature has already been used")
+	}
+	mp.seen[expected] = probeTime.Add(probeReplayWindow)
+	return nil
+}
+
+// isSyntheticProbe checks for a valid signed monitoring probe from an allowlisted network.
+// The direct peer address is used (not X-Forwarded-For) so the source can't be spoofed.
+func (svc *ServiceContext) isSyntheticProbe(c *gin.Context) bool {
+	if svc.Probe == nil {
+		return false
+	}
+	timestamp := c.GetHeader("X-Monitor-Timestamp")
+	signature := c.GetHeader("X-Monitor-Signature")
+	if timestamp == "" || signature == "" {
+		return false
+	}
+	if svc.Probe.allowedIP(c.RemoteIP()) == false {
+		log.Printf("WARNING: monitoring probe from non-allowlisted address %s", c.RemoteIP())
+		return false
+	}
+	if err := svc.Probe.validate(timestamp, signature, time.Now()); err != nil {
+		log.Printf("WARNING: monitoring probe rejected: %s", err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewMonitorProbe(t *testing.T) {
+	tests := []struct {
+		cidrs, secret string
+		configured    bool
+		fails         bool
+	}{
+		{"", "", false, false},
+		{"10.0.0.0/8", "", false, false},
+		{"", "secret", false, false},
+		{" ", "secret", false, false},
+		{"10.0.0.0/8, 192.168.1.0/24", "secret", true, false},
+		{"10.0.0.0/8,not-a-cidr", "secret", false, true},
+		{"10.0.0.1", "secret", false, true},
+	}
+	for _, tc := range tests {
+		mp, err := newMonitorProbe(tc.cidrs, tc.secret)
+		if (err != nil) != tc.fails || (mp != nil) != tc.configured {
+			t.Errorf("newMonitorProbe(%q, %q) = %v %v, want configured %t fails %t", tc.cidrs, tc.secret, mp, err, tc.configured, tc.fails)
+		}
+	}
+}
+
+func TestProbeAllowedIP(t *testing.T) {
+	mp, err := newMonitorProbe("10.1.0.0/16,2001:db8::/32", "secret")
+	if err != nil {
+		t.Fatalf("newMonitorProbe failed: %s", err.Error())
+	}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"10.2.0.1", false},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		{"", false},
+		{"not-an-ip", false},
+	}
+	for _, tc := range tests {
+		if got := mp.allowedIP(tc.ip); got != tc.want {
+			t.Errorf("allowedIP(%q) = %t, want %t", tc.ip, got, tc.want)
+		}
+	}
+}
+
+func TestProbeValidate(t *testing.T) {
+	mp, _ := newMonitorProbe("10.0.0.0/8", "monitor-secret")
+	now := time.Unix(1700000000, 0)
+	stamp := func(offset time.Duration) string {
+		return strconv.FormatInt(now.Add(offset).Unix(), 10)
+	}
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		ok        bool
+	}{
+		{"valid", stamp(0), mp.sign(stamp(0)), true},
+		{"replayed", stamp(0), mp.sign(stamp(0)), false},
+		{"uppercase signature", stamp(time.Second), strings.ToUpper(mp.sign(stamp(time.Second))), true},
+		{"edge of window", stamp(-probeReplayWindow), mp.sign(stamp(-probeReplayWindow)), true},
+		{"too old", stamp(-probeReplayWindow - time.Second), mp.sign(stamp(-probeReplayWindow - time.Second)), false},
+		{"too new", stamp(probeReplayWindow + time.Second), mp.sign(stamp(probeReplayWindow + time.Second)), false},
+		{"wrong secret", stamp(2 * time.Second), "00" + mp.sign(stamp(2 * time.Second))[2:], false},
+		{"other timestamp", stamp(3 * time.Second), mp.sign(stamp(4 * time.Second)), false},
+		{"malformed timestamp", "yesterday", mp.sign("yesterday"), false},
+	}
+	for _, tc := range tests {
+		err := mp.validate(tc.timestamp, tc.signature, now)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: validate = %v, want ok %t", tc.name, err, tc.ok)
+		}
+	}
+
+	// used signatures are forgotten once they could no longer pass the window check
+	later := now.Add(3 * probeReplayWindow)
+	if err := mp.validate(strconv.FormatInt(later.Unix(), 10), mp.sign(strconv.FormatInt(later.Unix(), 10)), later); err != nil {
+		t.Fatalf("validate later failed: %s", err.Error())
+	}
+	if len(mp.seen) != 1 {
+		t.Errorf("%d signatures remembered, want 1", len(mp.seen))
+	}
+}
+
+// probeRequest returns a signed probe search from the address
+func probeRequest(mp *monitorProbe, remoteAddr string, query string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/search", strings.NewReader(`{"query":"`+query+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = remoteAddr
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Monitor-Timestamp", timestamp)
+	req.Header.Set("X-Monitor-Signature", mp.sign(timestamp))
+	return req
+}
+
+func TestProbeAuthentication(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(0))
+	cfg := newTestConfig(sierra.apiURL())
+	cfg.ZeroResultsMax = 10
+	cfg.MonitorCIDRs = "10.0.0.0/8"
+	cfg.MonitorSecret = "monitor-secret"
+	svc := newTestServiceWithConfig(t, cfg)
+	svc.Probe, _ = newMonitorProbe(cfg.MonitorCIDRs, cfg.MonitorSecret)
+	router := newRouter(svc)
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		code int
+	}{
+		{"signed from allowlist", probeRequest(svc.Probe, "10.2.3.4:5555", "keyword: {probe}"), http.StatusOK},
+		{"signed from elsewhere", probeRequest(svc.Probe, "192.0.2.1:5555", "keyword: {probe}"), http.StatusUnauthorized},
+		{"forwarded address is ignored", func() *http.Request {
+			req := probeRequest(svc.Probe, "192.0.2.1:5555", "keyword: {probe}")
+			req.Header.Set("X-Forwarded-For", "10.2.3.4")
+			return req
+		}(), http.StatusUnauthorized},
+		{"bad signature", func() *http.Request {
+			req := probeRequest(svc.Probe, "10.2.3.4:5555", "keyword: {probe}")
+			req.Header.Set("X-Monitor-Signature", strings.Repeat("0", 64))
+			return req
+		}(), http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, tc.req)
+		if rec.Code != tc.code {
+			t.Errorf("%s: status = %d, want %d: %s", tc.name, rec.Code, tc.code, rec.Body.String())
+		}
+	}
+	if got := svc.ZeroResults.drain(); len(got) != 0 {
+		t.Errorf("probe searches recorded as zero result queries: %v", got)
+	}
+}
+
+func TestProbeNotConfigured(t *testing.T) {
+	svc := newTestService(t, nil)
+	if svc.Probe != nil {
+		t.Fatalf("monitoring probes are configured by default")
+	}
+	mp, _ := newMonitorProbe("10.0.0.0/8", "monitor-secret")
+	rec := httptest.NewRecorder()
+	newRouter(svc).ServeHTTP(rec, probeRequest(mp, "10.2.3.4:5555", "keyword: {probe}"))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// queryTokenType identifies the kind of a token in a V4 query
+type queryTokenType int
+
+const (
+	tokenWord queryTokenType = iota
+	tokenPhrase
+	tokenField
+	tokenOpen
+	tokenClose
+)
+
+// queryToken is a single token of a V4 query. Field tokens hold the field name without
+// the colon, phrase tokens include their double quotes and open/close tokens hold the
+// original brace or paren.
+type queryToken struct {
+	Type  queryTokenType
+	Value string
+}
+
+// sierraFieldCodes maps V4 field prefixes onto Sierra text search index codes.
+// An empty code means the field is a plain keyword search.
+var sierraFieldCodes = map[string]string{
+	"keyword": "",
+	"title":   "t",
+	"author":  "a",
+	"subject": "d",
+	"series":  "s",
+	"genre":   sierraGenreIndex,
+}
+
+// v4GrammarFields are the field prefixes known to the V4 query grammar. The pool also
+// accepts its own fields (series, genre, call_number) and searches unknown fields as
+// keyword, so queries are validated with those fields standing in as keyword.
+var v4GrammarFields = map[string]bool{
+	"title": true, "journal_title": true, "author": true, "subject": true, "keyword": true,
+	"fulltext": true, "published": true, "identifier": true, "filter": true, "date": true,
+}
+
+// isFieldName returns true if the string can be a V4 field prefix name
+func isFieldName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsLetter(r) == false && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenizeQuery splits a V4 query into tokens. Text inside double quotes is kept as a
+// single phrase token and never interpreted, so colons, braces and field names inside
+// quotes are preserved. An unterminated quote runs to the end of the query. Field
+// prefixes are only recognized outside of {} since V4 values are always in braces. They
+// are case-insensitive, may have whitespace before the colon and are returned lowercase.
+func tokenizeQuery(query string) []queryToken {
+	tokens := make([]queryToken, 0)
+	runes := []rune(query)
+	braceDepth := 0
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end < len(runes) {
+				end++
+			}
+			tokens = append(tokens, queryToken{Type: tokenPhrase, Value: string(runes[i:end])})
+			i = end
+		case r == '{' || r == '(':
+			if r == '{' {
+				braceDepth++
+			}
+			tokens = append(tokens, queryToken{Type: tokenOpen, Value: string(r)})
+			i++
+		case r == '}' || r == ')':
+			if r == '}' && braceDepth > 0 {
+				braceDepth--
+			}
+			tokens = append(tokens, queryToken{Type: tokenClose, Value: string(r)})
+			i++
+		default:
+			end := i
+			for end < len(runes) && isWordBreak(runes[end]) == false {
+				// a field prefix is a name at the start of a word followed by a colon
+				if braceDepth == 0 && runes[end] == ':' && isFieldName(string(runes[i:end])) {
+					break
+				}
+				end++
+			}
+			colon := end
+			if braceDepth == 0 && end > i && isFieldName(string(runes[i:end])) {
+				// allow whitespace between the field name and the colon
+				for colon < len(runes) && unicode.IsSpace(runes[colon]) {
+					colon++
+				}
+			}
+			if braceDepth == 0 && colon < len(runes) && runes[colon] == ':' && end > i {
+				tokens = append(tokens, queryToken{Type: tokenField, Value: strings.ToLower(string(runes[i:end]))})
+				i = colon + 1
+			} else {
+				tokens = append(tokens, queryToken{Type: tokenWord, Value: string(runes[i:end])})
+				i = end
+			}
+		}
+	}
+	return tokens
+}
+
+// formatQuery renders tokens back into V4 query syntax with canonical field prefixes
+// EX: Title : {cats} => title: {cats}
+func formatQuery(tokens []queryToken) string {
+	var out strings.Builder
+	noSpace := true
+	for _, tok := range tokens {
+		if noSpace == false && tok.Type != tokenClose {
+			out.WriteString(" ")
+		}
+		out.WriteString(tok.Value)
+		if tok.Type == tokenField {
+			out.WriteString(":")
+		}
+		noSpace = tok.Type == tokenOpen
+	}
+	return out.String()
+}
+
+// grammarQuery renders tokens as formatQuery does for validation. Field prefixes that are
+// not in the V4 grammar are replaced by keyword, and the AND NOT, OR NOT and leading NOT
+// forms rewriteNegations accepts are validated as the V4 binary NOT.
+// EX: genre: {cozy mysteries} => keyword: {cozy mysteries}
+// EX: keyword: {cats AND NOT dogs} => keyword: {cats NOT dogs}
+func grammarQuery(tokens []queryToken) string {
+	out := make([]queryToken, 0, len(tokens))
+	for i, tok := range tokens {
+		if tok.Type == tokenField && v4GrammarFields[tok.Value] == false {
+			tok = queryToken{Type: tokenField, Value: "keyword"}
+		}
+		if (isOperator(tok, "AND") || isOperator(tok, "OR")) && i+1 < len(tokens) && isOperator(tokens[i+1], "NOT") {
+			continue
+		}
+		leading := i == 0 || tokens[i-1].Type == tokenOpen
+		if isOperator(tok, "NOT") && leading && i+1 < len(tokens) && tokens[i+1].Type != tokenClose {
+			continue
+		}
+		out = append(out, tok)
+	}
+	return formatQuery(out)
+}
+
+// isWordBreak returns true for characters that end a bare word
+func isWordBreak(r rune) bool {
+	return unicode.IsSpace(r) || r == '"' || r == '{' || r == '}' || r == '(' || r == ')'
+}
+
+// queryHasField returns true if the query uses the field prefix outside of quotes
+func queryHasField(tokens []queryToken, field string) bool {
+	for _, tok := range tokens {
+		if tok.Type == tokenField && tok.Value == field {
+			return true
+		}
+	}
+	return false
+}
+
+// literalWord quotes a bare word that contains a colon. Sierra reads a colon as an index
+// code separator, so terms like "Homecoming:", "9:30" or "3:16" must be searched as phrases.
+func literalWord(word string) string {
+	if strings.ContainsRune(word, ':') == false {
+		return word
+	}
+	return `"` + word + `"`
+}
+
+// clauseHandler translates the content of a V4 field clause (the text inside the braces)
+// into a complete Sierra expression
+type clauseHandler func(content string) (string, error)
+
+// clauseHandlers are the V4 fields that need more than a simple index code mapping
+var clauseHandlers = map[string]clauseHandler{
+	"date":          translateDateClause,
+	"identifier":    translateIdentifierClause,
+	"filter":        translateFilterClause,
+	"journal_title": translateJournalTitleClause,
+	"call_number":   translateCallNumberClause,
+	"published":     translatePublishedClause,
+}
+
+// translateJournalTitleClause searches the title index restricted to serials.
+// EX: journal_title: {new yorker} => (t:(new yorker) AND m:s)
+func translateJournalTitleClause(content string) (string, error) {
+	return fmt.Sprintf("(%s:(%s) AND %s:%s)", sierraFieldCodes["title"], literalText(content),
+		sierraMaterialIndex, formatMaterialTypes["serial"]), nil
+}
+
+// literalText applies literalWord to the bare words of clause content that a handler
+// passes through as free text. Quoted phrases are left as they are.
+// EX: Star Trek: Voyager => Star "Trek:" Voyager
+func literalText(content string) string {
+	// inside braces nothing is read as a field prefix
+	tokens := tokenizeQuery("{" + content + "}")[1:]
+	if n := len(tokens); n > 0 && tokens[n-1].Type == tokenClose && tokens[n-1].Value == "}" {
+		tokens = tokens[:n-1]
+	}
+	parts := make([]string, 0, len(tokens))
+	for _, tok := range tokens {
+		if tok.Type == tokenWord {
+			parts = append(parts, literalWord(tok.Value))
+		} else {
+			parts = append(parts, tok.Value)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// clauseContent returns the content of the {} clause that follows the field token at
+// fieldIdx, and the index of the token after the closing brace. False is returned if
+// the field is not followed by a complete brace clause.
+func clauseContent(tokens []queryToken, fieldIdx int) (string, int, bool) {
+	open := fieldIdx + 1
+	if open >= len(tokens) || tokens[open].Type != tokenOpen || tokens[open].Value != "{" {
+		return "", 0, false
+	}
+	parts := make([]string, 0)
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.Value == "{" {
+			depth++
+			if depth == 1 {
+				continue
+			}
+		} else if tok.Value == "}" {
+			depth--
+			if depth == 0 {
+				return strings.Join(parts, " "), i + 1, true
+			}
+		}
+		parts = append(parts, tok.Value)
+	}
+	return "", 0, false
+}
+
+// translateQuery converts a V4 query into Sierra text search syntax. Braces become
+// parens and known field prefixes become Sierra index codes. Quoted phrases pass through
+// untouched and words containing colons are quoted. Fields with a clause handler are
+// translated as a whole clause, and an error is returned if a clause can not be
+// expressed in Sierra syntax.
+// EX: title: {"a memoir: part 1"} AND keyword: {cats} => t:("a memoir: part 1") AND (cats)
+func translateQuery(tokens []queryToken) (string, error) {
+	var out strings.Builder
+	noSpace := true
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		val := tok.Value
+		switch tok.Type {
+		case tokenWord:
+			val = literalWord(val)
+		case tokenOpen:
+			val = "("
+		case tokenClose:
+			val = ")"
+		case tokenField:
+			if handler, found := clauseHandlers[tok.Value]; found {
+				content, next, ok := clauseContent(tokens, i)
+				if ok == false {
+					return "", fmt.Errorf("%s is missing a {} value", tok.Value)
+				}
+				expr, err := handler(content)
+				if err != nil {
+					return "", err
+				}
+				if noSpace == false {
+					out.WriteString(" ")
+				}
+				out.WriteString(expr)
+				noSpace = false
+				i = next - 1
+				continue
+			}
+			// unmapped fields are searched as keyword; see keywordUnmappedFields
+			code := sierraFieldCodes[tok.Value]
+			if code == "" {
+				continue
+			}
+			val = code + ":"
+		}
+		if noSpace == false && tok.Type != tokenClose {
+			out.WriteString(" ")
+		}
+		out.WriteString(val)
+		noSpace = tok.Type == tokenOpen || tok.Type == tokenField
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+func TestTranslateQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"keyword", `keyword: {cats}`, `(cats)`},
+		{"title", `title: {cats}`, `t:(cats)`},
+		{"quoted colon", `keyword: {"title: a memoir"}`, `("title: a memoir")`},
+		{"quoted field name", `title: {"subject: of the crown"}`, `t:("subject: of the crown")`},
+		{"quoted braces", `keyword: {"a {b} (c)"}`, `("a {b} (c)")`},
+		{"unquoted field inside braces", `keyword: {subject: of the crown}`, `("subject:" of the crown)`},
+		{"colon word", `title: {Homecoming: 9:30}`, `t:("Homecoming:" "9:30")`},
+		{"subtitle colon", `title: {Homecoming: a novel}`, `t:("Homecoming:" a novel)`},
+		{"time", `keyword: {meeting at 9:30 am}`, `(meeting at "9:30" am)`},
+		{"bible citation", `keyword: {John 3:16}`, `(John "3:16")`},
+		{"bible citation range", `keyword: {Psalm 23:1-6}`, `(Psalm "23:1-6")`},
+		{"keyword re", `keyword: {re: something}`, `("re:" something)`},
+		{"mixed case fields", `Title: {cats} AND AUTHOR : {Smith}`, `t:(cats) AND a:(Smith)`},
+		{"spaces before colon", `title   :{cats} AND subject	 :  {pets}`, `t:(cats) AND d:(pets)`},
+		{"prefix after paren", `(TITLE :{cats}) OR (Author:{smith} AND SubJect :{pets})`, `(t:(cats)) OR (a:(smith) AND d:(pets))`},
+		{"prefix after nested parens", `((keyword :{cats}))`, `(((cats)))`},
+		{"mixed case value", `subject: {"Cats: A History"} OR Series: {Warriors}`, `d:("Cats: A History") OR s:(Warriors)`},
+		{"nested parens", `(title: {cats} OR title: {dogs}) AND author: {smith}`, `(t:(cats) OR t:(dogs)) AND a:(smith)`},
+		{"nested braces", `keyword: {cats {and dogs}}`, `(cats (and dogs))`},
+		{"unmapped field", `fulltext: {cats}`, `(cats)`},
+		{"journal title", `journal_title: {"new yorker: fiction"}`, `(t:("new yorker: fiction") AND m:s)`},
+		{"journal title colon", `journal_title: {Star Trek: Voyager}`, `(t:(Star "Trek:" Voyager) AND m:s)`},
+		{"journal title colon and phrase", `journal_title: {"new yorker" fiction: 1925}`, `(t:("new yorker" "fiction:" 1925) AND m:s)`},
+		{"genre", `genre: {cozy mysteries}`, `j:(cozy mysteries)`},
+		{"genre and author", `genre: {"graphic novels"} AND author: {smith}`, `j:("graphic novels") AND a:(smith)`},
+		{"mixed case genre", `Genre : {cozy mysteries} OR author: {christie}`, `j:(cozy mysteries) OR a:(christie)`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := translateQuery(tokenizeQuery(tc.query))
+			if err != nil {
+				t.Fatalf("translateQuery(%q) failed: %s", tc.query, err.Error())
+			}
+			if got != tc.want {
+				t.Errorf("translateQuery(%q) = %s, want %s", tc.query, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTokenizeQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []queryToken
+	}{
+		{`Title : {"a: b"}`, []queryToken{{tokenField, "title"}, {tokenOpen, "{"}, {tokenPhrase, `"a: b"`}, {tokenClose, "}"}}},
+		{`(AUTHOR  :{x})`, []queryToken{{tokenOpen, "("}, {tokenField, "author"}, {tokenOpen, "{"}, {tokenWord, "x"}, {tokenClose, "}"}, {tokenClose, ")"}}},
+		{`title:{x}`, []queryToken{{tokenField, "title"}, {tokenOpen, "{"}, {tokenWord, "x"}, {tokenClose, "}"}}},
+		{`(cats)`, []queryToken{{tokenOpen, "("}, {tokenWord, "cats"}, {tokenClose, ")"}}},
+		{`{title: cats}`, []queryToken{{tokenOpen, "{"}, {tokenWord, "title:"}, {tokenWord, "cats"}, {tokenClose, "}"}}},
+		{`"unterminated`, []queryToken{{tokenPhrase, `"unterminated`}}},
+		{`9:30`, []queryToken{{tokenWord, "9:30"}}},
+	}
+	for _, tc := range tests {
+		got := tokenizeQuery(tc.query)
+		if len(got) != len(tc.want) {
+			t.Errorf("tokenizeQuery(%q) = %v, want %v", tc.query, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("tokenizeQuery(%q) = %v, want %v", tc.query, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestFormatQueryRoundTrip(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`Title : {"a memoir: part 1"}`, `title: {"a memoir: part 1"}`},
+		{`KEYWORD:{cats} AND (author: {smith})`, `keyword: {cats} AND (author: {smith})`},
+	}
+	for _, tc := range tests {
+		got := formatQuery(tokenizeQuery(tc.query))
+		if got != tc.want {
+			t.Errorf("formatQuery(%q) = %s, want %s", tc.query, got, tc.want)
+		}
+		if again := formatQuery(tokenizeQuery(got)); again != got {
+			t.Errorf("formatQuery is not stable for %q: %s then %s", tc.query, got, again)
+		}
+	}
+}
+
+// quoted phrases must reach Sierra exactly as typed
+func TestTranslateSearchKeepsQuotedPhrases(t *testing.T) {
+	svc := newTestService(t, nil)
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`keyword: {"title: a memoir"}`, `("title: a memoir")`},
+		{`TITLE: {"Subject: of the Crown"} AND author: {mantel}`, `t:("Subject: of the Crown") AND a:(mantel)`},
+		{`(Title :{cats}) AND (AUTHOR  : {mantel})`, `(t:(cats)) AND (a:(mantel))`},
+	}
+	for _, tc := range tests {
+		req := jmrlSearchRequest{SearchRequest: v4api.SearchRequest{Query: tc.query, Pagination: v4api.Pagination{Rows: 20}}}
+		xlate, err := svc.translateSearch(req)
+		if err != nil {
+			t.Fatalf("translateSearch(%q) failed: %s", tc.query, err.Message)
+		}
+		if xlate.Query != tc.want {
+			t.Errorf("translateSearch(%q) query = %s, want %s", tc.query, xlate.Query, tc.want)
+		}
+	}
+
+	req := jmrlSearchRequest{SearchRequest: v4api.SearchRequest{Query: `keyword: {"cats: and dogs}`, Pagination: v4api.Pagination{Rows: 20}}}
+	if _, err := svc.translateSearch(req); err == nil || err.StatusCode != http.StatusBadRequest {
+		t.Errorf("unterminated quote returned %v, want %d", err, http.StatusBadRequest)
+	}
+}
+
+// journal_title: reaches Sierra as a title search restricted to serials, alone or combined
+func TestJournalTitleSearch(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`journal_title: {new yorker}`, `(t:(new yorker) AND m:s)`},
+		{`journal_title: {new yorker} AND keyword: {fiction}`, `(t:(new yorker) AND m:s) AND (fiction)`},
+		{`keyword: {fiction} OR journal_title: {"the atlantic"}`, `(fiction) OR (t:("the atlantic") AND m:s)`},
+		{`journal_title: {Star Trek: Voyager}`, `(t:(Star "Trek:" Voyager) AND m:s)`},
+	}
+	for _, tc := range tests {
+		sierra := newFakeSierra(t)
+		sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "The New Yorker")))
+		router := newRouter(newTestService(t, sierra))
+		body, _ := json.Marshal(v4api.SearchRequest{Query: tc.query, Pagination: v4api.Pagination{Rows: 20}})
+		rec := apiRequest(t, router, http.MethodPost, "/api/search", string(body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("search %q status = %d: %s", tc.query, rec.Code, rec.Body.String())
+		}
+		var resp v4api.PoolResult
+		decodeTestJSON(t, rec, &resp)
+		if len(resp.Warnings) != 0 {
+			t.Errorf("search %q warnings = %q, want none", tc.query, resp.Warnings)
+		}
+		for _, raw := range sierra.requestURLs() {
+			if u, _ := url.Parse(raw); u.Path == fakeSierraBase+"/bibs/search" {
+				if got := u.Query().Get("text"); got != tc.want {
+					t.Errorf("search %q Sierra text = %q, want %q", tc.query, got, tc.want)
+				}
+			}
+		}
+	}
+}
+
+// colons typed inside a clause must reach Sierra quoted, never as an index code
+func TestColonTermsReachSierraLiterally(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`title: {Homecoming: a novel}`, `t:("Homecoming:" a novel)`},
+		{`keyword: {open 9:30}`, `(open "9:30")`},
+		{`keyword: {John 3:16} AND subject: {bible}`, `(John "3:16") AND d:(bible)`},
+		{`keyword: {re: something}`, `("re:" something)`},
+		{`title: {"Homecoming: a novel"}`, `t:("Homecoming: a novel")`},
+	}
+	for _, tc := range tests {
+		sierra := newFakeSierra(t)
+		sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "Homecoming")))
+		router := newRouter(newTestService(t, sierra))
+		body, _ := json.Marshal(v4api.SearchRequest{Query: tc.query, Pagination: v4api.Pagination{Rows: 20}})
+		if rec := apiRequest(t, router, http.MethodPost, "/api/search", string(body)); rec.Code != http.StatusOK {
+			t.Fatalf("search %q status = %d: %s", tc.query, rec.Code, rec.Body.String())
+		}
+		searched := false
+		for _, raw := range sierra.requestURLs() {
+			if u, _ := url.Parse(raw); u.Path == fakeSierraBase+"/bibs/search" {
+				searched = true
+				if got := u.Query().Get("text"); got != tc.want {
+					t.Errorf("search %q Sierra text = %q, want %q", tc.query, got, tc.want)
+				}
+			}
+		}
+		if searched == false {
+			t.Errorf("search %q did not reach Sierra", tc.query)
+		}
+	}
+}
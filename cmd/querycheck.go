@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// defaultMaxQueryLength is the default longest query (in characters) that is searched
+const defaultMaxQueryLength = 1000
+
+// cleanQuery validates a raw V4 query before it is translated. Control characters are
+// removed (tabs and line breaks become spaces), then the query is rejected if it is
+// longer than maxLen characters or its braces and parens are not balanced outside of
+// quoted phrases. The error describes the problem for the client. An empty query is valid.
+func cleanQuery(query string, maxLen int) (string, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return ' '
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, query)
+	if maxLen > 0 && len([]rune(cleaned)) > maxLen {
+		return "", fmt.Errorf("query is longer than %d characters", maxLen)
+	}
+	if err := checkQueryBalance(cleaned); err != nil {
+		return "", err
+	}
+	return cleaned, nil
+}
+
+// checkQueryBalance returns an error if the braces and parens of a query outside quoted
+// phrases are not balanced and properly nested. An unterminated quote runs to the end of
+// the query, as in tokenizeQuery.
+func checkQueryBalance(query string) error {
+	closers := map[rune]rune{'}': '{', ')': '('}
+	open := make([]rune, 0)
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '{' || r == '(':
+			open = append(open, r)
+		case r == '}' || r == ')':
+			if len(open) == 0 || open[len(open)-1] != closers[r] {
+				return fmt.Errorf("query has an unmatched %c", r)
+			}
+			open = open[:len(open)-1]
+		}
+	}
+	if len(open) > 0 {
+		return fmt.Errorf("query has an unclosed %c", open[len(open)-1])
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCleanQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		maxLen int
+		want   string
+		err    string
+	}{
+		{"empty", "", 20, "", ""},
+		{"single term", "keyword: {cats}", 20, "keyword: {cats}", ""},
+		{"control characters", "keyword: {ca\x00ts\x1b}", 20, "keyword: {cats}", ""},
+		{"line breaks", "keyword: {cats\r\nand\tdogs}", 0, "keyword: {cats  and dogs}", ""},
+		{"at the limit", "keyword: {" + strings.Repeat("é", 9) + "}", 20, "keyword: {" + strings.Repeat("é", 9) + "}", ""},
+		{"too long", "keyword: {" + strings.Repeat("é", 10) + "}", 20, "", "query is longer than 20 characters"},
+		{"no limit", "keyword: {" + strings.Repeat("a", 5000) + "}", 0, "keyword: {" + strings.Repeat("a", 5000) + "}", ""},
+		{"unclosed brace", "keyword: {cats", 0, "", "query has an unclosed {"},
+		{"unmatched paren", "keyword: {cats})", 0, "", "query has an unmatched )"},
+		{"crossed", "(keyword: {cats)}", 0, "", "query has an unmatched )"},
+		{"quoted brace", `keyword: {"cats {"}`, 0, `keyword: {"cats {"}`, ""},
+		{"unterminated quote", `keyword: {"cats}`, 0, "", "query has an unclosed {"},
+	}
+	for _, tc := range tests {
+		got, err := cleanQuery(tc.query, tc.maxLen)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%s: error = %v, want %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%s: cleanQuery = %q %v, want %.40q", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+// every rejection is a 400 pool result the client can show, with the reason
+func TestSearchRejectsInvalidQueries(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "Cats")))
+	cfg := newTestConfig(sierra.apiURL())
+	cfg.MaxQueryLength = 50
+	router := newRouter(newTestServiceWithConfig(t, cfg))
+
+	tests := []struct {
+		name    string
+		query   string
+		status  int
+		message string
+	}{
+		{"too long", "keyword: {" + strings.Repeat("cats ", 20) + "}", http.StatusBadRequest, "query is longer than 50 characters"},
+		{"unclosed brace", "keyword: {cats", http.StatusBadRequest, "query has an unclosed {"},
+		{"unmatched paren", "keyword: {cats})", http.StatusBadRequest, "query has an unmatched )"},
+		{"control characters only break nothing", `keyword: {ca\u0000ts}`, http.StatusOK, ""},
+		{"single term", "keyword: {cats}", http.StatusOK, ""},
+	}
+	for _, tc := range tests {
+		rec := apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"`+tc.query+`"}`)
+		if rec.Code != tc.status {
+			t.Errorf("%s: status = %d, want %d: %s", tc.name, rec.Code, tc.status, rec.Body.String())
+			continue
+		}
+		if tc.status == http.StatusOK {
+			continue
+		}
+		var resp poolErrorResult
+		decodeTestJSON(t, rec, &resp)
+		if resp.StatusCode != tc.status || resp.StatusMessage != tc.message || resp.ErrorCode != errQueryMalformed {
+			t.Errorf("%s: result status %d %q %q, want %d %q %q", tc.name, resp.StatusCode, resp.StatusMessage,
+				resp.ErrorCode, tc.status, tc.message, errQueryMalformed)
+		}
+	}
+	if got := sierra.count("bibs/search"); got != 2 {
+		t.Errorf("%d Sierra searches, want 2 for the valid queries only", got)
+	}
+}
+
+// an empty query passes validation and is left to the V4 query grammar, as before
+func TestSearchEmptyQueryPassesValidation(t *testing.T) {
+	router := newRouter(newTestService(t, newFakeSierra(t)))
+	rec := apiRequest(t, router, http.MethodPost, "/api/search", `{"query":""}`)
+	var resp poolErrorResult
+	decodeTestJSON(t, rec, &resp)
+	if rec.Code != http.StatusBadRequest || resp.StatusMessage != "Malformed search" {
+		t.Errorf("empty query = %d %q, want the grammar's 400 Malformed search", rec.Code, resp.StatusMessage)
+	}
+}
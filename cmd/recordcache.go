@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// recordCacheMax is the most Sierra bib and item responses kept in the record cache
+const recordCacheMax = 2000
+
+// recordGet returns the Sierra response for a bib or items URL and whether it came from
+// the record cache. Without a record cache every response comes from Sierra; with one,
+// successful responses are cached and reused until they expire.
+func (svc *ServiceContext) recordGet(ctx context.Context, tgtURL string, trace *requestTrace) ([]byte, cacheStatus, *RequestError) {
+	if svc.Records == nil {
+		resp, err := svc.tracedAPIGet(ctx, tgtURL, trace)
+		return resp, newCacheStatus(false, 0), err
+	}
+	key := recordCacheKey(tgtURL)
+	now := time.Now()
+	if cached, age, found := svc.Records.lookup(key, now); found {
+		trace.decision("record cache hit")
+		trace.cachedAs(key)
+		return cached.([]byte), newCacheStatus(true, age), nil
+	}
+	resp, err := svc.tracedAPIGet(ctx, tgtURL, trace)
+	if err != nil {
+		return resp, newCacheStatus(false, 0), err
+	}
+	fetched := time.Now()
+	svc.Records.put(key, resp, newCacheSource(fetched, tgtURL), fetched)
+	trace.cachedAs(key)
+	return resp, newCacheStatus(false, 0), nil
+}
+
+// recordCacheKey returns the record cache key of a Sierra bib or items URL
+func recordCacheKey(tgtURL string) string {
+	return cacheKey{Kind: "record", Subject: tgtURL}.String()
+}
+
+// combine returns the status of a response built from two lookups: it is cached only
+// if both parts were, and is as old as the oldest part
+func (cs cacheStatus) combine(other cacheStatus) cacheStatus {
+	if cs.Cached == false || other.Cached == false {
+		return cacheStatus{}
+	}
+	if other.AgeSeconds > cs.AgeSeconds {
+		return other
+	}
+	return cs
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// knownJSONKeys returns the top level JSON keys a struct type decodes. Fields of
+// embedded structs are promoted just as encoding/json does.
+func knownJSONKeys(t reflect.Type) map[string]bool {
+	out := make(map[string]bool)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return out
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			for key := range knownJSONKeys(field.Type) {
+				out[key] = true
+			}
+			continue
+		}
+		if field.IsExported() == false {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		out[strings.ToLower(name)] = true
+	}
+	return out
+}
+
+// unknownRequestKeys returns the sorted top level keys of a JSON object body that the
+// target struct does not decode. Nested objects are not inspected. Keys are matched
+// case insensitively, like encoding/json. Bodies that are not objects have no unknown keys.
+func unknownRequestKeys(body []byte, target interface{}) []string {
+	out := make([]string, 0)
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return out
+	}
+	known := knownJSONKeys(reflect.TypeOf(target))
+	for key := range raw {
+		if known[strings.ToLower(key)] == false {
+			out = append(out, key)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+func TestUnknownRequestKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"current", `{"query":"keyword: {cats}","pagination":{"start":0,"rows":20},"sort":{"sort_id":"SortRelevance","order":"desc"},
+			"filters":[{"pool_id":"jmrl","facets":[]}],"preferences":{"exclude_online":true}}`, []string{}},
+		{"pool extensions", `{"query":"keyword: {cats}","latitude":38.03,"longitude":-78.48,"grouped":false,"added_days":30,"timeout_ms":500}`, []string{}},
+		{"future", `{"query":"keyword: {cats}","explain":true,"pagination":{"start":0},"scope":{"pool":"jmrl"}}`, []string{"explain", "scope"}},
+		// only the top level is compared; nested objects belong to their own types
+		{"nested unknown", `{"query":"keyword: {cats}","pagination":{"start":0,"cursor":"abc"},"sort":{"sort_id":"SortTitle","tiebreak":"id"}}`, []string{}},
+		{"case insensitive", `{"Query":"keyword: {cats}","PAGINATION":{"rows":5},"Grouped":true}`, []string{}},
+		{"sorted", `{"zeta":1,"alpha":2,"query":""}`, []string{"alpha", "zeta"}},
+		{"empty object", `{}`, []string{}},
+		{"array body", `[{"query":"cats"}]`, []string{}},
+		{"invalid", `{"query":`, []string{}},
+	}
+	for _, tc := range tests {
+		if got := unknownRequestKeys([]byte(tc.body), jmrlSearchRequest{}); reflect.DeepEqual(got, tc.want) == false {
+			t.Errorf("%s: unknownRequestKeys = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestKnownJSONKeys(t *testing.T) {
+	type inner struct {
+		Promoted string `json:"promoted"`
+	}
+	type Named struct {
+		Value string
+	}
+	type request struct {
+		inner
+		*Named   `json:"named"`
+		Plain    string
+		Tagged   string `json:"tagged,omitempty"`
+		Skipped  string `json:"-"`
+		Dash     string `json:"-,"`
+		internal string
+	}
+	want := map[string]bool{"promoted": true, "named": true, "plain": true, "tagged": true, "-": true}
+	if got := knownJSONKeys(reflect.TypeOf(&request{})); reflect.DeepEqual(got, want) == false {
+		t.Errorf("knownJSONKeys = %v, want %v", got, want)
+	}
+	if got := knownJSONKeys(reflect.TypeOf("")); len(got) != 0 {
+		t.Errorf("knownJSONKeys(string) = %v, want none", got)
+	}
+}
+
+func TestSearchReportsUnknownKeys(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "Cats")))
+	router := newRouter(newTestService(t, sierra))
+
+	var logged string
+	var resp v4api.PoolResult
+	logged = captureLog(func() {
+		rec := apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"keyword: {cats}","explain":true,"facet_limit":5}`)
+		decodeTestJSON(t, rec, &resp)
+	})
+	want := "Unsupported search request fields were ignored: explain, facet_limit"
+	found := false
+	for _, w := range resp.Warnings {
+		found = found || w == want
+	}
+	if found == false {
+		t.Errorf("warnings = %v, want %q", resp.Warnings, want)
+	}
+	if strings.Contains(logged, "WARNING: search request contains unsupported fields: explain, facet_limit") == false {
+		t.Errorf("unknown fields were not logged: %s", logged)
+	}
+
+	resp = v4api.PoolResult{}
+	decodeTestJSON(t, apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"keyword: {cats}","grouped":true}`), &resp)
+	for _, w := range resp.Warnings {
+		if strings.HasPrefix(w, "Unsupported search request fields") {
+			t.Errorf("known fields reported as unsupported: %q", w)
+		}
+	}
+}
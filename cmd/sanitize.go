@@ -0,0 +1,169 @@
+package main
+
+import (
+	"html"
+	"strings"
+)
+
+// htmlTagNames are the elements stripped from free text. Only known tag names are
+// treated as markup so angle brackets used as comparisons (x<y, a > b) are kept.
+var htmlTagNames = map[string]bool{
+	"a": true, "abbr": true, "b": true, "big": true, "blockquote": true, "body": true, "br": true,
+	"center": true, "cite": true, "code": true, "dd": true, "div": true, "dl": true, "dt": true,
+	"em": true, "font": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"head": true, "hr": true, "html": true, "i": true, "img": true, "li": true, "ol": true, "p": true,
+	"pre": true, "q": true, "s": true, "small": true, "span": true, "strike": true, "strong": true,
+	"sub": true, "sup": true, "table": true, "tbody": true, "td": true, "th": true, "thead": true,
+	"tr": true, "tt": true, "u": true, "ul": true,
+}
+
+// htmlBreakTags are the elements that separate lines of text
+var htmlBreakTags = map[string]bool{
+	"br": true, "p": true, "div": true, "li": true, "tr": true, "hr": true, "blockquote": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// cleanFreeText decodes entities in a free text field and strips any HTML markup.
+// It must not be used for URLs.
+func cleanFreeText(text string) string {
+	return stripHTML(html.UnescapeString(text))
+}
+
+// stripHTML removes HTML tags and comments from text. Line breaking tags (<br>, <p> and
+// the like) become line breaks, and spacing is collapsed within each line. Anything
+// that is not a complete tag with a known name is left as text. Stripping repeats until
+// nothing changes, so removing one tag can not leave another behind (<<b>b>).
+// EX: <b>A sweeping saga</b><br>of 5 < 6 => A sweeping saga\nof 5 < 6
+func stripHTML(text string) string {
+	for strings.ContainsRune(text, '<') {
+		stripped := stripHTMLPass(text)
+		if stripped == text {
+			break
+		}
+		text = stripped
+	}
+	return text
+}
+
+// stripHTMLPass makes a single stripHTML pass over text
+func stripHTMLPass(text string) string {
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		if text[i] != '<' {
+			out.WriteByte(text[i])
+			i++
+			continue
+		}
+		if strings.HasPrefix(text[i:], "<!--") {
+			if end := strings.Index(text[i+4:], "-->"); end >= 0 {
+				i += 4 + end + 3
+				continue
+			}
+		}
+		name, length := htmlTag(text[i:])
+		if length == 0 {
+			out.WriteByte(text[i])
+			i++
+			continue
+		}
+		if htmlBreakTags[name] {
+			out.WriteString("\n")
+		}
+		i += length
+	}
+
+	lines := make([]string, 0)
+	for _, line := range strings.Split(out.String(), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// htmlTag returns the lowercase name and byte length of the known HTML tag at the start
+// of text. Zero length is returned if text does not start with a complete known tag.
+// Attributes must have values, so comparisons like a<b and b>c are not read as a tag.
+func htmlTag(text string) (string, int) {
+	pos := 1
+	if pos < len(text) && text[pos] == '/' {
+		pos++
+	}
+	nameStart := pos
+	for pos < len(text) && (isASCIILetter(text[pos]) || (pos > nameStart && text[pos] >= '0' && text[pos] <= '9')) {
+		pos++
+	}
+	name := strings.ToLower(text[nameStart:pos])
+	if htmlTagNames[name] == false || pos >= len(text) {
+		return "", 0
+	}
+	if text[pos] != '>' && text[pos] != '/' && isHTMLSpace(text[pos]) == false {
+		return "", 0
+	}
+	for {
+		for pos < len(text) && isHTMLSpace(text[pos]) {
+			pos++
+		}
+		switch {
+		case pos >= len(text):
+			return "", 0
+		case text[pos] == '>':
+			return name, pos + 1
+		case text[pos] == '/' && pos+1 < len(text) && text[pos+1] == '>':
+			return name, pos + 2
+		}
+		next := htmlAttribute(text, pos)
+		if next == 0 {
+			return "", 0
+		}
+		pos = next
+	}
+}
+
+// htmlAttribute returns the position after the name=value attribute starting at pos, or
+// zero if there is none. Quoted values may contain brackets.
+func htmlAttribute(text string, pos int) int {
+	start := pos
+	for pos < len(text) && (isASCIILetter(text[pos]) || (pos > start && strings.IndexByte("0123456789-_:.", text[pos]) >= 0)) {
+		pos++
+	}
+	if pos == start {
+		return 0
+	}
+	for pos < len(text) && isHTMLSpace(text[pos]) {
+		pos++
+	}
+	if pos >= len(text) || text[pos] != '=' {
+		return 0
+	}
+	pos++
+	for pos < len(text) && isHTMLSpace(text[pos]) {
+		pos++
+	}
+	if pos < len(text) && (text[pos] == '"' || text[pos] == '\'') {
+		end := strings.IndexByte(text[pos+1:], text[pos])
+		if end < 0 {
+			return 0
+		}
+		return pos + 1 + end + 1
+	}
+	valueStart := pos
+	for pos < len(text) && isHTMLSpace(text[pos]) == false && strings.IndexByte("<>\"'", text[pos]) < 0 {
+		pos++
+	}
+	if pos == valueStart {
+		return 0
+	}
+	return pos
+}
+
+// isHTMLSpace returns true for the ASCII whitespace HTML allows between attributes
+func isHTMLSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
+
+// isASCIILetter returns true for a-z and A-Z
+func isASCIILetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
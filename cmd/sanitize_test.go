@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestStripHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "A sweeping saga", "A sweeping saga"},
+		{"bold", "<b>A sweeping saga</b>", "A sweeping saga"},
+		{"line breaks", "Part one<br>Part two<BR/>Part three<br />end", "Part one\nPart two\nPart three\nend"},
+		{"paragraphs", "<p>First.</p><p>Second.</p>", "First.\nSecond."},
+		{"nested", "<div><p><b><i>Deep</i></b> text</p></div>", "Deep text"},
+		{"attributes", `<a href="http://x.org/?a=1&b=<2>" title='>'>link</a> text`, "link text"},
+		{"comment", "before<!-- <b>hidden</b> -->after", "beforeafter"},
+		{"unclosed comment", "before<!-- never closed", "before<!-- never closed"},
+		{"unclosed tag", "Cats <b and dogs", "Cats <b and dogs"},
+		{"unclosed tag at end", "Cats <br", "Cats <br"},
+		{"tag interrupted by bracket", "<b <i>text</i>", "<b text"},
+		{"less than", "5 < 6 and x<y", "5 < 6 and x<y"},
+		{"greater than", "a > b >= c", "a > b >= c"},
+		{"math in brackets", "if a<b and b>c then a<c", "if a<b and b>c then a<c"},
+		{"unquoted attributes", "<font size=2 color=red>small</font><br clear=all>", "small"},
+		{"spaced attribute", `<p align = "center">centered</p>`, "centered"},
+		{"attribute without value", "<p hidden>kept</p>", "<p hidden>kept"},
+		{"unterminated quote", `<a href="x>link`, `<a href="x>link`},
+		{"unknown element", "<x-custom>kept</x-custom> <foo>", "<x-custom>kept</x-custom> <foo>"},
+		{"stray closing", "text</b> more", "text more"},
+		{"hidden tag", "<<b>b>bold", "bold"},
+		{"multi-byte", "<i>Cien años</i><br>de soledad — 村上", "Cien años\nde soledad — 村上"},
+		{"only markup", "<p><br></p>", ""},
+		{"spacing", "  lots   of\t<b>space</b>  ", "lots of space"},
+	}
+	for _, tc := range tests {
+		if got := stripHTML(tc.in); got != tc.want {
+			t.Errorf("%s: stripHTML(%q) = %q, want %q", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestCleanFreeText(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Cats &amp; dogs", "Cats & dogs"},
+		{"&lt;b&gt;Escaped saga&lt;/b&gt;", "Escaped saga"},
+		{"5 &lt; 6", "5 < 6"},
+		{"Caf&eacute;<br>menu", "Café\nmenu"},
+	}
+	for _, tc := range tests {
+		if got := cleanFreeText(tc.in); got != tc.want {
+			t.Errorf("cleanFreeText(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// free text fields are cleaned; access URLs are left as cataloged
+func TestResultFieldsStripHTML(t *testing.T) {
+	svc := newTestService(t, nil)
+	bib := testBib("1001", "Cats")
+	bib.VarFields = append(bib.VarFields,
+		marcField("520", "a", "<b>A sweeping saga</b><br>of <i>cats</i> &amp; dogs"),
+		marcField("505", "a", "<p>Part one.</p><p>Part two.</p>"),
+		marcField("856", "u", "https://jmrl.overdrive.com/media/1001?a=<b>&amp;c=1"))
+	fields := svc.getResultFields(&bib, fieldOptions{View: viewFull, Language: "en-US", Localizer: testLocalizer(svc, "en-US")})
+	if got := fieldValues(fields, "summary"); len(got) != 1 || got[0] != "A sweeping saga\nof cats & dogs" {
+		t.Errorf("summary = %q", got)
+	}
+	if got := fieldValues(fields, "contents"); len(got) != 1 || got[0] != "Part one.\nPart two." {
+		t.Errorf("contents = %q", got)
+	}
+	want := normalizeAccessURL("https://jmrl.overdrive.com/media/1001?a=<b>&amp;c=1", svc.StripParams)
+	if got := fieldValues(fields, "access_url"); len(got) != 1 || got[0] != want {
+		t.Errorf("access_url = %q, want [%q]", got, want)
+	}
+}
+
+func FuzzStripHTML(f *testing.F) {
+	for _, seed := range []string{"<b>saga</b><br>", "<<b>b>", "<a href='>'>x</a>", "<!-- <p> -->", "x<y>z", "<p", "años<br/>村上", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, in string) {
+		got := stripHTML(in)
+		if again := stripHTML(got); again != got {
+			t.Errorf("stripHTML is not stable for %q: %q then %q", in, got, again)
+		}
+		for i := 0; i < len(got); i++ {
+			if got[i] != '<' {
+				continue
+			}
+			if name, length := htmlTag(got[i:]); length > 0 {
+				t.Errorf("stripHTML(%q) = %q still contains a %s tag", in, got, name)
+			}
+		}
+		if utf8.ValidString(in) && utf8.ValidString(got) == false {
+			t.Errorf("stripHTML(%q) = %q is not valid UTF-8", in, got)
+		}
+	})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"html"
+	"strings"
+)
+
+// seriesMarcTags are the MARC tags holding series titles: the transcribed series statement
+// and the series added entry, which is usually the same series in authorized form
+var seriesMarcTags = []string{"490", "830"}
+
+// getSeries returns the series titles ($a) of a bib, de-duplicated on the normalized title
+// in first-seen order
+func getSeries(varFields *[]JMRLVarFields) []string {
+	out := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, tag := range seriesMarcTags {
+		for _, val := range getVarField(varFields, tag, "a") {
+			val = strings.TrimSpace(html.UnescapeString(val))
+			val = strings.TrimSpace(strings.TrimRight(stripTrailingData(val), ";,"))
+			key := normalizeMatchText(val)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			out = append(out, val)
+		}
+	}
+	return out
+}
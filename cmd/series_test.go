@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+func TestGetSeries(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []JMRLVarFields
+		want   []string
+	}{
+		{"statement", []JMRLVarFields{marcField("490", "a", "Magic tree house ;")}, []string{"Magic tree house"}},
+		{"added entry duplicate", []JMRLVarFields{marcField("490", "a", "Inspector Gamache novel ;"),
+			marcField("830", "a", "Inspector Gamache novel.")}, []string{"Inspector Gamache novel"}},
+		{"authorized form differs", []JMRLVarFields{marcField("490", "a", "A Gamache mystery"),
+			marcField("830", "a", "Inspector Gamache novel.")}, []string{"A Gamache mystery", "Inspector Gamache novel"}},
+		{"added entry only", []JMRLVarFields{marcField("830", "a", "Dog Man ;")}, []string{"Dog Man"}},
+		{"entities", []JMRLVarFields{marcField("490", "a", "Frog &amp; Toad ,")}, []string{"Frog & Toad"}},
+		{"case", []JMRLVarFields{marcField("490", "a", "WARRIORS"), marcField("490", "a", "Warriors")}, []string{"WARRIORS"}},
+		{"empty", []JMRLVarFields{marcField("490", "a", " ; ")}, []string{}},
+		{"none", []JMRLVarFields{marcField("245", "a", "Dog Man")}, []string{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := getSeries(&tc.fields); reflect.DeepEqual(got, tc.want) == false {
+				t.Errorf("getSeries = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSeriesSearch(t *testing.T) {
+	svc := newTestService(t, nil)
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`series: {magic tree house}`, `s:(magic tree house)`},
+		{`series: {dog man} AND author: {pilkey}`, `s:(dog man) AND a:(pilkey)`},
+		{`(series: {"inspector gamache"} OR title: {gamache}) AND NOT author: {smith}`,
+			`(s:("inspector gamache") OR t:(gamache)) AND NOT a:(smith)`},
+	}
+	for _, tc := range tests {
+		req := jmrlSearchRequest{SearchRequest: v4api.SearchRequest{Query: tc.query, Pagination: v4api.Pagination{Rows: 20}}}
+		xlate, err := svc.translateSearch(req)
+		if err != nil {
+			t.Fatalf("translateSearch(%q) failed: %s", tc.query, err.Message)
+		}
+		if xlate.Query != tc.want {
+			t.Errorf("translateSearch(%q) = %s, want %s", tc.query, xlate.Query, tc.want)
+		}
+	}
+}
+
+// the series field shows why a series search matched, in both views
+func TestSeriesRecordField(t *testing.T) {
+	svc := newTestService(t, nil)
+	bib := testBib("1001", "Dog Man")
+	bib.VarFields = append(bib.VarFields, marcField("490", "a", "Dog Man ;"), marcField("830", "a", "Dog Man."))
+	for _, view := range []string{viewBrief, viewFull} {
+		fields := svc.getResultFields(&bib, fieldOptions{View: view, Language: "en", Localizer: testLocalizer(svc, "en")})
+		if got := fieldValues(fields, "series"); reflect.DeepEqual(got, []string{"Dog Man"}) == false {
+			t.Errorf("%s series = %q, want [Dog Man]", view, got)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -11,33 +12,91 @@ import (
 	"net/http"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/uvalib/virgo4-api/v4api"
 
-	"github.com/BurntSushi/toml"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 	"github.com/uvalib/virgo4-jwt/v4jwt"
-	"golang.org/x/text/language"
 )
 
 // ServiceContext contains common data used by all handlers
 type ServiceContext struct {
 	Version         string
-	API             string
+	Config          *ServiceConfig
+	Sierra          *sierraClient
 	AuthToken       string
 	AccessToken     string
 	AccessExpiresAt time.Time
 	JWTKey          string
 	I18NBundle      *i18n.Bundle
 	HTTPClient      *http.Client
+	Metrics         *ServiceMetrics
+	// Branches is the branch and location list from Sierra; empty until fetched
+	Branches branchDirectory
+	// PlaceholderLocations is the set of location codes that are not real branches
+	PlaceholderLocations map[string]bool
+	// Locations holds the redacted location codes and the branch coordinates
+	Locations   locationSettings
+	LoadedFiles loadedFiles
+	// ZeroResults is nil unless zero result query tracking is enabled
+	ZeroResults       *zeroResultLog
+	IdentifyOverrides identifyOverrides
+	Collators         collatorCache
+	// Probe is nil unless synthetic monitoring probes are configured
+	Probe          *monitorProbe
+	Limiter        *sierraLimiter
+	Suggestions    *ttlCache
+	Feeds          *ttlCache
+	BrowseCounts   *ttlCache
+	PoolStats      poolStatsCache
+	FieldOverrides fieldOverrides
+	StripParams    map[string]bool
+	Suppression    fieldSuppression
+	// OnlineTypes are the material type codes excluded or required by the online preference
+	OnlineTypes []string
+	// BriefCodes are the bib level and BCODE3 codes that always mark a brief record
+	BriefCodes map[string]bool
+	// Faults is nil unless fault injection is enabled
+	Faults *faultInjector
+	// Trending is nil unless trending is enabled
+	Trending        *trendingCounter
+	TrendingRecords *ttlCache
+	// Records is nil unless the record cache is enabled
+	Records *ttlCache
+	// Adaptive is nil unless adaptive paging is enabled
+	Adaptive       *adaptivePaging
+	reloadLock     sync.Mutex
+	reloadStatus   map[string]ReloadStatus
+	staticLock     sync.Mutex
+	staticModified time.Time
+	tokenLock      sync.Mutex
+	tokenBackoff   time.Duration
+	nextTokenTry   time.Time
 }
 
+// minTokenLifetime is the shortest access token lifetime accepted from Sierra. Anything
+// less is treated as a failed authentication to avoid refreshing on every request
+const minTokenLifetime = 30 * time.Second
+
+// token refresh backoff used after failed or degenerate token responses
+const minTokenBackoff = 5 * time.Second
+const maxTokenBackoff = 5 * time.Minute
+
 // RequestError contains http status code and message for and API request
 type RequestError struct {
 	StatusCode int
 	Message    string
+	SierraCode int
+	Code       errorCode
+}
+
+// Error returns the message of the failed request
+func (re *RequestError) Error() string {
+	return re.Message
 }
 
 // InitializeService will initialize the service context based on the config parameters.
@@ -45,7 +104,29 @@ type RequestError struct {
 // Any errors are FATAL.
 func InitializeService(version string, cfg *ServiceConfig) *ServiceContext {
 	log.Printf("Initializing Service")
-	svc := ServiceContext{Version: version, API: cfg.API, JWTKey: cfg.JWTKey}
+	svc := ServiceContext{Version: version, Config: cfg, JWTKey: cfg.JWTKey}
+	sierra, err := newSierraClient(cfg.API)
+	if err != nil {
+		log.Fatalf("Unable to use Sierra API: %s", err.Error())
+	}
+	svc.Sierra = sierra
+	svc.Metrics = NewServiceMetrics()
+	svc.Limiter = newSierraLimiter(cfg.MaxConcurrent, 5*time.Second, svc.Metrics)
+	svc.Suggestions = newTTLCache(suggestTTL, suggestCacheMax)
+	svc.Feeds = newTTLCache(feedTTL, feedCacheMax)
+	svc.BrowseCounts = newTTLCache(browseCountTTL, browseCountCacheMax)
+	if cfg.TrendingHours > 0 {
+		svc.Trending = newTrendingCounter(time.Duration(cfg.TrendingHours) * time.Hour)
+		svc.TrendingRecords = newTTLCache(trendingTTL, trendingCacheMax)
+	}
+	if cfg.RecordCacheSecs > 0 {
+		svc.Records = newTTLCache(time.Duration(cfg.RecordCacheSecs)*time.Second, recordCacheMax)
+	}
+	svc.PlaceholderLocations = parsePlaceholderLocations(cfg.Placeholders)
+	svc.Suppression = parseFieldSuppression(cfg.SuppressFields)
+	svc.StripParams = toCodeSet(strings.Split(strings.ToLower(cfg.StripParams), ","))
+	svc.OnlineTypes = parseMaterialTypes(cfg.OnlineTypes)
+	svc.BriefCodes = parseBriefCodes(cfg.BriefCodes)
 
 	log.Printf("Create HTTP Client")
 	defaultTransport := &http.Transport{
@@ -68,14 +149,60 @@ func InitializeService(version string, cfg *ServiceConfig) *ServiceContext {
 	token := fmt.Sprintf("%s:%s", cfg.APIKey, cfg.APISecret)
 	svc.AuthToken = base64.StdEncoding.EncodeToString([]byte(token))
 
+	if cfg.ZeroResultsMax > 0 {
+		log.Printf("Track up to %d zero result queries", cfg.ZeroResultsMax)
+		svc.ZeroResults = newZeroResultLog(cfg.ZeroResultsMax)
+	}
+
+	if cfg.AdaptiveMS > 0 {
+		log.Printf("Reduce search page sizes while Sierra p95 latency exceeds %dms", cfg.AdaptiveMS)
+		svc.Adaptive = newAdaptivePaging(time.Duration(cfg.AdaptiveMS) * time.Millisecond)
+	}
+
+	if cfg.FaultInjection {
+		log.Printf("WARNING: Sierra fault injection is enabled")
+		svc.Faults = newFaultInjector()
+	}
+
+	probe, err := newMonitorProbe(cfg.MonitorCIDRs, cfg.MonitorSecret)
+	if err != nil {
+		log.Fatalf("Unable to configure monitoring probes: %s", err.Error())
+	}
+	svc.Probe = probe
+
+	if err := svc.reloadLocations(); err != nil {
+		log.Fatalf("Unable to load location configuration: %s", err.Error())
+	}
+
 	log.Printf("Authenticate with JMRL API")
-	svc.getAccessToken()
+	svc.ensureAccessToken()
+
+	// location names fall back to the bib payload if the branch list can't be fetched
+	log.Printf("Fetch Sierra branch list")
+	if err := svc.refreshBranches(context.Background(), time.Now()); err != nil {
+		log.Printf("WARNING: unable to fetch branch list; using location names from Sierra records: %s", err.Error())
+		svc.Metrics.Increment("branch_refresh_failure")
+	}
+	go svc.runBranchRefresh(branchRefreshInterval)
 
 	log.Printf("Init localization")
-	svc.I18NBundle = i18n.NewBundle(language.English)
-	svc.I18NBundle.RegisterUnmarshalFunc("toml", toml.Unmarshal)
-	svc.I18NBundle.MustLoadMessageFile("./i18n/active.en.toml")
-	svc.I18NBundle.MustLoadMessageFile("./i18n/active.es.toml")
+	bundle, msgFiles := newI18NBundle(cfg.I18NDir)
+	svc.I18NBundle = bundle
+	for _, msgFile := range msgFiles {
+		svc.recordLoadedFile("i18n", msgFile)
+	}
+
+	svc.reloadIdentifyOverrides()
+	if err := svc.reloadFieldOverrides(); err != nil {
+		log.Fatalf("Unable to load field overrides: %s", err.Error())
+	}
+	checkCitationMappings()
+	svc.setStaticModified()
+
+	if cfg.StatsHours > 0 {
+		log.Printf("Refresh pool stats every %d hours", cfg.StatsHours)
+		go svc.runPoolStats(time.Duration(cfg.StatsHours) * time.Hour)
+	}
 
 	return &svc
 }
@@ -108,9 +235,7 @@ func (svc *ServiceContext) healthCheck(c *gin.Context) {
 	}
 	hcMap := make(map[string]hcResp)
 
-	// idx := strings.LastIndex(svc.API, "/")
-	// baseURL := svc.API[0:idx]
-	// authURL := fmt.Sprintf("%s/about", baseURL)
+	// authURL := svc.Sierra.AboutURL()
 	// postReq, _ := http.NewRequest("GET", authURL, nil)
 	// postReq.Header.Set("Accept", "application/json")
 	// resp, postErr := svc.HTTPClient.Do(postReq)
@@ -130,25 +255,28 @@ func (svc *ServiceContext) healthCheck(c *gin.Context) {
 
 // IdentifyHandler returns localized identity information for this pool
 func (svc *ServiceContext) identifyHandler(c *gin.Context) {
-	acceptLang := strings.Split(c.GetHeader("Accept-Language"), ",")[0]
-	if acceptLang == "" {
-		acceptLang = "en-US"
-	}
-	log.Printf("Identify request Accept-Language %s", acceptLang)
+	acceptLang := svc.negotiateLanguage(c.GetHeader("Accept-Language"))
+	log.Printf("Identify request language %s", acceptLang)
 	localizer := i18n.NewLocalizer(svc.I18NBundle, acceptLang)
 
 	resp := v4api.PoolIdentity{Attributes: make([]v4api.PoolAttribute, 0)}
-	resp.Name = localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "PoolName"})
-	resp.Description = localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "PoolDescription"})
+	resp.Name = svc.localizeIdentify(localizer, acceptLang, "PoolName")
+	resp.Description = svc.localizeIdentify(localizer, acceptLang, "PoolDescription")
 	resp.Mode = "record"
 
 	resp.Attributes = append(resp.Attributes, v4api.PoolAttribute{Name: "logo_url", Supported: true, Value: "/assets/jmrl_logo.svg"})
 	resp.Attributes = append(resp.Attributes, v4api.PoolAttribute{Name: "external_url", Supported: true, Value: "https://jmrl.org"})
-	resp.Attributes = append(resp.Attributes, v4api.PoolAttribute{Name: "facets", Supported: false})
-	resp.Attributes = append(resp.Attributes, v4api.PoolAttribute{Name: "sorting", Supported: false})
+	resp.Attributes = append(resp.Attributes, v4api.PoolAttribute{Name: "facets", Supported: true})
+	resp.Attributes = append(resp.Attributes, v4api.PoolAttribute{Name: "sorting", Supported: true})
+	resp.Attributes = append(resp.Attributes, v4api.PoolAttribute{Name: "holdings", Supported: true})
+	resp.Attributes = append(resp.Attributes, v4api.PoolAttribute{Name: "branches", Supported: true, Value: "/api/branches"})
 	resp.Attributes = append(resp.Attributes, v4api.PoolAttribute{Name: "item_message", Supported: true, Value: `This resource is not held by the UVA Library. Contact <a href="https://jmrl.org">Jefferson-Madison Regional Library</a> to determine how to gain access.`})
 
-	c.JSON(http.StatusOK, resp)
+	resp.Attributes = append(resp.Attributes, svc.poolStatsAttributes()...)
+	resp.SortOptions = getSortOptions(localizer)
+
+	c.Header("Content-Language", acceptLang)
+	svc.sendCacheableJSON(c, resp)
 }
 
 // getBearerToken is a helper to extract the user auth token from the Auth header
@@ -163,19 +291,33 @@ func getBearerToken(authorization string) (string, error) {
 	return components[1], nil
 }
 
+// isTokenExpired returns true if JWT validation failed only because the token expired
+func isTokenExpired(jwtErr error) bool {
+	var valErr *jwt.ValidationError
+	return errors.As(jwtErr, &valErr) && valErr.Errors == jwt.ValidationErrorExpired
+}
+
 // AuthMiddleware is a middleware handler that verifies presence of a
-// user Bearer token in the Authorization header.
+// user Bearer token in the Authorization header, or a signed monitoring probe.
 func (svc *ServiceContext) authMiddleware(c *gin.Context) {
+	// signed synthetic monitoring probes are accepted in place of a user token.
+	// They are flagged so analytics can exclude them
+	if svc.isSyntheticProbe(c) {
+		log.Printf("Accepted synthetic monitoring probe for %s", c.Request.URL.Path)
+		c.Set("synthetic_probe", true)
+		return
+	}
+
 	tokenStr, err := getBearerToken(c.Request.Header.Get("Authorization"))
 	if err != nil {
 		log.Printf("Authentication failed: [%s]", err.Error())
-		c.AbortWithStatus(http.StatusUnauthorized)
+		sendError(c, http.StatusUnauthorized, errAuthRequired, "authorization is required")
 		return
 	}
 
 	if tokenStr == "undefined" {
 		log.Printf("Authentication failed; bearer token is undefined")
-		c.AbortWithStatus(http.StatusUnauthorized)
+		sendError(c, http.StatusUnauthorized, errAuthRequired, "authorization is required")
 		return
 	}
 
@@ -183,7 +325,11 @@ func (svc *ServiceContext) authMiddleware(c *gin.Context) {
 	v4Claims, jwtErr := v4jwt.Validate(tokenStr, svc.JWTKey)
 	if jwtErr != nil {
 		log.Printf("JWT signature for %s is invalid: %s", tokenStr, jwtErr.Error())
-		c.AbortWithStatus(http.StatusUnauthorized)
+		if isTokenExpired(jwtErr) {
+			sendError(c, http.StatusUnauthorized, errAuthExpired, "authorization has expired")
+		} else {
+			sendError(c, http.StatusUnauthorized, errAuthInvalid, "authorization is invalid")
+		}
 		return
 	}
 
@@ -194,11 +340,11 @@ func (svc *ServiceContext) authMiddleware(c *gin.Context) {
 }
 
 // GetAccess token will POST to the JMRL API /v5/token API to get an access token with an expiration time
-// Results will be stored in the ServiceContext
+// Results will be stored in the ServiceContext. Callers must hold tokenLock.
 func (svc *ServiceContext) getAccessToken() error {
 	log.Printf("Get JMRL access token")
 	startTime := time.Now()
-	authURL := fmt.Sprintf("%s/token", svc.API)
+	authURL := svc.Sierra.TokenURL()
 	postReq, _ := http.NewRequest("POST", authURL, nil)
 	postReq.Header.Set("Authorization", fmt.Sprintf("Basic %s", svc.AuthToken))
 	postResp, postErr := svc.HTTPClient.Do(postReq)
@@ -207,10 +353,9 @@ func (svc *ServiceContext) getAccessToken() error {
 	elapsedMS := int64(elapsedNanoSec / time.Millisecond)
 
 	if respErr != nil {
-		svc.AccessExpiresAt = time.Now()
-		svc.AccessToken = ""
 		log.Printf("ERROR: Failed response from POST %s %d. Elapsed Time: %d (ms). %s",
 			authURL, respErr.StatusCode, elapsedMS, respErr.Message)
+		svc.tokenFailed()
 		return errors.New(respErr.Message)
 	}
 	log.Printf("Successful response from POST %s. Elapsed Time: %d (ms)", authURL, elapsedMS)
@@ -224,38 +369,182 @@ func (svc *ServiceContext) getAccessToken() error {
 	parseErr := json.Unmarshal(respBytes, &authResp)
 	if parseErr != nil {
 		log.Printf("ERROR: Unable to parse auth response: %v", parseErr)
-		svc.AccessExpiresAt = time.Now()
-		svc.AccessToken = ""
+		svc.tokenFailed()
 		return parseErr
 	}
 
+	lifetime := time.Second * time.Duration(authResp.ExpireSeconds)
+	if lifetime < minTokenLifetime {
+		log.Printf("ERROR: Sierra returned a degenerate token lifetime of %d seconds; minimum accepted is %.0f",
+			authResp.ExpireSeconds, minTokenLifetime.Seconds())
+		svc.Metrics.Increment("token_degenerate_lifetime")
+		svc.tokenFailed()
+		return fmt.Errorf("access token lifetime of %d seconds is too short", authResp.ExpireSeconds)
+	}
+
 	log.Printf("Authentication successful, expires in %d seconds", authResp.ExpireSeconds)
 	svc.AccessToken = authResp.AccessToken
-	// flag expire time 15mins before it actually expires to catch any edge timing cases (hopefully)
-	svc.AccessExpiresAt = time.Now().Add(time.Second * time.Duration(authResp.ExpireSeconds-60*15))
+	svc.tokenBackoff = 0
+	svc.nextTokenTry = time.Time{}
+
+	// flag expire time 15mins before it actually expires to catch any edge timing cases (hopefully).
+	// Short lived tokens use a quarter of their lifetime as the margin instead
+	margin := 15 * time.Minute
+	if lifetime < 4*margin {
+		margin = lifetime / 4
+	}
+	svc.AccessExpiresAt = time.Now().Add(lifetime - margin)
 	return nil
 }
 
+// tokenFailed clears the access token and schedules the next permitted refresh attempt
+// using an exponential backoff. Callers must hold tokenLock.
+func (svc *ServiceContext) tokenFailed() {
+	svc.AccessExpiresAt = time.Now()
+	svc.AccessToken = ""
+	if svc.tokenBackoff == 0 {
+		svc.tokenBackoff = minTokenBackoff
+	} else {
+		svc.tokenBackoff *= 2
+		if svc.tokenBackoff > maxTokenBackoff {
+			svc.tokenBackoff = maxTokenBackoff
+		}
+	}
+	svc.nextTokenTry = time.Now().Add(svc.tokenBackoff)
+	svc.Metrics.Increment("token_refresh_failures")
+	log.Printf("WARNING: next access token request will not be attempted for %s", svc.tokenBackoff)
+}
+
+// ensureAccessToken makes sure a current access token is available, refreshing it if
+// necessary. Only one refresh runs at a time and refreshes are suppressed during backoff.
+func (svc *ServiceContext) ensureAccessToken() (string, error) {
+	svc.tokenLock.Lock()
+	defer svc.tokenLock.Unlock()
+	// an injected token failure behaves as an expired token whose refresh failed
+	if svc.Faults.inject(faultTokenFailure) != nil {
+		svc.Metrics.Increment("fault_injected")
+		return "", fmt.Errorf("injected fault: access token refresh failed")
+	}
+	now := time.Now()
+	if svc.AccessToken != "" && now.Before(svc.AccessExpiresAt) {
+		return svc.AccessToken, nil
+	}
+	if now.Before(svc.nextTokenTry) {
+		svc.Metrics.Increment("token_refresh_suppressed")
+		return "", fmt.Errorf("access token unavailable; refresh suppressed until %s", svc.nextTokenTry.Format(time.RFC3339))
+	}
+	log.Printf("Access token has expired; requesting a new one")
+	if err := svc.getAccessToken(); err != nil {
+		return "", err
+	}
+	return svc.AccessToken, nil
+}
+
 // APIGet sends a GET to the JMRL API and returns results a byte array
-func (svc *ServiceContext) apiGet(tgtURL string) ([]byte, *RequestError) {
+// The request is abandoned when ctx ends.
+func (svc *ServiceContext) apiGet(ctx context.Context, tgtURL string) ([]byte, *RequestError) {
+	return svc.tracedAPIGet(ctx, tgtURL, nil)
+}
+
+// tracedAPIGet is apiGet that records the slot wait and upstream timing in the trace.
+// Record busy errors are retried after a short backoff; the Sierra slot is given up
+// while waiting so other requests can use it.
+func (svc *ServiceContext) tracedAPIGet(ctx context.Context, tgtURL string, trace *requestTrace) ([]byte, *RequestError) {
+	for attempt := 0; ; attempt++ {
+		resp, err := svc.slotGet(ctx, tgtURL, trace)
+		if isRecordBusy(err) == false {
+			return resp, err
+		}
+		svc.Metrics.Increment("sierra_record_busy")
+		if attempt >= len(recordBusyBackoff) {
+			log.Printf("ERROR: record busy retries exhausted for GET %s", tgtURL)
+			svc.Metrics.Increment("sierra_record_busy_exhausted")
+			return resp, err
+		}
+		log.Printf("WARNING: record busy for GET %s; retry in %s", tgtURL, recordBusyBackoff[attempt])
+		trace.decision("record busy retry")
+		select {
+		case <-time.After(recordBusyBackoff[attempt]):
+		case <-ctx.Done():
+			return nil, budgetError(tgtURL)
+		}
+	}
+}
+
+// slotGet makes a single Sierra GET once a limiter slot is available and releases the
+// slot when the response is received
+func (svc *ServiceContext) slotGet(ctx context.Context, tgtURL string, trace *requestTrace) ([]byte, *RequestError) {
+	if ctx.Err() != nil {
+		log.Printf("WARNING: request time budget spent before GET %s", tgtURL)
+		trace.attempt(tgtURL, http.StatusRequestTimeout, 0, 0)
+		return nil, budgetError(tgtURL)
+	}
+	wait, ok := svc.Limiter.acquire(ctx)
+	if ok == false && ctx.Err() != nil {
+		log.Printf("WARNING: request time budget spent waiting for a Sierra slot for GET %s", tgtURL)
+		trace.attempt(tgtURL, http.StatusRequestTimeout, wait, 0)
+		return nil, budgetError(tgtURL)
+	}
+	if ok == false {
+		log.Printf("ERROR: no Sierra request slot available for GET %s", tgtURL)
+		svc.Metrics.Increment("sierra_limit_timeout")
+		trace.attempt(tgtURL, http.StatusServiceUnavailable, wait, 0)
+		return nil, &RequestError{StatusCode: http.StatusServiceUnavailable, Message: "JMRL is busy; please try again", Code: errRateLimited}
+	}
+	defer svc.Limiter.release()
+	getStart := time.Now()
+	resp, err := svc.sierraGet(ctx, tgtURL)
+	status := http.StatusOK
+	if err != nil {
+		status = err.StatusCode
+	}
+	trace.attempt(tgtURL, status, wait, time.Since(getStart))
+	return resp, err
+}
+
+// sierraRecordBusy is the Sierra error code returned with a 500 when a record is locked
+// by a circulation transaction. The lock is brief, so these requests are retried.
+const sierraRecordBusy = 130
+
+// recordBusyBackoff is the delay before each retry of a record busy request
+var recordBusyBackoff = []time.Duration{200 * time.Millisecond, 400 * time.Millisecond}
+
+// isRecordBusy returns true if the error is the Sierra record busy error
+func isRecordBusy(err *RequestError) bool {
+	return err != nil && err.StatusCode == http.StatusInternalServerError && err.SierraCode == sierraRecordBusy
+}
+
+// sierraGet performs an authenticated Sierra GET that is abandoned when ctx ends. Callers
+// must hold a limiter slot. The time taken is recorded in the sierra_upstream histogram.
+func (svc *ServiceContext) sierraGet(ctx context.Context, tgtURL string) ([]byte, *RequestError) {
 	log.Printf("JMRL API GET request: %s", tgtURL)
 	startTime := time.Now()
-	if startTime.After(svc.AccessExpiresAt) {
-		log.Printf("Access token has expired; requesting a new one")
-		authErr := svc.getAccessToken()
-		if authErr != nil {
-			return nil, &RequestError{StatusCode: 401, Message: authErr.Error()}
-		}
+	accessToken, authErr := svc.ensureAccessToken()
+	if authErr != nil {
+		return nil, &RequestError{StatusCode: 401, Message: authErr.Error()}
+	}
+
+	truncate, faultErr := svc.injectRequestFault(ctx, tgtURL)
+	if faultErr != nil {
+		log.Printf("ERROR: Failed response from GET %s %d. %s", tgtURL, faultErr.StatusCode, faultErr.Message)
+		return nil, faultErr
 	}
 
-	getReq, _ := http.NewRequest("GET", tgtURL, nil)
+	getReq, _ := http.NewRequestWithContext(ctx, "GET", tgtURL, nil)
 	getReq.Header.Set("deleted", "false")
 	getReq.Header.Set("suppressed", "false")
-	getReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", svc.AccessToken))
+	getReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 	rawResp, rawErr := svc.HTTPClient.Do(getReq)
 	resp, err := handleAPIResponse(tgtURL, rawResp, rawErr)
+	if err != nil && ctx.Err() != nil {
+		err = budgetError(tgtURL)
+	}
 	elapsedNanoSec := time.Since(startTime)
 	elapsedMS := int64(elapsedNanoSec / time.Millisecond)
+	svc.Metrics.Observe("sierra_upstream", elapsedNanoSec)
+	if truncate && err == nil {
+		resp = resp[:len(resp)/2]
+	}
 
 	if err != nil {
 		log.Printf("ERROR: Failed response from GET %s %d. Elapsed Time: %d (ms). %s",
@@ -283,7 +572,12 @@ func handleAPIResponse(URL string, resp *http.Response, err error) ([]byte, *Req
 		bodyBytes, _ := ioutil.ReadAll(resp.Body)
 		status := resp.StatusCode
 		errMsg := string(bodyBytes)
-		return nil, &RequestError{StatusCode: status, Message: errMsg}
+		reqErr := &RequestError{StatusCode: status, Message: errMsg}
+		var sierraErr SierraError
+		if json.Unmarshal(bodyBytes, &sierraErr) == nil {
+			reqErr.SierraCode = sierraErr.Code
+		}
+		return nil, reqErr
 	}
 
 	defer resp.Body.Close()
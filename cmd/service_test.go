@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestZeroTokenLifetimeBoundsRefreshes(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("token", http.StatusOK, map[string]interface{}{"access_token": "degenerate", "expires_in": 0})
+	svc := newTestService(t, sierra)
+
+	// a burst of requests within the backoff window makes a single token request
+	for i := 0; i < 50; i++ {
+		if _, err := svc.ensureAccessToken(); err == nil {
+			t.Fatalf("request %d: a zero lifetime token was accepted", i)
+		}
+	}
+	if got := sierra.count("token"); got != 1 {
+		t.Fatalf("token requests in the backoff window = %d, want 1", got)
+	}
+	metrics := svc.Metrics.Snapshot()
+	if metrics["token_degenerate_lifetime"] != 1 || metrics["token_refresh_suppressed"] != 49 {
+		t.Errorf("metrics = %v, want 1 degenerate lifetime and 49 suppressed refreshes", metrics)
+	}
+	if svc.tokenBackoff != minTokenBackoff {
+		t.Errorf("backoff = %s, want %s", svc.tokenBackoff, minTokenBackoff)
+	}
+
+	// once the window passes one more attempt is made and the backoff doubles
+	svc.nextTokenTry = time.Now().Add(-time.Millisecond)
+	for i := 0; i < 10; i++ {
+		svc.ensureAccessToken()
+	}
+	if got := sierra.count("token"); got != 2 {
+		t.Errorf("token requests after the first window = %d, want 2", got)
+	}
+	if svc.tokenBackoff != 2*minTokenBackoff {
+		t.Errorf("backoff = %s, want %s", svc.tokenBackoff, 2*minTokenBackoff)
+	}
+}
+
+func TestTokenBackoffIsCapped(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("token", http.StatusOK, map[string]interface{}{"access_token": "degenerate", "expires_in": 1})
+	svc := newTestService(t, sierra)
+	for i := 0; i < 12; i++ {
+		svc.nextTokenTry = time.Time{}
+		svc.ensureAccessToken()
+	}
+	if svc.tokenBackoff != maxTokenBackoff {
+		t.Errorf("backoff = %s, want the %s cap", svc.tokenBackoff, maxTokenBackoff)
+	}
+}
+
+func TestMinimumTokenLifetimeAccepted(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("token", http.StatusOK, map[string]interface{}{"access_token": "short", "expires_in": 30})
+	svc := newTestService(t, sierra)
+	token, err := svc.ensureAccessToken()
+	if err != nil || token != "short" {
+		t.Fatalf("ensureAccessToken = %q, %v; want the 30 second token", token, err)
+	}
+	// short lived tokens are refreshed a quarter of their lifetime early
+	remaining := time.Until(svc.AccessExpiresAt)
+	if remaining > 23*time.Second || remaining < 21*time.Second {
+		t.Errorf("token refresh due in %s, want about 22.5s", remaining)
+	}
+	for i := 0; i < 5; i++ {
+		svc.ensureAccessToken()
+	}
+	if got := sierra.count("token"); got != 1 {
+		t.Errorf("token requests = %d, want 1", got)
+	}
+}
+
+func TestIsRecordBusy(t *testing.T) {
+	tests := []struct {
+		err  *RequestError
+		want bool
+	}{
+		{&RequestError{StatusCode: http.StatusInternalServerError, SierraCode: sierraRecordBusy}, true},
+		{&RequestError{StatusCode: http.StatusInternalServerError, SierraCode: 109}, false},
+		{&RequestError{StatusCode: http.StatusInternalServerError}, false},
+		{&RequestError{StatusCode: http.StatusConflict, SierraCode: sierraRecordBusy}, false},
+		{nil, false},
+	}
+	for _, tc := range tests {
+		if got := isRecordBusy(tc.err); got != tc.want {
+			t.Errorf("isRecordBusy(%+v) = %t, want %t", tc.err, got, tc.want)
+		}
+	}
+}
+
+// scriptedBib serves bib 1001 with the statuses in order; the last one repeats. Errors
+// carry the Sierra code.
+func scriptedBib(t *testing.T, sierraCode int, statuses ...int) *fakeSierra {
+	t.Helper()
+	sierra := newFakeSierra(t)
+	var lock sync.Mutex
+	calls := 0
+	sierra.handle("bibs/1001", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		status := statuses[len(statuses)-1]
+		if calls < len(statuses) {
+			status = statuses[calls]
+		}
+		calls++
+		lock.Unlock()
+		if status == http.StatusOK {
+			writeTestJSON(w, status, testBib("1001", "Cats"))
+			return
+		}
+		writeTestJSON(w, status, SierraError{Code: sierraCode, HTTPStatus: status, Name: "Record is busy"})
+	})
+	sierra.handleJSON("items", http.StatusOK, map[string]interface{}{"total": 0, "entries": []interface{}{}})
+	return sierra
+}
+
+func TestResourceRecordBusyRetry(t *testing.T) {
+	fastRecordBusyRetries(t)
+	tests := []struct {
+		name       string
+		sierraCode int
+		statuses   []int
+		code       int
+		fetches    int
+		busy       int
+		exhausted  int
+	}{
+		{"first try", sierraRecordBusy, []int{200}, http.StatusOK, 1, 0, 0},
+		{"one retry", sierraRecordBusy, []int{500, 200}, http.StatusOK, 2, 1, 0},
+		{"two retries", sierraRecordBusy, []int{500, 500, 200}, http.StatusOK, 3, 2, 0},
+		{"retries exhausted", sierraRecordBusy, []int{500}, http.StatusInternalServerError, 3, 3, 1},
+		{"other server error", 109, []int{500, 200}, http.StatusInternalServerError, 1, 0, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sierra := scriptedBib(t, tc.sierraCode, tc.statuses...)
+			svc := newTestService(t, sierra)
+			rec := apiRequest(t, newRouter(svc), http.MethodGet, "/api/resource/1001", "")
+			if rec.Code != tc.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.code, rec.Body.String())
+			}
+			if n := sierra.count("bibs/1001"); n != tc.fetches {
+				t.Errorf("%d bib fetches, want %d", n, tc.fetches)
+			}
+			metrics := svc.Metrics.Snapshot()
+			if metrics["sierra_record_busy"] != int64(tc.busy) || metrics["sierra_record_busy_exhausted"] != int64(tc.exhausted) {
+				t.Errorf("record busy %d exhausted %d, want %d and %d", metrics["sierra_record_busy"],
+					metrics["sierra_record_busy_exhausted"], tc.busy, tc.exhausted)
+			}
+			if svc.Limiter.inUse() != 0 {
+				t.Errorf("%d Sierra slots still in use", svc.Limiter.inUse())
+			}
+		})
+	}
+}
+
+// a request waiting to retry a busy record does not hold the only Sierra slot
+func TestRecordBusyBackoffReleasesSlot(t *testing.T) {
+	saved := recordBusyBackoff
+	recordBusyBackoff = []time.Duration{300 * time.Millisecond}
+	t.Cleanup(func() { recordBusyBackoff = saved })
+	sierra := scriptedBib(t, sierraRecordBusy, 500, 200)
+	cfg := newTestConfig(sierra.apiURL())
+	cfg.MaxConcurrent = 1
+	svc := newTestServiceWithConfig(t, cfg)
+
+	done := make(chan *RequestError)
+	go func() {
+		_, err := svc.apiGet(context.Background(), svc.Sierra.BibURL("1001", ""))
+		done <- err
+	}()
+	deadline := time.Now().Add(time.Second)
+	for sierra.count("bibs/1001") == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, ok := svc.Limiter.acquire(ctx); ok == false {
+		t.Fatalf("the Sierra slot was held during the record busy backoff")
+	}
+	svc.Limiter.release()
+	if err := <-done; err != nil {
+		t.Fatalf("retried request failed: %s", err.Message)
+	}
+	if n := sierra.count("bibs/1001"); n != 2 {
+		t.Errorf("%d bib fetches, want 2", n)
+	}
+}
+
+// the backoff ends early, with a budget error, when the request time budget is spent
+func TestRecordBusyBackoffRespectsBudget(t *testing.T) {
+	saved := recordBusyBackoff
+	recordBusyBackoff = []time.Duration{time.Minute}
+	t.Cleanup(func() { recordBusyBackoff = saved })
+	sierra := scriptedBib(t, sierraRecordBusy, 500, 200)
+	svc := newTestService(t, sierra)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := svc.apiGet(ctx, svc.Sierra.BibURL("1001", ""))
+	if err == nil || isBudgetExceeded(err) == false {
+		t.Fatalf("apiGet = %v, want a budget error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("backoff took %s after the budget was spent", elapsed)
+	}
+	if n := sierra.count("bibs/1001"); n != 1 {
+		t.Errorf("%d bib fetches, want 1", n)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// bibFields is the list of bib fields requested for search results and resource details
+const bibFields = "default,varFields,fixedFields,locations,available"
+
+// defaultPageSize is the number of search results returned when the request does not specify rows
+const defaultPageSize = 20
+
+// sierraMaxLimit is the largest limit Sierra accepts for a single bib search request
+const sierraMaxLimit = 50
+
+// sierraMaxListLimit is the largest limit Sierra accepts for a bibs list request
+const sierraMaxListLimit = 2000
+
+// maxSearchRows is the most rows one search returns. Pages larger than sierraMaxLimit
+// are fetched from Sierra in several requests.
+const maxSearchRows = 200
+
+// effectivePageSize returns the number of rows to return for the requested rows. Zero or
+// negative rows use the default, and large values are clamped to maxSearchRows
+func effectivePageSize(rows int) int {
+	if rows <= 0 {
+		return defaultPageSize
+	}
+	if rows > maxSearchRows {
+		return maxSearchRows
+	}
+	return rows
+}
+
+// sierraClient owns the Sierra API base URL and builds all upstream endpoint URLs
+type sierraClient struct {
+	baseURL *url.URL
+}
+
+// newSierraClient parses and validates the Sierra API base URL. The base URL is
+// expected to include the API version, e.g. https://host/iii/sierra-api/v5
+func newSierraClient(apiURL string) (*sierraClient, error) {
+	parsed, err := url.Parse(strings.TrimSpace(apiURL))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sierra API URL %s: %s", apiURL, err.Error())
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("Sierra API URL %s must be http or https", apiURL)
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("Sierra API URL %s has no host", apiURL)
+	}
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return nil, fmt.Errorf("Sierra API URL %s must not contain a query or fragment", apiURL)
+	}
+	parsed.Path = strings.TrimRight(parsed.Path, "/")
+	sc := &sierraClient{baseURL: parsed}
+
+	// make sure every endpoint produces a parsable URL before accepting the base
+	for _, tgt := range []string{sc.TokenURL(), sc.AboutURL(), sc.BibURL("1", bibFields), sc.SearchURL(url.Values{"text": {"test"}})} {
+		if _, err := url.ParseRequestURI(tgt); err != nil {
+			return nil, fmt.Errorf("Sierra API URL %s produces invalid endpoint %s: %s", apiURL, tgt, err.Error())
+		}
+	}
+	return sc, nil
+}
+
+// endpoint builds a URL for an escaped path relative to the API base with optional query params
+func (sc *sierraClient) endpoint(relPath string, params url.Values) string {
+	tgt := *sc.baseURL
+	tgt.RawPath = sc.baseURL.EscapedPath() + "/" + strings.TrimLeft(relPath, "/")
+	tgt.Path, _ = url.PathUnescape(tgt.RawPath)
+	tgt.RawQuery = ""
+	if len(params) > 0 {
+		tgt.RawQuery = params.Encode()
+	}
+	return tgt.String()
+}
+
+// TokenURL returns the URL used to request an access token
+func (sc *sierraClient) TokenURL() string {
+	return sc.endpoint("token", nil)
+}
+
+// AboutURL returns the unversioned Sierra about URL, which sits next to the version path
+func (sc *sierraClient) AboutURL() string {
+	tgt := *sc.baseURL
+	tgt.Path = path.Join(path.Dir(sc.baseURL.Path), "about")
+	return tgt.String()
+}
+
+// SearchURL returns the bib search URL with the supplied query params
+func (sc *sierraClient) SearchURL(params url.Values) string {
+	return sc.endpoint("bibs/search", params)
+}
+
+// BibsURL returns the bibs list URL with the supplied query params
+func (sc *sierraClient) BibsURL(params url.Values) string {
+	return sc.endpoint("bibs", params)
+}
+
+// BibURL returns the URL to get a single bib with the requested fields
+func (sc *sierraClient) BibURL(id string, fields string) string {
+	params := url.Values{}
+	if fields != "" {
+		params.Set("fields", fields)
+	}
+	return sc.endpoint("bibs/"+url.PathEscape(id), params)
+}
+
+// ItemsURL returns the URL to get all items attached to the specified bibs
+func (sc *sierraClient) ItemsURL(bibIDs []string, fields string) string {
+	params := url.Values{}
+	params.Set("bibIds", strings.Join(bibIDs, ","))
+	params.Set("deleted", "false")
+	params.Set("suppressed", "false")
+	if fields != "" {
+		params.Set("fields", fields)
+	}
+	return sc.endpoint("items", params)
+}
+
+// BranchesURL returns the branch list URL with the supplied query params
+func (sc *sierraClient) BranchesURL(params url.Values) string {
+	return sc.endpoint("branches", params)
+}
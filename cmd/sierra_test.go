@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestSierraClientURLs(t *testing.T) {
+	for _, base := range []string{"https://sierra.example.org/iii/sierra-api/v6", " https://sierra.example.org/iii/sierra-api/v6/ "} {
+		sc, err := newSierraClient(base)
+		if err != nil {
+			t.Fatalf("newSierraClient(%q) failed: %s", base, err.Error())
+		}
+		tests := []struct {
+			name string
+			got  string
+			want string
+		}{
+			{"token", sc.TokenURL(), "https://sierra.example.org/iii/sierra-api/v6/token"},
+			{"about", sc.AboutURL(), "https://sierra.example.org/iii/sierra-api/about"},
+			{"search", sc.SearchURL(url.Values{"text": {"keyword: {cats}"}, "limit": {"20"}}),
+				"https://sierra.example.org/iii/sierra-api/v6/bibs/search?limit=20&text=keyword%3A+%7Bcats%7D"},
+			{"bibs", sc.BibsURL(url.Values{"id": {"1001,1002"}}), "https://sierra.example.org/iii/sierra-api/v6/bibs?id=1001%2C1002"},
+			{"bib", sc.BibURL("1001", bibFields),
+				"https://sierra.example.org/iii/sierra-api/v6/bibs/1001?fields=default%2CvarFields%2CfixedFields%2Clocations%2Cavailable"},
+			{"bib without fields", sc.BibURL("1001", ""), "https://sierra.example.org/iii/sierra-api/v6/bibs/1001"},
+			{"bib escaped id", sc.BibURL("10/01", ""), "https://sierra.example.org/iii/sierra-api/v6/bibs/10%2F01"},
+			{"items", sc.ItemsURL([]string{"1001", "1002"}, "status,location"),
+				"https://sierra.example.org/iii/sierra-api/v6/items?bibIds=1001%2C1002&deleted=false&fields=status%2Clocation&suppressed=false"},
+			{"items without fields", sc.ItemsURL([]string{"1001"}, ""),
+				"https://sierra.example.org/iii/sierra-api/v6/items?bibIds=1001&deleted=false&suppressed=false"},
+			{"branches", sc.BranchesURL(url.Values{"fields": {"name,locations"}}),
+				"https://sierra.example.org/iii/sierra-api/v6/branches?fields=name%2Clocations"},
+			{"branches without params", sc.BranchesURL(nil), "https://sierra.example.org/iii/sierra-api/v6/branches"},
+		}
+		for _, tc := range tests {
+			if tc.got != tc.want {
+				t.Errorf("%s URL for base %q = %s, want %s", tc.name, base, tc.got, tc.want)
+			}
+		}
+	}
+}
+
+func TestSierraClientPortBase(t *testing.T) {
+	sc, err := newSierraClient("http://localhost:8443/iii/sierra-api/v5")
+	if err != nil {
+		t.Fatalf("newSierraClient failed: %s", err.Error())
+	}
+	if got, want := sc.TokenURL(), "http://localhost:8443/iii/sierra-api/v5/token"; got != want {
+		t.Errorf("TokenURL = %s, want %s", got, want)
+	}
+	if got, want := sc.AboutURL(), "http://localhost:8443/iii/sierra-api/about"; got != want {
+		t.Errorf("AboutURL = %s, want %s", got, want)
+	}
+}
+
+func TestSierraClientRejectsInvalidBase(t *testing.T) {
+	for _, base := range []string{
+		"",
+		"sierra.example.org/iii/sierra-api/v6",
+		"ftp://sierra.example.org/iii/sierra-api/v6",
+		"https:///iii/sierra-api/v6",
+		"https://sierra.example.org/iii/sierra-api/v6?debug=1",
+		"https://sierra.example.org/iii/sierra-api/v6#top",
+		"https://sierra example.org/iii/sierra-api/v6",
+		"://missing-scheme",
+	} {
+		if sc, err := newSierraClient(base); err == nil {
+			t.Errorf("newSierraClient(%q) = %s, want error", base, sc.TokenURL())
+		}
+	}
+}
+
+func TestEffectivePageSize(t *testing.T) {
+	tests := []struct {
+		rows int
+		want int
+	}{
+		{0, defaultPageSize},
+		{-5, defaultPageSize},
+		{1, 1},
+		{sierraMaxLimit, sierraMaxLimit},
+		{maxSearchRows, maxSearchRows},
+		{maxSearchRows + 1, maxSearchRows},
+	}
+	for _, tc := range tests {
+		if got := effectivePageSize(tc.rows); got != tc.want {
+			t.Errorf("effectivePageSize(%d) = %d, want %d", tc.rows, got, tc.want)
+		}
+	}
+}
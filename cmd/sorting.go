@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// sortNewest is the sort ID for ordering by the date a bib was added to the catalog
+const sortNewest = "SortNewest"
+
+// sierraSortFields maps V4 sort IDs onto the Sierra bib search sort field. Relevance
+// has no Sierra field; it is the default ordering of a text search.
+var sierraSortFields = map[string]string{
+	v4api.SortRelevance.String(): "",
+	v4api.SortTitle.String():     "title",
+	v4api.SortAuthor.String():    "author",
+	v4api.SortDate.String():      "publishYear",
+	sortNewest:                   "createdDate",
+}
+
+// resolveSort validates the requested sort and returns the ordering that will actually be
+// applied. An empty sort means relevance. Unsupported combinations, like ascending
+// relevance, fall back to relevance. An error is returned for an unknown sort ID.
+func resolveSort(req v4api.SortOrder) (v4api.SortOrder, error) {
+	relevance := v4api.SortOrder{SortID: v4api.SortRelevance.String(), Order: "desc"}
+	if req.SortID == "" {
+		return relevance, nil
+	}
+	if req.SortID == sortNearest {
+		// distance is applied page-locally after the search; Sierra ordering stays relevance
+		return v4api.SortOrder{SortID: sortNearest, Order: "asc"}, nil
+	}
+	if _, known := sierraSortFields[req.SortID]; known == false {
+		return relevance, fmt.Errorf("unsupported sort field [%s]", req.SortID)
+	}
+	if req.SortID == v4api.SortRelevance.String() {
+		if req.Order != "" && req.Order != "desc" {
+			log.Printf("WARNING: relevance sort order %s is not supported; using desc", req.Order)
+		}
+		return relevance, nil
+	}
+	order := req.Order
+	if req.SortID == sortNewest && order == "" {
+		order = "desc"
+	}
+	if order != "asc" && order != "desc" {
+		log.Printf("WARNING: unsupported sort order [%s] for %s; using relevance", req.Order, req.SortID)
+		return relevance, nil
+	}
+	return v4api.SortOrder{SortID: req.SortID, Order: order}, nil
+}
+
+// applySierraSort adds the Sierra sort parameters for the resolved sort. Nothing is added
+// for relevance (or distance) ordering.
+func applySierraSort(params url.Values, sort v4api.SortOrder) {
+	field := sierraSortFields[sort.SortID]
+	if field == "" {
+		return
+	}
+	params.Set("sort", field)
+	params.Set("sortOrder", sort.Order)
+}
+
+// getSortOptions returns the localized sort options supported by this pool. Relevance
+// has no direction; the others carry localized labels for each direction.
+func getSortOptions(localizer *i18n.Localizer) []v4api.SortOption {
+	msg := func(id string) string {
+		return localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: id})
+	}
+	out := make([]v4api.SortOption, 0)
+	out = append(out, v4api.SortOption{ID: v4api.SortRelevance.String(), Label: msg("SortRelevance")})
+	out = append(out, v4api.SortOption{ID: v4api.SortTitle.String(), Label: msg("SortTitle"),
+		Asc: msg("SortAlphaAsc"), Desc: msg("SortAlphaDesc")})
+	out = append(out, v4api.SortOption{ID: v4api.SortAuthor.String(), Label: msg("SortAuthor"),
+		Asc: msg("SortAlphaAsc"), Desc: msg("SortAlphaDesc")})
+	out = append(out, v4api.SortOption{ID: v4api.SortDate.String(), Label: msg("SortDate"),
+		Asc: msg("SortDateAsc"), Desc: msg("SortDateDesc")})
+	out = append(out, v4api.SortOption{ID: sortNewest, Label: msg("SortNewest"),
+		Asc: msg("SortDateAsc"), Desc: msg("SortDateDesc")})
+	return out
+}
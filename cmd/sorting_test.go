@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+func TestResolveSort(t *testing.T) {
+	relevance := v4api.SortOrder{SortID: v4api.SortRelevance.String(), Order: "desc"}
+	tests := []struct {
+		name  string
+		req   v4api.SortOrder
+		want  v4api.SortOrder
+		fails bool
+	}{
+		{"default", v4api.SortOrder{}, relevance, false},
+		{"relevance", v4api.SortOrder{SortID: v4api.SortRelevance.String(), Order: "desc"}, relevance, false},
+		{"ascending relevance", v4api.SortOrder{SortID: v4api.SortRelevance.String(), Order: "asc"}, relevance, false},
+		{"title asc", v4api.SortOrder{SortID: v4api.SortTitle.String(), Order: "asc"}, v4api.SortOrder{SortID: v4api.SortTitle.String(), Order: "asc"}, false},
+		{"author desc", v4api.SortOrder{SortID: v4api.SortAuthor.String(), Order: "desc"}, v4api.SortOrder{SortID: v4api.SortAuthor.String(), Order: "desc"}, false},
+		{"date asc", v4api.SortOrder{SortID: v4api.SortDate.String(), Order: "asc"}, v4api.SortOrder{SortID: v4api.SortDate.String(), Order: "asc"}, false},
+		{"date without order", v4api.SortOrder{SortID: v4api.SortDate.String()}, relevance, false},
+		{"newest without order", v4api.SortOrder{SortID: sortNewest}, v4api.SortOrder{SortID: sortNewest, Order: "desc"}, false},
+		{"bad order", v4api.SortOrder{SortID: v4api.SortTitle.String(), Order: "sideways"}, relevance, false},
+		{"nearest", v4api.SortOrder{SortID: sortNearest, Order: "desc"}, v4api.SortOrder{SortID: sortNearest, Order: "asc"}, false},
+		{"unknown", v4api.SortOrder{SortID: "SortPopularity", Order: "desc"}, relevance, true},
+	}
+	for _, tc := range tests {
+		got, err := resolveSort(tc.req)
+		if (err != nil) != tc.fails || got != tc.want {
+			t.Errorf("%s: resolveSort = %+v %v, want %+v fails %t", tc.name, got, err, tc.want, tc.fails)
+		}
+	}
+}
+
+func TestApplySierraSort(t *testing.T) {
+	tests := []struct {
+		sort  v4api.SortOrder
+		field string
+		order string
+	}{
+		{v4api.SortOrder{SortID: v4api.SortRelevance.String(), Order: "desc"}, "", ""},
+		{v4api.SortOrder{SortID: sortNearest, Order: "asc"}, "", ""},
+		{v4api.SortOrder{SortID: v4api.SortTitle.String(), Order: "asc"}, "title", "asc"},
+		{v4api.SortOrder{SortID: v4api.SortAuthor.String(), Order: "desc"}, "author", "desc"},
+		{v4api.SortOrder{SortID: v4api.SortDate.String(), Order: "desc"}, "publishYear", "desc"},
+		{v4api.SortOrder{SortID: sortNewest, Order: "desc"}, "createdDate", "desc"},
+	}
+	for _, tc := range tests {
+		params := url.Values{}
+		applySierraSort(params, tc.sort)
+		if params.Get("sort") != tc.field || params.Get("sortOrder") != tc.order {
+			t.Errorf("applySierraSort(%+v) = %v, want sort %q order %q", tc.sort, params, tc.field, tc.order)
+		}
+	}
+}
+
+func TestIdentifyAdvertisesSorting(t *testing.T) {
+	svc := newTestService(t, nil)
+	c, rec := newTestContext(http.MethodGet, "/identify", nil)
+	c.Request.Header.Set("Accept-Language", "es")
+	svc.identifyHandler(c)
+	var identity v4api.PoolIdentity
+	decodeTestJSON(t, rec, &identity)
+	sorting := false
+	for _, attr := range identity.Attributes {
+		if attr.Name == "sorting" {
+			sorting = attr.Supported
+		}
+	}
+	if sorting == false {
+		t.Errorf("identify does not advertise sorting")
+	}
+	ids := make(map[string]bool)
+	for _, opt := range identity.SortOptions {
+		ids[opt.ID] = true
+		if opt.Label == "" || opt.Label == opt.ID {
+			t.Errorf("sort option %s has no localized label", opt.ID)
+		}
+		if opt.ID != v4api.SortRelevance.String() && (opt.Asc == "" || opt.Desc == "") {
+			t.Errorf("sort option %s has no direction labels", opt.ID)
+		}
+	}
+	for id := range sierraSortFields {
+		if ids[id] == false {
+			t.Errorf("sort %s is not advertised", id)
+		}
+	}
+}
+
+func TestSearchSort(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(0))
+	router := newRouter(newTestService(t, sierra))
+
+	tests := []struct {
+		body  string
+		code  int
+		field string
+		order string
+	}{
+		{`{"query":"keyword: {cats}","sort":{"sort_id":"SortTitle","order":"desc"}}`, http.StatusOK, "title", "desc"},
+		{`{"query":"keyword: {cats}","sort":{"sort_id":"SortRelevance","order":"desc"}}`, http.StatusOK, "", ""},
+		{`{"query":"keyword: {cats}","sort":{"sort_id":"SortPopularity","order":"desc"}}`, http.StatusBadRequest, "", ""},
+	}
+	for _, tc := range tests {
+		before := sierra.count("bibs/search")
+		rec := apiRequest(t, router, http.MethodPost, "/api/search", tc.body)
+		if rec.Code != tc.code {
+			t.Fatalf("%s: status = %d, want %d: %s", tc.body, rec.Code, tc.code, rec.Body.String())
+		}
+		if tc.code != http.StatusOK {
+			var resp struct {
+				ErrorCode     errorCode `json:"error_code"`
+				StatusMessage string    `json:"status_msg"`
+			}
+			decodeTestJSON(t, rec, &resp)
+			if resp.ErrorCode != errSortUnsupported || resp.StatusMessage == "" {
+				t.Errorf("%s: error = %+v", tc.body, resp)
+			}
+			if sierra.count("bibs/search") != before {
+				t.Errorf("%s: Sierra was searched", tc.body)
+			}
+			continue
+		}
+		urls := sierra.requestURLs()
+		sent, _ := url.Parse(urls[len(urls)-1])
+		if sent.Query().Get("sort") != tc.field || sent.Query().Get("sortOrder") != tc.order {
+			t.Errorf("%s: Sierra sort = %s", tc.body, sent.RawQuery)
+		}
+	}
+}
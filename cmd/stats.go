@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// PoolStats are the approximate collection statistics reported in identify
+type PoolStats struct {
+	CollectionSize int
+	OnlineShare    float64
+	ComputedAt     time.Time
+}
+
+// poolStatsCache holds the most recently computed statistics. Failed refreshes leave
+// the previous values in place, so stale stats keep being served with their timestamp.
+type poolStatsCache struct {
+	lock  sync.Mutex
+	stats *PoolStats
+}
+
+func (pc *poolStatsCache) get() *PoolStats {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	return pc.stats
+}
+
+func (pc *poolStatsCache) set(stats *PoolStats) {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	pc.stats = stats
+}
+
+// countBibs returns the Sierra hit count for a text query
+func (svc *ServiceContext) countBibs(ctx context.Context, query string, trace *requestTrace) (int, *RequestError) {
+	return svc.countSearch(ctx, url.Values{"text": {query}}, trace)
+}
+
+// countSearch returns the Sierra hit count for the params of a search. The params are
+// not modified.
+func (svc *ServiceContext) countSearch(ctx context.Context, params url.Values, trace *requestTrace) (int, *RequestError) {
+	countParams := url.Values{}
+	for key, vals := range params {
+		countParams[key] = vals
+	}
+	countParams.Del("offset")
+	countParams.Set("limit", "1")
+	countParams.Set("fields", "id")
+	resp, err := svc.searchBibs(ctx, countParams, trace)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Total, nil
+}
+
+// refreshPoolStats computes the collection size and e-material share with count queries
+func (svc *ServiceContext) refreshPoolStats(ctx context.Context, now time.Time) error {
+	total, err := svc.countBibs(ctx, "(*)", nil)
+	if err != nil {
+		return fmt.Errorf("collection count failed: %s", err.Message)
+	}
+	// the share counts the same material types as the online preferences
+	online := 0
+	if onlineQ := onlineRestriction(jmrlSearchPreferences{OnlineOnly: true}, svc.OnlineTypes); onlineQ != "" {
+		online, err = svc.countBibs(ctx, fmt.Sprintf("(*) AND %s", onlineQ), nil)
+		if err != nil {
+			return fmt.Errorf("e-material count failed: %s", err.Message)
+		}
+	}
+	stats := &PoolStats{CollectionSize: total, ComputedAt: now.UTC()}
+	if total > 0 {
+		stats.OnlineShare = float64(online) / float64(total)
+	}
+	svc.PoolStats.set(stats)
+	svc.setStaticModified()
+	log.Printf("Pool stats refreshed: %d records, %.3f online", stats.CollectionSize, stats.OnlineShare)
+	return nil
+}
+
+// statsDelay returns the wait before the next stats refresh: the interval plus or minus
+// up to 10% jitter, so replicas don't all query Sierra at once
+func statsDelay(interval time.Duration, rnd *rand.Rand) time.Duration {
+	jitter := interval / 10
+	if jitter <= 0 {
+		return interval
+	}
+	return interval - jitter + time.Duration(rnd.Int63n(int64(2*jitter)))
+}
+
+// statsClock is the time source of the pool stats schedule, so tests can use a fake
+// clock. wait blocks for the duration and returns false when the schedule should stop.
+type statsClock interface {
+	now() time.Time
+	wait(d time.Duration) bool
+}
+
+// systemClock is the real statsClock; it never stops
+type systemClock struct{}
+
+func (systemClock) now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) wait(d time.Duration) bool {
+	time.Sleep(d)
+	return true
+}
+
+// runPoolStats refreshes the pool stats in the background forever
+func (svc *ServiceContext) runPoolStats(interval time.Duration) {
+	svc.schedulePoolStats(interval, systemClock{}, rand.New(rand.NewSource(time.Now().UnixNano())))
+}
+
+// schedulePoolStats refreshes the pool stats every interval, with jitter, until the clock
+// stops. The first refresh happens after a short jittered delay so startup is not slowed.
+func (svc *ServiceContext) schedulePoolStats(interval time.Duration, clock statsClock, rnd *rand.Rand) {
+	delay := statsDelay(time.Minute, rnd)
+	for clock.wait(delay) {
+		if err := svc.refreshPoolStats(context.Background(), clock.now()); err != nil {
+			log.Printf("WARNING: unable to refresh pool stats: %s", err.Error())
+			svc.Metrics.Increment("pool_stats_failure")
+		}
+		delay = statsDelay(interval, rnd)
+	}
+}
+
+// poolStatsAttributes returns the identify attributes for the cached pool stats, if any
+func (svc *ServiceContext) poolStatsAttributes() []v4api.PoolAttribute {
+	out := make([]v4api.PoolAttribute, 0)
+	stats := svc.PoolStats.get()
+	if stats == nil {
+		return out
+	}
+	out = append(out, v4api.PoolAttribute{Name: "collection_size", Supported: true, Value: fmt.Sprintf("%d", stats.CollectionSize)})
+	out = append(out, v4api.PoolAttribute{Name: "online_share", Supported: true, Value: fmt.Sprintf("%.3f", stats.OnlineShare)})
+	out = append(out, v4api.PoolAttribute{Name: "stats_computed_at", Supported: true, Value: stats.ComputedAt.Format(time.RFC3339)})
+	return out
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// fakeStatsClock is a statsClock whose waits advance fake time instantly. The schedule
+// stops after the given number of waits.
+type fakeStatsClock struct {
+	current time.Time
+	rounds  int
+	waits   []time.Duration
+}
+
+func (fc *fakeStatsClock) now() time.Time {
+	return fc.current
+}
+
+func (fc *fakeStatsClock) wait(d time.Duration) bool {
+	if len(fc.waits) == fc.rounds {
+		return false
+	}
+	fc.waits = append(fc.waits, d)
+	fc.current = fc.current.Add(d)
+	return true
+}
+
+// countServer holds the totals served for count queries and the queries received
+type countServer struct {
+	lock    sync.Mutex
+	total   int
+	online  int
+	failing bool
+	texts   []string
+}
+
+// serveCounts answers count queries with the collection and e-material totals, or a
+// Sierra error while failing is set
+func serveCounts(sierra *fakeSierra, cs *countServer) {
+	sierra.handle("bibs/search", func(w http.ResponseWriter, r *http.Request) {
+		cs.lock.Lock()
+		defer cs.lock.Unlock()
+		text := r.URL.Query().Get("text")
+		cs.texts = append(cs.texts, text)
+		if cs.failing {
+			writeTestJSON(w, http.StatusServiceUnavailable, SierraError{Code: 109, HTTPStatus: 503, Name: "Unavailable"})
+			return
+		}
+		total := cs.total
+		if strings.Contains(text, sierraMaterialIndex+":") {
+			total = cs.online
+		}
+		writeTestJSON(w, http.StatusOK, JMRLResult{Total: total, Entries: []JMRLEntry{}})
+	})
+}
+
+func (cs *countServer) set(total int, online int, failing bool) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+	cs.total, cs.online, cs.failing = total, online, failing
+}
+
+func TestStatsDelay(t *testing.T) {
+	rnd := rand.New(rand.NewSource(764))
+	for _, interval := range []time.Duration{time.Minute, 24 * time.Hour} {
+		low, high := interval-interval/10, interval+interval/10
+		spread := make(map[time.Duration]bool)
+		for i := 0; i < 200; i++ {
+			delay := statsDelay(interval, rnd)
+			if delay < low || delay >= high {
+				t.Fatalf("statsDelay(%s) = %s, want [%s, %s)", interval, delay, low, high)
+			}
+			spread[delay] = true
+		}
+		if len(spread) < 100 {
+			t.Errorf("statsDelay(%s) has only %d distinct delays", interval, len(spread))
+		}
+	}
+	if got := statsDelay(5*time.Nanosecond, rnd); got != 5*time.Nanosecond {
+		t.Errorf("statsDelay without room for jitter = %s", got)
+	}
+}
+
+func TestSchedulePoolStats(t *testing.T) {
+	sierra := newFakeSierra(t)
+	counts := &countServer{total: 1000, online: 250}
+	serveCounts(sierra, counts)
+	svc := newTestService(t, sierra)
+	start := time.Date(2026, 3, 1, 6, 0, 0, 0, time.UTC)
+	interval := 24 * time.Hour
+
+	// the first refresh comes a jittered minute after startup
+	clock := &fakeStatsClock{current: start, rounds: 1}
+	svc.schedulePoolStats(interval, clock, rand.New(rand.NewSource(1)))
+	if len(clock.waits) != 1 || clock.waits[0] < 54*time.Second || clock.waits[0] >= 66*time.Second {
+		t.Fatalf("first wait = %v, want about a minute", clock.waits)
+	}
+	first := svc.PoolStats.get()
+	if first == nil || first.CollectionSize != 1000 || first.OnlineShare != 0.25 || first.ComputedAt.Equal(clock.current) == false {
+		t.Fatalf("stats = %+v, want 1000 records, 0.25 online at %s", first, clock.current)
+	}
+
+	// later refreshes are a jittered interval apart; a failure keeps the stale stats
+	counts.set(0, 0, true)
+	clock = &fakeStatsClock{current: first.ComputedAt, rounds: 3}
+	svc.schedulePoolStats(interval, clock, rand.New(rand.NewSource(2)))
+	for _, wait := range clock.waits[1:] {
+		if wait < interval-interval/10 || wait >= interval+interval/10 {
+			t.Errorf("refresh wait = %s, want about %s", wait, interval)
+		}
+	}
+	if got := svc.PoolStats.get(); got != first {
+		t.Errorf("stats after failed refreshes = %+v, want %+v", got, first)
+	}
+	if n := svc.Metrics.Snapshot()["pool_stats_failure"]; n != 3 {
+		t.Errorf("pool_stats_failure = %d, want 3", n)
+	}
+
+	counts.set(2000, 100, false)
+	clock = &fakeStatsClock{current: start.Add(72 * time.Hour), rounds: 1}
+	svc.schedulePoolStats(interval, clock, rand.New(rand.NewSource(3)))
+	if got := svc.PoolStats.get(); got.CollectionSize != 2000 || got.OnlineShare != 0.05 || got.ComputedAt.Equal(clock.current) == false {
+		t.Errorf("stats after recovery = %+v", got)
+	}
+}
+
+func TestRefreshPoolStatsQueries(t *testing.T) {
+	sierra := newFakeSierra(t)
+	counts := &countServer{total: 0, online: 0}
+	serveCounts(sierra, counts)
+	svc := newTestService(t, sierra)
+	now := time.Date(2026, 3, 1, 6, 0, 0, 0, time.FixedZone("EST", -5*3600))
+	if err := svc.refreshPoolStats(context.Background(), now); err != nil {
+		t.Fatalf("refreshPoolStats failed: %s", err.Error())
+	}
+	want := []string{"(*)", "(*) AND (m:z OR m:y OR m:w OR m:m)"}
+	if strings.Join(counts.texts, "|") != strings.Join(want, "|") {
+		t.Errorf("count queries = %q, want %q", counts.texts, want)
+	}
+	// an empty collection has no share rather than NaN
+	if got := svc.PoolStats.get(); got.CollectionSize != 0 || got.OnlineShare != 0 || got.ComputedAt.Location() != time.UTC {
+		t.Errorf("stats = %+v, want an empty UTC entry", got)
+	}
+}
+
+func TestIdentifyPoolStats(t *testing.T) {
+	svc := newTestService(t, nil)
+	router := newRouter(svc)
+	identify := func() map[string]string {
+		rec := apiRequest(t, router, http.MethodGet, "/identify", "")
+		var resp v4api.PoolIdentity
+		decodeTestJSON(t, rec, &resp)
+		out := make(map[string]string)
+		for _, attr := range resp.Attributes {
+			out[attr.Name] = attr.Value
+		}
+		return out
+	}
+	attrs := identify()
+	for _, name := range []string{"collection_size", "online_share", "stats_computed_at"} {
+		if _, found := attrs[name]; found {
+			t.Errorf("%s reported before the stats were computed", name)
+		}
+	}
+
+	computed := time.Date(2026, 3, 1, 6, 0, 0, 0, time.UTC)
+	svc.PoolStats.set(&PoolStats{CollectionSize: 123456, OnlineShare: 0.12345, ComputedAt: computed})
+	attrs = identify()
+	want := map[string]string{"collection_size": "123456", "online_share": "0.123", "stats_computed_at": "2026-03-01T06:00:00Z"}
+	for name, value := range want {
+		if attrs[name] != value {
+			t.Errorf("%s = %q, want %q", name, attrs[name], value)
+		}
+	}
+}
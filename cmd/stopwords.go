@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+)
+
+// sierraStopWords are the common words Sierra drops from keyword searches
+var sierraStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"but": true, "by": true, "for": true, "from": true, "if": true, "in": true, "into": true,
+	"is": true, "it": true, "no": true, "not": true, "of": true, "on": true, "or": true,
+	"such": true, "that": true, "the": true, "their": true, "then": true, "there": true,
+	"these": true, "they": true, "this": true, "to": true, "was": true, "will": true, "with": true,
+}
+
+// onlyStopWords returns true if a translated query has words and every one is a stop word
+// Sierra will drop. Quoted phrases are searched as written, and clauses with a handler
+// (identifiers, dates, filters) are never stop words, so their presence returns false.
+func onlyStopWords(tokens []queryToken) bool {
+	words := 0
+	for _, tok := range tokens {
+		switch tok.Type {
+		case tokenPhrase:
+			return false
+		case tokenField:
+			if _, handled := clauseHandlers[tok.Value]; handled {
+				return false
+			}
+		case tokenWord:
+			if isBooleanOperator(tok) {
+				continue
+			}
+			if sierraStopWords[strings.ToLower(tok.Value)] == false {
+				return false
+			}
+			words++
+		}
+	}
+	return words > 0
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-api/v4api"
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+func TestOnlyStopWords(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"keyword: {the of and}", true},
+		{"keyword: {THE Of}", true},
+		{"title: {the} AND author: {a}", true},
+		{"keyword: {to AND be}", true},
+		{`keyword: {"to be or not to be"}`, false},
+		{`keyword: {the "of"}`, false},
+		{"keyword: {the cats}", false},
+		{"title: {the} AND author: {burnett}", false},
+		{"identifier: {the}", false},
+		{"keyword: {}", false},
+	}
+	for _, tc := range tests {
+		if got := onlyStopWords(tokenizeQuery(tc.query)); got != tc.want {
+			t.Errorf("onlyStopWords(%q) = %t, want %t", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestStopWordsOnlySearch(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "Cats")))
+	svc := newTestService(t, sierra)
+	router := gin.New()
+	router.POST("/api/search", svc.authMiddleware, svc.search)
+	tests := []struct {
+		name string
+		lang string
+		body string
+		want []string
+	}{
+		{"english", "en-US", `{"query":"keyword: {the of and}"}`, []string{"Your search contained only common words"}},
+		{"spanish", "es", `{"query":"keyword: {the of and}"}`, []string{"Su búsqueda contenía solo palabras comunes"}},
+		{"with translation warnings", "en-US", `{"query":"keyword: {the of and}","filters":[{"pool_id":"jmrl","facets":[{"facet_id":"FilterUnknown","value":"x"}]}]}`,
+			[]string{"FilterUnknown", "Your search contained only common words"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/search", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.User))
+			req.Header.Set("Accept-Language", tc.lang)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var resp v4api.PoolResult
+			decodeTestJSON(t, rec, &resp)
+			if len(resp.Groups) != 0 || resp.Pagination.Total != 0 || resp.Confidence != "low" {
+				t.Errorf("%d groups, total %d, confidence %q, want an empty low confidence result",
+					len(resp.Groups), resp.Pagination.Total, resp.Confidence)
+			}
+			if len(resp.Warnings) != len(tc.want) {
+				t.Fatalf("warnings = %q, want %q", resp.Warnings, tc.want)
+			}
+			for i, want := range tc.want {
+				if strings.Contains(resp.Warnings[i], want) == false {
+					t.Errorf("warning %d = %q, want %q", i, resp.Warnings[i], want)
+				}
+			}
+		})
+	}
+	if n := sierra.count("bibs/search"); n != 0 {
+		t.Errorf("%d Sierra searches for stop word queries, want 0", n)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// subjectMarcTags are the MARC tags that carry subject headings, in display order
+var subjectMarcTags = []string{"600", "650", "651", "647"}
+
+// subjectMainSubfields make up the main heading; subdivisions are joined with " -- "
+const subjectMainSubfields = "abcdq"
+const subjectSubdivisions = "vxyz"
+
+// getSubjectHeadings assembles the full subject headings (main heading plus subdivisions)
+// from all subject tags, de-duplicated on the normalized heading in first-seen order
+func getSubjectHeadings(varFields *[]JMRLVarFields) []string {
+	out := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, tag := range subjectMarcTags {
+		for _, field := range *varFields {
+			if field.MarcTag != tag {
+				continue
+			}
+			heading := assembleHeading(&field)
+			key := headingKey(heading)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			out = append(out, heading)
+		}
+	}
+	return out
+}
+
+// assembleHeading joins the main heading subfields with spaces and appends each
+// subdivision with the conventional " -- " separator
+func assembleHeading(field *JMRLVarFields) string {
+	main := make([]string, 0)
+	parts := make([]string, 0)
+	for _, sub := range field.Subfields {
+		val := strings.TrimSpace(stripTrailingData(html.UnescapeString(sub.Content)))
+		if val == "" {
+			continue
+		}
+		if strings.Contains(subjectMainSubfields, sub.Tag) {
+			main = append(main, val)
+		} else if strings.Contains(subjectSubdivisions, sub.Tag) {
+			parts = append(parts, val)
+		}
+	}
+	if len(main) == 0 {
+		return ""
+	}
+	return strings.Join(append([]string{strings.Join(main, " ")}, parts...), " -- ")
+}
+
+// headingKey normalizes each part of a heading separately so that punctuation and case
+// differences match while the subdivision structure is kept
+// EX: "Virginia -- History." and "virginia--history" match, "Virginia history" does not
+func headingKey(heading string) string {
+	parts := strings.Split(heading, "--")
+	for i, part := range parts {
+		parts[i] = normalizeMatchText(part)
+	}
+	return strings.Trim(strings.Join(parts, "|"), "|")
+}
+
+// capSubjects limits the number of subject headings. When headings are dropped, a
+// marker describing how many were omitted is returned as well
+func capSubjects(headings []string, max int) ([]string, string) {
+	if max <= 0 || len(headings) <= max {
+		return headings, ""
+	}
+	return headings[:max], fmt.Sprintf("and %d more subjects", len(headings)-max)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetSubjectHeadings(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []JMRLVarFields
+		want   []string
+	}{
+		{"same heading in 650 and 651", []JMRLVarFields{
+			marcField("651", "a", "Virginia."),
+			marcField("650", "a", "Virginia"),
+		}, []string{"Virginia"}},
+		{"case and punctuation", []JMRLVarFields{
+			marcField("650", "a", "Cats", "x", "Behavior."),
+			marcField("650", "a", "cats", "x", "behavior"),
+			marcField("651", "a", "CATS,", "x", "Behavior"),
+		}, []string{"Cats -- Behavior"}},
+		{"distinct subdivisions kept", []JMRLVarFields{
+			marcField("651", "a", "Virginia", "x", "History", "y", "Civil War, 1861-1865."),
+			marcField("651", "a", "Virginia", "x", "History"),
+			marcField("650", "a", "Virginia", "v", "Maps."),
+			marcField("650", "a", "Virginia"),
+		}, []string{"Virginia -- Maps", "Virginia", "Virginia -- History -- Civil War, 1861-1865", "Virginia -- History"}},
+		{"subdivision is not a main heading", []JMRLVarFields{
+			marcField("650", "a", "Virginia", "x", "History."),
+			marcField("650", "a", "Virginia history."),
+		}, []string{"Virginia -- History", "Virginia history"}},
+		{"first seen order by tag", []JMRLVarFields{
+			marcField("647", "a", "Battle of Gettysburg"),
+			marcField("650", "a", "Gettysburg, Battle of"),
+			marcField("600", "a", "Lincoln, Abraham,", "d", "1809-1865."),
+			marcField("650", "a", "Lincoln, Abraham, 1809-1865"),
+		}, []string{"Lincoln, Abraham, 1809-1865", "Gettysburg, Battle of", "Battle of Gettysburg"}},
+		{"no main heading", []JMRLVarFields{
+			marcField("650", "x", "History."),
+			marcField("245", "a", "Not a subject"),
+		}, []string{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := getSubjectHeadings(&tc.fields); reflect.DeepEqual(got, tc.want) == false {
+				t.Errorf("getSubjectHeadings = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCapSubjects(t *testing.T) {
+	headings := []string{"A", "B", "C", "D", "E"}
+	tests := []struct {
+		max  int
+		want []string
+		more string
+	}{
+		{0, headings, ""},
+		{5, headings, ""},
+		{10, headings, ""},
+		{3, []string{"A", "B", "C"}, "and 2 more subjects"},
+		{1, []string{"A"}, "and 4 more subjects"},
+	}
+	for _, tc := range tests {
+		got, more := capSubjects(headings, tc.max)
+		if reflect.DeepEqual(got, tc.want) == false || more != tc.more {
+			t.Errorf("capSubjects(%d) = %q %q, want %q %q", tc.max, got, more, tc.want, tc.more)
+		}
+	}
+}
+
+func TestSubjectFieldsAreCapped(t *testing.T) {
+	cfg := newTestConfig("http://sierra.invalid" + fakeSierraBase)
+	cfg.MaxSubjects = 2
+	svc := newTestServiceWithConfig(t, cfg)
+	bib := testBib("1001", "Subjects")
+	bib.VarFields = append(bib.VarFields,
+		marcField("650", "a", "Cats."), marcField("651", "a", "Virginia."), marcField("650", "a", "cats"),
+		marcField("650", "a", "Dogs."), marcField("650", "a", "Birds."))
+	fields := svc.getResultFields(&bib, fieldOptions{View: viewFull, Language: "en-US", Localizer: testLocalizer(svc, "en-US")})
+	if got := fieldValues(fields, "subject"); reflect.DeepEqual(got, []string{"Cats", "Dogs"}) == false {
+		t.Errorf("subjects = %q, want [Cats Dogs]", got)
+	}
+	if got := fieldValues(fields, "subject_more"); reflect.DeepEqual(got, []string{"and 2 more subjects"}) == false {
+		t.Errorf("subject_more = %q, want [and 2 more subjects]", got)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// suggestion settings: minimum prefix length, max results, cache lifetime and size
+const suggestMinPrefix = 3
+const suggestLimit = 8
+const suggestTTL = 5 * time.Minute
+const suggestCacheMax = 2000
+
+// suggestFields are the minimal bib fields needed for a suggestion
+const suggestFields = "id,title,author,materialType"
+
+// Suggestion is a compact type-ahead hit
+type Suggestion struct {
+	Title  string `json:"title"`
+	Author string `json:"author,omitempty"`
+	ID     string `json:"id"`
+	Format string `json:"format,omitempty"`
+}
+
+// suggest returns a compact list of titles starting with the q prefix for type-ahead.
+// Only letters and digits count toward the minimum prefix length.
+func (svc *ServiceContext) suggest(c *gin.Context) {
+	prefix := normalizeMatchText(c.Query("q"))
+	c.Header("Cache-Control", "private, max-age=300")
+	if utf8.RuneCountInString(strings.ReplaceAll(prefix, " ", "")) < suggestMinPrefix {
+		c.JSON(http.StatusOK, make([]Suggestion, 0))
+		return
+	}
+
+	now := time.Now()
+	key := svc.requestCacheKey(c, "suggest", prefix, viewBrief)
+	c.Header("Vary", "Accept-Language")
+	setCacheKeyHeader(c, key)
+	cached, age, found := svc.Suggestions.lookup(key, now)
+	recordCacheStatus(c, newCacheStatus(found, age))
+	if found {
+		svc.Metrics.Increment("suggest_cache_hit")
+		c.JSON(http.StatusOK, cached.([]Suggestion))
+		return
+	}
+	svc.Metrics.Increment("suggest_cache_miss")
+
+	// suggestions never queue behind searches; if Sierra is busy just return nothing
+	if svc.Limiter.tryAcquireSuggest() == false {
+		log.Printf("WARNING: Sierra busy; skipping suggestions for [%s]", prefix)
+		svc.Metrics.Increment("suggest_shed")
+		c.JSON(http.StatusOK, make([]Suggestion, 0))
+		return
+	}
+	params := url.Values{}
+	params.Set("index", "title")
+	params.Set("text", prefix)
+	params.Set("limit", fmt.Sprintf("%d", suggestLimit))
+	params.Set("fields", suggestFields)
+	ctx, cancel := budgetContext(c.Request.Context(), requestBudget(c.Request.Header, 0))
+	sierraURL := svc.Sierra.SearchURL(params)
+	resp, err := svc.sierraGet(ctx, sierraURL)
+	cancel()
+	source := newCacheSource(time.Now(), sierraURL)
+	svc.Limiter.releaseSuggest()
+	if err != nil {
+		sendError(c, err.StatusCode, err.errorCode(), err.Message)
+		return
+	}
+
+	jmrlResp := &JMRLResult{}
+	if jsonErr := json.Unmarshal(resp, jmrlResp); jsonErr != nil {
+		log.Printf("ERROR: Invalid suggest response from JMRL API: %s", jsonErr.Error())
+		sendError(c, http.StatusInternalServerError, errUpstreamError, jsonErr.Error())
+		return
+	}
+	out := make([]Suggestion, 0, len(jmrlResp.Entries))
+	for _, entry := range jmrlResp.Entries {
+		bib := entry.Bib
+		if bib.Title == "" {
+			continue
+		}
+		out = append(out, Suggestion{Title: stripTrailingData(bib.Title), Author: bib.Author,
+			ID: bib.ID, Format: bib.Type.Value})
+	}
+	svc.Suggestions.put(key, out, source, now)
+	c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestSuggest(t *testing.T) {
+	sierra := newFakeSierra(t)
+	cats := testBib("1001", "Cats of the world /")
+	cats.Type = JMRLCodeValue{Code: "g", Value: "DVD"}
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(2, cats, testBib("1002", "")))
+	svc := newTestService(t, sierra)
+	router := newRouter(svc)
+
+	tests := []struct {
+		name     string
+		q        string
+		want     []Suggestion
+		searches int
+	}{
+		{"short prefix", "ca", []Suggestion{}, 0},
+		{"short after normalizing", "c.a!", []Suggestion{}, 0},
+		{"prefix", "Cat", []Suggestion{{Title: "Cats of the world", Author: "Author, Test", ID: "1001", Format: "DVD"}}, 1},
+		// the cache is keyed by the normalized prefix
+		{"cached", " cat. ", []Suggestion{{Title: "Cats of the world", Author: "Author, Test", ID: "1001", Format: "DVD"}}, 1},
+	}
+	for _, tc := range tests {
+		rec := apiRequest(t, router, http.MethodGet, "/api/suggest?q="+url.QueryEscape(tc.q), "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tc.name, rec.Code, rec.Body.String())
+		}
+		var got []Suggestion
+		decodeTestJSON(t, rec, &got)
+		if reflect.DeepEqual(got, tc.want) == false {
+			t.Errorf("%s: suggestions = %+v, want %+v", tc.name, got, tc.want)
+		}
+		if n := sierra.count("bibs/search"); n != tc.searches {
+			t.Errorf("%s: %d Sierra searches, want %d", tc.name, n, tc.searches)
+		}
+	}
+
+	urls := sierra.requestURLs()
+	sent, _ := url.Parse(urls[len(urls)-1])
+	want := url.Values{"index": {"title"}, "text": {"cat"}, "limit": {"8"}, "fields": {suggestFields}}
+	if reflect.DeepEqual(sent.Query(), want) == false {
+		t.Errorf("Sierra suggest params = %v, want %v", sent.Query(), want)
+	}
+}
+
+// type-ahead bursts must never take the slots real searches need
+func TestSuggestShedWhenBusy(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "Cats")))
+	cfg := newTestConfig(sierra.apiURL())
+	cfg.MaxConcurrent = 2
+	svc := newTestServiceWithConfig(t, cfg)
+	router := newRouter(svc)
+
+	// one suggestion already in flight uses the whole suggestion share
+	if svc.Limiter.tryAcquireSuggest() == false {
+		t.Fatalf("unable to take a suggestion slot")
+	}
+	if svc.Limiter.tryAcquireSuggest() {
+		t.Fatalf("suggestions took more than half of the Sierra slots")
+	}
+	rec := apiRequest(t, router, http.MethodGet, "/api/suggest?q=cats", "")
+	var got []Suggestion
+	decodeTestJSON(t, rec, &got)
+	if rec.Code != http.StatusOK || len(got) != 0 || sierra.count("bibs/search") != 0 {
+		t.Errorf("busy suggest = %d %v with %d searches, want an empty list", rec.Code, got, sierra.count("bibs/search"))
+	}
+	if svc.Metrics.Snapshot()["suggest_shed"] != 1 {
+		t.Errorf("suggest_shed = %d, want 1", svc.Metrics.Snapshot()["suggest_shed"])
+	}
+	svc.Limiter.releaseSuggest()
+	if svc.Limiter.inUse() != 0 {
+		t.Errorf("%d slots in use after release", svc.Limiter.inUse())
+	}
+}
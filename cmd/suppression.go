@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// defaultSuppressedFields hides the local 590 note, which JMRL uses for staff-only
+// processing notes
+const defaultSuppressedFields = "590"
+
+// fieldSuppression lists the bib data that must never be exposed. Entries are MARC tags
+// (590), MARC tag and subfield (500$a) or output record field names (summary).
+type fieldSuppression struct {
+	tags      map[string]bool
+	subfields map[string]bool
+	names     map[string]bool
+}
+
+// parseFieldSuppression parses the comma separated suppression list
+func parseFieldSuppression(list string) fieldSuppression {
+	fs := fieldSuppression{tags: make(map[string]bool), subfields: make(map[string]bool), names: make(map[string]bool)}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if tag, sub, found := strings.Cut(entry, "$"); found && isMarcTag(tag) && len(sub) == 1 {
+			fs.subfields[tag+"$"+sub] = true
+		} else if isMarcTag(entry) {
+			fs.tags[entry] = true
+		} else {
+			if knownRecordFields[entry] == false {
+				log.Printf("WARNING: suppressed field %s is not a field this pool emits", entry)
+			}
+			fs.names[entry] = true
+		}
+	}
+	return fs
+}
+
+// isMarcTag returns true for a three digit MARC tag
+func isMarcTag(tag string) bool {
+	if len(tag) != 3 {
+		return false
+	}
+	for _, r := range tag {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// suppressVarFields removes the suppressed MARC fields and subfields from the bib so no
+// field extraction can see them. Each removal is counted in the field_suppressed metric.
+func (svc *ServiceContext) suppressVarFields(bib *JMRLBib) {
+	fs := svc.Suppression
+	if len(fs.tags) == 0 && len(fs.subfields) == 0 {
+		return
+	}
+	kept := make([]JMRLVarFields, 0, len(bib.VarFields))
+	for _, field := range bib.VarFields {
+		if fs.tags[field.MarcTag] {
+			svc.Metrics.Increment("field_suppressed")
+			continue
+		}
+		subfields := make([]JMRLSubfield, 0, len(field.Subfields))
+		for _, sub := range field.Subfields {
+			if fs.subfields[field.MarcTag+"$"+sub.Tag] {
+				svc.Metrics.Increment("field_suppressed")
+				continue
+			}
+			subfields = append(subfields, sub)
+		}
+		field.Subfields = subfields
+		kept = append(kept, field)
+	}
+	bib.VarFields = kept
+}
+
+// suppressRecordFields removes suppressed output fields by name
+func (svc *ServiceContext) suppressRecordFields(fields []v4api.RecordField) []v4api.RecordField {
+	if len(svc.Suppression.names) == 0 {
+		return fields
+	}
+	out := make([]v4api.RecordField, 0, len(fields))
+	for _, field := range fields {
+		if svc.Suppression.names[field.Name] {
+			svc.Metrics.Increment("field_suppressed")
+			continue
+		}
+		out = append(out, field)
+	}
+	return out
+}
+
+// suppressRawBib removes the suppressed MARC fields and subfields from a raw Sierra bib
+// response. Everything else is passed through unmodified.
+func (svc *ServiceContext) suppressRawBib(raw []byte) ([]byte, error) {
+	var bib map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &bib); err != nil {
+		return nil, err
+	}
+	var varFields []map[string]interface{}
+	if err := json.Unmarshal(bib["varFields"], &varFields); err != nil {
+		return raw, nil
+	}
+	kept := make([]map[string]interface{}, 0, len(varFields))
+	for _, field := range varFields {
+		tag, _ := field["marcTag"].(string)
+		if svc.Suppression.tags[tag] {
+			continue
+		}
+		if subfields, ok := field["subfields"].([]interface{}); ok {
+			keptSubs := make([]interface{}, 0, len(subfields))
+			for _, sub := range subfields {
+				subMap, _ := sub.(map[string]interface{})
+				subTag, _ := subMap["tag"].(string)
+				if svc.Suppression.subfields[tag+"$"+subTag] == false {
+					keptSubs = append(keptSubs, sub)
+				}
+			}
+			field["subfields"] = keptSubs
+		}
+		kept = append(kept, field)
+	}
+	encoded, err := json.Marshal(kept)
+	if err != nil {
+		return nil, err
+	}
+	bib["varFields"] = encoded
+	return json.Marshal(bib)
+}
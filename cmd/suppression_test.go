@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+func TestParseFieldSuppression(t *testing.T) {
+	tests := []struct {
+		list      string
+		tags      []string
+		subfields []string
+		names     []string
+	}{
+		{"590", []string{"590"}, nil, nil},
+		{" 590 , 500$x,summary,,", []string{"590"}, []string{"500$x"}, []string{"summary"}},
+		{"500$ab", nil, nil, []string{"500$ab"}},
+		{"59", nil, nil, []string{"59"}},
+		{"", nil, nil, nil},
+	}
+	keys := func(m map[string]bool) []string {
+		out := make([]string, 0)
+		for k := range m {
+			out = append(out, k)
+		}
+		return out
+	}
+	for _, tc := range tests {
+		var fs fieldSuppression
+		captureLog(func() { fs = parseFieldSuppression(tc.list) })
+		for _, check := range []struct {
+			got  []string
+			want []string
+		}{{keys(fs.tags), tc.tags}, {keys(fs.subfields), tc.subfields}, {keys(fs.names), tc.names}} {
+			if len(check.got) != len(check.want) || (len(check.want) > 0 && reflect.DeepEqual(check.got, check.want) == false) {
+				t.Errorf("parseFieldSuppression(%q) = %+v", tc.list, fs)
+			}
+		}
+	}
+}
+
+// the suppressed values of suppressionBib
+var suppressedSecrets = []string{"PROCESSING-NOTE-590", "PROCESSING-SUB-500X", "SERIES-SECRET"}
+
+// suppressionBib is a bib with a staff 590 note, a suppressed 500$x and a series that is
+// suppressed by field name
+func suppressionBib() JMRLBib {
+	bib := testBib("1001", "Gardening")
+	bib.VarFields = append(bib.VarFields,
+		marcField("590", "a", "PROCESSING-NOTE-590 reprocessed 2019"),
+		JMRLVarFields{MarcTag: "500", Subfields: []JMRLSubfield{{Tag: "a", Content: "Includes index."}, {Tag: "x", Content: "PROCESSING-SUB-500X"}}},
+		marcField("490", "a", "SERIES-SECRET ;"),
+		marcField("520", "a", "All about roses."))
+	return bib
+}
+
+// suppressed content must appear in no response, only in the unmodified staff raw view
+func TestSuppressedFieldsNeverExposed(t *testing.T) {
+	for _, suppressRaw := range []bool{false, true} {
+		sierra := newFakeSierra(t)
+		sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, suppressionBib()))
+		sierra.handleJSON("bibs/1001", http.StatusOK, suppressionBib())
+		sierra.handleJSON("items", http.StatusOK, map[string]interface{}{"total": 0, "entries": []interface{}{}})
+		cfg := newTestConfig(sierra.apiURL())
+		cfg.SuppressFields = "590,500$x,series"
+		cfg.SuppressRaw = suppressRaw
+		svc := newTestServiceWithConfig(t, cfg)
+		router := newRouter(svc)
+
+		tests := []struct {
+			name   string
+			role   v4jwt.RoleEnum
+			method string
+			target string
+			body   string
+		}{
+			{"search", v4jwt.User, http.MethodPost, "/api/search", `{"query":"keyword: {gardening}","pagination":{"rows":20}}`},
+			{"search debug", v4jwt.Staff, http.MethodPost, "/api/search?debug=true",
+				`{"query":"keyword: {roses}","pagination":{"rows":20},"preferences":{"debug":true}}`},
+			{"resource", v4jwt.User, http.MethodGet, "/api/resource/1001", ""},
+			{"resource debug", v4jwt.Staff, http.MethodGet, "/api/resource/1001?debug=true", ""},
+			{"resource raw by user", v4jwt.User, http.MethodGet, "/api/resource/1001?raw=true", ""},
+			{"bibtex", v4jwt.User, http.MethodGet, "/api/resource/1001/bibtex", ""},
+			{"feed", v4jwt.User, http.MethodGet, "/api/feed?subject=gardening", ""},
+		}
+		for _, tc := range tests {
+			rec := apiRequestAs(t, router, tc.role, tc.method, tc.target, tc.body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s status = %d: %s", tc.name, rec.Code, rec.Body.String())
+			}
+			for _, secret := range suppressedSecrets {
+				if strings.Contains(rec.Body.String(), secret) {
+					t.Errorf("%s response exposes %s", tc.name, secret)
+				}
+			}
+			if tc.name == "resource" && strings.Contains(rec.Body.String(), "All about roses") == false {
+				t.Errorf("resource lost the unsuppressed summary")
+			}
+		}
+
+		// the raw staff view is the unmodified bib unless -suppressraw is set
+		raw := apiRequestAs(t, router, v4jwt.Staff, http.MethodGet, "/api/resource/1001?raw=true", "").Body.String()
+		for _, secret := range suppressedSecrets[:2] {
+			if strings.Contains(raw, secret) == suppressRaw {
+				t.Errorf("suppressraw %t: raw view contains %s = %t", suppressRaw, secret, strings.Contains(raw, secret))
+			}
+		}
+		if strings.Contains(raw, "Includes index.") == false {
+			t.Errorf("suppressraw %t: raw view lost the unsuppressed 500$a", suppressRaw)
+		}
+		if svc.Metrics.Snapshot()["field_suppressed"] == 0 {
+			t.Errorf("suppressions were not counted")
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"html"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// queryQuotes maps typographic quotes onto the ASCII quotes used by the V4 query syntax
+// and Sierra. Smart apostrophes in names (O’Brien) would otherwise never match.
+var queryQuotes = strings.NewReplacer("\u2018", "'", "\u2019", "'", "\u201B", "'", "\u2032", "'",
+	"\u201C", `"`, "\u201D", `"`, "\u201E", `"`)
+
+// foldDiacritics removes combining marks from text, leaving the base characters:
+// García Márquez => Garcia Marquez. Characters without a decomposition (ø, ß) are kept.
+func foldDiacritics(text string) string {
+	folded, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), text)
+	if err != nil {
+		return text
+	}
+	return folded
+}
+
+// normalizeQueryText prepares query text for Sierra: composed (NFC) Unicode so composed
+// and decomposed input search alike, ASCII quotes and, if fold is set, no diacritics
+func normalizeQueryText(query string, fold bool) string {
+	out := queryQuotes.Replace(norm.NFC.String(query))
+	if fold {
+		out = foldDiacritics(out)
+	}
+	return out
+}
+
+// normalizeMatchText produces a form of free text suitable for duplicate matching:
+// entities decoded, lowercase, all punctuation removed and spacing collapsed
+func normalizeMatchText(text string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, html.UnescapeString(text))
+	return strings.Join(strings.Fields(cleaned), " ")
+}
+
+// truncateText shortens text to at most maxRunes runes (plus an ellipsis). It prefers to
+// cut at the end of a sentence, then at a word boundary, within the second half of the
+// allowed length. Returns the text and a flag indicating if it was truncated.
+func truncateText(text string, maxRunes int) (string, bool) {
+	runes := []rune(text)
+	if maxRunes <= 0 || len(runes) <= maxRunes {
+		return text, false
+	}
+
+	cut := -1
+	for i := maxRunes - 1; i >= maxRunes/2; i-- {
+		if (runes[i] == '.' || runes[i] == '!' || runes[i] == '?') && i+1 < len(runes) && unicode.IsSpace(runes[i+1]) {
+			cut = i + 1
+			break
+		}
+	}
+	if cut == -1 {
+		for i := maxRunes; i >= maxRunes/2; i-- {
+			if unicode.IsSpace(runes[i]) {
+				cut = i
+				break
+			}
+		}
+	}
+	if cut == -1 {
+		cut = maxRunes
+	}
+
+	out := strings.TrimRightFunc(string(runes[:cut]), func(r rune) bool {
+		return unicode.IsSpace(r) || r == ',' || r == ';' || r == ':'
+	})
+	return out + "…", true
+}
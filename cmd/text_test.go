@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		max       int
+		want      string
+		truncated bool
+	}{
+		{"short", "A short summary.", 50, "A short summary.", false},
+		{"exact", "abcde", 5, "abcde", false},
+		{"disabled", "A summary that is never cut.", 0, "A summary that is never cut.", false},
+		{"sentence", "First sentence here. Second sentence is longer.", 30, "First sentence here.…", true},
+		{"word", "one two three four five six seven", 20, "one two three four…", true},
+		{"trailing punctuation", "alpha beta gamma, delta epsilon zeta", 20, "alpha beta gamma…", true},
+		{"no boundary", "abcdefghijklmnopqrstuvwxyz", 10, "abcdefghij…", true},
+		{"accented", "Él señaló que la niña había llegado tarde. Después", 45, "Él señaló que la niña había llegado tarde.…", true},
+		{"accented word", "áéíóú áéíóú áéíóú áéíóú", 14, "áéíóú áéíóú…", true},
+		{"cjk", "日本語の文章はスペースがない", 5, "日本語の文…", true},
+		{"emoji", "📚📚📚📚📚📚📚📚", 3, "📚📚📚…", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, truncated := truncateText(tc.text, tc.max)
+			if got != tc.want || truncated != tc.truncated {
+				t.Errorf("truncateText(%q, %d) = %q %t, want %q %t", tc.text, tc.max, got, truncated, tc.want, tc.truncated)
+			}
+			if utf8.ValidString(got) == false {
+				t.Errorf("truncateText(%q, %d) returned invalid UTF-8 %q", tc.text, tc.max, got)
+			}
+			if tc.max > 0 && utf8.RuneCountInString(strings.TrimSuffix(got, "…")) > tc.max {
+				t.Errorf("truncateText(%q, %d) returned %d runes", tc.text, tc.max, utf8.RuneCountInString(got))
+			}
+		})
+	}
+}
+
+func TestSummaryTruncatedOnlyInBriefView(t *testing.T) {
+	cfg := newTestConfig("http://sierra.invalid" + fakeSierraBase)
+	cfg.SummaryLength = 40
+	svc := newTestServiceWithConfig(t, cfg)
+	summary := "Una niña descubre un jardín secreto. Pasa el verano cuidándolo con su primo"
+	bib := testBib("b1", "El jardín secreto")
+	bib.VarFields = append(bib.VarFields, marcField("520", "a", summary), marcField("505", "a", summary))
+
+	brief := svc.getResultFields(&bib, fieldOptions{View: viewBrief, Language: "es", Localizer: testLocalizer(svc, "es")})
+	want := "Una niña descubre un jardín secreto.…"
+	if got := fieldValues(brief, "summary"); len(got) != 1 || got[0] != want {
+		t.Errorf("brief summary = %q, want %q", got, want)
+	}
+	if got := fieldValues(brief, "contents"); len(got) != 1 || got[0] != want {
+		t.Errorf("brief contents = %q, want %q", got, want)
+	}
+	if got := fieldValues(brief, "summary_truncated"); len(got) != 1 || got[0] != "true" {
+		t.Errorf("brief summary_truncated = %v, want [true]", got)
+	}
+
+	full := svc.getResultFields(&bib, fieldOptions{View: viewFull, Language: "es", Localizer: testLocalizer(svc, "es")})
+	if got := fieldValues(full, "summary"); len(got) != 1 || got[0] != summary {
+		t.Errorf("full summary = %q, want %q", got, summary)
+	}
+	if got := fieldValues(full, "summary_truncated"); len(got) != 0 {
+		t.Errorf("full summary_truncated = %v, want none", got)
+	}
+}
+
+func TestFoldDiacritics(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"García Márquez", "Garcia Marquez"},
+		{"Brontë", "Bronte"},
+		{"Dvor\u030ca\u0301k", "Dvorak"},
+		{"Ørsted Straße", "Ørsted Straße"},
+		{"plain", "plain"},
+	}
+	for _, tc := range tests {
+		if got := foldDiacritics(tc.in); got != tc.want {
+			t.Errorf("foldDiacritics(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeQueryText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		fold bool
+		want string
+	}{
+		{"accented author", "author: {García Márquez}", false, "author: {García Márquez}"},
+		{"accented author folded", "author: {García Márquez}", true, "author: {Garcia Marquez}"},
+		{"combining characters", "author: {Garci\u0301a Ma\u0301rquez}", false, "author: {García Márquez}"},
+		{"combining characters folded", "author: {Garci\u0301a Ma\u0301rquez}", true, "author: {Garcia Marquez}"},
+		{"smart apostrophe", "author: {O\u2019Brien}", false, "author: {O'Brien}"},
+		{"smart quotes", "title: {\u201Cthe secret garden\u201D}", false, `title: {"the secret garden"}`},
+		{"low quote", "title: {\u201Edie Blechtrommel\u201C}", true, `title: {"die Blechtrommel"}`},
+		{"ascii", "keyword: {cats}", true, "keyword: {cats}"},
+	}
+	for _, tc := range tests {
+		if got := normalizeQueryText(tc.in, tc.fold); got != tc.want {
+			t.Errorf("%s: normalizeQueryText = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// composed and decomposed input reach Sierra the same, folded with -folddiacritics, and
+// the log keeps the query as it was sent
+func TestSearchNormalizesQuery(t *testing.T) {
+	composed := `{"query":"author: {García Márquez}"}`
+	decomposed := "{\"query\":\"author: {Garci\u0301a Ma\u0301rquez}\"}"
+	tests := []struct {
+		name string
+		fold bool
+		body string
+		want string
+	}{
+		{"composed", false, composed, "a:(García Márquez)"},
+		{"decomposed", false, decomposed, "a:(García Márquez)"},
+		{"folded", true, composed, "a:(Garcia Marquez)"},
+		{"decomposed folded", true, decomposed, "a:(Garcia Marquez)"},
+	}
+	for _, tc := range tests {
+		sierra := newFakeSierra(t)
+		var text string
+		sierra.handle("bibs/search", func(w http.ResponseWriter, r *http.Request) {
+			text = r.URL.Query().Get("text")
+			writeTestJSON(w, http.StatusOK, searchResult(0))
+		})
+		cfg := newTestConfig(sierra.apiURL())
+		cfg.FoldDiacritics = tc.fold
+		router := newRouter(newTestServiceWithConfig(t, cfg))
+		logged := captureLog(func() {
+			if rec := apiRequest(t, router, http.MethodPost, "/api/search", tc.body); rec.Code != http.StatusOK {
+				t.Fatalf("%s: status = %d: %s", tc.name, rec.Code, rec.Body.String())
+			}
+		})
+		if text != tc.want {
+			t.Errorf("%s: Sierra text = %q, want %q", tc.name, text, tc.want)
+		}
+		var raw struct{ Query string }
+		if err := json.Unmarshal([]byte(tc.body), &raw); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(logged, "Raw query: "+raw.Query) == false {
+			t.Errorf("%s: log does not have the original query %q:\n%s", tc.name, raw.Query, logged)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// formerIDTag is the varField where a merge survivor keeps the record numbers of the
+// bibs merged into it. EX: 907 $a .b30030035
+const formerIDTag = "907"
+
+// mergeSearchLimit is the max number of candidate survivors checked for a deleted bib
+const mergeSearchLimit = 5
+
+// normalizeBibID strips the record type prefix and check digit from a bib record number.
+// Anything that doesn't look like a record number is returned trimmed but unchanged.
+func normalizeBibID(id string) string {
+	id = strings.TrimSpace(id)
+	if m := bibIDPattern.FindStringSubmatch(id); m != nil {
+		return m[1]
+	}
+	return id
+}
+
+// findMergeTarget returns the ID of the bib that a deleted bib was merged into. Sierra
+// does not say where a deleted bib went, so bibs mentioning its record number are
+// searched and a candidate is only accepted if its former ID field (907) names the
+// deleted bib.
+func (svc *ServiceContext) findMergeTarget(ctx context.Context, deleted *JMRLBib) (string, bool) {
+	params := url.Values{}
+	params.Set("text", fmt.Sprintf("(b%s)", deleted.ID))
+	params.Set("limit", fmt.Sprintf("%d", mergeSearchLimit))
+	params.Set("fields", "id,varFields")
+	resp, err := svc.searchBibs(ctx, params, nil)
+	if err != nil {
+		log.Printf("WARNING: unable to search for merge target of %s: %s", deleted.ID, err.Message)
+		return "", false
+	}
+	for _, entry := range resp.Entries {
+		if entry.Bib.ID == deleted.ID {
+			continue
+		}
+		for _, former := range getVarField(&entry.Bib.VarFields, formerIDTag, "a") {
+			if normalizeBibID(former) == deleted.ID {
+				return entry.Bib.ID, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestNormalizeBibID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{".b30030035", "3003003"},
+		{"b3003003", "3003003"},
+		{"B3003003x", "3003003"},
+		{" .b3003003 ", "3003003"},
+		{"3003003", "3003003"},
+		{"ocm12345", "ocm12345"},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		if got := normalizeBibID(tc.id); got != tc.want {
+			t.Errorf("normalizeBibID(%q) = %q, want %q", tc.id, got, tc.want)
+		}
+	}
+}
+
+func TestDeletedResource(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		search  string
+		status  int
+		movedTo string
+	}{
+		// only the candidate whose 907 names the deleted bib is the survivor
+		{"merged", "3003003", "search_merge_3003003.json", http.StatusMovedPermanently, "4004004"},
+		{"plainly deleted", "5005005", "search_merge_none.json", http.StatusNotFound, ""},
+		// a bib that mentions the record number without a former ID is not a survivor
+		{"mention only", "6006006", "search_merge_3003003.json", http.StatusNotFound, ""},
+		{"search failure", "5005005", "", http.StatusNotFound, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sierra := newFakeSierra(t)
+			switch tc.id {
+			case "3003003", "5005005":
+				serveFixture(t, sierra, "bibs/"+tc.id, "bib_"+tc.id+"_deleted.json")
+			default:
+				sierra.handleJSON("bibs/"+tc.id, http.StatusOK, JMRLBib{ID: tc.id, Deleted: true, DeletedDate: "2000-01-01"})
+			}
+			if tc.search != "" {
+				serveFixture(t, sierra, "bibs/search", tc.search)
+			} else {
+				sierra.handleJSON("bibs/search", http.StatusBadRequest, SierraError{Code: 108, HTTPStatus: 400, Name: "Invalid search"})
+			}
+			router := newRouter(newTestService(t, sierra))
+			rec := apiRequest(t, router, http.MethodGet, "/api/resource/"+tc.id, "")
+			if rec.Code != tc.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.status, rec.Body.String())
+			}
+			if tc.status == http.StatusMovedPermanently {
+				var body struct {
+					ID      string `json:"id"`
+					MovedTo string `json:"moved_to"`
+				}
+				decodeTestJSON(t, rec, &body)
+				if body.ID != tc.id || body.MovedTo != tc.movedTo {
+					t.Errorf("body = %+v, want %s moved to %s", body, tc.id, tc.movedTo)
+				}
+				if loc := rec.Header().Get("Location"); loc != "/api/resource/"+tc.movedTo {
+					t.Errorf("Location = %q", loc)
+				}
+			} else {
+				var body errorResponse
+				decodeTestJSON(t, rec, &body)
+				if body.ErrorCode != errRecordNotFound {
+					t.Errorf("error code = %q, want %q", body.ErrorCode, errRecordNotFound)
+				}
+			}
+			// the survivor is found by its record number, not an index of other identifiers
+			for _, raw := range sierra.requestURLs() {
+				if u, _ := url.Parse(raw); u.Path == fakeSierraBase+"/bibs/search" {
+					if got := u.Query().Get("text"); got != "(b"+tc.id+")" {
+						t.Errorf("merge search text = %q, want (b%s)", got, tc.id)
+					}
+				}
+			}
+			if sierra.count("bibs/search") != 1 {
+				t.Errorf("%d merge searches, want 1", sierra.count("bibs/search"))
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// requestTrace records how the time of a single search was spent: each Sierra attempt,
+// time spent waiting for a request slot and the decisions made along the way. Wait and
+// upstream totals are always kept so slow requests can report them; attempts and
+// decisions are only kept when debug is requested. A trace may be shared by the Sierra
+// requests of a search that run concurrently. A nil trace is valid and records nothing.
+type requestTrace struct {
+	lock       sync.Mutex
+	start      time.Time
+	detailed   bool
+	waitMS     int64
+	upstreamMS int64
+	// sierraEntries is the number of entries Sierra returned, before any pool filtering
+	sierraEntries int
+	// skipped are the optional enrichments left out of the result
+	skipped []string
+	// cache is the record cache status of a result built from a direct bib lookup, and
+	// cacheKey the key of the record cache entry it was built from
+	cache     cacheStatus
+	cacheKey  string
+	Attempts  []traceAttempt `json:"attempts"`
+	Decisions []string       `json:"decisions"`
+}
+
+// traceAttempt is a single upstream Sierra request
+type traceAttempt struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status"`
+	WaitMS     int64  `json:"wait_ms"`
+	ElapsedMS  int64  `json:"elapsed_ms"`
+}
+
+// newRequestTrace returns a trace that keeps attempts and decisions if detailed is set
+func newRequestTrace(detailed bool) *requestTrace {
+	return &requestTrace{start: time.Now(), detailed: detailed, Attempts: make([]traceAttempt, 0), Decisions: make([]string, 0)}
+}
+
+// enabled returns true if the trace keeps attempts and decisions
+func (t *requestTrace) enabled() bool {
+	return t != nil && t.detailed
+}
+
+// attempt records an upstream request, the time waited for a slot and its duration
+func (t *requestTrace) attempt(url string, status int, wait time.Duration, elapsed time.Duration) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	waitMS, elapsedMS := int64(wait/time.Millisecond), int64(elapsed/time.Millisecond)
+	t.waitMS += waitMS
+	t.upstreamMS += elapsedMS
+	if t.detailed {
+		t.Attempts = append(t.Attempts, traceAttempt{URL: url, StatusCode: status, WaitMS: waitMS, ElapsedMS: elapsedMS})
+	}
+}
+
+// returned records the number of entries returned by a Sierra search or bib list
+func (t *requestTrace) returned(n int) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.sierraEntries += n
+}
+
+// entries returns the number of entries Sierra returned
+func (t *requestTrace) entries() int {
+	if t == nil {
+		return 0
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.sierraEntries
+}
+
+// skip records an optional enrichment that was left out of the result because Sierra
+// failed or the time budget ran out
+func (t *requestTrace) skip(enrichment string) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if containsString(t.skipped, enrichment) {
+		return
+	}
+	log.Printf("WARNING: %s enrichment skipped", enrichment)
+	t.skipped = append(t.skipped, enrichment)
+}
+
+// skippedEnrichments returns the enrichments left out of the result
+func (t *requestTrace) skippedEnrichments() []string {
+	if t == nil {
+		return nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]string(nil), t.skipped...)
+}
+
+// cached records the record cache status of the result
+func (t *requestTrace) cached(status cacheStatus) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.cache = status
+}
+
+// cachedAs records the key of the record cache entry the result was built from
+func (t *requestTrace) cachedAs(key string) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.cacheKey = key
+}
+
+// recordCacheKey returns the key of the record cache entry the result was built from, or
+// empty if it was not built from one
+func (t *requestTrace) recordCacheKey() string {
+	if t == nil {
+		return ""
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.cacheKey
+}
+
+// cacheStatus returns the record cache status of the result; searches are never cached
+func (t *requestTrace) cacheStatus() cacheStatus {
+	if t == nil {
+		return cacheStatus{}
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.cache
+}
+
+// firstURL returns the URL of the first Sierra request, or empty if none was made
+func (t *requestTrace) firstURL() string {
+	urls := t.urls()
+	if len(urls) == 0 {
+		return ""
+	}
+	return urls[0]
+}
+
+// urls returns the URLs of the Sierra requests in the order they were made; a retried
+// request is listed once
+func (t *requestTrace) urls() []string {
+	if t == nil {
+		return nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	out := make([]string, 0, len(t.Attempts))
+	for _, attempt := range t.Attempts {
+		if containsString(out, attempt.URL) == false {
+			out = append(out, attempt.URL)
+		}
+	}
+	return out
+}
+
+// decision records a choice made while handling the request
+func (t *requestTrace) decision(msg string) {
+	if t.enabled() == false {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.Decisions = append(t.Decisions, msg)
+}
+
+// breakdown returns the time spent waiting for Sierra slots, waiting on Sierra and
+// processing (everything else) for a request that took elapsed in total
+func (t *requestTrace) breakdown(elapsed time.Duration) (int64, int64, int64) {
+	totalMS := int64(elapsed / time.Millisecond)
+	if t == nil {
+		return 0, 0, totalMS
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	processingMS := totalMS - t.waitMS - t.upstreamMS
+	if processingMS < 0 {
+		processingMS = 0
+	}
+	return t.waitMS, t.upstreamMS, processingMS
+}
+
+// debug returns the trace for the PoolResult debug block
+func (t *requestTrace) debug() map[string]interface{} {
+	elapsed := time.Since(t.start)
+	waitMS, upstreamMS, processingMS := t.breakdown(elapsed)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	out := make(map[string]interface{})
+	out["total_ms"] = int64(elapsed / time.Millisecond)
+	out["wait_ms"] = waitMS
+	out["upstream_ms"] = upstreamMS
+	out["processing_ms"] = processingMS
+	out["attempts"] = append([]traceAttempt{}, t.Attempts...)
+	out["decisions"] = append([]string{}, t.Decisions...)
+	return out
+}
+
+// summary returns a one line description of a request that took elapsed in total
+func (t *requestTrace) summary(elapsed time.Duration) string {
+	waitMS, upstreamMS, processingMS := t.breakdown(elapsed)
+	out := fmt.Sprintf("%dms waiting, %dms upstream, %dms processing", waitMS, upstreamMS, processingMS)
+	if t.enabled() {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		out += fmt.Sprintf("; %d attempts; %s", len(t.Attempts), strings.Join(t.Decisions, "; "))
+	}
+	return out
+}
+
+// traceEnabled returns true if the request asked for a debug trace with ?debug=true or
+// the debug search preference (requested). Only staff may see it.
+func traceEnabled(c *gin.Context, requested bool) bool {
+	return newSearchEnv(c).traceEnabled(requested)
+}
+
+// traceEnabled returns true if the search asked for a debug trace with ?debug=true or the
+// debug search preference (requested). Only staff may see it.
+func (env searchEnv) traceEnabled(requested bool) bool {
+	if (env.Debug || requested) == false {
+		return false
+	}
+	if env.Staff == false {
+		log.Printf("WARNING: debug requested for %s by non-staff user; ignoring", env.Path)
+		return false
+	}
+	return true
+}
+
+// finishTrace adds the trace to the result debug block, records the request processing
+// time and logs a timing breakdown of requests that took longer than the slow threshold
+func (svc *ServiceContext) finishTrace(env searchEnv, v4Resp *v4api.PoolResult, trace *requestTrace, requestStart time.Time) {
+	elapsed := time.Since(requestStart)
+	_, _, processingMS := trace.breakdown(elapsed)
+	svc.Metrics.Observe("request_processing", time.Duration(processingMS)*time.Millisecond)
+	if svc.Config.SlowMS > 0 && elapsed > time.Duration(svc.Config.SlowMS)*time.Millisecond {
+		log.Printf("WARNING: slow search %dms for %s: %s", elapsed/time.Millisecond, env.Path, trace.summary(elapsed))
+	}
+	status := trace.cacheStatus()
+	if trace.enabled() {
+		if v4Resp.Debug == nil {
+			v4Resp.Debug = make(map[string]interface{})
+		}
+		_, upstreamMS, _ := trace.breakdown(elapsed)
+		v4Resp.Debug["trace"] = trace.debug()
+		v4Resp.Debug["sierra_entries"] = trace.entries()
+		v4Resp.Debug["sierra_ms"] = upstreamMS
+		v4Resp.Debug["total_ms"] = int64(elapsed / time.Millisecond)
+		v4Resp.Debug["cached"] = status.Cached
+		v4Resp.Debug["cache_age_seconds"] = status.AgeSeconds
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+// scriptedSierra answers Sierra searches with the statuses in order; the last one repeats
+func scriptedSierra(t *testing.T, statuses ...int) *fakeSierra {
+	t.Helper()
+	sierra := newFakeSierra(t)
+	var lock sync.Mutex
+	calls := 0
+	sierra.handle("bibs/search", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		status := statuses[len(statuses)-1]
+		if calls < len(statuses) {
+			status = statuses[calls]
+		}
+		calls++
+		lock.Unlock()
+		if status == http.StatusOK {
+			writeTestJSON(w, status, searchResult(1, testBib("1001", "Cats")))
+			return
+		}
+		writeTestJSON(w, status, SierraError{Code: sierraRecordBusy, HTTPStatus: status, Name: "Record is busy"})
+	})
+	return sierra
+}
+
+// fastRecordBusyRetries shortens the record busy backoff for the test
+func fastRecordBusyRetries(t *testing.T) {
+	saved := recordBusyBackoff
+	recordBusyBackoff = []time.Duration{time.Millisecond, time.Millisecond}
+	t.Cleanup(func() { recordBusyBackoff = saved })
+}
+
+// searchTrace is the debug trace of a search response
+type searchTrace struct {
+	Debug struct {
+		Trace struct {
+			Attempts  []traceAttempt `json:"attempts"`
+			Decisions []string       `json:"decisions"`
+			WaitMS    int64          `json:"wait_ms"`
+		} `json:"trace"`
+		SierraEntries int `json:"sierra_entries"`
+	} `json:"debug"`
+	StatusCode int `json:"status_code"`
+}
+
+func TestTraceRecordBusyRetry(t *testing.T) {
+	fastRecordBusyRetries(t)
+	tests := []struct {
+		name      string
+		statuses  []int
+		code      int
+		attempts  []int
+		decisions int
+		entries   int
+	}{
+		{"first try", []int{200}, http.StatusOK, []int{200}, 0, 1},
+		{"one retry", []int{500, 200}, http.StatusOK, []int{500, 200}, 1, 1},
+		{"retries exhausted", []int{500}, http.StatusInternalServerError, []int{500, 500, 500}, 2, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sierra := scriptedSierra(t, tc.statuses...)
+			router := newRouter(newTestService(t, sierra))
+			rec := apiRequestAs(t, router, v4jwt.Staff, http.MethodPost, "/api/search?debug=true", `{"query":"keyword: {cats}"}`)
+			if rec.Code != tc.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.code, rec.Body.String())
+			}
+			var resp searchTrace
+			decodeTestJSON(t, rec, &resp)
+			got := make([]int, 0)
+			for _, attempt := range resp.Debug.Trace.Attempts {
+				got = append(got, attempt.StatusCode)
+				if strings.Contains(attempt.URL, "/bibs/search?") == false {
+					t.Errorf("attempt URL = %s", attempt.URL)
+				}
+			}
+			if len(got) != len(tc.attempts) {
+				t.Fatalf("attempts = %v, want %v", got, tc.attempts)
+			}
+			for i := range got {
+				if got[i] != tc.attempts[i] {
+					t.Errorf("attempts = %v, want %v", got, tc.attempts)
+				}
+			}
+			retries := 0
+			for _, decision := range resp.Debug.Trace.Decisions {
+				if decision == "record busy retry" {
+					retries++
+				}
+			}
+			if retries != tc.decisions {
+				t.Errorf("decisions = %q, want %d retries", resp.Debug.Trace.Decisions, tc.decisions)
+			}
+			if tc.code == http.StatusOK && resp.Debug.SierraEntries != tc.entries {
+				t.Errorf("sierra_entries = %d, want %d", resp.Debug.SierraEntries, tc.entries)
+			}
+		})
+	}
+}
+
+func TestTraceOnlyForStaff(t *testing.T) {
+	sierra := scriptedSierra(t, http.StatusOK)
+	router := newRouter(newTestService(t, sierra))
+	for role, want := range map[v4jwt.RoleEnum]bool{v4jwt.User: false, v4jwt.Staff: true} {
+		rec := apiRequestAs(t, router, role, http.MethodPost, "/api/search", `{"query":"keyword: {cats}","preferences":{"debug":true}}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s status = %d: %s", role, rec.Code, rec.Body.String())
+		}
+		if got := strings.Contains(rec.Body.String(), `"trace"`); got != want {
+			t.Errorf("%s response has trace %t, want %t", role, got, want)
+		}
+	}
+}
+
+func TestTraceWithoutDetail(t *testing.T) {
+	trace := newRequestTrace(false)
+	trace.attempt("https://sierra.example.org/bibs/search", http.StatusOK, 5*time.Millisecond, 20*time.Millisecond)
+	trace.decision("ignored")
+	if len(trace.Attempts) != 0 || len(trace.Decisions) != 0 {
+		t.Errorf("trace kept %d attempts and %d decisions without detail", len(trace.Attempts), len(trace.Decisions))
+	}
+	waitMS, upstreamMS, processingMS := trace.breakdown(50 * time.Millisecond)
+	if waitMS != 5 || upstreamMS != 20 || processingMS != 25 {
+		t.Errorf("breakdown = %d %d %d, want 5 20 25", waitMS, upstreamMS, processingMS)
+	}
+	if got := trace.summary(50 * time.Millisecond); got != "5ms waiting, 20ms upstream, 25ms processing" {
+		t.Errorf("summary = %q", got)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		trace.attempt("https://sierra.example.org/bibs/search", http.StatusOK, 0, time.Millisecond)
+		trace.decision("ignored")
+		trace.returned(1)
+	})
+	if allocs != 0 {
+		t.Errorf("trace without detail allocates %.0f times per request", allocs)
+	}
+
+	// a nil trace records nothing
+	var none *requestTrace
+	none.attempt("x", http.StatusOK, 0, 0)
+	none.decision("x")
+	none.skip("x")
+	if none.enabled() || none.entries() != 0 || none.firstURL() != "" {
+		t.Errorf("nil trace recorded data")
+	}
+}
+
+func TestTraceDetailedSummary(t *testing.T) {
+	trace := newRequestTrace(true)
+	trace.attempt("a", http.StatusInternalServerError, 0, 10*time.Millisecond)
+	trace.decision("record busy retry")
+	trace.attempt("a", http.StatusOK, 0, 10*time.Millisecond)
+	want := "0ms waiting, 20ms upstream, 10ms processing; 2 attempts; record busy retry"
+	if got := trace.summary(30 * time.Millisecond); got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+}
+
+// the debug block is only returned to staff who ask for it, with ?debug=true or the preference
+func TestDebugBlockOnlyWhenRequested(t *testing.T) {
+	sierra := scriptedSierra(t, http.StatusOK)
+	router := newRouter(newTestService(t, sierra))
+	tests := []struct {
+		name   string
+		role   v4jwt.RoleEnum
+		target string
+		body   string
+		want   bool
+	}{
+		{"staff without debug", v4jwt.Staff, "/api/search", `{"query":"keyword: {cats}"}`, false},
+		{"staff debug false", v4jwt.Staff, "/api/search", `{"query":"keyword: {cats}","preferences":{"debug":false}}`, false},
+		{"staff debug preference", v4jwt.Staff, "/api/search", `{"query":"keyword: {cats}","preferences":{"debug":true}}`, true},
+		{"staff debug parameter", v4jwt.Staff, "/api/search?debug=true", `{"query":"keyword: {cats}"}`, true},
+		{"user debug preference", v4jwt.User, "/api/search", `{"query":"keyword: {cats}","preferences":{"debug":true}}`, false},
+		{"user debug parameter", v4jwt.User, "/api/search?debug=true", `{"query":"keyword: {cats}"}`, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := apiRequestAs(t, router, tc.role, http.MethodPost, tc.target, tc.body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+			}
+			var resp map[string]interface{}
+			decodeTestJSON(t, rec, &resp)
+			debug, found := resp["debug"].(map[string]interface{})
+			if found != tc.want {
+				t.Fatalf("response has debug block %t, want %t: %s", found, tc.want, rec.Body.String())
+			}
+			if tc.want == false {
+				return
+			}
+			for _, key := range []string{"sierra_query", "sierra_url", "sierra_entries", "sierra_ms", "total_ms", "trace"} {
+				if _, ok := debug[key]; ok == false {
+					t.Errorf("debug block has no %s: %v", key, debug)
+				}
+			}
+			if debug["sierra_query"] != "(cats)" {
+				t.Errorf("sierra_query = %v", debug["sierra_query"])
+			}
+			if url, _ := debug["sierra_url"].(string); strings.Contains(url, "/bibs/search?") == false || strings.Contains(url, "token") {
+				t.Errorf("sierra_url = %q", url)
+			}
+			if debug["sierra_entries"] != float64(1) {
+				t.Errorf("sierra_entries = %v, want 1", debug["sierra_entries"])
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/uvalib/virgo4-api/v4api"
+	"github.com/uvalib/virgo4-parser/v4parser"
+)
+
+// searchTranslation is a V4 search request translated into the Sierra search to run
+type searchTranslation struct {
+	// BibID is set when the query is a single Sierra bib number that is fetched directly
+	BibID string
+	// BibFallback is set when a BibID that is not found should be searched normally; the
+	// rest of the translation is complete in that case
+	BibFallback bool
+	Tokens      []queryToken
+	Query       string
+	FilterQuery string
+	Params      url.Values
+	Sort        v4api.SortOrder
+	Start       int
+	Rows        int
+	Browse      bool
+	// StopWordsOnly is set when every search term is a stop word Sierra would drop
+	StopWordsOnly bool
+	RecentBrowse  bool
+	// BrowseDays is how far back a recent additions browse looks
+	BrowseDays  int
+	AvailFilter availabilityFilter
+	AddedFilter addedFilter
+	// Filters are the request filters merged with the filter clauses lifted from the query
+	Filters  []v4api.Filter
+	Warnings []string
+}
+
+// translateSearch converts a V4 search request into the Sierra query and parameters,
+// applying all query rewrites. Nothing is sent to Sierra. Requests that can not be
+// searched return an error with the status and error code for the response.
+func (svc *ServiceContext) translateSearch(jmrlReq jmrlSearchRequest) (*searchTranslation, *RequestError) {
+	req := jmrlReq.SearchRequest
+	cleanedQ, cleanErr := cleanQuery(req.Query, svc.Config.MaxQueryLength)
+	if cleanErr != nil {
+		log.Printf("ERROR: rejected query of %d bytes: %s", len(req.Query), cleanErr.Error())
+		return nil, &RequestError{StatusCode: http.StatusBadRequest, Message: cleanErr.Error(), Code: errQueryMalformed}
+	}
+	req.Query = cleanedQ
+	if req.Pagination.Start < 0 {
+		log.Printf("ERROR: negative search start %d", req.Pagination.Start)
+		return nil, &RequestError{StatusCode: http.StatusBadRequest, Message: "pagination start can not be negative", Code: errBadRequest}
+	}
+	if jmrlReq.AddedDays < 0 || jmrlReq.AddedDays > maxAddedDays {
+		log.Printf("ERROR: invalid added_days %d", jmrlReq.AddedDays)
+		return nil, &RequestError{StatusCode: http.StatusBadRequest,
+			Message: fmt.Sprintf("added_days must be between 0 and %d", maxAddedDays), Code: errBadRequest}
+	}
+	if jmrlReq.Preferences.ExcludeOnline && jmrlReq.Preferences.OnlineOnly {
+		log.Printf("ERROR: exclude_online and online_only preferences are both set")
+		return nil, &RequestError{StatusCode: http.StatusBadRequest,
+			Message: "exclude_online and online_only can not both be set", Code: errBadRequest}
+	}
+
+	sortOrder, sortErr := resolveSort(req.Sort)
+	if sortErr != nil {
+		log.Printf("ERROR: %s", sortErr.Error())
+		return nil, &RequestError{StatusCode: http.StatusBadRequest, Message: sortErr.Error(), Code: errSortUnsupported}
+	}
+	out := &searchTranslation{Sort: sortOrder, Start: req.Pagination.Start, Filters: req.Filters, Warnings: make([]string, 0)}
+
+	// make sure the query is well formed once field prefixes are in canonical form
+	log.Printf("Raw query: %s, %+v", req.Query, req.Pagination)
+	normalizedQ := normalizeQueryText(req.Query, svc.Config.FoldDiacritics)
+	if normalizedQ != req.Query {
+		log.Printf("Normalized query: %s", normalizedQ)
+	}
+	tokens := tokenizeQuery(normalizedQ)
+	canonicalQ := grammarQuery(tokens)
+	valid, errors := v4parser.Validate(canonicalQ)
+	if valid == false {
+		log.Printf("ERROR: Query [%s] is not valid: %s", canonicalQ, errors)
+		return nil, &RequestError{StatusCode: http.StatusBadRequest, Message: "Malformed search", Code: errQueryMalformed}
+	}
+
+	// a query that is just a Sierra bib number is fetched directly
+	if bibID, ok := singleBibID(tokens); ok {
+		out.BibID = bibID
+		out.Tokens = tokens
+		return out, nil
+	}
+
+	// filters sent both in the query and the request filters are applied once
+	tokens, mergedFilters, requiredFilters, mergeErr := mergeFilters(tokens, req.Filters)
+	if mergeErr != nil {
+		log.Printf("ERROR: %s", mergeErr.Error())
+		return nil, &RequestError{StatusCode: http.StatusBadRequest, Message: mergeErr.Error(), Code: errBadRequest}
+	}
+	req.Filters = mergedFilters
+	out.Filters = mergedFilters
+
+	// a bib number typed into the search box is tried directly before searching for it
+	searchBoxID, searchBoxLookup := keywordBibID(tokens)
+
+	// clauses JMRL can't search are dropped with a warning; only fail if nothing is left
+	tokens, clauseWarnings, searchable := dropUnsupportedClauses(tokens)
+	for _, warn := range clauseWarnings {
+		log.Printf("WARNING: %s", warn)
+	}
+	if searchable == false {
+		return nil, &RequestError{StatusCode: http.StatusNotImplemented, Message: strings.Join(clauseWarnings, "; "),
+			Code: errQueryUnsupportedField}
+	}
+
+	tokens, fieldNotes := keywordUnmappedFields(tokens)
+	for _, note := range fieldNotes {
+		log.Printf("WARNING: %s", note)
+	}
+
+	// EX: keyword: {(calico OR "tortoise shell") AND cats}
+	// Braces become parens, keyword: is removed and other fields become JMRL codes.
+	// Anything inside double quotes is left untouched
+	tokens, wildcardNotes := normalizeWildcards(tokens)
+	for _, note := range wildcardNotes {
+		log.Printf("WARNING: %s", note)
+	}
+	tokens, negationNotes := rewriteNegations(tokens)
+	for _, note := range negationNotes {
+		log.Printf("WARNING: %s", note)
+	}
+	out.Tokens = tokens
+	out.StopWordsOnly = onlyStopWords(tokens)
+	parsedQ, transErr := translateQuery(tokens)
+	if transErr != nil {
+		// malformed clauses are expected; mark them as warnings
+		log.Printf("WARNING: unable to translate query [%s]: %s", req.Query, transErr.Error())
+		return nil, &RequestError{StatusCode: http.StatusNotImplemented, Message: transErr.Error(), Code: errQueryMalformed}
+	}
+	log.Printf("Parsed query: %s", parsedQ)
+	out.Browse = parsedQ == "()" || parsedQ == ""
+	if out.Browse {
+		parsedQ = "(*)"
+	}
+	_, branchGeo := svc.Locations.get()
+	filterQ, filterWarnings := translateFilters(req.Filters, branchGeo)
+	availFilter, availWarnings := getAvailabilityFilter(req.Filters)
+	filterWarnings = append(filterWarnings, availWarnings...)
+	requiredQ, requiredWarnings := translateRequiredFilters(requiredFilters, branchGeo)
+	filterWarnings = append(filterWarnings, requiredWarnings...)
+	filterWarnings = append(filterWarnings, availFilter.requireValues(requiredFilters)...)
+	if requiredQ != "" {
+		if filterQ == "" {
+			filterQ = requiredQ
+		} else {
+			filterQ = fmt.Sprintf("%s AND %s", filterQ, requiredQ)
+		}
+	}
+	for _, warn := range filterWarnings {
+		log.Printf("WARNING: %s", warn)
+	}
+	// the online preference restricts material types like a filter, but does not stop a
+	// bib number in the search box from being fetched directly
+	restrictQ := filterQ
+	if onlineQ := onlineRestriction(jmrlReq.Preferences, svc.OnlineTypes); onlineQ != "" {
+		if restrictQ == "" {
+			restrictQ = onlineQ
+		} else {
+			restrictQ = fmt.Sprintf("%s AND %s", restrictQ, onlineQ)
+		}
+	}
+	if restrictQ != "" {
+		parsedQ = fmt.Sprintf("(%s) AND %s", parsedQ, restrictQ)
+		log.Printf("Filtered query: %s", parsedQ)
+	}
+	out.Query = parsedQ
+	out.FilterQuery = restrictQ
+	out.AvailFilter = availFilter
+	out.AddedFilter = newAddedFilter(jmrlReq.AddedDays, time.Now())
+	out.BrowseDays = browseDays
+	if jmrlReq.AddedDays > 0 {
+		out.BrowseDays = jmrlReq.AddedDays
+	}
+	// unfiltered browse queries show recent additions rather than an arbitrary wildcard set
+	// they are listed in catalog order, so a date added sort needs a wildcard search instead
+	out.RecentBrowse = out.Browse && restrictQ == "" && availFilter.active() == false && svc.Config.BrowseWildcard == false &&
+		sortOrder.SortID != sortNewest
+
+	out.Params = url.Values{}
+	out.Params.Set("text", parsedQ)
+	out.Rows = effectivePageSize(req.Pagination.Rows)
+	if out.Rows != req.Pagination.Rows {
+		log.Printf("Requested rows %d adjusted to %d", req.Pagination.Rows, out.Rows)
+	}
+	out.Params.Set("fields", bibFields)
+	applySierraSort(out.Params, sortOrder)
+
+	out.Warnings = append(out.Warnings, filterWarnings...)
+	out.Warnings = append(out.Warnings, clauseWarnings...)
+	out.Warnings = append(out.Warnings, fieldNotes...)
+	for _, note := range wildcardNotes {
+		out.Warnings = append(out.Warnings, "Wildcard adjusted for JMRL: "+note)
+	}
+	out.Warnings = append(out.Warnings, negationNotes...)
+	if searchBoxLookup && filterQ == "" && out.postFiltered() == false {
+		out.BibID = searchBoxID
+		out.BibFallback = true
+	}
+	return out, nil
+}
+
+// postFiltered returns true if results are filtered by the pool after the Sierra search.
+// A recent additions browse is already limited to the added date window.
+func (st *searchTranslation) postFiltered() bool {
+	return st.AvailFilter.active() || st.addedPostFiltered()
+}
+
+// addedPostFiltered returns true if the date added window is applied by the pool
+func (st *searchTranslation) addedPostFiltered() bool {
+	return st.AddedFilter.active() && st.RecentBrowse == false
+}
+
+// postFilter returns true if the bib passes every pool applied filter
+func (st *searchTranslation) postFilter(bib *JMRLBib) bool {
+	if st.AvailFilter.active() && st.AvailFilter.matches(bib) == false {
+		return false
+	}
+	return st.addedPostFiltered() == false || st.AddedFilter.matches(bib)
+}
+
+// postFilterWarning names the filters applied by the pool, which make the total an estimate
+func (st *searchTranslation) postFilterWarning() string {
+	switch {
+	case st.AvailFilter.active() && st.addedPostFiltered():
+		return "Availability and date added filtering are applied by the pool; the result total is an estimate"
+	case st.addedPostFiltered():
+		return "Date added filtering is applied by the pool; the result total is an estimate"
+	default:
+		return "Availability filtering is applied by the pool; the result total is an estimate"
+	}
+}
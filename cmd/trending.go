@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// trending settings: default and max records, cache lifetime and size, the most bibs
+// tracked, the count below which a bib is forgotten and the beacons a client may send
+// per minute
+const trendingDefaultLimit = 10
+const trendingMaxLimit = sierraMaxLimit
+const trendingTTL = 5 * time.Minute
+const trendingCacheMax = 200
+const trendingMaxTracked = 5000
+const trendingMinScore = 0.05
+const beaconsPerMinute = 30
+
+// trendingScore is the decayed number of times a bib was returned by a search and then
+// clicked, and the decayed number of times it was returned, as of updated
+type trendingScore struct {
+	clicks  float64
+	returns float64
+	updated time.Time
+}
+
+// ratio returns the share of the times the bib was returned that it was clicked
+func (s trendingScore) ratio() float64 {
+	if s.returns <= 0 {
+		return 0
+	}
+	return math.Min(s.clicks/s.returns, 1)
+}
+
+// trendingCounter keeps, per bib, how often searches returned it and how often it was
+// then clicked. Both counts halve every halfLife. Only bib IDs are stored. Beacon rate
+// limits are counted per client by a salted hash of the client address, for the current
+// minute only.
+type trendingCounter struct {
+	lock     sync.Mutex
+	halfLife time.Duration
+	scores   map[string]trendingScore
+	salt     []byte
+	window   time.Time
+	clients  map[[sha256.Size]byte]int
+}
+
+// newTrendingCounter creates an empty counter whose counts halve every halfLife
+func newTrendingCounter(halfLife time.Duration) *trendingCounter {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	return &trendingCounter{halfLife: halfLife, scores: make(map[string]trendingScore), salt: salt,
+		clients: make(map[[sha256.Size]byte]int)}
+}
+
+// decayed returns the counts as of now
+func (tc *trendingCounter) decayed(s trendingScore, now time.Time) trendingScore {
+	age := now.Sub(s.updated)
+	if age <= 0 {
+		return s
+	}
+	factor := math.Pow(0.5, age.Seconds()/tc.halfLife.Seconds())
+	return trendingScore{clicks: s.clicks * factor, returns: s.returns * factor, updated: now}
+}
+
+// allow returns true if the client may send another beacon this minute
+func (tc *trendingCounter) allow(clientIP string, now time.Time) bool {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	window := now.Truncate(time.Minute)
+	if window.Equal(tc.window) == false {
+		tc.window = window
+		tc.clients = make(map[[sha256.Size]byte]int)
+	}
+	key := sha256.Sum256(append(append([]byte{}, tc.salt...), clientIP...))
+	if tc.clients[key] >= beaconsPerMinute {
+		return false
+	}
+	tc.clients[key]++
+	return true
+}
+
+// wasReturned returns true if a search returned the bib recently enough that a click on
+// it counts
+func (tc *trendingCounter) wasReturned(id string, now time.Time) bool {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	s, found := tc.scores[id]
+	return found && tc.decayed(s, now).returns >= trendingMinScore
+}
+
+// returned counts a search returning each of the bibs
+func (tc *trendingCounter) returned(ids []string, now time.Time) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	for _, id := range ids {
+		s := tc.decayed(tc.scores[id], now)
+		s.returns++
+		s.updated = now
+		tc.scores[id] = s
+	}
+	if len(tc.scores) > trendingMaxTracked {
+		tc.prune(now)
+	}
+}
+
+// record adds a click for a bib that a search returned
+func (tc *trendingCounter) record(id string, now time.Time) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	s := tc.decayed(tc.scores[id], now)
+	s.clicks++
+	s.updated = now
+	tc.scores[id] = s
+}
+
+// prune forgets bibs whose counts have decayed below trendingMinScore and, if that is
+// not enough, the lowest ranked bibs. Callers must hold the lock.
+func (tc *trendingCounter) prune(now time.Time) {
+	for id, s := range tc.scores {
+		if current := tc.decayed(s, now); current.clicks < trendingMinScore && current.returns < trendingMinScore {
+			delete(tc.scores, id)
+		}
+	}
+	if len(tc.scores) <= trendingMaxTracked {
+		return
+	}
+	ranked := tc.ranked(now)
+	for _, id := range ranked[trendingMaxTracked:] {
+		delete(tc.scores, id)
+	}
+}
+
+// ranked returns the tracked bib IDs by decayed returned-and-clicked count, highest
+// first. Equal counts are ordered by the share of returns that were clicked, then by ID.
+// Callers must hold the lock.
+func (tc *trendingCounter) ranked(now time.Time) []string {
+	ids := make([]string, 0, len(tc.scores))
+	current := make(map[string]trendingScore, len(tc.scores))
+	for id, s := range tc.scores {
+		ids = append(ids, id)
+		current[id] = tc.decayed(s, now)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		sa, sb := current[ids[a]], current[ids[b]]
+		if sa.clicks != sb.clicks {
+			return sa.clicks > sb.clicks
+		}
+		if sa.ratio() != sb.ratio() {
+			return sa.ratio() > sb.ratio()
+		}
+		return ids[a] < ids[b]
+	})
+	return ids
+}
+
+// top returns up to limit bib IDs with the highest returned-and-clicked counts that
+// have not decayed away
+func (tc *trendingCounter) top(limit int, now time.Time) []string {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	out := make([]string, 0, limit)
+	for _, id := range tc.ranked(now) {
+		if len(out) >= limit || tc.decayed(tc.scores[id], now).clicks < trendingMinScore {
+			break
+		}
+		out = append(out, id)
+	}
+	return out
+}
+
+// beaconRequest is a click beacon: the bib a user opened from a result list
+type beaconRequest struct {
+	ID string `json:"id"`
+}
+
+// recordReturned counts the bibs of a search result for trending. Synthetic monitoring
+// probes are not counted.
+func (svc *ServiceContext) recordReturned(env searchEnv, ids []string) {
+	if svc.Trending == nil || env.Probe || len(ids) == 0 {
+		return
+	}
+	svc.Trending.returned(ids, time.Now())
+}
+
+// parseBibNumber returns the 7 digit record number of a bib number (b1234567, .b12345678
+// or a bare record number, with an optional check digit that must be correct)
+func parseBibNumber(value string) (string, bool) {
+	m := searchBoxBibPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil {
+		return "", false
+	}
+	if m[2] != "" && strings.ToLower(m[2])[0] != bibCheckDigit(m[1]) {
+		return "", false
+	}
+	return m[1], true
+}
+
+// beacon accepts a click beacon for the trending list. Beacons are rate limited per
+// client and the bib must exist. Only clicks on bibs a recent search returned are
+// counted; synthetic monitoring probes are ignored.
+func (svc *ServiceContext) beacon(c *gin.Context) {
+	if requireJSONBody(c) == false {
+		return
+	}
+	var req beaconRequest
+	if err := c.BindJSON(&req); err != nil {
+		log.Printf("ERROR: unable to parse beacon: %s", err.Error())
+		sendError(c, http.StatusBadRequest, errBadRequest, "invalid request")
+		return
+	}
+	id, ok := parseBibNumber(req.ID)
+	if ok == false {
+		log.Printf("ERROR: beacon for invalid bib id [%s]", req.ID)
+		sendError(c, http.StatusBadRequest, errBadRequest, "id must be a bib number")
+		return
+	}
+	if c.GetBool("synthetic_probe") {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	now := time.Now()
+	if svc.Trending.allow(c.ClientIP(), now) == false {
+		svc.Metrics.Increment("beacon_rate_limited")
+		sendError(c, http.StatusTooManyRequests, errRateLimited, "too many beacons; please slow down")
+		return
+	}
+	// only clicks on bibs a search returned count; others are accepted but ignored
+	if svc.Trending.wasReturned(id, now) == false {
+		resp, err := svc.apiGet(c.Request.Context(), svc.Sierra.BibURL(id, "id,deleted"))
+		if err != nil {
+			sendError(c, err.StatusCode, err.errorCode(), err.Message)
+			return
+		}
+		bib := JMRLBib{}
+		if jsonErr := json.Unmarshal(resp, &bib); jsonErr != nil || bib.Deleted {
+			sendError(c, http.StatusNotFound, errRecordNotFound, fmt.Sprintf("bib %s not found", id))
+			return
+		}
+		svc.Metrics.Increment("beacon_not_returned")
+		c.Status(http.StatusNoContent)
+		return
+	}
+	svc.Trending.record(id, now)
+	svc.Metrics.Increment("beacon_accepted")
+	c.Status(http.StatusNoContent)
+}
+
+// trending returns the brief records of the bibs most often returned and then clicked,
+// most clicked first
+func (svc *ServiceContext) trending(c *gin.Context) {
+	limit := trendingDefaultLimit
+	if val := c.Query("limit"); val != "" {
+		parsed, err := strconv.Atoi(val)
+		if err != nil || parsed < 1 || parsed > trendingMaxLimit {
+			sendError(c, http.StatusBadRequest, errBadRequest, fmt.Sprintf("limit must be between 1 and %d", trendingMaxLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	now := time.Now()
+	key := svc.requestCacheKey(c, "trending", strconv.Itoa(limit), viewBrief)
+	c.Header("Vary", "Accept-Language")
+	setCacheKeyHeader(c, key)
+	cached, age, found := svc.TrendingRecords.lookup(key, now)
+	recordCacheStatus(c, newCacheStatus(found, age))
+	if found {
+		c.JSON(http.StatusOK, cached.([]v4api.Record))
+		return
+	}
+
+	out := make([]v4api.Record, 0)
+	source := cacheSource{}
+	ids := svc.Trending.top(limit, now)
+	if len(ids) > 0 {
+		params := url.Values{}
+		params.Set("id", strings.Join(ids, ","))
+		params.Set("deleted", "false")
+		params.Set("suppressed", "false")
+		params.Set("limit", strconv.Itoa(len(ids)))
+		params.Set("fields", bibFields)
+		sierraURL := svc.Sierra.BibsURL(params)
+		resp, err := svc.apiGet(c.Request.Context(), sierraURL)
+		source = newCacheSource(time.Now(), sierraURL)
+		if err != nil {
+			sendError(c, err.StatusCode, err.errorCode(), err.Message)
+			return
+		}
+		list := &JMRLBibList{}
+		if jsonErr := json.Unmarshal(resp, list); jsonErr != nil {
+			log.Printf("ERROR: Invalid trending response from JMRL API: %s", jsonErr.Error())
+			sendError(c, http.StatusInternalServerError, errUpstreamError, jsonErr.Error())
+			return
+		}
+		bibs := make(map[string]*JMRLBib, len(list.Entries))
+		for i := range list.Entries {
+			bibs[list.Entries[i].ID] = &list.Entries[i]
+		}
+		acceptLang := svc.negotiateLanguage(c.GetHeader("Accept-Language"))
+		fieldOpts := fieldOptions{View: viewBrief, Language: acceptLang, Localizer: i18n.NewLocalizer(svc.I18NBundle, acceptLang)}
+		for _, id := range ids {
+			if bib, ok := bibs[id]; ok {
+				out = append(out, v4api.Record{Fields: svc.getResultFields(bib, fieldOpts)})
+			}
+		}
+	}
+	svc.TrendingRecords.put(key, out, source, now)
+	c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+// trendingStart is the fake clock start of the trending tests
+var trendingStart = time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+
+func TestTrendingCountsDecay(t *testing.T) {
+	tc := newTrendingCounter(time.Hour)
+	tc.returned([]string{"1000001"}, trendingStart)
+	tc.record("1000001", trendingStart)
+	tc.record("1000001", trendingStart)
+	tests := []struct {
+		after   time.Duration
+		clicks  float64
+		returns float64
+	}{
+		{0, 2, 1},
+		{time.Hour, 1, 0.5},
+		{3 * time.Hour, 0.25, 0.125},
+	}
+	for _, tt := range tests {
+		got := tc.decayed(tc.scores["1000001"], trendingStart.Add(tt.after))
+		if math.Abs(got.clicks-tt.clicks) > 1e-9 || math.Abs(got.returns-tt.returns) > 1e-9 {
+			t.Errorf("after %s: clicks %.3f returns %.3f, want %.3f %.3f", tt.after, got.clicks, got.returns, tt.clicks, tt.returns)
+		}
+	}
+
+	// counts added later build on the decayed value
+	tc.record("1000001", trendingStart.Add(time.Hour))
+	if got := tc.decayed(tc.scores["1000001"], trendingStart.Add(time.Hour)).clicks; math.Abs(got-2) > 1e-9 {
+		t.Errorf("clicks after a later click = %.3f, want 2", got)
+	}
+}
+
+func TestTrendingRatio(t *testing.T) {
+	tests := []struct {
+		score trendingScore
+		want  float64
+	}{
+		{trendingScore{clicks: 1, returns: 4}, 0.25},
+		{trendingScore{clicks: 3, returns: 3}, 1},
+		{trendingScore{clicks: 2, returns: 1}, 1},
+		{trendingScore{clicks: 1}, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.score.ratio(); got != tt.want {
+			t.Errorf("ratio of %+v = %.3f, want %.3f", tt.score, got, tt.want)
+		}
+	}
+}
+
+// bibs rank by returned-and-clicked count, then by the share of returns clicked
+func TestTrendingTop(t *testing.T) {
+	tc := newTrendingCounter(time.Hour)
+	now := trendingStart
+	tc.returned([]string{"1000001", "1000002", "1000003", "1000004"}, now)
+	tc.returned([]string{"1000001", "1000002", "1000003"}, now)
+	tc.returned([]string{"1000002"}, now)
+	for _, id := range []string{"1000001", "1000001", "1000002", "1000002", "1000003", "1000004"} {
+		tc.record(id, now)
+	}
+	want := []string{"1000001", "1000002", "1000004", "1000003"}
+	if got := tc.top(10, now); reflect.DeepEqual(got, want) == false {
+		t.Errorf("top = %v, want %v", got, want)
+	}
+	if got := tc.top(2, now); reflect.DeepEqual(got, want[:2]) == false {
+		t.Errorf("top 2 = %v, want %v", got, want[:2])
+	}
+
+	// newer clicks overtake older ones as they decay
+	later := now.Add(2 * time.Hour)
+	tc.returned([]string{"1000005"}, later)
+	tc.record("1000005", later)
+	if got := tc.top(1, later); reflect.DeepEqual(got, []string{"1000005"}) == false {
+		t.Errorf("top after two half lives = %v, want [1000005]", got)
+	}
+
+	// bibs whose clicks have decayed away are no longer trending
+	if got := tc.top(10, now.Add(24*time.Hour)); len(got) != 0 {
+		t.Errorf("top a day later = %v, want none", got)
+	}
+}
+
+func TestTrendingWasReturned(t *testing.T) {
+	tc := newTrendingCounter(time.Hour)
+	tc.returned([]string{"1000001"}, trendingStart)
+	tests := []struct {
+		id    string
+		after time.Duration
+		want  bool
+	}{
+		{"1000001", 0, true},
+		{"1000001", 3 * time.Hour, true},
+		{"1000001", 5 * time.Hour, false},
+		{"1000002", 0, false},
+	}
+	for _, tt := range tests {
+		if got := tc.wasReturned(tt.id, trendingStart.Add(tt.after)); got != tt.want {
+			t.Errorf("wasReturned(%s) after %s = %t, want %t", tt.id, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestTrendingPrune(t *testing.T) {
+	tc := newTrendingCounter(time.Hour)
+	stale := make([]string, 0, trendingMaxTracked)
+	for i := 0; i < trendingMaxTracked; i++ {
+		stale = append(stale, fmt.Sprintf("%07d", 2000000+i))
+	}
+	tc.returned(stale, trendingStart)
+	tc.record(stale[0], trendingStart)
+	later := trendingStart.Add(10 * time.Hour)
+	tc.returned([]string{"1000001"}, later)
+	if len(tc.scores) > trendingMaxTracked {
+		t.Errorf("%d bibs tracked, want at most %d", len(tc.scores), trendingMaxTracked)
+	}
+	if _, found := tc.scores["1000001"]; found == false {
+		t.Error("the newly returned bib was pruned")
+	}
+}
+
+func TestBeaconRateLimitWindow(t *testing.T) {
+	tc := newTrendingCounter(time.Hour)
+	now := trendingStart.Add(10 * time.Second)
+	for i := 0; i < beaconsPerMinute; i++ {
+		if tc.allow("10.0.0.1", now) == false {
+			t.Fatalf("beacon %d refused", i+1)
+		}
+	}
+	if tc.allow("10.0.0.1", now.Add(40*time.Second)) {
+		t.Error("beacon past the limit allowed in the same minute")
+	}
+	if tc.allow("10.0.0.2", now) == false {
+		t.Error("another client's beacon refused")
+	}
+	if tc.allow("10.0.0.1", now.Add(50*time.Second)) == false {
+		t.Error("beacon refused in the next minute")
+	}
+	for key := range tc.clients {
+		if strings.Contains(string(key[:]), "10.0.0.1") {
+			t.Error("client address stored in the clear")
+		}
+	}
+}
+
+// only clicks on bibs a search returned make them trend
+func TestBeaconCountsReturnedClicks(t *testing.T) {
+	sierra := newFakeSierra(t)
+	returned := []JMRLBib{testBib("1000001", "Cats"), testBib("1000002", "Dogs")}
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(2, returned...))
+	sierra.handleJSON("bibs/1000003", http.StatusOK, JMRLBib{ID: "1000003"})
+	sierra.handleJSON("bibs/1000004", http.StatusOK, JMRLBib{ID: "1000004", Deleted: true})
+	sierra.handleJSON("bibs", http.StatusOK, JMRLBibList{Total: 1, Entries: returned[:1]})
+	cfg := newTestConfig(sierra.apiURL())
+	cfg.TrendingHours = 24
+	svc := newTestServiceWithConfig(t, cfg)
+	router := newRouter(svc)
+
+	if rec := apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"keyword: {pets}"}`); rec.Code != http.StatusOK {
+		t.Fatalf("search status = %d: %s", rec.Code, rec.Body.String())
+	}
+	tests := []struct {
+		id     string
+		status int
+	}{
+		{"b1000001", http.StatusNoContent},
+		{".b1000001", http.StatusNoContent},
+		{"b1000003", http.StatusNoContent},
+		{"b1000004", http.StatusNotFound},
+		{"b1000009", http.StatusNotFound},
+		{"cats", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec := apiRequest(t, router, http.MethodPost, "/api/beacon", `{"id":"`+tt.id+`"}`); rec.Code != tt.status {
+			t.Errorf("beacon %s status = %d, want %d: %s", tt.id, rec.Code, tt.status, rec.Body.String())
+		}
+	}
+	metrics := svc.Metrics.Snapshot()
+	if metrics["beacon_accepted"] != 2 || metrics["beacon_not_returned"] != 1 {
+		t.Errorf("beacon metrics = %v accepted, %v not returned, want 2 and 1", metrics["beacon_accepted"], metrics["beacon_not_returned"])
+	}
+	if got := svc.Trending.top(10, time.Now()); reflect.DeepEqual(got, []string{"1000001"}) == false {
+		t.Errorf("trending bibs = %v, want [1000001]", got)
+	}
+
+	var records []v4api.Record
+	decodeTestJSON(t, apiRequest(t, router, http.MethodGet, "/api/trending?limit=5", ""), &records)
+	if len(records) != 1 || reflect.DeepEqual(fieldValues(records[0].Fields, "id"), []string{"1000001"}) == false {
+		t.Errorf("trending records = %+v, want 1000001", records)
+	}
+	if sent := sierra.requestURLs(); strings.Contains(sent[len(sent)-1], "id=1000001&") == false {
+		t.Errorf("trending fetch = %s, want id=1000001", sent[len(sent)-1])
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+)
+
+// unsupportedFields are V4 fields JMRL deliberately does not search, with a description
+// for warnings. Their clauses are dropped; any other unknown field is searched as keyword.
+// There are currently none.
+var unsupportedFields = map[string]string{}
+
+// isMappedField returns true if the V4 field has a Sierra translation or is deliberately
+// unsupported
+func isMappedField(field string) bool {
+	if _, found := sierraFieldCodes[field]; found {
+		return true
+	}
+	if _, found := clauseHandlers[field]; found {
+		return true
+	}
+	_, found := unsupportedFields[field]
+	return found
+}
+
+// keywordUnmappedFields turns field prefixes this pool does not know into keyword
+// searches, so new V4 fields still match on their value instead of matching nothing.
+// A warning naming each unmapped field is returned.
+func keywordUnmappedFields(tokens []queryToken) ([]queryToken, []string) {
+	warnings := make([]string, 0)
+	out := make([]queryToken, 0, len(tokens))
+	for _, tok := range tokens {
+		if tok.Type == tokenField && isMappedField(tok.Value) == false {
+			msg := fmt.Sprintf("Search field %s is not supported by this pool; it was searched as keyword", tok.Value)
+			if containsString(warnings, msg) == false {
+				warnings = append(warnings, msg)
+			}
+			tok = queryToken{Type: tokenField, Value: "keyword"}
+		}
+		out = append(out, tok)
+	}
+	return out, warnings
+}
+
+// isBooleanOperator returns true for the V4 boolean operator words
+func isBooleanOperator(tok queryToken) bool {
+	return isOperator(tok, "AND") || isOperator(tok, "OR") || isOperator(tok, "NOT")
+}
+
+// removeOperand removes tokens[start:end] along with the boolean operator(s) joining it to
+// the rest of the expression, so the remaining query stays well formed. The preceding
+// operators (e.g. AND NOT) are removed if there are any, otherwise the following one is.
+func removeOperand(tokens []queryToken, start int, end int) []queryToken {
+	for start > 0 && isBooleanOperator(tokens[start-1]) {
+		start--
+	}
+	if start == 0 || tokens[start-1].Type == tokenOpen {
+		// nothing precedes the operand in its group; drop the operator that follows
+		for end < len(tokens) && isBooleanOperator(tokens[end]) {
+			end++
+		}
+	}
+	out := make([]queryToken, 0, len(tokens)-(end-start))
+	out = append(out, tokens[:start]...)
+	return append(out, tokens[end:]...)
+}
+
+// emptyGroup returns the index range of the first () or {} group with nothing in it
+func emptyGroup(tokens []queryToken) (int, int, bool) {
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].Type == tokenOpen && tokens[i+1].Type == tokenClose {
+			start := i
+			if start > 0 && tokens[start-1].Type == tokenField {
+				start--
+			}
+			return start, i + 2, true
+		}
+	}
+	return 0, 0, false
+}
+
+// unsupportedClause returns the index range of the first clause JMRL can not search and
+// a warning describing it
+func unsupportedClause(tokens []queryToken) (int, int, string, bool) {
+	for i, tok := range tokens {
+		if tok.Type != tokenField {
+			continue
+		}
+		if desc, found := unsupportedFields[tok.Value]; found {
+			end := operandEnd(tokens, i)
+			return i, end, fmt.Sprintf("%s are not supported by this pool and were ignored", desc), true
+		}
+		handler, found := clauseHandlers[tok.Value]
+		if found == false {
+			continue
+		}
+		content, next, ok := clauseContent(tokens, i)
+		if ok == false {
+			continue
+		}
+		if _, err := handler(content); err != nil {
+			return i, next, fmt.Sprintf("%s; it was ignored", err.Error()), true
+		}
+	}
+	return 0, 0, "", false
+}
+
+// dropUnsupportedClauses removes the clauses that can't be translated to Sierra so the
+// rest of the query can still be searched. Groups left empty are removed as well. The
+// warnings describe each dropped clause. False is returned if nothing searchable remains.
+func dropUnsupportedClauses(tokens []queryToken) ([]queryToken, []string, bool) {
+	warnings := make([]string, 0)
+	dropped := false
+	for {
+		start, end, warning, found := unsupportedClause(tokens)
+		if found == false {
+			break
+		}
+		warnings = append(warnings, warning)
+		tokens = removeOperand(tokens, start, end)
+		dropped = true
+	}
+	if dropped == false {
+		return tokens, warnings, true
+	}
+	for {
+		start, end, found := emptyGroup(tokens)
+		if found == false {
+			break
+		}
+		tokens = removeOperand(tokens, start, end)
+	}
+	for _, tok := range tokens {
+		if tok.Type == tokenWord || tok.Type == tokenPhrase {
+			if isBooleanOperator(tok) == false {
+				return tokens, warnings, true
+			}
+		}
+	}
+	return tokens, warnings, false
+}
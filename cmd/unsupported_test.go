@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+	"github.com/uvalib/virgo4-parser/v4parser"
+)
+
+func TestDropUnsupportedClauses(t *testing.T) {
+	sometime := "date restriction [sometime] is not supported: unrecognized date format; it was ignored"
+	tests := []struct {
+		name       string
+		query      string
+		want       string
+		warnings   int
+		searchable bool
+	}{
+		{"start", `date: {sometime} AND keyword: {cats} AND title: {dogs}`, `keyword: {cats} AND title: {dogs}`, 1, true},
+		{"middle", `keyword: {cats} AND date: {sometime} AND title: {dogs}`, `keyword: {cats} AND title: {dogs}`, 1, true},
+		{"end", `keyword: {cats} AND title: {dogs} AND date: {sometime}`, `keyword: {cats} AND title: {dogs}`, 1, true},
+		{"start of group", `(date: {sometime} OR keyword: {cats}) AND title: {dogs}`, `(keyword: {cats}) AND title: {dogs}`, 1, true},
+		{"end of group", `title: {dogs} AND (keyword: {cats} OR date: {sometime})`, `title: {dogs} AND (keyword: {cats})`, 1, true},
+		{"and not", `keyword: {cats} AND NOT date: {sometime}`, `keyword: {cats}`, 1, true},
+		{"emptied group", `(date: {sometime}) AND title: {dogs}`, `title: {dogs}`, 1, true},
+		{"several", `date: {sometime} AND keyword: {cats} OR date: {BEFORE 1000}`, `keyword: {cats}`, 2, true},
+		{"supported", `keyword: {cats} AND date: {1999}`, `keyword: {cats} AND date: {1999}`, 0, true},
+		{"only unsupported", `date: {sometime}`, ``, 1, false},
+		{"only unsupported group", `(date: {sometime} OR date: {BEFORE 1000})`, ``, 2, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens, warnings, searchable := dropUnsupportedClauses(tokenizeQuery(tc.query))
+			if searchable != tc.searchable {
+				t.Fatalf("searchable = %t, want %t", searchable, tc.searchable)
+			}
+			if len(warnings) != tc.warnings {
+				t.Errorf("warnings = %q, want %d", warnings, tc.warnings)
+			}
+			if tc.warnings > 0 && strings.Contains(tc.query, "sometime") && warnings[0] != sometime {
+				t.Errorf("warning = %q, want %q", warnings[0], sometime)
+			}
+			if searchable == false {
+				return
+			}
+			if got := formatQuery(tokens); got != tc.want {
+				t.Errorf("remaining query = %q, want %q", got, tc.want)
+			}
+			// no dangling operators or empty groups may be left behind
+			if valid, errs := v4parser.Validate(grammarQuery(tokens)); valid == false {
+				t.Errorf("remaining query %q is invalid: %s", formatQuery(tokens), errs)
+			}
+		})
+	}
+}
+
+func TestUnsupportedFieldsDescribeDroppedClauses(t *testing.T) {
+	unsupportedFields["fulltext"] = "Full text searches"
+	t.Cleanup(func() { delete(unsupportedFields, "fulltext") })
+	tokens, warnings, searchable := dropUnsupportedClauses(tokenizeQuery(`fulltext: {gardening} AND keyword: {roses}`))
+	if searchable == false || formatQuery(tokens) != `keyword: {roses}` {
+		t.Errorf("remaining query = %q searchable %t", formatQuery(tokens), searchable)
+	}
+	want := "Full text searches are not supported by this pool and were ignored"
+	if len(warnings) != 1 || warnings[0] != want {
+		t.Errorf("warnings = %q, want [%s]", warnings, want)
+	}
+}
+
+// a partly unsupported query is searched with a warning; only a wholly unsupported one fails.
+// BEFORE 1000 passes V4 validation but is earlier than JMRL can search.
+func TestSearchWithUnsupportedClause(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "Gardening")))
+	router := newRouter(newTestService(t, sierra))
+
+	rec := apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"keyword: {gardening} AND date: {BEFORE 1000}","pagination":{"rows":20}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp v4api.PoolResult
+	decodeTestJSON(t, rec, &resp)
+	found := false
+	for _, warn := range resp.Warnings {
+		found = found || strings.Contains(warn, "[BEFORE 1000]")
+	}
+	if found == false {
+		t.Errorf("warnings = %q, want the dropped date clause", resp.Warnings)
+	}
+	for _, raw := range sierra.requestURLs() {
+		if u, _ := url.Parse(raw); u.Path == fakeSierraBase+"/bibs/search" {
+			if got := u.Query().Get("text"); got != "(gardening)" {
+				t.Errorf("Sierra text = %q, want (gardening)", got)
+			}
+		}
+	}
+
+	searches := sierra.count("bibs/search")
+	rec = apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"date: {BEFORE 1000}","pagination":{"rows":20}}`)
+	if rec.Code != http.StatusNotImplemented {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusNotImplemented, rec.Body.String())
+	}
+	var body errorResponse
+	decodeTestJSON(t, rec, &body)
+	if body.ErrorCode != errQueryUnsupportedField {
+		t.Errorf("error code = %q, want %q", body.ErrorCode, errQueryUnsupportedField)
+	}
+	if sierra.count("bibs/search") != searches {
+		t.Errorf("an unsupported query was sent to Sierra")
+	}
+}
+
+func TestKeywordUnmappedFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		want     string
+		warnings []string
+	}{
+		{"mapped", `title: {cats} AND author: {smith}`, `title: {cats} AND author: {smith}`, []string{}},
+		{"handled", `call_number: {FIC ROWLING}`, `call_number: {FIC ROWLING}`, []string{}},
+		{"unmapped", `fulltext: {cats}`, `keyword: {cats}`,
+			[]string{"Search field fulltext is not supported by this pool; it was searched as keyword"}},
+		{"unmapped with mapped", `title: {cats} AND shelf: {new}`, `title: {cats} AND keyword: {new}`,
+			[]string{"Search field shelf is not supported by this pool; it was searched as keyword"}},
+		{"repeated", `shelf: {new} OR shelf: {old}`, `keyword: {new} OR keyword: {old}`,
+			[]string{"Search field shelf is not supported by this pool; it was searched as keyword"}},
+		{"several", `(shelf: {new} AND fulltext: {cats})`, `(keyword: {new} AND keyword: {cats})`, []string{
+			"Search field shelf is not supported by this pool; it was searched as keyword",
+			"Search field fulltext is not supported by this pool; it was searched as keyword"}},
+		{"quoted", `keyword: {"shelf: new"}`, `keyword: {"shelf: new"}`, []string{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens, warnings := keywordUnmappedFields(tokenizeQuery(tc.query))
+			if got := formatQuery(tokens); got != tc.want {
+				t.Errorf("keywordUnmappedFields(%q) = %q, want %q", tc.query, got, tc.want)
+			}
+			if reflect.DeepEqual(warnings, tc.warnings) == false {
+				t.Errorf("warnings = %q, want %q", warnings, tc.warnings)
+			}
+		})
+	}
+}
+
+// an unmapped field still matches on its value, and the result says why
+func TestSearchWithUnmappedField(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(1, testBib("1001", "Cats")))
+	router := newRouter(newTestService(t, sierra))
+	rec := apiRequest(t, router, http.MethodPost, "/api/search", `{"query":"fulltext: {cats} AND title: {hats}","pagination":{"rows":20}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp v4api.PoolResult
+	decodeTestJSON(t, rec, &resp)
+	want := "Search field fulltext is not supported by this pool; it was searched as keyword"
+	if containsString(resp.Warnings, want) == false {
+		t.Errorf("warnings = %q, want %q", resp.Warnings, want)
+	}
+	for _, raw := range sierra.requestURLs() {
+		if u, _ := url.Parse(raw); u.Path == fakeSierraBase+"/bibs/search" {
+			if got := u.Query().Get("text"); got != "(cats) AND t:(hats)" {
+				t.Errorf("Sierra text = %q, want (cats) AND t:(hats)", got)
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sierraTruncation is the Sierra text search truncation character. Sierra only supports
+// right truncation; V4 * and ? wildcards are mapped onto it.
+const sierraTruncation = "*"
+
+// isWildcard returns true for the V4 wildcard characters
+func isWildcard(r rune) bool {
+	return r == '*' || r == '?'
+}
+
+// normalizeWildcard rewrites the wildcards in a single query word for Sierra. Leading
+// wildcards are dropped and a word is truncated at its first remaining wildcard, so
+// *garden becomes garden and gard?n becomes gard*. A bare * is left alone since it is
+// the match-all query. The returned note describes any wildcard that had to be changed.
+func normalizeWildcard(word string) (string, string) {
+	if strings.ContainsFunc(word, isWildcard) == false || word == "*" {
+		return word, ""
+	}
+	stem := strings.TrimLeftFunc(word, isWildcard)
+	note := ""
+	if stem != word {
+		note = fmt.Sprintf("leading wildcard removed from [%s]", word)
+	}
+	if stem == "" {
+		return "", fmt.Sprintf("wildcard [%s] removed", word)
+	}
+	idx := strings.IndexFunc(stem, isWildcard)
+	if idx < 0 {
+		return stem, note
+	}
+	rest := stem[idx:]
+	if strings.TrimFunc(rest, isWildcard) != "" && note == "" {
+		note = fmt.Sprintf("[%s] truncated at its first wildcard", word)
+	}
+	return stem[:idx] + sierraTruncation, note
+}
+
+// normalizeWildcards rewrites the wildcards in the bare words of a query for Sierra.
+// Quoted phrases are literal and never changed. Words that were only wildcards are
+// removed, along with the operators and groups they leave dangling. A bare * is only
+// kept as the whole query or as the left side of a NOT; next to other terms it matches
+// nothing useful. Notes describing dropped or changed wildcards are returned for the
+// result warnings.
+func normalizeWildcards(tokens []queryToken) ([]queryToken, []string) {
+	terms := 0
+	for _, tok := range tokens {
+		if tok.Type == tokenPhrase || (tok.Type == tokenWord && isBooleanOperator(tok) == false) {
+			terms++
+		}
+	}
+	out := make([]queryToken, 0, len(tokens))
+	notes := make([]string, 0)
+	removed := false
+	for i, tok := range tokens {
+		if tok.Type != tokenWord {
+			out = append(out, tok)
+			continue
+		}
+		word, note := normalizeWildcard(tok.Value)
+		if word == "*" && terms > 1 && negatedNext(tokens, i+1) == false {
+			word, note = "", "wildcard [*] removed"
+		}
+		if note != "" {
+			notes = append(notes, note)
+		}
+		if word == "" {
+			removed = true
+			continue
+		}
+		out = append(out, queryToken{Type: tokenWord, Value: word})
+	}
+	if removed {
+		out = dropDanglingOperators(out)
+	}
+	return out, notes
+}
+
+// negatedNext returns true if the tokens at idx are NOT or AND NOT
+func negatedNext(tokens []queryToken, idx int) bool {
+	if idx < len(tokens) && isOperator(tokens[idx], "AND") {
+		idx++
+	}
+	return idx < len(tokens) && isOperator(tokens[idx], "NOT")
+}
+
+// dropDanglingOperators removes what is left of the boolean structure after words are
+// dropped: empty groups along with their field prefix, AND/OR missing an operand and a
+// NOT with nothing to negate. EX: cats AND title: {?} OR dogs => cats OR dogs
+func dropDanglingOperators(tokens []queryToken) []queryToken {
+	for {
+		out := make([]queryToken, 0, len(tokens))
+		for i := 0; i < len(tokens); i++ {
+			tok := tokens[i]
+			var next *queryToken
+			if i+1 < len(tokens) {
+				next = &tokens[i+1]
+			}
+			if tok.Type == tokenOpen && next != nil && next.Type == tokenClose {
+				if len(out) > 0 && out[len(out)-1].Type == tokenField {
+					out = out[:len(out)-1]
+				}
+				i++
+				continue
+			}
+			if isBooleanOperator(tok) {
+				atStart := len(out) == 0 || out[len(out)-1].Type == tokenOpen
+				atEnd := next == nil || next.Type == tokenClose
+				beforeOperator := next != nil && (isOperator(*next, "AND") || isOperator(*next, "OR"))
+				if atEnd || beforeOperator || (atStart && tok.Value != "NOT") {
+					continue
+				}
+			}
+			out = append(out, tok)
+		}
+		if len(out) == len(tokens) {
+			return out
+		}
+		tokens = out
+	}
+}
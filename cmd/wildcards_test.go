@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/uvalib/virgo4-api/v4api"
+)
+
+func TestNormalizeWildcard(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+		note string
+	}{
+		{"garden*", "garden*", ""},
+		{"garden?", "garden*", ""},
+		{"gard?n", "gard*", "[gard?n] truncated at its first wildcard"},
+		{"wom*n*", "wom*", "[wom*n*] truncated at its first wildcard"},
+		{"*garden", "garden", "leading wildcard removed from [*garden]"},
+		{"?garden*", "garden*", "leading wildcard removed from [?garden*]"},
+		{"**", "", "wildcard [**] removed"},
+		{"?", "", "wildcard [?] removed"},
+		{"*", "*", ""},
+		{"garden", "garden", ""},
+	}
+	for _, tc := range tests {
+		got, note := normalizeWildcard(tc.word)
+		if got != tc.want || note != tc.note {
+			t.Errorf("normalizeWildcard(%q) = %q %q, want %q %q", tc.word, got, note, tc.want, tc.note)
+		}
+	}
+}
+
+func TestTranslateSearchWildcards(t *testing.T) {
+	svc := newTestService(t, nil)
+	tests := []struct {
+		name     string
+		query    string
+		want     string
+		warnings []string
+	}{
+		{"trailing", `title: {garden*}`, `t:(garden*)`, []string{}},
+		{"embedded", `title: {gard?n party}`, `t:(gard* party)`, []string{"Wildcard adjusted for JMRL: [gard?n] truncated at its first wildcard"}},
+		{"leading", `keyword: {*garden}`, `(garden)`, []string{"Wildcard adjusted for JMRL: leading wildcard removed from [*garden]"}},
+		{"quoted phrase", `title: {"*secret garden?"}`, `t:("*secret garden?")`, []string{}},
+		{"match all", `keyword: {*}`, `(*)`, []string{}},
+		// dropping a wildcard must not leave the boolean structure dangling
+		{"trailing operand", `keyword: {cats AND ?}`, `(cats)`, []string{"Wildcard adjusted for JMRL: wildcard [?] removed"}},
+		{"bare star operand", `keyword: {cats AND *}`, `(cats)`, []string{"Wildcard adjusted for JMRL: wildcard [*] removed"}},
+		{"leading operand", `keyword: {?? OR cats}`, `(cats)`, []string{"Wildcard adjusted for JMRL: wildcard [??] removed"}},
+		{"middle operand", `keyword: {cats AND ? OR dogs}`, `(cats OR dogs)`, []string{"Wildcard adjusted for JMRL: wildcard [?] removed"}},
+		{"negated operand", `keyword: {cats NOT ?}`, `(cats)`, []string{"Wildcard adjusted for JMRL: wildcard [?] removed"}},
+		{"empty group", `keyword: {cats AND (? OR **)}`, `(cats)`,
+			[]string{"Wildcard adjusted for JMRL: wildcard [?] removed", "Wildcard adjusted for JMRL: wildcard [**] removed"}},
+		{"empty field", `title: {?} AND author: {mantel}`, `a:(mantel)`, []string{"Wildcard adjusted for JMRL: wildcard [?] removed"}},
+		{"star exclusion", `keyword: {* NOT dogs}`, `(* AND NOT dogs)`, []string{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := jmrlSearchRequest{SearchRequest: v4api.SearchRequest{Query: tc.query, Pagination: v4api.Pagination{Rows: 20}}}
+			xlate, err := svc.translateSearch(req)
+			if err != nil {
+				t.Fatalf("translateSearch(%q) failed: %s", tc.query, err.Message)
+			}
+			if xlate.Query != tc.want {
+				t.Errorf("query = %s, want %s", xlate.Query, tc.want)
+			}
+			if reflect.DeepEqual(xlate.Warnings, tc.warnings) == false {
+				t.Errorf("warnings = %q, want %q", xlate.Warnings, tc.warnings)
+			}
+		})
+	}
+}
+
+// a query of nothing but wildcards becomes an empty (browse) search
+func TestWildcardOnlyQueryBrowses(t *testing.T) {
+	svc := newTestService(t, nil)
+	req := jmrlSearchRequest{SearchRequest: v4api.SearchRequest{Query: `keyword: {? AND **}`, Pagination: v4api.Pagination{Rows: 20}}}
+	xlate, err := svc.translateSearch(req)
+	if err != nil {
+		t.Fatalf("translateSearch failed: %s", err.Message)
+	}
+	if xlate.Browse == false {
+		t.Errorf("query %s is not a browse", xlate.Query)
+	}
+}
+
+func TestDropDanglingOperators(t *testing.T) {
+	word := func(v string) queryToken { return queryToken{Type: tokenWord, Value: v} }
+	open := queryToken{Type: tokenOpen, Value: "("}
+	close := queryToken{Type: tokenClose, Value: ")"}
+	tests := []struct {
+		name   string
+		tokens []queryToken
+		want   []queryToken
+	}{
+		{"well formed", []queryToken{word("a"), word("AND"), word("NOT"), word("b")}, []queryToken{word("a"), word("AND"), word("NOT"), word("b")}},
+		{"leading NOT kept", []queryToken{open, word("NOT"), word("b"), close}, []queryToken{open, word("NOT"), word("b"), close}},
+		{"trailing", []queryToken{word("a"), word("AND"), word("NOT")}, []queryToken{word("a")}},
+		{"doubled", []queryToken{word("a"), word("AND"), word("OR"), word("b")}, []queryToken{word("a"), word("OR"), word("b")}},
+		{"nested empty", []queryToken{word("a"), word("OR"), open, open, close, close}, []queryToken{word("a")}},
+		{"empty field", []queryToken{{Type: tokenField, Value: "title"}, {Type: tokenOpen, Value: "{"}, {Type: tokenClose, Value: "}"}, word("AND"), word("a")},
+			[]queryToken{word("a")}},
+		{"everything", []queryToken{open, word("AND"), close}, []queryToken{}},
+	}
+	for _, tc := range tests {
+		if got := dropDanglingOperators(tc.tokens); reflect.DeepEqual(got, tc.want) == false {
+			t.Errorf("%s: dropDanglingOperators = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
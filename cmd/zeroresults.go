@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxZeroResultQueryLen caps the stored length of a single query (in runes)
+const maxZeroResultQueryLen = 256
+
+// patterns for data that looks personal and must never be stored
+var emailPattern = regexp.MustCompile(`[[:alnum:]._%+\-]+@[[:alnum:].\-]+\.[[:alpha:]]{2,}`)
+var phonePattern = regexp.MustCompile(`\(?\b\d{3}\)?[\s.\-]?\d{3}[\s.\-]\d{4}\b`)
+var longDigitsPattern = regexp.MustCompile(`\d[\d\s\-]{5,}\d`)
+
+// yearsPattern matches digit runs that are only years, like 1861-1865, which are kept
+var yearsPattern = regexp.MustCompile(`^(1\d{3}|20\d{2})([\s\-]+(1\d{3}|20\d{2}))*$`)
+
+// ZeroResultQuery is a scrubbed query that returned no results
+type ZeroResultQuery struct {
+	Query     string    `json:"query"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// zeroResultLog is a bounded ring buffer of zero result queries
+type zeroResultLog struct {
+	lock    sync.Mutex
+	entries []ZeroResultQuery
+	next    int
+	full    bool
+}
+
+// newZeroResultLog creates a ring buffer holding at most capacity queries
+func newZeroResultLog(capacity int) *zeroResultLog {
+	return &zeroResultLog{entries: make([]ZeroResultQuery, capacity)}
+}
+
+// scrubQuery removes anything that looks like personal data from a query
+// and limits its length
+func scrubQuery(query string) string {
+	out := emailPattern.ReplaceAllString(query, "[email]")
+	out = phonePattern.ReplaceAllString(out, "[phone]")
+	out = longDigitsPattern.ReplaceAllStringFunc(out, func(digits string) string {
+		if yearsPattern.MatchString(digits) {
+			return digits
+		}
+		return "[number]"
+	})
+	out = strings.Join(strings.Fields(out), " ")
+	if runes := []rune(out); len(runes) > maxZeroResultQueryLen {
+		out = string(runes[:maxZeroResultQueryLen])
+	}
+	return out
+}
+
+// add records a scrubbed query, overwriting the oldest entry when full
+func (z *zeroResultLog) add(query string) {
+	scrubbed := scrubQuery(query)
+	if scrubbed == "" {
+		return
+	}
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	z.entries[z.next] = ZeroResultQuery{Query: scrubbed, Timestamp: time.Now()}
+	z.next = (z.next + 1) % len(z.entries)
+	if z.next == 0 {
+		z.full = true
+	}
+}
+
+// drain returns all recorded queries, oldest first, and clears the buffer
+func (z *zeroResultLog) drain() []ZeroResultQuery {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	out := make([]ZeroResultQuery, 0, len(z.entries))
+	if z.full {
+		out = append(out, z.entries[z.next:]...)
+	}
+	out = append(out, z.entries[:z.next]...)
+	z.entries = make([]ZeroResultQuery, len(z.entries))
+	z.next = 0
+	z.full = false
+	return out
+}
+
+// adminZeroResults returns and clears the recorded zero result queries
+func (svc *ServiceContext) adminZeroResults(c *gin.Context) {
+	if svc.ZeroResults == nil {
+		sendError(c, http.StatusNotFound, errNotEnabled, "zero result query tracking is not enabled")
+		return
+	}
+	queries := svc.ZeroResults.drain()
+	log.Printf("Returning %d zero result queries", len(queries))
+	c.JSON(http.StatusOK, gin.H{"queries": queries})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uvalib/virgo4-jwt/v4jwt"
+)
+
+func TestScrubQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"keyword: {knitting patterns}", "keyword: {knitting patterns}"},
+		{"books by jane.doe@example.com", "books by [email]"},
+		{"JANE_DOE+lib@mail.example.org wrote", "[email] wrote"},
+		{"call me 434-979-7151", "call me [phone]"},
+		{"call (434) 979-7151 please", "call [phone] please"},
+		{"434.979.7151", "[phone]"},
+		{"card 21234000123456", "card [number]"},
+		{"card 2123 4000 1234 56", "card [number]"},
+		{"ssn 123-45-6789", "ssn [number]"},
+		// years, editions and short numbers are kept
+		{"history of 1812", "history of 1812"},
+		{"harry potter 7", "harry potter 7"},
+		{"1776 1865", "1776 1865"},
+		{"civil war 1861-1865", "civil war 1861-1865"},
+		{"1861 - 1865 - 2001", "1861 - 1865 - 2001"},
+		{"1861-186512", "[number]"},
+		{"2024 434 5551", "[number]"},
+		{"  lots   of \t space  ", "lots of space"},
+		{"", ""},
+		{strings.Repeat("á", maxZeroResultQueryLen+10), strings.Repeat("á", maxZeroResultQueryLen)},
+	}
+	for _, tc := range tests {
+		if got := scrubQuery(tc.query); got != tc.want {
+			t.Errorf("scrubQuery(%q) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestZeroResultLogIsBounded(t *testing.T) {
+	zl := newZeroResultLog(3)
+	for i := 1; i <= 5; i++ {
+		zl.add(fmt.Sprintf("query %d", i))
+	}
+	zl.add("   ")
+	got := make([]string, 0)
+	for _, q := range zl.drain() {
+		got = append(got, q.Query)
+	}
+	if want := []string{"query 3", "query 4", "query 5"}; reflect.DeepEqual(got, want) == false {
+		t.Errorf("drain = %v, want %v", got, want)
+	}
+	if len(zl.entries) != 3 {
+		t.Errorf("buffer grew to %d entries", len(zl.entries))
+	}
+	if again := zl.drain(); len(again) != 0 {
+		t.Errorf("second drain = %v, want empty", again)
+	}
+	zl.add("query 6")
+	if again := zl.drain(); len(again) != 1 || again[0].Query != "query 6" {
+		t.Errorf("drain after reuse = %v", again)
+	}
+}
+
+func TestZeroResultQueriesEndToEnd(t *testing.T) {
+	sierra := newFakeSierra(t)
+	sierra.handleJSON("bibs/search", http.StatusOK, searchResult(0))
+	cfg := newTestConfig(sierra.apiURL())
+	cfg.ZeroResultsMax = 10
+	svc := newTestServiceWithConfig(t, cfg)
+	router := newRouter(svc)
+
+	search := httptest.NewRequest(http.MethodPost, "/api/search", strings.NewReader(`{"query":"keyword: {jane@example.com 434-555-1212}"}`))
+	search.Header.Set("Content-Type", "application/json")
+	search.Header.Set("Authorization", "Bearer "+mintTestToken(t, v4jwt.User))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, search)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("search = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	tests := []struct {
+		role v4jwt.RoleEnum
+		code int
+		want []string
+	}{
+		{v4jwt.User, http.StatusForbidden, nil},
+		{v4jwt.Staff, http.StatusOK, []string{"keyword: {[email] [phone]}"}},
+		// cleared on read
+		{v4jwt.Staff, http.StatusOK, []string{}},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/zero-results", nil)
+		req.Header.Set("Authorization", "Bearer "+mintTestToken(t, tc.role))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tc.code {
+			t.Fatalf("%s zero results = %d, want %d", tc.role, rec.Code, tc.code)
+		}
+		if tc.want == nil {
+			continue
+		}
+		var resp struct {
+			Queries []ZeroResultQuery `json:"queries"`
+		}
+		decodeTestJSON(t, rec, &resp)
+		got := make([]string, 0)
+		for _, q := range resp.Queries {
+			got = append(got, q.Query)
+		}
+		if reflect.DeepEqual(got, tc.want) == false {
+			t.Errorf("zero result queries = %v, want %v", got, tc.want)
+		}
+	}
+}
+
+func TestZeroResultQueriesOffByDefault(t *testing.T) {
+	svc := newTestService(t, nil)
+	if svc.ZeroResults != nil {
+		t.Fatalf("zero result tracking is enabled by default")
+	}
+	c, rec := newTestContext(http.MethodGet, "/api/admin/zero-results", nil)
+	asStaff(c)
+	svc.adminZeroResults(c)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,15 @@
+// Package i18n holds the pool message files. The English messages are compiled into the
+// binary so the service can always localize, even if the external files are unavailable.
+package i18n
+
+import (
+	_ "embed"
+)
+
+// EnglishFile is the name of the baseline English message file
+const EnglishFile = "active.en.toml"
+
+// English is the content of the baseline English message file
+//
+//go:embed active.en.toml
+var English []byte